@@ -0,0 +1,132 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/sys/cpu"
+)
+
+// identifiers for the cipher selected when using the generic AEAD profile,
+// these are prepended to the encrypted data so decryption can select the same cipher
+const (
+	aeadAES256GCM         byte = 1
+	aeadXChaCha20Poly1305 byte = 2
+	// only used by the segments of streams
+	aeadChaCha20Poly1305 byte = 3
+	// the cascade used by the long-term archive mode
+	aeadArchive byte = 4
+)
+
+// hasAESHardware indicates whether the platform provides constant-time
+// hardware AES and carry-less multiplication, which AES-GCM requires
+// to be both fast and resistant to timing attacks
+var hasAESHardware = cpu.X86.HasAES && cpu.X86.HasPCLMULQDQ ||
+	cpu.ARM64.HasAES && cpu.ARM64.HasPMULL ||
+	cpu.S390X.HasAES && cpu.S390X.HasAESGCM
+
+// WithAEAD is used to setup, and return EncryptManager for use with the generic AEAD profile.
+// AES256-GCM is used on platforms with hardware AES support, otherwise XChaCha20-Poly1305 is used.
+// The params are expected to be unencrypted, and in hex encoded string format
+func (e *EncryptManager) WithAEAD(params *GCMDecryptParams) *EncryptManager {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.protocol = AEAD
+	e.gcmDecryptParams = params
+	return e
+}
+
+// preferredAEAD returns the cipher used by the AEAD profile on this platform
+func preferredAEAD() byte {
+	if hasAESHardware {
+		return aeadAES256GCM
+	}
+	return aeadXChaCha20Poly1305
+}
+
+// newAEAD returns the cipher identified by id, using the given key
+func newAEAD(id byte, key []byte) (cipher.AEAD, error) {
+	switch id {
+	case aeadAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCMWithNonceSize(block, nonceSize)
+	case aeadXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	case aeadArchive:
+		return newCascadeAEAD(key)
+	default:
+		return nil, fmt.Errorf("unsupported aead cipher %d", id)
+	}
+}
+
+// encryptAEAD encrypts given io.Reader using the cipher identified by id
+// the resultant encrypted bytes, nonce, and cipher key are returned
+func (e *EncryptManager) encryptAEAD(r io.Reader, id byte) ([]byte, []byte, []byte, error) {
+	if r == nil {
+		return nil, nil, nil, errors.New("invalid content provided")
+	}
+	cipherKeyBytes := make([]byte, keylen)
+	if _, err := io.ReadFull(e.randomness(), cipherKeyBytes); err != nil {
+		return nil, nil, nil, err
+	}
+	nonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(e.randomness(), nonce); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := e.recordNonce(cipherKeyBytes, nonce); err != nil {
+		return nil, nil, nil, err
+	}
+	aead, err := newAEAD(id, cipherKeyBytes)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	dataToEncrypt, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// prefix the encrypted data with the cipher used
+	return aead.Seal([]byte{id}, nonce, dataToEncrypt, e.additionalData()), nonce, cipherKeyBytes, nil
+}
+
+// decryptAEAD is used to decrypt the given io.Reader encrypted using the AEAD profile
+func (e *EncryptManager) decryptAEAD(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	decodedKey, decodedNonce, err := e.decryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	encryptedData, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(encryptedData) == 0 {
+		return nil, errors.New("invalid content provided")
+	}
+	if err := e.checkCipher(encryptedData[0]); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(encryptedData[0], decodedKey)
+	if err != nil {
+		return nil, err
+	}
+	return openAEAD(aead, nil, decodedNonce, encryptedData[1:], e.additionalData(), e.objectBound)
+}
+
+// checkCipher refuses data of the long-term archive mode claiming to use
+// any cipher other than the cascade
+func (e *EncryptManager) checkCipher(id byte) error {
+	if e.getProtocol() == Archive && id != aeadArchive {
+		return ErrDowngrade
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package crypto
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func Test_EncryptManager_AEAD(t *testing.T) {
+	original, err := ioutil.ReadFile("README.md")
+	if err != nil {
+		t.Fatalf("setup failed: %s", err)
+	}
+	defer func(v bool) { hasAESHardware = v }(hasAESHardware)
+	tests := []struct {
+		name        string
+		hardwareAES bool
+		wantCipher  byte
+	}{
+		{"hardware aes", true, aeadAES256GCM},
+		{"software only", false, aeadXChaCha20Poly1305},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hasAESHardware = tt.hardwareAES
+			e := NewEncryptManager("helloworld").WithAEAD(nil)
+			encrypted, err := e.Encrypt(bytes.NewReader(original))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encrypted[0] != tt.wantCipher {
+				t.Fatalf("cipher = %d, want %d", encrypted[0], tt.wantCipher)
+			}
+			// decryption must use the recorded cipher regardless of platform
+			hasAESHardware = !tt.hardwareAES
+			decrypted, err := NewEncryptManager("helloworld").
+				WithAEAD(e.gcmDecryptParams).
+				Decrypt(bytes.NewReader(encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, original) {
+				t.Fatal("decrypted data does not match original")
+			}
+			// tampered data must fail authentication
+			encrypted[len(encrypted)-1] ^= 0xff
+			if _, err := e.Decrypt(bytes.NewReader(encrypted)); err == nil {
+				t.Fatal("expected error decrypting tampered data")
+			}
+		})
+	}
+	e := NewEncryptManager("helloworld").WithAEAD(nil)
+	encrypted, err := e.Encrypt(bytes.NewReader(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+	malformed := &GCMDecryptParams{CipherKey: e.gcmDecryptParams.CipherKey, Nonce: "00"}
+	if _, err := NewEncryptManager("helloworld").WithAEAD(malformed).Decrypt(bytes.NewReader(encrypted)); err == nil {
+		t.Fatal("expected error decrypting with a malformed nonce")
+	}
+	if _, err := NewEncryptManager("helloworld").WithAEAD(nil).Encrypt(nil); err == nil {
+		t.Fatal("expected error encrypting nil reader")
+	}
+}
@@ -0,0 +1,652 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrAgeNoIdentity is returned when none of the identities given to DecryptAge
+// unwraps the file key of an age file
+var ErrAgeNoIdentity = errors.New("no identity matched any of the recipients")
+
+const (
+	// ageIntro is the first line of age files
+	ageIntro = "age-encryption.org/v1"
+	// ageChunkSize is the amount of plaintext sealed in each age payload chunk
+	ageChunkSize = 64 * 1024
+	// ageFileKeySize is the size of the file key wrapped for every recipient
+	ageFileKeySize = 16
+	// ageTagSize is the size of ChaCha20-Poly1305 authentication tags
+	ageTagSize = 16
+	// ageScryptWorkFactor is the log2 of the scrypt N parameter used by
+	// default, matching the age CLI
+	ageScryptWorkFactor = 18
+	// ageMaxWorkFactor is the largest scrypt work factor accepted by default
+	ageMaxWorkFactor = 22
+	// ageArmorHeader begins the ASCII armored format produced by age -a
+	ageArmorHeader = "-----BEGIN AGE ENCRYPTED FILE-----"
+	ageArmorFooter = "-----END AGE ENCRYPTED FILE-----"
+)
+
+var ageBase64 = base64.RawStdEncoding.Strict()
+
+// ageStanza is a recipient stanza of an age header, holding the file key
+// wrapped for one recipient
+type ageStanza struct {
+	Type string
+	Args []string
+	Body []byte
+}
+
+// AgeRecipient is a recipient of files encrypted using EncryptAge, being an
+// *AgeX25519Recipient, or an *AgeScryptRecipient
+type AgeRecipient interface {
+	wrap(fileKey []byte) (*ageStanza, error)
+}
+
+// AgeIdentity is used by DecryptAge to unwrap the file key, being an
+// *AgeX25519Identity, or an *AgeScryptIdentity
+type AgeIdentity interface {
+	// unwrap returns the file key, or errAgeIncorrectIdentity if the
+	// stanzas do not include one for the identity
+	unwrap(stanzas []*ageStanza) ([]byte, error)
+}
+
+// errAgeIncorrectIdentity is returned by identities not matching any stanza
+var errAgeIncorrectIdentity = errors.New("incorrect identity")
+
+// AgeX25519Recipient is an age X25519 public key, encoded as age1...
+type AgeX25519Recipient struct {
+	key [32]byte
+}
+
+// ParseAgeRecipient is used to parse an age X25519 public key, as printed
+// by age-keygen
+func ParseAgeRecipient(s string) (*AgeX25519Recipient, error) {
+	hrp, data, err := bech32Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid age recipient: %v", err)
+	}
+	if hrp != "age" || len(data) != 32 {
+		return nil, errors.New("invalid age recipient")
+	}
+	r := &AgeX25519Recipient{}
+	copy(r.key[:], data)
+	return r, nil
+}
+
+// String returns the recipient encoded as age1...
+func (r *AgeX25519Recipient) String() string {
+	s, _ := bech32Encode("age", r.key[:])
+	return s
+}
+
+func (r *AgeX25519Recipient) wrap(fileKey []byte) (*ageStanza, error) {
+	var ephemeral, share, shared [32]byte
+	if _, err := io.ReadFull(rand.Reader, ephemeral[:]); err != nil {
+		return nil, err
+	}
+	curve25519.ScalarBaseMult(&share, &ephemeral)
+	curve25519.ScalarMult(&shared, &ephemeral, &r.key)
+	body, err := ageAEADSeal(ageX25519Key(shared[:], share[:], r.key[:]), fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return &ageStanza{Type: "X25519", Args: []string{ageBase64.EncodeToString(share[:])}, Body: body}, nil
+}
+
+// AgeX25519Identity is an age X25519 private key, encoded as AGE-SECRET-KEY-1...
+type AgeX25519Identity struct {
+	secret    [32]byte
+	recipient AgeX25519Recipient
+}
+
+// GenerateAgeIdentity is used to generate a new age X25519 private key
+func GenerateAgeIdentity() (*AgeX25519Identity, error) {
+	var secret [32]byte
+	if _, err := io.ReadFull(rand.Reader, secret[:]); err != nil {
+		return nil, err
+	}
+	return newAgeX25519Identity(secret), nil
+}
+
+// ParseAgeIdentity is used to parse an age X25519 private key, as written
+// by age-keygen, ignoring comments, and blank lines
+func ParseAgeIdentity(s string) (*AgeX25519Identity, error) {
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hrp, data, err := bech32Decode(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid age identity: %v", err)
+		}
+		if hrp != "AGE-SECRET-KEY-" || len(data) != 32 {
+			return nil, errors.New("invalid age identity")
+		}
+		var secret [32]byte
+		copy(secret[:], data)
+		return newAgeX25519Identity(secret), nil
+	}
+	return nil, errors.New("no age identity found")
+}
+
+func newAgeX25519Identity(secret [32]byte) *AgeX25519Identity {
+	i := &AgeX25519Identity{secret: secret}
+	curve25519.ScalarBaseMult(&i.recipient.key, &i.secret)
+	return i
+}
+
+// Recipient returns the public key of the identity
+func (i *AgeX25519Identity) Recipient() *AgeX25519Recipient {
+	r := i.recipient
+	return &r
+}
+
+// String returns the identity encoded as AGE-SECRET-KEY-1...
+func (i *AgeX25519Identity) String() string {
+	s, _ := bech32Encode("AGE-SECRET-KEY-", i.secret[:])
+	return strings.ToUpper(s)
+}
+
+func (i *AgeX25519Identity) unwrap(stanzas []*ageStanza) ([]byte, error) {
+	for _, s := range stanzas {
+		if s.Type != "X25519" {
+			continue
+		}
+		if len(s.Args) != 1 || len(s.Body) != ageFileKeySize+ageTagSize {
+			return nil, errors.New("invalid age X25519 stanza")
+		}
+		share, err := ageBase64.DecodeString(s.Args[0])
+		if err != nil || len(share) != 32 {
+			return nil, errors.New("invalid age X25519 stanza")
+		}
+		var point, shared [32]byte
+		copy(point[:], share)
+		curve25519.ScalarMult(&shared, &i.secret, &point)
+		if shared == [32]byte{} {
+			return nil, errors.New("invalid age X25519 stanza")
+		}
+		fileKey, err := ageAEADOpen(ageX25519Key(shared[:], share, i.recipient.key[:]), s.Body)
+		if err == nil {
+			return fileKey, nil
+		}
+	}
+	return nil, errAgeIncorrectIdentity
+}
+
+// ageX25519Key returns the key wrapping the file key for an X25519 recipient
+func ageX25519Key(shared, share, recipient []byte) []byte {
+	salt := append(append([]byte{}, share...), recipient...)
+	return ageHKDF(shared, salt, "age-encryption.org/v1/X25519")
+}
+
+// AgeScryptRecipient encrypts age files using a passphrase. Files with a
+// passphrase recipient may have no other recipients
+type AgeScryptRecipient struct {
+	Passphrase string
+	// WorkFactor is the log2 of the scrypt N parameter, 18 by default
+	WorkFactor int
+}
+
+func (r *AgeScryptRecipient) wrap(fileKey []byte) (*ageStanza, error) {
+	if r.Passphrase == "" {
+		return nil, errors.New("no passphrase provided")
+	}
+	logN := r.WorkFactor
+	if logN == 0 {
+		logN = ageScryptWorkFactor
+	}
+	if logN < 1 || logN > 30 {
+		return nil, errors.New("invalid scrypt work factor")
+	}
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	key, err := ageScryptKey(r.Passphrase, salt, logN)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ageAEADSeal(key, fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return &ageStanza{Type: "scrypt", Args: []string{ageBase64.EncodeToString(salt), strconv.Itoa(logN)}, Body: body}, nil
+}
+
+// AgeScryptIdentity decrypts age files encrypted using a passphrase
+type AgeScryptIdentity struct {
+	Passphrase string
+	// MaxWorkFactor is the largest work factor accepted, 22 by default,
+	// protecting against files crafted to be expensive to decrypt
+	MaxWorkFactor int
+}
+
+func (i *AgeScryptIdentity) unwrap(stanzas []*ageStanza) ([]byte, error) {
+	for _, s := range stanzas {
+		if s.Type != "scrypt" {
+			continue
+		}
+		if len(stanzas) != 1 {
+			return nil, errors.New("an age scrypt stanza must be the only stanza")
+		}
+		if len(s.Args) != 2 || len(s.Body) != ageFileKeySize+ageTagSize {
+			return nil, errors.New("invalid age scrypt stanza")
+		}
+		salt, err := ageBase64.DecodeString(s.Args[0])
+		if err != nil || len(salt) != 16 {
+			return nil, errors.New("invalid age scrypt stanza")
+		}
+		// the work factor is decimal without leading zeros
+		logN, err := strconv.Atoi(s.Args[1])
+		if err != nil || logN < 1 || strconv.Itoa(logN) != s.Args[1] {
+			return nil, errors.New("invalid age scrypt stanza")
+		}
+		max := i.MaxWorkFactor
+		if max == 0 {
+			max = ageMaxWorkFactor
+		}
+		if logN > max {
+			return nil, fmt.Errorf("scrypt work factor %d exceeds the maximum of %d", logN, max)
+		}
+		key, err := ageScryptKey(i.Passphrase, salt, logN)
+		if err != nil {
+			return nil, err
+		}
+		fileKey, err := ageAEADOpen(key, s.Body)
+		if err != nil {
+			return nil, errors.New("incorrect passphrase")
+		}
+		return fileKey, nil
+	}
+	return nil, errAgeIncorrectIdentity
+}
+
+// ageScryptKey returns the key wrapping the file key for a passphrase
+func ageScryptKey(passphrase string, salt []byte, logN int) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), append([]byte("age-encryption.org/v1/scrypt"), salt...), 1<<uint(logN), 8, 1, 32)
+}
+
+// ageHKDF returns 32 bytes derived from ikm using HKDF-SHA-256
+func ageHKDF(ikm, salt []byte, info string) []byte {
+	key := make([]byte, 32)
+	// reading 32 bytes never fails
+	io.ReadFull(hkdf.New(sha256.New, ikm, salt, []byte(info)), key)
+	return key
+}
+
+// ageAEADSeal seals the file key using ChaCha20-Poly1305 with a zero nonce,
+// as every wrapping key is used once
+func ageAEADSeal(key, fileKey []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, make([]byte, aead.NonceSize()), fileKey, nil), nil
+}
+
+// ageAEADOpen opens a file key sealed by ageAEADSeal
+func ageAEADOpen(key, body []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, make([]byte, aead.NonceSize()), body, nil)
+}
+
+// EncryptAge is used to encrypt src to the given recipients in the age
+// format (age-encryption.org/v1), writing the result to dst using constant
+// memory. The output can be decrypted by the age CLI, or any other
+// implementation of the format, using the identity of any recipient, or the
+// passphrase of an AgeScryptRecipient, which must be the only recipient
+func EncryptAge(dst io.Writer, src io.Reader, recipients ...AgeRecipient) error {
+	if dst == nil || src == nil {
+		return errors.New("invalid content provided")
+	}
+	if len(recipients) == 0 {
+		return errors.New("no age recipients provided")
+	}
+	fileKey := make([]byte, ageFileKeySize)
+	if _, err := io.ReadFull(rand.Reader, fileKey); err != nil {
+		return err
+	}
+	defer zero(fileKey)
+	var header bytes.Buffer
+	header.WriteString(ageIntro + "\n")
+	for _, r := range recipients {
+		if _, ok := r.(*AgeScryptRecipient); ok && len(recipients) != 1 {
+			return errors.New("an age scrypt recipient must be the only recipient")
+		}
+		s, err := r.wrap(fileKey)
+		if err != nil {
+			return err
+		}
+		s.marshal(&header)
+	}
+	header.WriteString("---")
+	mac := hmac.New(sha256.New, ageHKDF(fileKey, nil, "header"))
+	mac.Write(header.Bytes())
+	header.WriteString(" " + ageBase64.EncodeToString(mac.Sum(nil)) + "\n")
+	nonce := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	header.Write(nonce)
+	if _, err := dst.Write(header.Bytes()); err != nil {
+		return err
+	}
+	aead, err := chacha20poly1305.New(ageHKDF(fileKey, nonce, "payload"))
+	if err != nil {
+		return err
+	}
+	return readSegments(bufio.NewReaderSize(src, ageChunkSize+1), ageChunkSize, func(index uint32, chunk []byte, last bool) error {
+		_, err := dst.Write(aead.Seal(chunk[:0], ageChunkNonce(index, last), chunk, nil))
+		return err
+	})
+}
+
+// DecryptAge is used to decrypt src, encrypted in the age format by
+// EncryptAge, the age CLI, or any other implementation, writing the result
+// to dst using constant memory. Binary, and ASCII armored files are
+// supported. The file key is unwrapped using the first matching identity,
+// returning ErrAgeNoIdentity if none match. Plaintext is written to dst as
+// it is authenticated, so dst may have received part of the data before an
+// error, such as truncation, is detected
+func DecryptAge(dst io.Writer, src io.Reader, identities ...AgeIdentity) error {
+	if dst == nil || src == nil {
+		return errors.New("invalid content provided")
+	}
+	r := bufio.NewReaderSize(src, ageChunkSize+ageTagSize+1)
+	if peek, _ := r.Peek(len(ageArmorHeader)); string(peek) == ageArmorHeader {
+		dearmored, err := dearmorAge(r)
+		if err != nil {
+			return err
+		}
+		r = bufio.NewReaderSize(bytes.NewReader(dearmored), ageChunkSize+ageTagSize+1)
+	}
+	stanzas, header, mac, err := parseAgeHeader(r)
+	if err != nil {
+		return err
+	}
+	var fileKey []byte
+	for _, id := range identities {
+		if fileKey, err = id.unwrap(stanzas); err == nil {
+			break
+		} else if err != errAgeIncorrectIdentity {
+			return err
+		}
+	}
+	if fileKey == nil {
+		return ErrAgeNoIdentity
+	}
+	defer zero(fileKey)
+	if len(fileKey) != ageFileKeySize {
+		return errors.New("invalid age file key")
+	}
+	computed := hmac.New(sha256.New, ageHKDF(fileKey, nil, "header"))
+	computed.Write(header)
+	if !hmac.Equal(computed.Sum(nil), mac) {
+		return errors.New("age header mac mismatch")
+	}
+	nonce := make([]byte, 16)
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return errors.New("invalid content provided")
+	}
+	aead, err := chacha20poly1305.New(ageHKDF(fileKey, nonce, "payload"))
+	if err != nil {
+		return err
+	}
+	return readSegments(r, ageChunkSize+aead.Overhead(), func(index uint32, chunk []byte, last bool) error {
+		// only the payload of empty files ends with an empty chunk
+		if len(chunk) < aead.Overhead() || (len(chunk) == aead.Overhead() && index > 0) {
+			return errors.New("invalid content provided")
+		}
+		opened, err := aead.Open(chunk[:0], ageChunkNonce(index, last), chunk, nil)
+		if err != nil {
+			return err
+		}
+		_, err = dst.Write(opened)
+		return err
+	})
+}
+
+// DecryptAge is used to decrypt src using DecryptAge, once the approval gate
+// set using WithApprovalGate, if any, has been satisfied
+func (e *EncryptManager) DecryptAge(dst io.Writer, src io.Reader, identities ...AgeIdentity) error {
+	if err := e.checkApprovals(); err != nil {
+		return err
+	}
+	return DecryptAge(dst, src, identities...)
+}
+
+// ageChunkNonce returns the big endian 11 byte index followed by the final chunk flag
+func ageChunkNonce(index uint32, last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	nonce[7], nonce[8], nonce[9], nonce[10] = byte(index>>24), byte(index>>16), byte(index>>8), byte(index)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// marshal writes the stanza, wrapping its body at 64 columns, where the
+// final line is always shorter, and possibly empty
+func (s *ageStanza) marshal(w *bytes.Buffer) {
+	w.WriteString("-> " + strings.Join(append([]string{s.Type}, s.Args...), " ") + "\n")
+	body := ageBase64.EncodeToString(s.Body)
+	for len(body) >= 64 {
+		w.WriteString(body[:64] + "\n")
+		body = body[64:]
+	}
+	w.WriteString(body + "\n")
+}
+
+// parseAgeHeader parses the header of an age file, returning its stanzas,
+// the header covered by the mac, and the mac
+func parseAgeHeader(r *bufio.Reader) ([]*ageStanza, []byte, []byte, error) {
+	invalid := errors.New("invalid age header")
+	var header bytes.Buffer
+	readLine := func() (string, error) {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", invalid
+		}
+		header.WriteString(line)
+		return strings.TrimSuffix(line, "\n"), nil
+	}
+	if line, err := readLine(); err != nil || line != ageIntro {
+		return nil, nil, nil, errors.New("not an age file, or unsupported age version")
+	}
+	var stanzas []*ageStanza
+	for {
+		line, err := readLine()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if strings.HasPrefix(line, "--- ") {
+			mac, err := ageBase64.DecodeString(line[4:])
+			if err != nil || len(mac) != sha256.Size {
+				return nil, nil, nil, invalid
+			}
+			// the mac covers the header up to, and including "---"
+			covered := header.Bytes()[:header.Len()-len(line)-1+3]
+			return stanzas, covered, mac, nil
+		}
+		if !strings.HasPrefix(line, "-> ") {
+			return nil, nil, nil, invalid
+		}
+		args := strings.Split(line[3:], " ")
+		for _, arg := range args {
+			if arg == "" || !isAgeArgument(arg) {
+				return nil, nil, nil, invalid
+			}
+		}
+		s := &ageStanza{Type: args[0], Args: args[1:]}
+		for {
+			line, err := readLine()
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			chunk, err := ageBase64.DecodeString(line)
+			if err != nil || len(line) > 64 {
+				return nil, nil, nil, invalid
+			}
+			s.Body = append(s.Body, chunk...)
+			if len(line) < 64 {
+				break
+			}
+		}
+		stanzas = append(stanzas, s)
+	}
+}
+
+// isAgeArgument reports whether arg only holds visible ASCII characters
+func isAgeArgument(arg string) bool {
+	for i := 0; i < len(arg); i++ {
+		if arg[i] < 33 || arg[i] > 126 {
+			return false
+		}
+	}
+	return true
+}
+
+// dearmorAge decodes the ASCII armored format produced by age -a
+func dearmorAge(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimSpace(strings.Replace(string(data), "\r\n", "\n", -1))
+	if !strings.HasPrefix(text, ageArmorHeader) || !strings.HasSuffix(text, ageArmorFooter) {
+		return nil, errors.New("invalid age armor")
+	}
+	body := strings.TrimSuffix(strings.TrimPrefix(text, ageArmorHeader), ageArmorFooter)
+	decoded, err := base64.StdEncoding.Strict().DecodeString(strings.Replace(strings.TrimSpace(body), "\n", "", -1))
+	if err != nil {
+		return nil, errors.New("invalid age armor")
+	}
+	return decoded, nil
+}
+
+// bech32Charset is the alphabet of bech32 (BIP 173), used to encode age keys
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// bech32Polymod computes the bech32 checksum of values
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+// bech32HRPExpand expands the human readable part for checksum computation
+func bech32HRPExpand(hrp string) []byte {
+	out := make([]byte, 0, 2*len(hrp)+1)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]>>5)
+	}
+	out = append(out, 0)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]&31)
+	}
+	return out
+}
+
+// bech32ConvertBits regroups data of from bit groups into to bit groups
+func bech32ConvertBits(data []byte, from, to uint, pad bool) ([]byte, error) {
+	var acc uint32
+	var bits uint
+	maxv := uint32(1)<<to - 1
+	var out []byte
+	for _, b := range data {
+		if uint32(b)>>from != 0 {
+			return nil, errors.New("invalid data")
+		}
+		acc = acc<<from | uint32(b)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(to-bits)&maxv))
+		}
+	} else if bits >= from || acc<<(to-bits)&maxv != 0 {
+		return nil, errors.New("invalid padding")
+	}
+	return out, nil
+}
+
+// bech32Encode encodes data using the human readable part hrp, in lower case
+func bech32Encode(hrp string, data []byte) (string, error) {
+	values, err := bech32ConvertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	hrp = strings.ToLower(hrp)
+	polymod := bech32Polymod(append(append(bech32HRPExpand(hrp), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	for i := 0; i < 6; i++ {
+		values = append(values, byte(polymod>>uint(5*(5-i))&31))
+	}
+	var b strings.Builder
+	b.WriteString(hrp + "1")
+	for _, v := range values {
+		b.WriteByte(bech32Charset[v])
+	}
+	return b.String(), nil
+}
+
+// bech32Decode decodes s, returning the human readable part in the case of s
+func bech32Decode(s string) (string, []byte, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("mixed case")
+	}
+	pos := strings.LastIndex(s, "1")
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, errors.New("invalid separator")
+	}
+	hrp, lower := s[:pos], strings.ToLower(s)
+	values := make([]byte, 0, len(s)-pos-1)
+	for i := pos + 1; i < len(s); i++ {
+		v := strings.IndexByte(bech32Charset, lower[i])
+		if v < 0 {
+			return "", nil, errors.New("invalid character")
+		}
+		values = append(values, byte(v))
+	}
+	if bech32Polymod(append(bech32HRPExpand(lower[:pos]), values...)) != 1 {
+		return "", nil, errors.New("invalid checksum")
+	}
+	data, err := bech32ConvertBits(values[:len(values)-6], 5, 8, false)
+	if err != nil {
+		return "", nil, err
+	}
+	return hrp, data, nil
+}
@@ -0,0 +1,264 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_AgeKeys(t *testing.T) {
+	// the identity of 32 0x42 bytes, as used by the age test suite
+	id, err := ParseAgeIdentity("# created: 2019-12-28T00:00:00Z\n\nAGE-SECRET-KEY-1GFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPQ4EGAEX\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.String() != "AGE-SECRET-KEY-1GFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPQ4EGAEX" {
+		t.Fatalf("String = %s", id)
+	}
+	r, err := ParseAgeRecipient(id.Recipient().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.key != id.Recipient().key || !strings.HasPrefix(r.String(), "age1") {
+		t.Fatalf("recipient %s does not round trip", r)
+	}
+	if _, err := ParseAgeRecipient("age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8q",
+		"Age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+	} {
+		if _, err := ParseAgeRecipient(s); err == nil {
+			t.Fatalf("expected error parsing %s", s)
+		}
+	}
+	if _, err := ParseAgeIdentity("# no key\n"); err == nil {
+		t.Fatal("expected error parsing identity file without a key")
+	}
+}
+
+func Test_Age(t *testing.T) {
+	alice, err := GenerateAgeIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := GenerateAgeIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mallory, err := GenerateAgeIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{0, 1, ageChunkSize, ageChunkSize + 1, 2 * ageChunkSize} {
+		data := make([]byte, size)
+		if _, err := rand.Read(data); err != nil {
+			t.Fatal(err)
+		}
+		var encrypted bytes.Buffer
+		if err := EncryptAge(&encrypted, bytes.NewReader(data), alice.Recipient(), bob.Recipient()); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(encrypted.Bytes(), []byte("age-encryption.org/v1\n-> X25519 ")) {
+			t.Fatalf("size %d: invalid header", size)
+		}
+		tests := []struct {
+			name    string
+			ids     []AgeIdentity
+			wantErr bool
+		}{
+			{"alice", []AgeIdentity{alice}, false},
+			{"bob", []AgeIdentity{mallory, bob}, false},
+			{"mallory", []AgeIdentity{mallory}, true},
+			{"passphrase", []AgeIdentity{&AgeScryptIdentity{Passphrase: "helloworld"}}, true},
+		}
+		for _, tt := range tests {
+			var decrypted bytes.Buffer
+			err := DecryptAge(&decrypted, bytes.NewReader(encrypted.Bytes()), tt.ids...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("size %d, %s: DecryptAge err = %v, wantErr %v", size, tt.name, err, tt.wantErr)
+			}
+			if !tt.wantErr && !bytes.Equal(decrypted.Bytes(), data) {
+				t.Fatalf("size %d, %s: decrypted data does not match original", size, tt.name)
+			}
+		}
+		// truncating the payload at a chunk boundary is detected
+		if size > ageChunkSize {
+			truncated := encrypted.Bytes()[:encrypted.Len()-(size-ageChunkSize)-ageTagSize]
+			if err := DecryptAge(&bytes.Buffer{}, bytes.NewReader(truncated), alice); err == nil {
+				t.Fatalf("size %d: expected error decrypting truncated file", size)
+			}
+		}
+	}
+}
+
+func Test_Age_Scrypt(t *testing.T) {
+	var encrypted bytes.Buffer
+	r := &AgeScryptRecipient{Passphrase: "helloworld", WorkFactor: 10}
+	if err := EncryptAge(&encrypted, strings.NewReader("hello world"), r); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		id      AgeIdentity
+		wantErr bool
+	}{
+		{"passphrase", &AgeScryptIdentity{Passphrase: "helloworld"}, false},
+		{"wrong-passphrase", &AgeScryptIdentity{Passphrase: "wrong"}, true},
+		{"work-factor", &AgeScryptIdentity{Passphrase: "helloworld", MaxWorkFactor: 9}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var decrypted bytes.Buffer
+			err := DecryptAge(&decrypted, bytes.NewReader(encrypted.Bytes()), tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecryptAge err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && decrypted.String() != "hello world" {
+				t.Fatalf("DecryptAge = %s", decrypted.String())
+			}
+		})
+	}
+	id, err := GenerateAgeIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := EncryptAge(&bytes.Buffer{}, strings.NewReader("hello world"), r, id.Recipient()); err == nil {
+		t.Fatal("expected error mixing passphrase, and public key recipients")
+	}
+}
+
+func Test_Age_Modified(t *testing.T) {
+	id, err := GenerateAgeIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var encrypted bytes.Buffer
+	if err := EncryptAge(&encrypted, strings.NewReader("hello world"), id.Recipient()); err != nil {
+		t.Fatal(err)
+	}
+	data := encrypted.Bytes()
+	header := bytes.Index(data, []byte("\n---"))
+	tests := []struct {
+		name   string
+		modify func([]byte) []byte
+	}{
+		{"version", func(b []byte) []byte { return bytes.Replace(b, []byte("v1"), []byte("v2"), 1) }},
+		{"stanza", func(b []byte) []byte {
+			return append(b[:header:header], append([]byte("\n-> grease x\n\n"), b[header+1:]...)...)
+		}},
+		{"mac", func(b []byte) []byte { b[header+10] ^= 1; return b }},
+		{"payload", func(b []byte) []byte { b[len(b)-1] ^= 1; return b }},
+		{"truncated", func(b []byte) []byte { return b[:len(b)-1] }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modified := tt.modify(append([]byte{}, data...))
+			if err := DecryptAge(&bytes.Buffer{}, bytes.NewReader(modified), id); err == nil {
+				t.Fatal("expected error decrypting modified file")
+			}
+		})
+	}
+}
+
+func Test_Age_Armor(t *testing.T) {
+	id, err := GenerateAgeIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var encrypted bytes.Buffer
+	if err := EncryptAge(&encrypted, strings.NewReader("hello world"), id.Recipient()); err != nil {
+		t.Fatal(err)
+	}
+	// armor the file as age -a does
+	encoded := base64.StdEncoding.EncodeToString(encrypted.Bytes())
+	var armored strings.Builder
+	armored.WriteString(ageArmorHeader + "\n")
+	for len(encoded) > 64 {
+		armored.WriteString(encoded[:64] + "\n")
+		encoded = encoded[64:]
+	}
+	armored.WriteString(encoded + "\n" + ageArmorFooter + "\n")
+	var decrypted bytes.Buffer
+	if err := DecryptAge(&decrypted, strings.NewReader(armored.String()), id); err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.String() != "hello world" {
+		t.Fatalf("DecryptAge = %s", decrypted.String())
+	}
+}
+
+// the files in testdata/age were produced by the age v1.1.1 command line tool
+func Test_DecryptAge_Fixtures(t *testing.T) {
+	identity, err := ioutil.ReadFile(filepath.Join("testdata", "age", "identity.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := ParseAgeIdentity(string(identity))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		file string
+		id   AgeIdentity
+		want string
+	}{
+		{"recipient", "recipient.age", id, "hello from age"},
+		{"armored", "armored.age", id, "hello from armored age"},
+		{"multichunk", "multichunk.age", id, strings.Repeat("a", 70000)},
+		{"passphrase", "passphrase.age", &AgeScryptIdentity{Passphrase: "helloworld"}, "hello from age passphrase"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted, err := ioutil.ReadFile(filepath.Join("testdata", "age", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decrypted bytes.Buffer
+			if err := DecryptAge(&decrypted, bytes.NewReader(encrypted), tt.id); err != nil {
+				t.Fatal(err)
+			}
+			if decrypted.String() != tt.want {
+				t.Fatalf("DecryptAge = %q", decrypted.String())
+			}
+		})
+	}
+}
+
+func Test_EncryptAge_Interop(t *testing.T) {
+	ageTool, err := exec.LookPath("age")
+	if err != nil {
+		t.Skip("age tool not found")
+	}
+	identity := filepath.Join("testdata", "age", "identity.txt")
+	contents, err := ioutil.ReadFile(identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := ParseAgeIdentity(string(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := strings.Repeat("hello from crypto ", 5000)
+	var encrypted bytes.Buffer
+	if err := EncryptAge(&encrypted, strings.NewReader(plaintext), id.Recipient()); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(ageTool, "-d", "-i", identity)
+	cmd.Stdin = &encrypted
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != plaintext {
+		t.Fatal("age -d returned a different plaintext")
+	}
+}
@@ -0,0 +1,229 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// ErrInsufficientApprovals is returned when decryption of an object protected
+// by an ApprovalPolicy is attempted without enough valid approval tokens
+var ErrInsufficientApprovals = errors.New("insufficient approvals to decrypt object")
+
+// ApprovalPolicy is used to require that m-of-n approvers sign off on the
+// decryption of a high-sensitivity object before its data is decrypted
+type ApprovalPolicy struct {
+	// Threshold is the number of distinct approvals required
+	Threshold int
+	// Approvers are the keys allowed to approve decryption
+	Approvers []ed25519.PublicKey
+}
+
+// ApprovalToken is an approver's sign-off for decrypting a single object
+// under a single policy, until it expires
+type ApprovalToken struct {
+	Approver  ed25519.PublicKey
+	Expires   time.Time
+	Signature []byte
+}
+
+// ApprovalBinding records the approval policy, and object an envelope was
+// encrypted for. It is authenticated using a key derived from the passphrase,
+// and bound to the payload, so it cannot be removed, or moved to another envelope
+type ApprovalBinding struct {
+	// Policy is the digest of the ApprovalPolicy, as returned by ApprovalPolicy.Digest
+	Policy   []byte `json:"policy"`
+	ObjectID string `json:"object_id"`
+	// KDF is the key derivation function used to derive the key of the mac,
+	// if configured using WithKDF
+	KDF  *KDFConfig `json:"kdf,omitempty"`
+	Salt []byte     `json:"salt"`
+	MAC  []byte     `json:"mac"`
+}
+
+// SignApproval is used by an approver to create a token allowing the
+// decryption of objectID under policy until expires
+func SignApproval(key ed25519.PrivateKey, policy *ApprovalPolicy, objectID string, expires time.Time) ApprovalToken {
+	return ApprovalToken{
+		Approver:  key.Public().(ed25519.PublicKey),
+		Expires:   expires,
+		Signature: ed25519.Sign(key, approvalMessage(policy.Digest(), objectID, expires)),
+	}
+}
+
+// Digest returns the SHA-256 digest identifying the policy, which is
+// independent of the order approvers are listed in
+func (p *ApprovalPolicy) Digest() []byte {
+	approvers := make([][]byte, len(p.Approvers))
+	for i, approver := range p.Approvers {
+		approvers[i] = approver
+	}
+	sort.Slice(approvers, func(i, j int) bool { return bytes.Compare(approvers[i], approvers[j]) < 0 })
+	h := sha256.New()
+	h.Write([]byte("temporal-approval-policy"))
+	binary.Write(h, binary.BigEndian, uint64(p.Threshold))
+	for _, approver := range approvers {
+		binary.Write(h, binary.BigEndian, uint64(len(approver)))
+		h.Write(approver)
+	}
+	return h.Sum(nil)
+}
+
+// Verify is used to check that tokens contain enough valid approvals to decrypt objectID.
+// Tokens from unknown approvers, expired tokens, and duplicate approvals are
+// ignored, while policies listing the same approver more than once are refused
+func (p *ApprovalPolicy) Verify(objectID string, tokens []ApprovalToken) error {
+	return p.verify(objectID, tokens, time.Now())
+}
+
+// verify implements Verify, checking the expiry of tokens against now
+func (p *ApprovalPolicy) verify(objectID string, tokens []ApprovalToken, now time.Time) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	approvers := make(map[string]bool)
+	for _, approver := range p.Approvers {
+		approvers[string(approver)] = true
+	}
+	digest := p.Digest()
+	// approvals are counted by key, so no approver is counted twice
+	approved := make(map[string]bool)
+	for _, token := range tokens {
+		key := string(token.Approver)
+		if approved[key] || !approvers[key] || len(token.Approver) != ed25519.PublicKeySize {
+			continue
+		}
+		if token.Expires.IsZero() || now.After(token.Expires) {
+			continue
+		}
+		if ed25519.Verify(token.Approver, approvalMessage(digest, objectID, token.Expires), token.Signature) {
+			approved[key] = true
+		}
+	}
+	if len(approved) < p.Threshold {
+		return ErrInsufficientApprovals
+	}
+	return nil
+}
+
+// validate checks the threshold can be met, and no approver is listed twice
+func (p *ApprovalPolicy) validate() error {
+	if p.Threshold < 1 || p.Threshold > len(p.Approvers) {
+		return fmt.Errorf("invalid approval threshold %d of %d", p.Threshold, len(p.Approvers))
+	}
+	approvers := make(map[string]bool)
+	for _, approver := range p.Approvers {
+		if approvers[string(approver)] {
+			return errors.New("approval policy lists an approver more than once")
+		}
+		approvers[string(approver)] = true
+	}
+	return nil
+}
+
+// WithApprovalGate is used to require that the given approval policy is satisfied
+// by tokens before objectID is decrypted. Envelopes produced by EncryptSplit
+// record the policy, and objectID, and are refused by managers without the gate
+func (e *EncryptManager) WithApprovalGate(policy *ApprovalPolicy, objectID string, tokens ...ApprovalToken) *EncryptManager {
+	e.approvalPolicy = policy
+	e.approvalObjectID = objectID
+	e.approvalTokens = tokens
+	return e
+}
+
+// checkApprovals ensures the approval gate, if any, has been satisfied
+func (e *EncryptManager) checkApprovals() error {
+	if e.approvalPolicy == nil {
+		return nil
+	}
+	return e.approvalPolicy.verify(e.approvalObjectID, e.approvalTokens, e.now())
+}
+
+// additionalData returns the additional data authenticated by AES256-GCM,
+// GCM-STREAM, the AEAD profile, ChaCha20-Poly1305, and XChaCha20-Poly1305,
+// binding data encrypted with an approval gate to its policy, and object, so
+// it can not be decrypted by managers without the gate
+func (e *EncryptManager) additionalData() []byte {
+	if e.approvalPolicy == nil {
+		return e.aad
+	}
+	h := sha256.New()
+	h.Write([]byte("temporal-approval-aad"))
+	h.Write(e.approvalPolicy.Digest())
+	binary.Write(h, binary.BigEndian, uint64(len(e.approvalObjectID)))
+	h.Write([]byte(e.approvalObjectID))
+	return h.Sum(append([]byte{}, e.aad...))
+}
+
+// approvalBinding returns the authenticated approval binding for payload, if configured
+func (e *EncryptManager) approvalBinding(payload []byte) (*ApprovalBinding, error) {
+	if e.approvalPolicy == nil {
+		return nil, nil
+	}
+	if err := e.approvalPolicy.validate(); err != nil {
+		return nil, err
+	}
+	b := &ApprovalBinding{Policy: e.approvalPolicy.Digest(), ObjectID: e.approvalObjectID, KDF: e.kdf, Salt: make([]byte, e.kdf.saltLength())}
+	if _, err := io.ReadFull(e.randomness(), b.Salt); err != nil {
+		return nil, err
+	}
+	var err error
+	if b.MAC, err = e.approvalMAC(b, payload); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// checkApprovalBinding verifies b was produced using the passphrase, and
+// matches the approval gate, which must be satisfied before payload is decrypted
+func (e *EncryptManager) checkApprovalBinding(b *ApprovalBinding, payload []byte) error {
+	if len(b.Salt) != b.KDF.saltLength() {
+		return errors.New("invalid envelope approval binding")
+	}
+	mac, err := e.approvalMAC(b, payload)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(b.MAC, mac) {
+		return errors.New("invalid envelope approval binding")
+	}
+	if e.approvalPolicy == nil || e.approvalObjectID != b.ObjectID || !hmac.Equal(b.Policy, e.approvalPolicy.Digest()) {
+		return ErrInsufficientApprovals
+	}
+	return e.checkApprovals()
+}
+
+func (e *EncryptManager) approvalMAC(b *ApprovalBinding, payload []byte) ([]byte, error) {
+	key, err := e.cfbKey(b.KDF, b.Salt)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("temporal-approval"))
+	binary.Write(mac, binary.BigEndian, uint64(len(b.Policy)))
+	mac.Write(b.Policy)
+	binary.Write(mac, binary.BigEndian, uint64(len(b.ObjectID)))
+	mac.Write([]byte(b.ObjectID))
+	digest := sha256.Sum256(payload)
+	mac.Write(digest[:])
+	return mac.Sum(nil), nil
+}
+
+// approvalMessage returns the message signed by approvers for objectID under
+// the policy with the given digest, until expires
+func approvalMessage(policy []byte, objectID string, expires time.Time) []byte {
+	buf := bytes.NewBufferString("temporal-decrypt-approval")
+	buf.Write(policy)
+	binary.Write(buf, binary.BigEndian, uint64(len(objectID)))
+	buf.WriteString(objectID)
+	binary.Write(buf, binary.BigEndian, expires.Unix())
+	return buf.Bytes()
+}
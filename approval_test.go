@@ -0,0 +1,259 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+func Test_EncryptManager_ApprovalGate(t *testing.T) {
+	var (
+		pubs  []ed25519.PublicKey
+		privs []ed25519.PrivateKey
+	)
+	for i := 0; i < 4; i++ {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubs = append(pubs, pub)
+		privs = append(privs, priv)
+	}
+	// the last key is not an approver
+	policy := &ApprovalPolicy{Threshold: 2, Approvers: pubs[:3]}
+	other := &ApprovalPolicy{Threshold: 1, Approvers: pubs[:3]}
+	encrypted, err := NewEncryptManager("helloworld").Encrypt(bytes.NewReader([]byte("top secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().Add(time.Hour)
+	tests := []struct {
+		name    string
+		tokens  []ApprovalToken
+		wantErr bool
+	}{
+		{"no approvals", nil, true},
+		{"single approval", []ApprovalToken{SignApproval(privs[0], policy, "object", expires)}, true},
+		{"duplicate approval", []ApprovalToken{
+			SignApproval(privs[0], policy, "object", expires), SignApproval(privs[0], policy, "object", expires),
+		}, true},
+		{"non approver", []ApprovalToken{
+			SignApproval(privs[0], policy, "object", expires), SignApproval(privs[3], policy, "object", expires),
+		}, true},
+		{"wrong object", []ApprovalToken{
+			SignApproval(privs[0], policy, "object", expires), SignApproval(privs[1], policy, "other-object", expires),
+		}, true},
+		{"wrong policy", []ApprovalToken{
+			SignApproval(privs[0], policy, "object", expires), SignApproval(privs[1], other, "object", expires),
+		}, true},
+		{"expired", []ApprovalToken{
+			SignApproval(privs[0], policy, "object", expires), SignApproval(privs[1], policy, "object", time.Now().Add(-time.Minute)),
+		}, true},
+		{"no expiry", []ApprovalToken{
+			SignApproval(privs[0], policy, "object", expires), SignApproval(privs[1], policy, "object", time.Time{}),
+		}, true},
+		{"extended expiry", []ApprovalToken{
+			SignApproval(privs[0], policy, "object", expires), func() ApprovalToken {
+				token := SignApproval(privs[1], policy, "object", time.Now().Add(-time.Minute))
+				token.Expires = expires
+				return token
+			}(),
+		}, true},
+		{"threshold met", []ApprovalToken{
+			SignApproval(privs[0], policy, "object", expires), SignApproval(privs[2], policy, "object", expires),
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEncryptManager("helloworld").WithApprovalGate(policy, "object", tt.tokens...)
+			decrypted, err := e.Decrypt(bytes.NewReader(encrypted))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decrypt err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if err != ErrInsufficientApprovals {
+					t.Fatalf("Decrypt err = %v, want %v", err, ErrInsufficientApprovals)
+				}
+				return
+			}
+			if string(decrypted) != "top secret" {
+				t.Fatalf("Decrypt = %s, want top secret", decrypted)
+			}
+		})
+	}
+	// tokens expire according to the clock of the manager
+	tokens := []ApprovalToken{SignApproval(privs[0], policy, "object", expires), SignApproval(privs[1], policy, "object", expires)}
+	late := NewEncryptManager("helloworld").WithApprovalGate(policy, "object", tokens...).
+		WithClock(ClockFunc(func() time.Time { return expires.Add(time.Second) }))
+	if _, err := late.Decrypt(bytes.NewReader(encrypted)); err != ErrInsufficientApprovals {
+		t.Fatalf("Decrypt err = %v, want %v", err, ErrInsufficientApprovals)
+	}
+	// the digest does not depend on the order of approvers
+	reordered := &ApprovalPolicy{Threshold: 2, Approvers: []ed25519.PublicKey{pubs[2], pubs[0], pubs[1]}}
+	if err := reordered.Verify("object", tokens); err != nil {
+		t.Fatal(err)
+	}
+	// a key listed twice must not let one approver meet a threshold of 2
+	duplicated := &ApprovalPolicy{Threshold: 2, Approvers: []ed25519.PublicKey{pubs[0], pubs[0], pubs[1]}}
+	err = duplicated.Verify("object", []ApprovalToken{SignApproval(privs[0], duplicated, "object", expires)})
+	if err == nil || err == ErrInsufficientApprovals {
+		t.Fatalf("Verify err = %v, want invalid policy", err)
+	}
+}
+
+func Test_EncryptManager_ApprovalBinding(t *testing.T) {
+	var (
+		pubs  []ed25519.PublicKey
+		privs []ed25519.PrivateKey
+	)
+	for i := 0; i < 2; i++ {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubs = append(pubs, pub)
+		privs = append(privs, priv)
+	}
+	policy := &ApprovalPolicy{Threshold: 2, Approvers: pubs}
+	expires := time.Now().Add(time.Hour)
+	tokens := []ApprovalToken{SignApproval(privs[0], policy, "object", expires), SignApproval(privs[1], policy, "object", expires)}
+	gated := func() *EncryptManager {
+		return NewEncryptManager("helloworld").WithApprovalGate(policy, "object", tokens...)
+	}
+
+	t.Run("envelope", func(t *testing.T) {
+		env, payload, err := gated().EncryptSplit(bytes.NewReader([]byte("top secret")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if env.Approval == nil || env.Version != 5 {
+			t.Fatalf("envelope version %d does not record the approval binding", env.Version)
+		}
+		if decrypted, err := gated().DecryptSplit(env, bytes.NewReader(payload)); err != nil || string(decrypted) != "top secret" {
+			t.Fatalf("DecryptSplit = %s, %v", decrypted, err)
+		}
+		if _, err := NewEncryptManager("helloworld").DecryptSplit(env, bytes.NewReader(payload)); err != ErrInsufficientApprovals {
+			t.Fatalf("DecryptSplit without gate err = %v, want %v", err, ErrInsufficientApprovals)
+		}
+		otherObject := NewEncryptManager("helloworld").WithApprovalGate(policy, "other-object", tokens...)
+		if _, err := otherObject.DecryptSplit(env, bytes.NewReader(payload)); err != ErrInsufficientApprovals {
+			t.Fatalf("DecryptSplit of other object err = %v, want %v", err, ErrInsufficientApprovals)
+		}
+		forged := *env.Approval
+		forged.ObjectID = "other-object"
+		env.Approval = &forged
+		if _, err := otherObject.DecryptSplit(env, bytes.NewReader(payload)); err == nil {
+			t.Fatal("expected error decrypting envelope with modified approval binding")
+		}
+	})
+
+	t.Run("kdf", func(t *testing.T) {
+		env, payload, err := gated().WithPBKDF2Iterations(1000).EncryptSplit(bytes.NewReader([]byte("top secret")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if env.Approval.KDF == nil || env.Approval.KDF.Iterations != 1000 {
+			t.Fatalf("approval binding kdf = %+v", env.Approval.KDF)
+		}
+		if _, err := gated().DecryptSplit(env, bytes.NewReader(payload)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := gated().WithKDFLimits(KDFLimits{MaxPBKDF2Iterations: 100}).DecryptSplit(env, bytes.NewReader(payload)); err == nil {
+			t.Fatal("expected error decrypting envelope exceeding kdf limits")
+		}
+	})
+
+	// AEAD protocols authenticate the binding, so it can not be removed
+	for _, protocol := range []Protocol{GCM, GCMStream, AEAD, ChaCha20Poly1305, XChaCha20Poly1305} {
+		t.Run(string(protocol), func(t *testing.T) {
+			e := gated()
+			e.protocol = protocol
+			env, payload, err := e.EncryptSplit(bytes.NewReader([]byte("top secret")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decrypted, err := gated().DecryptSplit(env, bytes.NewReader(payload)); err != nil || string(decrypted) != "top secret" {
+				t.Fatalf("DecryptSplit = %s, %v", decrypted, err)
+			}
+			env.Approval, env.Version = nil, 4
+			if _, err := NewEncryptManager("helloworld").DecryptSplit(env, bytes.NewReader(payload)); err == nil {
+				t.Fatal("expected error decrypting envelope with approval binding removed")
+			}
+		})
+	}
+}
+
+func Test_EncryptManager_ApprovalGate_DecryptPaths(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &ApprovalPolicy{Threshold: 1, Approvers: []ed25519.PublicKey{pub}}
+	ungated := func() *EncryptManager {
+		return NewEncryptManager("helloworld").WithApprovalGate(policy, "object")
+	}
+	approved := func() *EncryptManager {
+		return NewEncryptManager("helloworld").WithApprovalGate(policy, "object", SignApproval(priv, policy, "object", time.Now().Add(time.Hour)))
+	}
+
+	t.Run("jwe", func(t *testing.T) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jwe, err := NewEncryptManager("").EncryptJWE(bytes.NewReader([]byte("top secret")), &key.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ungated().DecryptJWE(jwe, key); err != ErrInsufficientApprovals {
+			t.Fatalf("DecryptJWE err = %v, want %v", err, ErrInsufficientApprovals)
+		}
+		if decrypted, err := approved().DecryptJWE(jwe, key); err != nil || string(decrypted) != "top secret" {
+			t.Fatalf("DecryptJWE = %s, %v", decrypted, err)
+		}
+	})
+
+	t.Run("age", func(t *testing.T) {
+		identity, err := GenerateAgeIdentity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var encrypted bytes.Buffer
+		if err := EncryptAge(&encrypted, bytes.NewReader([]byte("top secret")), identity.Recipient()); err != nil {
+			t.Fatal(err)
+		}
+		var decrypted bytes.Buffer
+		if err := ungated().DecryptAge(&decrypted, bytes.NewReader(encrypted.Bytes()), identity); err != ErrInsufficientApprovals {
+			t.Fatalf("DecryptAge err = %v, want %v", err, ErrInsufficientApprovals)
+		}
+		if err := approved().DecryptAge(&decrypted, bytes.NewReader(encrypted.Bytes()), identity); err != nil || decrypted.String() != "top secret" {
+			t.Fatalf("DecryptAge = %s, %v", decrypted.String(), err)
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		e := approved().WithGCMStream(nil)
+		encrypted, err := e.Encrypt(bytes.NewReader([]byte("top secret")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ungated().WithGCMStream(e.gcmDecryptParams).DecryptStream(&bytes.Buffer{}, bytes.NewReader(encrypted)); err != ErrInsufficientApprovals {
+			t.Fatalf("DecryptStream err = %v, want %v", err, ErrInsufficientApprovals)
+		}
+		if _, err := ungated().WithGCMStream(e.gcmDecryptParams).DecryptSeeker(bytes.NewReader(encrypted)); err != ErrInsufficientApprovals {
+			t.Fatalf("DecryptSeeker err = %v, want %v", err, ErrInsufficientApprovals)
+		}
+		// the stream is bound to the gate, so managers without it can not decrypt it
+		if err := NewEncryptManager("helloworld").WithGCMStream(e.gcmDecryptParams).DecryptStream(&bytes.Buffer{}, bytes.NewReader(encrypted)); err == nil {
+			t.Fatal("expected error decrypting stream without approval gate")
+		}
+		var decrypted bytes.Buffer
+		if err := approved().WithGCMStream(e.gcmDecryptParams).DecryptStream(&decrypted, bytes.NewReader(encrypted)); err != nil || decrypted.String() != "top secret" {
+			t.Fatalf("DecryptStream = %s, %v", decrypted.String(), err)
+		}
+	})
+}
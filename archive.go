@@ -0,0 +1,204 @@
+package crypto
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrArchiveTooLarge is returned when an archive expands beyond ArchiveLimits.MaxSize
+	ErrArchiveTooLarge = errors.New("archive exceeds maximum expanded size")
+	// ErrArchiveTooManyEntries is returned when an archive holds more than ArchiveLimits.MaxEntries
+	ErrArchiveTooManyEntries = errors.New("archive exceeds maximum number of entries")
+	// ErrUnsafeArchiveEntry is returned for archive entries which could be
+	// written outside of the destination, such as ../ paths, or links
+	ErrUnsafeArchiveEntry = errors.New("archive entry is unsafe to extract")
+)
+
+// ArchiveLimits are the hard limits enforced when extracting untrusted archives.
+// Zero values are not limited
+type ArchiveLimits struct {
+	// MaxSize is the maximum number of bytes the archive may expand to
+	MaxSize int64
+	// MaxEntries is the maximum number of files, and directories in the archive
+	MaxEntries int
+}
+
+// DecryptArchive is used to decrypt, and extract a tar archive, which may be
+// gzip compressed, into dir while enforcing limits. Archives exceeding the
+// limits, or holding entries other than files, and directories within dir are
+// rejected. Decryption is streamed, so if an error is returned dir may hold
+// partially extracted content, which should be discarded
+func (e *EncryptManager) DecryptArchive(src io.Reader, dir string, limits ArchiveLimits) error {
+	pr, pw := io.Pipe()
+	decrypted := make(chan error, 1)
+	go func() {
+		err := e.DecryptStream(pw, src)
+		pw.CloseWithError(err)
+		decrypted <- err
+	}()
+	r := &archiveLimitReader{r: pr, max: limits.MaxSize}
+	err := extractArchive(r, dir, limits, false)
+	if err == nil {
+		// consume any trailing padding so the whole stream is authenticated
+		_, err = io.Copy(ioutil.Discard, r)
+	}
+	pr.CloseWithError(err)
+	if derr := <-decrypted; err == nil {
+		err = derr
+	}
+	return err
+}
+
+// extractArchive extracts the tar archive read from r into dir. If preserve is
+// set, the modes of directories are restored, and symbolic links are extracted
+// as long as they resolve within dir
+func extractArchive(r io.Reader, dir string, limits ArchiveLimits, preserve bool) error {
+	br := bufio.NewReader(r)
+	var archive io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		// limit the decompressed size, as the compressed size is bounded by r
+		archive = &archiveLimitReader{r: gz, max: limits.MaxSize}
+	}
+	tr := tar.NewReader(archive)
+	// directory modes are restored last, so read only directories can be filled
+	var dirs []*tar.Header
+	for entries := 0; ; entries++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			for i := len(dirs) - 1; i >= 0; i-- {
+				if err := os.Chmod(filepath.Join(dir, filepath.FromSlash(dirs[i].Name)), dirs[i].FileInfo().Mode().Perm()); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if limits.MaxEntries > 0 && entries >= limits.MaxEntries {
+			return ErrArchiveTooManyEntries
+		}
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if escapesDir(name) {
+			return ErrUnsafeArchiveEntry
+		}
+		path := filepath.Join(dir, name)
+		// never write through links extracted earlier, including links
+		// within the parents of the entry, which would let a chain of links
+		// each resolving within dir lead outside of it
+		linked, err := throughSymlink(dir, name)
+		if err != nil {
+			return err
+		}
+		if linked {
+			return ErrUnsafeArchiveEntry
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			if preserve {
+				hdr.Name = name
+				dirs = append(dirs, hdr)
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err := extractArchiveFile(path, hdr.FileInfo().Mode().Perm(), tr); err != nil {
+				return err
+			}
+			// the mode given when creating the file is subject to the umask
+			if preserve {
+				if err := os.Chmod(path, hdr.FileInfo().Mode().Perm()); err != nil {
+					return err
+				}
+			}
+		case tar.TypeSymlink:
+			// links are resolved relative to the directory holding them
+			target := filepath.FromSlash(hdr.Linkname)
+			if !preserve || filepath.IsAbs(target) || escapesDir(filepath.Join(filepath.Dir(name), target)) {
+				return ErrUnsafeArchiveEntry
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(target, path); err != nil {
+				return err
+			}
+		default:
+			return ErrUnsafeArchiveEntry
+		}
+	}
+}
+
+// escapesDir indicates whether the cleaned relative path name refers to a
+// location outside of the directory it is relative to
+func escapesDir(name string) bool {
+	return filepath.IsAbs(name) || filepath.VolumeName(name) != "" ||
+		name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator))
+}
+
+// throughSymlink indicates whether the relative path name within dir, or any
+// of its parents, is an existing symbolic link
+func throughSymlink(dir, name string) (bool, error) {
+	path := dir
+	for _, part := range strings.Split(name, string(filepath.Separator)) {
+		path = filepath.Join(path, part)
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// extractArchiveFile writes the content of the current archive entry to path
+func extractArchiveFile(path string, perm os.FileMode, r io.Reader) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// archiveLimitReader fails with ErrArchiveTooLarge once more than max bytes
+// are read, or never if max is zero
+type archiveLimitReader struct {
+	r    io.Reader
+	max  int64
+	read int64
+}
+
+func (l *archiveLimitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.max > 0 && l.read > l.max {
+		return 0, ErrArchiveTooLarge
+	}
+	return n, err
+}
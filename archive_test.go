@@ -0,0 +1,127 @@
+package crypto
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type archiveEntry struct {
+	name     string
+	typeflag byte
+	body     []byte
+}
+
+func newArchive(t *testing.T, compress bool, entries ...archiveEntry) []byte {
+	var buf bytes.Buffer
+	var gz *gzip.Writer
+	tw := tar.NewWriter(&buf)
+	if compress {
+		gz = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(gz)
+	}
+	for _, entry := range entries {
+		hdr := &tar.Header{Name: entry.name, Typeflag: entry.typeflag, Mode: 0600, Size: int64(len(entry.body))}
+		if entry.typeflag == tar.TypeSymlink {
+			hdr.Linkname, hdr.Size = "/etc/passwd", 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(entry.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func Test_EncryptManager_DecryptArchive(t *testing.T) {
+	files := []archiveEntry{
+		{"docs", tar.TypeDir, nil},
+		{"docs/hello.txt", tar.TypeReg, []byte("hello world")},
+		{"nested/dir/data.bin", tar.TypeReg, bytes.Repeat([]byte{1}, 1024)},
+	}
+	tests := []struct {
+		name     string
+		compress bool
+		entries  []archiveEntry
+		limits   ArchiveLimits
+		wantErr  error
+	}{
+		{"tar", false, files, ArchiveLimits{}, nil},
+		{"tar-gz", true, files, ArchiveLimits{MaxSize: 1 << 20, MaxEntries: 3}, nil},
+		{"traversal", false, []archiveEntry{{"../evil.txt", tar.TypeReg, []byte("evil")}}, ArchiveLimits{}, ErrUnsafeArchiveEntry},
+		{"nested-traversal", false, []archiveEntry{{"docs/../../evil.txt", tar.TypeReg, []byte("evil")}}, ArchiveLimits{}, ErrUnsafeArchiveEntry},
+		{"absolute", false, []archiveEntry{{"/tmp/evil.txt", tar.TypeReg, []byte("evil")}}, ArchiveLimits{}, ErrUnsafeArchiveEntry},
+		{"symlink", false, []archiveEntry{{"passwd", tar.TypeSymlink, nil}}, ArchiveLimits{}, ErrUnsafeArchiveEntry},
+		{"too-many-entries", false, files, ArchiveLimits{MaxEntries: 2}, ErrArchiveTooManyEntries},
+		{"too-large", false, files, ArchiveLimits{MaxSize: 2048}, ErrArchiveTooLarge},
+		{"gzip-bomb", true, []archiveEntry{{"bomb", tar.TypeReg, make([]byte, 4<<20)}}, ArchiveLimits{MaxSize: 1 << 20}, ErrArchiveTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "archive")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			dest := filepath.Join(dir, "dest")
+			e := NewEncryptManager("helloworld").WithGCMStream(nil)
+			encrypted, err := e.Encrypt(bytes.NewReader(newArchive(t, tt.compress, tt.entries...)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = e.DecryptArchive(bytes.NewReader(encrypted), dest, tt.limits)
+			if err != tt.wantErr {
+				t.Fatalf("DecryptArchive() err = %v, want %v", err, tt.wantErr)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "evil.txt")); !os.IsNotExist(err) {
+				t.Fatal("archive entry written outside of destination")
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			for _, entry := range tt.entries {
+				if entry.typeflag != tar.TypeReg {
+					continue
+				}
+				data, err := ioutil.ReadFile(filepath.Join(dest, entry.name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(data, entry.body) {
+					t.Fatalf("%s does not match original", entry.name)
+				}
+			}
+		})
+	}
+}
+
+func Test_EncryptManager_DecryptArchive_Tampered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	e := NewEncryptManager("helloworld").WithGCMStream(nil)
+	encrypted, err := e.Encrypt(bytes.NewReader(newArchive(t, false, archiveEntry{"hello.txt", tar.TypeReg, []byte("hello world")})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted[len(encrypted)-1] ^= 0xff
+	if err := e.DecryptArchive(bytes.NewReader(encrypted), dir, ArchiveLimits{}); err == nil {
+		t.Fatal("extracted tampered archive")
+	}
+}
@@ -0,0 +1,75 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Attestation is an externally generated proof embedded in an Envelope, ie a
+// zero-knowledge proof that the payload corresponds to a committed plaintext hash
+type Attestation struct {
+	// Type identifies the kind of attestation, and the verifier used for it
+	Type string `json:"type"`
+	Data []byte `json:"data"`
+}
+
+// Attestor is used to generate an attestation over a plaintext, and its encrypted payload
+type Attestor interface {
+	Attest(plaintext, payload []byte) (*Attestation, error)
+}
+
+// AttestationVerifier is used to verify an attestation once its payload has been decrypted
+type AttestationVerifier interface {
+	VerifyAttestation(att *Attestation, plaintext, payload []byte) error
+}
+
+// WithAttestors is used to embed attestations generated by the given
+// attestors in the envelopes returned by EncryptSplit
+func (e *EncryptManager) WithAttestors(attestors ...Attestor) *EncryptManager {
+	e.attestors = attestors
+	return e
+}
+
+// WithAttestationVerifiers is used to verify attestations embedded in envelopes
+// by DecryptSplit, keyed by attestation type. Every type with a verifier is
+// required to be present, while attestations of other types are ignored
+func (e *EncryptManager) WithAttestationVerifiers(verifiers map[string]AttestationVerifier) *EncryptManager {
+	e.attestVerifiers = verifiers
+	return e
+}
+
+// attest generates attestations using all configured attestors
+func (e *EncryptManager) attest(plaintext, payload []byte) ([]Attestation, error) {
+	var attestations []Attestation
+	for _, attestor := range e.attestors {
+		att, err := attestor.Attest(plaintext, payload)
+		if err != nil {
+			return nil, err
+		}
+		if att == nil || att.Type == "" {
+			return nil, errors.New("attestor returned an invalid attestation")
+		}
+		attestations = append(attestations, *att)
+	}
+	return attestations, nil
+}
+
+// verifyAttestations verifies the attestations of an envelope using the configured verifiers
+func (e *EncryptManager) verifyAttestations(attestations []Attestation, plaintext, payload []byte) error {
+	for attType, verifier := range e.attestVerifiers {
+		found := false
+		for i := range attestations {
+			if attestations[i].Type != attType {
+				continue
+			}
+			found = true
+			if err := verifier.VerifyAttestation(&attestations[i], plaintext, payload); err != nil {
+				return fmt.Errorf("%s attestation verification failed: %s", attType, err)
+			}
+		}
+		if !found {
+			return fmt.Errorf("envelope is missing required %s attestation", attType)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+// commitmentAttestor attests to the sha256 commitment of the plaintext
+type commitmentAttestor struct{}
+
+func (commitmentAttestor) Attest(plaintext, payload []byte) (*Attestation, error) {
+	sum := sha256.Sum256(plaintext)
+	return &Attestation{Type: "commitment", Data: sum[:]}, nil
+}
+
+func (commitmentAttestor) VerifyAttestation(att *Attestation, plaintext, payload []byte) error {
+	sum := sha256.Sum256(plaintext)
+	if !bytes.Equal(att.Data, sum[:]) {
+		return errors.New("plaintext does not match commitment")
+	}
+	return nil
+}
+
+func Test_EncryptManager_Attestations(t *testing.T) {
+	verifiers := map[string]AttestationVerifier{"commitment": commitmentAttestor{}}
+	e := NewEncryptManager("helloworld").WithGCM(nil).WithAttestors(commitmentAttestor{})
+	env, payload, err := e.EncryptSplit(bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(env.Attestations) != 1 {
+		t.Fatalf("got %d attestations, want 1", len(env.Attestations))
+	}
+	d := NewEncryptManager("helloworld").WithAttestationVerifiers(verifiers)
+	decrypted, err := d.DecryptSplit(env, bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != "hello world" {
+		t.Fatalf("DecryptSplit = %s", decrypted)
+	}
+	// a forged commitment must be rejected
+	env.Attestations[0].Data[0] ^= 0xff
+	if _, err := d.DecryptSplit(env, bytes.NewReader(payload)); err == nil {
+		t.Fatal("expected error decrypting with forged attestation")
+	}
+	// as must a missing attestation when a verifier is configured
+	env.Attestations = nil
+	if _, err := d.DecryptSplit(env, bytes.NewReader(payload)); err == nil {
+		t.Fatal("expected error decrypting without required attestation")
+	}
+	// while managers without verifiers ignore attestations
+	if _, err := NewEncryptManager("helloworld").DecryptSplit(env, bytes.NewReader(payload)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,266 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// paramBundleVersion is the version of the sealed bundle format. Version
+	// 2 bundles sealed under a passphrase record the KDF header before the
+	// salt, while version 1 bundles use the legacy key derivation function
+	paramBundleVersion byte = 2
+	// paramBundleLegacyVersion is the version of bundles without a KDF header
+	paramBundleLegacyVersion byte = 1
+	// bundlePassphrase marks a bundle sealed under a passphrase
+	bundlePassphrase byte = 1
+	// bundleRecipient marks a bundle sealed to a recipient's X25519 public key
+	bundleRecipient byte = 2
+)
+
+// ParamBundle holds the decryption parameters of many objects, indexed by CID
+// or name, so that they may be stored and shared as a single file encrypted
+// once, rather than as one parameter file per object. Sealed bundles are:
+//
+//	version || mode || cipher || [kdf header] || salt or ephemeral public key || sealed entries
+type ParamBundle struct {
+	mux     sync.RWMutex
+	entries map[string]BundleEntry
+}
+
+// BundleEntry holds the unencrypted decryption parameters of a single object
+type BundleEntry struct {
+	Protocol  Protocol `json:"protocol"`
+	CipherKey string   `json:"cipher_key"`
+	Nonce     string   `json:"nonce"`
+}
+
+// NewParamBundle is used to instantiate an empty ParamBundle
+func NewParamBundle() *ParamBundle {
+	return &ParamBundle{entries: make(map[string]BundleEntry)}
+}
+
+// Add stores the current decryption parameters of e under id, replacing
+// any existing entry. e must be using a protocol with decryption parameters
+func (b *ParamBundle) Add(id string, e *EncryptManager) error {
+	if id == "" {
+		return errors.New("no object id provided")
+	}
+	params := e.getGCMDecryptParams()
+	if !e.hasDecryptParams() || params == nil {
+		return errors.New("no decryption parameters to add")
+	}
+	if err := e.checkKeyExport(false); err != nil {
+		return err
+	}
+	b.mux.Lock()
+	b.entries[id] = BundleEntry{Protocol: e.getProtocol(), CipherKey: params.CipherKey, Nonce: params.Nonce}
+	b.mux.Unlock()
+	return nil
+}
+
+// Get returns the entry stored under id
+func (b *ParamBundle) Get(id string) (BundleEntry, error) {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	entry, ok := b.entries[id]
+	if !ok {
+		return BundleEntry{}, ErrParamsNotFound
+	}
+	return entry, nil
+}
+
+// Remove deletes the entry stored under id
+func (b *ParamBundle) Remove(id string) {
+	b.mux.Lock()
+	delete(b.entries, id)
+	b.mux.Unlock()
+}
+
+// IDs returns the sorted ids of all entries in the bundle
+func (b *ParamBundle) IDs() []string {
+	b.mux.RLock()
+	ids := make([]string, 0, len(b.entries))
+	for id := range b.entries {
+		ids = append(ids, id)
+	}
+	b.mux.RUnlock()
+	sort.Strings(ids)
+	return ids
+}
+
+// Manager returns an EncryptManager configured to decrypt the object stored under id
+func (b *ParamBundle) Manager(id string) (*EncryptManager, error) {
+	entry, err := b.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	params := &GCMDecryptParams{CipherKey: entry.CipherKey, Nonce: entry.Nonce}
+	switch entry.Protocol {
+	case GCM:
+		return NewEncryptManager("").WithGCM(params), nil
+	case AEAD:
+		return NewEncryptManager("").WithAEAD(params), nil
+	case GCMStream:
+		return NewEncryptManager("").WithGCMStream(params), nil
+	case ChaCha20Poly1305:
+		return NewEncryptManager("").WithChaCha20Poly1305(params), nil
+	case XChaCha20Poly1305:
+		return NewEncryptManager("").WithXChaCha20Poly1305(params), nil
+	case Archive:
+		return NewEncryptManager("").WithArchive(params), nil
+	case SecretBox:
+		return NewEncryptManager("").WithSecretBox(params), nil
+	default:
+		return NewEncryptManager("").WithRegisteredProtocol(entry.Protocol, params)
+	}
+}
+
+// Seal is used to encrypt the bundle under passphrase, using a key derived
+// using scrypt with the default parameters
+func (b *ParamBundle) Seal(passphrase string) ([]byte, error) {
+	return NewEncryptManager(passphrase).WithKDF(DefaultKDFConfig(Scrypt)).SealParamBundle(b)
+}
+
+// SealParamBundle is used to encrypt b under the passphrase of the manager,
+// using a key derived by the KDF set using WithKDF, which is recorded in the
+// bundle, or the legacy function if none is set
+func (e *EncryptManager) SealParamBundle(b *ParamBundle) ([]byte, error) {
+	key, header, err := e.newKDFKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := e.recordNonce(key, nil); err != nil {
+		return nil, err
+	}
+	version := paramBundleVersion
+	if e.kdf == nil {
+		version = paramBundleLegacyVersion
+	}
+	return b.seal(version, bundlePassphrase, header, key)
+}
+
+// SealForRecipient is used to encrypt the bundle to the X25519 public key of
+// a recipient, such as one generated using golang.org/x/crypto/nacl/box
+func (b *ParamBundle) SealForRecipient(recipient *[32]byte) ([]byte, error) {
+	var ephemeral, public [32]byte
+	if _, err := io.ReadFull(rand.Reader, ephemeral[:]); err != nil {
+		return nil, err
+	}
+	curve25519.ScalarBaseMult(&public, &ephemeral)
+	key, err := bundleRecipientKey(&ephemeral, recipient, public[:], recipient[:])
+	if err != nil {
+		return nil, err
+	}
+	return b.seal(paramBundleVersion, bundleRecipient, public[:], key)
+}
+
+func (b *ParamBundle) seal(version, mode byte, header, key []byte) ([]byte, error) {
+	b.mux.RLock()
+	entries, err := json.Marshal(b.entries)
+	b.mux.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	id := preferredAEAD()
+	aead, err := newAEAD(id, key)
+	if err != nil {
+		return nil, err
+	}
+	out := append([]byte{version, mode, id}, header...)
+	// every bundle is sealed under a freshly derived key, so the nonce is fixed
+	return aead.Seal(out, make([]byte, aead.NonceSize()), entries, out), nil
+}
+
+// OpenParamBundle is used to decrypt a bundle sealed using Seal
+func OpenParamBundle(data []byte, passphrase string) (*ParamBundle, error) {
+	return NewEncryptManager(passphrase).OpenParamBundle(data)
+}
+
+// OpenParamBundle is used to decrypt a bundle sealed under the passphrase of
+// the manager, using Seal, or SealParamBundle. The KDF recorded in the bundle
+// is subject to the limits, and format policy of the manager
+func (e *EncryptManager) OpenParamBundle(data []byte) (*ParamBundle, error) {
+	return openParamBundle(data, bundlePassphrase, func(version byte, header []byte) ([]byte, int, error) {
+		if version == paramBundleLegacyVersion {
+			if len(header) < saltlen {
+				return nil, 0, errors.New("invalid parameter bundle")
+			}
+			key, err := e.cfbKey(nil, header[:saltlen])
+			return key, saltlen, err
+		}
+		if !hasKDFHeader(header) {
+			return nil, 0, errors.New("invalid parameter bundle")
+		}
+		return e.readKDFKey(header)
+	})
+}
+
+// OpenParamBundleForRecipient is used to decrypt a bundle sealed using
+// SealForRecipient, with the private key of the recipient
+func OpenParamBundleForRecipient(data []byte, private *[32]byte) (*ParamBundle, error) {
+	return openParamBundle(data, bundleRecipient, func(version byte, header []byte) ([]byte, int, error) {
+		if len(header) < 32 {
+			return nil, 0, errors.New("invalid parameter bundle")
+		}
+		var peer, public [32]byte
+		copy(peer[:], header)
+		curve25519.ScalarBaseMult(&public, private)
+		key, err := bundleRecipientKey(private, &peer, header[:32], public[:])
+		return key, 32, err
+	})
+}
+
+// openParamBundle opens data sealed using mode, where key derives the bundle
+// key from the header following the cipher, returning the length of the header
+func openParamBundle(data []byte, mode byte, key func(version byte, header []byte) ([]byte, int, error)) (*ParamBundle, error) {
+	if len(data) < 3 {
+		return nil, errors.New("invalid parameter bundle")
+	}
+	if data[0] != paramBundleVersion && data[0] != paramBundleLegacyVersion {
+		return nil, fmt.Errorf("unsupported parameter bundle version %d", data[0])
+	}
+	if data[1] != mode {
+		return nil, errors.New("parameter bundle was sealed using a different method")
+	}
+	derived, n, err := key(data[0], data[3:])
+	if err != nil {
+		return nil, err
+	}
+	headerLen := 3 + n
+	aead, err := newAEAD(data[2], derived)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := aead.Open(nil, make([]byte, aead.NonceSize()), data[headerLen:], data[:headerLen])
+	if err != nil {
+		return nil, err
+	}
+	b := NewParamBundle()
+	if err := json.Unmarshal(entries, &b.entries); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// bundleRecipientKey derives the bundle key from the X25519 shared secret,
+// bound to both the ephemeral and recipient public keys
+func bundleRecipientKey(private, peer *[32]byte, ephemeral, recipient []byte) ([]byte, error) {
+	var shared [32]byte
+	curve25519.ScalarMult(&shared, private, peer)
+	salt := append(append([]byte{}, ephemeral...), recipient...)
+	key := make([]byte, keylen)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared[:], salt, []byte("temporal-param-bundle")), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,154 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+func Test_ParamBundle(t *testing.T) {
+	bundle := NewParamBundle()
+	encrypted := make(map[string][]byte)
+	for i, protocol := range []Protocol{GCM, AEAD, GCM} {
+		e := NewEncryptManager("helloworld")
+		if protocol == AEAD {
+			e.WithAEAD(nil)
+		} else {
+			e.WithGCM(nil)
+		}
+		id := fmt.Sprintf("object-%d", i)
+		out, err := e.Encrypt(bytes.NewReader([]byte(id)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bundle.Add(id, e); err != nil {
+			t.Fatal(err)
+		}
+		encrypted[id] = out
+	}
+	if err := bundle.Add("cfb", NewEncryptManager("helloworld")); err == nil {
+		t.Fatal("expected error adding manager without decryption parameters")
+	}
+	if _, err := bundle.Get("missing"); err != ErrParamsNotFound {
+		t.Fatalf("got error %v, want %v", err, ErrParamsNotFound)
+	}
+
+	public, private, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPrivate, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealedPassphrase, err := bundle.Seal("bundlepass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealedRecipient, err := bundle.SealForRecipient(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		open    func() (*ParamBundle, error)
+		wantErr bool
+	}{
+		{"passphrase", func() (*ParamBundle, error) { return OpenParamBundle(sealedPassphrase, "bundlepass") }, false},
+		{"wrong-passphrase", func() (*ParamBundle, error) { return OpenParamBundle(sealedPassphrase, "wrong") }, true},
+		{"recipient", func() (*ParamBundle, error) { return OpenParamBundleForRecipient(sealedRecipient, private) }, false},
+		{"wrong-recipient", func() (*ParamBundle, error) { return OpenParamBundleForRecipient(sealedRecipient, otherPrivate) }, true},
+		{"wrong-method", func() (*ParamBundle, error) { return OpenParamBundle(sealedRecipient, "bundlepass") }, true},
+		{"truncated", func() (*ParamBundle, error) { return OpenParamBundle(sealedPassphrase[:10], "bundlepass") }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opened, err := tt.open()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("open error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(opened.IDs(), bundle.IDs()) {
+				t.Fatalf("IDs = %v, want %v", opened.IDs(), bundle.IDs())
+			}
+			for id, out := range encrypted {
+				m, err := opened.Manager(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				decrypted, err := m.Decrypt(bytes.NewReader(out))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(decrypted) != id {
+					t.Fatalf("decrypted %q, want %q", decrypted, id)
+				}
+			}
+		})
+	}
+	bundle.Remove("object-0")
+	if _, err := bundle.Manager("object-0"); err != ErrParamsNotFound {
+		t.Fatalf("got error %v, want %v", err, ErrParamsNotFound)
+	}
+}
+
+func Test_ParamBundle_KDF(t *testing.T) {
+	bundle := NewParamBundle()
+	e := NewEncryptManager("").WithGCM(nil)
+	if _, err := e.Encrypt(bytes.NewReader([]byte("hello world"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := bundle.Add("object", e); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := NewEncryptManager("bundlepass").WithPBKDF2Iterations(1000).SealParamBundle(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sealed[0] != paramBundleVersion || !hasKDFHeader(sealed[3:]) {
+		t.Fatal("bundle does not record the kdf")
+	}
+	legacy, err := NewEncryptManager("bundlepass").SealParamBundle(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacy[0] != paramBundleLegacyVersion {
+		t.Fatalf("legacy bundle version = %d", legacy[0])
+	}
+	for _, data := range [][]byte{sealed, legacy} {
+		opened, err := OpenParamBundle(data, "bundlepass")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := opened.Get("object"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the recorded kdf is subject to the format policy, and limits of the manager
+	policy, err := NewEncryptManager("bundlepass").WithFormatPolicy(FormatPolicy{KDF: &KDFPolicy{MinPBKDF2Iterations: 10000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range [][]byte{sealed, legacy} {
+		if _, err := policy.OpenParamBundle(data); err != ErrDowngrade {
+			t.Fatalf("OpenParamBundle() err = %v, want %v", err, ErrDowngrade)
+		}
+	}
+	limited := NewEncryptManager("bundlepass").WithKDFLimits(KDFLimits{MaxPBKDF2Iterations: 100})
+	if _, err := limited.OpenParamBundle(sealed); err == nil {
+		t.Fatal("expected error opening bundle exceeding kdf limits")
+	}
+	// the default kdf is recorded
+	if sealed, err = bundle.Seal("bundlepass"); err != nil {
+		t.Fatal(err)
+	}
+	if kdf, _, err := parseKDFHeader(sealed[3:]); err != nil || kdf == nil || kdf.KDF != Scrypt {
+		t.Fatalf("Seal() kdf = %v, %v", kdf, err)
+	}
+}
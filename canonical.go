@@ -0,0 +1,94 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// canonicalMagic prefixes the canonical representation of an Envelope
+var canonicalMagic = []byte("TENV")
+
+// Canonicalize returns the canonical byte representation of the envelope,
+// allowing signatures over envelopes to be reproduced across languages, and
+// versions, regardless of how the envelope was serialized. Every field is
+// always present, in the following order, with absent fields encoded as empty:
+//
+//	"TENV" || version || protocol || iv || salt || kdf || cipher || params ||
+//	attestations || checksum || validity
+//
+// Integers are big endian. Byte strings, and text are encoded as a 4 byte
+// length followed by their contents. The kdf is encoded as its header, the
+// attestations as a 4 byte count followed by the type, and data of each,
+// the checksum as its algorithm, and digest, and the validity as the
+// not before, and not after times in nanoseconds since the unix epoch
+// (zero when open) followed by its salt, and mac. The AES256-CFB mac, the
+// compression, encoded as its algorithm, and 4 byte dictionary ID, and the
+// wrapped key, encoded as its key ID, and data, and the signature are
+// appended in that order only when present, or when followed by a later
+// field, so envelopes without them encode as before
+func (env *Envelope) Canonicalize() ([]byte, error) {
+	buf := bytes.NewBuffer(append([]byte{}, canonicalMagic...))
+	binary.Write(buf, binary.BigEndian, uint32(env.Version))
+	writeCanonical(buf, []byte(env.Protocol))
+	writeCanonical(buf, env.IV)
+	writeCanonical(buf, env.Salt)
+	var kdf []byte
+	if env.KDF != nil {
+		var err error
+		if kdf, err = env.KDF.header(); err != nil {
+			return nil, err
+		}
+	}
+	writeCanonical(buf, kdf)
+	buf.WriteByte(env.Cipher)
+	writeCanonical(buf, env.Params)
+	binary.Write(buf, binary.BigEndian, uint32(len(env.Attestations)))
+	for _, att := range env.Attestations {
+		writeCanonical(buf, []byte(att.Type))
+		writeCanonical(buf, att.Data)
+	}
+	var checksum bytes.Buffer
+	if env.Checksum != nil {
+		writeCanonical(&checksum, []byte(env.Checksum.Algorithm))
+		writeCanonical(&checksum, env.Checksum.Digest)
+	}
+	writeCanonical(buf, checksum.Bytes())
+	var validity bytes.Buffer
+	if env.Validity != nil {
+		binary.Write(&validity, binary.BigEndian, validityTime(env.Validity.NotBefore))
+		binary.Write(&validity, binary.BigEndian, validityTime(env.Validity.NotAfter))
+		writeCanonical(&validity, env.Validity.Salt)
+		writeCanonical(&validity, env.Validity.MAC)
+	}
+	writeCanonical(buf, validity.Bytes())
+	signed := len(env.Signature) > 0
+	if len(env.MAC) > 0 || env.Compression != nil || env.WrappedKey != nil || signed {
+		writeCanonical(buf, env.MAC)
+	}
+	if env.Compression != nil || env.WrappedKey != nil || signed {
+		var compression Compression
+		if env.Compression != nil {
+			compression = *env.Compression
+		}
+		writeCanonical(buf, []byte(compression.Algorithm))
+		binary.Write(buf, binary.BigEndian, compression.Dictionary)
+	}
+	if env.WrappedKey != nil || signed {
+		var wrapped WrappedKey
+		if env.WrappedKey != nil {
+			wrapped = *env.WrappedKey
+		}
+		writeCanonical(buf, []byte(wrapped.KeyID))
+		writeCanonical(buf, wrapped.Data)
+	}
+	if signed {
+		writeCanonical(buf, env.Signature)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeCanonical writes the length prefixed data to buf
+func writeCanonical(buf *bytes.Buffer, data []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.Write(data)
+}
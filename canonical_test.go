@@ -0,0 +1,81 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func Test_Envelope_Canonicalize(t *testing.T) {
+	env := &Envelope{
+		Version:  1,
+		Protocol: CFB,
+		IV:       []byte{1, 2},
+		Salt:     []byte{3},
+		KDF:      &KDFConfig{KDF: PBKDF2, Iterations: 4096},
+		Params:   []byte{4},
+		Attestations: []Attestation{
+			{Type: "a", Data: []byte{5}},
+		},
+		Checksum: &Checksum{Algorithm: SHA256, Digest: []byte{6}},
+		Validity: &Validity{
+			NotAfter: time.Unix(1, 0),
+			Salt:     []byte{7},
+			MAC:      []byte{8},
+		},
+	}
+	canonical, err := env.Canonicalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the canonical form is fixed across releases
+	want := "54454e5600000001" + // magic, version
+		"0000000a4145533235362d434642" + // protocol
+		"000000020102" + "0000000103" + // iv, salt
+		"0000000a544b44460101" + "00001000" + // kdf
+		"00" + "0000000104" + // cipher, params
+		"00000001" + "0000000161" + "0000000105" + // attestations
+		"00000010" + "00000007" + "5348412d323536" + "0000000106" + // checksum
+		"0000001a" + "0000000000000000" + "000000003b9aca00" + "0000000107" + "0000000108" // validity
+	if got := hex.EncodeToString(canonical); got != want {
+		t.Fatalf("Canonicalize =\n%s\nwant\n%s", got, want)
+	}
+
+	// serialization does not affect the canonical form
+	encoded, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Envelope
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	roundtrip, err := decoded.Canonicalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(roundtrip, canonical) {
+		t.Fatal("canonical form changed after json roundtrip")
+	}
+
+	// moving bytes between adjacent fields changes the canonical form
+	moved := *env
+	moved.IV, moved.Salt = []byte{1}, []byte{2, 3}
+	changed, err := moved.Canonicalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(changed, canonical) {
+		t.Fatal("distinct envelopes share a canonical form")
+	}
+
+	empty, err := (&Envelope{}).Canonicalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(empty) != len(canonicalMagic)+4+4*5+1+4*3 {
+		t.Fatalf("unexpected canonical length %d for empty envelope", len(empty))
+	}
+}
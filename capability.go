@@ -0,0 +1,265 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+const (
+	// capabilityVersion is the version of the capability token format.
+	// Version 2 tokens record the KDF header before the salt, while version 1
+	// tokens use the legacy key derivation function
+	capabilityVersion byte = 2
+	// capabilityLegacyVersion is the version of tokens without a KDF header
+	capabilityLegacyVersion byte = 1
+)
+
+// kinds of capabilities
+const (
+	capabilityObject byte = 1
+	capabilityChunks byte = 2
+)
+
+// ErrCapabilityScope is returned when using a capability for data it does not grant access to
+var ErrCapabilityScope = errors.New("data is outside of the scope of the capability")
+
+// Capability grants decryption of a single encrypted object, or a range of
+// chunks, without revealing the passphrase, or any other key. Capabilities are
+// read only, as the keys they hold can't be exported again, and expire at
+// NotAfter, if set. Tokens are encoded as
+//
+//	version || [kdf header] || salt || AES-KWP(kek, kind || scope || keys || usage)
+//
+// where the key-encryption key is derived from the passphrase of the grantee,
+// using the KDF of the minting manager set using WithKDF
+type Capability struct {
+	Protocol Protocol
+	// Object is the SHA-256 digest of the encrypted object, for object capabilities
+	Object []byte
+	// Start, and End are the range of chunks [Start, End), for chunk capabilities
+	Start    uint64
+	End      uint64
+	NotAfter time.Time
+
+	e      *EncryptManager
+	chunks *ChunkKeyRange
+}
+
+// MintCapability is used to mint a token granting decryption of the encrypted
+// object read from r, using the decryption parameters of the manager, to the
+// holder of the grantee passphrase until notAfter, or indefinitely if zero.
+// The usage constraints of unwrapped parameters are carried over
+func (e *EncryptManager) MintCapability(r io.Reader, grantee string, notAfter time.Time) (string, error) {
+	if r == nil {
+		return "", errors.New("invalid content provided")
+	}
+	protocol := e.getProtocol()
+	switch protocol {
+	case GCM, GCMStream, AEAD, ChaCha20Poly1305, XChaCha20Poly1305, Archive, SecretBox:
+	default:
+		return "", fmt.Errorf("capabilities are not supported by protocol %s", protocol)
+	}
+	if err := e.checkKeyExport(true); err != nil {
+		return "", err
+	}
+	key, nonce, err := e.decodeGCMDecryptParams()
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.New()
+	if _, err := io.Copy(digest, r); err != nil {
+		return "", err
+	}
+	body := []byte{capabilityObject, byte(len(protocol))}
+	body = append(append(body, protocol...), digest.Sum(nil)...)
+	body = append(append(body, key...), byte(len(nonce)))
+	body = append(body, nonce...)
+	usage := (&KeyUsage{DecryptOnly: true, NotAfter: notAfter}).narrow(e.getGCMDecryptParams().usage)
+	return e.sealCapability(body, usage, grantee)
+}
+
+// MintChunkCapability is used to mint a token granting decryption of the
+// chunks [start, end) sealed using keys, to the holder of the grantee
+// passphrase until notAfter, or indefinitely if zero
+func (e *EncryptManager) MintChunkCapability(keys *ChunkKeys, start, end uint64, grantee string, notAfter time.Time) (string, error) {
+	if keys == nil {
+		return "", errors.New("no chunk keys provided")
+	}
+	if end <= start || end-start > maxChunkKeyRange {
+		return "", errors.New("invalid chunk range")
+	}
+	r, err := keys.Range(start, end)
+	if err != nil {
+		return "", err
+	}
+	body := make([]byte, 9, 9+len(r.Keys)*keylen)
+	body[0] = capabilityChunks
+	binary.BigEndian.PutUint64(body[1:], start)
+	for _, key := range r.Keys {
+		body = append(body, key...)
+	}
+	return e.sealCapability(body, &KeyUsage{DecryptOnly: true, NotAfter: notAfter}, grantee)
+}
+
+// sealCapability wraps body followed by the encoded usage, and its length
+// under a key derived from grantee
+func (e *EncryptManager) sealCapability(body []byte, usage *KeyUsage, grantee string) (string, error) {
+	if grantee == "" {
+		return "", errors.New("no grantee passphrase provided")
+	}
+	encoded, err := usage.marshal()
+	if err != nil {
+		return "", err
+	}
+	length := make([]byte, 2)
+	binary.BigEndian.PutUint16(length, uint16(len(encoded)))
+	g := e.Clone()
+	g.passphrase = []byte(grantee)
+	kek, header, err := g.newKDFKey()
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := WrapKeyWithPadding(kek, append(append(body, encoded...), length...))
+	if err != nil {
+		return "", err
+	}
+	version := capabilityVersion
+	if e.kdf == nil {
+		version = capabilityLegacyVersion
+	}
+	token := append(append([]byte{version}, header...), wrapped...)
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// OpenCapability is used to open a capability token minted for the passphrase
+// of the manager. Expired capabilities are refused, and the clock of the
+// manager is used to enforce expiry when the capability is used. The KDF
+// recorded in the token is subject to the limits, and format policy of the manager
+func (e *EncryptManager) OpenCapability(token string) (*Capability, error) {
+	invalid := errors.New("invalid capability")
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) < 1+saltlen {
+		return nil, invalid
+	}
+	var (
+		kek       []byte
+		headerLen int
+	)
+	switch decoded[0] {
+	case capabilityLegacyVersion:
+		kek, err = e.cfbKey(nil, decoded[1:1+saltlen])
+		headerLen = saltlen
+	case capabilityVersion:
+		if !hasKDFHeader(decoded[1:]) {
+			return nil, invalid
+		}
+		kek, headerLen, err = e.readKDFKey(decoded[1:])
+	default:
+		return nil, fmt.Errorf("unsupported capability version %d", decoded[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+	body, err := UnwrapKeyWithPadding(kek, decoded[1+headerLen:])
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < 3 {
+		return nil, invalid
+	}
+	n := int(binary.BigEndian.Uint16(body[len(body)-2:]))
+	if n > len(body)-3 {
+		return nil, invalid
+	}
+	usage, err := parseKeyUsage(body[len(body)-2-n : len(body)-2])
+	if err != nil {
+		return nil, err
+	}
+	if usage.expired(e.now()) {
+		return nil, ErrKeyExpired
+	}
+	kind, scope := body[0], body[1:len(body)-2-n]
+	c := &Capability{NotAfter: usage.NotAfter}
+	switch kind {
+	case capabilityObject:
+		// protocol length || protocol || digest || key || nonce length || nonce
+		if len(scope) < 1 || len(scope) < 1+int(scope[0])+sha256.Size+keylen+1 {
+			return nil, invalid
+		}
+		protocol := Protocol(scope[1 : 1+scope[0]])
+		scope = scope[1+scope[0]:]
+		c.Protocol, c.Object = protocol, scope[:sha256.Size]
+		key, nonce := scope[sha256.Size:sha256.Size+keylen], scope[sha256.Size+keylen+1:]
+		if len(nonce) != int(scope[sha256.Size+keylen]) {
+			return nil, invalid
+		}
+		c.e = e.Clone()
+		c.e.protocol = protocol
+		c.e.gcmDecryptParams = &GCMDecryptParams{
+			CipherKey: hex.EncodeToString(key),
+			Nonce:     hex.EncodeToString(nonce),
+			usage:     usage,
+		}
+	case capabilityChunks:
+		if len(scope) < 8+keylen || (len(scope)-8)%keylen != 0 {
+			return nil, invalid
+		}
+		c.Start = binary.BigEndian.Uint64(scope)
+		c.chunks = &ChunkKeyRange{Start: c.Start}
+		for keys := scope[8:]; len(keys) > 0; keys = keys[keylen:] {
+			c.chunks.Keys = append(c.chunks.Keys, keys[:keylen])
+		}
+		c.End = c.Start + uint64(len(c.chunks.Keys))
+		c.e = e.Clone()
+	default:
+		return nil, invalid
+	}
+	return c, nil
+}
+
+// Decrypt is used to decrypt the object read from r, which must be the object
+// the capability was minted for
+func (c *Capability) Decrypt(r io.Reader) ([]byte, error) {
+	if c.Object == nil {
+		return nil, ErrCapabilityScope
+	}
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if digest := sha256.Sum256(data); subtle.ConstantTimeCompare(digest[:], c.Object) != 1 {
+		return nil, ErrCapabilityScope
+	}
+	return c.e.Decrypt(bytes.NewReader(data))
+}
+
+// OpenChunk is used to decrypt the chunk at index sealed using SealChunk,
+// provided the index is within the range of the capability
+func (c *Capability) OpenChunk(index uint64, sealed []byte, last bool) ([]byte, error) {
+	if c.chunks == nil || index < c.Start || index >= c.End {
+		return nil, ErrCapabilityScope
+	}
+	if !c.NotAfter.IsZero() && c.e.now().After(c.NotAfter) {
+		return nil, ErrKeyExpired
+	}
+	key, err := c.chunks.Key(index)
+	if err != nil {
+		return nil, err
+	}
+	return OpenChunk(key, index, sealed, last)
+}
@@ -0,0 +1,165 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func Test_EncryptManager_Capability(t *testing.T) {
+	data := []byte("hello world")
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, protocol := range []Protocol{GCM, GCMStream, XChaCha20Poly1305} {
+		t.Run(string(protocol), func(t *testing.T) {
+			e := NewEncryptManager("helloworld")
+			setProtocol(e, protocol)
+			encrypted, err := e.Encrypt(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			other, err := e.Clone().Encrypt(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := e.MintCapability(bytes.NewReader(encrypted), "support", now.Add(time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			support := NewEncryptManager("support").WithClock(ClockFunc(func() time.Time { return now }))
+			capability, err := support.OpenCapability(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if capability.Protocol != protocol || !capability.NotAfter.Equal(now.Add(time.Hour)) {
+				t.Fatalf("OpenCapability() = %+v", capability)
+			}
+			decrypted, err := capability.Decrypt(bytes.NewReader(encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+			// only the object the capability was minted for can be decrypted
+			if _, err := capability.Decrypt(bytes.NewReader(other)); err != ErrCapabilityScope {
+				t.Fatalf("Decrypt() err = %v, want %v", err, ErrCapabilityScope)
+			}
+			// the keys can't be exported
+			if _, err := capability.e.RetrieveWrappedGCMDecryptionParameters(); err != ErrKeyUsage {
+				t.Fatalf("RetrieveWrappedGCMDecryptionParameters() err = %v, want %v", err, ErrKeyUsage)
+			}
+			if _, err := capability.e.MintCapability(bytes.NewReader(encrypted), "other", time.Time{}); err != ErrKeyUsage {
+				t.Fatalf("MintCapability() err = %v, want %v", err, ErrKeyUsage)
+			}
+			// expiry is enforced when used, and opened
+			capability.e.WithClock(ClockFunc(func() time.Time { return now.Add(2 * time.Hour) }))
+			if _, err := capability.Decrypt(bytes.NewReader(encrypted)); err != ErrKeyExpired {
+				t.Fatalf("Decrypt() err = %v, want %v", err, ErrKeyExpired)
+			}
+			support.WithClock(ClockFunc(func() time.Time { return now.Add(2 * time.Hour) }))
+			if _, err := support.OpenCapability(token); err != ErrKeyExpired {
+				t.Fatalf("OpenCapability() err = %v, want %v", err, ErrKeyExpired)
+			}
+			if _, err := NewEncryptManager("wrong").OpenCapability(token); err == nil {
+				t.Fatal("expected error opening capability with the wrong passphrase")
+			}
+		})
+	}
+
+	if _, err := NewEncryptManager("helloworld").MintCapability(bytes.NewReader(data), "support", time.Time{}); err == nil {
+		t.Fatal("expected error minting capability for AES256-CFB")
+	}
+}
+
+func Test_EncryptManager_ChunkCapability(t *testing.T) {
+	keys, err := NewChunkKeys(bytes.Repeat([]byte{1}, keylen), []byte("object"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sealed [][]byte
+	for i := uint64(0); i < 4; i++ {
+		key, err := keys.Key(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunk, err := SealChunk(key, i, []byte{byte(i)}, i == 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sealed = append(sealed, chunk)
+	}
+	token, err := NewEncryptManager("helloworld").MintChunkCapability(keys, 1, 3, "worker", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	capability, err := NewEncryptManager("worker").OpenCapability(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if capability.Start != 1 || capability.End != 3 {
+		t.Fatalf("OpenCapability() = %+v", capability)
+	}
+	for i := uint64(0); i < 4; i++ {
+		chunk, err := capability.OpenChunk(i, sealed[i], i == 3)
+		if inRange := i >= 1 && i < 3; inRange != (err == nil) {
+			t.Fatalf("OpenChunk(%d) err = %v", i, err)
+		}
+		if err == nil && chunk[0] != byte(i) {
+			t.Fatalf("OpenChunk(%d) = %v", i, chunk)
+		}
+	}
+	if _, err := capability.Decrypt(bytes.NewReader(sealed[1])); err != ErrCapabilityScope {
+		t.Fatalf("Decrypt() err = %v, want %v", err, ErrCapabilityScope)
+	}
+	if _, err := NewEncryptManager("helloworld").MintChunkCapability(keys, 3, 3, "worker", time.Time{}); err == nil {
+		t.Fatal("expected error for empty chunk range")
+	}
+}
+
+func Test_EncryptManager_Capability_KDF(t *testing.T) {
+	e := NewEncryptManager("helloworld").WithGCM(nil).WithPBKDF2Iterations(1000)
+	encrypted, err := e.Encrypt(bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := e.MintCapability(bytes.NewReader(encrypted), "support", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded[0] != capabilityVersion || !hasKDFHeader(decoded[1:]) {
+		t.Fatal("capability does not record the kdf")
+	}
+	// without a kdf the legacy function is used
+	e.kdf = nil
+	legacy, err := e.MintCapability(bytes.NewReader(encrypted), "support", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{token, legacy} {
+		capability, err := NewEncryptManager("support").OpenCapability(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decrypted, err := capability.Decrypt(bytes.NewReader(encrypted)); err != nil || string(decrypted) != "hello world" {
+			t.Fatalf("Decrypt() = %s, %v", decrypted, err)
+		}
+	}
+	// the recorded kdf is subject to the format policy, and limits of the manager
+	policy, err := NewEncryptManager("support").WithFormatPolicy(FormatPolicy{KDF: &KDFPolicy{MinPBKDF2Iterations: 10000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{token, legacy} {
+		if _, err := policy.OpenCapability(token); err != ErrDowngrade {
+			t.Fatalf("OpenCapability() err = %v, want %v", err, ErrDowngrade)
+		}
+	}
+	if _, err := NewEncryptManager("support").WithKDFLimits(KDFLimits{MaxPBKDF2Iterations: 100}).OpenCapability(token); err == nil {
+		t.Fatal("expected error opening capability exceeding kdf limits")
+	}
+}
@@ -0,0 +1,82 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// WithArchive is used to setup, and return EncryptManager for use with the
+// long-term archive mode, which encrypts data using AES256-GCM and then
+// XChaCha20-Poly1305 under independent keys, so that data remains protected
+// should either cipher be broken. The params are expected to be unencrypted,
+// and in hex encoded string format
+func (e *EncryptManager) WithArchive(params *GCMDecryptParams) *EncryptManager {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.protocol = Archive
+	e.gcmDecryptParams = params
+	return e
+}
+
+// cascadeAEAD is a cipher.AEAD sealing data with AES256-GCM, and sealing the
+// result with XChaCha20-Poly1305, opening requires both to authenticate
+type cascadeAEAD struct {
+	inner cipher.AEAD
+	outer cipher.AEAD
+}
+
+// newCascadeAEAD returns the cascade of AES256-GCM and XChaCha20-Poly1305, each
+// using their own key derived from key so recovering one reveals nothing of the other
+func newCascadeAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != keylen {
+		return nil, errors.New("invalid archive key length")
+	}
+	gcmKey, chachaKey := make([]byte, keylen), make([]byte, keylen)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte("temporal-archive:aes256-gcm")), gcmKey); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte("temporal-archive:xchacha20-poly1305")), chachaKey); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(gcmKey)
+	if err != nil {
+		return nil, err
+	}
+	inner, err := cipher.NewGCMWithNonceSize(block, chacha20poly1305.NonceSizeX)
+	if err != nil {
+		return nil, err
+	}
+	outer, err := chacha20poly1305.NewX(chachaKey)
+	if err != nil {
+		return nil, err
+	}
+	return &cascadeAEAD{inner: inner, outer: outer}, nil
+}
+
+func (c *cascadeAEAD) NonceSize() int { return c.outer.NonceSize() }
+
+func (c *cascadeAEAD) Overhead() int { return c.inner.Overhead() + c.outer.Overhead() }
+
+// Seal encrypts plaintext using both ciphers, the nonce may be shared as the keys differ
+func (c *cascadeAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	return c.outer.Seal(dst, nonce, c.inner.Seal(nil, nonce, plaintext, additionalData), additionalData)
+}
+
+// Open decrypts ciphertext using both ciphers, refusing nonces of the wrong
+// length which would otherwise panic
+func (c *cascadeAEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != c.NonceSize() {
+		return nil, errors.New("invalid nonce length")
+	}
+	sealed, err := c.outer.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	return c.inner.Open(dst, nonce, sealed, additionalData)
+}
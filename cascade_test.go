@@ -0,0 +1,97 @@
+package crypto
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func Test_EncryptManager_Archive(t *testing.T) {
+	original, err := ioutil.ReadFile("README.md")
+	if err != nil {
+		t.Fatalf("setup failed: %s", err)
+	}
+	e := NewEncryptManager("helloworld").WithArchive(nil)
+	encrypted, err := e.Encrypt(bytes.NewReader(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encrypted[0] != aeadArchive {
+		t.Fatalf("cipher = %d, want %d", encrypted[0], aeadArchive)
+	}
+	// both ciphers contribute an authentication tag
+	if want := 1 + len(original) + 32; len(encrypted) != want {
+		t.Fatalf("encrypted length = %d, want %d", len(encrypted), want)
+	}
+	d := NewEncryptManager("helloworld").WithArchive(e.gcmDecryptParams)
+	decrypted, err := d.Decrypt(bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, original) {
+		t.Fatal("decrypted data does not match original")
+	}
+	// claiming a single cipher is refused
+	downgraded := append([]byte{aeadXChaCha20Poly1305}, encrypted[1:]...)
+	if _, err := d.Decrypt(bytes.NewReader(downgraded)); err != ErrDowngrade {
+		t.Fatalf("err = %v, want %v", err, ErrDowngrade)
+	}
+	encrypted[len(encrypted)-1] ^= 0xff
+	if _, err := d.Decrypt(bytes.NewReader(encrypted)); err == nil {
+		t.Fatal("expected error decrypting tampered data")
+	}
+	malformed := &GCMDecryptParams{CipherKey: e.gcmDecryptParams.CipherKey, Nonce: "00"}
+	if _, err := NewEncryptManager("helloworld").WithArchive(malformed).Decrypt(bytes.NewReader(encrypted)); err == nil {
+		t.Fatal("expected error decrypting with a malformed nonce")
+	}
+	if _, err := NewEncryptManager("helloworld").WithArchive(nil).Encrypt(nil); err == nil {
+		t.Fatal("expected error encrypting nil reader")
+	}
+}
+
+func Test_EncryptManager_Archive_Stream(t *testing.T) {
+	original := make([]byte, 3*segmentSize+17)
+	e := NewEncryptManager("helloworld").WithArchive(nil)
+	var encrypted bytes.Buffer
+	if err := e.EncryptStream(&encrypted, bytes.NewReader(original)); err != nil {
+		t.Fatal(err)
+	}
+	var decrypted bytes.Buffer
+	d := NewEncryptManager("helloworld").WithArchive(e.gcmDecryptParams)
+	if err := d.DecryptStream(&decrypted, bytes.NewReader(encrypted.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted.Bytes(), original) {
+		t.Fatal("decrypted data does not match original")
+	}
+	downgraded := append([]byte{aeadAES256GCM}, encrypted.Bytes()[1:]...)
+	if err := d.DecryptStream(ioutil.Discard, bytes.NewReader(downgraded)); err == nil {
+		t.Fatal("expected error decrypting downgraded stream")
+	}
+}
+
+func Test_cascadeAEAD(t *testing.T) {
+	key := make([]byte, keylen)
+	aead, err := newCascadeAEAD(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	sealed := aead.Seal(nil, nonce, []byte("hello world"), nil)
+	// the layers use independent keys, so neither opens using the other's key
+	for _, id := range []byte{aeadAES256GCM, aeadXChaCha20Poly1305} {
+		single, err := newAEAD(id, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := single.Open(nil, nonce, sealed, nil); err == nil {
+			t.Fatalf("cipher %d opened the cascade using the archive key", id)
+		}
+	}
+	if _, err := aead.Open(nil, nonce[:12], sealed, nil); err == nil {
+		t.Fatal("expected error opening using a short nonce")
+	}
+	if _, err := newCascadeAEAD(key[:16]); err == nil {
+		t.Fatal("expected error using short key")
+	}
+}
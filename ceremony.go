@@ -0,0 +1,171 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/RTradeLtd/crypto/v2/shamir"
+)
+
+// ErrInvalidShares is returned when key shares do not reconstruct the key they were split from
+var ErrInvalidShares = errors.New("key shares do not reconstruct the master key")
+
+// KeyShare is the share of a master key held by one custodian. Any Threshold
+// shares of the same key reconstruct it, while fewer reveal nothing about it
+type KeyShare struct {
+	Custodian string `json:"custodian"`
+	// Index is the point at which the share was evaluated, unique per custodian
+	Index     byte `json:"index"`
+	Threshold byte `json:"threshold"`
+	// KeyID commits to the master key, so a reconstructed key can be verified
+	KeyID []byte `json:"key_id"`
+	Data  []byte `json:"data"`
+}
+
+// KeyCeremony is used to generate a master key under dual control, split
+// between custodians using Shamir's secret sharing, so no single custodian
+// can recover it
+type KeyCeremony struct {
+	threshold  int
+	custodians []string
+}
+
+// NewKeyCeremony is used to prepare a ceremony splitting a master key between
+// the named custodians, of which threshold are required to reconstruct it
+func NewKeyCeremony(threshold int, custodians ...string) (*KeyCeremony, error) {
+	if threshold < 2 || threshold > len(custodians) || len(custodians) > 255 {
+		return nil, errors.New("threshold must be at least 2, and at most the number of custodians, of which there may be 255")
+	}
+	seen := make(map[string]bool)
+	for _, custodian := range custodians {
+		if custodian == "" || seen[custodian] {
+			return nil, errors.New("custodians must be named, and unique")
+		}
+		seen[custodian] = true
+	}
+	return &KeyCeremony{threshold: threshold, custodians: custodians}, nil
+}
+
+// Generate generates a 32 byte master key directly into a share for every
+// custodian. The key is only held in memory while it is split, and is never
+// returned. Before returning, every share is verified to reconstruct the key
+// along with others, so nothing is encrypted under a key which can't be
+// recovered. Use CombineKeyShares to reconstruct the key
+func (c *KeyCeremony) Generate() ([]KeyShare, error) {
+	key := make([]byte, keylen)
+	defer zero(key)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	id := keyShareID(key)
+	parts, err := shamir.Split(key, len(c.custodians), c.threshold)
+	if err != nil {
+		return nil, err
+	}
+	shares := make([]KeyShare, len(c.custodians))
+	for i, custodian := range c.custodians {
+		shares[i] = KeyShare{
+			Custodian: custodian,
+			Index:     parts[i].Index,
+			Threshold: byte(c.threshold),
+			KeyID:     id,
+			Data:      parts[i].Data,
+		}
+	}
+	// every share contributes to at least one reconstruction
+	for i := range shares {
+		subset := make([]KeyShare, c.threshold)
+		for j := range subset {
+			subset[j] = shares[(i+j)%len(shares)]
+		}
+		combined, err := CombineKeyShares(subset...)
+		if err != nil {
+			return nil, err
+		}
+		same := bytes.Equal(combined, key)
+		zero(combined)
+		if !same {
+			return nil, ErrInvalidShares
+		}
+	}
+	return shares, nil
+}
+
+// CombineKeyShares is used to reconstruct a master key generated by a
+// KeyCeremony from at least the threshold number of its shares. The key is
+// verified against the commitment of the shares, returning ErrInvalidShares
+// for mismatched, or corrupted shares. The key can be used with
+// NewDerivedKeyProvider to derive the keys used for encryption
+func CombineKeyShares(shares ...KeyShare) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no key shares provided")
+	}
+	first := shares[0]
+	if len(shares) < int(first.Threshold) {
+		return nil, fmt.Errorf("%d key shares are required, %d provided", first.Threshold, len(shares))
+	}
+	seen := make(map[byte]bool)
+	for _, share := range shares {
+		if share.Index == 0 || seen[share.Index] || share.Threshold != first.Threshold ||
+			len(share.Data) != len(first.Data) || !bytes.Equal(share.KeyID, first.KeyID) {
+			return nil, ErrInvalidShares
+		}
+		seen[share.Index] = true
+	}
+	parts := make([]shamir.Share, len(shares))
+	for i, share := range shares {
+		parts[i] = shamir.Share{Threshold: share.Threshold, Index: share.Index, Data: share.Data}
+	}
+	key, err := shamir.Combine(parts...)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(keyShareID(key), first.KeyID) {
+		zero(key)
+		return nil, ErrInvalidShares
+	}
+	return key, nil
+}
+
+// Seal is used to encrypt the share for its custodian using passphrase, so it
+// can be handed over, or stored by the custodian
+func (s KeyShare) Seal(passphrase string) ([]byte, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	defer zero(data)
+	return NewEncryptManager(passphrase).WithKDF(DefaultKDFConfig(Argon2id)).Encrypt(bytes.NewReader(data))
+}
+
+// OpenKeyShare is used to decrypt a share sealed using KeyShare.Seal
+func OpenKeyShare(data []byte, passphrase string) (KeyShare, error) {
+	var share KeyShare
+	decrypted, err := NewEncryptManager(passphrase).Decrypt(bytes.NewReader(data))
+	if err != nil {
+		return share, err
+	}
+	defer zero(decrypted)
+	err = json.Unmarshal(decrypted, &share)
+	return share, err
+}
+
+// keyShareID returns the commitment to key recorded in its shares
+func keyShareID(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("temporal-key-ceremony"))
+	return mac.Sum(nil)[:16]
+}
+
+// zero overwrites key material held in b
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
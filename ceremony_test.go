@@ -0,0 +1,100 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_KeyCeremony(t *testing.T) {
+	ceremony, err := NewKeyCeremony(3, "alice", "bob", "carol", "dave", "erin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares, err := ceremony.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shares) != 5 || shares[1].Custodian != "bob" {
+		t.Fatalf("Generate() = %+v", shares)
+	}
+	key, err := CombineKeyShares(shares[0], shares[2], shares[4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// any threshold shares reconstruct the same key
+	for _, subset := range [][]KeyShare{shares[1:4], {shares[4], shares[0], shares[3]}, shares} {
+		other, err := CombineKeyShares(subset...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(other, key) {
+			t.Fatal("shares reconstruct different keys")
+		}
+	}
+	if _, err := NewDerivedKeyProvider(key); err != nil {
+		t.Fatal(err)
+	}
+
+	corrupted := shares[1]
+	corrupted.Data = append([]byte{}, corrupted.Data...)
+	corrupted.Data[0] ^= 1
+	others, err := NewKeyCeremony(2, "alice", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, err := others.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		shares []KeyShare
+	}{
+		{"too-few", shares[:2]},
+		{"none", nil},
+		{"duplicate", []KeyShare{shares[0], shares[0], shares[1]}},
+		{"corrupted", []KeyShare{shares[0], corrupted, shares[2]}},
+		{"foreign", []KeyShare{shares[0], shares[1], foreign[1]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := CombineKeyShares(tt.shares...); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+
+	for _, custodians := range [][]string{{"alice"}, {"alice", "alice"}, {"alice", ""}} {
+		if _, err := NewKeyCeremony(2, custodians...); err == nil {
+			t.Fatalf("NewKeyCeremony(%v) expected error", custodians)
+		}
+	}
+}
+
+func Test_KeyShare_Seal(t *testing.T) {
+	ceremony, err := NewKeyCeremony(2, "alice", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares, err := ceremony.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := shares[0].Seal("alice-passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, shares[0].Data) {
+		t.Fatal("sealed share holds the share data")
+	}
+	opened, err := OpenKeyShare(sealed, "alice-passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CombineKeyShares(opened, shares[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenKeyShare(sealed, "bob-passphrase"); err == nil {
+		t.Fatal("expected error opening share with the wrong passphrase")
+	}
+}
@@ -0,0 +1,76 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+var (
+	// ErrCFBAuthentication is returned when AES256-CFB content fails authentication
+	ErrCFBAuthentication = errors.New("AES256-CFB content failed authentication")
+	// ErrUnauthenticatedCFB is returned when decrypting content in the
+	// unauthenticated AES256-CFB format without WithLegacyCFB
+	ErrUnauthenticatedCFB = errors.New("AES256-CFB content is not authenticated")
+)
+
+// cfbMACMagic prefixes authenticated AES256-CFB output
+var cfbMACMagic = []byte("TCFB\x01")
+
+// cfbMACSize is the size of the HMAC-SHA-256 appended to authenticated AES256-CFB output
+const cfbMACSize = sha256.Size
+
+// WithLegacyCFB is used to decrypt content in the unauthenticated AES256-CFB
+// format used by Temporal, and earlier versions of this package. AES256-CFB
+// output is authenticated using HMAC-SHA-256 in the encrypt-then-MAC
+// construction, and content which is not is otherwise rejected, as its
+// plaintext could have been modified undetected
+func (e *EncryptManager) WithLegacyCFB() *EncryptManager {
+	e.legacyCFB = true
+	return e
+}
+
+// cfbKeys derives separate encryption, and MAC keys from the key derived from
+// the passphrase for authenticated AES256-CFB
+func cfbKeys(key []byte) ([]byte, []byte, error) {
+	encKey, macKey := make([]byte, keylen), make([]byte, keylen)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte("temporal-cfb-encrypt")), encKey); err != nil {
+		return nil, nil, err
+	}
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte("temporal-cfb-mac")), macKey); err != nil {
+		return nil, nil, err
+	}
+	return encKey, macKey, nil
+}
+
+// verifyCFBMAC checks the mac over the authenticated AES256-CFB output before it
+func verifyCFBMAC(macKey, data, mac []byte) error {
+	h := hmac.New(sha256.New, macKey)
+	h.Write(data)
+	if !hmac.Equal(h.Sum(nil), mac) {
+		return ErrCFBAuthentication
+	}
+	return nil
+}
+
+// cfbOutput assembles AES256-CFB output from its parts, in the authenticated
+// format of magic || [kdf header] || iv || ciphertext || salt || mac when mac
+// is set, otherwise in the legacy format of [kdf header] || iv || ciphertext || salt
+func cfbOutput(kdf *KDFConfig, iv, ciphertext, salt, mac []byte) ([]byte, error) {
+	var out []byte
+	if mac != nil {
+		out = append(out, cfbMACMagic...)
+	}
+	if kdf != nil {
+		header, err := kdf.header()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, header...)
+	}
+	out = append(append(append(out, iv...), ciphertext...), salt...)
+	return append(out, mac...), nil
+}
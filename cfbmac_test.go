@@ -0,0 +1,148 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"testing"
+)
+
+// legacyCFB encrypts data in the unauthenticated AES256-CFB format
+func legacyCFB(t *testing.T, e *EncryptManager, data []byte) []byte {
+	salt, iv := make([]byte, saltlen), make([]byte, aes.BlockSize)
+	rand.Read(salt)
+	rand.Read(iv)
+	key, err := e.cfbKey(nil, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := make([]byte, len(data))
+	cipher.NewCFBEncrypter(block, iv).XORKeyStream(ciphertext, data)
+	out, err := cfbOutput(nil, iv, ciphertext, salt, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func Test_EncryptManager_CFB_Authentication(t *testing.T) {
+	data := []byte("hello world")
+	e := NewEncryptManager("helloworld")
+	encrypted, err := e.Encrypt(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var streamed bytes.Buffer
+	if err := e.EncryptStream(&streamed, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	// every byte after the magic is authenticated
+	for _, out := range [][]byte{encrypted, streamed.Bytes()} {
+		for i := len(cfbMACMagic); i < len(out); i++ {
+			tampered := append([]byte{}, out...)
+			tampered[i] ^= 1
+			if _, err := e.Decrypt(bytes.NewReader(tampered)); err != ErrCFBAuthentication {
+				t.Fatalf("Decrypt() tampered byte %d, err = %v", i, err)
+			}
+			var dst bytes.Buffer
+			if err := e.DecryptStream(&dst, bytes.NewReader(tampered)); err != ErrCFBAuthentication {
+				t.Fatalf("DecryptStream() tampered byte %d, err = %v", i, err)
+			}
+			if dst.Len() != 0 {
+				t.Fatal("plaintext written before authentication")
+			}
+		}
+	}
+	if _, err := NewEncryptManager("wrong").Decrypt(bytes.NewReader(encrypted)); err != ErrCFBAuthentication {
+		t.Fatalf("Decrypt() wrong passphrase, err = %v", err)
+	}
+}
+
+func Test_EncryptManager_WithLegacyCFB(t *testing.T) {
+	data := []byte("hello world")
+	legacy := legacyCFB(t, NewEncryptManager("helloworld"), data)
+	if _, err := NewEncryptManager("helloworld").Decrypt(bytes.NewReader(legacy)); err != ErrUnauthenticatedCFB {
+		t.Fatalf("Decrypt() err = %v, want %v", err, ErrUnauthenticatedCFB)
+	}
+	var dst bytes.Buffer
+	if err := NewEncryptManager("helloworld").DecryptStream(&dst, bytes.NewReader(legacy)); err != ErrUnauthenticatedCFB {
+		t.Fatalf("DecryptStream() err = %v, want %v", err, ErrUnauthenticatedCFB)
+	}
+
+	e := NewEncryptManager("helloworld").WithLegacyCFB()
+	decrypted, err := e.Decrypt(bytes.NewReader(legacy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Fatal("decrypted data does not match original")
+	}
+	if err := e.Clone().DecryptStream(&dst, bytes.NewReader(legacy)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.Bytes(), data) {
+		t.Fatal("stream decrypted data does not match original")
+	}
+	// authenticated content is still verified
+	encrypted, err := e.Encrypt(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted[len(encrypted)-1] ^= 1
+	if _, err := e.Decrypt(bytes.NewReader(encrypted)); err != ErrCFBAuthentication {
+		t.Fatalf("Decrypt() err = %v, want %v", err, ErrCFBAuthentication)
+	}
+}
+
+func Test_EncryptManager_CFB_LegacyLayouts(t *testing.T) {
+	data := []byte("hello world")
+	e := NewEncryptManager("helloworld")
+	res, err := e.EncryptWithResult(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// version 1 headers do not record a mac
+	v2, err := addHeader(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := legacyCFB(t, e, data)
+	v1, err := (&header{
+		protocol: CFB,
+		salt:     legacy[len(legacy)-saltlen:],
+		nonce:    legacy[:aes.BlockSize],
+		body:     legacy[aes.BlockSize : len(legacy)-saltlen],
+	}).marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1[len(headerMagic)] = 1
+	if decrypted, err := e.Decrypt(bytes.NewReader(v2)); err != nil || !bytes.Equal(decrypted, data) {
+		t.Fatalf("Decrypt() version 2 header, err = %v", err)
+	}
+	if _, err := e.Decrypt(bytes.NewReader(v1)); err != ErrUnauthenticatedCFB {
+		t.Fatalf("Decrypt() version 1 header, err = %v", err)
+	}
+	if decrypted, err := e.Clone().WithLegacyCFB().Decrypt(bytes.NewReader(v1)); err != nil || !bytes.Equal(decrypted, data) {
+		t.Fatalf("Decrypt() legacy version 1 header, err = %v", err)
+	}
+
+	// envelopes without a mac were produced by earlier versions
+	env, payload, err := e.EncryptSplit(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(env.MAC) != cfbMACSize {
+		t.Fatal("envelope does not record the mac")
+	}
+	env.MAC = nil
+	if _, err := e.DecryptSplit(env, bytes.NewReader(payload)); err != ErrUnauthenticatedCFB {
+		t.Fatalf("DecryptSplit() without mac, err = %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package crypto
+
+import (
+	"crypto/cipher"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// WithChaCha20Poly1305 is used to setup, and return EncryptManager for use with ChaCha20-Poly1305.
+// The params are expected to be unencrypted, and in hex encoded string format
+func (e *EncryptManager) WithChaCha20Poly1305(params *GCMDecryptParams) *EncryptManager {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.protocol = ChaCha20Poly1305
+	e.gcmDecryptParams = params
+	return e
+}
+
+// WithXChaCha20Poly1305 is used to setup, and return EncryptManager for use with XChaCha20-Poly1305.
+// The params are expected to be unencrypted, and in hex encoded string format
+func (e *EncryptManager) WithXChaCha20Poly1305(params *GCMDecryptParams) *EncryptManager {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.protocol = XChaCha20Poly1305
+	e.gcmDecryptParams = params
+	return e
+}
+
+// newChaCha returns the ChaCha20-Poly1305 variant used by protocol
+func newChaCha(protocol Protocol, key []byte) (cipher.AEAD, error) {
+	if protocol == XChaCha20Poly1305 {
+		return chacha20poly1305.NewX(key)
+	}
+	return chacha20poly1305.New(key)
+}
+
+// encryptChaCha encrypts given io.Reader using ChaCha20-Poly1305, or XChaCha20-Poly1305
+// the resultant encrypted bytes, nonce, and cipher key are returned
+func (e *EncryptManager) encryptChaCha(protocol Protocol, r io.Reader) ([]byte, []byte, []byte, error) {
+	if r == nil {
+		return nil, nil, nil, errors.New("invalid content provided")
+	}
+	cipherKeyBytes := make([]byte, keylen)
+	if _, err := io.ReadFull(e.randomness(), cipherKeyBytes); err != nil {
+		return nil, nil, nil, err
+	}
+	aead, err := newChaCha(protocol, cipherKeyBytes)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(e.randomness(), nonce); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := e.recordNonce(cipherKeyBytes, nonce); err != nil {
+		return nil, nil, nil, err
+	}
+	dataToEncrypt, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return aead.Seal(nil, nonce, dataToEncrypt, e.additionalData()), nonce, cipherKeyBytes, nil
+}
+
+// decryptChaCha is used to decrypt the given io.Reader encrypted using
+// ChaCha20-Poly1305, or XChaCha20-Poly1305
+func (e *EncryptManager) decryptChaCha(protocol Protocol, r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	decodedKey, decodedNonce, err := e.decryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newChaCha(protocol, decodedKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(decodedNonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce size")
+	}
+	encryptedData, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return openAEAD(aead, nil, decodedNonce, encryptedData, e.additionalData(), e.objectBound)
+}
@@ -0,0 +1,86 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"testing"
+)
+
+func Test_EncryptManager_ChaCha20Poly1305(t *testing.T) {
+	data, err := ioutil.ReadFile("README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		protocol  Protocol
+		nonceSize int
+		setup     func(e *EncryptManager, params *GCMDecryptParams) *EncryptManager
+	}{
+		{ChaCha20Poly1305, 12, (*EncryptManager).WithChaCha20Poly1305},
+		{XChaCha20Poly1305, 24, (*EncryptManager).WithXChaCha20Poly1305},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.protocol), func(t *testing.T) {
+			e := tt.setup(NewEncryptManager("helloworld"), nil)
+			encrypted, err := e.Encrypt(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			params := e.getGCMDecryptParams()
+			nonce, err := hex.DecodeString(params.Nonce)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(nonce) != tt.nonceSize {
+				t.Fatalf("nonce size = %d, want %d", len(nonce), tt.nonceSize)
+			}
+			decrypted, err := tt.setup(NewEncryptManager("helloworld"), params).Decrypt(bytes.NewReader(encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+
+			// parameters survive export, and restore
+			state, err := e.Export()
+			if err != nil {
+				t.Fatal(err)
+			}
+			restored := NewEncryptManager("helloworld")
+			if err := restored.Restore(state); err != nil {
+				t.Fatal(err)
+			}
+			if decrypted, err = restored.Decrypt(bytes.NewReader(encrypted)); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("restored decryption does not match original")
+			}
+
+			// the other variant must not be able to decrypt
+			other := ChaCha20Poly1305
+			if tt.protocol == ChaCha20Poly1305 {
+				other = XChaCha20Poly1305
+			}
+			mismatched := NewEncryptManager("helloworld")
+			mismatched.protocol = other
+			mismatched.gcmDecryptParams = params
+			if _, err := mismatched.Decrypt(bytes.NewReader(encrypted)); err == nil {
+				t.Fatal("expected error decrypting using the other variant")
+			}
+
+			var streamed, unstreamed bytes.Buffer
+			if err := e.EncryptStream(&streamed, bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+			if err := e.DecryptStream(&unstreamed, &streamed); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(unstreamed.Bytes(), data) {
+				t.Fatal("stream decryption does not match original")
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// ChecksumAlgorithm identifies the hash used to compute payload checksums
+type ChecksumAlgorithm string
+
+var (
+	// SHA256 computes checksums using SHA-256
+	SHA256 ChecksumAlgorithm = "SHA-256"
+	// SHA512 computes checksums using SHA-512
+	SHA512 ChecksumAlgorithm = "SHA-512"
+	// BLAKE2b256 computes checksums using BLAKE2b with a 256 bit digest
+	BLAKE2b256 ChecksumAlgorithm = "BLAKE2b-256"
+
+	// ErrChecksumMismatch is returned when a payload does not match its recorded checksum
+	ErrChecksumMismatch = errors.New("payload checksum mismatch")
+)
+
+var (
+	checksumMux        sync.RWMutex
+	checksumAlgorithms = map[ChecksumAlgorithm]func() hash.Hash{
+		SHA256: sha256.New,
+		SHA512: sha512.New,
+		BLAKE2b256: func() hash.Hash {
+			// only fails for invalid key lengths
+			h, _ := blake2b.New256(nil)
+			return h
+		},
+	}
+)
+
+// RegisterChecksumAlgorithm is used to add support for additional checksum
+// algorithms, such as BLAKE3, to match downstream verification systems. The
+// algorithm must be registered wherever envelopes using it are decrypted
+func RegisterChecksumAlgorithm(alg ChecksumAlgorithm, h func() hash.Hash) {
+	checksumMux.Lock()
+	checksumAlgorithms[alg] = h
+	checksumMux.Unlock()
+}
+
+// Checksum is the digest of a payload, recorded with the algorithm used
+type Checksum struct {
+	Algorithm ChecksumAlgorithm `json:"algorithm"`
+	Digest    []byte            `json:"digest"`
+}
+
+// newChecksumHash returns a new hash computing checksums using alg
+func newChecksumHash(alg ChecksumAlgorithm) (hash.Hash, error) {
+	checksumMux.RLock()
+	newHash, ok := checksumAlgorithms[alg]
+	checksumMux.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported checksum algorithm %s", alg)
+	}
+	return newHash(), nil
+}
+
+// ComputeChecksum is used to compute the checksum of data using alg
+func ComputeChecksum(alg ChecksumAlgorithm, data []byte) (*Checksum, error) {
+	h, err := newChecksumHash(alg)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(data)
+	return &Checksum{Algorithm: alg, Digest: h.Sum(nil)}, nil
+}
+
+// Verify is used to check that data matches the checksum
+func (c *Checksum) Verify(data []byte) error {
+	computed, err := ComputeChecksum(c.Algorithm, data)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(computed.Digest, c.Digest) != 1 {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// WithChecksum is used to record the checksum of encrypted payloads in the
+// envelopes produced by EncryptSplit, using the given algorithm. Recorded
+// checksums are always verified by DecryptSplit
+func (e *EncryptManager) WithChecksum(alg ChecksumAlgorithm) *EncryptManager {
+	e.checksum = alg
+	return e
+}
+
+// EncryptStreamWithChecksums is used to encrypt src as EncryptStream does,
+// computing the checksums of both the plaintext read from src, and the
+// ciphertext written to dst in the same pass, so large files don't need to be
+// read again to record them
+func (e *EncryptManager) EncryptStreamWithChecksums(dst io.Writer, src io.Reader, alg ChecksumAlgorithm) (*Checksum, *Checksum, error) {
+	if dst == nil || src == nil {
+		return nil, nil, errors.New("invalid content provided")
+	}
+	plaintext, err := newChecksumHash(alg)
+	if err != nil {
+		return nil, nil, err
+	}
+	ciphertext, err := newChecksumHash(alg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := e.EncryptStream(io.MultiWriter(dst, ciphertext), io.TeeReader(src, plaintext)); err != nil {
+		return nil, nil, err
+	}
+	return &Checksum{Algorithm: alg, Digest: plaintext.Sum(nil)},
+		&Checksum{Algorithm: alg, Digest: ciphertext.Sum(nil)}, nil
+}
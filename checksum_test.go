@@ -0,0 +1,94 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"testing"
+)
+
+func Test_ComputeChecksum(t *testing.T) {
+	tests := []struct {
+		alg     ChecksumAlgorithm
+		want    string
+		wantErr bool
+	}{
+		{SHA256, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", false},
+		{SHA512, "ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f", false},
+		{BLAKE2b256, "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319", false},
+		{"BLAKE3", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.alg), func(t *testing.T) {
+			sum, err := ComputeChecksum(tt.alg, []byte("abc"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ComputeChecksum error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := hex.EncodeToString(sum.Digest); got != tt.want {
+				t.Fatalf("digest = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_EncryptManager_Checksum(t *testing.T) {
+	RegisterChecksumAlgorithm("SHA-224", sha256.New224)
+	for _, alg := range []ChecksumAlgorithm{SHA256, SHA512, BLAKE2b256, "SHA-224"} {
+		t.Run(string(alg), func(t *testing.T) {
+			e := NewEncryptManager("helloworld").WithGCM(nil).WithChecksum(alg)
+			env, payload, err := e.EncryptSplit(bytes.NewReader([]byte("hello world")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if env.Checksum == nil || env.Checksum.Algorithm != alg {
+				t.Fatalf("envelope checksum = %+v", env.Checksum)
+			}
+			if _, err := e.DecryptSplit(env, bytes.NewReader(payload)); err != nil {
+				t.Fatal(err)
+			}
+			payload[0] ^= 1
+			if _, err := e.DecryptSplit(env, bytes.NewReader(payload)); err != ErrChecksumMismatch {
+				t.Fatalf("got error %v, want %v", err, ErrChecksumMismatch)
+			}
+		})
+	}
+	if _, _, err := NewEncryptManager("helloworld").WithChecksum("BLAKE3").EncryptSplit(bytes.NewReader(nil)); err == nil {
+		t.Fatal("expected error using unregistered checksum algorithm")
+	}
+}
+
+func Test_EncryptManager_EncryptStreamWithChecksums(t *testing.T) {
+	data := bytes.Repeat([]byte("hello world"), 20000)
+	for _, e := range []*EncryptManager{
+		NewEncryptManager("helloworld"),
+		NewEncryptManager("helloworld").WithGCMStream(nil),
+	} {
+		t.Run(string(e.getProtocol()), func(t *testing.T) {
+			var buf bytes.Buffer
+			plaintext, ciphertext, err := e.EncryptStreamWithChecksums(&buf, bytes.NewReader(data), BLAKE2b256)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := plaintext.Verify(data); err != nil {
+				t.Fatal(err)
+			}
+			if err := ciphertext.Verify(buf.Bytes()); err != nil {
+				t.Fatal(err)
+			}
+			var decrypted bytes.Buffer
+			if err := e.DecryptStream(&decrypted, bytes.NewReader(buf.Bytes())); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted.Bytes(), data) {
+				t.Fatal("decrypted data does not match original")
+			}
+		})
+	}
+	if _, _, err := NewEncryptManager("helloworld").EncryptStreamWithChecksums(ioutil.Discard, bytes.NewReader(data), "MD5"); err == nil {
+		t.Fatal("expected error for unsupported algorithm")
+	}
+}
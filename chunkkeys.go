@@ -0,0 +1,199 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// maxChunkKeyRange is the largest number of keys in a ChunkKeyRange
+const maxChunkKeyRange = 1 << 16
+
+// ChunkKeys derives an independent data key for every chunk of an object from
+// a single master key, and the ID of the object. Workers can be handed a
+// ChunkKeyRange holding only the keys for the chunks they process, allowing
+// disjoint chunk ranges to be decrypted in parallel across nodes without
+// sharing the master key. Streams encrypted using WithSegmentKeys seal their
+// segments using chunk keys, see EncryptManager.SegmentKeys
+type ChunkKeys struct {
+	master   []byte
+	objectID []byte
+}
+
+// ChunkKeyRange holds the derived keys for the chunks [Start, Start+len(Keys))
+type ChunkKeyRange struct {
+	// ObjectID is the ID of the object the keys were derived for, being the
+	// nonce prefix of segmented streams
+	ObjectID []byte   `json:"object_id"`
+	Start    uint64   `json:"start"`
+	Keys     [][]byte `json:"keys"`
+}
+
+// NewChunkKeys is used to derive chunk keys from the given 32 byte master key
+// for the object identified by objectID. Chunks are sealed using a fixed
+// nonce, so the ID must be unique for every object sealed using the same
+// master key, such as the random ID returned by NewChunkObjectID
+func NewChunkKeys(master, objectID []byte) (*ChunkKeys, error) {
+	if len(master) != keylen {
+		return nil, fmt.Errorf("invalid master key length %d, must be %d", len(master), keylen)
+	}
+	if len(objectID) == 0 {
+		return nil, errors.New("no chunk object id provided")
+	}
+	return &ChunkKeys{master: master, objectID: objectID}, nil
+}
+
+// NewChunkObjectID returns a random 16 byte object ID for use with NewChunkKeys
+func NewChunkObjectID() ([]byte, error) {
+	id := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// Record is used to record the object ID under the master key in log before
+// the chunks of the object are sealed, returning ErrNonceReuse if chunks of
+// another object with the same ID were sealed using the master key
+func (c *ChunkKeys) Record(log NonceLog) error {
+	return log.Record(nonceLogFingerprint(c.master), nonceLogEntry(c.objectID, nil))
+}
+
+// Key returns the data key for the chunk at index
+func (c *ChunkKeys) Key(index uint64) ([]byte, error) {
+	// label || index || object id
+	info := make([]byte, len("temporal-chunk-key")+8, len("temporal-chunk-key")+8+len(c.objectID))
+	copy(info, "temporal-chunk-key")
+	binary.BigEndian.PutUint64(info[len("temporal-chunk-key"):], index)
+	info = append(info, c.objectID...)
+	key := make([]byte, keylen)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, c.master, nil, info), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Range returns the keys for the chunks [start, end), of at most 65536 chunks
+func (c *ChunkKeys) Range(start, end uint64) (*ChunkKeyRange, error) {
+	if end <= start || end-start > maxChunkKeyRange {
+		return nil, errors.New("invalid chunk range")
+	}
+	r := &ChunkKeyRange{ObjectID: c.objectID, Start: start}
+	for i := start; i < end; i++ {
+		key, err := c.Key(i)
+		if err != nil {
+			return nil, err
+		}
+		r.Keys = append(r.Keys, key)
+	}
+	return r, nil
+}
+
+// Key returns the data key for the chunk at index, provided it is within the range
+func (r *ChunkKeyRange) Key(index uint64) ([]byte, error) {
+	if index < r.Start || index-r.Start >= uint64(len(r.Keys)) {
+		return nil, fmt.Errorf("chunk %d is outside of the key range", index)
+	}
+	return r.Keys[index-r.Start], nil
+}
+
+// SealChunk encrypts a single chunk using AES256-GCM and its derived key.
+// As every chunk key is unique to the object, and index the nonce is fixed, while the chunk index and
+// final chunk marker are authenticated to prevent reordering and truncation.
+// Objects sealed under a long-lived master key should be recorded using ChunkKeys.Record
+func SealChunk(key []byte, index uint64, chunk []byte, last bool) ([]byte, error) {
+	aead, err := newChunkAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, make([]byte, aead.NonceSize()), chunk, chunkAdditionalData(index, last)), nil
+}
+
+// OpenChunk decrypts a single chunk sealed with SealChunk
+func OpenChunk(key []byte, index uint64, sealed []byte, last bool) ([]byte, error) {
+	aead, err := newChunkAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, make([]byte, aead.NonceSize()), sealed, chunkAdditionalData(index, last))
+}
+
+func newChunkAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkAdditionalData(index uint64, last bool) []byte {
+	ad := make([]byte, 9)
+	binary.BigEndian.PutUint64(ad, index)
+	if last {
+		ad[8] = 1
+	}
+	return ad
+}
+
+// WithSegmentKeys is used to seal every segment of GCM-STREAM output, and
+// streams encrypted by EncryptStream using an AEAD protocol, using its own
+// key, derived from the data key, and nonce prefix as with ChunkKeys. Ranges
+// of segment keys returned by SegmentKeys can then be handed to workers,
+// which decrypt their segments using DecryptSegmentRange without the
+// decryption parameters
+func (e *EncryptManager) WithSegmentKeys() *EncryptManager {
+	e.segmentKeys = true
+	return e
+}
+
+// SegmentKeys returns the keys of the segments of streams encrypted using
+// WithSegmentKeys, derived from the decryption parameters of the manager
+func (e *EncryptManager) SegmentKeys() (*ChunkKeys, error) {
+	if err := e.checkKeyExport(true); err != nil {
+		return nil, err
+	}
+	key, prefix, err := e.decryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	return NewChunkKeys(key, prefix)
+}
+
+// DecryptSegmentRange is used to decrypt the segments of src covered by keys,
+// a range of the SegmentKeys of the stream, writing their plaintext to dst.
+// The first segment decrypted begins at offset keys.Start times the segment
+// size of the plaintext
+func (e *EncryptManager) DecryptSegmentRange(dst io.Writer, src io.ReadSeeker, keys *ChunkKeyRange) error {
+	if src == nil || keys == nil {
+		return errors.New("invalid content provided")
+	}
+	if err := e.checkApprovals(); err != nil {
+		return err
+	}
+	r, err := e.newSegmentReader(src, nil, nil, keys)
+	if err != nil {
+		return e.decryptError(err)
+	}
+	if keys.Start >= uint64(r.segments) {
+		return errors.New("segment range is outside of the stream")
+	}
+	end := keys.Start + uint64(len(keys.Keys))
+	if end > uint64(r.segments) {
+		end = uint64(r.segments)
+	}
+	if _, err := r.Seek(int64(keys.Start)*r.segment, io.SeekStart); err != nil {
+		return err
+	}
+	n := int64(end)*r.segment - int64(keys.Start)*r.segment
+	if _, err := io.Copy(dst, io.LimitReader(r, n)); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"testing"
+)
+
+func Test_ChunkKeys(t *testing.T) {
+	master := make([]byte, 32)
+	if _, err := rand.Read(master); err != nil {
+		t.Fatal(err)
+	}
+	objectID, err := NewChunkObjectID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewChunkKeys(master[:16], objectID); err == nil {
+		t.Fatal("expected error using short master key")
+	}
+	if _, err := NewChunkKeys(master, nil); err == nil {
+		t.Fatal("expected error without object id")
+	}
+	keys, err := NewChunkKeys(master, objectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// seal 6 chunks using the master key
+	var (
+		chunks [][]byte
+		sealed [][]byte
+	)
+	for i := uint64(0); i < 6; i++ {
+		chunk := bytes.Repeat([]byte{byte(i)}, 64)
+		key, err := keys.Key(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := SealChunk(key, i, chunk, i == 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, chunk)
+		sealed = append(sealed, out)
+	}
+	// split decryption between two workers
+	for _, bounds := range [][2]uint64{{0, 3}, {3, 6}} {
+		r, err := keys.Range(bounds[0], bounds[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := uint64(0); i < 6; i++ {
+			key, err := r.Key(i)
+			if i < bounds[0] || i >= bounds[1] {
+				if err == nil {
+					t.Fatalf("expected error retrieving key %d outside of range", i)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := OpenChunk(key, i, sealed[i], i == 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out, chunks[i]) {
+				t.Fatalf("chunk %d mismatch", i)
+			}
+		}
+	}
+	// chunks must not be reorderable or truncatable
+	key, err := keys.Key(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenChunk(key, 1, sealed[2], false); err == nil {
+		t.Fatal("expected error opening chunk with wrong key")
+	}
+	if _, err := OpenChunk(key, 1, sealed[1], true); err == nil {
+		t.Fatal("expected error opening chunk with wrong final marker")
+	}
+}
+
+func Test_ChunkKeys_ObjectID(t *testing.T) {
+	master := bytes.Repeat([]byte{1}, keylen)
+	a, err := NewChunkKeys(master, []byte("object-a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewChunkKeys(master, []byte("object-b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// objects sharing a master key never share chunk keys
+	for i := uint64(0); i < 4; i++ {
+		keyA, err := a.Key(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyB, err := b.Key(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(keyA, keyB) {
+			t.Fatalf("chunk %d key shared between objects", i)
+		}
+	}
+	if _, err := a.Range(0, maxChunkKeyRange+1); err == nil {
+		t.Fatal("expected error deriving excessive range")
+	}
+	r, err := a.Range(0, maxChunkKeyRange)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Keys) != maxChunkKeyRange || !bytes.Equal(r.ObjectID, []byte("object-a")) {
+		t.Fatalf("Range() of %d keys, object %s", len(r.Keys), r.ObjectID)
+	}
+}
+
+func Test_EncryptManager_SegmentKeys(t *testing.T) {
+	original, err := ioutil.ReadFile("README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := New(WithPassphrase("helloworld"), WithProtocol(GCMStream), WithChunkSize(1024), WithSegmentKeys())
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := e.Encrypt(bytes.NewReader(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encrypted[0]&segmentKeysFlag == 0 {
+		t.Fatal("stream does not record segment keys")
+	}
+	d := NewEncryptManager("helloworld").WithGCMStream(e.gcmDecryptParams)
+	decrypted, err := d.Decrypt(bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, original) {
+		t.Fatal("decrypted data does not match original")
+	}
+	seeker, err := d.DecryptSeeker(bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted, err = ioutil.ReadAll(seeker); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, original) {
+		t.Fatal("seeker data does not match original")
+	}
+
+	// split decryption between workers holding only their segment keys
+	keys, err := d.SegmentKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments := uint64(len(original)/1024 + 1)
+	var joined bytes.Buffer
+	for start := uint64(0); start < segments; start += 3 {
+		r, err := keys.Range(start, start+3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := NewEncryptManager("").DecryptSegmentRange(&joined, bytes.NewReader(encrypted), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(joined.Bytes(), original) {
+		t.Fatal("segment ranges do not match original")
+	}
+	r, err := keys.Range(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a range only decrypts its own segments
+	r.Start = 2
+	if err := NewEncryptManager("").DecryptSegmentRange(ioutil.Discard, bytes.NewReader(encrypted), r); err == nil {
+		t.Fatal("expected error decrypting segment using the key of another")
+	}
+	if r, err = keys.Range(segments, segments+1); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewEncryptManager("").DecryptSegmentRange(ioutil.Discard, bytes.NewReader(encrypted), r); err == nil {
+		t.Fatal("expected error decrypting range outside of the stream")
+	}
+
+	// streams sealed using a single key have no segment keys
+	single, err := NewEncryptManager("helloworld").WithGCMStream(nil).Encrypt(bytes.NewReader(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err = keys.Range(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewEncryptManager("").DecryptSegmentRange(ioutil.Discard, bytes.NewReader(single), r); err == nil {
+		t.Fatal("expected error decrypting stream without segment keys")
+	}
+}
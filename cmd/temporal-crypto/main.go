@@ -1,11 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"flag"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/RTradeLtd/cmd/v2"
 	"github.com/RTradeLtd/config/v2"
@@ -13,7 +19,10 @@ import (
 )
 
 var (
-	pwd = flag.String("passphrase", "", "passphrase to decrypt file with")
+	pwd        = flag.String("passphrase", "", "passphrase to decrypt file with")
+	jsonOutput = flag.Bool("json", false, "print results as JSON for automation")
+	protocol   = flag.String("protocol", "", "protocol of encrypted data without a header")
+	keyring    = flag.String("keyring", "", "path of the keyring, defaulting to ~/.temporal-crypto/keyring")
 )
 
 var commands = map[string]cmd.Cmd{
@@ -30,9 +39,11 @@ arguments. For example:
 				log.Fatal("no passphrase provided - use the '--passphrase' flag")
 			}
 
-			decrypt := crypto.NewEncryptManager(*pwd)
-			for i := 2; i < len(os.Args); i++ {
-				f, err := os.Open(os.Args[i])
+			// files encrypted by Temporal are in the unauthenticated AES256-CFB format
+			decrypt := crypto.NewEncryptManager(*pwd).WithLegacyCFB()
+			results := []fileResult{}
+			for _, path := range flag.Args()[1:] {
+				f, err := os.Open(path)
 				if err != nil {
 					fatal(err)
 				}
@@ -46,13 +57,13 @@ arguments. For example:
 					fatal(err)
 				}
 
-				if err = ioutil.WriteFile(
-					filepath.Join(dir, filepath.Base(os.Args[i]))+".decrypted",
-					out, 0644,
-				); err != nil {
+				outPath := filepath.Join(dir, filepath.Base(path)) + ".decrypted"
+				if err = ioutil.WriteFile(outPath, out, 0644); err != nil {
 					fatal(err)
 				}
+				results = append(results, fileResult{Input: path, Output: outPath, SHA256: sha256Hex(out)})
 			}
+			report(os.Stdout, results, nil)
 		},
 	},
 	"encrypt-cfb": {
@@ -66,8 +77,9 @@ arguments. For example:
 			}
 
 			decrypt := crypto.NewEncryptManager(p)
-			for i := 2; i < len(os.Args); i++ {
-				f, err := os.Open(os.Args[i])
+			results := []fileResult{}
+			for _, path := range flag.Args()[1:] {
+				f, err := os.Open(path)
 				if err != nil {
 					fatal(err)
 				}
@@ -81,15 +93,435 @@ arguments. For example:
 					fatal(err)
 				}
 
-				if err = ioutil.WriteFile(
-					filepath.Join(dir, filepath.Base(os.Args[i]))+".encrypted",
-					out, 0644,
-				); err != nil {
+				outPath := filepath.Join(dir, filepath.Base(path)) + ".encrypted"
+				if err = ioutil.WriteFile(outPath, out, 0644); err != nil {
 					fatal(err)
 				}
+				results = append(results, fileResult{Input: path, Output: outPath, SHA256: sha256Hex(out)})
 			}
+			report(os.Stdout, results, nil)
 		},
 	},
+	"fixtures": {
+		Blurb: "generate and verify golden encryption fixtures",
+		Description: `Generates a corpus of encrypted fixtures across all supported protocols, or
+verifies that a previously generated corpus still decrypts. Fixtures are
+encrypted using the passphrase set in the '--passphrase' flag.`,
+		ChildRequired: true,
+		Children: map[string]cmd.Cmd{
+			"generate": {
+				Blurb: "generate fixtures from seed files",
+				Description: `Encrypts the given seed files using every supported protocol, writing the
+results and a manifest to the given directory. For example:
+
+	temporal-crypto --passphrase=temporal fixtures generate ./fixtures seed1.txt seed2.bin
+`,
+				Action: func(cfg config.TemporalConfig, args map[string]string) {
+					if *pwd == "" {
+						log.Fatal("no passphrase provided - use the '--passphrase' flag")
+					}
+					if flag.NArg() < 4 {
+						log.Fatal("a directory and at least one seed file must be provided")
+					}
+					seeds := make(map[string][]byte)
+					for _, path := range flag.Args()[3:] {
+						data, err := ioutil.ReadFile(path)
+						if err != nil {
+							fatal(err)
+						}
+						seeds[filepath.Base(path)] = data
+					}
+					manifest, err := crypto.GenerateFixtures(flag.Arg(2), *pwd, seeds)
+					if err != nil {
+						fatal(err)
+					}
+					report(os.Stdout, manifest, func() {
+						fmt.Printf("generated %d fixtures in %s\n", len(manifest.Fixtures), flag.Arg(2))
+					})
+				},
+			},
+			"verify": {
+				Blurb: "verify previously generated fixtures",
+				Args:  []string{"dir"},
+				Action: func(cfg config.TemporalConfig, args map[string]string) {
+					if *pwd == "" {
+						log.Fatal("no passphrase provided - use the '--passphrase' flag")
+					}
+					if err := crypto.VerifyFixtures(args["dir"], *pwd); err != nil {
+						fatal(err)
+					}
+					report(os.Stdout, map[string]interface{}{"dir": args["dir"], "verified": true}, func() {
+						fmt.Printf("all fixtures in %s decrypted successfully\n", args["dir"])
+					})
+				},
+			},
+		},
+	},
+	"inspect": {
+		Blurb: "describe encrypted data without decrypting it",
+		Description: `Prints the format, protocol, key derivation function, and other metadata
+recorded by an encrypted file, or a JSON envelope, without decrypting it. For example:
+
+	temporal-crypto inspect file.encrypted
+`,
+		Args: []string{"file"},
+		Action: func(cfg config.TemporalConfig, args map[string]string) {
+			f, err := os.Open(args["file"])
+			if err != nil {
+				fatal(err)
+			}
+			defer f.Close()
+			in, err := crypto.Inspect(f)
+			if err != nil {
+				fatal(err)
+			}
+			report(os.Stdout, newInspectResult(args["file"], in), func() {
+				fmt.Print(in)
+			})
+		},
+	},
+	"kdf-audit": {
+		Blurb: "report encrypted files protected by weak key derivation",
+		Description: `Walks the given directory, printing every encrypted file, or JSON envelope,
+whose key derivation function does not meet the current policy, along with the
+plan to re-encrypt it. Files are not decrypted. For example:
+
+	temporal-crypto kdf-audit ./backups
+`,
+		Args: []string{"dir"},
+		Action: func(cfg config.TemporalConfig, args map[string]string) {
+			policy := crypto.DefaultKDFPolicy()
+			results := []auditResult{}
+			err := filepath.Walk(args["dir"], func(path string, info os.FileInfo, err error) error {
+				if err != nil || !info.Mode().IsRegular() {
+					return err
+				}
+				f, err := os.Open(path)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				advice, err := crypto.AdviseKDF(f, policy)
+				if err != nil {
+					results = append(results, auditResult{Path: path, Error: err.Error()})
+					if !*jsonOutput {
+						fmt.Printf("%s: %s\n", path, err)
+					}
+					return nil
+				}
+				if advice.Compliant {
+					return nil
+				}
+				results = append(results, auditResult{
+					Path:        path,
+					KDF:         advice.KDF,
+					Legacy:      advice.Legacy,
+					Problems:    advice.Problems,
+					Recommended: advice.Recommended,
+					Plan:        advice.Plan,
+				})
+				if *jsonOutput {
+					return nil
+				}
+				fmt.Printf("%s:\n", path)
+				for _, problem := range advice.Problems {
+					fmt.Printf("\t%s\n", problem)
+				}
+				for i, step := range advice.Plan {
+					fmt.Printf("\t%d. %s\n", i+1, step)
+				}
+				return nil
+			})
+			if err != nil {
+				fatal(err)
+			}
+			report(os.Stdout, results, nil)
+		},
+	},
+	"keygen": {
+		Blurb: "generate a keypair with a passphrase protected private key",
+		Description: `Generates an ed25519 or rsa keypair, writing the public key to '<name>.pub',
+and the private key to '<name>.key'. The private key is encrypted using the
+passphrase set in the '--passphrase' flag before it is written, as a PKCS #8
+encrypted private key readable by openssl. For example:
+
+	temporal-crypto --passphrase=temporal keygen ed25519 mykey
+`,
+		Args: []string{"type", "name"},
+		Action: func(cfg config.TemporalConfig, args map[string]string) {
+			if *pwd == "" {
+				log.Fatal("no passphrase provided - use the '--passphrase' flag")
+			}
+			public, private, err := crypto.GenerateKeyPair(crypto.KeyType(args["type"]), *pwd)
+			if err != nil {
+				fatal(err)
+			}
+			if err := ioutil.WriteFile(args["name"]+".key", private, 0600); err != nil {
+				fatal(err)
+			}
+			if err := ioutil.WriteFile(args["name"]+".pub", public, 0644); err != nil {
+				fatal(err)
+			}
+			report(os.Stdout, keygenResult{
+				Type:        args["type"],
+				PublicKey:   args["name"] + ".pub",
+				PrivateKey:  args["name"] + ".key",
+				Fingerprint: hex.EncodeToString(crypto.KeyFingerprint(public)),
+			}, nil)
+		},
+	},
+	"keyring": {
+		Blurb: "manage keys in the shared keyring",
+		Description: `Adds, generates, removes, and lists the keys of the keyring set in the
+'--keyring' flag, which is shared with other tools on the same machine, and
+protected using the passphrase set in the '--passphrase' flag. For example:
+
+	temporal-crypto --passphrase=temporal keyring generate backup
+	temporal-crypto --passphrase=temporal keyring add archive archive.key
+	temporal-crypto --passphrase=temporal keyring passphrase new-passphrase.txt
+`,
+		ChildRequired: true,
+		Children: map[string]cmd.Cmd{
+			"add": {
+				Blurb: "add the key read from a file",
+				Args:  []string{"name", "file"},
+				Action: func(cfg config.TemporalConfig, args map[string]string) {
+					key, err := ioutil.ReadFile(args["file"])
+					if err != nil {
+						fatal(err)
+					}
+					k := openKeyRing()
+					if err := k.Put(args["name"], key); err != nil {
+						fatal(err)
+					}
+					report(os.Stdout, keyringResult{Keyring: keyringPath(), Name: args["name"]}, nil)
+				},
+			},
+			"generate": {
+				Blurb: "add a random 256 bit key",
+				Args:  []string{"name"},
+				Action: func(cfg config.TemporalConfig, args map[string]string) {
+					key := make([]byte, 32)
+					if _, err := rand.Read(key); err != nil {
+						fatal(err)
+					}
+					k := openKeyRing()
+					if err := k.Put(args["name"], key); err != nil {
+						fatal(err)
+					}
+					report(os.Stdout, keyringResult{Keyring: keyringPath(), Name: args["name"]}, nil)
+				},
+			},
+			"remove": {
+				Blurb: "remove a key",
+				Args:  []string{"name"},
+				Action: func(cfg config.TemporalConfig, args map[string]string) {
+					k := openKeyRing()
+					if _, err := k.Key(args["name"]); err != nil {
+						fatal(err)
+					}
+					if err := k.Delete(args["name"]); err != nil {
+						fatal(err)
+					}
+					report(os.Stdout, keyringResult{Keyring: keyringPath(), Name: args["name"]}, nil)
+				},
+			},
+			"passphrase": {
+				Blurb: "re-encrypt the keyring under the passphrase read from a file",
+				Args:  []string{"file"},
+				Action: func(cfg config.TemporalConfig, args map[string]string) {
+					data, err := ioutil.ReadFile(args["file"])
+					if err != nil {
+						fatal(err)
+					}
+					passphrase := strings.TrimRight(string(data), "\r\n")
+					if err := openKeyRing().ChangePassphrase(passphrase, crypto.DefaultKDFConfig(crypto.Argon2id)); err != nil {
+						fatal(err)
+					}
+					report(os.Stdout, keyringResult{Keyring: keyringPath()}, func() {
+						fmt.Printf("re-encrypted %s\n", keyringPath())
+					})
+				},
+			},
+			"list": {
+				Blurb: "list the names of all keys",
+				Action: func(cfg config.TemporalConfig, args map[string]string) {
+					names, err := openKeyRing().Names()
+					if err != nil {
+						fatal(err)
+					}
+					report(os.Stdout, keyringResult{Keyring: keyringPath(), Names: names}, func() {
+						for _, name := range names {
+							fmt.Println(name)
+						}
+					})
+				},
+			},
+		},
+	},
+	"sign": {
+		Blurb: "sign a file, writing a detached signature",
+		Description: `Signs the given file using an ed25519 or rsa private key, as generated by
+keygen, writing the signature to '<file>.sig'. The private key is decrypted
+using the passphrase set in the '--passphrase' flag. For example:
+
+	temporal-crypto --passphrase=temporal sign mykey.key backup.tar
+`,
+		Args: []string{"key", "file"},
+		Action: func(cfg config.TemporalConfig, args map[string]string) {
+			data, err := ioutil.ReadFile(args["key"])
+			if err != nil {
+				fatal(err)
+			}
+			signer, err := crypto.ParseSigningKey(data, *pwd)
+			if err != nil {
+				fatal(err)
+			}
+			e, err := crypto.NewEncryptManager("").WithSigningKey(signer)
+			if err != nil {
+				fatal(err)
+			}
+			if err := e.SignFile(args["file"]); err != nil {
+				fatal(err)
+			}
+			report(os.Stdout, signatureResult{File: args["file"], Signature: args["file"] + crypto.SignatureExt}, nil)
+		},
+	},
+	"stream": {
+		Blurb: "pipe encrypted data between processes",
+		Description: `Encrypts or decrypts a framed stream between stdin and stdout, using the
+passphrase set in the '--passphrase' flag. For example:
+
+	tar c ./dir | temporal-crypto --passphrase=temporal stream send | \
+		ssh host 'temporal-crypto --passphrase=temporal stream receive | tar x'
+`,
+		ChildRequired: true,
+		Children: map[string]cmd.Cmd{
+			"send": {
+				Blurb: "encrypt stdin into a stream written to stdout",
+				Action: func(cfg config.TemporalConfig, args map[string]string) {
+					if *pwd == "" {
+						log.Fatal("no passphrase provided - use the '--passphrase' flag")
+					}
+					stream := crypto.NewEncryptManager(*pwd).DialStream(stdio{os.Stdin, os.Stdout})
+					n, err := io.Copy(stream, os.Stdin)
+					if err != nil {
+						fatal(err)
+					}
+					if err := stream.Close(); err != nil {
+						fatal(err)
+					}
+					// stdout carries the stream, so results are reported on stderr
+					report(os.Stderr, streamResult{Bytes: n}, nil)
+				},
+			},
+			"receive": {
+				Blurb: "decrypt a stream read from stdin to stdout",
+				Action: func(cfg config.TemporalConfig, args map[string]string) {
+					if *pwd == "" {
+						log.Fatal("no passphrase provided - use the '--passphrase' flag")
+					}
+					stream := crypto.NewEncryptManager(*pwd).AcceptStream(stdio{os.Stdin, os.Stdout})
+					n, err := io.Copy(os.Stdout, stream)
+					if err != nil {
+						fatal(err)
+					}
+					// stdout carries the plaintext, so results are reported on stderr
+					report(os.Stderr, streamResult{Bytes: n}, nil)
+				},
+			},
+		},
+	},
+	"stub": {
+		Blurb: "generate a program decrypting an encrypted file",
+		Description: `Writes the source of a small Go program, pinned to the format of the given
+encrypted file, which decrypts it using only the standard library, and
+golang.org/x/crypto, to be stored alongside long-term archives. Data without a
+header requires the '--protocol' flag. The program can be built into a static
+binary using 'CGO_ENABLED=0 go build'. For example:
+
+	temporal-crypto stub backup.tar.encrypted decrypt.go
+`,
+		Args: []string{"file", "output"},
+		Action: func(cfg config.TemporalConfig, args map[string]string) {
+			f, err := os.Open(args["file"])
+			if err != nil {
+				fatal(err)
+			}
+			defer f.Close()
+			var src bytes.Buffer
+			if err := crypto.WriteDecryptStub(&src, f, crypto.Protocol(*protocol)); err != nil {
+				fatal(err)
+			}
+			if err := ioutil.WriteFile(args["output"], src.Bytes(), 0644); err != nil {
+				fatal(err)
+			}
+			report(os.Stdout, fileResult{Input: args["file"], Output: args["output"], SHA256: sha256Hex(src.Bytes())}, nil)
+		},
+	},
+	"verify": {
+		Blurb: "verify a file against its detached signature",
+		Description: `Verifies the given file against the signature in '<file>.sig', as written by
+sign, using an ed25519 or rsa public key. For example:
+
+	temporal-crypto verify mykey.pub backup.tar
+`,
+		Args: []string{"key", "file"},
+		Action: func(cfg config.TemporalConfig, args map[string]string) {
+			data, err := ioutil.ReadFile(args["key"])
+			if err != nil {
+				fatal(err)
+			}
+			public, err := crypto.ParseVerifyKey(data)
+			if err != nil {
+				fatal(err)
+			}
+			e, err := crypto.NewEncryptManager("").WithVerifyKeys(public)
+			if err != nil {
+				fatal(err)
+			}
+			if err := e.VerifyFile(args["file"]); err != nil {
+				fatal(err)
+			}
+			report(os.Stdout, signatureResult{File: args["file"], Signature: args["file"] + crypto.SignatureExt, Verified: true}, func() {
+				fmt.Printf("%s: signature verified\n", args["file"])
+			})
+		},
+	},
+}
+
+// stdio joins stdin and stdout into a single io.ReadWriter
+type stdio struct {
+	io.Reader
+	io.Writer
+}
+
+// keyringPath returns the path of the keyring set in the '--keyring' flag
+func keyringPath() string {
+	if *keyring != "" {
+		return *keyring
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		fatal(err)
+	}
+	return filepath.Join(home, ".temporal-crypto", "keyring")
+}
+
+// openKeyRing opens the keyring using the passphrase set in the '--passphrase' flag
+func openKeyRing() *crypto.KeyRing {
+	if *pwd == "" {
+		log.Fatal("no passphrase provided - use the '--passphrase' flag")
+	}
+	path := keyringPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		fatal(err)
+	}
+	k, err := crypto.OpenKeyRing(path, *pwd)
+	if err != nil {
+		fatal(err)
+	}
+	return k
 }
 
 func main() {
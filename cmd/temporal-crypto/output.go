@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/RTradeLtd/crypto/v2"
+)
+
+// fileResult describes a file written by a command
+type fileResult struct {
+	Input  string `json:"input"`
+	Output string `json:"output"`
+	// SHA256 is the hex encoded digest of the output
+	SHA256 string `json:"sha256"`
+}
+
+// inspectResult describes encrypted data, see crypto.Inspection
+type inspectResult struct {
+	File          string            `json:"file"`
+	Format        string            `json:"format"`
+	Version       int               `json:"version,omitempty"`
+	Protocol      crypto.Protocol   `json:"protocol,omitempty"`
+	KDF           *crypto.KDFConfig `json:"kdf,omitempty"`
+	Size          int64             `json:"size,omitempty"`
+	Segments      int               `json:"segments,omitempty"`
+	SaltSize      int               `json:"salt_size,omitempty"`
+	NonceSize     int               `json:"nonce_size,omitempty"`
+	Recipients    int               `json:"recipients,omitempty"`
+	Authenticated bool              `json:"authenticated"`
+	HasParams     bool              `json:"has_params"`
+	KeyID         string            `json:"key_id,omitempty"`
+	SignerKeyID   string            `json:"signer_key_id,omitempty"`
+	Checksum      string            `json:"checksum,omitempty"`
+	Attestations  []string          `json:"attestations,omitempty"`
+	NotBefore     *time.Time        `json:"not_before,omitempty"`
+	NotAfter      *time.Time        `json:"not_after,omitempty"`
+}
+
+// auditResult describes a file found by kdf-audit
+type auditResult struct {
+	Path        string            `json:"path"`
+	Compliant   bool              `json:"compliant"`
+	KDF         *crypto.KDFConfig `json:"kdf,omitempty"`
+	Legacy      bool              `json:"legacy,omitempty"`
+	Problems    []string          `json:"problems,omitempty"`
+	Recommended *crypto.KDFConfig `json:"recommended,omitempty"`
+	Plan        []string          `json:"plan,omitempty"`
+	Error       string            `json:"error,omitempty"`
+}
+
+// keygenResult describes a generated keypair
+type keygenResult struct {
+	Type       string `json:"type"`
+	PublicKey  string `json:"public_key"`
+	PrivateKey string `json:"private_key"`
+	// Fingerprint is the hex encoded fingerprint of the public key
+	Fingerprint string `json:"fingerprint"`
+}
+
+// signatureResult describes a file signed, or verified
+type signatureResult struct {
+	File      string `json:"file"`
+	Signature string `json:"signature"`
+	Verified  bool   `json:"verified,omitempty"`
+}
+
+// keyringResult describes a keyring updated, or listed
+type keyringResult struct {
+	Keyring string   `json:"keyring"`
+	Name    string   `json:"name,omitempty"`
+	Names   []string `json:"names,omitempty"`
+}
+
+// streamResult describes a stream sent, or received
+type streamResult struct {
+	Bytes int64 `json:"bytes"`
+}
+
+// report writes result to w as JSON when the '--json' flag is set, and
+// otherwise calls text to print the human readable result
+func report(w io.Writer, result interface{}, text func()) {
+	if !*jsonOutput {
+		if text != nil {
+			text()
+		}
+		return
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(result); err != nil {
+		fatal(err)
+	}
+}
+
+// newInspectResult converts in for JSON output
+func newInspectResult(file string, in *crypto.Inspection) inspectResult {
+	res := inspectResult{
+		File:          file,
+		Format:        in.Format,
+		Version:       in.Version,
+		Protocol:      in.Protocol,
+		KDF:           in.KDF,
+		Size:          in.Size,
+		Segments:      in.Segments,
+		SaltSize:      in.SaltSize,
+		NonceSize:     in.NonceSize,
+		Recipients:    in.Recipients,
+		Authenticated: in.Authenticated,
+		HasParams:     in.HasParams,
+		KeyID:         in.KeyID,
+		SignerKeyID:   in.SignerKeyID,
+		Checksum:      string(in.Checksum),
+		Attestations:  in.Attestations,
+	}
+	if !in.NotBefore.IsZero() {
+		res.NotBefore = &in.NotBefore
+	}
+	if !in.NotAfter.IsZero() {
+		res.NotAfter = &in.NotAfter
+	}
+	return res
+}
+
+// sha256Hex returns the hex encoded sha256 digest of data
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
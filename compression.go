@@ -0,0 +1,153 @@
+package crypto
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// CompressionZstd compresses payloads using zstd with a dictionary
+	CompressionZstd = "zstd"
+	// CompressionDeflate compresses payloads using DEFLATE with a preset
+	// dictionary, which remain readable, though payloads are no longer
+	// compressed using DEFLATE
+	CompressionDeflate = "deflate"
+)
+
+// maxDictionarySize is the size of the DEFLATE window, beyond which a
+// dictionary is of no use
+const maxDictionarySize = 32 * 1024
+
+// maxDecompressedSize is the largest payload decompressed unless a larger
+// size is allowed using MaxContentSize, protecting against payloads crafted
+// to expand to exhaust memory
+const maxDecompressedSize = 256 << 20
+
+// zstdDictionaryMagic begins dictionaries trained using zstd --train
+const zstdDictionaryMagic = 0xEC30A437
+
+// CompressionDictionary is a dictionary holding data typical of the payloads
+// of an application, such as JSON records, allowing small payloads to be
+// compressed well using zstd. Data is either a dictionary trained on samples
+// of the payloads using zstd --train, whose ID must match the one it
+// records, or raw content as returned by NewCompressionDictionary. The ID,
+// which must not be zero, is recorded in the envelope of compressed payloads,
+// and must identify the same data for as long as they are stored
+type CompressionDictionary struct {
+	ID   uint32
+	Data []byte
+}
+
+// Compression describes how a payload was compressed before encryption
+type Compression struct {
+	Algorithm  string `json:"algorithm"`
+	Dictionary uint32 `json:"dictionary,omitempty"`
+}
+
+// NewCompressionDictionary returns a raw content dictionary built from samples
+// of the payloads of an application. Matches near the end of the dictionary
+// are cheapest, so the most common samples should be given last. Only the
+// last 32KiB are used. Dictionaries trained using zstd --train generally
+// compress better
+func NewCompressionDictionary(id uint32, samples ...[]byte) CompressionDictionary {
+	data := bytes.Join(samples, nil)
+	if len(data) > maxDictionarySize {
+		data = data[len(data)-maxDictionarySize:]
+	}
+	return CompressionDictionary{ID: id, Data: data}
+}
+
+// WithCompressionDictionaries is used to compress payloads encrypted by
+// EncryptSplit using the first dictionary, recording its ID in the envelope.
+// Payloads are left uncompressed if compression does not make them smaller.
+// Every dictionary is available to DecryptSplit, so dictionaries can be
+// replaced while payloads compressed with earlier ones remain readable
+func (e *EncryptManager) WithCompressionDictionaries(dicts ...CompressionDictionary) *EncryptManager {
+	e.dictionaries = dicts
+	return e
+}
+
+// trained indicates whether the dictionary is in the format produced by
+// zstd --train, rather than raw content
+func (d CompressionDictionary) trained() bool {
+	return len(d.Data) >= 8 && binary.LittleEndian.Uint32(d.Data) == zstdDictionaryMagic
+}
+
+// compress returns data compressed using the first dictionary, or a nil
+// Compression if it is not smaller than data
+func (e *EncryptManager) compress(data []byte) ([]byte, *Compression, error) {
+	dict := e.dictionaries[0]
+	if dict.ID == 0 {
+		return nil, nil, errors.New("compression dictionary id must not be zero")
+	}
+	opt := zstd.WithEncoderDictRaw(dict.ID, dict.Data)
+	if dict.trained() {
+		opt = zstd.WithEncoderDict(dict.Data)
+	}
+	w, err := zstd.NewWriter(nil, opt, zstd.WithEncoderLevel(zstd.SpeedBestCompression), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer w.Close()
+	compressed := w.EncodeAll(data, nil)
+	if len(compressed) >= len(data) {
+		return data, nil, nil
+	}
+	return compressed, &Compression{Algorithm: CompressionZstd, Dictionary: dict.ID}, nil
+}
+
+// decompress returns data decompressed as described by c, refusing output
+// larger than allowed by MaxContentSize, or maxDecompressedSize
+func (e *EncryptManager) decompress(data []byte, c *Compression) ([]byte, error) {
+	if c.Algorithm != CompressionDeflate && c.Algorithm != CompressionZstd {
+		return nil, fmt.Errorf("unsupported compression %s", c.Algorithm)
+	}
+	limit := int64(maxDecompressedSize)
+	if max, ok := e.maxContentSize(); ok {
+		limit = max
+	}
+	for _, dict := range e.dictionaries {
+		if dict.ID != c.Dictionary {
+			continue
+		}
+		var r io.ReadCloser
+		if c.Algorithm == CompressionZstd {
+			// windows beyond the limit are refused before they are allocated
+			window := uint64(limit) + 1
+			if window < 1<<20 {
+				window = 1 << 20
+			}
+			opt := zstd.WithDecoderDictRaw(dict.ID, dict.Data)
+			if dict.trained() {
+				opt = zstd.WithDecoderDicts(dict.Data)
+			}
+			d, err := zstd.NewReader(bytes.NewReader(data), opt, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(window))
+			if err != nil {
+				return nil, err
+			}
+			r = d.IOReadCloser()
+		} else {
+			r = flate.NewReaderDict(bytes.NewReader(data), dict.Data)
+		}
+		defer r.Close()
+		out, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+		if err == zstd.ErrWindowSizeExceeded || err == zstd.ErrDecoderSizeExceeded {
+			return nil, ErrContentTooLarge
+		}
+		if err != nil {
+			return nil, errors.New("invalid compressed data")
+		}
+		if int64(len(out)) > limit {
+			return nil, ErrContentTooLarge
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unknown compression dictionary %d", c.Dictionary)
+}
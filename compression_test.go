@@ -0,0 +1,181 @@
+package crypto
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func Test_EncryptManager_CompressionDictionaries(t *testing.T) {
+	record := func(i int) []byte {
+		return []byte(fmt.Sprintf(`{"id":%d,"name":"user-%d","email":"user-%d@example.com","role":"member","active":true}`, i, i, i))
+	}
+	var samples [][]byte
+	for i := 0; i < 100; i++ {
+		samples = append(samples, record(i))
+	}
+	old, current := NewCompressionDictionary(1, samples[:50]...), NewCompressionDictionary(2, samples[50:]...)
+	// dictionaries trained using zstd --train --dictID=6, and 7
+	oldTrained, currentTrained := loadTrainedDictionary(t, 6), loadTrainedDictionary(t, 7)
+	data := record(1000)
+	tests := []struct {
+		name         string
+		old, current CompressionDictionary
+	}{
+		{"raw", old, current},
+		{"trained", oldTrained, currentTrained},
+	}
+	for _, tt := range tests {
+		for _, protocol := range []Protocol{CFB, GCM, XChaCha20Poly1305} {
+			old, current := tt.old, tt.current
+			t.Run(tt.name+"/"+string(protocol), func(t *testing.T) {
+				e := NewEncryptManager("helloworld")
+				setProtocol(e, protocol)
+				_, plain, err := e.Clone().EncryptSplit(bytes.NewReader(data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				env, payload, err := e.WithCompressionDictionaries(current, old).EncryptSplit(bytes.NewReader(data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if env.Compression == nil || env.Compression.Algorithm != CompressionZstd || env.Compression.Dictionary != current.ID || env.Version != 2 {
+					t.Fatalf("EncryptSplit() compression = %+v, version = %d", env.Compression, env.Version)
+				}
+				if len(payload) >= len(plain) {
+					t.Fatalf("compressed payload of %d bytes, uncompressed %d", len(payload), len(plain))
+				}
+				// payloads remain readable while their dictionary is configured
+				for _, dicts := range [][]CompressionDictionary{{current}, {old, current}} {
+					decrypted, err := NewEncryptManager("helloworld").WithCompressionDictionaries(dicts...).DecryptSplit(env, bytes.NewReader(payload))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(decrypted, data) {
+						t.Fatal("decrypted data does not match original")
+					}
+				}
+				if _, err := NewEncryptManager("helloworld").WithCompressionDictionaries(old).DecryptSplit(env, bytes.NewReader(payload)); err == nil {
+					t.Fatal("expected error without the dictionary")
+				}
+				// validators run against the decompressed plaintext
+				decrypted, err := NewEncryptManager("helloworld").WithCompressionDictionaries(current).
+					WithContentValidators(MagicBytes([]byte("{"))).DecryptSplit(env, bytes.NewReader(payload))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(decrypted, data) {
+					t.Fatal("decrypted data does not match original")
+				}
+				if _, err := NewEncryptManager("helloworld").WithCompressionDictionaries(current).
+					WithContentValidators(MaxContentSize(int64(len(payload)))).DecryptSplit(env, bytes.NewReader(payload)); err != ErrContentTooLarge {
+					t.Fatalf("err = %v, want %v", err, ErrContentTooLarge)
+				}
+			})
+		}
+	}
+	if _, _, err := NewEncryptManager("helloworld").WithCompressionDictionaries(NewCompressionDictionary(0, samples...)).EncryptSplit(bytes.NewReader(data)); err == nil {
+		t.Fatal("expected error using dictionary without id")
+	}
+
+	// incompressible payloads are left uncompressed
+	random, err := hex.DecodeString("8f3a1c5e9b2d7f4061e8a3c5b7d9f1e2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, _, err := NewEncryptManager("helloworld").WithGCM(nil).WithCompressionDictionaries(current).EncryptSplit(bytes.NewReader(random))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Compression != nil || env.Version != 1 {
+		t.Fatalf("EncryptSplit() compression = %+v, version = %d", env.Compression, env.Version)
+	}
+
+	// the compression is part of the canonical form
+	plain := &Envelope{Version: 1, Protocol: GCM}
+	compressed := &Envelope{Version: 1, Protocol: GCM, Compression: &Compression{Algorithm: CompressionDeflate, Dictionary: 2}}
+	a, err := plain.Canonicalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := compressed.Canonicalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(a, b) {
+		t.Fatal("compression not part of the canonical form")
+	}
+}
+
+func Test_EncryptManager_CompressionBomb(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 100; i++ {
+		samples = append(samples, []byte(fmt.Sprintf(`{"id":%d}`, i)))
+	}
+	// a payload expanding far beyond its compressed size
+	bomb := make([]byte, 4<<20)
+	for _, dict := range []CompressionDictionary{NewCompressionDictionary(1, samples...), loadTrainedDictionary(t, 7)} {
+		env, payload, err := NewEncryptManager("helloworld").WithGCM(nil).WithCompressionDictionaries(dict).EncryptSplit(bytes.NewReader(bomb))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if env.Compression == nil || len(payload) > 64*1024 {
+			t.Fatalf("compressed payload of %d bytes", len(payload))
+		}
+		if _, err := NewEncryptManager("helloworld").WithCompressionDictionaries(dict).
+			WithContentValidators(MaxContentSize(1<<20)).DecryptSplit(env, bytes.NewReader(payload)); err != ErrContentTooLarge {
+			t.Fatalf("%s: err = %v, want %v", env.Compression.Algorithm, err, ErrContentTooLarge)
+		}
+	}
+}
+
+func Test_CompressionDictionary_Interop(t *testing.T) {
+	dict := loadTrainedDictionary(t, 7)
+	// compressed using zstd -19 -D records-7.dict
+	compressed, err := ioutil.ReadFile(filepath.Join("testdata", "zstd", "record.json.zst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"id":1000,"name":"user-1000","email":"user-1000@example.com","role":"member","active":true,"created":"2019-01-10T00:00:00Z"}`
+	decompressed, err := NewEncryptManager("helloworld").WithCompressionDictionaries(dict).decompress(compressed, &Compression{Algorithm: CompressionZstd, Dictionary: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decompressed) != want {
+		t.Fatalf("decompress = %s", decompressed)
+	}
+
+	// payloads compressed using DEFLATE by earlier versions remain readable
+	raw := NewCompressionDictionary(1, []byte(want))
+	var buf bytes.Buffer
+	w, err := flate.NewWriterDict(&buf, flate.BestCompression, raw.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(want))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if decompressed, err = NewEncryptManager("helloworld").WithCompressionDictionaries(raw).decompress(buf.Bytes(), &Compression{Algorithm: CompressionDeflate, Dictionary: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if string(decompressed) != want {
+		t.Fatalf("decompress = %s", decompressed)
+	}
+	if _, err := NewEncryptManager("helloworld").WithCompressionDictionaries(raw).decompress(buf.Bytes(), &Compression{Algorithm: "brotli", Dictionary: 1}); err == nil {
+		t.Fatal("expected error using unsupported compression")
+	}
+}
+
+// loadTrainedDictionary returns the dictionary trained using zstd --train with the given id
+func loadTrainedDictionary(t *testing.T, id uint32) CompressionDictionary {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "zstd", fmt.Sprintf("records-%d.dict", id)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return CompressionDictionary{ID: id, Data: data}
+}
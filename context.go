@@ -0,0 +1,52 @@
+package crypto
+
+import (
+	"context"
+	"io"
+)
+
+// EncryptContext is used to encrypt r as Encrypt does, aborting once ctx is
+// done. ctx is checked before every read from r, so encryption of large
+// objects stops promptly, returning ctx.Err()
+func (e *EncryptManager) EncryptContext(ctx context.Context, r io.Reader) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if r != nil {
+		r = &contextReader{ctx: ctx, r: r}
+	}
+	out, err := e.Encrypt(r)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	return out, err
+}
+
+// DecryptContext is used to decrypt r as Decrypt does, aborting once ctx is
+// done. ctx is checked before every read from r, returning ctx.Err()
+func (e *EncryptManager) DecryptContext(ctx context.Context, r io.Reader) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if r != nil {
+		r = &contextReader{ctx: ctx, r: r}
+	}
+	out, err := e.Decrypt(r)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	return out, err
+}
+
+// contextReader fails reads once ctx is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,69 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// cancelReader cancels a context once n bytes have been read
+type cancelReader struct {
+	r      io.Reader
+	n      int
+	cancel context.CancelFunc
+}
+
+func (c *cancelReader) Read(p []byte) (int, error) {
+	if len(p) > 1024 {
+		p = p[:1024]
+	}
+	n, err := c.r.Read(p)
+	if c.n -= n; c.n <= 0 {
+		c.cancel()
+	}
+	return n, err
+}
+
+func Test_EncryptManager_Context(t *testing.T) {
+	data := bytes.Repeat([]byte("hello world"), 100000)
+	for _, e := range []*EncryptManager{NewEncryptManager("helloworld"), NewEncryptManager("helloworld").WithGCM(nil)} {
+		t.Run(string(e.getProtocol()), func(t *testing.T) {
+			encrypted, err := e.EncryptContext(context.Background(), bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err := e.DecryptContext(context.Background(), bytes.NewReader(encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+
+			// cancellation part way through aborts cleanly
+			ctx, cancel := context.WithCancel(context.Background())
+			r := &cancelReader{r: bytes.NewReader(data), n: len(data) / 2, cancel: cancel}
+			if _, err := e.EncryptContext(ctx, r); err != context.Canceled {
+				t.Fatalf("EncryptContext() err = %v, want %v", err, context.Canceled)
+			}
+			ctx, cancel = context.WithCancel(context.Background())
+			r = &cancelReader{r: bytes.NewReader(encrypted), n: len(encrypted) / 2, cancel: cancel}
+			if _, err := e.DecryptContext(ctx, r); err != context.Canceled {
+				t.Fatalf("DecryptContext() err = %v, want %v", err, context.Canceled)
+			}
+
+			// contexts which are already done are rejected before reading
+			ctx, cancel = context.WithTimeout(context.Background(), time.Nanosecond)
+			defer cancel()
+			<-ctx.Done()
+			if _, err := e.EncryptContext(ctx, bytes.NewReader(data)); err != context.DeadlineExceeded {
+				t.Fatalf("EncryptContext() err = %v, want %v", err, context.DeadlineExceeded)
+			}
+			if _, err := e.DecryptContext(ctx, bytes.NewReader(encrypted)); err != context.DeadlineExceeded {
+				t.Fatalf("DecryptContext() err = %v, want %v", err, context.DeadlineExceeded)
+			}
+		})
+	}
+}
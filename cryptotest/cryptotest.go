@@ -0,0 +1,192 @@
+// Package cryptotest provides test doubles for services built on the crypto
+// package, allowing fast, and hermetic tests of the encryption layer.
+// Nothing in this package is suitable for production use.
+package cryptotest
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RTradeLtd/crypto/v2"
+	"golang.org/x/crypto/ed25519"
+)
+
+// KeyProvider is an in-memory crypto.KeyProvider. Keys which have not been
+// set are generated deterministically from their identifier on first use
+type KeyProvider struct {
+	// Err, if set, is returned by all calls to Key to simulate provider outages
+	Err error
+
+	mux   sync.Mutex
+	keys  map[string][]byte
+	calls map[string]int
+}
+
+// NewKeyProvider is used to instantiate an empty in-memory KeyProvider
+func NewKeyProvider() *KeyProvider {
+	return &KeyProvider{
+		keys:  make(map[string][]byte),
+		calls: make(map[string]int),
+	}
+}
+
+// SetKey is used to set the key returned for id
+func (k *KeyProvider) SetKey(id string, key []byte) {
+	k.mux.Lock()
+	k.keys[id] = key
+	k.mux.Unlock()
+}
+
+// Key returns the key for id
+func (k *KeyProvider) Key(id string) ([]byte, error) {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	k.calls[id]++
+	if k.Err != nil {
+		return nil, k.Err
+	}
+	key, ok := k.keys[id]
+	if !ok {
+		sum := sha256.Sum256([]byte("cryptotest-key:" + id))
+		key = sum[:]
+		k.keys[id] = key
+	}
+	return key, nil
+}
+
+// Calls returns the number of times the key for id was requested
+func (k *KeyProvider) Calls(id string) int {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	return k.calls[id]
+}
+
+// Rand is a deterministic source of "randomness" for reproducible tests,
+// suitable for use with EncryptManager.WithRandom
+type Rand struct {
+	seed    [32]byte
+	counter uint64
+	buf     []byte
+}
+
+// NewRand returns a deterministic randomness source for the given seed
+func NewRand(seed string) *Rand {
+	return &Rand{seed: sha256.Sum256([]byte(seed))}
+}
+
+// Read fills p with deterministic bytes
+func (r *Rand) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(r.buf) == 0 {
+			block := make([]byte, len(r.seed)+8)
+			copy(block, r.seed[:])
+			binary.BigEndian.PutUint64(block[len(r.seed):], r.counter)
+			r.counter++
+			sum := sha256.Sum256(block)
+			r.buf = sum[:]
+		}
+		copied := copy(p[n:], r.buf)
+		r.buf = r.buf[copied:]
+		n += copied
+	}
+	return n, nil
+}
+
+// ensure Rand satisfies io.Reader
+var _ io.Reader = (*Rand)(nil)
+
+// Clock is a crypto.Clock which only moves when set or advanced, suitable
+// for use with EncryptManager.WithClock
+type Clock struct {
+	mux sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a Clock stopped at start
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Now returns the current time of the clock
+func (c *Clock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.now
+}
+
+// Set moves the clock to t
+func (c *Clock) Set(t time.Time) {
+	c.mux.Lock()
+	c.now = t
+	c.mux.Unlock()
+}
+
+// Advance moves the clock forward by d
+func (c *Clock) Advance(d time.Duration) {
+	c.mux.Lock()
+	c.now = c.now.Add(d)
+	c.mux.Unlock()
+}
+
+// ensure Clock satisfies crypto.Clock
+var _ crypto.Clock = (*Clock)(nil)
+
+// Recipient is a canned identity with a deterministic ed25519 keypair
+type Recipient struct {
+	Name       string
+	PublicKey  ed25519.PublicKey
+	PrivateKey ed25519.PrivateKey
+}
+
+// NewRecipient returns the canned recipient for name
+func NewRecipient(name string) *Recipient {
+	seed := sha256.Sum256([]byte("cryptotest-recipient:" + name))
+	priv := ed25519.NewKeyFromSeed(seed[:])
+	return &Recipient{
+		Name:       name,
+		PublicKey:  priv.Public().(ed25519.PublicKey),
+		PrivateKey: priv,
+	}
+}
+
+var (
+	// Alice is a canned recipient
+	Alice = NewRecipient("alice")
+	// Bob is a canned recipient
+	Bob = NewRecipient("bob")
+	// Carol is a canned recipient
+	Carol = NewRecipient("carol")
+	// Directory is the canned identity used to sign key directory entries
+	Directory = NewRecipient("directory")
+)
+
+// NewKeyDirectoryServer starts a TLS server acting as a key directory serving
+// the public keys of the given recipients, signed by Directory. The returned
+// client is configured to trust the server, and pin Directory as its signer
+func NewKeyDirectoryServer(recipients ...*Recipient) (*httptest.Server, *crypto.KeyDirectory) {
+	entries := make(map[string]*crypto.KeyDirectoryEntry)
+	for _, r := range recipients {
+		entries[r.Name] = crypto.SignKeyDirectoryEntry(Directory.PrivateKey, r.Name, r.PublicKey)
+	}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entry, ok := entries[strings.TrimPrefix(r.URL.Path, "/")]
+		if !ok {
+			http.Error(w, fmt.Sprintf("no entry for %s", r.URL.Path), http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(entry)
+	}))
+	dir := crypto.NewKeyDirectory(srv.URL)
+	dir.Client = srv.Client()
+	dir.SigningKey = Directory.PublicKey
+	return srv, dir
+}
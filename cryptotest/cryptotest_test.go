@@ -0,0 +1,98 @@
+package cryptotest
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/crypto/v2"
+)
+
+func Test_Rand(t *testing.T) {
+	encrypt := func(seed string) []byte {
+		out, err := crypto.NewEncryptManager("helloworld").
+			WithGCM(nil).
+			WithRandom(NewRand(seed)).
+			Encrypt(bytes.NewReader([]byte("hello world")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	if !bytes.Equal(encrypt("seed"), encrypt("seed")) {
+		t.Fatal("same seed produced different output")
+	}
+	if bytes.Equal(encrypt("seed"), encrypt("other-seed")) {
+		t.Fatal("different seeds produced the same output")
+	}
+}
+
+func Test_Clock(t *testing.T) {
+	start := time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewClock(start)
+	e := crypto.NewEncryptManager("helloworld").
+		WithGCM(nil).
+		WithValidity(start, start.Add(time.Hour)).
+		WithClock(clock)
+	env, payload, err := e.EncryptSplit(bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.DecryptSplit(env, bytes.NewReader(payload)); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(2 * time.Hour)
+	if _, err := e.DecryptSplit(env, bytes.NewReader(payload)); err != crypto.ErrExpired {
+		t.Fatalf("got error %v, want %v", err, crypto.ErrExpired)
+	}
+	clock.Set(start.Add(-time.Second))
+	if _, err := e.DecryptSplit(env, bytes.NewReader(payload)); err != crypto.ErrNotYetValid {
+		t.Fatalf("got error %v, want %v", err, crypto.ErrNotYetValid)
+	}
+}
+
+func Test_KeyProvider(t *testing.T) {
+	kp := NewKeyProvider()
+	kp.SetKey("tenant/alice", bytes.Repeat([]byte{1}, 32))
+	tm := crypto.NewTenantKeyManager(kp)
+	for _, tenant := range []string{"alice", "bob"} {
+		e, err := tm.Manager(tenant)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encrypted, err := e.Encrypt(bytes.NewReader([]byte("hello world")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := e.Decrypt(bytes.NewReader(encrypted))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(decrypted) != "hello world" {
+			t.Fatalf("Decrypt = %s", decrypted)
+		}
+	}
+	if kp.Calls("tenant/alice") != 1 {
+		t.Fatalf("Calls = %d, want 1", kp.Calls("tenant/alice"))
+	}
+	kp.Err = errors.New("provider unavailable")
+	if _, err := tm.Manager("alice"); err == nil {
+		t.Fatal("expected error from unavailable provider")
+	}
+}
+
+func Test_KeyDirectoryServer(t *testing.T) {
+	srv, dir := NewKeyDirectoryServer(Alice, Bob)
+	defer srv.Close()
+	key, err := dir.Lookup("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, Alice.PublicKey) {
+		t.Fatal("resolved key does not match alice")
+	}
+	if _, err := dir.Lookup("carol"); err == nil {
+		t.Fatal("expected error resolving unknown recipient")
+	}
+}
@@ -0,0 +1,116 @@
+package crypto
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// EncryptDirectory is used to encrypt the directory tree at path as a tar
+// archive, written to w using EncryptStream, so the tree is never held in
+// memory. The modes of files, and directories are preserved, and symbolic
+// links are archived as links. Special files, such as sockets, are skipped.
+// Use DecryptDirectory to restore the tree
+func (e *EncryptManager) EncryptDirectory(path string, w io.Writer) error {
+	if w == nil {
+		return errors.New("invalid content provided")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New("path is not a directory")
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeArchive(pw, path))
+	}()
+	err = e.EncryptStream(w, pr)
+	pr.CloseWithError(err)
+	return err
+}
+
+// DecryptDirectory is used to decrypt an archive produced by EncryptDirectory,
+// restoring the directory tree into path, along with the modes of its files,
+// and directories, and its symbolic links. Links which are absolute, or
+// resolve outside of path are rejected with ErrUnsafeArchiveEntry.
+// Decryption is streamed, so if an error is returned path may hold partially
+// restored content, which should be discarded
+func (e *EncryptManager) DecryptDirectory(r io.Reader, path string) error {
+	if r == nil {
+		return errors.New("invalid content provided")
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	pr, pw := io.Pipe()
+	decrypted := make(chan error, 1)
+	go func() {
+		err := e.DecryptStream(pw, r)
+		pw.CloseWithError(err)
+		decrypted <- err
+	}()
+	err := extractArchive(pr, path, ArchiveLimits{}, true)
+	if err == nil {
+		// consume the tar padding so the whole stream is authenticated
+		_, err = io.Copy(ioutil.Discard, pr)
+	}
+	pr.CloseWithError(err)
+	if derr := <-decrypted; err == nil {
+		err = derr
+	}
+	return err
+}
+
+// writeArchive writes the directory tree at root to w as a tar archive, with
+// names relative to root
+func writeArchive(w io.Writer, root string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(root, path)
+		if err != nil || name == "." {
+			return err
+		}
+		var link string
+		switch mode := info.Mode(); {
+		case mode&os.ModeSymlink != 0:
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		case !mode.IsRegular() && !mode.IsDir():
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(name)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.CopyN(tw, f, info.Size())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
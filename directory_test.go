@@ -0,0 +1,149 @@
+package crypto
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_EncryptManager_Directory(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "directory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	src := filepath.Join(tmp, "src")
+	files := []struct {
+		name string
+		mode os.FileMode
+		data []byte
+	}{
+		{"config", 0600, []byte(`{"Identity":{}}`)},
+		{"blocks/CIQA/data", 0644, bytes.Repeat([]byte("block"), 20000)},
+		{"bin/run.sh", 0755, []byte("#!/bin/sh\n")},
+	}
+	for _, f := range files {
+		path := filepath.Join(src, filepath.FromSlash(f.name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, f.data, f.mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, f.mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(src, "empty"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../config", filepath.Join(src, "bin", "config")); err != nil {
+		t.Fatal(err)
+	}
+	for _, protocol := range []Protocol{CFB, GCM} {
+		t.Run(string(protocol), func(t *testing.T) {
+			e := NewEncryptManager("helloworld")
+			setProtocol(e, protocol)
+			var encrypted bytes.Buffer
+			if err := e.EncryptDirectory(src, &encrypted); err != nil {
+				t.Fatal(err)
+			}
+			dst := filepath.Join(tmp, "dst-"+string(protocol))
+			if err := e.DecryptDirectory(bytes.NewReader(encrypted.Bytes()), dst); err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range files {
+				path := filepath.Join(dst, filepath.FromSlash(f.name))
+				data, err := ioutil.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(data, f.data) {
+					t.Fatalf("%s does not match original", f.name)
+				}
+				info, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Mode().Perm() != f.mode {
+					t.Fatalf("%s has mode %v, want %v", f.name, info.Mode().Perm(), f.mode)
+				}
+			}
+			if info, err := os.Stat(filepath.Join(dst, "empty")); err != nil || info.Mode().Perm() != 0700 {
+				t.Fatalf("empty directory not restored: %v, %v", info, err)
+			}
+			link, err := os.Readlink(filepath.Join(dst, "bin", "config"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if link != "../config" {
+				t.Fatalf("link target = %s, want ../config", link)
+			}
+		})
+	}
+
+	e := NewEncryptManager("helloworld").WithGCMStream(nil)
+	if err := e.EncryptDirectory(filepath.Join(src, "config"), ioutil.Discard); err == nil {
+		t.Fatal("expected error encrypting a file")
+	}
+	// links resolving outside of the destination are rejected
+	tests := []struct {
+		name   string
+		target string
+	}{
+		{"absolute", "/etc/passwd"},
+		{"escaping", "../../etc/passwd"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var archive bytes.Buffer
+			tw := tar.NewWriter(&archive)
+			if err := tw.WriteHeader(&tar.Header{Name: "dir/link", Typeflag: tar.TypeSymlink, Linkname: tt.target, Mode: 0777}); err != nil {
+				t.Fatal(err)
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			encrypted, err := e.Encrypt(&archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := e.DecryptDirectory(bytes.NewReader(encrypted), filepath.Join(tmp, tt.name)); err != ErrUnsafeArchiveEntry {
+				t.Fatalf("DecryptDirectory() err = %v, want %v", err, ErrUnsafeArchiveEntry)
+			}
+		})
+	}
+	// a chain of links, each resolving within the destination, must not be
+	// followed to write outside of it
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	for _, hdr := range []*tar.Header{
+		{Name: "a", Typeflag: tar.TypeSymlink, Linkname: ".", Mode: 0777},
+		{Name: "a/b", Typeflag: tar.TypeSymlink, Linkname: "..", Mode: 0777},
+		{Name: "b/evil", Typeflag: tar.TypeReg, Mode: 0600, Size: 4},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tw.Write([]byte("evil")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := e.Encrypt(&archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(tmp, "chain", "dst")
+	if err := e.DecryptDirectory(bytes.NewReader(encrypted), dst); err != ErrUnsafeArchiveEntry {
+		t.Fatalf("DecryptDirectory() err = %v, want %v", err, ErrUnsafeArchiveEntry)
+	}
+	if _, err := os.Lstat(filepath.Join(tmp, "chain", "evil")); !os.IsNotExist(err) {
+		t.Fatal("file written outside of the destination")
+	}
+}
@@ -0,0 +1,91 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrDowngrade is returned when encrypted data, or an envelope, claims weaker
+// settings than the format policy allows, as would result from an attacker
+// rewriting its header
+var ErrDowngrade = errors.New("encrypted data is weaker than the format policy allows")
+
+// FormatPolicy protects against downgrade attacks, where the header of
+// encrypted data, or an envelope, is rewritten to claim an older format, or
+// weaker settings, by setting the weakest formats produced, and accepted
+type FormatPolicy struct {
+	// MinEnvelopeVersion is the oldest envelope version produced by
+	// EncryptSplit, or accepted by DecryptSplit, ignored if zero
+	MinEnvelopeVersion int
+	// Protocols are the protocols used to encrypt, and accepted when recorded
+	// by a header, or an envelope. Any protocol is allowed if empty
+	Protocols []Protocol
+	// KDF is the minimum cost of deriving AES256-CFB keys, including those
+	// protecting decryption parameters, both when encrypting, and decrypting,
+	// ignored if nil. Data without a key derivation function header uses the
+	// legacy function, which is never compliant
+	KDF *KDFPolicy
+}
+
+// WithFormatPolicy is used to refuse producing, or decrypting, formats weaker
+// than policy allows, returning ErrDowngrade
+func (e *EncryptManager) WithFormatPolicy(policy FormatPolicy) (*EncryptManager, error) {
+	if policy.MinEnvelopeVersion > envelopeVersion {
+		return nil, fmt.Errorf("unsupported envelope version %d", policy.MinEnvelopeVersion)
+	}
+	e.formatPolicy = &policy
+	return e, nil
+}
+
+// checkProtocol checks the policy allows protocol
+func (e *EncryptManager) checkProtocol(protocol Protocol) error {
+	if e.formatPolicy == nil || len(e.formatPolicy.Protocols) == 0 {
+		return nil
+	}
+	for _, allowed := range e.formatPolicy.Protocols {
+		if protocol == allowed {
+			return nil
+		}
+	}
+	return ErrDowngrade
+}
+
+// checkKDF checks the policy allows the key derivation function cfg, where
+// nil is the legacy function
+func (e *EncryptManager) checkKDF(cfg *KDFConfig) error {
+	if e.formatPolicy == nil || e.formatPolicy.KDF == nil {
+		return nil
+	}
+	if advice := e.formatPolicy.KDF.Check(cfg); !advice.Compliant {
+		return ErrDowngrade
+	}
+	return nil
+}
+
+// checkEnvelope checks the version of env is allowed by the policy, and is
+// not older than the features of env require
+func (e *EncryptManager) checkEnvelope(env *Envelope) error {
+	if env.Version < env.minVersion() {
+		return ErrDowngrade
+	}
+	if e.formatPolicy != nil && env.Version < e.formatPolicy.MinEnvelopeVersion {
+		return ErrDowngrade
+	}
+	return e.checkProtocol(env.Protocol)
+}
+
+// minVersion returns the oldest envelope version able to describe env
+func (env *Envelope) minVersion() int {
+	switch {
+	case env.Approval != nil:
+		return 5
+	case len(env.Signature) > 0:
+		return 4
+	case env.WrappedKey != nil:
+		return 3
+	case env.Compression != nil:
+		return 2
+	default:
+		return 1
+	}
+}
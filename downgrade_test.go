@@ -0,0 +1,113 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_EncryptManager_FormatPolicy(t *testing.T) {
+	data := []byte("hello world")
+	strong := KDFConfig{KDF: PBKDF2, Iterations: 1000}
+	weak := KDFConfig{KDF: PBKDF2, Iterations: 100}
+	kdfPolicy := &KDFPolicy{MinPBKDF2Iterations: 1000, Recommended: strong}
+	if _, err := NewEncryptManager("helloworld").WithFormatPolicy(FormatPolicy{MinEnvelopeVersion: envelopeVersion + 1}); err == nil {
+		t.Fatal("expected error with unsupported envelope version")
+	}
+	policy := func(p FormatPolicy) *EncryptManager {
+		e, err := NewEncryptManager("helloworld").WithFormatPolicy(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	t.Run("envelope-version", func(t *testing.T) {
+		env, payload, err := NewEncryptManager("helloworld").WithKDF(strong).WithGCM(nil).EncryptSplit(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if env.Version != 1 {
+			t.Fatalf("EncryptSplit() version = %d, want 1", env.Version)
+		}
+		e := policy(FormatPolicy{MinEnvelopeVersion: 2})
+		if _, err := e.DecryptSplit(env, bytes.NewReader(payload)); err != ErrDowngrade {
+			t.Fatalf("DecryptSplit() err = %v, want %v", err, ErrDowngrade)
+		}
+		// the floor is recorded when encrypting
+		env, payload, err = e.WithKDF(strong).WithGCM(nil).EncryptSplit(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if env.Version != 2 {
+			t.Fatalf("EncryptSplit() version = %d, want 2", env.Version)
+		}
+		if _, err := e.DecryptSplit(env, bytes.NewReader(payload)); err != nil {
+			t.Fatal(err)
+		}
+		// envelopes claiming an older version than their contents require are refused
+		env.Version, env.WrappedKey = 2, &WrappedKey{KeyID: "key"}
+		if _, err := NewEncryptManager("helloworld").DecryptSplit(env, bytes.NewReader(payload)); err != ErrDowngrade {
+			t.Fatalf("DecryptSplit() err = %v, want %v", err, ErrDowngrade)
+		}
+	})
+
+	t.Run("protocol", func(t *testing.T) {
+		e := policy(FormatPolicy{Protocols: []Protocol{GCM}})
+		if _, err := e.Encrypt(bytes.NewReader(data)); err != ErrDowngrade {
+			t.Fatalf("Encrypt() err = %v, want %v", err, ErrDowngrade)
+		}
+		if err := e.EncryptStream(&bytes.Buffer{}, bytes.NewReader(data)); err != ErrDowngrade {
+			t.Fatalf("EncryptStream() err = %v, want %v", err, ErrDowngrade)
+		}
+		// headers claiming a protocol outside of the policy are refused
+		encrypted, err := NewEncryptManager("helloworld").WithHeader().Encrypt(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := e.Decrypt(bytes.NewReader(encrypted)); err != ErrDowngrade {
+			t.Fatalf("Decrypt() err = %v, want %v", err, ErrDowngrade)
+		}
+		env, payload, err := NewEncryptManager("helloworld").EncryptSplit(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := e.DecryptSplit(env, bytes.NewReader(payload)); err != ErrDowngrade {
+			t.Fatalf("DecryptSplit() err = %v, want %v", err, ErrDowngrade)
+		}
+	})
+
+	t.Run("kdf", func(t *testing.T) {
+		e := policy(FormatPolicy{KDF: kdfPolicy})
+		for _, cfg := range []*KDFConfig{nil, &weak} {
+			d := NewEncryptManager("helloworld")
+			if cfg != nil {
+				d.WithKDF(*cfg)
+				e.WithKDF(*cfg)
+			}
+			if _, err := e.Encrypt(bytes.NewReader(data)); err != ErrDowngrade {
+				t.Fatalf("Encrypt() with %v err = %v, want %v", cfg, err, ErrDowngrade)
+			}
+			encrypted, err := d.Encrypt(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := e.Decrypt(bytes.NewReader(encrypted)); err != ErrDowngrade {
+				t.Fatalf("Decrypt() with %v err = %v, want %v", cfg, err, ErrDowngrade)
+			}
+			if err := e.DecryptStream(&bytes.Buffer{}, bytes.NewReader(encrypted)); err != ErrDowngrade {
+				t.Fatalf("DecryptStream() with %v err = %v, want %v", cfg, err, ErrDowngrade)
+			}
+		}
+		encrypted, err := e.WithKDF(strong).Encrypt(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := e.Decrypt(bytes.NewReader(encrypted))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Fatal("decrypted data does not match original")
+		}
+	})
+}
@@ -0,0 +1,359 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// WithECIES is used to setup, and return EncryptManager for use with ECIES,
+// encrypting content to the public key of a recipient without sharing a
+// passphrase. Encryption requires the public key, and decryption the private
+// key, so either may be nil. Keys may be X25519 keys given as *[32]byte,
+// Ed25519 keys, which are converted to X25519 keys, or ECDSA keys using the
+// NIST curves, and are loaded using ParseECIESPublicKey, and ParseECIESPrivateKey.
+//
+// Content is encrypted using AES256-GCM under a key derived using HKDF from
+// the shared secret of an ephemeral key, and the recipient key, and is in the
+// format of ephemeral public key || ciphertext
+func (e *EncryptManager) WithECIES(public, private interface{}) *EncryptManager {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.protocol = ECIES
+	e.eciesPublic = public
+	e.eciesPrivate = private
+	return e
+}
+
+// encryptECIES encrypts given io.Reader to the configured public key
+func (e *EncryptManager) encryptECIES(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	public := e.eciesPublic
+	if public == nil {
+		if e.eciesPrivate == nil {
+			return nil, errors.New("no ecies public key provided")
+		}
+		var err error
+		if public, err = eciesPublicKey(e.eciesPrivate); err != nil {
+			return nil, err
+		}
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return eciesSeal(e.randomness(), public, data)
+}
+
+// decryptECIES decrypts given io.Reader using the configured private key
+func (e *EncryptManager) decryptECIES(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	if e.eciesPrivate == nil {
+		return nil, errors.New("no ecies private key provided")
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return eciesOpen(e.eciesPrivate, data)
+}
+
+// ParseECIESPublicKey is used to load the public key of an ECIES recipient,
+// encoded as PEM, such as an Ed25519 key generated using GenerateKeyPair, or
+// a PKIX ECDSA key, as raw bytes, being either a 32 byte X25519 key, or an
+// uncompressed NIST curve point, or as a protobuf encoded libp2p key
+func ParseECIESPublicKey(data []byte) (interface{}, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		switch block.Type {
+		case "PUBLIC KEY":
+			public, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := public.(*ecdsa.PublicKey); !ok {
+				return nil, errors.New("unsupported ecies public key")
+			}
+			return public, nil
+		case "ED25519 PUBLIC KEY":
+			if len(block.Bytes) != ed25519.PublicKeySize {
+				return nil, errors.New("invalid ed25519 public key")
+			}
+			return ed25519.PublicKey(block.Bytes), nil
+		default:
+			return nil, fmt.Errorf("unsupported public key type %s", block.Type)
+		}
+	}
+	if len(data) == 32 {
+		return x25519Key(data)
+	}
+	if len(data) > 0 && data[0] == 4 {
+		for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+			if len(data) != 1+2*((curve.Params().BitSize+7)/8) {
+				continue
+			}
+			x, y := elliptic.Unmarshal(curve, data)
+			if x == nil {
+				return nil, errors.New("invalid ecdsa public key")
+			}
+			return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+		}
+	}
+	public, err := parseLibp2pPublicKey(data)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := eciesRecipient(public); err != nil {
+		return nil, err
+	}
+	return public, nil
+}
+
+// ParseECIESPrivateKey is used to load the private key of an ECIES recipient,
+// encoded as PEM, as supported by ParsePrivateKey, as a raw 32 byte X25519
+// key, or as a protobuf encoded libp2p key. The passphrase is only required
+// for encrypted private keys
+func ParseECIESPrivateKey(data []byte, passphrase string) (interface{}, error) {
+	var (
+		private interface{}
+		err     error
+	)
+	switch {
+	case len(data) == 32:
+		return x25519Key(data)
+	case bytes.Contains(data, []byte("-----BEGIN")):
+		private, err = ParsePrivateKey(data, passphrase)
+	default:
+		private, err = parseLibp2pPrivateKey(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := eciesPublicKey(private); err != nil {
+		return nil, err
+	}
+	return private, nil
+}
+
+// x25519Key returns a copy of a raw X25519 key
+func x25519Key(data []byte) (*[32]byte, error) {
+	if len(data) != 32 {
+		return nil, errors.New("invalid x25519 key")
+	}
+	var key [32]byte
+	copy(key[:], data)
+	return &key, nil
+}
+
+// eciesPublicKey returns the public key of an ECIES private key
+func eciesPublicKey(private interface{}) (interface{}, error) {
+	switch private := private.(type) {
+	case *[32]byte:
+		var public [32]byte
+		curve25519.ScalarBaseMult(&public, private)
+		return &public, nil
+	case ed25519.PrivateKey:
+		return private.Public(), nil
+	case *ecdsa.PrivateKey:
+		return &private.PublicKey, nil
+	default:
+		return nil, fmt.Errorf("unsupported ecies private key %T", private)
+	}
+}
+
+// eciesRecipient returns the X25519 key content is sealed to for X25519, and
+// Ed25519 keys, or the ECDSA key itself
+func eciesRecipient(public interface{}) (interface{}, error) {
+	switch public := public.(type) {
+	case *[32]byte, *ecdsa.PublicKey:
+		return public, nil
+	case ed25519.PublicKey:
+		return ed25519PublicToX25519(public)
+	default:
+		return nil, fmt.Errorf("unsupported ecies public key %T", public)
+	}
+}
+
+// eciesSeal encrypts data to an ECIES public key
+func eciesSeal(random io.Reader, public interface{}, data []byte) ([]byte, error) {
+	recipient, err := eciesRecipient(public)
+	if err != nil {
+		return nil, err
+	}
+	if recipient, ok := recipient.(*ecdsa.PublicKey); ok {
+		return sealECDH(random, recipient, data)
+	}
+	return sealX25519(random, recipient.(*[32]byte), data)
+}
+
+// eciesOpen decrypts data sealed using eciesSeal with an ECIES private key
+func eciesOpen(private interface{}, data []byte) ([]byte, error) {
+	switch private := private.(type) {
+	case *[32]byte:
+		return openX25519(private, data)
+	case ed25519.PrivateKey:
+		return openX25519(ed25519PrivateToX25519(private), data)
+	case *ecdsa.PrivateKey:
+		return openECDH(private, data)
+	default:
+		return nil, fmt.Errorf("unsupported ecies private key %T", private)
+	}
+}
+
+// curve25519P is the prime 2^255 - 19
+var curve25519P, _ = new(big.Int).SetString("7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffed", 16)
+
+// ed25519PublicToX25519 converts an Ed25519 public key to the X25519 public
+// key of the same secret, using the birational map u = (1 + y) / (1 - y)
+func ed25519PublicToX25519(public ed25519.PublicKey) (*[32]byte, error) {
+	// the key is the little endian y coordinate, with the sign of x in the top bit
+	le := make([]byte, ed25519.PublicKeySize)
+	copy(le, public)
+	le[31] &= 0x7f
+	y := new(big.Int).SetBytes(reverse(le))
+	if y.Cmp(curve25519P) >= 0 {
+		return nil, errors.New("invalid ed25519 public key")
+	}
+	one := big.NewInt(1)
+	denominator := new(big.Int).Sub(one, y)
+	denominator.Mod(denominator, curve25519P)
+	if denominator.Sign() == 0 {
+		return nil, errors.New("invalid ed25519 public key")
+	}
+	u := new(big.Int).Add(one, y)
+	u.Mul(u, denominator.ModInverse(denominator, curve25519P))
+	u.Mod(u, curve25519P)
+	var out [32]byte
+	b := u.Bytes()
+	copy(out[32-len(b):], b)
+	copy(out[:], reverse(out[:]))
+	return &out, nil
+}
+
+// ed25519PrivateToX25519 converts an Ed25519 private key to the X25519 private
+// key of the same secret, which is the clamped scalar derived from its seed
+func ed25519PrivateToX25519(private ed25519.PrivateKey) *[32]byte {
+	digest := sha512.Sum512(private[:32])
+	var out [32]byte
+	copy(out[:], digest[:32])
+	out[0] &= 248
+	out[31] &= 127
+	out[31] |= 64
+	return &out
+}
+
+// reverse returns a reversed copy of b, converting between byte orders
+func reverse(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i := range b {
+		out[len(b)-1-i] = b[i]
+	}
+	return out
+}
+
+// sealX25519 encrypts data to the X25519 public key of recipient, in the
+// format of ephemeral public key || ciphertext
+func sealX25519(random io.Reader, recipient *[32]byte, data []byte) ([]byte, error) {
+	var ephemeral, public [32]byte
+	if _, err := io.ReadFull(random, ephemeral[:]); err != nil {
+		return nil, err
+	}
+	curve25519.ScalarBaseMult(&public, &ephemeral)
+	var shared [32]byte
+	curve25519.ScalarMult(&shared, &ephemeral, recipient)
+	return sealShared(shared[:], public[:], recipient[:], data)
+}
+
+// openX25519 decrypts data sealed using sealX25519 with the X25519 private key
+func openX25519(private *[32]byte, data []byte) ([]byte, error) {
+	if len(data) < 32 {
+		return nil, errors.New("invalid content provided")
+	}
+	var peer, public, shared [32]byte
+	copy(peer[:], data[:32])
+	curve25519.ScalarBaseMult(&public, private)
+	curve25519.ScalarMult(&shared, private, &peer)
+	return openShared(shared[:], data[:32], public[:], data[32:])
+}
+
+// sealECDH encrypts data to an ECDSA public key using ECIES, in the format
+// of uncompressed ephemeral public key || ciphertext
+func sealECDH(random io.Reader, recipient *ecdsa.PublicKey, data []byte) ([]byte, error) {
+	ephemeral, err := ecdsa.GenerateKey(recipient.Curve, random)
+	if err != nil {
+		return nil, err
+	}
+	x, _ := recipient.Curve.ScalarMult(recipient.X, recipient.Y, ephemeral.D.Bytes())
+	public := elliptic.Marshal(recipient.Curve, ephemeral.X, ephemeral.Y)
+	return sealShared(ecdhSecret(recipient.Curve, x), public, elliptic.Marshal(recipient.Curve, recipient.X, recipient.Y), data)
+}
+
+// openECDH decrypts data sealed using sealECDH with the ECDSA private key
+func openECDH(private *ecdsa.PrivateKey, data []byte) ([]byte, error) {
+	curve := private.Curve
+	size := 1 + 2*((curve.Params().BitSize+7)/8)
+	if len(data) < size {
+		return nil, errors.New("invalid content provided")
+	}
+	px, py := elliptic.Unmarshal(curve, data[:size])
+	if px == nil {
+		return nil, errors.New("invalid ephemeral public key")
+	}
+	x, _ := curve.ScalarMult(px, py, private.D.Bytes())
+	return openShared(ecdhSecret(curve, x), data[:size], elliptic.Marshal(curve, private.X, private.Y), data[size:])
+}
+
+// ecdhSecret returns the shared x coordinate, padded to the size of the curve
+func ecdhSecret(curve elliptic.Curve, x *big.Int) []byte {
+	secret := make([]byte, (curve.Params().BitSize+7)/8)
+	b := x.Bytes()
+	copy(secret[len(secret)-len(b):], b)
+	return secret
+}
+
+// sealShared encrypts data under a key derived from the shared secret, bound
+// to both the ephemeral, and recipient public keys, prefixing the ephemeral key
+func sealShared(shared, ephemeral, recipient, data []byte) ([]byte, error) {
+	aead, err := sharedAEAD(shared, ephemeral, recipient)
+	if err != nil {
+		return nil, err
+	}
+	// every message is sealed under a freshly derived key, so the nonce is fixed
+	out := append([]byte{}, ephemeral...)
+	return aead.Seal(out, make([]byte, aead.NonceSize()), data, ephemeral), nil
+}
+
+// openShared decrypts data sealed using sealShared
+func openShared(shared, ephemeral, recipient, data []byte) ([]byte, error) {
+	aead, err := sharedAEAD(shared, ephemeral, recipient)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, make([]byte, aead.NonceSize()), data, ephemeral)
+}
+
+func sharedAEAD(shared, ephemeral, recipient []byte) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, ephemeral...), recipient...)
+	key := make([]byte, keylen)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte("temporal-sealed-box")), key); err != nil {
+		return nil, err
+	}
+	return newAEAD(aeadAES256GCM, key)
+}
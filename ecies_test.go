@@ -0,0 +1,150 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/ed25519"
+)
+
+func Test_EncryptManager_ECIES(t *testing.T) {
+	data := bytes.Repeat([]byte("hello world"), 1000)
+	var x25519Private, x25519Public [32]byte
+	if _, err := rand.Read(x25519Private[:]); err != nil {
+		t.Fatal(err)
+	}
+	curve25519.ScalarBaseMult(&x25519Public, &x25519Private)
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p521, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		public  interface{}
+		private interface{}
+	}{
+		{"x25519", &x25519Public, &x25519Private},
+		{"ed25519", edPublic, edPrivate},
+		{"p256", &p256.PublicKey, p256},
+		{"p521", &p521.PublicKey, p521},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted, err := NewEncryptManager("").WithECIES(tt.public, nil).Encrypt(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := NewEncryptManager("").WithECIES(nil, tt.private)
+			decrypted, err := e.Decrypt(bytes.NewReader(encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+			if err := e.Health(); err != nil {
+				t.Fatal(err)
+			}
+			if err := NewEncryptManager("").WithECIES(tt.public, nil).Health(); err != nil {
+				t.Fatal(err)
+			}
+			encrypted[len(encrypted)-1] ^= 0xff
+			if _, err := e.Decrypt(bytes.NewReader(encrypted)); err == nil {
+				t.Fatal("decrypted tampered data")
+			}
+		})
+	}
+	if _, err := NewEncryptManager("").WithECIES("key", nil).Encrypt(bytes.NewReader(data)); err == nil {
+		t.Fatal("encrypted using an unsupported key")
+	}
+	if _, err := NewEncryptManager("").WithECIES(&x25519Public, nil).Decrypt(bytes.NewReader(data)); err == nil {
+		t.Fatal("decrypted without a private key")
+	}
+}
+
+func Test_ParseECIESKeys(t *testing.T) {
+	edPublic, edPrivate, err := GenerateKeyPair(Ed25519Key, "helloworld")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkix, err := x509.MarshalPKIXPublicKey(&p256.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec1, err := x509.MarshalECPrivateKey(p256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var x25519Private, x25519Public [32]byte
+	if _, err := rand.Read(x25519Private[:]); err != nil {
+		t.Fatal(err)
+	}
+	curve25519.ScalarBaseMult(&x25519Public, &x25519Private)
+	libp2pPublic, libp2pPrivate := newLibp2pKeys(t, libp2pEd25519)
+	rsaPublic, _ := newLibp2pKeys(t, libp2pRSA)
+
+	tests := []struct {
+		name       string
+		public     []byte
+		private    []byte
+		passphrase string
+		wantErr    bool
+	}{
+		{"pem-ed25519", edPublic, edPrivate, "helloworld", false},
+		{"pem-ecdsa", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkix}),
+			pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}), "", false},
+		{"raw-x25519", x25519Public[:], x25519Private[:], "", false},
+		{"raw-ecdsa", elliptic.Marshal(elliptic.P256(), p256.X, p256.Y),
+			pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}), "", false},
+		{"der-private", pkix, sec1, "", true},
+		{"libp2p", libp2pPublic, libp2pPrivate, "", false},
+		{"libp2p-rsa", rsaPublic, nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			public, err := ParseECIESPublicKey(tt.public)
+			if err != nil {
+				if !tt.wantErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			private, err := ParseECIESPrivateKey(tt.private, tt.passphrase)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseECIESPrivateKey() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			encrypted, err := NewEncryptManager("").WithECIES(public, nil).Encrypt(bytes.NewReader([]byte("hello world")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err := NewEncryptManager("").WithECIES(nil, private).Decrypt(bytes.NewReader(encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(decrypted) != "hello world" {
+				t.Fatal("decrypted data does not match original")
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package crypto
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// Encoding is used to configure the text encoding of exported key material,
+// such as nonces, cipher keys, and fingerprints
+type Encoding string
+
+var (
+	// Hex encodes data as lowercase hexadecimal, the default
+	Hex Encoding = "hex"
+	// Base64 encodes data as standard, padded base64
+	Base64 Encoding = "base64"
+	// Multibase encodes data as multibase base64url, which is self describing
+	// through its prefix. Multibase base16, base32, and base64 are accepted when decoding
+	Multibase Encoding = "multibase"
+)
+
+// Encode returns data encoded using enc
+func (enc Encoding) Encode(data []byte) (string, error) {
+	switch enc {
+	case Hex:
+		return hex.EncodeToString(data), nil
+	case Base64:
+		return base64.StdEncoding.EncodeToString(data), nil
+	case Multibase:
+		return "u" + base64.RawURLEncoding.EncodeToString(data), nil
+	default:
+		return "", fmt.Errorf("unsupported encoding %s", enc)
+	}
+}
+
+// Decode returns the data encoded in s using enc
+func (enc Encoding) Decode(s string) ([]byte, error) {
+	switch enc {
+	case Hex:
+		return hex.DecodeString(s)
+	case Base64:
+		return base64.StdEncoding.DecodeString(s)
+	case Multibase:
+		if s == "" {
+			return nil, errors.New("invalid multibase data")
+		}
+		switch s[0] {
+		case 'f':
+			return hex.DecodeString(s[1:])
+		case 'b':
+			return base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding).DecodeString(s[1:])
+		case 'm':
+			return base64.RawStdEncoding.DecodeString(s[1:])
+		case 'u':
+			return base64.RawURLEncoding.DecodeString(s[1:])
+		default:
+			return nil, fmt.Errorf("unsupported multibase prefix %q", s[0])
+		}
+	default:
+		return nil, fmt.Errorf("unsupported encoding %s", enc)
+	}
+}
+
+// WithParamEncoding is used to select the encoding of the nonce and cipher key
+// in parameters returned by RetrieveGCMDecryptionParameters. Non hex encodings
+// are tagged in the parameters, and are understood by all parameter loaders.
+// Hex is the default, and produces the format used by Temporal
+func (e *EncryptManager) WithParamEncoding(enc Encoding) *EncryptManager {
+	e.paramEncoding = enc
+	return e
+}
+
+// formatGCMDecryptParams formats params using the given encoding
+func formatGCMDecryptParams(params *GCMDecryptParams, enc Encoding) (string, error) {
+	if enc == "" || enc == Hex {
+		return fmt.Sprintf("Nonce:\t%s\nCipherKey:\t%s", params.Nonce, params.CipherKey), nil
+	}
+	values := make([]string, 2)
+	for i, value := range []string{params.Nonce, params.CipherKey} {
+		decoded, err := hex.DecodeString(value)
+		if err != nil {
+			return "", err
+		}
+		if values[i], err = enc.Encode(decoded); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("Encoding:\t%s\nNonce:\t%s\nCipherKey:\t%s", enc, values[0], values[1]), nil
+}
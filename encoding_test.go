@@ -0,0 +1,96 @@
+package crypto
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_Encoding(t *testing.T) {
+	data := []byte{0xde, 0xad, 0xbe, 0xef, 0xff}
+	tests := []struct {
+		enc  Encoding
+		want string
+	}{
+		{Hex, "deadbeefff"},
+		{Base64, "3q2+7/8="},
+		{Multibase, "u3q2-7_8"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.enc), func(t *testing.T) {
+			encoded, err := tt.enc.Encode(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encoded != tt.want {
+				t.Fatalf("Encode = %s, want %s", encoded, tt.want)
+			}
+			decoded, err := tt.enc.Decode(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decoded, data) {
+				t.Fatalf("Decode = %x", decoded)
+			}
+		})
+	}
+	for _, multibase := range []string{"fdeadbeefff", "b32w35377", "m3q2+7/8"} {
+		decoded, err := Multibase.Decode(multibase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded, data) {
+			t.Fatalf("Decode(%s) = %x", multibase, decoded)
+		}
+	}
+	if _, err := Encoding("base58").Encode(data); err == nil {
+		t.Fatal("expected error using unsupported encoding")
+	}
+	if _, err := Multibase.Decode("zabc"); err == nil {
+		t.Fatal("expected error using unsupported multibase prefix")
+	}
+}
+
+func Test_EncryptManager_ParamEncoding(t *testing.T) {
+	for _, enc := range []Encoding{Hex, Base64, Multibase} {
+		for _, protocol := range []Protocol{GCM, AEAD} {
+			t.Run(string(enc)+"/"+string(protocol), func(t *testing.T) {
+				e := NewEncryptManager("helloworld").WithParamEncoding(enc)
+				d := NewEncryptManager("helloworld")
+				if protocol == AEAD {
+					e.WithAEAD(nil)
+					d.WithAEAD(nil)
+				} else {
+					e.WithGCM(nil)
+					d.WithGCM(nil)
+				}
+				encrypted, err := e.Encrypt(bytes.NewReader([]byte("hello world")))
+				if err != nil {
+					t.Fatal(err)
+				}
+				params, err := e.RetrieveGCMDecryptionParameters()
+				if err != nil {
+					t.Fatal(err)
+				}
+				formatted, err := NewEncryptManager("helloworld").Decrypt(bytes.NewReader(params))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tagged := strings.HasPrefix(string(formatted), "Encoding:\t"+string(enc)+"\n"); tagged == (enc == Hex) {
+					t.Fatalf("unexpected parameter format %q", formatted)
+				}
+				store := NewMemoryParamStore()
+				if err := store.Put("object", params); err != nil {
+					t.Fatal(err)
+				}
+				decrypted, err := d.LoadAndDecrypt(store, "object", bytes.NewReader(encrypted))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(decrypted) != "hello world" {
+					t.Fatalf("decrypted %s", decrypted)
+				}
+			})
+		}
+	}
+}
@@ -1,28 +1,39 @@
 package crypto
 
 import (
+	"bytes"
+	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"strings"
+	"sync"
+	"time"
 
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/openpgp"
 	"golang.org/x/crypto/pbkdf2"
 )
 
 const (
-	// if these settings take too long on your server or workstation feel free to modify
-	// however please keep in mind these are the settings that Temporal uses.
-	// thus if you want to decrypt a file which was encrypted by our Temporal node, you must
-	// ensure that the settings match as foillows: keylen = 32, saltlen = 32, nonceSize = 24
+	// these are the settings that Temporal uses. saltlen is the default salt
+	// length, and the PBKDF2 iteration count is 4096 unless set using
+	// WithPBKDF2Iterations, or WithSaltLength, which record the values used
+	// in the header of the encrypted data rather than relying on these
 	keylen    = 32
 	saltlen   = 32
 	nonceSize = 24
+	// standardNonceSize is the nonce size recommended for AES256-GCM, which
+	// may be selected using WithNonceSize for interoperability
+	standardNonceSize = 12
 )
 
 // Protocol is used to configure encryption/decryption methods
@@ -33,32 +44,139 @@ var (
 	GCM Protocol = "AES256-GCM"
 	// CFB allows for usage of AES256-CFB encryption/decryption
 	CFB Protocol = "AES256-CFB"
+	// AEAD allows for usage of the best authenticated cipher for the platform,
+	// falling back to XChaCha20-Poly1305 when hardware AES is unavailable
+	AEAD Protocol = "AEAD"
+	// GCMStream allows for usage of AES256-GCM in the STREAM construction, sealing
+	// data in authenticated segments so large files may be processed in constant memory
+	GCMStream Protocol = "GCM-STREAM"
+	// ChaCha20Poly1305 allows for usage of ChaCha20-Poly1305 encryption/decryption,
+	// which outperforms AES256-GCM on platforms without hardware AES
+	ChaCha20Poly1305 Protocol = "CHACHA20-POLY1305"
+	// XChaCha20Poly1305 allows for usage of XChaCha20-Poly1305 encryption/decryption,
+	// the extended nonce variant of ChaCha20-Poly1305 using a 24 byte nonce
+	XChaCha20Poly1305 Protocol = "XCHACHA20-POLY1305"
+	// RSA allows for usage of RSA public key encryption/decryption, wrapping a
+	// random AES256-GCM key using PKCS #1 v1.5, or RSA-OAEP
+	RSA Protocol = "RSA"
+	// IPFSKey allows for usage of libp2p keys, such as the keys of IPFS nodes,
+	// for public key encryption/decryption using a scheme suited to the key type
+	IPFSKey Protocol = "IPFS-KEY"
+	// ECIES allows for usage of elliptic curve public key encryption/decryption,
+	// using X25519, Ed25519, or ECDSA keys
+	ECIES Protocol = "ECIES"
+	// MultiRecipient allows for usage of encryption to several recipients, each
+	// decrypting using their own RSA, or elliptic curve private key
+	MultiRecipient Protocol = "MULTI-RECIPIENT"
+	// Archive allows for usage of the long-term archive mode, encrypting data
+	// using both AES256-GCM, and XChaCha20-Poly1305 under independent keys
+	Archive Protocol = "ARCHIVE"
+	// SecretBox allows for usage of NaCl secretbox encryption/decryption, using
+	// XSalsa20-Poly1305 with a random key, and nonce
+	SecretBox Protocol = "SECRETBOX"
+	// Box allows for usage of NaCl box public key encryption/decryption, using
+	// Curve25519 keys
+	Box Protocol = "BOX"
+	// PGP allows for usage of OpenPGP messages compatible with GnuPG, encrypted
+	// to OpenPGP public keys, or using a passphrase
+	PGP Protocol = "PGP"
+	// OpenSSL allows for usage of the AES256-CBC format of openssl enc, for
+	// compatibility with data encrypted by openssl
+	OpenSSL Protocol = "OPENSSL"
 )
 
 // EncryptManager handles file encryption and decryption
+//
+// Encrypt, Decrypt, and the retrieval of decryption parameters are safe for
+// concurrent use, however Encrypt replaces the decryption parameters of the
+// manager, so goroutines encrypting distinct objects should each use their own
+// copy obtained with Clone. Configuration methods (With*) should be called
+// before the manager is shared between goroutines
 type EncryptManager struct {
+	mux              sync.RWMutex
 	passphrase       []byte
 	gcmDecryptParams *GCMDecryptParams
 	protocol         Protocol
+	notarizer        Notarizer
+	notaryReceipt    []byte
+	approvalPolicy   *ApprovalPolicy
+	approvalObjectID string
+	approvalTokens   []ApprovalToken
+	tenant           string
+	usageReporter    UsageReporter
+	random           io.Reader
+	nonceLog         NonceLog
+	attestors        []Attestor
+	attestVerifiers  map[string]AttestationVerifier
+	validators       []ContentValidator
+	checksum         ChecksumAlgorithm
+	notBefore        time.Time
+	notAfter         time.Time
+	clock            Clock
+	paramEncoding    Encoding
+	kdf              *KDFConfig
+	kdfLimits        *KDFLimits
+	header           bool
+	rsaPublic        *rsa.PublicKey
+	rsaPrivate       crypto.Decrypter
+	rsaOAEPHash      crypto.Hash
+	ipfsPublic       []byte
+	ipfsPrivate      []byte
+	eciesPublic      interface{}
+	eciesPrivate     interface{}
+	recipients       []interface{}
+	recipientKey     interface{}
+	legacyCFB        bool
+	keyUsage         *KeyUsage
+	aad              []byte
+	objectBound      bool
+	receiptSigner    ed25519.PrivateKey
+	receiptKeyID     string
+	lastReceipt      *DecryptionReceipt
+	parallelism      int
+	dictionaries     []CompressionDictionary
+	paramFormat      ParamFormat
+	paramRecipient   interface{}
+	errorDetail      ErrorDetail
+	errorHandler     func(error)
+	keyWrapper       KeyWrapper
+	formatPolicy     *FormatPolicy
+	encryptHooks     []EncryptHook
+	hookMetadata     map[string]string
+	signer           crypto.Signer
+	verifyKeys       []crypto.PublicKey
+	signPlaintext    bool
+	spill            SpillConfig
+	gcmNonceSize     int
+	chunkSize        int
+	segmentKeys      bool
+	boxPublic        *[32]byte
+	boxPrivate       *[32]byte
+	pgpKeys          openpgp.EntityList
+	opensslConfig    *OpenSSLConfig
 }
 
 // GCMDecryptParams is used to configure decryption for AES256-GCM
 type GCMDecryptParams struct {
 	CipherKey string
 	Nonce     string
+	// usage holds the constraints of parameters unwrapped by UnwrapGCMDecryptionParameters
+	usage *KeyUsage
 }
 
 // NewEncryptManager creates a new EncryptManager
-// Default is CFB
+// Default is CFB. New supports further options
 func NewEncryptManager(passphrase string) *EncryptManager {
-	return &EncryptManager{
-		passphrase: []byte(passphrase),
-		protocol:   CFB}
+	// WithPassphrase never fails
+	e, _ := New(WithPassphrase(passphrase))
+	return e
 }
 
 // WithGCM is used setup, and return EncryptManager for use with AES256-GCM
 // the params are expected to be unencrypted, and in hex encoded string format
 func (e *EncryptManager) WithGCM(params *GCMDecryptParams) *EncryptManager {
+	e.mux.Lock()
+	defer e.mux.Unlock()
 	// set GCM protocol
 	e.protocol = GCM
 	// set decryption parameters
@@ -67,10 +185,171 @@ func (e *EncryptManager) WithGCM(params *GCMDecryptParams) *EncryptManager {
 	return e
 }
 
+// Clone returns a copy of the EncryptManager sharing its configuration, and
+// current decryption parameters, for use by another goroutine
+func (e *EncryptManager) Clone() *EncryptManager {
+	e.mux.RLock()
+	defer e.mux.RUnlock()
+	return &EncryptManager{
+		passphrase:       e.passphrase,
+		gcmDecryptParams: e.gcmDecryptParams,
+		protocol:         e.protocol,
+		notarizer:        e.notarizer,
+		notaryReceipt:    e.notaryReceipt,
+		approvalPolicy:   e.approvalPolicy,
+		approvalObjectID: e.approvalObjectID,
+		approvalTokens:   e.approvalTokens,
+		tenant:           e.tenant,
+		usageReporter:    e.usageReporter,
+		random:           e.random,
+		nonceLog:         e.nonceLog,
+		attestors:        e.attestors,
+		attestVerifiers:  e.attestVerifiers,
+		validators:       e.validators,
+		checksum:         e.checksum,
+		notBefore:        e.notBefore,
+		notAfter:         e.notAfter,
+		clock:            e.clock,
+		paramEncoding:    e.paramEncoding,
+		kdf:              e.kdf,
+		kdfLimits:        e.kdfLimits,
+		header:           e.header,
+		rsaPublic:        e.rsaPublic,
+		rsaPrivate:       e.rsaPrivate,
+		rsaOAEPHash:      e.rsaOAEPHash,
+		ipfsPublic:       e.ipfsPublic,
+		ipfsPrivate:      e.ipfsPrivate,
+		eciesPublic:      e.eciesPublic,
+		eciesPrivate:     e.eciesPrivate,
+		recipients:       e.recipients,
+		recipientKey:     e.recipientKey,
+		legacyCFB:        e.legacyCFB,
+		keyUsage:         e.keyUsage,
+		aad:              e.aad,
+		objectBound:      e.objectBound,
+		receiptSigner:    e.receiptSigner,
+		receiptKeyID:     e.receiptKeyID,
+		parallelism:      e.parallelism,
+		dictionaries:     e.dictionaries,
+		paramFormat:      e.paramFormat,
+		paramRecipient:   e.paramRecipient,
+		errorDetail:      e.errorDetail,
+		errorHandler:     e.errorHandler,
+		keyWrapper:       e.keyWrapper,
+		formatPolicy:     e.formatPolicy,
+		encryptHooks:     e.encryptHooks,
+		hookMetadata:     e.hookMetadata,
+		signer:           e.signer,
+		verifyKeys:       e.verifyKeys,
+		signPlaintext:    e.signPlaintext,
+		spill:            e.spill,
+		gcmNonceSize:     e.gcmNonceSize,
+		chunkSize:        e.chunkSize,
+		segmentKeys:      e.segmentKeys,
+		boxPublic:        e.boxPublic,
+		boxPrivate:       e.boxPrivate,
+		pgpKeys:          e.pgpKeys,
+		opensslConfig:    e.opensslConfig,
+	}
+}
+
+// WithRandom is used to override the source of randomness used to generate
+// keys, nonces, salts, and initialization vectors. This is intended for
+// deterministic testing, and defaults to crypto/rand
+func (e *EncryptManager) WithRandom(r io.Reader) *EncryptManager {
+	e.random = r
+	return e
+}
+
+// randomness returns the source of randomness in use
+func (e *EncryptManager) randomness() io.Reader {
+	if e.random == nil {
+		return rand.Reader
+	}
+	return e.random
+}
+
+// gcmNonceLength returns the AES256-GCM nonce size in use
+func (e *EncryptManager) gcmNonceLength() int {
+	if e.gcmNonceSize == 0 {
+		return nonceSize
+	}
+	return e.gcmNonceSize
+}
+
+// validGCMNonceSize reports whether n is a supported AES256-GCM nonce size,
+// being the standard 12 bytes, or the 24 bytes used by Temporal
+func validGCMNonceSize(n int) bool {
+	return n == standardNonceSize || n == nonceSize
+}
+
+// getProtocol returns the protocol currently in use
+func (e *EncryptManager) getProtocol() Protocol {
+	e.mux.RLock()
+	defer e.mux.RUnlock()
+	return e.protocol
+}
+
+// getGCMDecryptParams returns the current decryption parameters
+func (e *EncryptManager) getGCMDecryptParams() *GCMDecryptParams {
+	e.mux.RLock()
+	defer e.mux.RUnlock()
+	return e.gcmDecryptParams
+}
+
 // Encrypt is used to handle encryption of objects
 func (e *EncryptManager) Encrypt(r io.Reader) ([]byte, error) {
-	var out []byte
-	switch e.protocol {
+	res, err := e.encrypt(r, e.header)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.afterEncrypt("", res.Data); err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// encrypt encrypts r, prefixing the output with a self-describing header when requested
+func (e *EncryptManager) encrypt(r io.Reader, header bool) (*EncryptResult, error) {
+	return e.encryptSigned(r, header, nil)
+}
+
+// encryptSigned implements encrypt, recording the given signature of the
+// plaintext in the header instead of signing it, when one is given
+func (e *EncryptManager) encryptSigned(r io.Reader, header bool, signature []byte) (*EncryptResult, error) {
+	var (
+		out    []byte
+		params *GCMDecryptParams
+	)
+	// count the plaintext bytes for usage reporting
+	var counter *countingReader
+	if r != nil && e.usageReporter != nil {
+		counter = &countingReader{r: r}
+		r = counter
+	}
+	if err := e.checkProtocol(e.getProtocol()); err != nil {
+		return nil, err
+	}
+	// the signature of the plaintext is recorded in the header
+	if header && e.signPlaintext && r != nil && signature == nil {
+		// the plaintext is read twice, so is buffered
+		buf := NewSpillBuffer(e.spill)
+		defer buf.Close()
+		if _, err := io.Copy(buf, r); err != nil {
+			return nil, err
+		}
+		plaintext, err := buf.Reader()
+		if err != nil {
+			return nil, err
+		}
+		if signature, err = e.Sign(plaintext); err != nil {
+			return nil, err
+		}
+		if r, err = buf.Reader(); err != nil {
+			return nil, err
+		}
+	}
+	switch e.getProtocol() {
 	case GCM:
 		encryptedData, nonce, cipherKey, err := e.encryptGCM(r)
 		if err != nil {
@@ -79,7 +358,7 @@ func (e *EncryptManager) Encrypt(r io.Reader) ([]byte, error) {
 		// set encrypted data output
 		out = encryptedData
 		// set gcm decrypt params
-		e.gcmDecryptParams = &GCMDecryptParams{
+		params = &GCMDecryptParams{
 			CipherKey: hex.EncodeToString(cipherKey),
 			Nonce:     hex.EncodeToString(nonce),
 		}
@@ -89,10 +368,134 @@ func (e *EncryptManager) Encrypt(r io.Reader) ([]byte, error) {
 			return nil, err
 		}
 		out = encryptedData
+	case AEAD, Archive:
+		id := preferredAEAD()
+		if e.getProtocol() == Archive {
+			id = aeadArchive
+		}
+		encryptedData, nonce, cipherKey, err := e.encryptAEAD(r, id)
+		if err != nil {
+			return nil, err
+		}
+		out = encryptedData
+		params = &GCMDecryptParams{
+			CipherKey: hex.EncodeToString(cipherKey),
+			Nonce:     hex.EncodeToString(nonce),
+		}
+	case ChaCha20Poly1305, XChaCha20Poly1305:
+		encryptedData, nonce, cipherKey, err := e.encryptChaCha(e.getProtocol(), r)
+		if err != nil {
+			return nil, err
+		}
+		out = encryptedData
+		params = &GCMDecryptParams{
+			CipherKey: hex.EncodeToString(cipherKey),
+			Nonce:     hex.EncodeToString(nonce),
+		}
+	case GCMStream:
+		if r == nil {
+			return nil, errors.New("invalid content provided")
+		}
+		var buf bytes.Buffer
+		var err error
+		if params, err = e.encryptSegments(&buf, r, aeadAES256GCM); err != nil {
+			return nil, err
+		}
+		out = buf.Bytes()
+	case RSA:
+		encryptedData, err := e.encryptRSA(r)
+		if err != nil {
+			return nil, err
+		}
+		out = encryptedData
+	case IPFSKey:
+		encryptedData, err := e.encryptIPFSKey(r)
+		if err != nil {
+			return nil, err
+		}
+		out = encryptedData
+	case ECIES:
+		encryptedData, err := e.encryptECIES(r)
+		if err != nil {
+			return nil, err
+		}
+		out = encryptedData
+	case MultiRecipient:
+		encryptedData, err := e.encryptRecipients(r)
+		if err != nil {
+			return nil, err
+		}
+		out = encryptedData
+	case SecretBox:
+		encryptedData, nonce, cipherKey, err := e.encryptSecretBox(r)
+		if err != nil {
+			return nil, err
+		}
+		out = encryptedData
+		params = &GCMDecryptParams{
+			CipherKey: hex.EncodeToString(cipherKey),
+			Nonce:     hex.EncodeToString(nonce),
+		}
+	case Box:
+		encryptedData, err := e.encryptBox(r)
+		if err != nil {
+			return nil, err
+		}
+		out = encryptedData
+	case PGP:
+		encryptedData, err := e.encryptPGP(r)
+		if err != nil {
+			return nil, err
+		}
+		out = encryptedData
+	case OpenSSL:
+		encryptedData, err := e.encryptOpenSSL(r)
+		if err != nil {
+			return nil, err
+		}
+		out = encryptedData
 	default:
-		return nil, fmt.Errorf("no protocol specified")
+		impl, ok := registeredProtocol(e.getProtocol())
+		if !ok {
+			return nil, fmt.Errorf("no protocol specified")
+		}
+		encryptedData, nonce, cipherKey, err := e.encryptRegistered(impl, r)
+		if err != nil {
+			return nil, err
+		}
+		out = encryptedData
+		params = &GCMDecryptParams{
+			CipherKey: hex.EncodeToString(cipherKey),
+			Nonce:     hex.EncodeToString(nonce),
+		}
 	}
-	return out, nil
+	res, err := e.newEncryptResult(out, params)
+	if err != nil {
+		return nil, err
+	}
+	res.Signature = signature
+	if header {
+		if res.Data, err = addHeader(res); err != nil {
+			return nil, err
+		}
+	}
+	// submit the encrypted data to the transparency log if configured
+	receipt, err := e.notarize(res.Data)
+	if err != nil {
+		return nil, err
+	}
+	e.mux.Lock()
+	if params != nil {
+		e.gcmDecryptParams = params
+	}
+	if receipt != nil {
+		e.notaryReceipt = receipt
+	}
+	e.mux.Unlock()
+	if counter != nil {
+		e.reportUsage(OperationEncrypt, counter.n)
+	}
+	return res, nil
 }
 
 //eEncryptGCM encrypts given io.Reader using AES256-GCM
@@ -103,18 +506,21 @@ func (e *EncryptManager) encryptGCM(r io.Reader) ([]byte, []byte, []byte, error)
 	}
 	// create a 32bit cipher key allowing usage for AES256-GCM
 	cipherKeyBytes := make([]byte, 32)
-	if _, err := rand.Read(cipherKeyBytes); err != nil {
+	if _, err := io.ReadFull(e.randomness(), cipherKeyBytes); err != nil {
 		return nil, nil, nil, err
 	}
-	nonce := make([]byte, nonceSize)
-	if _, err := rand.Read(nonce); err != nil {
+	nonce := make([]byte, e.gcmNonceLength())
+	if _, err := io.ReadFull(e.randomness(), nonce); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := e.recordNonce(cipherKeyBytes, nonce); err != nil {
 		return nil, nil, nil, err
 	}
 	block, err := aes.NewCipher(cipherKeyBytes)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	aesGCM, err := cipher.NewGCMWithNonceSize(block, 24)
+	aesGCM, err := cipher.NewGCMWithNonceSize(block, len(nonce))
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -122,24 +528,30 @@ func (e *EncryptManager) encryptGCM(r io.Reader) ([]byte, []byte, []byte, error)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	return aesGCM.Seal(nil, nonce, dataToEncrypt, nil), nonce, cipherKeyBytes, nil
+	return aesGCM.Seal(nil, nonce, dataToEncrypt, e.additionalData()), nonce, cipherKeyBytes, nil
 }
 
 // EncryptCFB encrypts given io.Reader using AES256CFB
-// the resultant bytes are returned
+// the resultant bytes are returned, authenticated using HMAC-SHA-256
 func (e *EncryptManager) encryptCFB(r io.Reader) ([]byte, error) {
 	if r == nil {
 		return nil, errors.New("invalid content provided")
 	}
 
 	// generate salt, encrypt password for use as a key for a cipher
-	salt := make([]byte, saltlen)
-	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+	salt := make([]byte, e.kdf.saltLength())
+	if _, err := io.ReadFull(e.randomness(), salt); err != nil {
 		return nil, err
 	}
-	// using sha512 is safer than sha256, but should also be faster on 64bit platforms
-	key := pbkdf2.Key(e.passphrase, salt, 4096, keylen, sha512.New)
-	block, err := aes.NewCipher(key)
+	key, err := e.cfbKey(e.kdf, salt)
+	if err != nil {
+		return nil, err
+	}
+	encKey, macKey, err := cfbKeys(key)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(encKey)
 	if err != nil {
 		return nil, err
 	}
@@ -153,7 +565,10 @@ func (e *EncryptManager) encryptCFB(r io.Reader) ([]byte, error) {
 	// generate an intialization vector for encryption
 	encrypted := make([]byte, aes.BlockSize+len(b))
 	iv := encrypted[:aes.BlockSize]
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+	if _, err := io.ReadFull(e.randomness(), iv); err != nil {
+		return nil, err
+	}
+	if err := e.recordNonce(encKey, iv); err != nil {
 		return nil, err
 	}
 
@@ -161,37 +576,146 @@ func (e *EncryptManager) encryptCFB(r io.Reader) ([]byte, error) {
 	stream := cipher.NewCFBEncrypter(block, iv)
 	stream.XORKeyStream(encrypted[aes.BlockSize:], b)
 
-	// attach salt to end of encrypted content
-	encrypted = append(encrypted, salt...)
+	// assemble the output, recording the key derivation function when configured
+	out, err := cfbOutput(e.kdf, iv, encrypted[aes.BlockSize:], salt, nil)
+	if err != nil {
+		return nil, err
+	}
+	out = append(cfbMACMagic, out...)
+
+	// authenticate everything, including the salt
+	h := hmac.New(sha256.New, macKey)
+	h.Write(out)
+	return h.Sum(out), nil
+}
 
-	return encrypted, nil
+// deriveKey derives an encryption key from the passphrase using the given salt
+func (e *EncryptManager) deriveKey(salt []byte) []byte {
+	// using sha512 is safer than sha256, but should also be faster on 64bit platforms
+	return pbkdf2.Key(e.passphrase, salt, 4096, keylen, sha512.New)
 }
 
 // RetrieveGCMDecryptionParameters is used to retrieve GCM cipher and nonce
 // before returning, the cipher and nonce data are formatted, and encrypted
 func (e *EncryptManager) RetrieveGCMDecryptionParameters() ([]byte, error) {
-	if e.gcmDecryptParams == nil {
+	params := e.getGCMDecryptParams()
+	if params == nil {
 		return nil, errors.New("gcm decryption parameters is empty")
 	}
-	return e.encryptCFB(
-		strings.NewReader(fmt.Sprintf(
-			"Nonce:\t%s\nCipherKey:\t%s",
-			e.gcmDecryptParams.Nonce, e.gcmDecryptParams.CipherKey)))
+	if err := e.checkKeyExport(false); err != nil {
+		return nil, err
+	}
+	if e.paramRecipient != nil {
+		return e.wrapGCMDecryptParams()
+	}
+	serialized, err := e.serializeGCMDecryptParams(params)
+	if err != nil {
+		return nil, err
+	}
+	return e.encryptCFB(bytes.NewReader(serialized))
 }
 
 // Decrypt is used to handle decryption of the io.Reader
 func (e *EncryptManager) Decrypt(r io.Reader) ([]byte, error) {
-	switch e.protocol {
+	if e.receiptSigner != nil {
+		out, receipt, err := e.DecryptWithReceipt(r, "")
+		if err != nil {
+			return nil, err
+		}
+		e.mux.Lock()
+		e.lastReceipt = receipt
+		e.mux.Unlock()
+		return out, nil
+	}
+	out, err := e.decryptObject(r)
+	if err != nil {
+		return nil, e.decryptError(err)
+	}
+	return out, nil
+}
+
+// decryptObject decrypts r, enforcing approvals, and content validators
+func (e *EncryptManager) decryptObject(r io.Reader) ([]byte, error) {
+	// ensure any required approvals are present before touching key material
+	if err := e.checkApprovals(); err != nil {
+		return nil, err
+	}
+	out, err := e.decrypt(r)
+	if err != nil {
+		return nil, err
+	}
+	// never return content rejected by a validator
+	if err := e.validateContent(out); err != nil {
+		return nil, err
+	}
+	e.reportUsage(OperationDecrypt, int64(len(out)))
+	return out, nil
+}
+
+// decrypt dispatches decryption to the protocol recorded in the header
+// of the encrypted data, or otherwise the configured protocol
+func (e *EncryptManager) decrypt(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	data, err := e.readContent(r)
+	if err != nil {
+		return nil, err
+	}
+	if h, ok := parseHeader(data); ok {
+		out, err := e.decryptHeader(h)
+		if err != nil {
+			return nil, err
+		}
+		if err := e.verifyPlaintext(h.signature, out); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+	if err := e.verifyPlaintext(nil, nil); err != nil {
+		return nil, err
+	}
+	return e.decryptProtocol(bytes.NewReader(data))
+}
+
+// decryptProtocol dispatches decryption to the configured protocol
+func (e *EncryptManager) decryptProtocol(r io.Reader) ([]byte, error) {
+	switch e.getProtocol() {
 	case CFB:
 		return e.decryptCFB(r)
 	case GCM:
 		return e.decryptGCM(r)
 	case GCM:
-		if e.gcmDecryptParams == nil {
+		if e.getGCMDecryptParams() == nil {
 			return nil, errors.New("no gcm decryption parameters given")
 		}
 		return e.decryptGCM(r)
+	case AEAD, Archive:
+		return e.decryptAEAD(r)
+	case GCMStream:
+		return e.decryptGCMStream(r)
+	case ChaCha20Poly1305, XChaCha20Poly1305:
+		return e.decryptChaCha(e.getProtocol(), r)
+	case RSA:
+		return e.decryptRSA(r)
+	case IPFSKey:
+		return e.decryptIPFSKey(r)
+	case ECIES:
+		return e.decryptECIES(r)
+	case MultiRecipient:
+		return e.decryptRecipients(r)
+	case SecretBox:
+		return e.decryptSecretBox(r)
+	case Box:
+		return e.decryptBox(r)
+	case PGP:
+		return e.decryptPGP(r)
+	case OpenSSL:
+		return e.decryptOpenSSL(r)
 	default:
+		if impl, ok := registeredProtocol(e.getProtocol()); ok {
+			return e.decryptRegistered(impl, r)
+		}
 		return nil, fmt.Errorf("invalid invocation, must be one of\nAES256-GCM: EncryptManager::WithGCM::Decrypt\nAES256-CFB: EncryptManager::WithCFB:Decrypt")
 	}
 }
@@ -199,25 +723,19 @@ func (e *EncryptManager) Decrypt(r io.Reader) ([]byte, error) {
 // DecryptGCM is used to decrypt the given io.Reader using a specified key and nonce
 // the key and nonce are expected to be in the format of hex.EncodeToString
 func (e *EncryptManager) decryptGCM(r io.Reader) ([]byte, error) {
-	if e.gcmDecryptParams == nil {
-		return nil, errors.New("gcm decryption parameters is null")
-	}
-	// decode the key
-	decodedKey, err := hex.DecodeString(e.gcmDecryptParams.CipherKey)
-	if err != nil {
-		return nil, err
-	}
-	// decode the nonce
-	decodedNonce, err := hex.DecodeString(e.gcmDecryptParams.Nonce)
+	decodedKey, decodedNonce, err := e.decryptionKey()
 	if err != nil {
 		return nil, err
 	}
 
+	if !validGCMNonceSize(len(decodedNonce)) {
+		return nil, errors.New("invalid gcm nonce")
+	}
 	block, err := aes.NewCipher(decodedKey)
 	if err != nil {
 		return nil, err
 	}
-	aesGCM, err := cipher.NewGCMWithNonceSize(block, nonceSize)
+	aesGCM, err := cipher.NewGCMWithNonceSize(block, len(decodedNonce))
 	if err != nil {
 		return nil, err
 	}
@@ -225,12 +743,37 @@ func (e *EncryptManager) decryptGCM(r io.Reader) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	return aesGCM.Open(nil, decodedNonce, encryptedData, nil)
+	return openAEAD(aesGCM, nil, decodedNonce, encryptedData, e.additionalData(), e.objectBound)
+}
+
+// decodeGCMDecryptParams returns the decoded cipher key and nonce
+func (e *EncryptManager) decodeGCMDecryptParams() ([]byte, []byte, error) {
+	params := e.getGCMDecryptParams()
+	if params == nil {
+		return nil, nil, errors.New("gcm decryption parameters is null")
+	}
+	// decode the key
+	decodedKey, err := hex.DecodeString(params.CipherKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	// decode the nonce
+	decodedNonce, err := hex.DecodeString(params.Nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	return decodedKey, decodedNonce, nil
 }
 
 // DecryptCFB decrypts given io.Reader which was encrypted using AES256-CFB
 // the resulting decrypt bytes are returned
 func (e *EncryptManager) decryptCFB(r io.Reader) ([]byte, error) {
+	return e.decryptCFBWith(r, e.legacyCFB)
+}
+
+// decryptCFBWith decrypts given io.Reader which was encrypted using AES256-CFB,
+// accepting the unauthenticated legacy format if legacy is set
+func (e *EncryptManager) decryptCFBWith(r io.Reader, legacy bool) ([]byte, error) {
 	if r == nil {
 		return nil, errors.New("invalid content provided")
 	}
@@ -241,13 +784,51 @@ func (e *EncryptManager) decryptCFB(r io.Reader) ([]byte, error) {
 		return nil, err
 	}
 
+	// strip, and hold back the mac of authenticated content
+	authenticated := bytes.HasPrefix(raw, cfbMACMagic)
+	var signed, mac []byte
+	switch {
+	case authenticated:
+		if len(raw) < len(cfbMACMagic)+cfbMACSize {
+			return nil, errors.New("invalid content provided")
+		}
+		signed, mac = raw[:len(raw)-cfbMACSize], raw[len(raw)-cfbMACSize:]
+		raw = signed[len(cfbMACMagic):]
+	case !legacy:
+		return nil, ErrUnauthenticatedCFB
+	}
+
+	// strip the key derivation function header if present
+	kdf, n, err := parseKDFHeader(raw)
+	if err != nil {
+		return nil, err
+	}
+	raw = raw[n:]
+
+	// ensure the contents hold at least the iv and salt
+	size := kdf.saltLength()
+	if len(raw) < aes.BlockSize+size {
+		return nil, errors.New("invalid content provided")
+	}
+
 	// retrieve and remove salt
-	salt := raw[len(raw)-saltlen:]
-	raw = raw[:len(raw)-saltlen]
+	salt := raw[len(raw)-size:]
+	raw = raw[:len(raw)-size]
 
-	// generate cipher
-	// using sha512 is safer than sha256, but should also be faster on 64bit platforms
-	key := pbkdf2.Key(e.passphrase, salt, 4096, keylen, sha512.New)
+	// generate cipher, verifying authenticated content first
+	key, err := e.cfbKey(kdf, salt)
+	if err != nil {
+		return nil, err
+	}
+	if authenticated {
+		var macKey []byte
+		if key, macKey, err = cfbKeys(key); err != nil {
+			return nil, err
+		}
+		if err := verifyCFBMAC(macKey, signed, mac); err != nil {
+			return nil, err
+		}
+	}
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
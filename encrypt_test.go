@@ -3,10 +3,12 @@ package crypto
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"io"
 	"io/ioutil"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -173,6 +175,7 @@ func Test_EncryptManager_AES256_CFB(t *testing.T) {
 			// we need to fake some data to decrypt
 			if tt.wantErr {
 				dataToDecrypt = []byte("somesillyfakedatatotesthello12345678910111213141")
+				e.WithLegacyCFB()
 			}
 			// decrypt
 			decrypted, err := e.Decrypt(bytes.NewReader(dataToDecrypt))
@@ -186,3 +189,56 @@ func Test_EncryptManager_AES256_CFB(t *testing.T) {
 		})
 	}
 }
+
+func Test_EncryptManager_Concurrent(t *testing.T) {
+	original := []byte("concurrent encryption test data")
+	shared := NewEncryptManager("helloworld").WithGCM(nil)
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		// each goroutine encrypting objects uses its own clone
+		go func() {
+			defer wg.Done()
+			e := shared.Clone()
+			encrypted, err := e.Encrypt(bytes.NewReader(original))
+			if err != nil {
+				errs <- err
+				return
+			}
+			decrypted, err := e.Decrypt(bytes.NewReader(encrypted))
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !bytes.Equal(decrypted, original) {
+				errs <- errors.New("decrypted data does not match original")
+			}
+		}()
+		// while the shared manager may be used concurrently
+		go func() {
+			defer wg.Done()
+			if _, err := shared.Encrypt(bytes.NewReader(original)); err != nil {
+				errs <- err
+				return
+			}
+			if _, err := shared.RetrieveGCMDecryptionParameters(); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	// clones must not share state with the original
+	clone := shared.Clone()
+	before := shared.getGCMDecryptParams()
+	if _, err := clone.Encrypt(bytes.NewReader(original)); err != nil {
+		t.Fatal(err)
+	}
+	if shared.getGCMDecryptParams() != before {
+		t.Fatal("encrypting with clone modified original decryption parameters")
+	}
+}
@@ -0,0 +1,97 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrEntropyFailure is returned by an EntropyMonitor once its source of
+// randomness has failed a health test
+var ErrEntropyFailure = errors.New("randomness source failed health test")
+
+const (
+	// entropyRepetitionCutoff is the number of consecutive identical bytes
+	// failing the repetition count test of NIST SP 800-90B, assuming at least
+	// one bit of entropy per byte, for a false positive rate of 2^-20
+	entropyRepetitionCutoff = 21
+	// entropyBlockSize is the size of the blocks compared by the continuous
+	// random number generator test of FIPS 140-2
+	entropyBlockSize = 16
+)
+
+// EntropyMonitor wraps a source of randomness, continuously testing all bytes
+// read from it for failures such as a stuck, or repeating source. Once a test
+// fails, the failure callback is called, and all further reads fail with
+// ErrEntropyFailure, so no keys, or nonces are generated from a broken source.
+// It is used with WithRandom, ie for deployments on VMs, or embedded boards
+type EntropyMonitor struct {
+	mux       sync.Mutex
+	r         io.Reader
+	onFailure func(error)
+	last      byte
+	run       int
+	block     []byte
+	prevBlock []byte
+	err       error
+}
+
+// NewEntropyMonitor is used to monitor r, or crypto/rand if nil, calling
+// onFailure, if not nil, once when a health test fails
+func NewEntropyMonitor(r io.Reader, onFailure func(error)) *EntropyMonitor {
+	if r == nil {
+		r = rand.Reader
+	}
+	return &EntropyMonitor{r: r, onFailure: onFailure}
+}
+
+// Read reads from the monitored source, testing all bytes read
+func (m *EntropyMonitor) Read(p []byte) (int, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.err != nil {
+		return 0, m.err
+	}
+	n, err := m.r.Read(p)
+	for _, b := range p[:n] {
+		if !m.test(b) {
+			m.err = ErrEntropyFailure
+			if m.onFailure != nil {
+				m.onFailure(m.err)
+			}
+			return 0, m.err
+		}
+	}
+	return n, err
+}
+
+// test applies the repetition count, and continuous block tests to b,
+// returning false if either fails
+func (m *EntropyMonitor) test(b byte) bool {
+	if m.run > 0 && b == m.last {
+		m.run++
+	} else {
+		m.last, m.run = b, 1
+	}
+	if m.run >= entropyRepetitionCutoff {
+		return false
+	}
+	m.block = append(m.block, b)
+	if len(m.block) < entropyBlockSize {
+		return true
+	}
+	if bytes.Equal(m.block, m.prevBlock) {
+		return false
+	}
+	m.prevBlock, m.block = m.block, make([]byte, 0, entropyBlockSize)
+	return true
+}
+
+// Health returns ErrEntropyFailure if a health test has failed
+func (m *EntropyMonitor) Health() error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.err
+}
@@ -0,0 +1,86 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+// repeatingReader endlessly repeats the same bytes
+type repeatingReader struct {
+	pattern []byte
+}
+
+func (r *repeatingReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r.pattern[i%len(r.pattern)]
+	}
+	return len(p), nil
+}
+
+func Test_EntropyMonitor(t *testing.T) {
+	tests := []struct {
+		name    string
+		r       io.Reader
+		wantErr bool
+	}{
+		{"crypto-rand", nil, false},
+		{"stuck", bytes.NewReader(make([]byte, 64)), true},
+		{"repeating", &repeatingReader{pattern: []byte("0123456789abcdef")}, true},
+		{"short-repeats", bytes.NewReader(append(bytes.Repeat([]byte{7}, entropyRepetitionCutoff-1), []byte("abcdefghijklmnopqrstuvwxyz0123456789ABCDEFGH")...)), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var failures []error
+			m := NewEntropyMonitor(tt.r, func(err error) { failures = append(failures, err) })
+			_, err := io.ReadFull(m, make([]byte, 64))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Read() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				if len(failures) != 0 || m.Health() != nil {
+					t.Fatal("unexpected failure")
+				}
+				return
+			}
+			if err != ErrEntropyFailure || m.Health() != ErrEntropyFailure {
+				t.Fatalf("err = %v, want %v", err, ErrEntropyFailure)
+			}
+			// failures are permanent, and reported once
+			if _, err := m.Read(make([]byte, 1)); err != ErrEntropyFailure {
+				t.Fatalf("err = %v, want %v", err, ErrEntropyFailure)
+			}
+			if len(failures) != 1 {
+				t.Fatalf("failure callback called %d times, want 1", len(failures))
+			}
+		})
+	}
+}
+
+func Test_EntropyMonitor_EncryptManager(t *testing.T) {
+	data := []byte("hello world")
+	e := NewEncryptManager("helloworld").WithGCM(nil).WithRandom(NewEntropyMonitor(rand.Reader, nil))
+	if err := e.Health(); err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := e.Encrypt(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := e.Decrypt(bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Fatal("decrypted data does not match original")
+	}
+	// a broken source fails encryption, and health checks
+	broken := NewEncryptManager("helloworld").WithGCM(nil).WithRandom(NewEntropyMonitor(&repeatingReader{pattern: []byte{0}}, nil))
+	if _, err := broken.Encrypt(bytes.NewReader(data)); err == nil {
+		t.Fatal("encrypted using a broken source of randomness")
+	}
+	if err := broken.Health(); err == nil {
+		t.Fatal("expected health check to fail")
+	}
+}
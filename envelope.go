@@ -0,0 +1,258 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// envelopeVersion is the current version of the Envelope format. Version 2
+// adds compression, version 3 data keys protected by a KeyWrapper, version 4
+// signatures of the plaintext, and version 5 approval bindings. Each is only
+// produced for envelopes using them, so other envelopes remain readable by
+// earlier versions
+const envelopeVersion = 5
+
+// Envelope holds the metadata required to decrypt a payload, allowing it to be
+// stored separately from the bulk encrypted data, ie metadata in a database
+// while the payload is stored in object storage or IPFS. Key material is only
+// present in encrypted form, and can only be recovered using the passphrase,
+// or the KeyWrapper set using WithKeyWrapper
+type Envelope struct {
+	// Version is the oldest version of the format able to read the envelope.
+	// Envelopes claiming an older version than their contents require are refused
+	Version  int      `json:"version"`
+	Protocol Protocol `json:"protocol"`
+	// IV is the initialization vector used by AES256-CFB
+	IV []byte `json:"iv,omitempty"`
+	// Salt is the salt used to derive the AES256-CFB key from the passphrase
+	Salt []byte `json:"salt,omitempty"`
+	// KDF is the key derivation function used for AES256-CFB, if configured using WithKDF
+	KDF *KDFConfig `json:"kdf,omitempty"`
+	// MAC authenticates the AES256-CFB payload, and is absent from envelopes
+	// produced by earlier versions
+	MAC []byte `json:"mac,omitempty"`
+	// Cipher is the cipher selected by the AEAD profile
+	Cipher byte `json:"cipher,omitempty"`
+	// Params are the decryption parameters as returned by RetrieveGCMDecryptionParameters
+	Params []byte `json:"params,omitempty"`
+	// Attestations are externally generated proofs about the payload
+	Attestations []Attestation `json:"attestations,omitempty"`
+	// Checksum is the checksum of the payload, if configured using WithChecksum
+	Checksum *Checksum `json:"checksum,omitempty"`
+	// Validity is the period during which decryption is allowed, if configured using WithValidity
+	Validity *Validity `json:"validity,omitempty"`
+	// Compression describes how the plaintext was compressed before
+	// encryption, if configured using WithCompressionDictionaries
+	Compression *Compression `json:"compression,omitempty"`
+	// WrappedKey is the data key protected by a KeyWrapper, used in place of
+	// Params when configured using WithKeyWrapper
+	WrappedKey *WrappedKey `json:"wrapped_key,omitempty"`
+	// Signature is the signature of the plaintext, recording the ID of the
+	// signing key, if configured using WithSignedPlaintext
+	Signature []byte `json:"signature,omitempty"`
+	// Approval binds the envelope to an approval policy, and object, if
+	// encrypted using WithApprovalGate
+	Approval *ApprovalBinding `json:"approval,omitempty"`
+}
+
+// EncryptSplit is used to encrypt r, returning the metadata required for
+// decryption, and the encrypted payload as separate artifacts
+func (e *EncryptManager) EncryptSplit(r io.Reader) (*Envelope, []byte, error) {
+	protocol := e.getProtocol()
+	// attestors, compression, and signatures require access to the plaintext
+	var plaintext, signature []byte
+	if (len(e.attestors) > 0 || len(e.dictionaries) > 0 || e.signPlaintext) && r != nil {
+		var err error
+		if plaintext, err = ioutil.ReadAll(r); err != nil {
+			return nil, nil, err
+		}
+		r = bytes.NewReader(plaintext)
+	}
+	if e.signPlaintext && r != nil {
+		var err error
+		if signature, err = e.Sign(bytes.NewReader(plaintext)); err != nil {
+			return nil, nil, err
+		}
+	}
+	var compression *Compression
+	if len(e.dictionaries) > 0 && r != nil {
+		compressed, c, err := e.compress(plaintext)
+		if err != nil {
+			return nil, nil, err
+		}
+		r, compression = bytes.NewReader(compressed), c
+	}
+	// the envelope describes the payload, so no header is needed
+	res, err := e.encrypt(r, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	encrypted := res.Data
+	env := &Envelope{Protocol: protocol, Compression: compression, Signature: signature}
+	var payload []byte
+	switch protocol {
+	case CFB:
+		env.KDF, env.IV, env.Salt, env.MAC = res.KDF, res.IV, res.Salt, res.MAC
+		if payload, err = res.cfbCiphertext(); err != nil {
+			return nil, nil, err
+		}
+	case GCM, GCMStream, ChaCha20Poly1305, XChaCha20Poly1305, RSA, IPFSKey, ECIES, MultiRecipient, SecretBox, Box, PGP, OpenSSL:
+		payload = encrypted
+	case AEAD, Archive:
+		// AEAD output is prefixed with the selected cipher
+		env.Cipher = encrypted[0]
+		payload = encrypted[1:]
+	default:
+		if _, ok := registeredProtocol(protocol); !ok {
+			return nil, nil, fmt.Errorf("unsupported protocol %s", protocol)
+		}
+		payload = encrypted
+	}
+	switch {
+	case e.hasDecryptParams() && e.keyWrapper != nil:
+		if env.WrappedKey, err = e.wrapDataKey(); err != nil {
+			return nil, nil, err
+		}
+	case e.hasDecryptParams():
+		if env.Params, err = e.RetrieveGCMDecryptionParameters(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if env.Attestations, err = e.attest(plaintext, payload); err != nil {
+		return nil, nil, err
+	}
+	if e.checksum != "" {
+		if env.Checksum, err = ComputeChecksum(e.checksum, payload); err != nil {
+			return nil, nil, err
+		}
+	}
+	if env.Validity, err = e.validity(payload); err != nil {
+		return nil, nil, err
+	}
+	if env.Approval, err = e.approvalBinding(payload); err != nil {
+		return nil, nil, err
+	}
+	// record the oldest version able to read the envelope, unless the policy
+	// requires a newer one
+	env.Version = env.minVersion()
+	if e.formatPolicy != nil && env.Version < e.formatPolicy.MinEnvelopeVersion {
+		env.Version = e.formatPolicy.MinEnvelopeVersion
+	}
+	if len(e.encryptHooks) > 0 {
+		digest := sha256.Sum256(payload)
+		event := EncryptionEvent{Digest: digest[:], Size: int64(len(payload))}
+		if env.WrappedKey != nil {
+			event.KeyID = env.WrappedKey.KeyID
+		}
+		if err := e.runEncryptHooks(event); err != nil {
+			return nil, nil, err
+		}
+	}
+	return env, payload, nil
+}
+
+// DecryptSplit is used to decrypt a payload produced by EncryptSplit using its envelope.
+// The protocol, and decryption parameters of the EncryptManager are not modified
+func (e *EncryptManager) DecryptSplit(env *Envelope, payload io.Reader) ([]byte, error) {
+	out, err := e.decryptSplit(env, payload)
+	if err != nil {
+		return nil, e.decryptError(err)
+	}
+	return out, nil
+}
+
+// decryptSplit implements DecryptSplit, returning the cause of failures
+func (e *EncryptManager) decryptSplit(env *Envelope, payload io.Reader) ([]byte, error) {
+	if env == nil {
+		return nil, errors.New("no envelope provided")
+	}
+	if env.Version < 1 || env.Version > envelopeVersion {
+		return nil, fmt.Errorf("unsupported envelope version %d", env.Version)
+	}
+	if err := e.checkEnvelope(env); err != nil {
+		return nil, err
+	}
+	if payload == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	data, err := e.readContent(payload)
+	if err != nil {
+		return nil, err
+	}
+	if env.Checksum != nil {
+		if err := env.Checksum.Verify(data); err != nil {
+			return nil, err
+		}
+	}
+	if env.Validity != nil {
+		if err := e.checkValidity(env.Validity, data); err != nil {
+			return nil, err
+		}
+	}
+	if env.Approval != nil {
+		if err := e.checkApprovalBinding(env.Approval, data); err != nil {
+			return nil, err
+		}
+	}
+	d := e.Clone()
+	d.protocol = env.Protocol
+	// the signature is verified, and content validated once the plaintext
+	// is decompressed
+	d.verifyKeys = nil
+	if env.Compression != nil {
+		d.validators = nil
+	}
+	var encrypted []byte
+	switch env.Protocol {
+	case CFB:
+		if len(env.IV) != aes.BlockSize || len(env.Salt) != env.KDF.saltLength() {
+			return nil, errors.New("invalid envelope iv or salt")
+		}
+		if encrypted, err = cfbOutput(env.KDF, env.IV, data, env.Salt, env.MAC); err != nil {
+			return nil, err
+		}
+	case GCM, GCMStream, ChaCha20Poly1305, XChaCha20Poly1305, RSA, IPFSKey, ECIES, MultiRecipient, SecretBox, Box, PGP, OpenSSL:
+		encrypted = data
+	case AEAD, Archive:
+		encrypted = append([]byte{env.Cipher}, data...)
+	default:
+		if _, ok := registeredProtocol(env.Protocol); !ok {
+			return nil, fmt.Errorf("unsupported protocol %s", env.Protocol)
+		}
+		encrypted = data
+	}
+	switch {
+	case d.hasDecryptParams() && env.WrappedKey != nil:
+		if d.gcmDecryptParams, err = d.unwrapDataKey(env.WrappedKey); err != nil {
+			return nil, err
+		}
+	case d.hasDecryptParams():
+		if d.gcmDecryptParams, err = d.loadGCMDecryptParams(env.Params); err != nil {
+			return nil, err
+		}
+	}
+	plaintext, err := d.Decrypt(bytes.NewReader(encrypted))
+	if err != nil {
+		return nil, err
+	}
+	if env.Compression != nil {
+		if plaintext, err = e.decompress(plaintext, env.Compression); err != nil {
+			return nil, err
+		}
+		if err := e.validateContent(plaintext); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.verifyPlaintext(env.Signature, plaintext); err != nil {
+		return nil, err
+	}
+	if err := e.verifyAttestations(env.Attestations, plaintext, data); err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,53 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+func Test_EncryptManager_Split(t *testing.T) {
+	original, err := ioutil.ReadFile("README.md")
+	if err != nil {
+		t.Fatalf("setup failed: %s", err)
+	}
+	for _, protocol := range []Protocol{CFB, GCM, AEAD, Archive} {
+		t.Run(string(protocol), func(t *testing.T) {
+			e := NewEncryptManager("helloworld")
+			e.protocol = protocol
+			env, payload, err := e.EncryptSplit(bytes.NewReader(original))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// metadata round trips through json, ie for storage in a database
+			metadata, err := json.Marshal(env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var loaded Envelope
+			if err := json.Unmarshal(metadata, &loaded); err != nil {
+				t.Fatal(err)
+			}
+			// decryption only requires the passphrase, envelope, and payload
+			decrypted, err := NewEncryptManager("helloworld").DecryptSplit(&loaded, bytes.NewReader(payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, original) {
+				t.Fatal("decrypted data does not match original")
+			}
+			if protocol == CFB {
+				return
+			}
+			// authenticated protocols detect a modified payload
+			payload[0] ^= 0xff
+			if _, err := NewEncryptManager("helloworld").DecryptSplit(&loaded, bytes.NewReader(payload)); err == nil {
+				t.Fatal("expected error decrypting modified payload")
+			}
+		})
+	}
+	if _, err := NewEncryptManager("helloworld").DecryptSplit(&Envelope{Version: 99, Protocol: CFB}, bytes.NewReader(nil)); err == nil {
+		t.Fatal("expected error decrypting unsupported envelope version")
+	}
+}
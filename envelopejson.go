@@ -0,0 +1,99 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// EnvelopeExt is the extension of the envelope written alongside the payload by EncryptSplitFile
+const EnvelopeExt = ".envelope.json"
+
+// CanonicalJSON returns the canonical JSON encoding of the envelope, allowing
+// it to be stored as human readable, diff-able metadata, ie in git alongside
+// the payload. Object keys are sorted, and placed on their own line indented
+// by two spaces, HTML characters are not escaped, and the output ends with a
+// newline, so the same envelope always encodes to identical bytes. Byte
+// strings are base64 encoded, as by encoding/json, so the output can also be
+// decoded using json.Unmarshal
+func (env *Envelope) CanonicalJSON() ([]byte, error) {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+	// maps are encoded with sorted keys
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var fields map[string]interface{}
+	if err := dec.Decode(&fields); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(fields); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ParseEnvelope is used to decode a JSON encoded envelope, such as one returned
+// by CanonicalJSON, refusing unknown fields, and trailing data, which could
+// otherwise be silently lost when it is encoded again
+func ParseEnvelope(data []byte) (*Envelope, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var env Envelope
+	if err := dec.Decode(&env); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("invalid data after envelope")
+	}
+	return &env, nil
+}
+
+// EncryptSplitFile is used to encrypt the file at inPath using EncryptSplit,
+// writing the payload to outPath, and the envelope in canonical JSON to a file
+// of the same name with the EnvelopeExt extension
+func (e *EncryptManager) EncryptSplitFile(inPath, outPath string) error {
+	return transformFile(inPath, outPath, func(dst io.Writer, src *os.File) error {
+		env, payload, err := e.EncryptSplit(src)
+		if err != nil {
+			return err
+		}
+		metadata, err := env.CanonicalJSON()
+		if err != nil {
+			return err
+		}
+		if _, err := dst.Write(payload); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(outPath+EnvelopeExt, metadata, 0644)
+	})
+}
+
+// DecryptSplitFile is used to decrypt the payload at inPath using the envelope
+// written alongside it by EncryptSplitFile, writing the result to outPath
+func (e *EncryptManager) DecryptSplitFile(inPath, outPath string) error {
+	metadata, err := ioutil.ReadFile(inPath + EnvelopeExt)
+	if err != nil {
+		return err
+	}
+	env, err := ParseEnvelope(metadata)
+	if err != nil {
+		return err
+	}
+	return transformFile(inPath, outPath, func(dst io.Writer, src *os.File) error {
+		decrypted, err := e.DecryptSplit(env, src)
+		if err != nil {
+			return err
+		}
+		_, err = dst.Write(decrypted)
+		return err
+	})
+}
@@ -0,0 +1,116 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Envelope_CanonicalJSON(t *testing.T) {
+	original := []byte("hello world")
+	for _, protocol := range []Protocol{CFB, GCM, AEAD} {
+		t.Run(string(protocol), func(t *testing.T) {
+			e := NewEncryptManager("helloworld").WithKDF(DefaultKDFConfig(Scrypt))
+			setProtocol(e, protocol)
+			env, payload, err := e.EncryptSplit(bytes.NewReader(original))
+			if err != nil {
+				t.Fatal(err)
+			}
+			canonical, err := env.CanonicalJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the canonical form is stable across encoding, and decoding
+			parsed, err := ParseEnvelope(canonical)
+			if err != nil {
+				t.Fatal(err)
+			}
+			again, err := parsed.CanonicalJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(canonical, again) {
+				t.Fatalf("canonical json is not stable:\n%s\n%s", canonical, again)
+			}
+			// keys are sorted
+			if bytes.Index(canonical, []byte(`"protocol"`)) > bytes.Index(canonical, []byte(`"version"`)) {
+				t.Fatalf("keys are not sorted:\n%s", canonical)
+			}
+			var decoded Envelope
+			if err := json.Unmarshal(canonical, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err := NewEncryptManager("helloworld").DecryptSplit(parsed, bytes.NewReader(payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, original) {
+				t.Fatal("decrypted data does not match original")
+			}
+		})
+	}
+}
+
+func Test_ParseEnvelope(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{"valid", `{"version":1,"protocol":"AES256-GCM"}`, false},
+		{"unknown-field", `{"version":1,"protocol":"AES256-GCM","extra":true}`, true},
+		{"trailing-data", `{"version":1,"protocol":"AES256-GCM"}{}`, true},
+		{"invalid", `not json`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseEnvelope([]byte(tt.data)); (err != nil) != tt.wantErr {
+				t.Fatalf("ParseEnvelope() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_EncryptManager_EncryptSplitFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envelope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "plain")
+	if err := ioutil.WriteFile(in, []byte("hello world"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "payload")
+	e := NewEncryptManager("helloworld").WithGCM(nil)
+	if err := e.EncryptSplitFile(in, out); err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := ioutil.ReadFile(out + EnvelopeExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in, err := Inspect(bytes.NewReader(metadata)); err != nil || in.Format != FormatEnvelope {
+		t.Fatalf("Inspect() = %+v, %v", in, err)
+	}
+	decrypted := filepath.Join(dir, "decrypted")
+	if err := NewEncryptManager("helloworld").DecryptSplitFile(out, decrypted); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(decrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello world" {
+		t.Fatalf("decrypted = %s", data)
+	}
+	if err := NewEncryptManager("wrong").DecryptSplitFile(out, decrypted+"2"); err == nil {
+		t.Fatal("expected error decrypting using the wrong passphrase")
+	}
+	if _, err := os.Stat(decrypted + "2"); !os.IsNotExist(err) {
+		t.Fatal("failed decryption left output behind")
+	}
+}
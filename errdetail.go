@@ -0,0 +1,43 @@
+package crypto
+
+import "errors"
+
+// ErrDecryptionFailed is returned in place of the cause of any decryption
+// failure when errors are generic, see WithErrorDetail
+var ErrDecryptionFailed = errors.New("decryption failed")
+
+// ErrorDetail controls how much detail decryption errors expose
+type ErrorDetail int
+
+const (
+	// DetailedErrors returns the cause of decryption failures, such as invalid
+	// key derivation parameters, a failed authentication tag, or malformed
+	// content. This is the default, and is intended for local diagnostics
+	DetailedErrors ErrorDetail = iota
+	// GenericErrors replaces the cause of every decryption failure with
+	// ErrDecryptionFailed, so public-facing services do not act as an oracle
+	GenericErrors
+)
+
+// WithErrorDetail is used to set the detail of errors returned by Decrypt,
+// DecryptWithReceipt, DecryptSplit, DecryptStream, and DecryptSeeker, along
+// with the functions built on them. When errors are generic, handler, which
+// may be nil, receives the cause of every failure, so it can still be logged
+// privately
+func (e *EncryptManager) WithErrorDetail(detail ErrorDetail, handler func(error)) *EncryptManager {
+	e.errorDetail = detail
+	e.errorHandler = handler
+	return e
+}
+
+// decryptError returns err with the configured detail. Errors already made
+// generic are returned as is, so nested decryption reports every cause once
+func (e *EncryptManager) decryptError(err error) error {
+	if err == nil || err == ErrDecryptionFailed || e.errorDetail == DetailedErrors {
+		return err
+	}
+	if e.errorHandler != nil {
+		e.errorHandler(err)
+	}
+	return ErrDecryptionFailed
+}
@@ -0,0 +1,82 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+func Test_EncryptManager_ErrorDetail(t *testing.T) {
+	data := bytes.Repeat([]byte("hello world"), 10000)
+	e := NewEncryptManager("helloworld").WithGCMStream(nil)
+	encrypted, err := e.Encrypt(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := e.getGCMDecryptParams()
+	tampered := append([]byte{}, encrypted...)
+	tampered[len(tampered)-1] ^= 1
+	_, signer, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		detail ErrorDetail
+	}{
+		{"detailed", DetailedErrors},
+		{"generic", GenericErrors},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var causes []error
+			d := NewEncryptManager("helloworld").WithGCMStream(params).WithErrorDetail(tt.detail, func(err error) {
+				causes = append(causes, err)
+			})
+			check := func(op string, err error) {
+				t.Helper()
+				if err == nil {
+					t.Fatalf("%s expected error", op)
+				}
+				if (err == ErrDecryptionFailed) != (tt.detail == GenericErrors) {
+					t.Fatalf("%s err = %v", op, err)
+				}
+				// the cause is only handed to the handler when hidden
+				if want := int(tt.detail); len(causes) != want {
+					t.Fatalf("%s reported %d causes, want %d", op, len(causes), want)
+				}
+				if len(causes) > 0 && causes[0] == ErrDecryptionFailed {
+					t.Fatalf("%s reported generic cause", op)
+				}
+				causes = nil
+			}
+			_, err := d.Decrypt(bytes.NewReader(tampered))
+			check("Decrypt()", err)
+			check("DecryptStream()", d.DecryptStream(ioutil.Discard, bytes.NewReader(tampered)))
+			seeker, err := d.DecryptSeeker(bytes.NewReader(tampered))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = ioutil.ReadAll(seeker)
+			check("DecryptSeeker() Read", err)
+			_, err = d.Clone().WithDecryptionReceipts(signer, "key").Decrypt(bytes.NewReader(tampered))
+			check("Decrypt() with receipts", err)
+			_, err = NewEncryptManager("wrong").WithErrorDetail(tt.detail, func(err error) {
+				causes = append(causes, err)
+			}).Decrypt(bytes.NewReader(tampered[:10]))
+			check("Decrypt() truncated", err)
+
+			// successful decryption is unaffected
+			decrypted, err := d.Decrypt(bytes.NewReader(encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+		})
+	}
+}
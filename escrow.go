@@ -0,0 +1,48 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"github.com/RTradeLtd/crypto/v2/shamir"
+)
+
+// SplitDataKey is used to split the GCM cipher key, and nonce into n shares
+// using Shamir's secret sharing, of which threshold are needed to recover the
+// decryption parameters using CombineDataKey, so no single holder of a share
+// can decrypt the data. Shares encode as text, so they can be stored
+// alongside the ciphertext. Usage constraints can not be represented, so
+// constrained parameters are refused
+func (e *EncryptManager) SplitDataKey(n, threshold int) ([]shamir.Share, error) {
+	if err := e.checkKeyExport(false); err != nil {
+		return nil, err
+	}
+	if e.keyUsage != nil {
+		return nil, ErrKeyUsage
+	}
+	key, nonce, err := e.decodeGCMDecryptParams()
+	if err != nil {
+		return nil, err
+	}
+	secret := append(append([]byte{}, key...), nonce...)
+	defer zero(secret)
+	return shamir.Split(secret, n, threshold)
+}
+
+// CombineDataKey is used to recover the decryption parameters split using
+// SplitDataKey from at least the threshold number of shares, ready for use
+// with WithGCM
+func CombineDataKey(shares ...shamir.Share) (*GCMDecryptParams, error) {
+	secret, err := shamir.Combine(shares...)
+	if err != nil {
+		return nil, err
+	}
+	defer zero(secret)
+	if len(secret) < keylen || !validGCMNonceSize(len(secret)-keylen) {
+		return nil, errors.New("invalid data key shares")
+	}
+	return &GCMDecryptParams{
+		CipherKey: hex.EncodeToString(secret[:keylen]),
+		Nonce:     hex.EncodeToString(secret[keylen:]),
+	}, nil
+}
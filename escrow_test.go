@@ -0,0 +1,49 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/RTradeLtd/crypto/v2/shamir"
+)
+
+func Test_EncryptManager_SplitDataKey(t *testing.T) {
+	e := NewEncryptManager("helloworld").WithGCM(nil)
+	if _, err := e.SplitDataKey(3, 2); err == nil {
+		t.Fatal("expected error splitting before encryption")
+	}
+	encrypted, err := e.Encrypt(bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares, err := e.SplitDataKey(3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// shares are stored, and handed over as text
+	share, err := shamir.Parse(shares[2].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, err := CombineDataKey(shares[0], share)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := NewEncryptManager("").WithGCM(params).Decrypt(bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != "hello world" {
+		t.Fatalf("Decrypt = %s", decrypted)
+	}
+	if _, err := CombineDataKey(shares[0]); err == nil {
+		t.Fatal("expected error combining fewer shares than the threshold")
+	}
+	other, err := shamir.Split([]byte("hello world"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CombineDataKey(other[:2]...); err == nil {
+		t.Fatal("expected error combining shares of another secret")
+	}
+}
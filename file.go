@@ -0,0 +1,71 @@
+package crypto
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// EncryptFile is used to encrypt the file at inPath, writing the result to
+// outPath using EncryptStream, so the file is never held in memory. The output
+// is written to a temporary file which is renamed once complete, and has the
+// permissions of the input file
+func (e *EncryptManager) EncryptFile(inPath, outPath string) error {
+	return transformFile(inPath, outPath, func(dst io.Writer, src *os.File) error {
+		return e.EncryptStream(dst, src)
+	})
+}
+
+// DecryptFile is used to decrypt the file at inPath, produced by EncryptFile,
+// or EncryptStream, writing the result to outPath using DecryptStream. The
+// output is written to a temporary file which is renamed once the data is
+// authenticated, so a failed decryption never leaves partial plaintext behind
+func (e *EncryptManager) DecryptFile(inPath, outPath string) error {
+	return transformFile(inPath, outPath, func(dst io.Writer, src *os.File) error {
+		return e.DecryptStream(dst, src)
+	})
+}
+
+// transformFile writes the output of fn for the file at inPath to outPath,
+// atomically replacing it, with the permissions of the input file
+func transformFile(inPath, outPath string, fn func(dst io.Writer, src *os.File) error) error {
+	if inPath == "" || outPath == "" {
+		return errors.New("invalid path provided")
+	}
+	src, err := os.Open(inPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return errors.New("input is not a regular file")
+	}
+	dst, err := ioutil.TempFile(filepath.Dir(outPath), "."+filepath.Base(outPath)+".partial")
+	if err != nil {
+		return err
+	}
+	err = fn(dst, src)
+	if err == nil {
+		err = dst.Chmod(info.Mode().Perm())
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(dst.Name(), outPath)
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return err
+	}
+	return nil
+}
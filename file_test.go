@@ -0,0 +1,94 @@
+package crypto
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_EncryptManager_File(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := bytes.Repeat([]byte("hello world"), 20000)
+	plain := filepath.Join(dir, "plain.txt")
+	if err := ioutil.WriteFile(plain, data, 0640); err != nil {
+		t.Fatal(err)
+	}
+	// umask may narrow the permissions given to WriteFile
+	if err := os.Chmod(plain, 0640); err != nil {
+		t.Fatal(err)
+	}
+	for _, protocol := range []Protocol{CFB, GCM, XChaCha20Poly1305} {
+		t.Run(string(protocol), func(t *testing.T) {
+			e := NewEncryptManager("helloworld")
+			setProtocol(e, protocol)
+			encrypted, decrypted := filepath.Join(dir, "encrypted"), filepath.Join(dir, "decrypted")
+			if err := e.EncryptFile(plain, encrypted); err != nil {
+				t.Fatal(err)
+			}
+			if err := e.DecryptFile(encrypted, decrypted); err != nil {
+				t.Fatal(err)
+			}
+			out, err := ioutil.ReadFile(decrypted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+			for _, path := range []string{encrypted, decrypted} {
+				info, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Mode().Perm() != 0640 {
+					t.Fatalf("%s has mode %v, want %v", path, info.Mode().Perm(), os.FileMode(0640))
+				}
+			}
+		})
+	}
+
+	e := NewEncryptManager("helloworld").WithGCM(nil)
+	encrypted, decrypted := filepath.Join(dir, "tampered"), filepath.Join(dir, "existing")
+	if err := e.EncryptFile(plain, encrypted); err != nil {
+		t.Fatal(err)
+	}
+	tampered, err := ioutil.ReadFile(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered[len(tampered)-1] ^= 1
+	if err := ioutil.WriteFile(encrypted, tampered, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(decrypted, []byte("existing"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// a failed decryption leaves the existing output, and no temporary file
+	if err := e.DecryptFile(encrypted, decrypted); err == nil {
+		t.Fatal("expected error decrypting tampered file")
+	}
+	if out, err := ioutil.ReadFile(decrypted); err != nil || string(out) != "existing" {
+		t.Fatalf("existing output modified: %q, %v", out, err)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != "" && entry.Name() != "plain.txt" {
+			t.Fatalf("temporary file %s left behind", entry.Name())
+		}
+	}
+	if err := e.EncryptFile(filepath.Join(dir, "missing"), encrypted); err == nil {
+		t.Fatal("expected error encrypting missing file")
+	}
+	if err := e.EncryptFile(dir, encrypted); err == nil {
+		t.Fatal("expected error encrypting directory")
+	}
+}
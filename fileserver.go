@@ -0,0 +1,69 @@
+package crypto
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// DecryptingFileServer is an http.Handler serving files from a directory of
+// encrypted objects, decrypting them on the fly, so that encrypted mirrors,
+// such as those pinned to IPFS, can be browsed through a trusted gateway.
+// Range requests are supported, and files encrypted using GCM-STREAM are
+// decrypted one segment at a time, while other protocols, and files checked
+// by content validators, are decrypted in full
+type DecryptingFileServer struct {
+	// Root is the directory holding the encrypted files
+	Root http.FileSystem
+	// Params holds the decryption parameters of every file, keyed by its
+	// slash separated path within Root, without a leading slash
+	Params *ParamBundle
+	// Validators check the plaintext of every file before it is served
+	Validators []ContentValidator
+}
+
+// ServeHTTP decrypts, and serves the file at the request path. Files without
+// decryption parameters are never served
+func (s *DecryptingFileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	id := strings.TrimPrefix(name, "/")
+	entry, err := s.Params.Get(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	e, err := s.Params.Manager(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.WithContentValidators(s.Validators...)
+	file, err := s.Root.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	var content io.ReadSeeker
+	// validators require the complete plaintext, which DecryptSeeker avoids
+	if entry.Protocol == GCMStream && len(e.validators) == 0 {
+		content, err = e.DecryptSeeker(file)
+	} else {
+		var decrypted []byte
+		decrypted, err = e.Decrypt(file)
+		content = bytes.NewReader(decrypted)
+	}
+	if err != nil {
+		// never reveal why decryption failed
+		http.Error(w, "failed to decrypt file", http.StatusInternalServerError)
+		return
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), content)
+}
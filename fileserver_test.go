@@ -0,0 +1,108 @@
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_DecryptingFileServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fileserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := bytes.Repeat([]byte("0123456789"), segmentSize/5)
+	bundle := NewParamBundle()
+	for name, e := range map[string]*EncryptManager{
+		"docs/stream.txt": NewEncryptManager("helloworld").WithGCMStream(nil),
+		"gcm.txt":         NewEncryptManager("helloworld").WithGCM(nil),
+	} {
+		encrypted, err := e.Encrypt(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, encrypted, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := bundle.Add(name, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "unlisted.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(&DecryptingFileServer{Root: http.Dir(dir), Params: bundle})
+	defer srv.Close()
+
+	start := segmentSize - 5
+	tests := []struct {
+		name       string
+		path       string
+		rangeHdr   string
+		wantStatus int
+		wantBody   []byte
+	}{
+		{"stream", "/docs/stream.txt", "", http.StatusOK, data},
+		{"gcm", "/gcm.txt", "", http.StatusOK, data},
+		{"stream-range", "/docs/stream.txt", fmt.Sprintf("bytes=%d-%d", start, start+9), http.StatusPartialContent, data[start : start+10]},
+		{"gcm-range", "/gcm.txt", "bytes=0-3", http.StatusPartialContent, data[:4]},
+		{"unlisted", "/unlisted.txt", "", http.StatusNotFound, nil},
+		{"missing", "/missing.txt", "", http.StatusNotFound, nil},
+		{"traversal", "/../gcm.txt", "", http.StatusOK, data},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, srv.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.rangeHdr != "" {
+				req.Header.Set("Range", tt.rangeHdr)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantBody != nil && !bytes.Equal(body, tt.wantBody) {
+				t.Fatalf("body of %d bytes does not match", len(body))
+			}
+		})
+	}
+	// files checked by validators are decrypted in full before being served
+	for _, tt := range []struct {
+		validator  ContentValidator
+		wantStatus int
+	}{
+		{MaxContentSize(int64(len(data))), http.StatusOK},
+		{MaxContentSize(4), http.StatusInternalServerError},
+	} {
+		validated := httptest.NewServer(&DecryptingFileServer{Root: http.Dir(dir), Params: bundle, Validators: []ContentValidator{tt.validator}})
+		resp, err := http.Get(validated.URL + "/docs/stream.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		validated.Close()
+		if resp.StatusCode != tt.wantStatus {
+			t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+		}
+	}
+}
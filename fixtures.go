@@ -0,0 +1,346 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/nacl/box"
+)
+
+const (
+	// fixtureManifest is the name of the manifest written to fixture directories
+	fixtureManifest = "manifest.json"
+	// fixtureVersion is the version of the fixture corpus layout. Version 2
+	// adds fixtures produced using the options of a variant, and envelopes
+	fixtureVersion = 2
+	// fixtureIterations is the PBKDF2 iteration count recorded by kdf fixtures
+	fixtureIterations = 10000
+	// fixtureKeyID is the key-encryption key ID recorded by wrapped envelope fixtures
+	fixtureKeyID = "fixtures"
+)
+
+// fixtureProtocols are the protocols fixtures are generated for
+var fixtureProtocols = []Protocol{CFB, GCM, AEAD, GCMStream, ChaCha20Poly1305, XChaCha20Poly1305, SecretBox, Archive}
+
+// fixtureVariant describes fixtures produced using options beyond the protocol
+type fixtureVariant struct {
+	name     string
+	protocol Protocol
+	// split variants are encrypted using EncryptSplit, writing the envelope alongside the payload
+	split bool
+	// configure applies the options of the variant, using keys derived from the passphrase
+	configure func(e *EncryptManager, passphrase string) (*EncryptManager, error)
+}
+
+// fixtureVariants are the variants fixtures are generated for, in addition
+// to the default output of each protocol, covering every versioned format
+var fixtureVariants = func() []fixtureVariant {
+	withHeader := func(e *EncryptManager, _ string) (*EncryptManager, error) {
+		return e.WithHeader(), nil
+	}
+	withKDF := func(e *EncryptManager, _ string) (*EncryptManager, error) {
+		return e.WithPBKDF2Iterations(fixtureIterations), nil
+	}
+	var variants []fixtureVariant
+	for _, protocol := range headerProtocols {
+		variants = append(variants, fixtureVariant{name: "header", protocol: protocol, configure: withHeader})
+	}
+	return append(variants,
+		fixtureVariant{name: "kdf", protocol: CFB, configure: withKDF},
+		fixtureVariant{name: "kdf", protocol: GCM, configure: withKDF},
+		fixtureVariant{name: "kdf-salt", protocol: CFB, configure: func(e *EncryptManager, _ string) (*EncryptManager, error) {
+			return e.WithPBKDF2Iterations(fixtureIterations).WithSaltLength(32)
+		}},
+		fixtureVariant{name: "signed", protocol: GCM, configure: withFixtureSignature},
+		fixtureVariant{name: "box", protocol: Box, configure: func(e *EncryptManager, passphrase string) (*EncryptManager, error) {
+			public, private, err := box.GenerateKey(bytes.NewReader(fixtureKey(passphrase, "box")))
+			if err != nil {
+				return nil, err
+			}
+			return e.WithBox(public, private), nil
+		}},
+		fixtureVariant{name: "envelope", protocol: CFB, split: true},
+		fixtureVariant{name: "envelope", protocol: GCM, split: true},
+		fixtureVariant{name: "envelope-compressed", protocol: GCM, split: true, configure: func(e *EncryptManager, _ string) (*EncryptManager, error) {
+			return e.WithCompressionDictionaries(NewCompressionDictionary(1, []byte("temporal fixtures hello world"))), nil
+		}},
+		fixtureVariant{name: "envelope-wrapped", protocol: GCM, split: true, configure: func(e *EncryptManager, passphrase string) (*EncryptManager, error) {
+			return e.WithKeyWrapper(fixtureKeyWrapper{kek: fixtureKey(passphrase, "kek")}), nil
+		}},
+		fixtureVariant{name: "envelope-signed", protocol: GCM, split: true, configure: withFixtureSignature},
+	)
+}()
+
+// findFixtureVariant returns the variant named name for protocol
+func findFixtureVariant(name string, protocol Protocol) (fixtureVariant, bool) {
+	for _, variant := range fixtureVariants {
+		if variant.name == name && variant.protocol == protocol {
+			return variant, true
+		}
+	}
+	return fixtureVariant{}, false
+}
+
+// fixtureKey derives key material for purpose from the passphrase, so every
+// fixture in a corpus can be verified using the passphrase alone
+func fixtureKey(passphrase, purpose string) []byte {
+	key := make([]byte, keylen)
+	// hkdf never fails reading less than 255 hash lengths
+	io.ReadFull(hkdf.New(sha256.New, []byte(passphrase), nil, []byte("temporal-fixtures:"+purpose)), key)
+	return key
+}
+
+// withFixtureSignature signs, and verifies the plaintext using an ed25519 key
+// derived from the passphrase
+func withFixtureSignature(e *EncryptManager, passphrase string) (*EncryptManager, error) {
+	private := ed25519.NewKeyFromSeed(fixtureKey(passphrase, "signature"))
+	e, err := e.WithSigningKey(private)
+	if err != nil {
+		return nil, err
+	}
+	if e, err = e.WithVerifyKeys(private.Public()); err != nil {
+		return nil, err
+	}
+	return e.WithSignedPlaintext(), nil
+}
+
+// fixtureKeyWrapper wraps keys using AES Key Wrap with Padding under a kek
+// derived from the passphrase
+type fixtureKeyWrapper struct {
+	kek []byte
+}
+
+func (f fixtureKeyWrapper) WrapKey(key []byte) (string, []byte, error) {
+	wrapped, err := WrapKeyWithPadding(f.kek, key)
+	return fixtureKeyID, wrapped, err
+}
+
+func (f fixtureKeyWrapper) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	if keyID != fixtureKeyID {
+		return nil, fmt.Errorf("unknown key %s", keyID)
+	}
+	return UnwrapKeyWithPadding(f.kek, wrapped)
+}
+
+// FixtureManifest describes a corpus of encrypted fixtures
+type FixtureManifest struct {
+	Version  int       `json:"version"`
+	Fixtures []Fixture `json:"fixtures"`
+}
+
+// Fixture describes a single encrypted fixture within a corpus
+type Fixture struct {
+	Seed     string   `json:"seed"`
+	Protocol Protocol `json:"protocol"`
+	// Digest is the hex encoded sha256 digest of the seed input
+	Digest string `json:"digest"`
+	// Ciphertext is the name of the file holding the encrypted seed
+	Ciphertext string `json:"ciphertext"`
+	// Params is the name of the file holding the encrypted decryption parameters, if any
+	Params string `json:"params,omitempty"`
+	// Variant names the options used beyond the protocol, if any
+	Variant string `json:"variant,omitempty"`
+	// Envelope is the name of the file holding the envelope of split fixtures
+	Envelope string `json:"envelope,omitempty"`
+}
+
+// GenerateFixtures is used to encrypt every seed input using all supported
+// protocols, and the versioned formats produced by their options, writing the results, and a manifest to dir. The resulting corpus
+// can be checked into source control and validated with VerifyFixtures to
+// ensure changes to the package remain backwards compatible
+func GenerateFixtures(dir, passphrase string, seeds map[string][]byte) (*FixtureManifest, error) {
+	if len(seeds) == 0 {
+		return nil, errors.New("no seed inputs provided")
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	// generate fixtures in a stable order
+	var names []string
+	for name := range seeds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	manifest := &FixtureManifest{Version: fixtureVersion}
+	for _, name := range names {
+		digest := sha256.Sum256(seeds[name])
+		variants := make([]fixtureVariant, 0, len(fixtureProtocols)+len(fixtureVariants))
+		for _, protocol := range fixtureProtocols {
+			variants = append(variants, fixtureVariant{protocol: protocol})
+		}
+		for _, variant := range append(variants, fixtureVariants...) {
+			fixture, err := generateFixture(dir, passphrase, name, seeds[name], variant)
+			if err != nil {
+				return nil, err
+			}
+			fixture.Digest = hex.EncodeToString(digest[:])
+			manifest.Fixtures = append(manifest.Fixtures, fixture)
+		}
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return manifest, ioutil.WriteFile(filepath.Join(dir, fixtureManifest), data, 0644)
+}
+
+// generateFixture encrypts a single seed input as described by variant
+func generateFixture(dir, passphrase, name string, seed []byte, variant fixtureVariant) (Fixture, error) {
+	fixture := Fixture{
+		Seed:       name,
+		Protocol:   variant.protocol,
+		Variant:    variant.name,
+		Ciphertext: fmt.Sprintf("%s.%s.enc", name, variant.protocol),
+	}
+	if variant.name != "" {
+		fixture.Ciphertext = fmt.Sprintf("%s.%s.%s.enc", name, variant.protocol, variant.name)
+	}
+	e := NewEncryptManager(passphrase)
+	e.protocol = variant.protocol
+	if variant.configure != nil {
+		var err error
+		if e, err = variant.configure(e, passphrase); err != nil {
+			return fixture, err
+		}
+	}
+	if variant.split {
+		env, payload, err := e.EncryptSplit(bytes.NewReader(seed))
+		if err != nil {
+			return fixture, err
+		}
+		metadata, err := env.CanonicalJSON()
+		if err != nil {
+			return fixture, err
+		}
+		fixture.Envelope = fixture.Ciphertext + EnvelopeExt
+		if err := ioutil.WriteFile(filepath.Join(dir, fixture.Envelope), metadata, 0644); err != nil {
+			return fixture, err
+		}
+		return fixture, ioutil.WriteFile(filepath.Join(dir, fixture.Ciphertext), payload, 0644)
+	}
+	encrypted, err := e.Encrypt(bytes.NewReader(seed))
+	if err != nil {
+		return fixture, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, fixture.Ciphertext), encrypted, 0644); err != nil {
+		return fixture, err
+	}
+	if e.getGCMDecryptParams() != nil {
+		params, err := e.RetrieveGCMDecryptionParameters()
+		if err != nil {
+			return fixture, err
+		}
+		fixture.Params = strings.TrimSuffix(fixture.Ciphertext, ".enc") + ".params"
+		if err := ioutil.WriteFile(filepath.Join(dir, fixture.Params), params, 0644); err != nil {
+			return fixture, err
+		}
+	}
+	return fixture, nil
+}
+
+// VerifyFixtures is used to check that every fixture in the corpus at dir
+// still decrypts to its original seed input
+func VerifyFixtures(dir, passphrase string) error {
+	data, err := ioutil.ReadFile(filepath.Join(dir, fixtureManifest))
+	if err != nil {
+		return err
+	}
+	var manifest FixtureManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return err
+	}
+	if manifest.Version > fixtureVersion {
+		return fmt.Errorf("unsupported fixture version %d", manifest.Version)
+	}
+	for _, fixture := range manifest.Fixtures {
+		if err := verifyFixture(dir, passphrase, fixture); err != nil {
+			return fmt.Errorf("fixture %s: %s", fixture.Ciphertext, err)
+		}
+	}
+	return nil
+}
+
+// verifyFixture decrypts a single fixture, and compares it against the seed digest
+func verifyFixture(dir, passphrase string, fixture Fixture) error {
+	// fixtures generated by earlier versions hold unauthenticated AES256-CFB
+	e := NewEncryptManager(passphrase).WithLegacyCFB()
+	e.protocol = fixture.Protocol
+	var variant fixtureVariant
+	if fixture.Variant != "" {
+		var ok bool
+		if variant, ok = findFixtureVariant(fixture.Variant, fixture.Protocol); !ok {
+			return fmt.Errorf("unknown variant %s", fixture.Variant)
+		}
+		if variant.configure != nil {
+			var err error
+			if e, err = variant.configure(e, passphrase); err != nil {
+				return err
+			}
+		}
+	}
+	encrypted, err := ioutil.ReadFile(filepath.Join(dir, fixture.Ciphertext))
+	if err != nil {
+		return err
+	}
+	var decrypted []byte
+	switch {
+	case variant.split:
+		metadata, err := ioutil.ReadFile(filepath.Join(dir, fixture.Envelope))
+		if err != nil {
+			return err
+		}
+		env, err := ParseEnvelope(metadata)
+		if err != nil {
+			return err
+		}
+		if decrypted, err = e.DecryptSplit(env, bytes.NewReader(encrypted)); err != nil {
+			return err
+		}
+	// the keys of box fixtures are derived from the passphrase, leaving no state to restore
+	case fixture.Protocol == Box:
+		if decrypted, err = e.Decrypt(bytes.NewReader(encrypted)); err != nil {
+			return err
+		}
+	default:
+		if err := restoreFixture(e, dir, fixture); err != nil {
+			return err
+		}
+		if decrypted, err = e.Decrypt(bytes.NewReader(encrypted)); err != nil {
+			return err
+		}
+	}
+	digest := sha256.Sum256(decrypted)
+	if hex.EncodeToString(digest[:]) != fixture.Digest {
+		return errors.New("decrypted data does not match seed")
+	}
+	return nil
+}
+
+// restoreFixture restores the protocol, and decryption parameters of fixture
+func restoreFixture(e *EncryptManager, dir string, fixture Fixture) error {
+	state := ManagerState{Protocol: fixture.Protocol}
+	if fixture.Params != "" {
+		params, err := ioutil.ReadFile(filepath.Join(dir, fixture.Params))
+		if err != nil {
+			return err
+		}
+		state.Params = params
+	}
+	stateData, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return e.Restore(stateData)
+}
@@ -0,0 +1,60 @@
+package crypto
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// goldenPassphrase is the passphrase used to generate testdata/fixtures
+const goldenPassphrase = "temporal-fixtures"
+
+func Test_GoldenFixtures(t *testing.T) {
+	// fixtures generated by previous versions of the package must still decrypt,
+	// with version 2 covering headers, kdf headers, envelopes, and every protocol
+	for _, dir := range []string{
+		filepath.Join("testdata", "fixtures"),
+		filepath.Join("testdata", "fixtures", "v2"),
+	} {
+		t.Run(dir, func(t *testing.T) {
+			if err := VerifyFixtures(dir, goldenPassphrase); err != nil {
+				t.Fatal(err)
+			}
+			if err := VerifyFixtures(dir, "wrong-passphrase"); err == nil {
+				t.Fatal("expected error verifying fixtures with wrong passphrase")
+			}
+		})
+	}
+}
+
+func Test_GenerateFixtures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := GenerateFixtures(dir, "helloworld", nil); err == nil {
+		t.Fatal("expected error generating fixtures without seeds")
+	}
+	manifest, err := GenerateFixtures(dir, "helloworld", map[string][]byte{
+		"empty": {},
+		"text":  []byte("hello world"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 2 * (len(fixtureProtocols) + len(fixtureVariants)); len(manifest.Fixtures) != want {
+		t.Fatalf("generated %d fixtures, want %d", len(manifest.Fixtures), want)
+	}
+	if err := VerifyFixtures(dir, "helloworld"); err != nil {
+		t.Fatal(err)
+	}
+	// corrupting a fixture must be detected
+	if err := ioutil.WriteFile(filepath.Join(dir, manifest.Fixtures[0].Ciphertext), []byte("corrupted data which is long enough to decrypt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyFixtures(dir, "helloworld"); err == nil {
+		t.Fatal("expected error verifying corrupted fixtures")
+	}
+}
@@ -5,5 +5,7 @@ go 1.12
 require (
 	github.com/RTradeLtd/cmd/v2 v2.1.0
 	github.com/RTradeLtd/config/v2 v2.1.5
+	github.com/klauspost/compress v1.17.0
 	golang.org/x/crypto v0.0.0-20190411191339-88737f569e3a
+	golang.org/x/sys v0.0.0-20190403152447-81d4e9dc473e
 )
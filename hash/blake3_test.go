@@ -0,0 +1,48 @@
+package hash
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func Test_BLAKE3(t *testing.T) {
+	// official test vectors, where the input is the repeating sequence 0..250
+	tests := []struct {
+		size int
+		want string
+	}{
+		{0, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+		{1, "2d3adedff11b61f14c886e35afa036736dcd87a74d27b5c1510225d0f592e213"},
+		{1024, "42214739f095a406f3fc83deb889744ac00df831c10daa55189b5d121c855af7"},
+		{1025, "d00278ae47eb27b34faecf67b4fe263f82d5412916c1ffd97c8cb7fb814b8444"},
+		{102400, "bc3e3d41a1146b069abffad3c0d44860cf664390afce4d9661f7902e7943e085"},
+	}
+	for _, tt := range tests {
+		input := make([]byte, tt.size)
+		for i := range input {
+			input[i] = byte(i % 251)
+		}
+		h := newBLAKE3()
+		// written in uneven pieces, crossing block, and chunk boundaries
+		for p := input; len(p) > 0; {
+			n := 7
+			if n > len(p) {
+				n = len(p)
+			}
+			h.Write(p[:n])
+			p = p[n:]
+		}
+		if got := hex.EncodeToString(h.Sum(nil)); got != tt.want {
+			t.Fatalf("BLAKE3(%d) = %s, want %s", tt.size, got, tt.want)
+		}
+		// Sum does not change the state
+		if got := hex.EncodeToString(h.Sum(nil)); got != tt.want {
+			t.Fatalf("BLAKE3(%d) = %s after Sum", tt.size, got)
+		}
+		h.Reset()
+		h.Write(input)
+		if got := hex.EncodeToString(h.Sum(nil)); got != tt.want {
+			t.Fatalf("BLAKE3(%d) = %s after Reset", tt.size, got)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Package hash provides a uniform streaming API over the SHA-2, SHA-3,
+// BLAKE2b, and BLAKE3 hash functions, used to fingerprint data encrypted by
+// the crypto package. Digests can be encoded as multihashes, the
+// self-describing format used by IPFS, so fingerprints interoperate with CIDs
+// without additional libraries.
+package hash
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
+)
+
+// Algorithm identifies a hash function, using its multihash name
+type Algorithm string
+
+// Supported hash functions
+const (
+	SHA256     Algorithm = "sha2-256"
+	SHA512     Algorithm = "sha2-512"
+	SHA3_256   Algorithm = "sha3-256"
+	SHA3_512   Algorithm = "sha3-512"
+	BLAKE2b256 Algorithm = "blake2b-256"
+	BLAKE2b512 Algorithm = "blake2b-512"
+	// BLAKE3 computes 256 bit digests
+	BLAKE3 Algorithm = "blake3"
+)
+
+// algorithm is a hash function, and its multihash code
+type algorithm struct {
+	code uint64
+	new  func() hash.Hash
+}
+
+var algorithms = map[Algorithm]algorithm{
+	SHA256:   {0x12, sha256.New},
+	SHA512:   {0x13, sha512.New},
+	SHA3_256: {0x16, sha3.New256},
+	SHA3_512: {0x14, sha3.New512},
+	BLAKE2b256: {0xb220, func() hash.Hash {
+		// only fails for invalid key lengths
+		h, _ := blake2b.New256(nil)
+		return h
+	}},
+	BLAKE2b512: {0xb240, func() hash.Hash {
+		h, _ := blake2b.New512(nil)
+		return h
+	}},
+	BLAKE3: {0x1e, newBLAKE3},
+}
+
+// New returns a new hash.Hash computing digests using alg
+func New(alg Algorithm) (hash.Hash, error) {
+	a, ok := algorithms[alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash algorithm %s", alg)
+	}
+	return a.new(), nil
+}
+
+// Sum returns the digest of data using alg
+func Sum(alg Algorithm, data []byte) ([]byte, error) {
+	h, err := New(alg)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(data)
+	return h.Sum(nil), nil
+}
+
+// SumReader returns the digest of the data read from r using alg, reading
+// it in constant memory
+func SumReader(alg Algorithm, r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	h, err := New(alg)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// SumFile returns the digest of the file at path using alg
+func SumFile(alg Algorithm, path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return SumReader(alg, f)
+}
+
+// EncodeMultihash encodes the digest computed using alg as a multihash, being
+//
+//	varint(code) || varint(len(digest)) || digest
+func EncodeMultihash(alg Algorithm, digest []byte) ([]byte, error) {
+	a, ok := algorithms[alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash algorithm %s", alg)
+	}
+	buf := make([]byte, 2*binary.MaxVarintLen64, 2*binary.MaxVarintLen64+len(digest))
+	n := binary.PutUvarint(buf, a.code)
+	n += binary.PutUvarint(buf[n:], uint64(len(digest)))
+	return append(buf[:n], digest...), nil
+}
+
+// DecodeMultihash returns the algorithm, and digest of the multihash mh
+func DecodeMultihash(mh []byte) (Algorithm, []byte, error) {
+	code, n := binary.Uvarint(mh)
+	if n <= 0 {
+		return "", nil, errors.New("invalid multihash")
+	}
+	size, m := binary.Uvarint(mh[n:])
+	if m <= 0 || uint64(len(mh)-n-m) != size {
+		return "", nil, errors.New("invalid multihash")
+	}
+	for alg, a := range algorithms {
+		if a.code == code {
+			return alg, mh[n+m:], nil
+		}
+	}
+	return "", nil, fmt.Errorf("unsupported multihash code 0x%x", code)
+}
+
+// SumMultihash returns the multihash of the data read from r using alg
+func SumMultihash(alg Algorithm, r io.Reader) ([]byte, error) {
+	digest, err := SumReader(alg, r)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeMultihash(alg, digest)
+}
+
+// Reader hashes the data read through it, allowing data to be fingerprinted
+// in the same pass as it is encrypted, or uploaded
+type Reader struct {
+	r   io.Reader
+	alg Algorithm
+	h   hash.Hash
+}
+
+// NewReader returns a Reader hashing the data read from r using alg
+func NewReader(alg Algorithm, r io.Reader) (*Reader, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	h, err := New(alg)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{r: r, alg: alg, h: h}, nil
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.h.Write(p[:n])
+	return n, err
+}
+
+// Sum returns the digest of the data read so far
+func (r *Reader) Sum() []byte {
+	return r.h.Sum(nil)
+}
+
+// Multihash returns the multihash of the data read so far
+func (r *Reader) Multihash() []byte {
+	// the algorithm is known to be supported
+	mh, _ := EncodeMultihash(r.alg, r.Sum())
+	return mh
+}
@@ -0,0 +1,129 @@
+package hash
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Sum(t *testing.T) {
+	tests := []struct {
+		alg     Algorithm
+		want    string
+		wantErr bool
+	}{
+		{SHA256, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", false},
+		{SHA512, "ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f", false},
+		{SHA3_256, "3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532", false},
+		{SHA3_512, "b751850b1a57168a5693cd924b6b096e08f621827444f70d884f5d0240d2712e10e116e9192af3c91a7ec57647e3934057340b4cf408d5a56592f8274eec53f0", false},
+		{BLAKE2b256, "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319", false},
+		{BLAKE2b512, "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923", false},
+		{BLAKE3, "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85", false},
+		{"md5", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.alg), func(t *testing.T) {
+			sum, err := Sum(tt.alg, []byte("abc"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Sum() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := hex.EncodeToString(sum); got != tt.want {
+				t.Fatalf("Sum() = %s, want %s", got, tt.want)
+			}
+			streamed, err := SumReader(tt.alg, bytes.NewReader([]byte("abc")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(streamed, sum) {
+				t.Fatal("SumReader() does not match Sum()")
+			}
+		})
+	}
+}
+
+func Test_Multihash(t *testing.T) {
+	tests := []struct {
+		alg    Algorithm
+		prefix string
+	}{
+		{SHA256, "1220"},
+		{SHA512, "1340"},
+		{SHA3_256, "1620"},
+		{SHA3_512, "1440"},
+		{BLAKE2b256, "a0e40220"},
+		{BLAKE2b512, "c0e40240"},
+		{BLAKE3, "1e20"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.alg), func(t *testing.T) {
+			mh, err := SumMultihash(tt.alg, bytes.NewReader([]byte("hello world")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(mh); got[:len(tt.prefix)] != tt.prefix {
+				t.Fatalf("multihash = %s, want prefix %s", got, tt.prefix)
+			}
+			alg, digest, err := DecodeMultihash(mh)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum, _ := Sum(tt.alg, []byte("hello world"))
+			if alg != tt.alg || !bytes.Equal(digest, sum) {
+				t.Fatalf("DecodeMultihash() = %s, %x", alg, digest)
+			}
+		})
+	}
+	// the multihash of a well known IPFS block
+	mh, err := SumMultihash(SHA256, bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(mh); got != "1220b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9" {
+		t.Fatalf("multihash = %s", got)
+	}
+	for _, bad := range []string{"", "12", "1221" + hex.EncodeToString(make([]byte, 32)), "ff0100"} {
+		data, _ := hex.DecodeString(bad)
+		if _, _, err := DecodeMultihash(data); err == nil {
+			t.Fatalf("expected error decoding %q", bad)
+		}
+	}
+}
+
+func Test_Reader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file")
+	data := bytes.Repeat([]byte("hello world"), 1000)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(BLAKE3, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := SumFile(BLAKE3, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r.Sum(), sum) {
+		t.Fatal("Reader.Sum() does not match SumFile()")
+	}
+	if mh, _ := EncodeMultihash(BLAKE3, sum); !bytes.Equal(r.Multihash(), mh) {
+		t.Fatal("Reader.Multihash() does not match EncodeMultihash()")
+	}
+	if _, err := NewReader("md5", bytes.NewReader(data)); err == nil {
+		t.Fatal("expected error using unsupported algorithm")
+	}
+}
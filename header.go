@@ -0,0 +1,189 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+)
+
+const (
+	// headerVersion is the current version of the ciphertext header format.
+	// Version 3 records the signature of the plaintext, and is only produced
+	// for signed data. Version 2 appends the mac of AES256-CFB to its
+	// ciphertext, and version 1 AES256-CFB headers are decrypted as
+	// unauthenticated content
+	headerVersion byte = 3
+)
+
+var (
+	// headerMagic identifies encrypted data prefixed with a header
+	headerMagic = []byte("TCRY")
+
+	// headerProtocols maps protocol identifiers within headers, which are
+	// the index of the protocol plus one, and must never be reordered
+	headerProtocols = []Protocol{CFB, GCM, AEAD, GCMStream, ChaCha20Poly1305, XChaCha20Poly1305, Archive, SecretBox}
+)
+
+// header is the self-describing header of encrypted data, encoded as
+//
+//	"TCRY" || version || protocol || len(kdf) || kdf || len(salt) || salt ||
+//	len(nonce) || nonce || [len(signature) || signature] || body
+//
+// where the kdf is encoded as the AES256-CFB key derivation function header,
+// and lengths are single bytes, other than the 2 byte length of the signature
+// present from version 3. The body holds the protocol output without
+// the parameters recorded in the header: the ciphertext followed by the mac
+// for AES256-CFB, and the same output as without a header for all other protocols
+type header struct {
+	version   byte
+	protocol  Protocol
+	kdf       *KDFConfig
+	salt      []byte
+	nonce     []byte
+	signature []byte
+	body      []byte
+}
+
+// WithHeader is used to prefix the output of Encrypt with a self-describing,
+// versioned header recording the protocol, key derivation function, salt,
+// and nonce used. Decrypt detects the header automatically, decrypting using
+// the recorded protocol, so only the passphrase, and for protocols other than
+// AES256-CFB the cipher key, is required. Without a header, the formats used
+// by Temporal are produced, which Decrypt continues to support
+func (e *EncryptManager) WithHeader() *EncryptManager {
+	e.header = true
+	return e
+}
+
+// addHeader prefixes the output recorded by res with its header
+func addHeader(res *EncryptResult) ([]byte, error) {
+	h := header{protocol: res.Protocol, kdf: res.KDF, salt: res.Salt, nonce: res.Nonce, signature: res.Signature, body: res.Data}
+	if res.Protocol == CFB {
+		var err error
+		if h.body, err = res.cfbCiphertext(); err != nil {
+			return nil, err
+		}
+		h.body = append(h.body[:len(h.body):len(h.body)], res.MAC...)
+		h.nonce = res.IV
+	} else if res.Nonce == nil {
+		return nil, errors.New("no nonce to record")
+	}
+	return h.marshal()
+}
+
+// marshal encodes the header, and body
+func (h *header) marshal() ([]byte, error) {
+	id := 0
+	for i, protocol := range headerProtocols {
+		if protocol == h.protocol {
+			id = i + 1
+		}
+	}
+	if id == 0 {
+		return nil, errors.New("protocol can not be recorded in a header")
+	}
+	var kdf []byte
+	if h.kdf != nil {
+		var err error
+		if kdf, err = h.kdf.header(); err != nil {
+			return nil, err
+		}
+	}
+	// only signed data requires the current version
+	version := headerVersion - 1
+	if h.signature != nil {
+		if len(h.signature) > 0xffff {
+			return nil, errors.New("signature is too large")
+		}
+		version = headerVersion
+	}
+	buf := bytes.NewBuffer(append([]byte{}, headerMagic...))
+	buf.Write([]byte{version, byte(id)})
+	for _, field := range [][]byte{kdf, h.salt, h.nonce} {
+		buf.WriteByte(byte(len(field)))
+		buf.Write(field)
+	}
+	if h.signature != nil {
+		binary.Write(buf, binary.BigEndian, uint16(len(h.signature)))
+		buf.Write(h.signature)
+	}
+	buf.Write(h.body)
+	return buf.Bytes(), nil
+}
+
+// parseHeader parses the header at the start of data, indicating whether
+// one was found. Data without a valid header is treated as headerless
+func parseHeader(data []byte) (*header, bool) {
+	if !bytes.HasPrefix(data, headerMagic) || len(data) < len(headerMagic)+2 {
+		return nil, false
+	}
+	data = data[len(headerMagic):]
+	if data[0] == 0 || data[0] > headerVersion || data[1] == 0 || int(data[1]) > len(headerProtocols) {
+		return nil, false
+	}
+	h := &header{version: data[0], protocol: headerProtocols[data[1]-1]}
+	data = data[2:]
+	fields := make([][]byte, 3)
+	for i := range fields {
+		if len(data) < 1 || len(data) < 1+int(data[0]) {
+			return nil, false
+		}
+		fields[i], data = data[1:1+int(data[0])], data[1+int(data[0]):]
+	}
+	if len(fields[0]) > 0 {
+		kdf, n, err := parseKDFHeader(fields[0])
+		if err != nil || kdf == nil || n != len(fields[0]) {
+			return nil, false
+		}
+		h.kdf = kdf
+	}
+	if h.version >= 3 {
+		if len(data) < 2 || len(data) < 2+int(binary.BigEndian.Uint16(data)) {
+			return nil, false
+		}
+		n := 2 + int(binary.BigEndian.Uint16(data))
+		h.signature, data = data[2:n], data[n:]
+	}
+	h.salt, h.nonce, h.body = fields[1], fields[2], data
+	return h, true
+}
+
+// decryptHeader decrypts the body of h using the protocol, and parameters it records
+func (e *EncryptManager) decryptHeader(h *header) ([]byte, error) {
+	if err := e.checkProtocol(h.protocol); err != nil {
+		return nil, err
+	}
+	d := e.Clone()
+	d.protocol = h.protocol
+	if h.protocol == CFB {
+		if len(h.nonce) != aes.BlockSize || len(h.salt) != h.kdf.saltLength() {
+			return nil, errors.New("invalid header iv or salt")
+		}
+		// version 1 headers are unauthenticated, so stripping the mac from
+		// later versions must not downgrade them without WithLegacyCFB
+		if h.version < 2 && !d.legacyCFB {
+			return nil, ErrUnauthenticatedCFB
+		}
+		ciphertext, mac := h.body, []byte(nil)
+		if h.version >= 2 {
+			if len(h.body) < cfbMACSize {
+				return nil, errors.New("invalid content provided")
+			}
+			ciphertext, mac = h.body[:len(h.body)-cfbMACSize], h.body[len(h.body)-cfbMACSize:]
+		}
+		out, err := cfbOutput(h.kdf, h.nonce, ciphertext, h.salt, mac)
+		if err != nil {
+			return nil, err
+		}
+		return d.decryptCFB(bytes.NewReader(out))
+	}
+	// only the cipher key is required, as the nonce is recorded
+	params := d.getGCMDecryptParams()
+	if params == nil {
+		return nil, errors.New("no decryption parameters given")
+	}
+	d.gcmDecryptParams = &GCMDecryptParams{CipherKey: params.CipherKey, Nonce: hex.EncodeToString(h.nonce), usage: params.usage}
+	return d.decryptProtocol(bytes.NewReader(h.body))
+}
@@ -0,0 +1,134 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_EncryptManager_Header(t *testing.T) {
+	data := []byte("hello world")
+	tests := []struct {
+		name string
+		with func(e *EncryptManager) *EncryptManager
+	}{
+		{"cfb", func(e *EncryptManager) *EncryptManager { return e }},
+		{"cfb-kdf", func(e *EncryptManager) *EncryptManager {
+			return e.WithKDF(KDFConfig{KDF: Scrypt, N: 1024, R: 8, P: 1})
+		}},
+		{"gcm", func(e *EncryptManager) *EncryptManager { return e.WithGCM(nil) }},
+		{"aead", func(e *EncryptManager) *EncryptManager { return e.WithAEAD(nil) }},
+		{"gcm-stream", func(e *EncryptManager) *EncryptManager { return e.WithGCMStream(nil) }},
+		{"chacha20", func(e *EncryptManager) *EncryptManager { return e.WithChaCha20Poly1305(nil) }},
+		{"xchacha20", func(e *EncryptManager) *EncryptManager { return e.WithXChaCha20Poly1305(nil) }},
+		{"archive", func(e *EncryptManager) *EncryptManager { return e.WithArchive(nil) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := tt.with(NewEncryptManager("helloworld")).WithHeader()
+			encrypted, err := e.Encrypt(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(encrypted, headerMagic) {
+				t.Fatal("encrypted data is missing header")
+			}
+			h, ok := parseHeader(encrypted)
+			if !ok {
+				t.Fatal("failed to parse header")
+			}
+			if h.protocol != e.getProtocol() {
+				t.Fatalf("header protocol = %s, want %s", h.protocol, e.getProtocol())
+			}
+			if h.kdf != e.kdf && (h.kdf == nil || e.kdf == nil || *h.kdf != *e.kdf) {
+				t.Fatalf("header kdf = %+v, want %+v", h.kdf, e.kdf)
+			}
+			// decryption selects the protocol from the header, only requiring the cipher key
+			d := NewEncryptManager("helloworld")
+			if params := e.getGCMDecryptParams(); params != nil {
+				d = d.WithGCM(&GCMDecryptParams{CipherKey: params.CipherKey})
+			}
+			decrypted, err := d.Decrypt(bytes.NewReader(encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+			// tampering with the recorded nonce must fail decryption
+			tampered := append([]byte{}, encrypted...)
+			tampered[len(encrypted)-len(h.body)-1] ^= 0xff
+			if decrypted, err = d.Decrypt(bytes.NewReader(tampered)); err == nil && bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data with tampered header")
+			}
+		})
+	}
+}
+
+func Test_EncryptManager_Header_Downgrade(t *testing.T) {
+	encrypted, err := NewEncryptManager("helloworld").WithHeader().Encrypt(bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// rewriting the header as the unauthenticated version 1, without the mac
+	stripped := append([]byte{}, encrypted[:len(encrypted)-cfbMACSize]...)
+	stripped[len(headerMagic)] = 1
+	if _, err := NewEncryptManager("helloworld").Decrypt(bytes.NewReader(stripped)); err != ErrUnauthenticatedCFB {
+		t.Fatalf("Decrypt err = %v, want %v", err, ErrUnauthenticatedCFB)
+	}
+}
+
+func Test_EncryptManager_Headerless(t *testing.T) {
+	data := []byte("hello world")
+	e := NewEncryptManager("helloworld")
+	encrypted, err := e.Encrypt(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.HasPrefix(encrypted, headerMagic) {
+		t.Fatal("header added without being requested")
+	}
+	decrypted, err := e.Decrypt(bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Fatal("decrypted data does not match original")
+	}
+	// headers are not recorded in envelopes, which hold the same parameters
+	env, payload, err := e.Clone().WithHeader().EncryptSplit(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.HasPrefix(payload, headerMagic) {
+		t.Fatal("envelope payload contains header")
+	}
+	if decrypted, err = e.DecryptSplit(env, bytes.NewReader(payload)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Fatal("decrypted data does not match original")
+	}
+}
+
+func Test_parseHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		ok   bool
+	}{
+		{"empty", nil, false},
+		{"no-magic", []byte("hello world"), false},
+		{"bad-version", []byte("TCRY\x04\x01\x00\x00\x00"), false},
+		{"bad-protocol", []byte("TCRY\x01\xff\x00\x00\x00"), false},
+		{"truncated", []byte("TCRY\x01\x02\x00\x00\x0c\x00"), false},
+		{"bad-kdf", []byte("TCRY\x01\x01\x02ab\x00\x00"), false},
+		{"minimal", []byte("TCRY\x01\x02\x00\x00\x00"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := parseHeader(tt.data); ok != tt.ok {
+				t.Fatalf("parseHeader() ok = %v, want %v", ok, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// healthProbe is the plaintext encrypted, and decrypted by Health
+var healthProbe = []byte("temporal-health")
+
+// HealthChecker may be implemented by dependencies, such as KeyProviders
+// backed by a KMS, to report whether they are usable
+type HealthChecker interface {
+	Health() error
+}
+
+// HealthCheckerFunc allows using an ordinary function as a HealthChecker
+type HealthCheckerFunc func() error
+
+// Health calls f()
+func (f HealthCheckerFunc) Health() error {
+	return f()
+}
+
+// Health is used to verify the manager is usable before taking traffic, ie
+// during startup, or from a readiness probe. The configured protocol, key
+// derivation function, and source of randomness are exercised by encrypting,
+// and decrypting a probe, which also warms up key derivation. The configured
+// Notarizer, NonceLog, and source of randomness, such as an EntropyMonitor,
+// are checked when they implement HealthChecker,
+// followed by the given checks, such as a TenantKeyManager
+func (e *EncryptManager) Health(checks ...HealthChecker) error {
+	protocol := e.getProtocol()
+	if e.kdf != nil {
+		if err := e.kdf.validateWithin(e.getKDFLimits()); err != nil {
+			return err
+		}
+	}
+	// probe using a manager without side effects, such as notarization
+	probe := &EncryptManager{
+		passphrase:    e.passphrase,
+		protocol:      protocol,
+		random:        e.random,
+		kdf:           e.kdf,
+		kdfLimits:     e.kdfLimits,
+		rsaPublic:     e.rsaPublic,
+		rsaPrivate:    e.rsaPrivate,
+		rsaOAEPHash:   e.rsaOAEPHash,
+		ipfsPublic:    e.ipfsPublic,
+		ipfsPrivate:   e.ipfsPrivate,
+		eciesPublic:   e.eciesPublic,
+		eciesPrivate:  e.eciesPrivate,
+		boxPublic:     e.boxPublic,
+		boxPrivate:    e.boxPrivate,
+		pgpKeys:       e.pgpKeys,
+		opensslConfig: e.opensslConfig,
+		recipients:    e.recipients,
+		recipientKey:  e.recipientKey,
+	}
+	// managers holding only a recipient key probe by encrypting to themselves
+	if protocol == MultiRecipient && len(probe.recipients) == 0 && probe.recipientKey != nil {
+		public, err := recipientPublicKey(probe.recipientKey)
+		if err != nil {
+			return err
+		}
+		probe.recipients = []interface{}{public}
+	}
+	encrypted, err := probe.Encrypt(bytes.NewReader(healthProbe))
+	if err != nil {
+		return fmt.Errorf("%s encryption failed: %v", protocol, err)
+	}
+	if !probe.publicKeyOnly() {
+		decrypted, err := probe.Decrypt(bytes.NewReader(encrypted))
+		if err != nil {
+			return fmt.Errorf("%s decryption failed: %v", protocol, err)
+		}
+		if !bytes.Equal(decrypted, healthProbe) {
+			return fmt.Errorf("%s decryption does not match probe", protocol)
+		}
+	}
+	if c, ok := e.notarizer.(HealthChecker); ok {
+		checks = append([]HealthChecker{c}, checks...)
+	}
+	if c, ok := e.nonceLog.(HealthChecker); ok {
+		checks = append([]HealthChecker{c}, checks...)
+	}
+	if c, ok := e.random.(HealthChecker); ok {
+		checks = append([]HealthChecker{c}, checks...)
+	}
+	for _, c := range checks {
+		if err := c.Health(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publicKeyOnly indicates whether the manager uses a public key protocol, and
+// only holds the public key, so it can't decrypt
+func (e *EncryptManager) publicKeyOnly() bool {
+	switch e.getProtocol() {
+	case RSA:
+		return e.rsaPrivate == nil
+	case IPFSKey:
+		return e.ipfsPrivate == nil
+	case ECIES:
+		return e.eciesPrivate == nil
+	case Box:
+		return e.boxPrivate == nil
+	case PGP:
+		for _, entity := range e.pgpKeys {
+			if entity.PrivateKey != nil {
+				return false
+			}
+		}
+		return len(e.pgpKeys) > 0
+	case MultiRecipient:
+		return e.recipientKey == nil
+	default:
+		return false
+	}
+}
+
+// Health checks the KeyProvider of the TenantKeyManager when it implements
+// HealthChecker, ie to verify a KMS is reachable
+func (t *TenantKeyManager) Health() error {
+	if t.provider == nil {
+		return errors.New("no key provider configured")
+	}
+	if c, ok := t.provider.(HealthChecker); ok {
+		return c.Health()
+	}
+	return nil
+}
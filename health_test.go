@@ -0,0 +1,89 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+type healthyNotarizer struct {
+	err    error
+	digest []byte
+}
+
+func (n *healthyNotarizer) Notarize(digest []byte) ([]byte, error) {
+	n.digest = digest
+	return []byte("receipt"), nil
+}
+
+func (n *healthyNotarizer) Health() error { return n.err }
+
+type healthyKeyProvider struct {
+	DerivedKeyProvider
+	err error
+}
+
+func (p *healthyKeyProvider) Health() error { return p.err }
+
+func Test_EncryptManager_Health(t *testing.T) {
+	unreachable := errors.New("kms unreachable")
+	tests := []struct {
+		name    string
+		e       *EncryptManager
+		checks  []HealthChecker
+		wantErr bool
+		err     error
+	}{
+		{"cfb", NewEncryptManager("helloworld"), nil, false, nil},
+		{"gcm", NewEncryptManager("helloworld").WithGCM(nil), nil, false, nil},
+		{"aead", NewEncryptManager("helloworld").WithAEAD(nil), nil, false, nil},
+		{"gcm-stream", NewEncryptManager("helloworld").WithGCMStream(nil), nil, false, nil},
+		{"xchacha20", NewEncryptManager("helloworld").WithXChaCha20Poly1305(nil), nil, false, nil},
+		{"kdf", NewEncryptManager("helloworld").WithKDF(KDFConfig{KDF: Scrypt, N: 1024, R: 8, P: 1}), nil, false, nil},
+		{"bad-protocol", &EncryptManager{passphrase: []byte("helloworld"), protocol: "bad"}, nil, true, nil},
+		{"bad-random", NewEncryptManager("helloworld").WithRandom(bytes.NewReader(nil)), nil, true, nil},
+		{"notarizer", NewEncryptManager("helloworld").WithNotarizer(&healthyNotarizer{err: unreachable}), nil, true, unreachable},
+		{"tenant-keys", NewEncryptManager("helloworld"),
+			[]HealthChecker{NewTenantKeyManager(&healthyKeyProvider{err: unreachable})}, true, unreachable},
+		{"func", NewEncryptManager("helloworld"),
+			[]HealthChecker{HealthCheckerFunc(func() error { return unreachable })}, true, unreachable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.e.Health(tt.checks...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Health() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.err != nil && err != tt.err {
+				t.Fatalf("Health() err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func Test_EncryptManager_Health_NoSideEffects(t *testing.T) {
+	n := &healthyNotarizer{}
+	e := NewEncryptManager("helloworld").WithGCM(nil).WithNotarizer(n)
+	if err := e.Health(); err != nil {
+		t.Fatal(err)
+	}
+	if n.digest != nil {
+		t.Fatal("health probe was notarized")
+	}
+	if e.getGCMDecryptParams() != nil {
+		t.Fatal("health probe replaced decryption parameters")
+	}
+}
+
+func Test_TenantKeyManager_Health(t *testing.T) {
+	provider, err := NewDerivedKeyProvider(bytes.Repeat([]byte{1}, keylen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewTenantKeyManager(provider).Health(); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewTenantKeyManager(nil).Health(); err == nil {
+		t.Fatal("expected error")
+	}
+}
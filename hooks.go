@@ -0,0 +1,129 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// EncryptionEvent describes encrypted content produced by an EncryptManager
+type EncryptionEvent struct {
+	// Ref is the reference of the stored object, set by EncryptTo, and EncryptAndStore
+	Ref      string   `json:"ref,omitempty"`
+	Protocol Protocol `json:"protocol"`
+	// Digest is the sha256 digest of the encrypted data, as submitted to a Notarizer
+	Digest []byte `json:"digest"`
+	// Size is the size of the encrypted data
+	Size int64 `json:"size"`
+	// KeyID identifies the key-encryption key protecting the data key of an
+	// envelope, see WithKeyWrapper
+	KeyID string `json:"key_id,omitempty"`
+	// Metadata is the metadata set using WithEncryptHooks
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Time     time.Time         `json:"time"`
+}
+
+// EncryptHook is notified after every encryption, allowing inventory systems
+// to track encrypted content as it is produced. Errors fail the encryption
+type EncryptHook interface {
+	AfterEncrypt(event EncryptionEvent) error
+}
+
+// EncryptHookFunc allows using an ordinary function as an EncryptHook
+type EncryptHookFunc func(event EncryptionEvent) error
+
+// AfterEncrypt calls f(event)
+func (f EncryptHookFunc) AfterEncrypt(event EncryptionEvent) error {
+	return f(event)
+}
+
+// WithEncryptHooks is used to notify hooks after every encryption by Encrypt,
+// EncryptWithResult, EncryptStream, EncryptSplit, EncryptTo, and
+// EncryptAndStore, along with the functions built on them. metadata, which
+// may be nil, is attached to every event
+func (e *EncryptManager) WithEncryptHooks(metadata map[string]string, hooks ...EncryptHook) *EncryptManager {
+	e.hookMetadata = metadata
+	e.encryptHooks = hooks
+	return e
+}
+
+// afterEncrypt notifies the hooks of the encrypted data stored under ref
+func (e *EncryptManager) afterEncrypt(ref string, encrypted []byte) error {
+	if len(e.encryptHooks) == 0 {
+		return nil
+	}
+	digest := sha256.Sum256(encrypted)
+	return e.runEncryptHooks(EncryptionEvent{Ref: ref, Digest: digest[:], Size: int64(len(encrypted))})
+}
+
+// runEncryptHooks completes event, and notifies the hooks in order
+func (e *EncryptManager) runEncryptHooks(event EncryptionEvent) error {
+	event.Protocol = e.getProtocol()
+	event.Metadata = e.hookMetadata
+	event.Time = e.now().UTC()
+	for _, hook := range e.encryptHooks {
+		if err := hook.AfterEncrypt(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Webhook is an EncryptHook posting every event as JSON to an HTTP endpoint,
+// such as Temporal's API
+type Webhook struct {
+	// URL is the endpoint events are posted to
+	URL string
+	// Secret is used to sign the body using HMAC-SHA-256, sent hex encoded in
+	// the X-Temporal-Signature header, allowing the receiver to authenticate events
+	Secret []byte
+	// Header holds additional headers, such as Authorization, sent with every event
+	Header http.Header
+	// Client is used to make requests, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// AfterEncrypt posts event to the endpoint, failing unless it responds with a 2xx status
+func (w *Webhook) AfterEncrypt(event EncryptionEvent) error {
+	if w.URL == "" {
+		return errors.New("no webhook url provided")
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range w.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(w.Secret) > 0 {
+		mac := hmac.New(sha256.New, w.Secret)
+		mac.Write(body)
+		req.Header.Set("X-Temporal-Signature", hex.EncodeToString(mac.Sum(nil)))
+	}
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("webhook returned status %d: %s", resp.StatusCode, data)
+	}
+	return nil
+}
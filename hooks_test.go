@@ -0,0 +1,141 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_EncryptManager_EncryptHooks(t *testing.T) {
+	data := []byte("hello world")
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var events []EncryptionEvent
+	hook := EncryptHookFunc(func(event EncryptionEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	metadata := map[string]string{"owner": "alice"}
+	kw := &memoryKeyWrapper{id: "key-1", kek: bytes.Repeat([]byte{1}, 32)}
+	tests := []struct {
+		name    string
+		encrypt func(e *EncryptManager) ([]byte, error)
+		ref     string
+		keyID   string
+	}{
+		{"Encrypt", func(e *EncryptManager) ([]byte, error) {
+			return e.Encrypt(bytes.NewReader(data))
+		}, "", ""},
+		{"EncryptWithResult", func(e *EncryptManager) ([]byte, error) {
+			res, err := e.EncryptWithResult(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			return res.Data, nil
+		}, "", ""},
+		{"EncryptStream", func(e *EncryptManager) ([]byte, error) {
+			var out bytes.Buffer
+			err := e.EncryptStream(&out, bytes.NewReader(data))
+			return out.Bytes(), err
+		}, "", ""},
+		{"EncryptSplit", func(e *EncryptManager) ([]byte, error) {
+			_, payload, err := e.WithKeyWrapper(kw).EncryptSplit(bytes.NewReader(data))
+			return payload, err
+		}, "", "key-1"},
+		{"EncryptTo", func(e *EncryptManager) ([]byte, error) {
+			storage := NewMemoryStorage()
+			ref, err := e.EncryptTo(storage, "object", bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			rc, err := storage.Get(ref)
+			if err != nil {
+				return nil, err
+			}
+			defer rc.Close()
+			return ioutil.ReadAll(rc)
+		}, "object", ""},
+		{"EncryptAndStore", func(e *EncryptManager) ([]byte, error) {
+			return e.EncryptAndStore(NewMemoryParamStore(), "object-id", bytes.NewReader(data))
+		}, "object-id", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events = nil
+			e := NewEncryptManager("helloworld").WithGCM(nil).WithClock(ClockFunc(func() time.Time { return now })).
+				WithEncryptHooks(metadata, hook)
+			encrypted, err := tt.encrypt(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != 1 {
+				t.Fatalf("hook called %d times, want 1", len(events))
+			}
+			digest := sha256.Sum256(encrypted)
+			event := events[0]
+			if event.Ref != tt.ref || event.KeyID != tt.keyID || event.Protocol != GCM ||
+				!bytes.Equal(event.Digest, digest[:]) || event.Size != int64(len(encrypted)) ||
+				event.Metadata["owner"] != "alice" || !event.Time.Equal(now) {
+				t.Fatalf("event = %+v", event)
+			}
+		})
+	}
+
+	// hook errors fail the encryption
+	failed := errors.New("inventory unavailable")
+	e := NewEncryptManager("helloworld").WithEncryptHooks(nil, EncryptHookFunc(func(EncryptionEvent) error {
+		return failed
+	}))
+	if _, err := e.Encrypt(bytes.NewReader(data)); err != failed {
+		t.Fatalf("Encrypt() err = %v, want %v", err, failed)
+	}
+	storage := NewMemoryStorage()
+	if _, err := e.EncryptTo(storage, "object", bytes.NewReader(data)); err == nil {
+		t.Fatal("expected error from hook")
+	}
+	if _, err := storage.Get("object"); err != ErrObjectNotFound {
+		t.Fatalf("object not cleaned up after hook failure, err = %v", err)
+	}
+}
+
+func Test_Webhook(t *testing.T) {
+	secret := []byte("secret")
+	var received EncryptionEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(body)
+		if r.Header.Get("X-Temporal-Signature") != hex.EncodeToString(mac.Sum(nil)) ||
+			r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if err := json.Unmarshal(body, &received); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	hook := &Webhook{URL: srv.URL, Secret: secret, Header: http.Header{"Authorization": {"Bearer token"}}}
+	encrypted, err := NewEncryptManager("helloworld").WithEncryptHooks(map[string]string{"job": "42"}, hook).
+		EncryptTo(NewMemoryStorage(), "object", bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received.Ref != encrypted || received.Metadata["job"] != "42" || len(received.Digest) != sha256.Size {
+		t.Fatalf("received event = %+v", received)
+	}
+	for _, wh := range []*Webhook{{}, {URL: srv.URL, Secret: []byte("wrong")}} {
+		if err := wh.AfterEncrypt(received); err == nil {
+			t.Fatalf("AfterEncrypt() with %+v expected error", wh)
+		}
+	}
+}
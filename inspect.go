@@ -0,0 +1,217 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// Formats of encrypted data recognised by Inspect
+const (
+	// FormatEnvelope is an Envelope produced by EncryptSplit, encoded as JSON
+	FormatEnvelope = "envelope"
+	// FormatHeader is encrypted data prefixed with a header, see WithHeader
+	FormatHeader = "header"
+	// FormatMultiRecipient is content encrypted to several recipients, see WithRecipients
+	FormatMultiRecipient = "multi-recipient"
+	// FormatCFB is authenticated AES256-CFB encrypted data, or legacy data
+	// recording its key derivation function
+	FormatCFB = "AES256-CFB"
+	// FormatUnknown is encrypted data which does not describe itself, such as
+	// the formats used by Temporal, which require the protocol to be known
+	FormatUnknown = "unknown"
+)
+
+// inspectPeek is the amount of data Inspect reads to identify the format,
+// which covers the largest header
+const inspectPeek = 1024
+
+// Inspection describes encrypted data, or an envelope, without decrypting it
+type Inspection struct {
+	Format   string
+	Version  int
+	Protocol Protocol
+	// KDF is the key derivation function, if recorded
+	KDF *KDFConfig
+	// Size is the size of the encrypted data, excluding envelopes
+	Size int64
+	// Segments is the number of authenticated segments of segmented protocols
+	Segments int
+	// SaltSize, and NonceSize are the sizes of the recorded salt, and nonce or iv
+	SaltSize  int
+	NonceSize int
+	// Recipients is the number of key slots of multi-recipient content
+	Recipients int
+	// Authenticated indicates AES256-CFB content is authenticated by a mac
+	Authenticated bool
+	// HasParams indicates an envelope holds encrypted decryption parameters
+	HasParams bool
+	// KeyID identifies the key-encryption key protecting the data key of an
+	// envelope, see WithKeyWrapper
+	KeyID string
+	// SignerKeyID is the hex encoded ID of the key which signed the plaintext,
+	// see WithSignedPlaintext
+	SignerKeyID  string
+	Checksum     ChecksumAlgorithm
+	Attestations []string
+	NotBefore    time.Time
+	NotAfter     time.Time
+}
+
+// Inspect is used to describe encrypted data, or a JSON encoded Envelope, read
+// from r without decrypting it, to help debug interoperability problems.
+// Encrypted data is read in full to determine its size, using constant memory
+func Inspect(r io.Reader) (*Inspection, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	br := bufio.NewReaderSize(r, inspectPeek)
+	peek, err := br.Peek(inspectPeek)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+	if trimmed := bytes.TrimSpace(peek); len(trimmed) > 0 && trimmed[0] == '{' {
+		var env Envelope
+		if err := json.NewDecoder(br).Decode(&env); err != nil {
+			return nil, err
+		}
+		return inspectEnvelope(&env), nil
+	}
+	size, err := io.Copy(ioutil.Discard, br)
+	if err != nil {
+		return nil, err
+	}
+	in := &Inspection{Format: FormatUnknown, Size: size}
+	if h, ok := parseHeader(peek); ok {
+		in.Format, in.Version, in.Protocol = FormatHeader, int(h.version), h.protocol
+		in.KDF, in.SaltSize, in.NonceSize = h.kdf, len(h.salt), len(h.nonce)
+		in.Authenticated = h.protocol == CFB && h.version >= 2
+		in.SignerKeyID = hex.EncodeToString(signatureKeyID(h.signature))
+		if h.protocol == GCMStream {
+			// the body is the cipher identifier, and segment size followed by the segments
+			if _, segment, n, err := readSegmentHeader(bytes.NewReader(h.body)); err == nil {
+				in.Segments = countSegments(size-int64(len(peek)-len(h.body)+n), segment)
+			}
+		}
+		return in, nil
+	}
+	if bytes.HasPrefix(peek, recipientsMagic) && len(peek) >= len(recipientsMagic)+2 {
+		in.Format, in.Protocol = FormatMultiRecipient, MultiRecipient
+		in.Recipients = int(binary.BigEndian.Uint16(peek[len(recipientsMagic):]))
+		return in, nil
+	}
+	if bytes.HasPrefix(peek, cfbMACMagic) {
+		in.Format, in.Protocol, in.Authenticated = FormatCFB, CFB, true
+		in.SaltSize, in.NonceSize = saltlen, aes.BlockSize
+		peek = peek[len(cfbMACMagic):]
+	}
+	if kdf, _, err := parseKDFHeader(peek); err == nil && kdf != nil {
+		in.Format, in.Protocol, in.KDF = FormatCFB, CFB, kdf
+		in.SaltSize, in.NonceSize = kdf.saltLength(), aes.BlockSize
+	}
+	return in, nil
+}
+
+// inspectEnvelope describes the contents of env
+func inspectEnvelope(env *Envelope) *Inspection {
+	in := &Inspection{
+		Format:    FormatEnvelope,
+		Version:   env.Version,
+		Protocol:  env.Protocol,
+		KDF:       env.KDF,
+		SaltSize:  len(env.Salt),
+		NonceSize: len(env.IV),
+		HasParams: len(env.Params) > 0,
+	}
+	if env.WrappedKey != nil {
+		in.KeyID = env.WrappedKey.KeyID
+	}
+	in.SignerKeyID = hex.EncodeToString(signatureKeyID(env.Signature))
+	if env.Checksum != nil {
+		in.Checksum = env.Checksum.Algorithm
+	}
+	for _, a := range env.Attestations {
+		in.Attestations = append(in.Attestations, a.Type)
+	}
+	if env.Validity != nil {
+		in.NotBefore, in.NotAfter = env.Validity.NotBefore, env.Validity.NotAfter
+	}
+	return in
+}
+
+// countSegments returns the number of segments of segment bytes of plaintext
+// in a segmented stream of size bytes
+func countSegments(size int64, segment int) int {
+	sealed := int64(segment + 16)
+	if size <= 0 {
+		return 0
+	}
+	return int((size + sealed - 1) / sealed)
+}
+
+// String formats the inspection as a table of the fields present
+func (in *Inspection) String() string {
+	var b strings.Builder
+	field := func(name string, value interface{}) {
+		fmt.Fprintf(&b, "%s:\t%v\n", name, value)
+	}
+	field("Format", in.Format)
+	if in.Version > 0 {
+		field("Version", in.Version)
+	}
+	if in.Protocol != "" {
+		field("Protocol", in.Protocol)
+	}
+	if in.KDF != nil {
+		field("KDF", describeKDF(in.KDF))
+	}
+	if in.Format != FormatEnvelope {
+		field("Size", in.Size)
+	}
+	if in.Segments > 0 {
+		field("Segments", in.Segments)
+	}
+	if in.Recipients > 0 {
+		field("Recipients", in.Recipients)
+	}
+	if in.SaltSize > 0 {
+		field("Salt", fmt.Sprintf("%d bytes", in.SaltSize))
+	}
+	if in.NonceSize > 0 {
+		field("Nonce", fmt.Sprintf("%d bytes", in.NonceSize))
+	}
+	if in.Protocol == CFB {
+		field("Authenticated", in.Authenticated)
+	}
+	if in.Format == FormatEnvelope {
+		field("Params", in.HasParams)
+	}
+	if in.KeyID != "" {
+		field("Key ID", in.KeyID)
+	}
+	if in.SignerKeyID != "" {
+		field("Signer", in.SignerKeyID)
+	}
+	if in.Checksum != "" {
+		field("Checksum", in.Checksum)
+	}
+	if len(in.Attestations) > 0 {
+		field("Attestations", strings.Join(in.Attestations, ", "))
+	}
+	if !in.NotBefore.IsZero() {
+		field("Not Before", in.NotBefore.Format(time.RFC3339))
+	}
+	if !in.NotAfter.IsZero() {
+		field("Not After", in.NotAfter.Format(time.RFC3339))
+	}
+	return b.String()
+}
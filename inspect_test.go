@@ -0,0 +1,76 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Inspect(t *testing.T) {
+	large := bytes.Repeat([]byte("hello world"), 20000)
+	kdf := KDFConfig{KDF: Scrypt, N: 1024, R: 8, P: 1}
+	headered, err := NewEncryptManager("helloworld").WithGCMStream(nil).WithHeader().Encrypt(bytes.NewReader(large))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfb, err := NewEncryptManager("helloworld").WithKDF(kdf).Encrypt(bytes.NewReader(large))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var authenticated []byte
+	// legacy data beginning with "{" is taken for an envelope, so retry
+	for authenticated == nil || bytes.HasPrefix(bytes.TrimSpace(authenticated[len(cfbMACMagic):]), []byte("{")) {
+		if authenticated, err = NewEncryptManager("helloworld").Encrypt(bytes.NewReader(large)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the legacy format lacks the magic, and mac
+	legacy := authenticated[len(cfbMACMagic) : len(authenticated)-cfbMACSize]
+	env, _, err := NewEncryptManager("helloworld").WithGCM(nil).WithChecksum(SHA256).
+		WithValidity(time.Time{}, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)).EncryptSplit(bytes.NewReader(large))
+	if err != nil {
+		t.Fatal(err)
+	}
+	envJSON, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		data     []byte
+		format   string
+		protocol Protocol
+		segments int
+		contains []string
+	}{
+		{"header", headered, FormatHeader, GCMStream, 4, []string{"Segments:\t4", "Nonce:\t"}},
+		{"cfb-kdf", cfb, FormatCFB, CFB, 0, []string{"KDF:\tscrypt (N=1024, r=8, p=1)"}},
+		{"authenticated", authenticated, FormatCFB, CFB, 0, []string{"Authenticated:\ttrue"}},
+		{"legacy", legacy, FormatUnknown, "", 0, []string{"Format:\tunknown"}},
+		{"envelope", envJSON, FormatEnvelope, GCM, 0, []string{"Params:\ttrue", "Checksum:\tSHA-256", "Not After:\t2030-01-01T00:00:00Z"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, err := Inspect(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if in.Format != tt.format || in.Protocol != tt.protocol || in.Segments != tt.segments {
+				t.Fatalf("Inspect() = %+v", in)
+			}
+			if in.Format != FormatEnvelope && in.Size != int64(len(tt.data)) {
+				t.Fatalf("size = %d, want %d", in.Size, len(tt.data))
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(in.String(), s) {
+					t.Fatalf("String() = %q, missing %q", in.String(), s)
+				}
+			}
+		})
+	}
+	if _, err := Inspect(strings.NewReader("{not json")); err == nil {
+		t.Fatal("expected error for invalid envelope")
+	}
+}
@@ -0,0 +1,199 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// MACAlgorithm identifies the keyed hash used to authenticate existing ciphertexts
+type MACAlgorithm string
+
+var (
+	// HMACSHA256 authenticates data using HMAC-SHA-256
+	HMACSHA256 MACAlgorithm = "HMAC-SHA-256"
+	// BLAKE2b256MAC authenticates data using keyed BLAKE2b with a 256 bit digest
+	BLAKE2b256MAC MACAlgorithm = "BLAKE2b-256-MAC"
+
+	// ErrMACMismatch is returned when data does not match its mac
+	ErrMACMismatch = errors.New("data does not match its mac")
+	// ErrNoMACTrailer is returned when stripping the mac from data without one
+	ErrNoMACTrailer = errors.New("data has no mac trailer")
+)
+
+// macTrailerMagic terminates the trailer appended by AppendMAC
+var macTrailerMagic = []byte("TMAC")
+
+// macTrailerSize is the size of mac trailers, as every supported mac is 32 bytes
+const macTrailerSize = sha256.Size + 1 + 4
+
+// identifiers of the algorithm recorded in mac trailers
+var macAlgorithmIDs = map[MACAlgorithm]byte{
+	HMACSHA256:    1,
+	BLAKE2b256MAC: 2,
+}
+
+// newMAC returns a new hash computing macs using alg, keyed by key
+func newMAC(alg MACAlgorithm, key []byte) (hash.Hash, error) {
+	if len(key) == 0 {
+		return nil, errors.New("no mac key provided")
+	}
+	switch alg {
+	case HMACSHA256:
+		return hmac.New(sha256.New, key), nil
+	case BLAKE2b256MAC:
+		return blake2b.New256(key)
+	default:
+		return nil, fmt.Errorf("unsupported mac algorithm %s", alg)
+	}
+}
+
+// ComputeMAC is used to compute the HMAC-SHA-256 of the data read from r
+// using key, reading it in constant memory
+func ComputeMAC(r io.Reader, key []byte) ([]byte, error) {
+	return ComputeMACWith(HMACSHA256, r, key)
+}
+
+// VerifyMAC is used to check that the data read from r matches the
+// HMAC-SHA-256 mac, as returned by ComputeMAC
+func VerifyMAC(r io.Reader, key, mac []byte) error {
+	return VerifyMACWith(HMACSHA256, r, key, mac)
+}
+
+// ComputeMACWith is used to compute the mac of the data read from r using alg
+func ComputeMACWith(alg MACAlgorithm, r io.Reader, key []byte) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	h, err := newMAC(alg, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// VerifyMACWith is used to check that the data read from r matches mac, as
+// returned by ComputeMACWith using alg
+func VerifyMACWith(alg MACAlgorithm, r io.Reader, key, mac []byte) error {
+	computed, err := ComputeMACWith(alg, r, key)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(computed, mac) {
+		return ErrMACMismatch
+	}
+	return nil
+}
+
+// macTrailer returns the trailer authenticating data, in the format of
+//
+//	mac || algorithm || "TMAC"
+func macTrailer(alg MACAlgorithm, r io.Reader, key []byte) ([]byte, error) {
+	mac, err := ComputeMACWith(alg, r, key)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(mac, macAlgorithmIDs[alg]), macTrailerMagic...), nil
+}
+
+// AppendMAC is used to append a trailer holding the mac of data computed
+// using alg, so ciphertexts produced by the legacy AES256-CFB format, or any
+// other stored blobs, gain tamper detection without being re-encrypted
+func AppendMAC(data, key []byte, alg MACAlgorithm) ([]byte, error) {
+	trailer, err := macTrailer(alg, bytes.NewReader(data), key)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, data...), trailer...), nil
+}
+
+// HasMACTrailer reports whether data ends with a trailer written by AppendMAC
+func HasMACTrailer(data []byte) bool {
+	_, ok := parseMACTrailer(data)
+	return ok
+}
+
+// StripMAC is used to verify the trailer appended by AppendMAC, returning
+// the data without it, such as a legacy ciphertext which may then be
+// decrypted using WithLegacyCFB
+func StripMAC(data, key []byte) ([]byte, error) {
+	alg, ok := parseMACTrailer(data)
+	if !ok {
+		return nil, ErrNoMACTrailer
+	}
+	body := data[:len(data)-macTrailerSize]
+	mac := data[len(body) : len(body)+sha256.Size]
+	if err := VerifyMACWith(alg, bytes.NewReader(body), key, mac); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// AppendMACFile is used to append the trailer written by AppendMAC to the
+// file at path in place, reading the file in constant memory
+func AppendMACFile(path string, key []byte, alg MACAlgorithm) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	trailer, err := macTrailer(alg, f, key)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(trailer); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// VerifyMACFile is used to verify the trailer of the file at path, as
+// written by AppendMACFile, reading the file in constant memory
+func VerifyMACFile(path string, key []byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := int64(macTrailerSize)
+	if info.Size() < size {
+		return ErrNoMACTrailer
+	}
+	trailer := make([]byte, size)
+	if _, err := f.ReadAt(trailer, info.Size()-size); err != nil {
+		return err
+	}
+	alg, ok := parseMACTrailer(trailer)
+	if !ok {
+		return ErrNoMACTrailer
+	}
+	return VerifyMACWith(alg, io.LimitReader(f, info.Size()-size), key, trailer[:sha256.Size])
+}
+
+// parseMACTrailer returns the algorithm of the trailer ending data
+func parseMACTrailer(data []byte) (MACAlgorithm, bool) {
+	if len(data) < macTrailerSize || !bytes.HasSuffix(data, macTrailerMagic) {
+		return "", false
+	}
+	id := data[len(data)-len(macTrailerMagic)-1]
+	for alg, algID := range macAlgorithmIDs {
+		if algID == id {
+			return alg, true
+		}
+	}
+	return "", false
+}
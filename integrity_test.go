@@ -0,0 +1,128 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_ComputeMAC(t *testing.T) {
+	tests := []struct {
+		name    string
+		alg     MACAlgorithm
+		key     []byte
+		want    string
+		wantErr bool
+	}{
+		// RFC 4231 test case 2
+		{"hmac-sha256", HMACSHA256, []byte("Jefe"), "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843", false},
+		{"blake2b", BLAKE2b256MAC, []byte("Jefe"), "", false},
+		{"no-key", HMACSHA256, nil, "", true},
+		{"blake2b-long-key", BLAKE2b256MAC, make([]byte, 65), "", true},
+		{"unsupported", "POLY1305", []byte("Jefe"), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := []byte("what do ya want for nothing?")
+			mac, err := ComputeMACWith(tt.alg, bytes.NewReader(data), tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ComputeMACWith() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.want != "" && hex.EncodeToString(mac) != tt.want {
+				t.Fatalf("mac = %x, want %s", mac, tt.want)
+			}
+			if err := VerifyMACWith(tt.alg, bytes.NewReader(data), tt.key, mac); err != nil {
+				t.Fatal(err)
+			}
+			if err := VerifyMACWith(tt.alg, bytes.NewReader(data[1:]), tt.key, mac); err != ErrMACMismatch {
+				t.Fatalf("VerifyMACWith() err = %v, want %v", err, ErrMACMismatch)
+			}
+		})
+	}
+	mac, err := ComputeMAC(bytes.NewReader([]byte("hello world")), []byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyMAC(bytes.NewReader([]byte("hello world")), []byte("key"), mac); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyMAC(bytes.NewReader([]byte("hello world")), []byte("other"), mac); err != ErrMACMismatch {
+		t.Fatalf("VerifyMAC() err = %v, want %v", err, ErrMACMismatch)
+	}
+}
+
+func Test_AppendMAC(t *testing.T) {
+	key := []byte("mac key")
+	for _, alg := range []MACAlgorithm{HMACSHA256, BLAKE2b256MAC} {
+		t.Run(string(alg), func(t *testing.T) {
+			e := NewEncryptManager("password")
+			encrypted := legacyCFB(t, e, []byte("hello world"))
+			if HasMACTrailer(encrypted) {
+				t.Fatal("legacy ciphertext has a mac trailer")
+			}
+			if _, err := StripMAC(encrypted, key); err != ErrNoMACTrailer {
+				t.Fatalf("StripMAC() err = %v, want %v", err, ErrNoMACTrailer)
+			}
+			trailed, err := AppendMAC(encrypted, key, alg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !HasMACTrailer(trailed) {
+				t.Fatal("mac trailer not found")
+			}
+			stripped, err := StripMAC(trailed, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := e.WithLegacyCFB().Decrypt(bytes.NewReader(stripped))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != "hello world" {
+				t.Fatalf("decrypted %q", out)
+			}
+			trailed[0] ^= 1
+			if _, err := StripMAC(trailed, key); err != ErrMACMismatch {
+				t.Fatalf("StripMAC() err = %v, want %v", err, ErrMACMismatch)
+			}
+		})
+	}
+}
+
+func Test_AppendMACFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "integrity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key := []byte("mac key")
+	path := filepath.Join(dir, "blob")
+	if err := ioutil.WriteFile(path, []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyMACFile(path, key); err != ErrNoMACTrailer {
+		t.Fatalf("VerifyMACFile() err = %v, want %v", err, ErrNoMACTrailer)
+	}
+	if err := AppendMACFile(path, key, BLAKE2b256MAC); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyMACFile(path, key); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := StripMAC(data, key); err != nil || string(out) != "hello world" {
+		t.Fatalf("StripMAC() = %q, %v", out, err)
+	}
+	if err := VerifyMACFile(path, []byte("other")); err != ErrMACMismatch {
+		t.Fatalf("VerifyMACFile() err = %v, want %v", err, ErrMACMismatch)
+	}
+}
@@ -0,0 +1,261 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// types of libp2p keys, as used by IPFS
+const (
+	libp2pRSA       = 0
+	libp2pEd25519   = 1
+	libp2pSecp256k1 = 2
+	libp2pECDSA     = 3
+)
+
+// WithIPFSKey is used to setup, and return EncryptManager for use with libp2p
+// keys, such as the keys of IPFS nodes, in the protobuf encoding used by libp2p
+// and the IPFS config. Encryption requires the public key, and decryption the
+// private key, so either may be nil. The scheme used depends on the type of key:
+// RSA keys wrap a random AES256-GCM key using RSA-OAEP with SHA-256, Ed25519
+// keys are converted to X25519 keys content is sealed to, and ECDSA keys use
+// ECIES. Secp256k1 keys are not supported
+func (e *EncryptManager) WithIPFSKey(public, private []byte) *EncryptManager {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.protocol = IPFSKey
+	e.ipfsPublic = public
+	e.ipfsPrivate = private
+	return e
+}
+
+// encryptIPFSKey encrypts given io.Reader to the configured libp2p public key
+func (e *EncryptManager) encryptIPFSKey(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	public, err := e.ipfsPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	if public, ok := public.(*rsa.PublicKey); ok {
+		return e.ipfsRSA(public, nil).encryptRSA(r)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return eciesSeal(e.randomness(), public, data)
+}
+
+// decryptIPFSKey decrypts given io.Reader using the configured libp2p private key
+func (e *EncryptManager) decryptIPFSKey(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	if e.ipfsPrivate == nil {
+		return nil, errors.New("no ipfs private key provided")
+	}
+	private, err := parseLibp2pPrivateKey(e.ipfsPrivate)
+	if err != nil {
+		return nil, err
+	}
+	if private, ok := private.(*rsa.PrivateKey); ok {
+		return e.ipfsRSA(nil, private).decryptRSA(r)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return eciesOpen(private, data)
+}
+
+// ipfsPublicKey returns the configured libp2p public key, or the public key of
+// the configured private key
+func (e *EncryptManager) ipfsPublicKey() (crypto.PublicKey, error) {
+	if e.ipfsPublic != nil {
+		return parseLibp2pPublicKey(e.ipfsPublic)
+	}
+	if e.ipfsPrivate == nil {
+		return nil, errors.New("no ipfs public key provided")
+	}
+	private, err := parseLibp2pPrivateKey(e.ipfsPrivate)
+	if err != nil {
+		return nil, err
+	}
+	if private, ok := private.(*rsa.PrivateKey); ok {
+		return &private.PublicKey, nil
+	}
+	return eciesPublicKey(private)
+}
+
+// ipfsRSA returns a manager using RSA-OAEP with the given RSA keys
+func (e *EncryptManager) ipfsRSA(public *rsa.PublicKey, private *rsa.PrivateKey) *EncryptManager {
+	return &EncryptManager{
+		random:      e.random,
+		nonceLog:    e.nonceLog,
+		rsaPublic:   public,
+		rsaPrivate:  rsaDecrypter(private),
+		rsaOAEPHash: crypto.SHA256,
+	}
+}
+
+// MarshalIPFSPublicKey is used to encode an *rsa.PublicKey, ed25519.PublicKey,
+// or *ecdsa.PublicKey in the libp2p protobuf encoding accepted by WithIPFSKey
+func MarshalIPFSPublicKey(public crypto.PublicKey) ([]byte, error) {
+	switch public := public.(type) {
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(public)
+		if err != nil {
+			return nil, err
+		}
+		return marshalLibp2pKey(libp2pRSA, der), nil
+	case ed25519.PublicKey:
+		return marshalLibp2pKey(libp2pEd25519, public), nil
+	case *ecdsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(public)
+		if err != nil {
+			return nil, err
+		}
+		return marshalLibp2pKey(libp2pECDSA, der), nil
+	default:
+		return nil, fmt.Errorf("unsupported ipfs key type %T", public)
+	}
+}
+
+// MarshalIPFSPrivateKey is used to encode an *rsa.PrivateKey, ed25519.PrivateKey,
+// or *ecdsa.PrivateKey in the libp2p protobuf encoding accepted by WithIPFSKey
+func MarshalIPFSPrivateKey(private crypto.PrivateKey) ([]byte, error) {
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		return marshalLibp2pKey(libp2pRSA, x509.MarshalPKCS1PrivateKey(private)), nil
+	case ed25519.PrivateKey:
+		return marshalLibp2pKey(libp2pEd25519, private), nil
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(private)
+		if err != nil {
+			return nil, err
+		}
+		return marshalLibp2pKey(libp2pECDSA, der), nil
+	default:
+		return nil, fmt.Errorf("unsupported ipfs key type %T", private)
+	}
+}
+
+// marshalLibp2pKey returns the protobuf encoding of a libp2p key
+func marshalLibp2pKey(keyType uint64, data []byte) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	out := []byte{1 << 3}
+	out = append(out, buf[:binary.PutUvarint(buf, keyType)]...)
+	out = append(out, 2<<3|2)
+	out = append(out, buf[:binary.PutUvarint(buf, uint64(len(data)))]...)
+	return append(out, data...)
+}
+
+// unmarshalLibp2pKey returns the type, and data of a protobuf encoded libp2p key
+func unmarshalLibp2pKey(data []byte) (uint64, []byte, error) {
+	var (
+		keyType         uint64
+		keyData         []byte
+		hasType, hasKey bool
+	)
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return 0, nil, errors.New("invalid ipfs key")
+		}
+		data = data[n:]
+		switch tag {
+		case 1<<3 | 0: // type, varint
+			if keyType, n = binary.Uvarint(data); n <= 0 {
+				return 0, nil, errors.New("invalid ipfs key")
+			}
+			data, hasType = data[n:], true
+		case 2<<3 | 2: // data, length delimited
+			length, n := binary.Uvarint(data)
+			if n <= 0 || length > uint64(len(data)-n) {
+				return 0, nil, errors.New("invalid ipfs key")
+			}
+			keyData, data, hasKey = data[n:n+int(length)], data[n+int(length):], true
+		default:
+			return 0, nil, errors.New("invalid ipfs key")
+		}
+	}
+	if !hasType || !hasKey {
+		return 0, nil, errors.New("invalid ipfs key")
+	}
+	return keyType, keyData, nil
+}
+
+// parseLibp2pPublicKey parses a protobuf encoded libp2p public key
+func parseLibp2pPublicKey(data []byte) (crypto.PublicKey, error) {
+	keyType, keyData, err := unmarshalLibp2pKey(data)
+	if err != nil {
+		return nil, err
+	}
+	switch keyType {
+	case libp2pRSA:
+		public, err := x509.ParsePKIXPublicKey(keyData)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := public.(*rsa.PublicKey); !ok {
+			return nil, errors.New("invalid ipfs rsa key")
+		}
+		return public, nil
+	case libp2pEd25519:
+		if len(keyData) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ipfs ed25519 key")
+		}
+		return ed25519.PublicKey(keyData), nil
+	case libp2pECDSA:
+		public, err := x509.ParsePKIXPublicKey(keyData)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := public.(*ecdsa.PublicKey); !ok {
+			return nil, errors.New("invalid ipfs ecdsa key")
+		}
+		return public, nil
+	case libp2pSecp256k1:
+		return nil, errors.New("secp256k1 ipfs keys are not supported")
+	default:
+		return nil, fmt.Errorf("unsupported ipfs key type %d", keyType)
+	}
+}
+
+// parseLibp2pPrivateKey parses a protobuf encoded libp2p private key
+func parseLibp2pPrivateKey(data []byte) (crypto.PrivateKey, error) {
+	keyType, keyData, err := unmarshalLibp2pKey(data)
+	if err != nil {
+		return nil, err
+	}
+	switch keyType {
+	case libp2pRSA:
+		return x509.ParsePKCS1PrivateKey(keyData)
+	case libp2pEd25519:
+		// older keys redundantly append the public key
+		if len(keyData) == ed25519.PrivateKeySize+ed25519.PublicKeySize {
+			keyData = keyData[:ed25519.PrivateKeySize]
+		}
+		if len(keyData) != ed25519.PrivateKeySize {
+			return nil, errors.New("invalid ipfs ed25519 key")
+		}
+		return ed25519.PrivateKey(keyData), nil
+	case libp2pECDSA:
+		return x509.ParseECPrivateKey(keyData)
+	case libp2pSecp256k1:
+		return nil, errors.New("secp256k1 ipfs keys are not supported")
+	default:
+		return nil, fmt.Errorf("unsupported ipfs key type %d", keyType)
+	}
+}
@@ -0,0 +1,136 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"testing"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/ed25519"
+)
+
+// marshalLibp2pKey encodes a key in the protobuf encoding used by libp2p
+func newLibp2pKeys(t *testing.T, keyType uint64) ([]byte, []byte) {
+	switch keyType {
+	case libp2pRSA:
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		public, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return marshalLibp2pKey(keyType, public), marshalLibp2pKey(keyType, x509.MarshalPKCS1PrivateKey(private))
+	case libp2pEd25519:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return marshalLibp2pKey(keyType, public), marshalLibp2pKey(keyType, private)
+	case libp2pECDSA:
+		private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		public, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.MarshalECPrivateKey(private)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return marshalLibp2pKey(keyType, public), marshalLibp2pKey(keyType, der)
+	default:
+		t.Fatalf("unsupported key type %d", keyType)
+		return nil, nil
+	}
+}
+
+func Test_EncryptManager_IPFSKey(t *testing.T) {
+	data := bytes.Repeat([]byte("hello world"), 1000)
+	tests := []struct {
+		name    string
+		keyType uint64
+	}{
+		{"rsa", libp2pRSA},
+		{"ed25519", libp2pEd25519},
+		{"ecdsa", libp2pECDSA},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			public, private := newLibp2pKeys(t, tt.keyType)
+			encrypted, err := NewEncryptManager("").WithIPFSKey(public, nil).Encrypt(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the public key is derived from the private key when not given
+			e := NewEncryptManager("").WithIPFSKey(nil, private)
+			decrypted, err := e.Decrypt(bytes.NewReader(encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+			if err := e.Health(); err != nil {
+				t.Fatal(err)
+			}
+			encrypted[len(encrypted)-1] ^= 0xff
+			if _, err := e.Decrypt(bytes.NewReader(encrypted)); err == nil {
+				t.Fatal("decrypted tampered data")
+			}
+			// content can only be decrypted using the matching key
+			_, other := newLibp2pKeys(t, tt.keyType)
+			encrypted[len(encrypted)-1] ^= 0xff
+			if _, err := NewEncryptManager("").WithIPFSKey(nil, other).Decrypt(bytes.NewReader(encrypted)); err == nil {
+				t.Fatal("decrypted using the wrong key")
+			}
+		})
+	}
+}
+
+func Test_EncryptManager_IPFSKey_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		key  []byte
+	}{
+		{"empty", nil},
+		{"truncated", []byte{1 << 3, 1, 2<<3 | 2, 32, 0}},
+		{"unknown-field", []byte{3 << 3, 1}},
+		{"secp256k1", marshalLibp2pKey(libp2pSecp256k1, make([]byte, 33))},
+		{"bad-ed25519", marshalLibp2pKey(libp2pEd25519, make([]byte, 31))},
+		{"bad-rsa", marshalLibp2pKey(libp2pRSA, []byte("not a key"))},
+		{"unknown-type", marshalLibp2pKey(9, make([]byte, 32))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewEncryptManager("").WithIPFSKey(tt.key, nil).Encrypt(bytes.NewReader([]byte("hello"))); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func Test_ed25519ToX25519(t *testing.T) {
+	for i := 0; i < 16; i++ {
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		converted, err := ed25519PublicToX25519(public)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var derived [32]byte
+		curve25519.ScalarBaseMult(&derived, ed25519PrivateToX25519(private))
+		if derived != *converted {
+			t.Fatal("converted public key does not match converted private key")
+		}
+	}
+}
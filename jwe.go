@@ -0,0 +1,495 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/ed25519"
+)
+
+// JWE key management algorithms, and content encryption
+const (
+	jweRSAOAEP      = "RSA-OAEP"
+	jweRSAOAEP256   = "RSA-OAEP-256"
+	jweECDHES       = "ECDH-ES"
+	jweECDHESA256KW = "ECDH-ES+A256KW"
+	jweA256GCM      = "A256GCM"
+)
+
+// jweEncoding is the unpadded base64url encoding used by JOSE
+var jweEncoding = base64.RawURLEncoding
+
+// JWE is a JSON Web Encryption object (RFC 7516) using A256GCM content
+// encryption, which encodes as the general JSON serialization. Members
+// holding binary data are base64url encoded
+type JWE struct {
+	Protected   string         `json:"protected"`
+	Unprotected *JWEHeader     `json:"unprotected,omitempty"`
+	Recipients  []JWERecipient `json:"recipients"`
+	AAD         string         `json:"aad,omitempty"`
+	IV          string         `json:"iv"`
+	Ciphertext  string         `json:"ciphertext"`
+	Tag         string         `json:"tag"`
+}
+
+// JWERecipient holds the content encryption key wrapped for a recipient
+type JWERecipient struct {
+	Header       *JWEHeader `json:"header,omitempty"`
+	EncryptedKey string     `json:"encrypted_key,omitempty"`
+}
+
+// JWEHeader holds the JOSE header parameters supported by this package
+type JWEHeader struct {
+	Algorithm  string `json:"alg,omitempty"`
+	Encryption string `json:"enc,omitempty"`
+	KeyID      string `json:"kid,omitempty"`
+	// EphemeralKey is the ephemeral public key of ECDH-ES
+	EphemeralKey *JWK   `json:"epk,omitempty"`
+	PartyUInfo   string `json:"apu,omitempty"`
+	PartyVInfo   string `json:"apv,omitempty"`
+	// Critical lists extensions which must be understood, and is always refused
+	Critical []string `json:"crit,omitempty"`
+	// Compression is refused, as compressing before encryption leaks the
+	// contents through the length of the ciphertext
+	Compression string `json:"zip,omitempty"`
+}
+
+// JWK is an elliptic curve, or X25519 public key (RFC 7518, and RFC 8037)
+type JWK struct {
+	KeyType string `json:"kty"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y,omitempty"`
+}
+
+// EncryptJWE is used to encrypt the given io.Reader as a JWE, so it can be
+// consumed by JOSE libraries, and web clients. Content is encrypted using
+// A256GCM under a random key, which is wrapped for every recipient using
+// RSA-OAEP-256 for RSA public keys, or ECDH-ES+A256KW for ECDSA public keys
+// using the NIST curves, and X25519, or Ed25519 public keys. The additional
+// data set using WithAdditionalData becomes the aad member, which can't be
+// represented by the compact serialization
+func (e *EncryptManager) EncryptJWE(r io.Reader, recipients ...interface{}) (*JWE, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	if len(recipients) == 0 {
+		return nil, errors.New("no jwe recipients provided")
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	cek := make([]byte, keylen)
+	if _, err := io.ReadFull(e.randomness(), cek); err != nil {
+		return nil, err
+	}
+	var (
+		protected = JWEHeader{Encryption: jweA256GCM}
+		jwe       JWE
+	)
+	for _, recipient := range recipients {
+		header, wrapped, err := e.wrapJWEKey(recipient, cek)
+		if err != nil {
+			return nil, err
+		}
+		jwe.Recipients = append(jwe.Recipients, JWERecipient{
+			Header:       header,
+			EncryptedKey: jweEncoding.EncodeToString(wrapped),
+		})
+	}
+	// a single recipient is protected, and representable as compact serialization
+	if len(recipients) == 1 {
+		header := jwe.Recipients[0].Header
+		header.Encryption = jweA256GCM
+		protected = *header
+		jwe.Recipients[0].Header = nil
+	}
+	encoded, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+	jwe.Protected = jweEncoding.EncodeToString(encoded)
+	if len(e.aad) > 0 {
+		jwe.AAD = jweEncoding.EncodeToString(e.aad)
+	}
+	aead, err := jweAEAD(cek)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(e.randomness(), iv); err != nil {
+		return nil, err
+	}
+	if err := e.recordNonce(cek, iv); err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nil, iv, data, jwe.additionalData())
+	tag := len(sealed) - aead.Overhead()
+	jwe.IV = jweEncoding.EncodeToString(iv)
+	jwe.Ciphertext = jweEncoding.EncodeToString(sealed[:tag])
+	jwe.Tag = jweEncoding.EncodeToString(sealed[tag:])
+	return &jwe, nil
+}
+
+// DecryptJWE is used to decrypt a JWE using the private key of a recipient,
+// being an *rsa.PrivateKey for RSA-OAEP, and RSA-OAEP-256, or an
+// *ecdsa.PrivateKey, X25519, or Ed25519 private key for ECDH-ES, and
+// ECDH-ES+A256KW. Only A256GCM content encryption is supported. Every
+// recipient is tried until one can be decrypted using the key
+func (e *EncryptManager) DecryptJWE(jwe *JWE, private interface{}) ([]byte, error) {
+	if jwe == nil {
+		return nil, errors.New("invalid jwe provided")
+	}
+	if err := e.checkApprovals(); err != nil {
+		return nil, err
+	}
+	var protected JWEHeader
+	encoded, err := jweEncoding.DecodeString(jwe.Protected)
+	if err != nil {
+		return nil, err
+	}
+	if len(encoded) > 0 {
+		if err := json.Unmarshal(encoded, &protected); err != nil {
+			return nil, err
+		}
+	}
+	iv, err := jweEncoding.DecodeString(jwe.IV)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := jweEncoding.DecodeString(jwe.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := jweEncoding.DecodeString(jwe.Tag)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := jweEncoding.DecodeString(jwe.AAD); err != nil {
+		return nil, err
+	}
+	for _, recipient := range jwe.Recipients {
+		header := mergeJWEHeaders(&protected, jwe.Unprotected, recipient.Header)
+		if len(header.Critical) > 0 {
+			return nil, errors.New("critical jwe extensions are not supported")
+		}
+		if header.Compression != "" {
+			return nil, errors.New("jwe compression is not supported")
+		}
+		if header.Encryption != jweA256GCM {
+			return nil, fmt.Errorf("unsupported jwe content encryption %s", header.Encryption)
+		}
+		wrapped, err := jweEncoding.DecodeString(recipient.EncryptedKey)
+		if err != nil {
+			return nil, err
+		}
+		cek, err := e.unwrapJWEKey(header, private, wrapped)
+		if err != nil || len(cek) != keylen {
+			continue
+		}
+		aead, err := jweAEAD(cek)
+		if err != nil {
+			return nil, err
+		}
+		if len(iv) != aead.NonceSize() || len(tag) != aead.Overhead() {
+			return nil, errors.New("invalid jwe iv, or tag")
+		}
+		return aead.Open(nil, iv, append(append([]byte{}, ciphertext...), tag...), jwe.additionalData())
+	}
+	return nil, ErrNoMatchingRecipient
+}
+
+// Compact returns the compact serialization of the JWE, which is only
+// possible for a single recipient, without additional data
+func (j *JWE) Compact() (string, error) {
+	if len(j.Recipients) != 1 || j.Recipients[0].Header != nil || j.Unprotected != nil || j.AAD != "" {
+		return "", errors.New("jwe can not be represented by the compact serialization")
+	}
+	return strings.Join([]string{j.Protected, j.Recipients[0].EncryptedKey, j.IV, j.Ciphertext, j.Tag}, "."), nil
+}
+
+// ParseJWE is used to decode a JWE in the compact, or either of the general,
+// and flattened JSON serializations
+func ParseJWE(data []byte) (*JWE, error) {
+	data = bytes.TrimSpace(data)
+	if !bytes.HasPrefix(data, []byte("{")) {
+		parts := strings.Split(string(data), ".")
+		if len(parts) != 5 {
+			return nil, errors.New("invalid jwe compact serialization")
+		}
+		return &JWE{
+			Protected:  parts[0],
+			Recipients: []JWERecipient{{EncryptedKey: parts[1]}},
+			IV:         parts[2],
+			Ciphertext: parts[3],
+			Tag:        parts[4],
+		}, nil
+	}
+	// the flattened serialization holds a single recipient at the top level
+	var jwe struct {
+		JWE
+		JWERecipient
+	}
+	if err := json.Unmarshal(data, &jwe); err != nil {
+		return nil, err
+	}
+	if len(jwe.Recipients) == 0 {
+		jwe.Recipients = []JWERecipient{jwe.JWERecipient}
+	} else if jwe.Header != nil || jwe.EncryptedKey != "" {
+		return nil, errors.New("invalid jwe json serialization")
+	}
+	return &jwe.JWE, nil
+}
+
+// additionalData returns the additional data authenticated by A256GCM
+func (j *JWE) additionalData() []byte {
+	if j.AAD == "" {
+		return []byte(j.Protected)
+	}
+	return []byte(j.Protected + "." + j.AAD)
+}
+
+// mergeJWEHeaders returns the union of the protected, shared unprotected,
+// and per recipient headers
+func mergeJWEHeaders(headers ...*JWEHeader) *JWEHeader {
+	var out JWEHeader
+	for _, h := range headers {
+		if h == nil {
+			continue
+		}
+		if h.Algorithm != "" {
+			out.Algorithm = h.Algorithm
+		}
+		if h.Encryption != "" {
+			out.Encryption = h.Encryption
+		}
+		if h.KeyID != "" {
+			out.KeyID = h.KeyID
+		}
+		if h.EphemeralKey != nil {
+			out.EphemeralKey = h.EphemeralKey
+		}
+		if h.PartyUInfo != "" {
+			out.PartyUInfo = h.PartyUInfo
+		}
+		if h.PartyVInfo != "" {
+			out.PartyVInfo = h.PartyVInfo
+		}
+		out.Critical = append(out.Critical, h.Critical...)
+		if h.Compression != "" {
+			out.Compression = h.Compression
+		}
+	}
+	return &out
+}
+
+// wrapJWEKey wraps the content encryption key for recipient
+func (e *EncryptManager) wrapJWEKey(recipient interface{}, cek []byte) (*JWEHeader, []byte, error) {
+	if public, ok := recipient.(*rsa.PublicKey); ok {
+		wrapped, err := rsa.EncryptOAEP(sha256.New(), e.randomness(), public, cek, nil)
+		return &JWEHeader{Algorithm: jweRSAOAEP256}, wrapped, err
+	}
+	public, err := eciesRecipient(recipient)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unsupported jwe recipient %T", recipient)
+	}
+	var (
+		epk *JWK
+		z   []byte
+	)
+	switch public := public.(type) {
+	case *ecdsa.PublicKey:
+		ephemeral, err := ecdsa.GenerateKey(public.Curve, e.randomness())
+		if err != nil {
+			return nil, nil, err
+		}
+		if epk, err = ecJWK(&ephemeral.PublicKey); err != nil {
+			return nil, nil, err
+		}
+		x, _ := public.Curve.ScalarMult(public.X, public.Y, ephemeral.D.Bytes())
+		z = ecdhSecret(public.Curve, x)
+	case *[32]byte:
+		var ephemeral, ephemeralPublic, shared [32]byte
+		if _, err := io.ReadFull(e.randomness(), ephemeral[:]); err != nil {
+			return nil, nil, err
+		}
+		curve25519.ScalarBaseMult(&ephemeralPublic, &ephemeral)
+		curve25519.ScalarMult(&shared, &ephemeral, public)
+		epk = &JWK{KeyType: "OKP", Curve: "X25519", X: jweEncoding.EncodeToString(ephemeralPublic[:])}
+		z = shared[:]
+	}
+	header := &JWEHeader{Algorithm: jweECDHESA256KW, EphemeralKey: epk}
+	wrapped, err := WrapKey(concatKDF(z, jweECDHESA256KW, nil, nil, keylen), cek)
+	return header, wrapped, err
+}
+
+// unwrapJWEKey recovers the content encryption key using the private key
+func (e *EncryptManager) unwrapJWEKey(header *JWEHeader, private interface{}, wrapped []byte) ([]byte, error) {
+	switch header.Algorithm {
+	case jweRSAOAEP, jweRSAOAEP256:
+		key, ok := private.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("jwe recipient requires an rsa private key")
+		}
+		if header.Algorithm == jweRSAOAEP {
+			return rsa.DecryptOAEP(sha1.New(), e.randomness(), key, wrapped, nil)
+		}
+		return rsa.DecryptOAEP(crypto.SHA256.New(), e.randomness(), key, wrapped, nil)
+	case jweECDHES, jweECDHESA256KW:
+	default:
+		return nil, fmt.Errorf("unsupported jwe key management algorithm %s", header.Algorithm)
+	}
+	if header.EphemeralKey == nil {
+		return nil, errors.New("jwe recipient has no ephemeral key")
+	}
+	apu, err := jweEncoding.DecodeString(header.PartyUInfo)
+	if err != nil {
+		return nil, err
+	}
+	apv, err := jweEncoding.DecodeString(header.PartyVInfo)
+	if err != nil {
+		return nil, err
+	}
+	var z []byte
+	switch private := private.(type) {
+	case *ecdsa.PrivateKey:
+		public, err := header.EphemeralKey.ecdsaPublicKey(private.Curve)
+		if err != nil {
+			return nil, err
+		}
+		x, _ := private.Curve.ScalarMult(public.X, public.Y, private.D.Bytes())
+		z = ecdhSecret(private.Curve, x)
+	case *[32]byte, ed25519.PrivateKey:
+		key, ok := private.(*[32]byte)
+		if !ok {
+			key = ed25519PrivateToX25519(private.(ed25519.PrivateKey))
+		}
+		peer, err := header.EphemeralKey.x25519PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		var shared [32]byte
+		curve25519.ScalarMult(&shared, key, peer)
+		z = shared[:]
+	default:
+		return nil, fmt.Errorf("unsupported jwe private key %T", private)
+	}
+	// ECDH-ES uses the derived key directly as the content encryption key
+	if header.Algorithm == jweECDHES {
+		if len(wrapped) != 0 {
+			return nil, errors.New("invalid jwe encrypted key for ECDH-ES")
+		}
+		return concatKDF(z, header.Encryption, apu, apv, keylen), nil
+	}
+	return UnwrapKey(concatKDF(z, header.Algorithm, apu, apv, keylen), wrapped)
+}
+
+// concatKDF derives a key of size bytes from the shared secret using the
+// Concat KDF with SHA-256 (NIST SP 800-56A), as specified for ECDH-ES by RFC 7518
+func concatKDF(z []byte, algorithm string, apu, apv []byte, size int) []byte {
+	lengthPrefixed := func(b []byte) []byte {
+		out := make([]byte, 4, 4+len(b))
+		binary.BigEndian.PutUint32(out, uint32(len(b)))
+		return append(out, b...)
+	}
+	var info []byte
+	info = append(info, lengthPrefixed([]byte(algorithm))...)
+	info = append(info, lengthPrefixed(apu)...)
+	info = append(info, lengthPrefixed(apv)...)
+	bits := make([]byte, 4)
+	binary.BigEndian.PutUint32(bits, uint32(size*8))
+	info = append(info, bits...)
+	var out []byte
+	for counter := uint32(1); len(out) < size; counter++ {
+		h := sha256.New()
+		binary.Write(h, binary.BigEndian, counter)
+		h.Write(z)
+		h.Write(info)
+		out = h.Sum(out)
+	}
+	return out[:size]
+}
+
+// jweAEAD returns A256GCM using the 96 bit iv required by RFC 7518
+func jweAEAD(cek []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// jweCurves maps JWK curve names to the NIST curves
+var jweCurves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// ecJWK returns the JWK of an ECDSA public key
+func ecJWK(public *ecdsa.PublicKey) (*JWK, error) {
+	for name, curve := range jweCurves {
+		if curve != public.Curve {
+			continue
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		pad := func(n *big.Int) string {
+			b := make([]byte, size)
+			copy(b[size-len(n.Bytes()):], n.Bytes())
+			return jweEncoding.EncodeToString(b)
+		}
+		return &JWK{KeyType: "EC", Curve: name, X: pad(public.X), Y: pad(public.Y)}, nil
+	}
+	return nil, errors.New("unsupported jwe elliptic curve")
+}
+
+// ecdsaPublicKey returns the public key of an EC JWK on the given curve
+func (k *JWK) ecdsaPublicKey(curve elliptic.Curve) (*ecdsa.PublicKey, error) {
+	if k.KeyType != "EC" || jweCurves[k.Curve] != curve {
+		return nil, errors.New("jwe ephemeral key does not match the private key")
+	}
+	x, err := jweEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := jweEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, err
+	}
+	public := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	// points off the curve leak the private key through invalid curve attacks
+	if !curve.IsOnCurve(public.X, public.Y) {
+		return nil, errors.New("invalid jwe ephemeral key")
+	}
+	return public, nil
+}
+
+// x25519PublicKey returns the public key of an X25519 JWK
+func (k *JWK) x25519PublicKey() (*[32]byte, error) {
+	if k.KeyType != "OKP" || k.Curve != "X25519" {
+		return nil, errors.New("jwe ephemeral key does not match the private key")
+	}
+	x, err := jweEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, err
+	}
+	return x25519Key(x)
+}
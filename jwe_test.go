@@ -0,0 +1,227 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+func Test_ConcatKDF(t *testing.T) {
+	// RFC 7518 appendix C
+	d, err := jweEncoding.DecodeString("VEmDZpDXXK8p8N0Cndsxs924q6nS1RXFASRl6BfUqdw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	epk := &JWK{KeyType: "EC", Curve: "P-256", X: "gI0GAILBdu7T53akrFmMyGcsF3n5dO7MmwNBHKW5SV0", Y: "SLW_xSffzlPWrHEVI30DHM_4egVwt3NQqeUD7nMFpps"}
+	public, err := epk.ecdsaPublicKey(elliptic.P256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, _ := elliptic.P256().ScalarMult(public.X, public.Y, d)
+	key := concatKDF(ecdhSecret(elliptic.P256(), x), "A128GCM", []byte("Alice"), []byte("Bob"), 16)
+	if got := jweEncoding.EncodeToString(key); got != "VqqN6vgjbSBcIijNcacQGg" {
+		t.Fatalf("concatKDF = %s", got)
+	}
+}
+
+func Test_EncryptManager_JWE(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var x25519 [32]byte
+	if _, err := rand.Read(x25519[:]); err != nil {
+		t.Fatal(err)
+	}
+	x25519Public, err := eciesPublicKey(&x25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		public  interface{}
+		private interface{}
+		alg     string
+	}{
+		{"rsa", &rsaKey.PublicKey, rsaKey, jweRSAOAEP256},
+		{"p256", &p256.PublicKey, p256, jweECDHESA256KW},
+		{"p384", &p384.PublicKey, p384, jweECDHESA256KW},
+		{"x25519", x25519Public, &x25519, jweECDHESA256KW},
+		{"ed25519", edPublic, edPrivate, jweECDHESA256KW},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEncryptManager("")
+			jwe, err := e.EncryptJWE(strings.NewReader("hello world"), tt.public)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compact, err := jwe.Compact()
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := ParseJWE([]byte(compact))
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err := e.DecryptJWE(parsed, tt.private)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(decrypted) != "hello world" {
+				t.Fatalf("DecryptJWE = %s", decrypted)
+			}
+			if iv, _ := jweEncoding.DecodeString(parsed.IV); len(iv) != 12 {
+				t.Fatalf("iv size = %d, want 12", len(iv))
+			}
+			var header JWEHeader
+			protected, _ := jweEncoding.DecodeString(parsed.Protected)
+			if err := json.Unmarshal(protected, &header); err != nil {
+				t.Fatal(err)
+			}
+			if header.Algorithm != tt.alg || header.Encryption != jweA256GCM {
+				t.Fatalf("protected header = %s", protected)
+			}
+			// the protected header is authenticated
+			parsed.Protected = jweEncoding.EncodeToString(bytes.Replace(protected, []byte(`"enc"`), []byte(` "enc"`), 1))
+			if _, err := e.DecryptJWE(parsed, tt.private); err == nil {
+				t.Fatal("expected error decrypting modified jwe")
+			}
+		})
+	}
+	// several recipients use the general JSON serialization
+	e := NewEncryptManager("").WithAdditionalData([]byte("object"))
+	jwe, err := e.EncryptJWE(strings.NewReader("hello world"), &rsaKey.PublicKey, &p256.PublicKey, x25519Public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jwe.Compact(); err == nil {
+		t.Fatal("expected error using compact serialization with several recipients")
+	}
+	data, err := json.Marshal(jwe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseJWE(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, private := range []interface{}{rsaKey, p256, &x25519} {
+		decrypted, err := e.DecryptJWE(parsed, private)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(decrypted) != "hello world" {
+			t.Fatalf("DecryptJWE = %s", decrypted)
+		}
+	}
+	if _, err := e.DecryptJWE(parsed, p384); err != ErrNoMatchingRecipient {
+		t.Fatalf("DecryptJWE err = %v, want ErrNoMatchingRecipient", err)
+	}
+	parsed.AAD = jweEncoding.EncodeToString([]byte("other"))
+	if _, err := e.DecryptJWE(parsed, rsaKey); err == nil {
+		t.Fatal("expected error decrypting jwe with modified additional data")
+	}
+	if _, err := e.EncryptJWE(strings.NewReader("hello world"), "key"); err == nil {
+		t.Fatal("expected error using unsupported recipient")
+	}
+}
+
+func Test_DecryptJWE_Fixtures(t *testing.T) {
+	// produced by an independent implementation of RFC 7516
+	readKey := func(name string) []byte {
+		data, err := ioutil.ReadFile(filepath.Join("testdata/jwe", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode(data)
+		return block.Bytes
+	}
+	rsaKey, err := x509.ParsePKCS1PrivateKey(readKey("rsa.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := x509.ParseECPrivateKey(readKey("ec.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		file    string
+		private interface{}
+		want    string
+	}{
+		{"rsa-oaep.jwe", rsaKey, "hello from python rsa"},
+		{"ecdh-es.jwe", ecKey, "hello from python ecdh-es"},
+		{"ecdh-es-a256kw.json", ecKey, "hello from python flattened"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filepath.Join("testdata/jwe", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			jwe, err := ParseJWE(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err := NewEncryptManager("").DecryptJWE(jwe, tt.private)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(decrypted) != tt.want {
+				t.Fatalf("DecryptJWE = %s", decrypted)
+			}
+		})
+	}
+}
+
+func Test_ParseJWE(t *testing.T) {
+	// the flattened serialization holds the recipient at the top level
+	flattened := `{"protected":"eyJlbmMiOiJBMjU2R0NNIn0","header":{"alg":"RSA-OAEP"},"encrypted_key":"a2V5","iv":"aXY","ciphertext":"Y3Q","tag":"dGFn"}`
+	jwe, err := ParseJWE([]byte(flattened))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jwe.Recipients) != 1 || jwe.Recipients[0].Header.Algorithm != jweRSAOAEP || jwe.Recipients[0].EncryptedKey != "a2V5" {
+		t.Fatalf("ParseJWE = %+v", jwe)
+	}
+	for _, data := range []string{
+		"a.b.c.d",
+		`{"recipients":[{}],"encrypted_key":"a2V5"}`,
+		`{"protected":`,
+	} {
+		if _, err := ParseJWE([]byte(data)); err == nil {
+			t.Fatalf("expected error parsing %s", data)
+		}
+	}
+	// critical extensions, and compression are refused
+	for _, header := range []string{`{"alg":"RSA-OAEP","enc":"A256GCM","zip":"DEF"}`, `{"alg":"RSA-OAEP","enc":"A256GCM","crit":["exp"]}`, `{"alg":"RSA-OAEP","enc":"A128GCM"}`} {
+		jwe := &JWE{Protected: jweEncoding.EncodeToString([]byte(header)), Recipients: []JWERecipient{{}}}
+		if _, err := NewEncryptManager("").DecryptJWE(jwe, nil); err == nil || err == ErrNoMatchingRecipient {
+			t.Fatalf("expected error decrypting jwe with header %s, got %v", header, err)
+		}
+	}
+}
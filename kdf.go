@@ -0,0 +1,411 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KDF identifies the function used to derive AES256-CFB keys from the passphrase
+type KDF string
+
+var (
+	// PBKDF2 derives keys using PBKDF2-SHA512, the default
+	PBKDF2 KDF = "pbkdf2"
+	// Argon2id derives keys using Argon2id
+	Argon2id KDF = "argon2id"
+	// Scrypt derives keys using scrypt
+	Scrypt KDF = "scrypt"
+)
+
+// kdfMagic prefixes AES256-CFB output recording the key derivation function
+// used, and kdfSaltMagic output which also records a salt length other than
+// the default, so the headers of other output remain readable by earlier versions
+var (
+	kdfMagic     = []byte("TKDF\x01")
+	kdfSaltMagic = []byte("TKDF\x02")
+)
+
+// maxKDFHeaderSize is the size of the largest kdf header, being the magic,
+// identifier, the 12 bytes of scrypt parameters, and the salt length
+const maxKDFHeaderSize = 5 + 1 + 12 + 1
+
+// identifiers of key derivation functions within the AES256-CFB header
+const (
+	kdfPBKDF2   byte = 1
+	kdfArgon2id byte = 2
+	kdfScrypt   byte = 3
+)
+
+// KDFConfig configures the key derivation function, and its cost parameters
+type KDFConfig struct {
+	KDF KDF `json:"kdf"`
+	// Iterations is the PBKDF2 iteration count, or the Argon2id time cost
+	Iterations uint32 `json:"iterations,omitempty"`
+	// Memory is the Argon2id memory cost in KiB
+	Memory uint32 `json:"memory,omitempty"`
+	// Threads is the Argon2id degree of parallelism
+	Threads uint8 `json:"threads,omitempty"`
+	// N is the scrypt CPU and memory cost, which must be a power of two
+	N uint32 `json:"n,omitempty"`
+	// R is the scrypt block size
+	R uint32 `json:"r,omitempty"`
+	// P is the scrypt degree of parallelism
+	P uint32 `json:"p,omitempty"`
+	// SaltLength is the length of the salt in bytes, defaulting to 32
+	SaltLength uint8 `json:"salt_length,omitempty"`
+}
+
+// DefaultKDFConfig returns recommended parameters for the given KDF. For PBKDF2
+// these are the legacy parameters used by Temporal
+func DefaultKDFConfig(kdf KDF) KDFConfig {
+	switch kdf {
+	case Argon2id:
+		return KDFConfig{KDF: Argon2id, Iterations: 1, Memory: 64 * 1024, Threads: 4}
+	case Scrypt:
+		return KDFConfig{KDF: Scrypt, N: 1 << 15, R: 8, P: 1}
+	default:
+		return KDFConfig{KDF: PBKDF2, Iterations: 4096}
+	}
+}
+
+// WithKDF is used to configure the key derivation function used by AES256-CFB,
+// which also protects the decryption parameters of other protocols. The KDF,
+// and its parameters are recorded in a header of the encrypted data, so
+// decryption selects them automatically. Without a KDF configured, the
+// headerless format using PBKDF2 with 4096 iterations is produced
+func (e *EncryptManager) WithKDF(cfg KDFConfig) *EncryptManager {
+	e.kdf = &cfg
+	return e
+}
+
+// WithPBKDF2Iterations is used to derive AES256-CFB keys using PBKDF2 with n
+// iterations, which are recorded in the header of the encrypted data along
+// with any salt length set using WithSaltLength
+func (e *EncryptManager) WithPBKDF2Iterations(n uint32) *EncryptManager {
+	cfg := KDFConfig{KDF: PBKDF2, Iterations: n}
+	if e.kdf != nil {
+		cfg.SaltLength = e.kdf.SaltLength
+	}
+	return e.WithKDF(cfg)
+}
+
+// WithSaltLength is used to set the length in bytes of the salt used to
+// derive AES256-CFB keys, between 16, and 255, which is recorded in the
+// header of the encrypted data. The legacy PBKDF2 parameters are used unless
+// a KDF is configured, which must be done before the salt length is set
+func (e *EncryptManager) WithSaltLength(n int) (*EncryptManager, error) {
+	if n < minSaltLength || n > 255 {
+		return nil, fmt.Errorf("salt length must be between %d, and 255 bytes", minSaltLength)
+	}
+	cfg := DefaultKDFConfig(PBKDF2)
+	if e.kdf != nil {
+		cfg = *e.kdf
+	}
+	cfg.SaltLength = uint8(n)
+	return e.WithKDF(cfg), nil
+}
+
+// minSaltLength is the shortest salt accepted for key derivation
+const minSaltLength = 16
+
+// saltLength returns the length of the salt used with c, where a nil config
+// is the legacy PBKDF2 parameters
+func (c *KDFConfig) saltLength() int {
+	if c == nil || c.SaltLength == 0 {
+		return saltlen
+	}
+	return int(c.SaltLength)
+}
+
+// KDFLimits is the largest cost of key derivation accepted, protecting
+// against headers crafted to exhaust memory, or CPU before the data they
+// protect is authenticated
+type KDFLimits struct {
+	// MaxPBKDF2Iterations is the largest PBKDF2-SHA512 iteration count
+	MaxPBKDF2Iterations uint32
+	// MaxArgon2idIterations, MaxArgon2idMemory, and MaxArgon2idThreads are the
+	// largest Argon2id time cost, memory cost in KiB, and parallelism
+	MaxArgon2idIterations uint32
+	MaxArgon2idMemory     uint32
+	MaxArgon2idThreads    uint8
+	// MaxScryptN, MaxScryptR, and MaxScryptP are the largest scrypt
+	// parameters, and MaxScryptCost the largest product of N, r, and p
+	MaxScryptN    uint32
+	MaxScryptR    uint32
+	MaxScryptP    uint32
+	MaxScryptCost uint64
+}
+
+// DefaultKDFLimits returns the limits used unless configured otherwise, being
+// well above the recommended parameters, while using at most 1GiB of memory
+func DefaultKDFLimits() KDFLimits {
+	return KDFLimits{
+		MaxPBKDF2Iterations:   10000000,
+		MaxArgon2idIterations: 16,
+		MaxArgon2idMemory:     1024 * 1024,
+		MaxArgon2idThreads:    64,
+		MaxScryptN:            1 << 20,
+		MaxScryptR:            32,
+		MaxScryptP:            16,
+		MaxScryptCost:         1 << 23,
+	}
+}
+
+// validate ensures the parameters can be used, and do not exceed the
+// default limits
+func (c *KDFConfig) validate() error {
+	return c.validateWithin(DefaultKDFLimits())
+}
+
+// validateWithin ensures the parameters can be used, and do not exceed limits
+func (c *KDFConfig) validateWithin(limits KDFLimits) error {
+	if err := c.check(); err != nil {
+		return err
+	}
+	switch c.KDF {
+	case PBKDF2:
+		if c.Iterations > limits.MaxPBKDF2Iterations {
+			return fmt.Errorf("pbkdf2 iterations %d exceed maximum of %d", c.Iterations, limits.MaxPBKDF2Iterations)
+		}
+	case Argon2id:
+		if c.Iterations > limits.MaxArgon2idIterations || c.Memory > limits.MaxArgon2idMemory || c.Threads > limits.MaxArgon2idThreads {
+			return fmt.Errorf("argon2id parameters exceed maximum of iterations=%d, memory=%dKiB, threads=%d", limits.MaxArgon2idIterations, limits.MaxArgon2idMemory, limits.MaxArgon2idThreads)
+		}
+	case Scrypt:
+		if c.N > limits.MaxScryptN || c.R > limits.MaxScryptR || c.P > limits.MaxScryptP || uint64(c.N)*uint64(c.R)*uint64(c.P) > limits.MaxScryptCost {
+			return fmt.Errorf("scrypt parameters exceed maximum of N=%d, r=%d, p=%d, N*r*p=%d", limits.MaxScryptN, limits.MaxScryptR, limits.MaxScryptP, limits.MaxScryptCost)
+		}
+	}
+	return nil
+}
+
+// check ensures the parameters can be used, and encoded in a header,
+// without limiting their cost
+func (c *KDFConfig) check() error {
+	switch c.KDF {
+	case PBKDF2:
+		if c.Iterations == 0 {
+			return errors.New("pbkdf2 iterations must be greater than zero")
+		}
+	case Argon2id:
+		if c.Iterations == 0 || c.Memory == 0 || c.Threads == 0 {
+			return errors.New("argon2id time, memory, and threads must be greater than zero")
+		}
+	case Scrypt:
+		if c.N < 2 || c.N&(c.N-1) != 0 || c.R == 0 || c.P == 0 {
+			return errors.New("scrypt N must be a power of two, and r, p greater than zero")
+		}
+	default:
+		return fmt.Errorf("unsupported kdf %s", c.KDF)
+	}
+	if c.SaltLength != 0 && c.SaltLength < minSaltLength {
+		return fmt.Errorf("salt length must be at least %d bytes", minSaltLength)
+	}
+	return nil
+}
+
+// deriveKey derives a key from passphrase using the given salt. The cost of
+// the parameters must already be validated against the limits in use
+func (c *KDFConfig) deriveKey(passphrase, salt []byte) ([]byte, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	switch c.KDF {
+	case Argon2id:
+		return argon2.IDKey(passphrase, salt, c.Iterations, c.Memory, c.Threads, keylen), nil
+	case Scrypt:
+		return scrypt.Key(passphrase, salt, int(c.N), int(c.R), int(c.P), keylen)
+	default:
+		return pbkdf2.Key(passphrase, salt, int(c.Iterations), keylen, sha512.New), nil
+	}
+}
+
+// header encodes the KDF, and its parameters as
+// magic || id || parameters || [salt length]
+func (c *KDFConfig) header() ([]byte, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	magic := kdfMagic
+	if c.saltLength() != saltlen {
+		magic = kdfSaltMagic
+	}
+	buf := bytes.NewBuffer(append([]byte{}, magic...))
+	switch c.KDF {
+	case PBKDF2:
+		buf.WriteByte(kdfPBKDF2)
+		binary.Write(buf, binary.BigEndian, c.Iterations)
+	case Argon2id:
+		buf.WriteByte(kdfArgon2id)
+		binary.Write(buf, binary.BigEndian, c.Iterations)
+		binary.Write(buf, binary.BigEndian, c.Memory)
+		buf.WriteByte(c.Threads)
+	case Scrypt:
+		buf.WriteByte(kdfScrypt)
+		binary.Write(buf, binary.BigEndian, c.N)
+		binary.Write(buf, binary.BigEndian, c.R)
+		binary.Write(buf, binary.BigEndian, c.P)
+	}
+	if c.saltLength() != saltlen {
+		buf.WriteByte(c.SaltLength)
+	}
+	return buf.Bytes(), nil
+}
+
+// hasKDFHeader indicates whether data begins with a kdf header
+func hasKDFHeader(data []byte) bool {
+	return bytes.HasPrefix(data, kdfMagic) || bytes.HasPrefix(data, kdfSaltMagic)
+}
+
+// parseKDFHeader parses the header at the start of data, returning the KDF
+// configuration, and header length. A nil configuration is returned for
+// data without a header, which uses the legacy PBKDF2 parameters. The cost
+// of the parameters is validated against the limits in use before deriving keys
+func parseKDFHeader(data []byte) (*KDFConfig, int, error) {
+	if !hasKDFHeader(data) || len(data) < len(kdfMagic)+1 {
+		return nil, 0, nil
+	}
+	n := len(kdfMagic) + 1
+	cfg := &KDFConfig{}
+	var fields []interface{}
+	switch data[len(kdfMagic)] {
+	case kdfPBKDF2:
+		cfg.KDF = PBKDF2
+		fields = []interface{}{&cfg.Iterations}
+	case kdfArgon2id:
+		cfg.KDF = Argon2id
+		fields = []interface{}{&cfg.Iterations, &cfg.Memory, &cfg.Threads}
+	case kdfScrypt:
+		cfg.KDF = Scrypt
+		fields = []interface{}{&cfg.N, &cfg.R, &cfg.P}
+	default:
+		return nil, 0, fmt.Errorf("unsupported kdf %d", data[len(kdfMagic)])
+	}
+	if bytes.HasPrefix(data, kdfSaltMagic) {
+		fields = append(fields, &cfg.SaltLength)
+	}
+	r := bytes.NewReader(data[n:])
+	for _, field := range fields {
+		if err := binary.Read(r, binary.BigEndian, field); err != nil {
+			return nil, 0, errors.New("invalid kdf header")
+		}
+	}
+	n = len(data) - r.Len()
+	if err := cfg.check(); err != nil {
+		return nil, 0, err
+	}
+	return cfg, n, nil
+}
+
+// readKDFHeader reads a kdf header from r, for formats unable to peek at
+// their input. Errors reading r are returned unchanged
+func readKDFHeader(r io.Reader) (*KDFConfig, error) {
+	head := make([]byte, len(kdfMagic)+1)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	if !hasKDFHeader(head) {
+		return nil, errors.New("invalid kdf header")
+	}
+	var size int
+	switch head[len(kdfMagic)] {
+	case kdfPBKDF2:
+		size = 4
+	case kdfArgon2id:
+		size = 4 + 4 + 1
+	case kdfScrypt:
+		size = 4 + 4 + 4
+	default:
+		return nil, fmt.Errorf("unsupported kdf %d", head[len(kdfMagic)])
+	}
+	if bytes.HasPrefix(head, kdfSaltMagic) {
+		size++
+	}
+	params := make([]byte, size)
+	if _, err := io.ReadFull(r, params); err != nil {
+		return nil, err
+	}
+	cfg, _, err := parseKDFHeader(append(head, params...))
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// WithKDFLimits is used to raise, or lower the largest cost of key derivation
+// accepted, from the DefaultKDFLimits. Recorded parameters above the limits
+// are refused before any key is derived
+func (e *EncryptManager) WithKDFLimits(limits KDFLimits) *EncryptManager {
+	e.kdfLimits = &limits
+	return e
+}
+
+// getKDFLimits returns the configured limits, or the defaults
+func (e *EncryptManager) getKDFLimits() KDFLimits {
+	if e.kdfLimits != nil {
+		return *e.kdfLimits
+	}
+	return DefaultKDFLimits()
+}
+
+// cfbKey derives the AES256-CFB key for salt, using cfg when set
+func (e *EncryptManager) cfbKey(cfg *KDFConfig, salt []byte) ([]byte, error) {
+	if err := e.checkKDF(cfg); err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return e.deriveKey(salt), nil
+	}
+	if err := cfg.validateWithin(e.getKDFLimits()); err != nil {
+		return nil, err
+	}
+	return cfg.deriveKey(e.passphrase, salt)
+}
+
+// newKDFKey derives a key from the passphrase under a fresh salt, as cfbKey
+// does for AES256-CFB, returning the key, and the salt prefixed by the header
+// of the KDF set using WithKDF. Without a KDF only the salt is returned, and
+// the key is derived using the legacy function
+func (e *EncryptManager) newKDFKey() ([]byte, []byte, error) {
+	var header []byte
+	if e.kdf != nil {
+		var err error
+		if header, err = e.kdf.header(); err != nil {
+			return nil, nil, err
+		}
+	}
+	salt := make([]byte, e.kdf.saltLength())
+	if _, err := io.ReadFull(e.randomness(), salt); err != nil {
+		return nil, nil, err
+	}
+	key, err := e.cfbKey(e.kdf, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, append(header, salt...), nil
+}
+
+// readKDFKey derives the key recorded at the start of data by newKDFKey,
+// returning the key, and the length of the salt, and any KDF header
+func (e *EncryptManager) readKDFKey(data []byte) ([]byte, int, error) {
+	kdf, n, err := parseKDFHeader(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data) < n+kdf.saltLength() {
+		return nil, 0, errors.New("invalid salt")
+	}
+	key, err := e.cfbKey(kdf, data[n:n+kdf.saltLength()])
+	if err != nil {
+		return nil, 0, err
+	}
+	return key, n + kdf.saltLength(), nil
+}
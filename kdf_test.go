@@ -0,0 +1,286 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_EncryptManager_KDF(t *testing.T) {
+	data := []byte("hello world")
+	tests := []struct {
+		name string
+		cfg  KDFConfig
+	}{
+		{"pbkdf2", KDFConfig{KDF: PBKDF2, Iterations: 100000}},
+		{"argon2id", KDFConfig{KDF: Argon2id, Iterations: 1, Memory: 1024, Threads: 1}},
+		{"scrypt", KDFConfig{KDF: Scrypt, N: 1024, R: 8, P: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEncryptManager("helloworld").WithKDF(tt.cfg)
+			encrypted, err := e.Encrypt(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(encrypted[len(cfbMACMagic):], kdfMagic) {
+				t.Fatal("encrypted data does not record the kdf")
+			}
+			// decryption selects the kdf from the header
+			decrypted, err := NewEncryptManager("helloworld").Decrypt(bytes.NewReader(encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+			if decrypted, err = NewEncryptManager("wrong").Decrypt(bytes.NewReader(encrypted)); err == nil && bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted using the wrong passphrase")
+			}
+
+			var streamed, unstreamed bytes.Buffer
+			if err := e.EncryptStream(&streamed, bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+			if err := NewEncryptManager("helloworld").DecryptStream(&unstreamed, bytes.NewReader(streamed.Bytes())); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(unstreamed.Bytes(), data) {
+				t.Fatal("stream decryption does not match original")
+			}
+
+			env, payload, err := e.EncryptSplit(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if env.KDF == nil || *env.KDF != tt.cfg {
+				t.Fatalf("envelope kdf = %+v, want %+v", env.KDF, tt.cfg)
+			}
+			if decrypted, err = NewEncryptManager("helloworld").DecryptSplit(env, bytes.NewReader(payload)); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("split decryption does not match original")
+			}
+
+			// decryption parameters are protected using the kdf
+			gcm := NewEncryptManager("helloworld").WithKDF(tt.cfg).WithGCM(nil)
+			store := NewMemoryParamStore()
+			encrypted, err = gcm.EncryptAndStore(store, "object", bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decrypted, err = NewEncryptManager("helloworld").WithGCM(nil).LoadAndDecrypt(store, "object", bytes.NewReader(encrypted)); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("gcm decryption does not match original")
+			}
+		})
+	}
+
+	// without a kdf configured no kdf header is produced
+	encrypted, err := NewEncryptManager("helloworld").Encrypt(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encrypted) != len(cfbMACMagic)+16+len(data)+saltlen+cfbMACSize {
+		t.Fatal("unexpected header in output")
+	}
+
+	for _, cfg := range []KDFConfig{
+		{KDF: PBKDF2},
+		{KDF: Argon2id, Iterations: 1, Memory: 1024},
+		{KDF: Scrypt, N: 1000, R: 8, P: 1},
+		{KDF: "bcrypt"},
+	} {
+		if _, err := NewEncryptManager("helloworld").WithKDF(cfg).Encrypt(bytes.NewReader(data)); err == nil {
+			t.Fatalf("expected error using kdf config %+v", cfg)
+		}
+	}
+	if _, err := NewEncryptManager("helloworld").Decrypt(bytes.NewReader(append(append([]byte{}, kdfMagic...), 9))); err == nil {
+		t.Fatal("expected error decrypting unknown kdf")
+	}
+}
+
+func Test_KDFLimits(t *testing.T) {
+	data := []byte("hello world")
+	tests := []struct {
+		name   string
+		cfg    KDFConfig
+		forged KDFConfig
+	}{
+		{"pbkdf2", KDFConfig{KDF: PBKDF2, Iterations: 1000}, KDFConfig{KDF: PBKDF2, Iterations: 0xFFFFFFFF}},
+		{"argon2id", KDFConfig{KDF: Argon2id, Iterations: 1, Memory: 1024, Threads: 1}, KDFConfig{KDF: Argon2id, Iterations: 1, Memory: 0xFFFFFFFF, Threads: 1}},
+		{"argon2id-threads", KDFConfig{KDF: Argon2id, Iterations: 1, Memory: 1024, Threads: 1}, KDFConfig{KDF: Argon2id, Iterations: 1, Memory: 1024, Threads: 255}},
+		{"scrypt", KDFConfig{KDF: Scrypt, N: 1024, R: 8, P: 1}, KDFConfig{KDF: Scrypt, N: 1 << 31, R: 0xFFFFFFFF, P: 1}},
+		{"scrypt-cost", KDFConfig{KDF: Scrypt, N: 1024, R: 8, P: 1}, KDFConfig{KDF: Scrypt, N: 1 << 20, R: 32, P: 16}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEncryptManager("helloworld").WithKDF(tt.cfg)
+			encrypted, err := e.Encrypt(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			header, err := tt.cfg.header()
+			if err != nil {
+				t.Fatal(err)
+			}
+			forgedHeader, err := tt.forged.header()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// replace the header, which is refused before deriving any key
+			forged := append(append(append([]byte{}, cfbMACMagic...), forgedHeader...), encrypted[len(cfbMACMagic)+len(header):]...)
+			if _, err := NewEncryptManager("helloworld").Decrypt(bytes.NewReader(forged)); err == nil {
+				t.Fatal("expected error decrypting forged header")
+			}
+			if err := NewEncryptManager("helloworld").DecryptStream(&bytes.Buffer{}, bytes.NewReader(forged)); err == nil {
+				t.Fatal("expected error stream decrypting forged header")
+			}
+			if _, err := NewEncryptManager("helloworld").WithKDF(tt.forged).Encrypt(bytes.NewReader(data)); err == nil {
+				t.Fatal("expected error encrypting above the limits")
+			}
+		})
+	}
+
+	// a larger ceiling may be configured
+	costly := KDFConfig{KDF: Argon2id, Iterations: DefaultKDFLimits().MaxArgon2idIterations + 1, Memory: 1024, Threads: 1}
+	if _, err := NewEncryptManager("helloworld").WithKDF(costly).Encrypt(bytes.NewReader(data)); err == nil {
+		t.Fatal("expected error encrypting above the default limits")
+	}
+	limits := DefaultKDFLimits()
+	limits.MaxArgon2idIterations = costly.Iterations
+	encrypted, err := NewEncryptManager("helloworld").WithKDFLimits(limits).WithKDF(costly).Encrypt(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewEncryptManager("helloworld").Decrypt(bytes.NewReader(encrypted)); err == nil {
+		t.Fatal("expected error decrypting above the default limits")
+	}
+	decrypted, err := NewEncryptManager("helloworld").WithKDFLimits(limits).Decrypt(bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Fatal("decrypted data does not match original")
+	}
+	if _, err := New(WithPassphrase("helloworld"), WithKDF(costly)); err == nil {
+		t.Fatal("expected error configuring kdf above the default limits")
+	}
+	if _, err := New(WithPassphrase("helloworld"), WithKDFLimits(limits), WithKDF(costly)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_DefaultKDFConfig(t *testing.T) {
+	for _, kdf := range []KDF{PBKDF2, Argon2id, Scrypt} {
+		cfg := DefaultKDFConfig(kdf)
+		if cfg.KDF != kdf {
+			t.Fatalf("DefaultKDFConfig(%s).KDF = %s", kdf, cfg.KDF)
+		}
+		if err := cfg.validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test_EncryptManager_WithSaltLength(t *testing.T) {
+	data := []byte("hello world")
+	tests := []struct {
+		name    string
+		length  int
+		wantErr bool
+	}{
+		{"default", 32, false},
+		{"short", 16, false},
+		{"long", 255, false},
+		{"too-short", 8, true},
+		{"too-long", 256, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := NewEncryptManager("helloworld").WithPBKDF2Iterations(1000).WithSaltLength(tt.length)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WithSaltLength() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if e.kdf.KDF != PBKDF2 || e.kdf.Iterations != 1000 {
+				t.Fatalf("kdf = %+v", e.kdf)
+			}
+			encrypted, err := e.Encrypt(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// decryption relies on the recorded parameters, not the configuration
+			d := NewEncryptManager("helloworld")
+			if out, err := d.Decrypt(bytes.NewReader(encrypted)); err != nil || !bytes.Equal(out, data) {
+				t.Fatalf("Decrypt() = %q, %v", out, err)
+			}
+			in, err := Inspect(bytes.NewReader(encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if in.SaltSize != tt.length || in.KDF.Iterations != 1000 {
+				t.Fatalf("Inspect() = %+v", in)
+			}
+			var stream bytes.Buffer
+			if err := e.EncryptStream(&stream, bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			if err := d.DecryptStream(&out, bytes.NewReader(stream.Bytes())); err != nil || !bytes.Equal(out.Bytes(), data) {
+				t.Fatalf("DecryptStream() = %q, %v", out.Bytes(), err)
+			}
+			headed, err := e.Clone().WithHeader().Encrypt(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out, err := d.Decrypt(bytes.NewReader(headed)); err != nil || !bytes.Equal(out, data) {
+				t.Fatalf("Decrypt() header = %q, %v", out, err)
+			}
+			env, payload, err := e.EncryptSplit(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(env.Salt) != tt.length {
+				t.Fatalf("envelope salt is %d bytes", len(env.Salt))
+			}
+			if out, err := d.DecryptSplit(env, bytes.NewReader(payload)); err != nil || !bytes.Equal(out, data) {
+				t.Fatalf("DecryptSplit() = %q, %v", out, err)
+			}
+		})
+	}
+	// the default salt length keeps the version 1 kdf header
+	e, err := NewEncryptManager("helloworld").WithSaltLength(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := e.Encrypt(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(encrypted[len(cfbMACMagic):], kdfMagic) {
+		t.Fatal("default salt length changed the kdf header")
+	}
+	if _, _, err := parseKDFHeader(append(append([]byte{}, kdfSaltMagic...), kdfPBKDF2, 0, 0, 0, 1, 8)); err == nil {
+		t.Fatal("expected error parsing salt length below the minimum")
+	}
+	// scrypt parameters with a salt length make the largest kdf header
+	e, err = NewEncryptManager("helloworld").WithKDF(KDFConfig{KDF: Scrypt, N: 1024, R: 8, P: 1}).WithSaltLength(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header, err := e.kdf.header(); err != nil || len(header) != maxKDFHeaderSize {
+		t.Fatalf("kdf header is %d bytes, want %d", len(header), maxKDFHeaderSize)
+	}
+	var stream, out bytes.Buffer
+	if err := e.EncryptStream(&stream, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewEncryptManager("helloworld").DecryptStream(&out, bytes.NewReader(stream.Bytes())); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("DecryptStream() = %q, %v", out.Bytes(), err)
+	}
+}
@@ -0,0 +1,133 @@
+package crypto
+
+import (
+	"fmt"
+	"io"
+)
+
+// legacyKDF is the key derivation used by data which does not record its KDF
+var legacyKDF = KDFConfig{KDF: PBKDF2, Iterations: 4096}
+
+// KDFPolicy is the minimum cost of key derivation considered adequate for
+// passphrase protected data
+type KDFPolicy struct {
+	// MinPBKDF2Iterations is the minimum PBKDF2-SHA512 iteration count
+	MinPBKDF2Iterations uint32
+	// MinArgon2idIterations, and MinArgon2idMemory are the minimum Argon2id
+	// time cost, and memory cost in KiB
+	MinArgon2idIterations uint32
+	MinArgon2idMemory     uint32
+	// MinScryptN is the minimum scrypt CPU and memory cost
+	MinScryptN uint32
+	// Limits is the largest cost accepted, the DefaultKDFLimits when unset
+	Limits *KDFLimits
+	// Recommended is the configuration suggested for re-encrypting data which
+	// does not meet the policy
+	Recommended KDFConfig
+}
+
+// DefaultKDFPolicy returns the current policy, following the OWASP password
+// storage recommendations, and recommending Argon2id for re-encryption
+func DefaultKDFPolicy() KDFPolicy {
+	return KDFPolicy{
+		MinPBKDF2Iterations:   210000,
+		MinArgon2idIterations: 1,
+		MinArgon2idMemory:     46 * 1024,
+		MinScryptN:            1 << 15,
+		Recommended:           DefaultKDFConfig(Argon2id),
+	}
+}
+
+// KDFAdvice reports whether the key derivation protecting encrypted data meets a KDFPolicy
+type KDFAdvice struct {
+	// KDF is the key derivation function used, or nil if the data is not
+	// protected by the passphrase
+	KDF *KDFConfig
+	// Legacy indicates the KDF is not recorded, so the legacy PBKDF2
+	// parameters used by Temporal are assumed
+	Legacy bool
+	// Compliant indicates the KDF meets the policy
+	Compliant bool
+	// Problems describes every way the KDF falls short of the policy
+	Problems []string
+	// Recommended is the configuration to re-encrypt the data with, and Plan
+	// the steps to do so, set when the data is not compliant
+	Recommended *KDFConfig
+	Plan        []string
+}
+
+// Check reports whether cfg meets the policy. A nil cfg is treated as the
+// legacy PBKDF2 parameters
+func (p KDFPolicy) Check(cfg *KDFConfig) *KDFAdvice {
+	advice := &KDFAdvice{KDF: cfg}
+	if cfg == nil {
+		advice.KDF, advice.Legacy = &legacyKDF, true
+		advice.Problems = append(advice.Problems, "key derivation function is not recorded")
+	}
+	switch kdf := advice.KDF; kdf.KDF {
+	case PBKDF2:
+		if kdf.Iterations < p.MinPBKDF2Iterations {
+			advice.Problems = append(advice.Problems, fmt.Sprintf("pbkdf2 iterations %d below minimum of %d", kdf.Iterations, p.MinPBKDF2Iterations))
+		}
+	case Argon2id:
+		if kdf.Iterations < p.MinArgon2idIterations {
+			advice.Problems = append(advice.Problems, fmt.Sprintf("argon2id iterations %d below minimum of %d", kdf.Iterations, p.MinArgon2idIterations))
+		}
+		if kdf.Memory < p.MinArgon2idMemory {
+			advice.Problems = append(advice.Problems, fmt.Sprintf("argon2id memory %dKiB below minimum of %dKiB", kdf.Memory, p.MinArgon2idMemory))
+		}
+	case Scrypt:
+		if kdf.N < p.MinScryptN {
+			advice.Problems = append(advice.Problems, fmt.Sprintf("scrypt N %d below minimum of %d", kdf.N, p.MinScryptN))
+		}
+	default:
+		advice.Problems = append(advice.Problems, fmt.Sprintf("unsupported kdf %s", kdf.KDF))
+	}
+	limits := DefaultKDFLimits()
+	if p.Limits != nil {
+		limits = *p.Limits
+	}
+	if err := advice.KDF.validateWithin(limits); err != nil && advice.KDF.check() == nil {
+		advice.Problems = append(advice.Problems, err.Error())
+	}
+	advice.Compliant = len(advice.Problems) == 0
+	if !advice.Compliant {
+		recommended := p.Recommended
+		advice.Recommended = &recommended
+		advice.Plan = []string{
+			"decrypt the data using its current passphrase",
+			fmt.Sprintf("encrypt it again using WithKDF with %s", describeKDF(&recommended)),
+			"verify the new object decrypts, then replace the original",
+		}
+	}
+	return advice
+}
+
+// AdviseKDF is used to inspect the key derivation protecting the encrypted
+// data, or JSON encoded Envelope, read from r, reporting whether it meets the
+// policy without decrypting it. Data which does not describe itself is
+// assumed to be legacy AES256-CFB, while data whose key is not derived from
+// the passphrase is always compliant
+func AdviseKDF(r io.Reader, policy KDFPolicy) (*KDFAdvice, error) {
+	in, err := Inspect(r)
+	if err != nil {
+		return nil, err
+	}
+	if in.KDF == nil && in.Protocol != CFB && in.Format != FormatUnknown && !in.HasParams {
+		return &KDFAdvice{Compliant: true}, nil
+	}
+	return policy.Check(in.KDF), nil
+}
+
+// describeKDF formats the KDF, and its parameters
+func describeKDF(cfg *KDFConfig) string {
+	switch cfg.KDF {
+	case PBKDF2:
+		return fmt.Sprintf("%s (iterations=%d)", cfg.KDF, cfg.Iterations)
+	case Argon2id:
+		return fmt.Sprintf("%s (iterations=%d, memory=%dKiB, threads=%d)", cfg.KDF, cfg.Iterations, cfg.Memory, cfg.Threads)
+	case Scrypt:
+		return fmt.Sprintf("%s (N=%d, r=%d, p=%d)", cfg.KDF, cfg.N, cfg.R, cfg.P)
+	}
+	return string(cfg.KDF)
+}
@@ -0,0 +1,83 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func Test_KDFPolicy_Check(t *testing.T) {
+	policy := DefaultKDFPolicy()
+	tests := []struct {
+		name      string
+		cfg       *KDFConfig
+		compliant bool
+		problems  int
+	}{
+		{"legacy", nil, false, 2},
+		{"pbkdf2-weak", &KDFConfig{KDF: PBKDF2, Iterations: 4096}, false, 1},
+		{"pbkdf2-strong", &KDFConfig{KDF: PBKDF2, Iterations: 600000}, true, 0},
+		{"argon2id-default", &KDFConfig{KDF: Argon2id, Iterations: 1, Memory: 64 * 1024, Threads: 4}, true, 0},
+		{"argon2id-weak", &KDFConfig{KDF: Argon2id, Iterations: 0, Memory: 1024, Threads: 1}, false, 2},
+		{"scrypt-default", &KDFConfig{KDF: Scrypt, N: 1 << 15, R: 8, P: 1}, true, 0},
+		{"scrypt-weak", &KDFConfig{KDF: Scrypt, N: 1024, R: 8, P: 1}, false, 1},
+		{"argon2id-excessive", &KDFConfig{KDF: Argon2id, Iterations: 1, Memory: 0xFFFFFFFF, Threads: 4}, false, 1},
+		{"scrypt-excessive", &KDFConfig{KDF: Scrypt, N: 1 << 30, R: 8, P: 1}, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			advice := policy.Check(tt.cfg)
+			if advice.Compliant != tt.compliant || len(advice.Problems) != tt.problems {
+				t.Fatalf("Check() = %+v", advice)
+			}
+			if advice.Legacy != (tt.cfg == nil) {
+				t.Fatalf("Check() legacy = %v", advice.Legacy)
+			}
+			if (advice.Recommended == nil) != tt.compliant || (len(advice.Plan) == 0) != tt.compliant {
+				t.Fatalf("Check() recommended = %v, plan = %v", advice.Recommended, advice.Plan)
+			}
+		})
+	}
+}
+
+func Test_AdviseKDF(t *testing.T) {
+	data := []byte("hello world")
+	encrypt := func(e *EncryptManager) []byte {
+		out, err := e.Encrypt(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	env, _, err := NewEncryptManager("helloworld").WithGCM(nil).EncryptSplit(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	envJSON, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		data      []byte
+		compliant bool
+		legacy    bool
+	}{
+		{"cfb-legacy", encrypt(NewEncryptManager("helloworld")), false, true},
+		{"cfb-weak-scrypt", encrypt(NewEncryptManager("helloworld").WithKDF(KDFConfig{KDF: Scrypt, N: 1024, R: 8, P: 1})), false, false},
+		{"cfb-argon2id", encrypt(NewEncryptManager("helloworld").WithKDF(DefaultKDFConfig(Argon2id))), true, false},
+		{"envelope-legacy", envJSON, false, true},
+		{"gcm-stream-header", encrypt(NewEncryptManager("helloworld").WithGCMStream(nil).WithHeader()), true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			advice, err := AdviseKDF(bytes.NewReader(tt.data), DefaultKDFPolicy())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if advice.Compliant != tt.compliant || advice.Legacy != tt.legacy {
+				t.Fatalf("AdviseKDF() = %+v", advice)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package crypto
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by a CachingKeyProvider while requests to its
+// KeyProvider are suspended after repeated failures, and no cached key is available
+var ErrCircuitOpen = errors.New("key provider circuit is open")
+
+// KeyCacheConfig configures a CachingKeyProvider. Zero values use the defaults
+type KeyCacheConfig struct {
+	// TTL is how long keys are served from the cache, 5 minutes by default
+	TTL time.Duration
+	// RefreshAhead is the period before a key expires during which it is
+	// refreshed in the background, while the cached key is still returned.
+	// Disabled if zero
+	RefreshAhead time.Duration
+	// StaleTTL is how long after expiring a key may still be returned while
+	// the KeyProvider is failing. Disabled if zero
+	StaleTTL time.Duration
+	// FailureThreshold is the number of consecutive failures after which
+	// requests to the KeyProvider are suspended, 5 by default
+	FailureThreshold int
+	// Cooldown is how long requests are suspended for, after which a single
+	// request is let through to probe the KeyProvider, 30 seconds by default
+	Cooldown time.Duration
+	// Clock is the source of time, the system clock by default
+	Clock Clock
+}
+
+// CachingKeyProvider is a KeyProvider caching the keys of another, such as a
+// KeyProvider unwrapping keys using a KMS. Concurrent requests for the same key
+// share a single request to the KeyProvider, keys are refreshed ahead of
+// expiring in the background, and requests are suspended after repeated
+// failures, so bursts of requests don't overload the KMS, or stall while it
+// is unavailable
+type CachingKeyProvider struct {
+	provider KeyProvider
+	cfg      KeyCacheConfig
+
+	mux       sync.Mutex
+	entries   map[string]*keyCacheEntry
+	calls     map[string]*keyCall
+	failures  int
+	openUntil time.Time
+}
+
+// keyCacheEntry is a cached key, and the time it was retrieved
+type keyCacheEntry struct {
+	key     []byte
+	fetched time.Time
+}
+
+// keyCall is a request to the KeyProvider shared by concurrent callers
+type keyCall struct {
+	done chan struct{}
+	key  []byte
+	err  error
+}
+
+// NewCachingKeyProvider is used to cache the keys of provider using cfg
+func NewCachingKeyProvider(provider KeyProvider, cfg KeyCacheConfig) *CachingKeyProvider {
+	if cfg.TTL <= 0 {
+		cfg.TTL = 5 * time.Minute
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 5
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = 30 * time.Second
+	}
+	return &CachingKeyProvider{
+		provider: provider,
+		cfg:      cfg,
+		entries:  make(map[string]*keyCacheEntry),
+		calls:    make(map[string]*keyCall),
+	}
+}
+
+// Key returns the key for id, from the cache when possible
+func (c *CachingKeyProvider) Key(id string) ([]byte, error) {
+	now := c.now()
+	c.mux.Lock()
+	entry := c.entries[id]
+	open := now.Before(c.openUntil)
+	if entry != nil {
+		age := now.Sub(entry.fetched)
+		if age < c.cfg.TTL {
+			if c.cfg.RefreshAhead > 0 && age >= c.cfg.TTL-c.cfg.RefreshAhead && !open {
+				c.fetch(id)
+			}
+			c.mux.Unlock()
+			return copyKey(entry.key), nil
+		}
+		if age >= c.cfg.TTL+c.cfg.StaleTTL {
+			entry = nil
+		}
+	}
+	if open {
+		c.mux.Unlock()
+		if entry != nil {
+			return copyKey(entry.key), nil
+		}
+		return nil, ErrCircuitOpen
+	}
+	call := c.fetch(id)
+	c.mux.Unlock()
+	<-call.done
+	if call.err != nil {
+		if entry != nil {
+			return copyKey(entry.key), nil
+		}
+		return nil, call.err
+	}
+	return copyKey(call.key), nil
+}
+
+// Invalidate removes the key for id from the cache, ie after it was rotated
+func (c *CachingKeyProvider) Invalidate(id string) {
+	c.mux.Lock()
+	delete(c.entries, id)
+	c.mux.Unlock()
+}
+
+// Health reports ErrCircuitOpen while requests to the KeyProvider are
+// suspended, and otherwise checks the KeyProvider when it implements HealthChecker
+func (c *CachingKeyProvider) Health() error {
+	now := c.now()
+	c.mux.Lock()
+	open := now.Before(c.openUntil)
+	c.mux.Unlock()
+	if open {
+		return ErrCircuitOpen
+	}
+	if checker, ok := c.provider.(HealthChecker); ok {
+		return checker.Health()
+	}
+	return nil
+}
+
+// fetch starts retrieving the key for id, unless a request is in flight,
+// returning the request. The caller must hold mux
+func (c *CachingKeyProvider) fetch(id string) *keyCall {
+	if call, ok := c.calls[id]; ok {
+		return call
+	}
+	call := &keyCall{done: make(chan struct{})}
+	c.calls[id] = call
+	go func() {
+		key, err := c.provider.Key(id)
+		now := c.now()
+		c.mux.Lock()
+		delete(c.calls, id)
+		if err != nil {
+			if c.failures++; c.failures >= c.cfg.FailureThreshold {
+				c.openUntil = now.Add(c.cfg.Cooldown)
+			}
+		} else {
+			c.failures, c.openUntil = 0, time.Time{}
+			c.entries[id] = &keyCacheEntry{key: key, fetched: now}
+		}
+		call.key, call.err = key, err
+		c.mux.Unlock()
+		close(call.done)
+	}()
+	return call
+}
+
+// now returns the current time according to the configured clock
+func (c *CachingKeyProvider) now() time.Time {
+	if c.cfg.Clock == nil {
+		return time.Now()
+	}
+	return c.cfg.Clock.Now()
+}
+
+// copyKey returns a copy of key, so callers can't modify cached keys
+func copyKey(key []byte) []byte {
+	return append([]byte{}, key...)
+}
@@ -0,0 +1,183 @@
+package crypto
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// kmsProvider is a KeyProvider counting its requests, which fail while err is set
+type kmsProvider struct {
+	mux     sync.Mutex
+	calls   int
+	err     error
+	release chan struct{}
+}
+
+func (k *kmsProvider) Key(id string) ([]byte, error) {
+	if k.release != nil {
+		<-k.release
+	}
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	k.calls++
+	if k.err != nil {
+		return nil, k.err
+	}
+	return []byte("key-" + id), nil
+}
+
+func (k *kmsProvider) setErr(err error) {
+	k.mux.Lock()
+	k.err = err
+	k.mux.Unlock()
+}
+
+func (k *kmsProvider) count() int {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	return k.calls
+}
+
+func Test_CachingKeyProvider(t *testing.T) {
+	var (
+		mux sync.Mutex
+		now = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	)
+	advance := func(d time.Duration) {
+		mux.Lock()
+		now = now.Add(d)
+		mux.Unlock()
+	}
+	clock := ClockFunc(func() time.Time {
+		mux.Lock()
+		defer mux.Unlock()
+		return now
+	})
+	kms := &kmsProvider{}
+	cache := NewCachingKeyProvider(kms, KeyCacheConfig{
+		TTL:              time.Minute,
+		StaleTTL:         time.Minute,
+		FailureThreshold: 2,
+		Cooldown:         time.Minute,
+		Clock:            clock,
+	})
+	key, err := cache.Key("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(key) != "key-a" {
+		t.Fatalf("Key() = %s", key)
+	}
+	// cached keys can't be modified by callers
+	key[0] = 'x'
+	if key, err = cache.Key("a"); err != nil || string(key) != "key-a" || kms.count() != 1 {
+		t.Fatalf("Key() = %s, %v after %d calls", key, err, kms.count())
+	}
+	// expired keys are retrieved again
+	advance(time.Minute)
+	if _, err := cache.Key("a"); err != nil || kms.count() != 2 {
+		t.Fatalf("Key() err = %v after %d calls", err, kms.count())
+	}
+
+	// failures serve stale keys, and open the circuit
+	kms.setErr(errors.New("kms unavailable"))
+	advance(90 * time.Second)
+	for i := 0; i < 2; i++ {
+		if key, err := cache.Key("a"); err != nil || string(key) != "key-a" {
+			t.Fatalf("Key() = %s, %v", key, err)
+		}
+	}
+	if err := cache.Health(); err != ErrCircuitOpen {
+		t.Fatalf("Health() err = %v, want %v", err, ErrCircuitOpen)
+	}
+	calls := kms.count()
+	if _, err := cache.Key("b"); err != ErrCircuitOpen || kms.count() != calls {
+		t.Fatalf("Key() err = %v, want %v", err, ErrCircuitOpen)
+	}
+	// a probe is let through after the cooldown, closing the circuit
+	kms.setErr(nil)
+	advance(time.Minute)
+	if key, err := cache.Key("b"); err != nil || string(key) != "key-b" {
+		t.Fatalf("Key() = %s, %v", key, err)
+	}
+	if err := cache.Health(); err != nil {
+		t.Fatal(err)
+	}
+	cache.Invalidate("b")
+	calls = kms.count()
+	if _, err := cache.Key("b"); err != nil || kms.count() != calls+1 {
+		t.Fatalf("Key() err = %v, invalidated key not retrieved", err)
+	}
+}
+
+func Test_CachingKeyProvider_Singleflight(t *testing.T) {
+	kms := &kmsProvider{release: make(chan struct{})}
+	cache := NewCachingKeyProvider(kms, KeyCacheConfig{})
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key, err := cache.Key("a")
+			if err == nil && string(key) != "key-a" {
+				err = errors.New("unexpected key")
+			}
+			errs <- err
+		}()
+	}
+	// wait for the request to be in flight before releasing it
+	for {
+		cache.mux.Lock()
+		inflight := len(cache.calls)
+		cache.mux.Unlock()
+		if inflight == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(kms.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if kms.count() != 1 {
+		t.Fatalf("%d requests to the key provider, want 1", kms.count())
+	}
+}
+
+func Test_CachingKeyProvider_RefreshAhead(t *testing.T) {
+	var (
+		mux sync.Mutex
+		now = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	)
+	clock := ClockFunc(func() time.Time {
+		mux.Lock()
+		defer mux.Unlock()
+		return now
+	})
+	kms := &kmsProvider{}
+	cache := NewCachingKeyProvider(kms, KeyCacheConfig{TTL: time.Minute, RefreshAhead: 10 * time.Second, Clock: clock})
+	if _, err := cache.Key("a"); err != nil {
+		t.Fatal(err)
+	}
+	mux.Lock()
+	now = now.Add(55 * time.Second)
+	mux.Unlock()
+	// the cached key is returned while it is refreshed in the background
+	if _, err := cache.Key("a"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; kms.count() != 2; i++ {
+		if i > 1000 {
+			t.Fatal("key not refreshed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,166 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// maxKeyDirectoryEntrySize is the largest entry accepted from a key directory,
+// so a misbehaving directory cannot exhaust memory
+const maxKeyDirectoryEntrySize = 64 * 1024
+
+// KeyDirectory is used to resolve recipient names into verified public keys.
+// The directory can be any HTTPS endpoint serving key entries at <url>/<name>,
+// including IPNS names published through an IPFS gateway, ie:
+// https://gateway.temporal.cloud/ipns/<directory>
+type KeyDirectory struct {
+	// URL is the base location of the directory
+	URL string
+	// Client is used to query the directory, defaults to http.DefaultClient
+	Client *http.Client
+	// SigningKey, if set, is used to verify the signature of every entry
+	// returned by the directory
+	SigningKey ed25519.PublicKey
+	// TrustOnFirstUse pins the fingerprint of keys the first time they are resolved
+	TrustOnFirstUse bool
+
+	mux  sync.RWMutex
+	pins map[string][]byte
+}
+
+// KeyDirectoryEntry is a single entry served by a KeyDirectory
+type KeyDirectoryEntry struct {
+	Name      string `json:"name"`
+	Key       []byte `json:"key"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// NewKeyDirectory is used to instantiate a client for the key directory at the given url
+func NewKeyDirectory(url string) *KeyDirectory {
+	return &KeyDirectory{
+		URL:  strings.TrimSuffix(url, "/"),
+		pins: make(map[string][]byte),
+	}
+}
+
+// KeyFingerprint returns the fingerprint of the given public key
+func KeyFingerprint(key []byte) []byte {
+	sum := sha256.Sum256(key)
+	return sum[:]
+}
+
+// SignKeyDirectoryEntry is used by directory operators to sign the entry for name
+func SignKeyDirectoryEntry(signingKey ed25519.PrivateKey, name string, key []byte) *KeyDirectoryEntry {
+	return &KeyDirectoryEntry{
+		Name:      name,
+		Key:       key,
+		Signature: ed25519.Sign(signingKey, directoryEntryMessage(name, key)),
+	}
+}
+
+// Pin is used to require that the key resolved for name has the given fingerprint
+func (d *KeyDirectory) Pin(name string, fingerprint []byte) {
+	d.mux.Lock()
+	d.pin(name, fingerprint)
+	d.mux.Unlock()
+}
+
+// pin records the fingerprint for name, allowing KeyDirectory to be used
+// without NewKeyDirectory. The caller must hold the lock
+func (d *KeyDirectory) pin(name string, fingerprint []byte) {
+	if d.pins == nil {
+		d.pins = make(map[string][]byte)
+	}
+	d.pins[name] = fingerprint
+}
+
+// Lookup is used to resolve the public key for name, verifying the entry
+// against the directory signing key, and any pinned fingerprint
+func (d *KeyDirectory) Lookup(name string) ([]byte, error) {
+	if name == "" {
+		return nil, errors.New("no name provided")
+	}
+	if !strings.HasPrefix(d.URL, "https://") {
+		return nil, errors.New("key directory must be served over https")
+	}
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(d.URL + "/" + url.PathEscape(name))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("key directory returned status %d for %s", resp.StatusCode, name)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxKeyDirectoryEntrySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxKeyDirectoryEntrySize {
+		return nil, fmt.Errorf("key directory entry for %s exceeds %d bytes", name, maxKeyDirectoryEntrySize)
+	}
+	var entry KeyDirectoryEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	if err := d.verify(name, &entry); err != nil {
+		return nil, err
+	}
+	return entry.Key, nil
+}
+
+// verify checks the given entry against the signing key and pins
+func (d *KeyDirectory) verify(name string, entry *KeyDirectoryEntry) error {
+	if entry.Name != name {
+		return fmt.Errorf("key directory returned entry for %s, expected %s", entry.Name, name)
+	}
+	if len(entry.Key) == 0 {
+		return errors.New("key directory returned an empty key")
+	}
+	if d.SigningKey != nil {
+		if !ed25519.Verify(d.SigningKey, directoryEntryMessage(name, entry.Key), entry.Signature) {
+			return errors.New("invalid key directory entry signature")
+		}
+	}
+	fingerprint := KeyFingerprint(entry.Key)
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	pinned, ok := d.pins[name]
+	if !ok {
+		if d.TrustOnFirstUse {
+			d.pin(name, fingerprint)
+		}
+		return nil
+	}
+	if !bytes.Equal(pinned, fingerprint) {
+		return fmt.Errorf("key for %s does not match pinned fingerprint", name)
+	}
+	return nil
+}
+
+// directoryEntryMessage returns the message signed for a directory entry,
+// binding the key to the name it is published under. Both are length
+// prefixed, so no name, and key can be split differently to forge another entry
+func directoryEntryMessage(name string, key []byte) []byte {
+	buf := bytes.NewBufferString("temporal-key-directory")
+	binary.Write(buf, binary.BigEndian, uint64(len(name)))
+	buf.WriteString(name)
+	binary.Write(buf, binary.BigEndian, uint64(len(key)))
+	buf.Write(key)
+	return buf.Bytes()
+}
@@ -0,0 +1,104 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+func Test_KeyDirectory_EntryMessage(t *testing.T) {
+	// moving bytes between the name, and key must change the signed message
+	if bytes.Equal(directoryEntryMessage("alice", []byte(":key")), directoryEntryMessage("alice:", []byte("key"))) {
+		t.Fatal("directory entry messages are ambiguous")
+	}
+}
+
+func Test_KeyDirectory(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := map[string]*KeyDirectoryEntry{
+		"alice":   SignKeyDirectoryEntry(priv, "alice", []byte("alice-public-key")),
+		"bob":     SignKeyDirectoryEntry(priv, "bob", []byte("bob-public-key")),
+		"mallory": {Name: "mallory", Key: []byte("mallory-public-key"), Signature: []byte("bad")},
+		// an entry for alice served under another name
+		"eve": SignKeyDirectoryEntry(priv, "alice", []byte("alice-public-key")),
+	}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entry, ok := entries[strings.TrimPrefix(r.URL.Path, "/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(entry)
+	}))
+	defer srv.Close()
+
+	dir := NewKeyDirectory(srv.URL)
+	dir.Client = srv.Client()
+	dir.SigningKey = pub
+	dir.Pin("bob", KeyFingerprint([]byte("old-bob-public-key")))
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"alice", "alice-public-key", false},
+		{"bob", "", true},
+		{"mallory", "", true},
+		{"eve", "", true},
+		{"unknown", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := dir.Lookup(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Lookup err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(key) != tt.want {
+				t.Fatalf("Lookup = %s, want %s", key, tt.want)
+			}
+		})
+	}
+
+	// trust on first use should pin the key and reject changes
+	dir = NewKeyDirectory(srv.URL)
+	dir.Client = srv.Client()
+	dir.TrustOnFirstUse = true
+	if _, err := dir.Lookup("alice"); err != nil {
+		t.Fatal(err)
+	}
+	entries["alice"] = SignKeyDirectoryEntry(priv, "alice", []byte("rotated-alice-public-key"))
+	if _, err := dir.Lookup("alice"); err == nil {
+		t.Fatal("expected error resolving key not matching pin")
+	}
+
+	// directories built without NewKeyDirectory should pin keys
+	dir = &KeyDirectory{URL: srv.URL, Client: srv.Client(), TrustOnFirstUse: true}
+	if _, err := dir.Lookup("bob"); err != nil {
+		t.Fatal(err)
+	}
+	dir = &KeyDirectory{URL: srv.URL, Client: srv.Client()}
+	dir.Pin("bob", KeyFingerprint([]byte("old-bob-public-key")))
+	if _, err := dir.Lookup("bob"); err == nil {
+		t.Fatal("expected error resolving key not matching pin")
+	}
+
+	// oversized entries should be rejected
+	entries["large"] = SignKeyDirectoryEntry(priv, "large", make([]byte, maxKeyDirectoryEntrySize))
+	if _, err := dir.Lookup("large"); err == nil {
+		t.Fatal("expected error resolving oversized entry")
+	}
+
+	// plain http directories should be rejected
+	if _, err := NewKeyDirectory("http://example.com").Lookup("alice"); err == nil {
+		t.Fatal("expected error using insecure directory")
+	}
+}
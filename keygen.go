@@ -0,0 +1,163 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// KeyType identifies the type of keypair generated by GenerateKeyPair
+type KeyType string
+
+var (
+	// Ed25519Key generates ed25519 keypairs
+	Ed25519Key KeyType = "ed25519"
+	// RSAKey generates 3072 bit RSA keypairs
+	RSAKey KeyType = "rsa"
+)
+
+const (
+	rsaKeyBits = 3072
+	// minRSAKeyBits is the smallest RSA key generated by GenerateRSAKeyPair
+	minRSAKeyBits = 2048
+	// encryptedPEMPrefix prefixes the PEM type of private keys protected using
+	// AES key wrap by earlier versions of GenerateKeyPair
+	encryptedPEMPrefix = "ENCRYPTED "
+)
+
+// GenerateKeyPair is used to generate a PEM encoded keypair of the given type.
+// When passphrase is not empty the private key is only ever returned encrypted,
+// as a PKCS #8 EncryptedPrivateKeyInfo using PBES2 with PBKDF2-HMAC-SHA256,
+// and AES-256-CBC, which records the salt, and iteration count, and is read
+// by openssl, so that an unencrypted private key never needs to touch disk
+func GenerateKeyPair(keyType KeyType, passphrase string) ([]byte, []byte, error) {
+	var (
+		public, private *pem.Block
+		key             interface{}
+	)
+	switch keyType {
+	case Ed25519Key:
+		pub, priv, err := GenerateEd25519KeyPair()
+		if err != nil {
+			return nil, nil, err
+		}
+		public = &pem.Block{Type: "ED25519 PUBLIC KEY", Bytes: pub}
+		private = &pem.Block{Type: "ED25519 PRIVATE KEY", Bytes: priv.Seed()}
+		key = priv
+	case RSAKey:
+		publicKey, priv, err := GenerateRSAKeyPair(rsaKeyBits)
+		if err != nil {
+			return nil, nil, err
+		}
+		pub, err := x509.MarshalPKIXPublicKey(publicKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		public = &pem.Block{Type: "PUBLIC KEY", Bytes: pub}
+		private = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)}
+		key = priv
+	default:
+		return nil, nil, fmt.Errorf("unsupported key type %s", keyType)
+	}
+	if passphrase != "" {
+		der, err := marshalPKCS8(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		encrypted, err := encryptPKCS8(der, passphrase, pkcs8Iterations)
+		if err != nil {
+			return nil, nil, err
+		}
+		private = &pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: encrypted}
+	}
+	return pem.EncodeToMemory(public), pem.EncodeToMemory(private), nil
+}
+
+// GenerateRSAKeyPair is used to generate an RSA keypair of the given size, of
+// at least 2048 bits, for use with WithRSA, or WithRecipients. Use
+// MarshalIPFSPublicKey, and MarshalIPFSPrivateKey to obtain the encoding
+// accepted by WithIPFSKey
+func GenerateRSAKeyPair(bits int) (*rsa.PublicKey, *rsa.PrivateKey, error) {
+	if bits < minRSAKeyBits {
+		return nil, nil, fmt.Errorf("rsa keys must be at least %d bits", minRSAKeyBits)
+	}
+	private, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &private.PublicKey, private, nil
+}
+
+// GenerateEd25519KeyPair is used to generate an ed25519 keypair, for use with
+// WithECIES, WithRecipients, SignAndEncrypt, or, once marshaled, WithIPFSKey
+func GenerateEd25519KeyPair() (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	return ed25519.GenerateKey(rand.Reader)
+}
+
+// GenerateAESKey is used to generate a random 256 bit key, such as the cipher
+// key of GCMDecryptParams, or a key-encryption key for WrapKey
+func GenerateAESKey() ([]byte, error) {
+	key := make([]byte, keylen)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ParsePrivateKey is used to parse a private key generated by GenerateKeyPair,
+// a SEC 1 ECDSA private key, or a PKCS #1, or PKCS #8 private key as produced
+// by openssl, returning an ed25519.PrivateKey, *rsa.PrivateKey, or
+// *ecdsa.PrivateKey. PKCS #8 keys encrypted using PBES2, and PEM blocks
+// encrypted using a Proc-Type header are supported, as are keys encrypted
+// using AES key wrap by earlier versions of GenerateKeyPair. The passphrase
+// is only required for encrypted private keys
+func ParsePrivateKey(data []byte, passphrase string) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no pem encoded private key found")
+	}
+	keyType, der := block.Type, block.Bytes
+	encrypted := keyType == "ENCRYPTED PRIVATE KEY" || x509.IsEncryptedPEMBlock(block) || strings.HasPrefix(keyType, encryptedPEMPrefix)
+	if encrypted && passphrase == "" {
+		return nil, errors.New("private key is encrypted, but no passphrase was provided")
+	}
+	var err error
+	switch {
+	case keyType == "ENCRYPTED PRIVATE KEY":
+		der, err = decryptPKCS8(der, passphrase)
+		keyType = "PRIVATE KEY"
+	case x509.IsEncryptedPEMBlock(block):
+		der, err = x509.DecryptPEMBlock(block, []byte(passphrase))
+	case strings.HasPrefix(keyType, encryptedPEMPrefix):
+		if len(der) <= saltlen {
+			return nil, errors.New("invalid encrypted private key")
+		}
+		der, err = UnwrapKeyWithPadding(NewEncryptManager(passphrase).deriveKey(der[:saltlen]), der[saltlen:])
+		keyType = strings.TrimPrefix(keyType, encryptedPEMPrefix)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch keyType {
+	case "ED25519 PRIVATE KEY":
+		if len(der) != ed25519.SeedSize {
+			return nil, errors.New("invalid ed25519 private key")
+		}
+		return ed25519.NewKeyFromSeed(der), nil
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(der)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(der)
+	case "PRIVATE KEY":
+		return parsePKCS8(der)
+	default:
+		return nil, fmt.Errorf("unsupported private key type %s", keyType)
+	}
+}
@@ -0,0 +1,206 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+func Test_GenerateKeyPair(t *testing.T) {
+	tests := []struct {
+		keyType    KeyType
+		passphrase string
+	}{
+		{Ed25519Key, ""},
+		{Ed25519Key, "helloworld"},
+		{RSAKey, "helloworld"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.keyType)+"/"+tt.passphrase, func(t *testing.T) {
+			public, private, err := GenerateKeyPair(tt.keyType, tt.passphrase)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encrypted := bytes.Contains(private, []byte("ENCRYPTED PRIVATE KEY")); encrypted != (tt.passphrase != "") {
+				t.Fatalf("unexpected private key format:\n%s", private)
+			}
+			key, err := ParsePrivateKey(private, tt.passphrase)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch key := key.(type) {
+			case ed25519.PrivateKey:
+				if !bytes.Contains(public, []byte("ED25519 PUBLIC KEY")) {
+					t.Fatalf("unexpected public key format:\n%s", public)
+				}
+			case *rsa.PrivateKey:
+				if err := key.Validate(); err != nil {
+					t.Fatal(err)
+				}
+			default:
+				t.Fatalf("unexpected private key type %T", key)
+			}
+			if tt.passphrase == "" {
+				return
+			}
+			for _, wrong := range []string{"", "wrong"} {
+				if _, err := ParsePrivateKey(private, wrong); err == nil {
+					t.Fatalf("expected error parsing with passphrase %q", wrong)
+				}
+			}
+		})
+	}
+	if _, _, err := GenerateKeyPair("dsa", ""); err == nil {
+		t.Fatal("expected error generating unsupported key type")
+	}
+	if _, err := ParsePrivateKey([]byte("not a key"), ""); err == nil {
+		t.Fatal("expected error parsing invalid key")
+	}
+}
+
+func Test_GenerateKeyPair_PKCS8(t *testing.T) {
+	_, private, err := GenerateKeyPair(Ed25519Key, "helloworld")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the key derivation function, and its parameters are recorded in the key
+	block, _ := pem.Decode(private)
+	var (
+		info   encryptedPrivateKeyInfo
+		params pbes2Params
+		kdf    pbkdf2Params
+	)
+	if _, err := asn1.Unmarshal(block.Bytes, &info); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		t.Fatal(err)
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) || !kdf.PRF.Algorithm.Equal(oidHMACWithSHA256) ||
+		kdf.Iterations != pkcs8Iterations || len(kdf.Salt) != saltlen || !params.EncryptionScheme.Algorithm.Equal(oidAES256CBC) {
+		t.Fatalf("unexpected key derivation function %+v", kdf)
+	}
+
+	// keys wrapped using AES key wrap by earlier versions remain readable
+	_, seed, err := GenerateEd25519KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt := bytes.Repeat([]byte{1}, saltlen)
+	wrapped, err := WrapKeyWithPadding(NewEncryptManager("helloworld").deriveKey(salt), seed.Seed())
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED ED25519 PRIVATE KEY", Bytes: append(salt, wrapped...)})
+	key, err := ParsePrivateKey(legacy, "helloworld")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key.(ed25519.PrivateKey), seed) {
+		t.Fatal("legacy private key does not match")
+	}
+}
+
+func Test_GenerateKeyPair_Interop(t *testing.T) {
+	openssl, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("openssl not found")
+	}
+	dir, err := ioutil.TempDir("", "keygen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, keyType := range []KeyType{Ed25519Key, RSAKey} {
+		_, private, err := GenerateKeyPair(keyType, "helloworld")
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, string(keyType)+".pem")
+		if err := ioutil.WriteFile(path, private, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if out, err := exec.Command(openssl, "pkey", "-in", path, "-passin", "pass:helloworld", "-noout").CombinedOutput(); err != nil {
+			t.Fatalf("openssl could not read %s key: %v\n%s", keyType, err, out)
+		}
+	}
+}
+
+func Test_GenerateKeys(t *testing.T) {
+	data := []byte("hello world")
+	if _, _, err := GenerateRSAKeyPair(1024); err == nil {
+		t.Fatal("expected error generating a small rsa key")
+	}
+	rsaPublic, rsaPrivate, err := GenerateRSAKeyPair(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edPrivate, err := GenerateEd25519KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	aesKey, err := GenerateAESKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aesKey) != keylen {
+		t.Fatalf("aes key length = %d", len(aesKey))
+	}
+	ipfsPublic, err := MarshalIPFSPublicKey(edPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipfsPrivate, err := MarshalIPFSPrivateKey(edPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipfsRSAPublic, err := MarshalIPFSPublicKey(rsaPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipfsRSAPrivate, err := MarshalIPFSPrivateKey(rsaPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MarshalIPFSPublicKey("not a key"); err == nil {
+		t.Fatal("expected error marshaling an unsupported key")
+	}
+	// the generated keys are directly usable by EncryptManager
+	tests := []struct {
+		name    string
+		encrypt *EncryptManager
+		decrypt *EncryptManager
+	}{
+		{"rsa", NewEncryptManager("").WithRSA(rsaPublic, nil), NewEncryptManager("").WithRSA(nil, rsaPrivate)},
+		{"ecies", NewEncryptManager("").WithECIES(edPublic, nil), NewEncryptManager("").WithECIES(nil, edPrivate)},
+		{"ipfs-ed25519", NewEncryptManager("").WithIPFSKey(ipfsPublic, nil), NewEncryptManager("").WithIPFSKey(nil, ipfsPrivate)},
+		{"ipfs-rsa", NewEncryptManager("").WithIPFSKey(ipfsRSAPublic, nil), NewEncryptManager("").WithIPFSKey(nil, ipfsRSAPrivate)},
+		{"recipients", NewEncryptManager("").WithRecipients(rsaPublic, edPublic), NewEncryptManager("").WithRecipientKey(edPrivate)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted, err := tt.encrypt.Encrypt(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err := tt.decrypt.Decrypt(bytes.NewReader(encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+		})
+	}
+}
@@ -0,0 +1,460 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// ErrKeyNotFound is returned when a key is not held by a KeyRing
+var ErrKeyNotFound = errors.New("key not found in keyring")
+
+// keyRingMagic prefixes keyring files
+var keyRingMagic = []byte("TKRG\x01")
+
+// operations recorded by keyring records
+const (
+	keyRingPut    byte = 1
+	keyRingDelete byte = 2
+)
+
+var (
+	// keyRingLockTimeout is how long updates wait for another process to
+	// release the keyring
+	keyRingLockTimeout = 10 * time.Second
+	// keyRingStaleLock is the age after which a lock left behind by a
+	// crashed process is removed
+	keyRingStaleLock = time.Minute
+)
+
+// KeyRing holds named keys in a passphrase protected file shared by the CLI,
+// and any other tools on the same machine. The file is an append-only log of
+// the format
+//
+//	"TKRG\x01" || len(kdf) || kdf || salt || records
+//
+// where every record is a 4 byte length followed by a nonce, and the
+// XChaCha20-Poly1305 sealed operation, name, and key, authenticated along
+// with the SHA-256 chain of the header, and all earlier records so records
+// can not be removed, or reordered. Updates hold a lock file, and replace
+// the keyring by renaming a complete copy over it, so concurrent updates are
+// never lost, and readers never see a partial update. A KeyRing is a KeyProvider
+type KeyRing struct {
+	path       string
+	passphrase []byte
+	kdf        KDFConfig
+
+	mux    sync.Mutex
+	header []byte
+	key    []byte
+	keys   map[string][]byte
+	size   int64
+	mod    time.Time
+}
+
+// OpenKeyRing is used to open the keyring at path, which is created by the
+// first Put, protecting new keyrings using Argon2id
+func OpenKeyRing(path, passphrase string) (*KeyRing, error) {
+	return OpenKeyRingWithKDF(path, passphrase, DefaultKDFConfig(Argon2id))
+}
+
+// OpenKeyRingWithKDF is used to open the keyring at path, protecting new
+// keyrings using the given KDF. Existing keyrings use the KDF they record
+func OpenKeyRingWithKDF(path, passphrase string, kdf KDFConfig) (*KeyRing, error) {
+	if passphrase == "" {
+		return nil, errors.New("no passphrase provided")
+	}
+	if err := kdf.validate(); err != nil {
+		return nil, err
+	}
+	k := &KeyRing{path: path, passphrase: []byte(passphrase), kdf: kdf, keys: make(map[string][]byte)}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Key returns the key stored under name, reloading the keyring if it was
+// updated by another process
+func (k *KeyRing) Key(name string) ([]byte, error) {
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	key, ok := k.keys[name]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return copyKey(key), nil
+}
+
+// Names returns the sorted names of all stored keys
+func (k *KeyRing) Names() ([]string, error) {
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	names := make([]string, 0, len(k.keys))
+	for name := range k.keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Put stores key under name, replacing any key previously stored under it
+func (k *KeyRing) Put(name string, key []byte) error {
+	if len(key) == 0 {
+		return errors.New("no key provided")
+	}
+	return k.update(keyRingPut, name, key)
+}
+
+// Delete removes the key stored under name
+func (k *KeyRing) Delete(name string) error {
+	return k.update(keyRingDelete, name, nil)
+}
+
+// Reload reads the keyring from disk if it changed since it was last read
+func (k *KeyRing) Reload() error {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	info, err := os.Stat(k.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.Size() == k.size && info.ModTime().Equal(k.mod) {
+		return nil
+	}
+	_, _, err = k.load()
+	return err
+}
+
+// load reads, and verifies the keyring, returning its contents, and the
+// chain of all its records
+func (k *KeyRing) load() ([]byte, []byte, error) {
+	file, err := os.Open(k.path)
+	if os.IsNotExist(err) {
+		k.keys, k.size, k.mod = make(map[string][]byte), 0, time.Time{}
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	header, records, err := k.parseHeader(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := make(map[string][]byte)
+	chain, err := k.openRecords(header, records, func(op byte, name string, key []byte) {
+		if op == keyRingPut {
+			keys[name] = key
+		} else {
+			delete(keys, name)
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	k.keys, k.size, k.mod = keys, info.Size(), info.ModTime()
+	return data, chain, nil
+}
+
+// openRecords opens every record following header in order, passing their
+// operation, name, and key to fn, returning the chain of all records
+func (k *KeyRing) openRecords(header, records []byte, fn func(op byte, name string, key []byte)) ([]byte, error) {
+	chain := sha256.Sum256(header)
+	for index := 0; len(records) > 0; index++ {
+		if len(records) < 4 || len(records)-4 < int(binary.BigEndian.Uint32(records)) {
+			return nil, fmt.Errorf("keyring record %d: truncated", index)
+		}
+		n := 4 + int(binary.BigEndian.Uint32(records))
+		op, name, key, err := k.openRecord(chain[:], records[4:n])
+		if err != nil {
+			return nil, fmt.Errorf("keyring record %d: %s", index, err)
+		}
+		if op != keyRingPut && op != keyRingDelete {
+			return nil, fmt.Errorf("keyring record %d: unsupported operation %d", index, op)
+		}
+		fn(op, name, key)
+		chain = sha256.Sum256(append(chain[:], records[:n]...))
+		records = records[n:]
+	}
+	return chain[:], nil
+}
+
+// ChangePassphrase is used to re-encrypt the keyring under passphrase, with
+// a new salt, and the key derivation function kdf, such as to upgrade its
+// parameters. Every record, including earlier versions of keys, and
+// deletions, is sealed again in order, so the history of the keyring is kept,
+// and the keyring is replaced in one operation, so it is never left
+// partially re-encrypted. A keyring which does not exist yet is created with
+// the new passphrase by the first Put
+func (k *KeyRing) ChangePassphrase(passphrase string, kdf KDFConfig) error {
+	if passphrase == "" {
+		return errors.New("no passphrase provided")
+	}
+	if err := kdf.validate(); err != nil {
+		return err
+	}
+	unlock, err := lockKeyRing(k.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	data, _, err := k.load()
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		k.passphrase, k.kdf = []byte(passphrase), kdf
+		return nil
+	}
+	_, body, err := k.parseHeader(data)
+	if err != nil {
+		return err
+	}
+	var ops []byte
+	var names []string
+	var keys [][]byte
+	if _, err := k.openRecords(data[:len(data)-len(body)], body, func(op byte, name string, key []byte) {
+		ops, names, keys = append(ops, op), append(names, name), append(keys, key)
+	}); err != nil {
+		return err
+	}
+	// the previous passphrase is restored unless the keyring is replaced
+	previous, previousKDF, previousHeader, previousKey := k.passphrase, k.kdf, k.header, k.key
+	k.passphrase, k.kdf = []byte(passphrase), kdf
+	restore := func(err error) error {
+		k.passphrase, k.kdf, k.header, k.key = previous, previousKDF, previousHeader, previousKey
+		return err
+	}
+	out, err := k.newHeader()
+	if err != nil {
+		return restore(err)
+	}
+	sum := sha256.Sum256(out)
+	chain := sum[:]
+	for i := range ops {
+		record, err := k.sealRecord(chain, ops[i], names[i], keys[i])
+		if err != nil {
+			return restore(err)
+		}
+		out = append(out, record...)
+		sum = sha256.Sum256(append(chain, record...))
+		chain = sum[:]
+	}
+	if err := writeKeyRing(k.path, out); err != nil {
+		return restore(err)
+	}
+	_, _, err = k.load()
+	return err
+}
+
+// parseHeader parses the keyring header, deriving the key of the keyring
+// when it differs from the one last read
+func (k *KeyRing) parseHeader(data []byte) ([]byte, []byte, error) {
+	if !bytes.HasPrefix(data, keyRingMagic) || len(data) < len(keyRingMagic)+1 {
+		return nil, nil, errors.New("invalid keyring format")
+	}
+	n := len(keyRingMagic) + 1 + int(data[len(keyRingMagic)])
+	if len(data) < n+saltlen {
+		return nil, nil, errors.New("invalid keyring format")
+	}
+	kdf, _, err := parseKDFHeader(data[len(keyRingMagic)+1 : n])
+	if err != nil {
+		return nil, nil, err
+	}
+	if kdf == nil {
+		return nil, nil, errors.New("invalid keyring kdf")
+	}
+	if err := kdf.validate(); err != nil {
+		return nil, nil, err
+	}
+	header := data[:n+saltlen]
+	if !bytes.Equal(header, k.header) {
+		key, err := kdf.deriveKey(k.passphrase, data[n:n+saltlen])
+		if err != nil {
+			return nil, nil, err
+		}
+		k.header, k.key = append([]byte{}, header...), key
+	}
+	return header, data[len(header):], nil
+}
+
+// newHeader returns the header of a new keyring, deriving its key
+func (k *KeyRing) newHeader() ([]byte, error) {
+	kdf, err := k.kdf.header()
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, saltlen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, err := k.kdf.deriveKey(k.passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	header := append(append(append([]byte{}, keyRingMagic...), byte(len(kdf))), kdf...)
+	k.header, k.key = append(header, salt...), key
+	return k.header, nil
+}
+
+// sealRecord returns the record of op, authenticated with the chain of all
+// earlier records
+func (k *KeyRing) sealRecord(chain []byte, op byte, name string, key []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(k.key)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer([]byte{op})
+	binary.Write(buf, binary.BigEndian, uint16(len(name)))
+	buf.WriteString(name)
+	buf.Write(key)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nonce, nonce, buf.Bytes(), chain)
+	record := make([]byte, 4, 4+len(sealed))
+	binary.BigEndian.PutUint32(record, uint32(len(sealed)))
+	return append(record, sealed...), nil
+}
+
+// openRecord returns the operation, name, and key of the sealed record
+func (k *KeyRing) openRecord(chain, sealed []byte) (byte, string, []byte, error) {
+	aead, err := chacha20poly1305.NewX(k.key)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return 0, "", nil, errors.New("truncated")
+	}
+	record, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], chain)
+	if err != nil {
+		return 0, "", nil, errors.New("invalid passphrase, or modified keyring")
+	}
+	if len(record) < 3 || len(record)-3 < int(binary.BigEndian.Uint16(record[1:])) {
+		return 0, "", nil, errors.New("invalid record")
+	}
+	n := 3 + int(binary.BigEndian.Uint16(record[1:]))
+	return record[0], string(record[3:n]), record[n:], nil
+}
+
+// update appends a record to the keyring while holding its lock, loading
+// changes made by other processes first so none are lost
+func (k *KeyRing) update(op byte, name string, key []byte) error {
+	if name == "" || len(name) > 0xffff {
+		return errors.New("invalid key name")
+	}
+	unlock, err := lockKeyRing(k.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	data, chain, err := k.load()
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		if data, err = k.newHeader(); err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		chain = sum[:]
+	}
+	record, err := k.sealRecord(chain, op, name, key)
+	if err != nil {
+		return err
+	}
+	if err := writeKeyRing(k.path, append(data, record...)); err != nil {
+		return err
+	}
+	_, _, err = k.load()
+	return err
+}
+
+// writeKeyRing replaces the keyring at path with data, writing it to a
+// temporary file which is renamed once complete
+func writeKeyRing(path string, data []byte) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".partial")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	if err := os.Chmod(file.Name(), 0600); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return nil
+}
+
+// lockKeyRing acquires the lock file of the keyring at path, shared by all
+// processes, returning the function releasing it
+func lockKeyRing(path string) (func(), error) {
+	lock := path + ".lock"
+	deadline := time.Now().Add(keyRingLockTimeout)
+	for {
+		file, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(lock); err == nil && time.Since(info.ModTime()) > keyRingStaleLock {
+			os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("timed out waiting for the keyring lock")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
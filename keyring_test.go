@@ -0,0 +1,236 @@
+package crypto
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testKeyRingKDF keeps keyring tests fast
+var testKeyRingKDF = KDFConfig{KDF: PBKDF2, Iterations: 1}
+
+func Test_KeyRing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keyring")
+	k, err := OpenKeyRingWithKDF(path, "password", testKeyRingKDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.Key("backup"); err != ErrKeyNotFound {
+		t.Fatalf("Key() err = %v, want %v", err, ErrKeyNotFound)
+	}
+	if err := k.Put("backup", []byte("key one")); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Put("archive", []byte("key two")); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Put("backup", []byte("key three")); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Delete("archive"); err != nil {
+		t.Fatal(err)
+	}
+	// another tool sees every update
+	other, err := OpenKeyRingWithKDF(path, "password", testKeyRingKDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, err := other.Key("backup"); err != nil || string(key) != "key three" {
+		t.Fatalf("Key() = %q, %v", key, err)
+	}
+	if names, err := other.Names(); err != nil || len(names) != 1 {
+		t.Fatalf("Names() = %v, %v", names, err)
+	}
+	if err := other.Put("archive", []byte("key four")); err != nil {
+		t.Fatal(err)
+	}
+	if key, err := k.Key("archive"); err != nil || string(key) != "key four" {
+		t.Fatalf("Key() = %q, %v", key, err)
+	}
+	if _, err := OpenKeyRingWithKDF(path, "wrong", testKeyRingKDF); err == nil {
+		t.Fatal("expected error opening keyring with wrong passphrase")
+	}
+	if err := k.Put("", []byte("key")); err == nil {
+		t.Fatal("expected error storing key without a name")
+	}
+	if err := k.Put("empty", nil); err == nil {
+		t.Fatal("expected error storing empty key")
+	}
+}
+
+func Test_KeyRing_ChangePassphrase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keyring")
+	k, err := OpenKeyRingWithKDF(path, "password", testKeyRingKDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, put := range [][2]string{{"backup", "key one"}, {"archive", "key two"}, {"backup", "key three"}, {"removed", "key four"}} {
+		if err := k.Put(put[0], []byte(put[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := k.Delete("removed"); err != nil {
+		t.Fatal(err)
+	}
+	upgraded := KDFConfig{KDF: Scrypt, N: 1024, R: 8, P: 1}
+	if err := k.ChangePassphrase("", upgraded); err == nil {
+		t.Fatal("expected error changing to empty passphrase")
+	}
+	if err := k.ChangePassphrase("new password", upgraded); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenKeyRingWithKDF(path, "password", testKeyRingKDF); err == nil {
+		t.Fatal("expected error opening keyring with the previous passphrase")
+	}
+	other, err := OpenKeyRingWithKDF(path, "new password", testKeyRingKDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"backup": "key three", "archive": "key two"}
+	for _, ring := range []*KeyRing{k, other} {
+		if names, err := ring.Names(); err != nil || len(names) != len(want) {
+			t.Fatalf("Names() = %v, %v", names, err)
+		}
+		for name, key := range want {
+			if got, err := ring.Key(name); err != nil || string(got) != key {
+				t.Fatalf("Key(%s) = %q, %v", name, got, err)
+			}
+		}
+	}
+	// the keyring records the upgraded kdf, and remains writable
+	if kdf, _, err := parseKDFHeader(other.header[len(keyRingMagic)+1:]); err != nil || *kdf != upgraded {
+		t.Fatalf("keyring kdf = %+v, %v", kdf, err)
+	}
+	if err := other.Put("archive", []byte("key five")); err != nil {
+		t.Fatal(err)
+	}
+	if key, err := k.Key("archive"); err != nil || string(key) != "key five" {
+		t.Fatalf("Key() = %q, %v", key, err)
+	}
+}
+
+func Test_KeyRing_Tampered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keyring")
+	k, err := OpenKeyRingWithKDF(path, "password", testKeyRingKDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := k.Put(fmt.Sprintf("key-%d", i), []byte("key")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := len(k.header)
+	first := 4 + int(binary.BigEndian.Uint32(data[header:]))
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"modified", append(append([]byte{}, data[:len(data)-1]...), data[len(data)-1]^1)},
+		{"removed", append(append([]byte{}, data[:header]...), data[header+first:]...)},
+		{"truncated", data[:len(data)-1]},
+		{"bad-magic", append([]byte("XKRG"), data[4:]...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ioutil.WriteFile(path, tt.data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := OpenKeyRingWithKDF(path, "password", testKeyRingKDF); err == nil {
+				t.Fatal("expected error opening modified keyring")
+			}
+		})
+	}
+}
+
+func Test_KeyRing_Concurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keyring")
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// every writer uses its own KeyRing, as separate processes would
+			k, err := OpenKeyRingWithKDF(path, "password", testKeyRingKDF)
+			if err != nil {
+				errs <- err
+				return
+			}
+			errs <- k.Put(fmt.Sprintf("key-%d", i), []byte("key"))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	k, err := OpenKeyRingWithKDF(path, "password", testKeyRingKDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names, err := k.Names(); err != nil || len(names) != 8 {
+		t.Fatalf("Names() = %v, %v", names, err)
+	}
+}
+
+func Test_lockKeyRing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keyring")
+	unlock, err := lockKeyRing(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeout := keyRingLockTimeout
+	keyRingLockTimeout = 50 * time.Millisecond
+	defer func() { keyRingLockTimeout = timeout }()
+	if _, err := lockKeyRing(path); err == nil {
+		t.Fatal("expected error acquiring held lock")
+	}
+	// locks left behind by crashed processes are removed
+	stale := time.Now().Add(-2 * keyRingStaleLock)
+	if err := os.Chtimes(path+".lock", stale, stale); err != nil {
+		t.Fatal(err)
+	}
+	again, err := lockKeyRing(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again()
+	unlock()
+}
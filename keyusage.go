@@ -0,0 +1,170 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrKeyUsage is returned when an operation is not permitted by the usage
+	// constraints of unwrapped decryption parameters
+	ErrKeyUsage = errors.New("operation not permitted by key usage constraints")
+	// ErrKeyExpired is returned when using unwrapped decryption parameters after their NotAfter time
+	ErrKeyExpired = errors.New("wrapped key has expired")
+)
+
+// KeyUsage constrains the use of decryption parameters wrapped using
+// RetrieveWrappedGCMDecryptionParameters, limiting the damage done by a leaked
+// parameter file. The constraints are integrity protected by the key wrap, and
+// enforced by EncryptManagers using parameters unwrapped by UnwrapGCMDecryptionParameters
+type KeyUsage struct {
+	// DecryptOnly prevents the parameters from being exported again, in any format
+	DecryptOnly bool
+	// NotAfter is the time after which the parameters are refused, ignored if zero
+	NotAfter time.Time
+	// AADPrefixes restricts decryption to additional data, set using
+	// WithAdditionalData, starting with one of the prefixes. Ignored if empty
+	AADPrefixes [][]byte
+}
+
+// WithKeyUsage is used to embed usage constraints in the decryption parameters
+// wrapped by RetrieveWrappedGCMDecryptionParameters. Constraints of unwrapped
+// parameters are carried over when wrapping them again, and can only be narrowed
+func (e *EncryptManager) WithKeyUsage(usage KeyUsage) *EncryptManager {
+	e.keyUsage = &usage
+	return e
+}
+
+// WithAdditionalData is used to authenticate aad along with the encrypted
+// data for AES256-GCM, GCM-STREAM, the AEAD profile, ChaCha20-Poly1305, and
+// XChaCha20-Poly1305, including stream encryption. The same additional data
+// is required for decryption
+func (e *EncryptManager) WithAdditionalData(aad []byte) *EncryptManager {
+	e.aad = aad
+	e.objectBound = false
+	return e
+}
+
+// permit checks the constraints allow decrypting with the additional data aad at now
+func (u *KeyUsage) permit(now time.Time, aad []byte) error {
+	if u == nil {
+		return nil
+	}
+	if u.expired(now) {
+		return ErrKeyExpired
+	}
+	if len(u.AADPrefixes) == 0 {
+		return nil
+	}
+	for _, prefix := range u.AADPrefixes {
+		if bytes.HasPrefix(aad, prefix) {
+			return nil
+		}
+	}
+	return ErrKeyUsage
+}
+
+// expired indicates whether now is after the NotAfter time
+func (u *KeyUsage) expired(now time.Time) bool {
+	return !u.NotAfter.IsZero() && now.After(u.NotAfter)
+}
+
+// narrow returns the constraints of both u, and other. The prefixes of u take
+// precedence, as allowing those of other would widen them
+func (u *KeyUsage) narrow(other *KeyUsage) *KeyUsage {
+	if u == nil || other == nil {
+		if u == nil {
+			return other
+		}
+		return u
+	}
+	out := *u
+	out.DecryptOnly = u.DecryptOnly || other.DecryptOnly
+	if out.NotAfter.IsZero() || (!other.NotAfter.IsZero() && other.NotAfter.Before(out.NotAfter)) {
+		out.NotAfter = other.NotAfter
+	}
+	if len(out.AADPrefixes) == 0 {
+		out.AADPrefixes = other.AADPrefixes
+	}
+	return &out
+}
+
+// marshal encodes the constraints as
+//
+//	flags || not after || count || len(prefix) || prefix ...
+//
+// where the not after time is in nanoseconds since the unix epoch, or zero
+func (u *KeyUsage) marshal() ([]byte, error) {
+	if len(u.AADPrefixes) > 255 {
+		return nil, errors.New("too many aad prefixes")
+	}
+	var flags byte
+	if u.DecryptOnly {
+		flags |= 1
+	}
+	out := make([]byte, 10)
+	out[0] = flags
+	binary.BigEndian.PutUint64(out[1:], uint64(validityTime(u.NotAfter)))
+	out[9] = byte(len(u.AADPrefixes))
+	for _, prefix := range u.AADPrefixes {
+		if len(prefix) > 255 {
+			return nil, errors.New("aad prefix is too long")
+		}
+		out = append(append(out, byte(len(prefix))), prefix...)
+	}
+	return out, nil
+}
+
+// parseKeyUsage decodes constraints encoded by marshal
+func parseKeyUsage(data []byte) (*KeyUsage, error) {
+	if len(data) < 10 || data[0]&^1 != 0 {
+		return nil, errors.New("invalid key usage")
+	}
+	u := &KeyUsage{DecryptOnly: data[0]&1 != 0}
+	if ns := int64(binary.BigEndian.Uint64(data[1:])); ns != 0 {
+		u.NotAfter = time.Unix(0, ns).UTC()
+	}
+	count := int(data[9])
+	data = data[10:]
+	for i := 0; i < count; i++ {
+		if len(data) < 1 || len(data) < 1+int(data[0]) {
+			return nil, errors.New("invalid key usage")
+		}
+		u.AADPrefixes = append(u.AADPrefixes, data[1:1+int(data[0])])
+		data = data[1+int(data[0]):]
+	}
+	if len(data) != 0 {
+		return nil, errors.New("invalid key usage")
+	}
+	return u, nil
+}
+
+// decryptionKey returns the decoded cipher key, and nonce for decryption,
+// enforcing the usage constraints of unwrapped parameters
+func (e *EncryptManager) decryptionKey() ([]byte, []byte, error) {
+	if params := e.getGCMDecryptParams(); params != nil {
+		if err := params.usage.permit(e.now(), e.aad); err != nil {
+			return nil, nil, err
+		}
+	}
+	return e.decodeGCMDecryptParams()
+}
+
+// checkKeyExport enforces the usage constraints of unwrapped parameters when
+// exporting them. Constrained parameters may only be exported wrapped, so the
+// constraints are preserved
+func (e *EncryptManager) checkKeyExport(wrapped bool) error {
+	params := e.getGCMDecryptParams()
+	if params == nil || params.usage == nil {
+		return nil
+	}
+	if params.usage.expired(e.now()) {
+		return ErrKeyExpired
+	}
+	if params.usage.DecryptOnly || !wrapped {
+		return ErrKeyUsage
+	}
+	return nil
+}
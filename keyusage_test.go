@@ -0,0 +1,124 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func Test_EncryptManager_KeyUsage(t *testing.T) {
+	data := []byte("hello world")
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := ClockFunc(func() time.Time { return now })
+	tests := []struct {
+		name      string
+		usage     *KeyUsage
+		aad       []byte
+		at        time.Time
+		wantErr   error
+		canExport bool
+	}{
+		{"unconstrained", nil, nil, now, nil, true},
+		{"decrypt-only", &KeyUsage{DecryptOnly: true}, nil, now, nil, false},
+		{"not-expired", &KeyUsage{NotAfter: now.Add(time.Hour)}, nil, now, nil, true},
+		{"expired", &KeyUsage{NotAfter: now.Add(time.Hour)}, nil, now.Add(2 * time.Hour), ErrKeyExpired, false},
+		{"aad-allowed", &KeyUsage{AADPrefixes: [][]byte{[]byte("tenant-a/"), []byte("tenant-b/")}}, []byte("tenant-b/object"), now, nil, true},
+		{"aad-denied", &KeyUsage{AADPrefixes: [][]byte{[]byte("tenant-a/")}}, []byte("tenant-b/object"), now, ErrKeyUsage, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEncryptManager("helloworld").WithGCM(nil).WithAdditionalData(tt.aad)
+			if tt.usage != nil {
+				e.WithKeyUsage(*tt.usage)
+			}
+			encrypted, err := e.Encrypt(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			wrapped, err := e.RetrieveWrappedGCMDecryptionParameters()
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := NewEncryptManager("helloworld").WithClock(clock)
+			params, err := d.UnwrapGCMDecryptionParameters(wrapped)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the constraints are enforced at the time of use
+			d.WithClock(ClockFunc(func() time.Time { return tt.at })).WithGCM(params).WithAdditionalData(tt.aad)
+			decrypted, err := d.Decrypt(bytes.NewReader(encrypted))
+			if err != tt.wantErr {
+				t.Fatalf("Decrypt() err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+			// constrained parameters are never exported without their constraints
+			if _, err := d.RetrieveGCMDecryptionParameters(); (err == nil) != (tt.usage == nil) {
+				t.Fatalf("RetrieveGCMDecryptionParameters() err = %v", err)
+			}
+			rewrapped, err := d.RetrieveWrappedGCMDecryptionParameters()
+			if (err == nil) != tt.canExport {
+				t.Fatalf("RetrieveWrappedGCMDecryptionParameters() err = %v", err)
+			}
+			if err != nil {
+				return
+			}
+			// the constraints are carried over
+			params, err = NewEncryptManager("helloworld").WithClock(clock).UnwrapGCMDecryptionParameters(rewrapped)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (params.usage == nil) != (len(wrapped) == saltlen+8+keylen+nonceSize) {
+				t.Fatal("constraints not carried over")
+			}
+		})
+	}
+
+	// expired parameters are refused when unwrapped
+	e := NewEncryptManager("helloworld").WithGCM(nil).WithKeyUsage(KeyUsage{NotAfter: now})
+	if _, err := e.Encrypt(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := e.RetrieveWrappedGCMDecryptionParameters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	late := ClockFunc(func() time.Time { return now.Add(time.Second) })
+	if _, err := NewEncryptManager("helloworld").WithClock(late).UnwrapGCMDecryptionParameters(wrapped); err != ErrKeyExpired {
+		t.Fatalf("UnwrapGCMDecryptionParameters() err = %v, want %v", err, ErrKeyExpired)
+	}
+}
+
+func Test_KeyUsage_narrow(t *testing.T) {
+	early, late := time.Unix(100, 0), time.Unix(200, 0)
+	a := &KeyUsage{NotAfter: late, AADPrefixes: [][]byte{[]byte("a")}}
+	b := &KeyUsage{DecryptOnly: true, NotAfter: early, AADPrefixes: [][]byte{[]byte("b")}}
+	got := a.narrow(b)
+	if !got.DecryptOnly || !got.NotAfter.Equal(early) || len(got.AADPrefixes) != 1 || string(got.AADPrefixes[0]) != "a" {
+		t.Fatalf("narrow() = %+v", got)
+	}
+	if (*KeyUsage)(nil).narrow(b) != b || a.narrow(nil) != a {
+		t.Fatal("narrow() with nil constraints")
+	}
+}
+
+func Test_parseKeyUsage(t *testing.T) {
+	usage := &KeyUsage{DecryptOnly: true, NotAfter: time.Unix(0, 12345).UTC(), AADPrefixes: [][]byte{[]byte("x"), {}}}
+	encoded, err := usage.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parseKeyUsage(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.DecryptOnly || !parsed.NotAfter.Equal(usage.NotAfter) || len(parsed.AADPrefixes) != 2 {
+		t.Fatalf("parseKeyUsage() = %+v", parsed)
+	}
+	for _, data := range [][]byte{nil, encoded[:9], append(encoded, 0), {2, 0, 0, 0, 0, 0, 0, 0, 0, 0}} {
+		if _, err := parseKeyUsage(data); err == nil {
+			t.Fatalf("parseKeyUsage(%x) expected error", data)
+		}
+	}
+}
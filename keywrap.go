@@ -0,0 +1,291 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+)
+
+var (
+	// ErrKeyWrapIntegrity is returned when unwrapping a key fails its integrity check,
+	// indicating the wrong key-encryption key was used, or the wrapped key was modified
+	ErrKeyWrapIntegrity = errors.New("wrapped key failed integrity check")
+
+	// keyWrapIV is the default initial value defined by RFC 3394
+	keyWrapIV = []byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}
+	// keyWrapPadIV is the alternative initial value prefix defined by RFC 5649
+	keyWrapPadIV = []byte{0xA6, 0x59, 0x59, 0xA6}
+)
+
+// WrapKey wraps key using the AES Key Wrap algorithm (RFC 3394) under the
+// key-encryption key kek. The key must be a multiple of 8 bytes, and at least 16 bytes
+func WrapKey(kek, key []byte) ([]byte, error) {
+	if len(key) < 16 || len(key)%8 != 0 {
+		return nil, errors.New("key to wrap must be a multiple of 8 bytes, and at least 16 bytes")
+	}
+	return wrap(kek, keyWrapIV, key)
+}
+
+// UnwrapKey unwraps a key wrapped using WrapKey
+func UnwrapKey(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, errors.New("invalid wrapped key length")
+	}
+	iv, key, err := unwrap(kek, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(iv, keyWrapIV) != 1 {
+		return nil, ErrKeyWrapIntegrity
+	}
+	return key, nil
+}
+
+// WrapKeyWithPadding wraps key of any length using the AES Key Wrap with
+// Padding algorithm (RFC 5649) under the key-encryption key kek
+func WrapKeyWithPadding(kek, key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errors.New("key to wrap must not be empty")
+	}
+	iv := make([]byte, 8)
+	copy(iv, keyWrapPadIV)
+	binary.BigEndian.PutUint32(iv[4:], uint32(len(key)))
+	padded := make([]byte, (len(key)+7)/8*8)
+	copy(padded, key)
+	// a single block is encrypted directly rather than wrapped
+	if len(padded) == 8 {
+		block, err := aes.NewCipher(kek)
+		if err != nil {
+			return nil, err
+		}
+		out := append(iv, padded...)
+		block.Encrypt(out, out)
+		return out, nil
+	}
+	return wrap(kek, iv, padded)
+}
+
+// UnwrapKeyWithPadding unwraps a key wrapped using WrapKeyWithPadding
+func UnwrapKeyWithPadding(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 16 || len(wrapped)%8 != 0 {
+		return nil, errors.New("invalid wrapped key length")
+	}
+	var iv, padded []byte
+	if len(wrapped) == 16 {
+		block, err := aes.NewCipher(kek)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, 16)
+		block.Decrypt(out, wrapped)
+		iv, padded = out[:8], out[8:]
+	} else {
+		var err error
+		if iv, padded, err = unwrap(kek, wrapped); err != nil {
+			return nil, err
+		}
+	}
+	if subtle.ConstantTimeCompare(iv[:4], keyWrapPadIV) != 1 {
+		return nil, ErrKeyWrapIntegrity
+	}
+	// validate the message length indicator, and padding
+	length := int(binary.BigEndian.Uint32(iv[4:]))
+	if length > len(padded) || length <= len(padded)-8 {
+		return nil, ErrKeyWrapIntegrity
+	}
+	var nonZero byte
+	for _, b := range padded[length:] {
+		nonZero |= b
+	}
+	if nonZero != 0 {
+		return nil, ErrKeyWrapIntegrity
+	}
+	return padded[:length], nil
+}
+
+// wrap implements the wrapping process of RFC 3394 section 2.2.1 with the given initial value
+func wrap(kek, iv, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(plaintext) / 8
+	out := make([]byte, 8+len(plaintext))
+	copy(out[8:], plaintext)
+	a := make([]byte, 8)
+	copy(a, iv)
+	buf := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(buf, a)
+			copy(buf[8:], out[i*8:(i+1)*8])
+			block.Encrypt(buf, buf)
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(a, binary.BigEndian.Uint64(buf[:8])^t)
+			copy(out[i*8:], buf[8:])
+		}
+	}
+	copy(out, a)
+	return out, nil
+}
+
+// unwrap implements the unwrapping process of RFC 3394 section 2.2.2,
+// returning the recovered initial value, and plaintext
+func unwrap(kek, ciphertext []byte) ([]byte, []byte, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, nil, err
+	}
+	n := len(ciphertext)/8 - 1
+	out := make([]byte, len(ciphertext)-8)
+	copy(out, ciphertext[8:])
+	a := make([]byte, 8)
+	copy(a, ciphertext[:8])
+	buf := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(buf, binary.BigEndian.Uint64(a)^t)
+			copy(buf[8:], out[(i-1)*8:i*8])
+			block.Decrypt(buf, buf)
+			copy(a, buf[:8])
+			copy(out[(i-1)*8:], buf[8:])
+		}
+	}
+	return a, out, nil
+}
+
+// RetrieveWrappedGCMDecryptionParameters is used to retrieve the GCM cipher key
+// and nonce wrapped using AES Key Wrap, under a key-encryption key derived from
+// the passphrase. Unlike RetrieveGCMDecryptionParameters the output is compact,
+// and integrity protected. The format is salt || AES-KW(cipherKey || nonce),
+// or when usage constraints apply salt || AES-KWP(cipherKey || nonce || usage),
+// where the salt is prefixed by the header of the KDF set using WithKDF, if any
+func (e *EncryptManager) RetrieveWrappedGCMDecryptionParameters() ([]byte, error) {
+	if err := e.checkKeyExport(true); err != nil {
+		return nil, err
+	}
+	key, nonce, err := e.decodeGCMDecryptParams()
+	if err != nil {
+		return nil, err
+	}
+	// the nonce size is implied by the size of the wrapped parameters
+	if len(nonce) != nonceSize {
+		return nil, errors.New("wrapped gcm decryption parameters require the default nonce size")
+	}
+	kek, header, err := e.newKDFKey()
+	if err != nil {
+		return nil, err
+	}
+	var wrapped []byte
+	if usage := e.getGCMDecryptParams().usage.narrow(e.keyUsage); usage != nil {
+		encoded, err := usage.marshal()
+		if err != nil {
+			return nil, err
+		}
+		wrapped, err = WrapKeyWithPadding(kek, append(append(key, nonce...), encoded...))
+		if err != nil {
+			return nil, err
+		}
+	} else if wrapped, err = WrapKey(kek, append(key, nonce...)); err != nil {
+		return nil, err
+	}
+	return append(header, wrapped...), nil
+}
+
+// UnwrapGCMDecryptionParameters is used to recover the decryption parameters
+// returned by RetrieveWrappedGCMDecryptionParameters, ready for use with WithGCM.
+// Usage constraints are enforced by the EncryptManager using the parameters,
+// and the recorded KDF is subject to its limits, and format policy
+func (e *EncryptManager) UnwrapGCMDecryptionParameters(wrapped []byte) (*GCMDecryptParams, error) {
+	if len(wrapped) < saltlen+8+keylen+nonceSize {
+		return nil, errors.New("invalid wrapped gcm decryption parameters")
+	}
+	kek, n, err := e.readKDFKey(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if wrapped = wrapped[n:]; len(wrapped) < 8+keylen+nonceSize {
+		return nil, errors.New("invalid wrapped gcm decryption parameters")
+	}
+	// parameters without usage constraints have a fixed size
+	if len(wrapped) == 8+keylen+nonceSize {
+		unwrapped, err := UnwrapKey(kek, wrapped)
+		if err != nil {
+			return nil, err
+		}
+		return &GCMDecryptParams{
+			CipherKey: hex.EncodeToString(unwrapped[:keylen]),
+			Nonce:     hex.EncodeToString(unwrapped[keylen:]),
+		}, nil
+	}
+	unwrapped, err := UnwrapKeyWithPadding(kek, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if len(unwrapped) < keylen+nonceSize {
+		return nil, errors.New("invalid wrapped gcm decryption parameters")
+	}
+	usage, err := parseKeyUsage(unwrapped[keylen+nonceSize:])
+	if err != nil {
+		return nil, err
+	}
+	if usage.expired(e.now()) {
+		return nil, ErrKeyExpired
+	}
+	return &GCMDecryptParams{
+		CipherKey: hex.EncodeToString(unwrapped[:keylen]),
+		Nonce:     hex.EncodeToString(unwrapped[keylen : keylen+nonceSize]),
+		usage:     usage,
+	}, nil
+}
+
+// WrappedDataKey is the GCM cipher key of an EncryptManager wrapped under a
+// long-term key-encryption key, such as one held by an HSM or KMS
+type WrappedDataKey struct {
+	// Key is the cipher key wrapped using AES Key Wrap (RFC 3394), unwrappable
+	// by any compliant implementation
+	Key []byte
+	// Nonce is the nonce used with the cipher key, which is not secret
+	Nonce []byte
+}
+
+// WrapDataKey is used to wrap the GCM cipher key under the AES key-encryption
+// key kek, of 16, 24, or 32 bytes. Unlike RetrieveWrappedGCMDecryptionParameters
+// no key derivation is involved, and the wrapped key is plain RFC 3394 output.
+// Usage constraints can not be represented, so constrained parameters are refused
+func (e *EncryptManager) WrapDataKey(kek []byte) (*WrappedDataKey, error) {
+	if err := e.checkKeyExport(false); err != nil {
+		return nil, err
+	}
+	if e.keyUsage != nil {
+		return nil, ErrKeyUsage
+	}
+	key, nonce, err := e.decodeGCMDecryptParams()
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := WrapKey(kek, key)
+	if err != nil {
+		return nil, err
+	}
+	return &WrappedDataKey{Key: wrapped, Nonce: nonce}, nil
+}
+
+// UnwrapDataKey is used to recover the decryption parameters of a key wrapped
+// using WrapDataKey, ready for use with WithGCM
+func UnwrapDataKey(kek []byte, wrapped *WrappedDataKey) (*GCMDecryptParams, error) {
+	if wrapped == nil || len(wrapped.Key) != 8+keylen || !validGCMNonceSize(len(wrapped.Nonce)) {
+		return nil, errors.New("invalid wrapped data key")
+	}
+	key, err := UnwrapKey(kek, wrapped.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &GCMDecryptParams{
+		CipherKey: hex.EncodeToString(key),
+		Nonce:     hex.EncodeToString(wrapped.Nonce),
+	}, nil
+}
@@ -0,0 +1,173 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func Test_KeyWrap(t *testing.T) {
+	// test vectors from RFC 3394 section 4, and RFC 5649 section 6
+	tests := []struct {
+		name    string
+		kek     string
+		key     string
+		wrapped string
+		padded  bool
+	}{
+		{"rfc3394 128 bit kek", "000102030405060708090A0B0C0D0E0F",
+			"00112233445566778899AABBCCDDEEFF",
+			"1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5", false},
+		{"rfc3394 256 bit kek", "000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+			"00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F",
+			"28C9F404C4B810F4CBCCB35CFB87F8263F5786E2D80ED326CBC7F0E71A99F43BFB988B9B7A02DD21", false},
+		{"rfc5649 20 byte key", "5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8",
+			"c37b7e6492584340bed12207808941155068f738",
+			"138bdeaa9b8fa7fc61f97742e72248ee5ae6ae5360d1ae6a5f54f373fa543b6a", true},
+		{"rfc5649 7 byte key", "5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8",
+			"466f7250617369",
+			"afbeb0f07dfbf5419200f2ccb50bb24f", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kek := mustDecodeHex(t, tt.kek)
+			key := mustDecodeHex(t, tt.key)
+			want := mustDecodeHex(t, tt.wrapped)
+			wrapFn, unwrapFn := WrapKey, UnwrapKey
+			if tt.padded {
+				wrapFn, unwrapFn = WrapKeyWithPadding, UnwrapKeyWithPadding
+			}
+			wrapped, err := wrapFn(kek, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(wrapped, want) {
+				t.Fatalf("wrap = %x, want %x", wrapped, want)
+			}
+			unwrapped, err := unwrapFn(kek, wrapped)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(unwrapped, key) {
+				t.Fatalf("unwrap = %x, want %x", unwrapped, key)
+			}
+			wrapped[len(wrapped)-1] ^= 1
+			if _, err := unwrapFn(kek, wrapped); err != ErrKeyWrapIntegrity {
+				t.Fatalf("unwrap of modified key err = %v, want %v", err, ErrKeyWrapIntegrity)
+			}
+		})
+	}
+	if _, err := WrapKey(make([]byte, 32), make([]byte, 12)); err == nil {
+		t.Fatal("expected error wrapping key which is not a multiple of 8 bytes")
+	}
+}
+
+func Test_EncryptManager_WrappedGCMDecryptionParameters(t *testing.T) {
+	e := NewEncryptManager("helloworld").WithGCM(nil)
+	encrypted, err := e.Encrypt(bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := e.RetrieveWrappedGCMDecryptionParameters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewEncryptManager("wrong").UnwrapGCMDecryptionParameters(wrapped); err != ErrKeyWrapIntegrity {
+		t.Fatalf("unwrap with wrong passphrase err = %v, want %v", err, ErrKeyWrapIntegrity)
+	}
+	d := NewEncryptManager("helloworld")
+	params, err := d.UnwrapGCMDecryptionParameters(wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := d.WithGCM(params).Decrypt(bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != "hello world" {
+		t.Fatalf("Decrypt = %s", decrypted)
+	}
+
+	// the kdf set using WithKDF is recorded, and subject to the limits, and
+	// format policy of the manager unwrapping the parameters
+	wrapped, err = e.WithPBKDF2Iterations(1000).RetrieveWrappedGCMDecryptionParameters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasKDFHeader(wrapped) {
+		t.Fatal("wrapped parameters do not record the kdf")
+	}
+	if params, err = NewEncryptManager("helloworld").UnwrapGCMDecryptionParameters(wrapped); err != nil {
+		t.Fatal(err)
+	}
+	if decrypted, err = NewEncryptManager("").WithGCM(params).Decrypt(bytes.NewReader(encrypted)); err != nil || string(decrypted) != "hello world" {
+		t.Fatalf("Decrypt = %s, %v", decrypted, err)
+	}
+	policy, err := NewEncryptManager("helloworld").WithFormatPolicy(FormatPolicy{KDF: &KDFPolicy{MinPBKDF2Iterations: 10000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := policy.UnwrapGCMDecryptionParameters(wrapped); err != ErrDowngrade {
+		t.Fatalf("UnwrapGCMDecryptionParameters() err = %v, want %v", err, ErrDowngrade)
+	}
+	if _, err := NewEncryptManager("helloworld").WithKDFLimits(KDFLimits{MaxPBKDF2Iterations: 100}).UnwrapGCMDecryptionParameters(wrapped); err == nil {
+		t.Fatal("expected error unwrapping parameters exceeding kdf limits")
+	}
+}
+
+func Test_EncryptManager_WrapDataKey(t *testing.T) {
+	kek := bytes.Repeat([]byte{0x42}, 32)
+	e := NewEncryptManager("helloworld").WithGCM(nil)
+	if _, err := e.WrapDataKey(kek); err == nil {
+		t.Fatal("expected error wrapping before encryption")
+	}
+	encrypted, err := e.Encrypt(bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.WrapDataKey(kek[:20]); err == nil {
+		t.Fatal("expected error with invalid key-encryption key size")
+	}
+	wrapped, err := e.WrapDataKey(kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the wrapped key is plain RFC 3394 output
+	key, _, err := e.decodeGCMDecryptParams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unwrapped, err := UnwrapKey(kek, wrapped.Key); err != nil || !bytes.Equal(unwrapped, key) {
+		t.Fatalf("UnwrapKey() = %x, %v", unwrapped, err)
+	}
+	if _, err := UnwrapDataKey(bytes.Repeat([]byte{0x43}, 32), wrapped); err != ErrKeyWrapIntegrity {
+		t.Fatalf("unwrap with wrong key-encryption key err = %v, want %v", err, ErrKeyWrapIntegrity)
+	}
+	if _, err := UnwrapDataKey(kek, &WrappedDataKey{Key: wrapped.Key}); err == nil {
+		t.Fatal("expected error without nonce")
+	}
+	params, err := UnwrapDataKey(kek, wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := NewEncryptManager("").WithGCM(params).Decrypt(bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != "hello world" {
+		t.Fatalf("Decrypt = %s", decrypted)
+	}
+	// constraints can not be represented
+	if _, err := e.WithKeyUsage(KeyUsage{DecryptOnly: true}).WrapDataKey(kek); err != ErrKeyUsage {
+		t.Fatalf("WrapDataKey() with usage err = %v, want %v", err, ErrKeyUsage)
+	}
+}
@@ -0,0 +1,69 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"errors"
+)
+
+// KeyWrapper protects data keys using a key management service, such as AWS
+// KMS, so the data key of an envelope can only be recovered by callers
+// authorized to use the key-encryption key held by the service.
+// Implementations for cloud services are provided by the kms package
+type KeyWrapper interface {
+	// WrapKey encrypts key, returning the ID of the key-encryption key used
+	WrapKey(key []byte) (keyID string, wrapped []byte, err error)
+	// UnwrapKey decrypts wrapped, encrypted using the key-encryption key keyID
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// WrappedKey is the data key, and nonce of an envelope protected by a KeyWrapper
+type WrappedKey struct {
+	// KeyID identifies the key-encryption key, as returned by the KeyWrapper
+	KeyID string `json:"key_id"`
+	Data  []byte `json:"data"`
+}
+
+// WithKeyWrapper is used to protect the decryption parameters of envelopes
+// produced by EncryptSplit using kw, instead of the passphrase. The data key is
+// generated locally, so only the key, and never the payload, is sent to the
+// key management service. DecryptSplit unwraps the key using kw
+func (e *EncryptManager) WithKeyWrapper(kw KeyWrapper) *EncryptManager {
+	e.keyWrapper = kw
+	return e
+}
+
+// wrapDataKey wraps the cipher key, and nonce using the key wrapper
+func (e *EncryptManager) wrapDataKey() (*WrappedKey, error) {
+	if err := e.checkKeyExport(false); err != nil {
+		return nil, err
+	}
+	key, nonce, err := e.decodeGCMDecryptParams()
+	if err != nil {
+		return nil, err
+	}
+	keyID, wrapped, err := e.keyWrapper.WrapKey(append(key, nonce...))
+	zero(key)
+	if err != nil {
+		return nil, err
+	}
+	return &WrappedKey{KeyID: keyID, Data: wrapped}, nil
+}
+
+// unwrapDataKey recovers the decryption parameters of wk using the key wrapper
+func (e *EncryptManager) unwrapDataKey(wk *WrappedKey) (*GCMDecryptParams, error) {
+	if e.keyWrapper == nil {
+		return nil, errors.New("no key wrapper provided")
+	}
+	unwrapped, err := e.keyWrapper.UnwrapKey(wk.KeyID, wk.Data)
+	if err != nil {
+		return nil, err
+	}
+	defer zero(unwrapped)
+	if len(unwrapped) <= keylen {
+		return nil, errors.New("invalid wrapped data key")
+	}
+	return &GCMDecryptParams{
+		CipherKey: hex.EncodeToString(unwrapped[:keylen]),
+		Nonce:     hex.EncodeToString(unwrapped[keylen:]),
+	}, nil
+}
@@ -0,0 +1,86 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// memoryKeyWrapper wraps keys using AES Key Wrap with Padding under a single kek
+type memoryKeyWrapper struct {
+	id  string
+	kek []byte
+}
+
+func (m *memoryKeyWrapper) WrapKey(key []byte) (string, []byte, error) {
+	wrapped, err := WrapKeyWithPadding(m.kek, key)
+	return m.id, wrapped, err
+}
+
+func (m *memoryKeyWrapper) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	if keyID != m.id {
+		return nil, errors.New("unknown key")
+	}
+	return UnwrapKeyWithPadding(m.kek, wrapped)
+}
+
+func Test_EncryptManager_KeyWrapper(t *testing.T) {
+	data := []byte("hello world")
+	kw := &memoryKeyWrapper{id: "key-1", kek: bytes.Repeat([]byte{1}, 32)}
+	for _, protocol := range []Protocol{GCM, GCMStream, AEAD, ChaCha20Poly1305, XChaCha20Poly1305} {
+		t.Run(string(protocol), func(t *testing.T) {
+			e := NewEncryptManager("helloworld").WithKeyWrapper(kw)
+			setProtocol(e, protocol)
+			env, payload, err := e.EncryptSplit(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if env.Version != 3 || env.WrappedKey == nil || env.WrappedKey.KeyID != "key-1" || env.Params != nil {
+				t.Fatalf("EncryptSplit() envelope = %+v", env)
+			}
+			// the envelope survives serialization
+			encoded, err := json.Marshal(env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded Envelope
+			if err := json.Unmarshal(encoded, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			// the passphrase is not required
+			decrypted, err := NewEncryptManager("").WithKeyWrapper(kw).DecryptSplit(&decoded, bytes.NewReader(payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+			if _, err := NewEncryptManager("helloworld").DecryptSplit(&decoded, bytes.NewReader(payload)); err == nil {
+				t.Fatal("expected error without key wrapper")
+			}
+			other := &memoryKeyWrapper{id: "key-1", kek: bytes.Repeat([]byte{2}, 32)}
+			if _, err := NewEncryptManager("").WithKeyWrapper(other).DecryptSplit(&decoded, bytes.NewReader(payload)); err != ErrKeyWrapIntegrity {
+				t.Fatalf("DecryptSplit() with wrong key err = %v, want %v", err, ErrKeyWrapIntegrity)
+			}
+			in := inspectEnvelope(env)
+			if in.KeyID != "key-1" {
+				t.Fatalf("Inspect() KeyID = %s", in.KeyID)
+			}
+		})
+	}
+
+	// the wrapped key is covered by the canonical representation
+	env, _, err := NewEncryptManager("").WithGCM(nil).WithKeyWrapper(kw).EncryptSplit(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonical, err := env.Canonicalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.WrappedKey.KeyID = "key-2"
+	if modified, err := env.Canonicalize(); err != nil || bytes.Equal(canonical, modified) {
+		t.Fatal("Canonicalize() does not cover the wrapped key")
+	}
+}
@@ -0,0 +1,98 @@
+package kms
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RTradeLtd/crypto/v2"
+)
+
+// AWS is a crypto.KeyWrapper using AWS KMS. Keys are wrapped using the KMS
+// Encrypt, and Decrypt APIs, so the ciphertexts are interoperable with other
+// AWS tooling
+type AWS struct {
+	// KeyID is the ID, ARN, alias name, or alias ARN of the KMS key used to wrap keys
+	KeyID       string
+	Region      string
+	Credentials crypto.AWSCredentials
+	// Endpoint overrides the regional endpoint, ie for VPC endpoints
+	Endpoint string
+	// EncryptionContext is bound to every wrapped key, and is required to
+	// unwrap it, appearing in CloudTrail logs of every use
+	EncryptionContext map[string]string
+	// Client is used to make requests, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+var _ crypto.KeyWrapper = (*AWS)(nil)
+
+// WrapKey encrypts key using the KMS key, returning the ARN of the KMS key
+func (a *AWS) WrapKey(key []byte) (string, []byte, error) {
+	if a.KeyID == "" {
+		return "", nil, errors.New("no kms key id provided")
+	}
+	var resp struct {
+		KeyID          string `json:"KeyId"`
+		CiphertextBlob []byte `json:"CiphertextBlob"`
+	}
+	if err := a.do("Encrypt", map[string]interface{}{
+		"KeyId":             a.KeyID,
+		"Plaintext":         key,
+		"EncryptionContext": a.EncryptionContext,
+	}, &resp); err != nil {
+		return "", nil, err
+	}
+	if len(resp.CiphertextBlob) == 0 {
+		return "", nil, errors.New("kms returned no ciphertext")
+	}
+	return resp.KeyID, resp.CiphertextBlob, nil
+}
+
+// UnwrapKey decrypts wrapped using the KMS key keyID
+func (a *AWS) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	var resp struct {
+		Plaintext []byte `json:"Plaintext"`
+	}
+	if err := a.do("Decrypt", map[string]interface{}{
+		"KeyId":             keyID,
+		"CiphertextBlob":    wrapped,
+		"EncryptionContext": a.EncryptionContext,
+	}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Plaintext) == 0 {
+		return nil, errors.New("kms returned no plaintext")
+	}
+	return resp.Plaintext, nil
+}
+
+// do performs a signed request against the given KMS action
+func (a *AWS) do(action string, params map[string]interface{}, out interface{}) error {
+	if len(a.EncryptionContext) == 0 {
+		delete(params, "EncryptionContext")
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	endpoint := a.Endpoint
+	if endpoint == "" {
+		endpoint = "https://kms." + a.Region + ".amazonaws.com"
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	a.Credentials.Sign(req, body, a.Region, "kms", time.Now())
+	data, err := do(a.Client, req)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
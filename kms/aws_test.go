@@ -0,0 +1,108 @@
+package kms
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/RTradeLtd/crypto/v2"
+)
+
+// newFakeAWS returns a server implementing the KMS Encrypt, and Decrypt APIs
+// for a single key, binding the encryption context to the ciphertext
+func newFakeAWS(t *testing.T, arn string) *httptest.Server {
+	kw := &memoryWrapper{kek: bytes.Repeat([]byte{2}, 32)}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") ||
+			!strings.Contains(r.Header.Get("Authorization"), "/us-east-1/kms/aws4_request") {
+			http.Error(w, `{"__type":"UnrecognizedClientException"}`, http.StatusBadRequest)
+			return
+		}
+		var req struct {
+			KeyID             string `json:"KeyId"`
+			Plaintext         []byte
+			CiphertextBlob    []byte
+			EncryptionContext map[string]string
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		context, _ := json.Marshal(req.EncryptionContext)
+		if req.KeyID != "alias/test" && req.KeyID != arn {
+			http.Error(w, `{"__type":"NotFoundException"}`, http.StatusBadRequest)
+			return
+		}
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.Encrypt":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"KeyId":          arn,
+				"CiphertextBlob": kw.seal(req.Plaintext, context),
+			})
+		case "TrentService.Decrypt":
+			plaintext, err := kw.open(req.CiphertextBlob, context)
+			if err != nil {
+				http.Error(w, `{"__type":"InvalidCiphertextException"}`, http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"KeyId": arn, "Plaintext": plaintext})
+		default:
+			http.Error(w, `{"__type":"UnknownOperationException"}`, http.StatusBadRequest)
+		}
+	}))
+}
+
+func Test_AWS(t *testing.T) {
+	arn := "arn:aws:kms:us-east-1:111122223333:key/test"
+	srv := newFakeAWS(t, arn)
+	defer srv.Close()
+	kw := &AWS{
+		KeyID:             "alias/test",
+		Region:            "us-east-1",
+		Endpoint:          srv.URL,
+		Credentials:       crypto.AWSCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret"},
+		EncryptionContext: map[string]string{"tenant": "a"},
+	}
+	data := []byte("hello world")
+	env, payload, err := crypto.NewEncryptManager("").WithGCM(nil).WithKeyWrapper(kw).
+		EncryptSplit(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.WrappedKey == nil || env.WrappedKey.KeyID != arn || len(env.Params) != 0 {
+		t.Fatalf("envelope key = %+v, params = %x", env.WrappedKey, env.Params)
+	}
+	// decryption calls kms transparently
+	decrypted, err := crypto.NewEncryptManager("").WithKeyWrapper(kw).DecryptSplit(env, bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Fatal("decrypted data does not match original")
+	}
+
+	tests := []struct {
+		name string
+		kw   *AWS
+	}{
+		{"wrong-context", &AWS{Region: "us-east-1", Endpoint: srv.URL, Credentials: kw.Credentials,
+			EncryptionContext: map[string]string{"tenant": "b"}}},
+		{"wrong-credentials", &AWS{Region: "us-east-1", Endpoint: srv.URL,
+			Credentials: crypto.AWSCredentials{AccessKeyID: "other", SecretAccessKey: "secret"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.kw.UnwrapKey(env.WrappedKey.KeyID, env.WrappedKey.Data); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+	if _, _, err := (&AWS{Region: "us-east-1", Endpoint: srv.URL}).WrapKey(data); err == nil {
+		t.Fatal("expected error without key id")
+	}
+}
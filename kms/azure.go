@@ -0,0 +1,116 @@
+package kms
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/RTradeLtd/crypto/v2"
+)
+
+// azureAPIVersion is the version of the Key Vault REST API used
+const azureAPIVersion = "7.4"
+
+// Azure is a crypto.KeyWrapper using Azure Key Vault. Keys are wrapped using
+// the wrapKey, and unwrapKey operations of an RSA key, or a symmetric key of
+// a Managed HSM
+type Azure struct {
+	// Vault is the URL of the vault, ie https://myvault.vault.azure.net
+	Vault string
+	// Key is the name of the key used to wrap keys
+	Key string
+	// Version is the version of the key used to wrap keys, the latest if empty
+	Version string
+	// Algorithm is the key wrapping algorithm, defaults to RSA-OAEP-256
+	Algorithm string
+	Tokens    TokenSource
+	// Client is used to make requests, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+var _ crypto.KeyWrapper = (*Azure)(nil)
+
+// azureKeyOperation is the request, and response of the wrapKey, and unwrapKey operations
+type azureKeyOperation struct {
+	KeyID     string    `json:"kid,omitempty"`
+	Algorithm string    `json:"alg,omitempty"`
+	Value     base64URL `json:"value"`
+}
+
+// WrapKey wraps key using the Key Vault key, returning the identifier of the
+// key version used
+func (a *Azure) WrapKey(key []byte) (string, []byte, error) {
+	if a.Vault == "" || a.Key == "" {
+		return "", nil, errors.New("no key vault key provided")
+	}
+	kid := a.keyURL()
+	if a.Version != "" {
+		kid += "/" + url.PathEscape(a.Version)
+	}
+	var resp azureKeyOperation
+	if err := a.do(kid+"/wrapkey", key, &resp); err != nil {
+		return "", nil, err
+	}
+	if len(resp.Value) == 0 {
+		return "", nil, errors.New("key vault returned no wrapped key")
+	}
+	return resp.KeyID, resp.Value, nil
+}
+
+// UnwrapKey unwraps wrapped using the key version keyID. keyID, which is read
+// from untrusted envelopes, must be a version of the Key Vault key, so tokens
+// are never sent elsewhere
+func (a *Azure) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	version := strings.TrimPrefix(keyID, a.keyURL()+"/")
+	if a.Vault == "" || a.Key == "" || version == keyID || version == "" || strings.ContainsAny(version, "/?#") {
+		return nil, errors.New("key was not wrapped by the key vault key")
+	}
+	var resp azureKeyOperation
+	if err := a.do(keyID+"/unwrapkey", wrapped, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Value) == 0 {
+		return nil, errors.New("key vault returned no key")
+	}
+	return resp.Value, nil
+}
+
+// keyURL returns the URL of the key, without a version
+func (a *Azure) keyURL() string {
+	return strings.TrimSuffix(a.Vault, "/") + "/keys/" + url.PathEscape(a.Key)
+}
+
+// do performs the key operation at target on value
+func (a *Azure) do(target string, value []byte, out *azureKeyOperation) error {
+	alg := a.Algorithm
+	if alg == "" {
+		alg = "RSA-OAEP-256"
+	}
+	return doJSON(a.Client, a.Tokens, target+"?api-version="+azureAPIVersion,
+		azureKeyOperation{Algorithm: alg, Value: value}, out)
+}
+
+// base64URL is encoded in JSON using unpadded base64url, as used by Key Vault
+type base64URL []byte
+
+// MarshalJSON encodes b as a base64url string
+func (b base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+// UnmarshalJSON decodes a base64url string, with, or without padding
+func (b *base64URL) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
@@ -0,0 +1,110 @@
+package kms
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/RTradeLtd/crypto/v2"
+)
+
+// newFakeAzure returns a server implementing the Key Vault wrapKey, and
+// unwrapKey operations for a single key
+func newFakeAzure(t *testing.T, key string) *httptest.Server {
+	kw := &memoryWrapper{kek: bytes.Repeat([]byte{4}, 32)}
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.URL.Query().Get("api-version") != azureAPIVersion {
+			http.Error(w, `{"error":{"code":"Unauthorized"}}`, http.StatusUnauthorized)
+			return
+		}
+		var req azureKeyOperation
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Algorithm != "RSA-OAEP-256" {
+			http.Error(w, `{"error":{"code":"BadParameter"}}`, http.StatusBadRequest)
+			return
+		}
+		kid := srv.URL + "/keys/" + key + "/v1"
+		switch {
+		case r.URL.Path == "/keys/"+key+"/wrapkey", r.URL.Path == "/keys/"+key+"/v1/wrapkey":
+			json.NewEncoder(w).Encode(azureKeyOperation{KeyID: kid, Value: kw.seal(req.Value, nil)})
+		case r.URL.Path == "/keys/"+key+"/v1/unwrapkey":
+			plaintext, err := kw.open(req.Value, nil)
+			if err != nil {
+				http.Error(w, `{"error":{"code":"BadParameter"}}`, http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(azureKeyOperation{KeyID: kid, Value: plaintext})
+		case strings.HasSuffix(r.URL.Path, "/unwrapkey"):
+			http.Error(w, `{"error":{"code":"KeyNotFound"}}`, http.StatusNotFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return srv
+}
+
+func Test_Azure(t *testing.T) {
+	srv := newFakeAzure(t, "wrapping")
+	defer srv.Close()
+	kw := &Azure{Vault: srv.URL, Key: "wrapping", Tokens: StaticToken("token")}
+	data := []byte("hello world")
+	env, payload, err := crypto.NewEncryptManager("").WithGCM(nil).WithKeyWrapper(kw).
+		EncryptSplit(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.WrappedKey.KeyID != srv.URL+"/keys/wrapping/v1" {
+		t.Fatalf("envelope key id = %s", env.WrappedKey.KeyID)
+	}
+	decrypted, err := crypto.NewEncryptManager("").WithKeyWrapper(kw).DecryptSplit(env, bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Fatal("decrypted data does not match original")
+	}
+	// a pinned version wraps keys the same way
+	if _, _, err := (&Azure{Vault: srv.URL, Key: "wrapping", Version: "v1", Tokens: kw.Tokens}).WrapKey(data); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		keyID string
+	}{
+		{"other-vault", "https://attacker.example/keys/wrapping/v1"},
+		{"other-key", srv.URL + "/keys/other/v1"},
+		{"no-version", srv.URL + "/keys/wrapping"},
+		{"nested-path", srv.URL + "/keys/wrapping/v1/../../other/v1"},
+		{"unknown-version", srv.URL + "/keys/wrapping/v2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := kw.UnwrapKey(tt.keyID, env.WrappedKey.Data); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func Test_base64URL(t *testing.T) {
+	encoded, err := json.Marshal(base64URL{0xfb, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(encoded) != `"-_8"` {
+		t.Fatalf("MarshalJSON() = %s", encoded)
+	}
+	var decoded base64URL
+	for _, data := range []string{`"-_8"`, `"-_8="`} {
+		if err := json.Unmarshal([]byte(data), &decoded); err != nil || !bytes.Equal(decoded, []byte{0xfb, 0xff}) {
+			t.Fatalf("UnmarshalJSON(%s) = %x, %v", data, decoded, err)
+		}
+	}
+	if err := json.Unmarshal([]byte(`"+/"`), &decoded); err == nil {
+		t.Fatal("expected error decoding standard base64")
+	}
+}
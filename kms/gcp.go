@@ -0,0 +1,84 @@
+package kms
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/RTradeLtd/crypto/v2"
+)
+
+// GCP is a crypto.KeyWrapper using Google Cloud KMS. Keys are wrapped using
+// the encrypt, and decrypt methods of a symmetric CryptoKey
+type GCP struct {
+	// Key is the resource name of the CryptoKey, ie
+	// projects/p/locations/global/keyRings/r/cryptoKeys/k
+	Key string
+	// AdditionalData is authenticated along with every wrapped key, and is
+	// required to unwrap it
+	AdditionalData []byte
+	Tokens         TokenSource
+	// Endpoint overrides the Cloud KMS endpoint, defaults to https://cloudkms.googleapis.com
+	Endpoint string
+	// Client is used to make requests, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+var _ crypto.KeyWrapper = (*GCP)(nil)
+
+// WrapKey encrypts key using the CryptoKey, returning the resource name of
+// the CryptoKeyVersion used
+func (g *GCP) WrapKey(key []byte) (string, []byte, error) {
+	if g.Key == "" {
+		return "", nil, errors.New("no cloud kms key provided")
+	}
+	var resp struct {
+		Name       string `json:"name"`
+		Ciphertext []byte `json:"ciphertext"`
+	}
+	if err := g.do(g.Key+":encrypt", map[string][]byte{
+		"plaintext":                   key,
+		"additionalAuthenticatedData": g.AdditionalData,
+	}, &resp); err != nil {
+		return "", nil, err
+	}
+	if len(resp.Ciphertext) == 0 {
+		return "", nil, errors.New("cloud kms returned no ciphertext")
+	}
+	return resp.Name, resp.Ciphertext, nil
+}
+
+// UnwrapKey decrypts wrapped using the CryptoKey. keyID, which is read from
+// untrusted envelopes, must be a version of the CryptoKey, so tokens are never
+// sent elsewhere
+func (g *GCP) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	if g.Key == "" || !strings.HasPrefix(keyID, g.Key+"/cryptoKeyVersions/") {
+		return nil, errors.New("key was not wrapped by the cloud kms key")
+	}
+	var resp struct {
+		Plaintext []byte `json:"plaintext"`
+	}
+	// the version is identified by the ciphertext
+	if err := g.do(g.Key+":decrypt", map[string][]byte{
+		"ciphertext":                  wrapped,
+		"additionalAuthenticatedData": g.AdditionalData,
+	}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Plaintext) == 0 {
+		return nil, errors.New("cloud kms returned no plaintext")
+	}
+	return resp.Plaintext, nil
+}
+
+// do calls the given method of the CryptoKey
+func (g *GCP) do(method string, params map[string][]byte, out interface{}) error {
+	if len(params["additionalAuthenticatedData"]) == 0 {
+		delete(params, "additionalAuthenticatedData")
+	}
+	endpoint := g.Endpoint
+	if endpoint == "" {
+		endpoint = "https://cloudkms.googleapis.com"
+	}
+	return doJSON(g.Client, g.Tokens, strings.TrimSuffix(endpoint, "/")+"/v1/"+method, params, out)
+}
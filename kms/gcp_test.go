@@ -0,0 +1,87 @@
+package kms
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/RTradeLtd/crypto/v2"
+)
+
+// newFakeGCP returns a server implementing the Cloud KMS encrypt, and decrypt
+// methods for a single CryptoKey
+func newFakeGCP(t *testing.T, key string) *httptest.Server {
+	kw := &memoryWrapper{kek: bytes.Repeat([]byte{3}, 32)}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, `{"error":{"code":401}}`, http.StatusUnauthorized)
+			return
+		}
+		var req struct {
+			Plaintext  []byte `json:"plaintext"`
+			Ciphertext []byte `json:"ciphertext"`
+			AAD        []byte `json:"additionalAuthenticatedData"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/" + key + ":encrypt":
+			ciphertext := kw.seal(req.Plaintext, req.AAD)
+			json.NewEncoder(w).Encode(map[string]interface{}{"name": key + "/cryptoKeyVersions/1", "ciphertext": ciphertext})
+		case "/v1/" + key + ":decrypt":
+			plaintext, err := kw.open(req.Ciphertext, req.AAD)
+			if err != nil {
+				http.Error(w, `{"error":{"code":400}}`, http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"plaintext": plaintext})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func Test_GCP(t *testing.T) {
+	key := "projects/p/locations/global/keyRings/r/cryptoKeys/k"
+	srv := newFakeGCP(t, key)
+	defer srv.Close()
+	kw := &GCP{Key: key, AdditionalData: []byte("tenant-a"), Tokens: StaticToken("token"), Endpoint: srv.URL}
+	data := []byte("hello world")
+	env, payload, err := crypto.NewEncryptManager("").WithGCM(nil).WithKeyWrapper(kw).
+		EncryptSplit(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.WrappedKey.KeyID != key+"/cryptoKeyVersions/1" {
+		t.Fatalf("envelope key id = %s", env.WrappedKey.KeyID)
+	}
+	decrypted, err := crypto.NewEncryptManager("").WithKeyWrapper(kw).DecryptSplit(env, bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Fatal("decrypted data does not match original")
+	}
+
+	tests := []struct {
+		name  string
+		kw    *GCP
+		keyID string
+	}{
+		{"wrong-aad", &GCP{Key: key, Tokens: StaticToken("token"), Endpoint: srv.URL}, env.WrappedKey.KeyID},
+		{"wrong-token", &GCP{Key: key, AdditionalData: kw.AdditionalData, Tokens: StaticToken("other"), Endpoint: srv.URL}, env.WrappedKey.KeyID},
+		{"no-token", &GCP{Key: key, AdditionalData: kw.AdditionalData, Endpoint: srv.URL}, env.WrappedKey.KeyID},
+		{"foreign-key", kw, "projects/other/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.kw.UnwrapKey(tt.keyID, env.WrappedKey.Data); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+// Package kms provides crypto.KeyWrapper implementations backed by key
+// management services, such as those of cloud providers, or HashiCorp Vault,
+// allowing envelopes to protect their data keys using keys which never leave
+// the service. Keys are wrapped using the REST APIs of
+// each service, so no vendor SDK is required.
+package kms
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// TokenSource provides the OAuth2 access tokens used to authenticate requests
+// to Google Cloud, and Azure, refreshing them as required
+type TokenSource interface {
+	Token() (string, error)
+}
+
+// StaticToken is a TokenSource always returning the same token
+type StaticToken string
+
+// Token returns t
+func (t StaticToken) Token() (string, error) {
+	return string(t), nil
+}
+
+// doJSON performs an authenticated request posting in as JSON to url,
+// decoding the response into out
+func doJSON(client *http.Client, tokens TokenSource, url string, in, out interface{}) error {
+	if tokens == nil {
+		return errors.New("no token source provided")
+	}
+	token, err := tokens.Token()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	data, err := do(client, req)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// do performs req, returning the body of successful responses
+func do(client *http.Client, req *http.Request) ([]byte, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s %s returned status %d: %s", req.Method, req.URL.Path,
+			resp.StatusCode, bytes.TrimSpace(data))
+	}
+	return data, nil
+}
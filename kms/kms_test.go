@@ -0,0 +1,49 @@
+package kms
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// memoryWrapper seals keys for the fake services using AES-GCM under kek
+type memoryWrapper struct {
+	kek []byte
+}
+
+func (m *memoryWrapper) aead() cipher.AEAD {
+	block, err := aes.NewCipher(m.kek)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return aead
+}
+
+func (m *memoryWrapper) seal(plaintext, aad []byte) []byte {
+	aead := m.aead()
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad)
+}
+
+func (m *memoryWrapper) open(ciphertext, aad []byte) ([]byte, error) {
+	aead := m.aead()
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("invalid ciphertext")
+	}
+	return aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], aad)
+}
+
+func Test_StaticToken(t *testing.T) {
+	if token, err := StaticToken("token").Token(); err != nil || token != "token" {
+		t.Fatalf("Token() = %s, %v", token, err)
+	}
+}
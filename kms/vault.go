@@ -0,0 +1,152 @@
+package kms
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RTradeLtd/crypto/v2"
+)
+
+// Vault is a crypto.KeyWrapper using the transit secrets engine of HashiCorp
+// Vault, so keys can be protected by an on-premises Vault cluster
+type Vault struct {
+	// Address is the address of the Vault server, ie https://vault:8200
+	Address string
+	// Mount is the mount path of the transit engine, defaults to "transit"
+	Mount string
+	// Key is the name of the transit key used to wrap keys
+	Key string
+	// Context is the key derivation context, required by keys with derivation enabled
+	Context []byte
+	// Tokens authenticates requests, ie a StaticToken, or a VaultAppRole
+	Tokens TokenSource
+	// Client is used to make requests, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+var _ crypto.KeyWrapper = (*Vault)(nil)
+
+// vaultTransitRequest is the request of the encrypt, and decrypt operations
+type vaultTransitRequest struct {
+	Plaintext  []byte `json:"plaintext,omitempty"`
+	Ciphertext string `json:"ciphertext,omitempty"`
+	Context    []byte `json:"context,omitempty"`
+}
+
+// WrapKey encrypts key using the transit key, returning the name of the key.
+// The version of the key used is recorded by the wrapped key
+func (v *Vault) WrapKey(key []byte) (string, []byte, error) {
+	if v.Key == "" {
+		return "", nil, errors.New("no transit key provided")
+	}
+	var resp struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	if err := v.do("encrypt", vaultTransitRequest{Plaintext: key, Context: v.Context}, &resp); err != nil {
+		return "", nil, err
+	}
+	if !strings.HasPrefix(resp.Data.Ciphertext, "vault:") {
+		return "", nil, errors.New("vault returned no ciphertext")
+	}
+	return v.Key, []byte(resp.Data.Ciphertext), nil
+}
+
+// UnwrapKey decrypts wrapped using the transit key keyID, which must be the
+// configured key
+func (v *Vault) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	if v.Key == "" || keyID != v.Key {
+		return nil, errors.New("key was not wrapped by the transit key")
+	}
+	var resp struct {
+		Data struct {
+			Plaintext []byte `json:"plaintext"`
+		} `json:"data"`
+	}
+	if err := v.do("decrypt", vaultTransitRequest{Ciphertext: string(wrapped), Context: v.Context}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Data.Plaintext) == 0 {
+		return nil, errors.New("vault returned no plaintext")
+	}
+	return resp.Data.Plaintext, nil
+}
+
+// do calls the given operation of the transit key
+func (v *Vault) do(operation string, req vaultTransitRequest, out interface{}) error {
+	mount := v.Mount
+	if mount == "" {
+		mount = "transit"
+	}
+	return doJSON(v.Client, v.Tokens, strings.TrimSuffix(v.Address, "/")+"/v1/"+mount+"/"+operation+"/"+url.PathEscape(v.Key), req, out)
+}
+
+// VaultAppRole is a TokenSource logging in to Vault using the AppRole auth
+// method, logging in again shortly before the token expires
+type VaultAppRole struct {
+	// Address is the address of the Vault server, ie https://vault:8200
+	Address string
+	// Mount is the mount path of the auth method, defaults to "approle"
+	Mount    string
+	RoleID   string
+	SecretID string
+	// Client is used to make requests, defaults to http.DefaultClient
+	Client *http.Client
+
+	mux     sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// Token returns the current token, logging in when there is none, or it is
+// about to expire
+func (a *VaultAppRole) Token() (string, error) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	now := time.Now()
+	if a.token != "" && (a.expires.IsZero() || now.Before(a.expires)) {
+		return a.token, nil
+	}
+	mount := a.Mount
+	if mount == "" {
+		mount = "approle"
+	}
+	body, err := json.Marshal(map[string]string{"role_id": a.RoleID, "secret_id": a.SecretID})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(a.Address, "/")+"/v1/auth/"+mount+"/login", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	data, err := do(a.Client, req)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int64  `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return "", err
+	}
+	if resp.Auth.ClientToken == "" {
+		return "", errors.New("vault returned no client token")
+	}
+	a.token, a.expires = resp.Auth.ClientToken, time.Time{}
+	if lease := time.Duration(resp.Auth.LeaseDuration) * time.Second; lease > 0 {
+		// renew once 90% of the lease has elapsed
+		a.expires = now.Add(lease - lease/10)
+	}
+	return a.token, nil
+}
@@ -0,0 +1,116 @@
+package kms
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/RTradeLtd/crypto/v2"
+)
+
+// newFakeVault returns a server implementing AppRole login, and the transit
+// encrypt, and decrypt operations for a single key, counting logins
+func newFakeVault(t *testing.T, key string, logins *int32) *httptest.Server {
+	kw := &memoryWrapper{kek: bytes.Repeat([]byte{5}, 32)}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/auth/approle/login" {
+			var req map[string]string
+			json.NewDecoder(r.Body).Decode(&req)
+			if req["role_id"] != "role" || req["secret_id"] != "secret" {
+				http.Error(w, `{"errors":["invalid role or secret ID"]}`, http.StatusBadRequest)
+				return
+			}
+			atomic.AddInt32(logins, 1)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"auth": map[string]interface{}{"client_token": "s.approle", "lease_duration": 3600},
+			})
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token != "s.root" && token != "s.approle" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		var req vaultTransitRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/" + key:
+			ciphertext := "vault:v1:" + base64.StdEncoding.EncodeToString(kw.seal(req.Plaintext, req.Context))
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"ciphertext": ciphertext}})
+		case "/v1/transit/decrypt/" + key:
+			sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(req.Ciphertext, "vault:v1:"))
+			if err != nil {
+				http.Error(w, `{"errors":["invalid ciphertext"]}`, http.StatusBadRequest)
+				return
+			}
+			plaintext, err := kw.open(sealed, req.Context)
+			if err != nil {
+				http.Error(w, `{"errors":["cipher: message authentication failed"]}`, http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string][]byte{"plaintext": plaintext}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func Test_Vault(t *testing.T) {
+	var logins int32
+	srv := newFakeVault(t, "archive", &logins)
+	defer srv.Close()
+	approle := &VaultAppRole{Address: srv.URL, RoleID: "role", SecretID: "secret"}
+	tests := []struct {
+		name   string
+		tokens TokenSource
+	}{
+		{"token", StaticToken("s.root")},
+		{"approle", approle},
+	}
+	data := []byte("hello world")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kw := &Vault{Address: srv.URL, Key: "archive", Context: []byte("tenant-a"), Tokens: tt.tokens}
+			env, payload, err := crypto.NewEncryptManager("").WithGCM(nil).WithKeyWrapper(kw).
+				EncryptSplit(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if env.WrappedKey.KeyID != "archive" || !bytes.HasPrefix(env.WrappedKey.Data, []byte("vault:v1:")) {
+				t.Fatalf("envelope key = %s, %s", env.WrappedKey.KeyID, env.WrappedKey.Data)
+			}
+			decrypted, err := crypto.NewEncryptManager("").WithKeyWrapper(kw).DecryptSplit(env, bytes.NewReader(payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+			other := &Vault{Address: srv.URL, Key: "archive", Tokens: tt.tokens}
+			if _, err := other.UnwrapKey(env.WrappedKey.KeyID, env.WrappedKey.Data); err == nil {
+				t.Fatal("expected error without context")
+			}
+			if _, err := kw.UnwrapKey("other", env.WrappedKey.Data); err == nil {
+				t.Fatal("expected error with other key")
+			}
+		})
+	}
+	// the approle token is reused until it nears expiry
+	if logins != 1 {
+		t.Fatalf("logged in %d times, want 1", logins)
+	}
+	if _, err := (&VaultAppRole{Address: srv.URL, RoleID: "role", SecretID: "wrong"}).Token(); err == nil {
+		t.Fatal("expected error with wrong secret id")
+	}
+	if _, _, err := (&Vault{Address: srv.URL, Key: "archive", Tokens: StaticToken("s.wrong")}).WrapKey(data); err == nil {
+		t.Fatal("expected error with wrong token")
+	}
+}
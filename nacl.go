@@ -0,0 +1,158 @@
+package crypto
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// naclNonceSize is the nonce size of NaCl secretbox, and box
+const naclNonceSize = 24
+
+// WithSecretBox is used to setup, and return EncryptManager for use with
+// SECRETBOX, being NaCl secretbox using XSalsa20-Poly1305. The params are
+// expected to be unencrypted, and in hex encoded string format
+func (e *EncryptManager) WithSecretBox(params *GCMDecryptParams) *EncryptManager {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.protocol = SecretBox
+	e.gcmDecryptParams = params
+	return e
+}
+
+// WithBox is used to setup, and return EncryptManager for use with BOX,
+// being NaCl box using Curve25519, XSalsa20, and Poly1305, encrypting content
+// to the public key of a recipient, as generated by box.GenerateKey.
+// Encryption requires the public key, and decryption the private key, so
+// either may be nil. Content is encrypted using an ephemeral key, and is in
+// the format of ephemeral public key || nonce || ciphertext
+func (e *EncryptManager) WithBox(public, private *[32]byte) *EncryptManager {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.protocol = Box
+	e.boxPublic = public
+	e.boxPrivate = private
+	return e
+}
+
+// encryptSecretBox encrypts given io.Reader using NaCl secretbox
+// the resultant encrypted bytes, nonce, and cipher key are returned
+func (e *EncryptManager) encryptSecretBox(r io.Reader) ([]byte, []byte, []byte, error) {
+	if r == nil {
+		return nil, nil, nil, errors.New("invalid content provided")
+	}
+	if len(e.aad) > 0 {
+		return nil, nil, nil, errors.New("additional data is not supported by secretbox")
+	}
+	var key [keylen]byte
+	if _, err := io.ReadFull(e.randomness(), key[:]); err != nil {
+		return nil, nil, nil, err
+	}
+	var nonce [naclNonceSize]byte
+	if _, err := io.ReadFull(e.randomness(), nonce[:]); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := e.recordNonce(key[:], nonce[:]); err != nil {
+		return nil, nil, nil, err
+	}
+	dataToEncrypt, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return secretbox.Seal(nil, dataToEncrypt, &nonce, &key), nonce[:], key[:], nil
+}
+
+// decryptSecretBox is used to decrypt the given io.Reader encrypted using NaCl secretbox
+func (e *EncryptManager) decryptSecretBox(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	if len(e.aad) > 0 {
+		return nil, errors.New("additional data is not supported by secretbox")
+	}
+	decodedKey, decodedNonce, err := e.decryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	if len(decodedKey) != keylen || len(decodedNonce) != naclNonceSize {
+		return nil, errors.New("invalid key, or nonce size")
+	}
+	var key [keylen]byte
+	var nonce [naclNonceSize]byte
+	copy(key[:], decodedKey)
+	copy(nonce[:], decodedNonce)
+	encryptedData, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	decrypted, ok := secretbox.Open(nil, encryptedData, &nonce, &key)
+	if !ok {
+		return nil, errors.New("message authentication failed")
+	}
+	return decrypted, nil
+}
+
+// encryptBox encrypts given io.Reader to the configured public key
+func (e *EncryptManager) encryptBox(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	if len(e.aad) > 0 {
+		return nil, errors.New("additional data is not supported by box")
+	}
+	public := e.boxPublic
+	if public == nil {
+		if e.boxPrivate == nil {
+			return nil, errors.New("no box public key provided")
+		}
+		public = new([32]byte)
+		curve25519.ScalarBaseMult(public, e.boxPrivate)
+	}
+	ephemeralPublic, ephemeralPrivate, err := box.GenerateKey(e.randomness())
+	if err != nil {
+		return nil, err
+	}
+	var nonce [naclNonceSize]byte
+	if _, err := io.ReadFull(e.randomness(), nonce[:]); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	out := append(ephemeralPublic[:], nonce[:]...)
+	return box.Seal(out, data, &nonce, public, ephemeralPrivate), nil
+}
+
+// decryptBox decrypts given io.Reader using the configured private key
+func (e *EncryptManager) decryptBox(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	if len(e.aad) > 0 {
+		return nil, errors.New("additional data is not supported by box")
+	}
+	if e.boxPrivate == nil {
+		return nil, errors.New("no box private key provided")
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 32+naclNonceSize+box.Overhead {
+		return nil, errors.New("invalid content provided")
+	}
+	var ephemeralPublic [32]byte
+	var nonce [naclNonceSize]byte
+	copy(ephemeralPublic[:], data)
+	copy(nonce[:], data[32:])
+	decrypted, ok := box.Open(nil, data[32+naclNonceSize:], &nonce, &ephemeralPublic, e.boxPrivate)
+	if !ok {
+		return nil, errors.New("message authentication failed")
+	}
+	return decrypted, nil
+}
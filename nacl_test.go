@@ -0,0 +1,93 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+func Test_EncryptManager_SecretBox(t *testing.T) {
+	for _, header := range []bool{false, true} {
+		e := NewEncryptManager("helloworld").WithSecretBox(nil)
+		if header {
+			e.WithHeader()
+		}
+		encrypted, err := e.Encrypt(bytes.NewReader([]byte("hello world")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		params := e.getGCMDecryptParams()
+		d := NewEncryptManager("helloworld").WithSecretBox(params)
+		decrypted, err := d.Decrypt(bytes.NewReader(encrypted))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(decrypted) != "hello world" {
+			t.Fatalf("Decrypt = %s", decrypted)
+		}
+		encrypted[len(encrypted)-1] ^= 1
+		if _, err := d.Decrypt(bytes.NewReader(encrypted)); err == nil {
+			t.Fatal("expected error decrypting modified data")
+		}
+	}
+	e := NewEncryptManager("helloworld").WithSecretBox(nil)
+	env, payload, err := e.EncryptSplit(bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := NewEncryptManager("helloworld").DecryptSplit(env, bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != "hello world" {
+		t.Fatalf("DecryptSplit = %s", decrypted)
+	}
+	if _, err := e.WithAdditionalData([]byte("object")).Encrypt(bytes.NewReader([]byte("hello world"))); err == nil {
+		t.Fatal("expected error using additional data")
+	}
+}
+
+func Test_EncryptManager_Box(t *testing.T) {
+	public, private, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := NewEncryptManager("").WithBox(public, nil).Encrypt(bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		private *[32]byte
+		wantErr bool
+	}{
+		{"recipient", private, false},
+		{"other", other, true},
+		{"no-key", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decrypted, err := NewEncryptManager("").WithBox(nil, tt.private).Decrypt(bytes.NewReader(encrypted))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decrypt err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(decrypted) != "hello world" {
+				t.Fatalf("Decrypt = %s", decrypted)
+			}
+		})
+	}
+	// the public key is derived from the private key
+	e := NewEncryptManager("").WithBox(nil, private)
+	if err := e.Health(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewEncryptManager("").WithBox(nil, private).Decrypt(bytes.NewReader(encrypted[:40])); err == nil {
+		t.Fatal("expected error decrypting truncated data")
+	}
+}
@@ -0,0 +1,241 @@
+package crypto
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// ErrNonceReuse is returned when encryption would reuse a nonce under the same key
+var ErrNonceReuse = errors.New("nonce reuse detected")
+
+const (
+	// fingerprintSize is the size of the key fingerprints recorded in nonce logs
+	fingerprintSize = 16
+	// DefaultNonceLogEntries is the number of entries held by a nonce log
+	// before it is rotated, unless MaxEntries is set
+	DefaultNonceLogEntries = 1 << 20
+	// nonceLogPreviousExt is the extension of the previous generation of a FileNonceLog
+	nonceLogPreviousExt = ".1"
+)
+
+// NonceLog is used to audit the nonces, and data keys used under long-lived
+// keys, refusing to record a nonce which has been used before under the same key
+type NonceLog interface {
+	// Record records the use of nonce under the long-lived key identified by
+	// fingerprint, returning ErrNonceReuse if it has been used before
+	Record(fingerprint, nonce []byte) error
+}
+
+// WithNonceLog is used to record every data key, and nonce used for
+// encryption in the given log, under the passphrase, or provider key of the
+// manager, including the keys of streams, bundles, and SealOnce. Encryption
+// fails with ErrNonceReuse rather than reusing a data key, and nonce
+func (e *EncryptManager) WithNonceLog(log NonceLog) *EncryptManager {
+	e.nonceLog = log
+	return e
+}
+
+// recordNonce records the use of nonce under the data key in the nonce log,
+// if configured. Data keys are random, or derived from a random salt, so the
+// log is keyed on the master key of the manager, recording a digest of the
+// data key, and nonce, which repeats only if the randomness source is broken
+func (e *EncryptManager) recordNonce(key, nonce []byte) error {
+	if e.nonceLog == nil {
+		return nil
+	}
+	return e.nonceLog.Record(nonceLogFingerprint(e.passphrase), nonceLogEntry(key, nonce))
+}
+
+// nonceLogFingerprint returns the fingerprint of key recorded in nonce logs,
+// which is truncated so the log is compact, and does not reveal the key
+func nonceLogFingerprint(key []byte) []byte {
+	sum := sha256.Sum256(append([]byte("temporal-nonce-log:"), key...))
+	return sum[:fingerprintSize]
+}
+
+// nonceLogEntry returns the digest of a data key, and nonce recorded in nonce
+// logs, so entries are of a fixed size, and do not reveal the data key
+func nonceLogEntry(key, nonce []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte("temporal-nonce-log-entry"))
+	binary.Write(h, binary.BigEndian, uint64(len(key)))
+	h.Write(key)
+	h.Write(nonce)
+	return h.Sum(nil)[:fingerprintSize]
+}
+
+// MemoryNonceLog is a NonceLog held in memory. Once MaxEntries have been
+// recorded the log is rotated, keeping the previous generation, so memory is
+// bounded while reuse is detected across at least the last MaxEntries entries
+type MemoryNonceLog struct {
+	// MaxEntries is the number of entries recorded before the log is
+	// rotated, defaulting to DefaultNonceLogEntries
+	MaxEntries int
+
+	mux      sync.Mutex
+	seen     map[string]bool
+	previous map[string]bool
+}
+
+// NewMemoryNonceLog is used to instantiate an empty MemoryNonceLog
+func NewMemoryNonceLog() *MemoryNonceLog {
+	return &MemoryNonceLog{seen: make(map[string]bool)}
+}
+
+// Record records the use of nonce under the key identified by fingerprint
+func (m *MemoryNonceLog) Record(fingerprint, nonce []byte) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.full() {
+		m.rotate()
+	}
+	return m.record(fingerprint, nonce)
+}
+
+func (m *MemoryNonceLog) record(fingerprint, nonce []byte) error {
+	entry := string(fingerprint) + string(nonce)
+	if m.seen[entry] || m.previous[entry] {
+		return ErrNonceReuse
+	}
+	if m.seen == nil {
+		m.seen = make(map[string]bool)
+	}
+	m.seen[entry] = true
+	return nil
+}
+
+// full indicates whether the current generation holds MaxEntries
+func (m *MemoryNonceLog) full() bool {
+	max := m.MaxEntries
+	if max <= 0 {
+		max = DefaultNonceLogEntries
+	}
+	return len(m.seen) >= max
+}
+
+// rotate replaces the previous generation with the current one
+func (m *MemoryNonceLog) rotate() {
+	m.previous, m.seen = m.seen, make(map[string]bool)
+}
+
+// FileNonceLog is a NonceLog persisted to an append-only file of fixed format
+// records: a 16 byte key fingerprint, a single byte nonce length, and the nonce.
+// Once MaxEntries have been recorded the file is renamed with the ".1"
+// extension, replacing the previous generation, and a new file is started
+type FileNonceLog struct {
+	MemoryNonceLog
+	path string
+	file *os.File
+}
+
+// OpenFileNonceLog is used to open, or create the nonce log at path,
+// loading the nonces recorded in it, and its previous generation
+func OpenFileNonceLog(path string) (*FileNonceLog, error) {
+	log := &FileNonceLog{path: path}
+	log.seen = make(map[string]bool)
+	log.previous = make(map[string]bool)
+	previous, err := os.Open(path + nonceLogPreviousExt)
+	if err == nil {
+		err = readNonceLog(previous, func(fingerprint, nonce []byte) error {
+			log.previous[string(fingerprint)+string(nonce)] = true
+			return nil
+		})
+		previous.Close()
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if log.file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600); err != nil {
+		return nil, err
+	}
+	if err := readNonceLog(log.file, log.record); err != nil {
+		log.file.Close()
+		return nil, err
+	}
+	return log, nil
+}
+
+// Record records the use of nonce under the key identified by fingerprint,
+// appending it to the log file
+func (f *FileNonceLog) Record(fingerprint, nonce []byte) error {
+	if len(fingerprint) != fingerprintSize || len(nonce) > 255 {
+		return errors.New("invalid nonce log entry")
+	}
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.full() {
+		if err := f.rotateFile(); err != nil {
+			return err
+		}
+	}
+	if err := f.record(fingerprint, nonce); err != nil {
+		return err
+	}
+	entry := append(append(fingerprint[:fingerprintSize:fingerprintSize], byte(len(nonce))), nonce...)
+	_, err := f.file.Write(entry)
+	return err
+}
+
+// rotateFile moves the log file to the previous generation, starting a new file
+func (f *FileNonceLog) rotateFile() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.path, f.path+nonceLogPreviousExt); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	f.file = file
+	f.rotate()
+	return nil
+}
+
+// Close closes the log file
+func (f *FileNonceLog) Close() error {
+	return f.file.Close()
+}
+
+// VerifyNonceLog is used to check that no nonce was reused under
+// the same key in the nonce log read from r
+func VerifyNonceLog(r io.Reader) error {
+	seen := make(map[string]bool)
+	return readNonceLog(r, func(fingerprint, nonce []byte) error {
+		entry := string(fingerprint) + string(nonce)
+		if seen[entry] {
+			return ErrNonceReuse
+		}
+		seen[entry] = true
+		return nil
+	})
+}
+
+// readNonceLog reads all entries of a nonce log, passing them to fn
+func readNonceLog(r io.Reader, fn func(fingerprint, nonce []byte) error) error {
+	br := bufio.NewReader(r)
+	for entry := 0; ; entry++ {
+		header := make([]byte, fingerprintSize+1)
+		if _, err := io.ReadFull(br, header); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("nonce log entry %d: %s", entry, err)
+		}
+		nonce := make([]byte, header[fingerprintSize])
+		if _, err := io.ReadFull(br, nonce); err != nil {
+			return fmt.Errorf("nonce log entry %d: %s", entry, err)
+		}
+		if err := fn(header[:fingerprintSize], nonce); err != nil {
+			return fmt.Errorf("nonce log entry %d: %s", entry, err)
+		}
+	}
+}
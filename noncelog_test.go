@@ -0,0 +1,201 @@
+package crypto
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// zeroReader is a broken source of randomness which only returns zeros
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func Test_EncryptManager_NonceLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "noncelog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		name     string
+		protocol Protocol
+	}{
+		{"CFB", CFB},
+		{"GCM", GCM},
+		{"AEAD", AEAD},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log, err := OpenFileNonceLog(filepath.Join(dir, tt.name+".log"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := NewEncryptManager("helloworld").WithNonceLog(log)
+			e.protocol = tt.protocol
+			// with healthy randomness nonces are never reused
+			for i := 0; i < 3; i++ {
+				if _, err := e.Encrypt(bytes.NewReader([]byte("hello world"))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// while broken randomness is detected
+			e.WithRandom(zeroReader{})
+			if _, err := e.Encrypt(bytes.NewReader([]byte("hello world"))); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := e.Encrypt(bytes.NewReader([]byte("hello world"))); err != ErrNonceReuse {
+				t.Fatalf("Encrypt err = %v, want %v", err, ErrNonceReuse)
+			}
+			if err := log.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	// reopening the log must retain recorded nonces
+	path := filepath.Join(dir, "GCM.log")
+	log, err := OpenFileNonceLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	e := NewEncryptManager("helloworld").WithGCM(nil).WithNonceLog(log).WithRandom(zeroReader{})
+	if _, err := e.Encrypt(bytes.NewReader([]byte("hello world"))); err != ErrNonceReuse {
+		t.Fatalf("Encrypt err = %v, want %v", err, ErrNonceReuse)
+	}
+	// the log itself contains no reuse, while a duplicated log does
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyNonceLog(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyNonceLog(bytes.NewReader(append(data, data...))); err == nil {
+		t.Fatal("expected error verifying log with reused nonces")
+	}
+	if err := VerifyNonceLog(bytes.NewReader(data[:len(data)-1])); err == nil {
+		t.Fatal("expected error verifying truncated log")
+	}
+}
+
+func Test_NonceLog_KeyReuse(t *testing.T) {
+	data := []byte("hello world")
+	tests := []struct {
+		name string
+		seal func(e *EncryptManager) error
+	}{
+		{"stream", func(e *EncryptManager) error {
+			_, err := e.DialStream(&bytes.Buffer{}).Write(data)
+			return err
+		}},
+		{"bundle", func(e *EncryptManager) error {
+			_, err := e.SealParamBundle(NewParamBundle())
+			return err
+		}},
+		{"once", func(e *EncryptManager) error {
+			_, _, err := e.SealOnce(bytes.NewReader(data))
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := NewMemoryNonceLog()
+			if err := tt.seal(NewEncryptManager("helloworld").WithNonceLog(log).WithRandom(zeroReader{})); err != nil {
+				t.Fatal(err)
+			}
+			// the log is keyed on the master key, so other passphrases are independent
+			if err := tt.seal(NewEncryptManager("otherpassword").WithNonceLog(log).WithRandom(zeroReader{})); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.seal(NewEncryptManager("helloworld").WithNonceLog(log).WithRandom(zeroReader{})); err != ErrNonceReuse {
+				t.Fatalf("err = %v, want %v", err, ErrNonceReuse)
+			}
+		})
+	}
+	t.Run("chunks", func(t *testing.T) {
+		log := NewMemoryNonceLog()
+		master := bytes.Repeat([]byte{1}, keylen)
+		for _, id := range []string{"object-1", "object-2"} {
+			keys, err := NewChunkKeys(master, []byte(id))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := keys.Record(log); err != nil {
+				t.Fatal(err)
+			}
+		}
+		keys, err := NewChunkKeys(master, []byte("object-1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := keys.Record(log); err != ErrNonceReuse {
+			t.Fatalf("Record err = %v, want %v", err, ErrNonceReuse)
+		}
+	})
+}
+
+func Test_NonceLog_Rotation(t *testing.T) {
+	fingerprint := make([]byte, fingerprintSize)
+	record := func(log NonceLog, nonces ...string) error {
+		for _, nonce := range nonces {
+			if err := log.Record(fingerprint, []byte(nonce)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	memory := &MemoryNonceLog{MaxEntries: 2}
+	if err := record(memory, "a", "b", "c"); err != nil {
+		t.Fatal(err)
+	}
+	// reuse is detected across the previous generation
+	if err := record(memory, "a"); err != ErrNonceReuse {
+		t.Fatalf("Record err = %v, want %v", err, ErrNonceReuse)
+	}
+	// while older generations are dropped
+	if err := record(memory, "d", "e", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if len(memory.seen)+len(memory.previous) > 2*memory.MaxEntries {
+		t.Fatalf("log holds %d entries", len(memory.seen)+len(memory.previous))
+	}
+
+	dir, err := ioutil.TempDir("", "noncelog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "nonces.log")
+	log, err := OpenFileNonceLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.MaxEntries = 2
+	if err := record(log, "a", "b", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + nonceLogPreviousExt); err != nil {
+		t.Fatal(err)
+	}
+	// reopening the log must load both generations
+	if log, err = OpenFileNonceLog(path); err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	for _, nonce := range []string{"a", "c"} {
+		if err := record(log, nonce); err != ErrNonceReuse {
+			t.Fatalf("Record(%s) err = %v, want %v", nonce, err, ErrNonceReuse)
+		}
+	}
+}
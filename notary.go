@@ -0,0 +1,69 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+)
+
+// Notarizer is used to submit the digest of encrypted data to an external
+// transparency log (ie, a Sigstore/Rekor style log). The returned receipt
+// is an opaque proof of inclusion that can later be checked by a NotaryVerifier
+type Notarizer interface {
+	Notarize(digest []byte) ([]byte, error)
+}
+
+// NotaryVerifier is used to verify receipts issued by a Notarizer
+type NotaryVerifier interface {
+	VerifyReceipt(digest, receipt []byte) error
+}
+
+// WithNotarizer is used to submit the digest of all encrypted data to the
+// given Notarizer. The receipt of the last notarization is available via NotaryReceipt
+func (e *EncryptManager) WithNotarizer(n Notarizer) *EncryptManager {
+	e.notarizer = n
+	return e
+}
+
+// NotaryReceipt returns the receipt issued for the last encryption
+func (e *EncryptManager) NotaryReceipt() []byte {
+	e.mux.RLock()
+	defer e.mux.RUnlock()
+	return e.notaryReceipt
+}
+
+// notarize submits the digest of the encrypted data to the configured notarizer,
+// returning the issued receipt
+func (e *EncryptManager) notarize(encrypted []byte) ([]byte, error) {
+	if e.notarizer == nil {
+		return nil, nil
+	}
+	digest := sha256.Sum256(encrypted)
+	return e.notarizer.Notarize(digest[:])
+}
+
+// CiphertextDigest returns the digest of the given encrypted data
+// as it is submitted to a Notarizer
+func CiphertextDigest(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// VerifyNotarization is used to verify that the given encrypted data
+// was notarized, and that it has not been tampered with since
+func VerifyNotarization(r io.Reader, receipt []byte, v NotaryVerifier) error {
+	if v == nil {
+		return errors.New("no notary verifier provided")
+	}
+	digest, err := CiphertextDigest(r)
+	if err != nil {
+		return err
+	}
+	return v.VerifyReceipt(digest, receipt)
+}
@@ -0,0 +1,73 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"testing"
+)
+
+// memoryLog is a simple append-only transparency log used for testing
+type memoryLog struct {
+	entries []string
+}
+
+func (m *memoryLog) Notarize(digest []byte) ([]byte, error) {
+	m.entries = append(m.entries, hex.EncodeToString(digest))
+	return []byte(strconv.Itoa(len(m.entries) - 1)), nil
+}
+
+func (m *memoryLog) VerifyReceipt(digest, receipt []byte) error {
+	index, err := strconv.Atoi(string(receipt))
+	if err != nil {
+		return err
+	}
+	if index < 0 || index >= len(m.entries) {
+		return errors.New("no such log entry")
+	}
+	if m.entries[index] != hex.EncodeToString(digest) {
+		return errors.New("digest mismatch")
+	}
+	return nil
+}
+
+func Test_EncryptManager_Notarize(t *testing.T) {
+	original, err := ioutil.ReadFile("README.md")
+	if err != nil {
+		t.Fatalf("setup failed: %s", err)
+	}
+	tests := []struct {
+		name   string
+		useGCM bool
+	}{
+		{"CFB", false},
+		{"GCM", true},
+	}
+	log := &memoryLog{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEncryptManager("helloworld").WithNotarizer(log)
+			if tt.useGCM {
+				e = e.WithGCM(nil)
+			}
+			encrypted, err := e.Encrypt(bytes.NewReader(original))
+			if err != nil {
+				t.Fatal(err)
+			}
+			receipt := e.NotaryReceipt()
+			if receipt == nil {
+				t.Fatal("no receipt issued")
+			}
+			if err := VerifyNotarization(bytes.NewReader(encrypted), receipt, log); err != nil {
+				t.Fatal(err)
+			}
+			// tampering with the encrypted data should fail verification
+			encrypted[0] ^= 0xff
+			if err := VerifyNotarization(bytes.NewReader(encrypted), receipt, log); err == nil {
+				t.Fatal("expected error verifying tampered data")
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package crypto
+
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ErrObjectMismatch is returned when decrypting data bound to an object using
+// WithObjectBinding fails authentication, as the data was encrypted for a
+// different object, ie substituted from another upload, or was modified
+var ErrObjectMismatch = errors.New("encrypted data is not bound to the object")
+
+// ObjectAAD is used to derive the additional data binding encrypted data to
+// an object from template, such as "temporal:{uploadID}:{userID}", replacing
+// every {name} placeholder with the value of name. Values are escaped using
+// url.QueryEscape, so they can not forge the delimiters of the template. Every
+// placeholder requires a value, and every value must be used
+func ObjectAAD(template string, values map[string]string) ([]byte, error) {
+	var (
+		out  strings.Builder
+		used = make(map[string]bool)
+	)
+	for rest := template; rest != ""; {
+		start := strings.IndexAny(rest, "{}")
+		if start < 0 {
+			out.WriteString(rest)
+			break
+		}
+		if rest[start] == '}' {
+			return nil, errors.New("unexpected } in object aad template")
+		}
+		end := strings.IndexAny(rest[start+1:], "{}")
+		if end < 0 || rest[start+1+end] != '}' {
+			return nil, errors.New("unterminated placeholder in object aad template")
+		}
+		name := rest[start+1 : start+1+end]
+		value, ok := values[name]
+		if !ok {
+			return nil, fmt.Errorf("no value for placeholder {%s}", name)
+		}
+		out.WriteString(rest[:start])
+		out.WriteString(url.QueryEscape(value))
+		used[name] = true
+		rest = rest[start+2+end:]
+	}
+	var unused []string
+	for name := range values {
+		if !used[name] {
+			unused = append(unused, name)
+		}
+	}
+	if len(unused) > 0 {
+		sort.Strings(unused)
+		return nil, fmt.Errorf("object aad template has no placeholder for %s", strings.Join(unused, ", "))
+	}
+	return []byte(out.String()), nil
+}
+
+// WithObjectBinding is used to bind encrypted data to an object, using the
+// additional data derived by ObjectAAD from template, and values, which must
+// be supplied for both encryption, and decryption. Decrypting data encrypted
+// for any other object fails with ErrObjectMismatch
+func (e *EncryptManager) WithObjectBinding(template string, values map[string]string) (*EncryptManager, error) {
+	aad, err := ObjectAAD(template, values)
+	if err != nil {
+		return nil, err
+	}
+	e.aad = aad
+	e.objectBound = true
+	return e, nil
+}
+
+// openAEAD opens sealed using aead, and the additional data aad, reporting
+// authentication failures of data bound to an object as ErrObjectMismatch.
+// Nonces of the wrong length, such as those of malformed decryption
+// parameters, are refused rather than panicking
+func openAEAD(aead cipher.AEAD, dst, nonce, sealed, aad []byte, bound bool) ([]byte, error) {
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce of %d bytes, expected %d", len(nonce), aead.NonceSize())
+	}
+	opened, err := aead.Open(dst, nonce, sealed, aad)
+	if err != nil && bound {
+		return nil, ErrObjectMismatch
+	}
+	return opened, err
+}
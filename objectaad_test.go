@@ -0,0 +1,114 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_ObjectAAD(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		values   map[string]string
+		want     string
+		wantErr  bool
+	}{
+		{"placeholders", "temporal:{uploadID}:{userID}", map[string]string{"uploadID": "u1", "userID": "alice"}, "temporal:u1:alice", false},
+		{"escaped", "temporal:{uploadID}:{userID}", map[string]string{"uploadID": "u1:bob", "userID": "a b"}, "temporal:u1%3Abob:a+b", false},
+		{"repeated", "{id}/{id}", map[string]string{"id": "x"}, "x/x", false},
+		{"literal", "temporal", nil, "temporal", false},
+		{"missing-value", "temporal:{uploadID}", nil, "", true},
+		{"unused-value", "temporal", map[string]string{"userID": "alice"}, "", true},
+		{"unterminated", "temporal:{uploadID", map[string]string{"uploadID": "u1"}, "", true},
+		{"nested", "temporal:{upload{ID}}", map[string]string{"ID": "u1"}, "", true},
+		{"unexpected-close", "temporal:}", nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ObjectAAD(tt.template, tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ObjectAAD() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Fatalf("ObjectAAD() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_EncryptManager_WithObjectBinding(t *testing.T) {
+	data := bytes.Repeat([]byte("hello world"), 10000)
+	template := "temporal:{uploadID}:{userID}"
+	bind := func(e *EncryptManager, uploadID string) *EncryptManager {
+		bound, err := e.WithObjectBinding(template, map[string]string{"uploadID": uploadID, "userID": "alice"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bound
+	}
+	tests := []struct {
+		name    string
+		manager func() *EncryptManager
+		stream  bool
+	}{
+		{"gcm", func() *EncryptManager { return NewEncryptManager("").WithGCM(nil) }, false},
+		{"aead", func() *EncryptManager { return NewEncryptManager("").WithAEAD(nil) }, false},
+		{"xchacha20", func() *EncryptManager { return NewEncryptManager("").WithXChaCha20Poly1305(nil) }, false},
+		{"gcm-stream", func() *EncryptManager { return NewEncryptManager("").WithGCMStream(nil) }, false},
+		{"stream", func() *EncryptManager { return NewEncryptManager("").WithGCM(nil) }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := bind(tt.manager(), "upload-1")
+			var encrypted []byte
+			if tt.stream {
+				var buf bytes.Buffer
+				if err := e.EncryptStream(&buf, bytes.NewReader(data)); err != nil {
+					t.Fatal(err)
+				}
+				encrypted = buf.Bytes()
+			} else {
+				var err error
+				if encrypted, err = e.Encrypt(bytes.NewReader(data)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			decrypt := func(d *EncryptManager) ([]byte, error) {
+				if !tt.stream {
+					return d.Decrypt(bytes.NewReader(encrypted))
+				}
+				var buf bytes.Buffer
+				err := d.DecryptStream(&buf, bytes.NewReader(encrypted))
+				return buf.Bytes(), err
+			}
+			decrypted, err := decrypt(bind(e.Clone(), "upload-1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+			// ciphertext substituted from another object fails cleanly
+			if _, err := decrypt(bind(e.Clone(), "upload-2")); err != ErrObjectMismatch {
+				t.Fatalf("err = %v, want %v", err, ErrObjectMismatch)
+			}
+		})
+	}
+
+	// seekable decryption enforces the binding
+	e := bind(NewEncryptManager("").WithGCMStream(nil), "upload-1")
+	encrypted, err := e.Encrypt(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seeker, err := bind(e.Clone(), "upload-2").DecryptSeeker(bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := seeker.Read(make([]byte, 10)); err != ErrObjectMismatch {
+		t.Fatalf("Read() err = %v, want %v", err, ErrObjectMismatch)
+	}
+	if _, err := NewEncryptManager("").WithObjectBinding(template, nil); err == nil {
+		t.Fatal("expected error binding without values")
+	}
+}
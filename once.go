@@ -0,0 +1,83 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// onceTokenVersion is the version of tokens returned by SealOnce
+const onceTokenVersion byte = 1
+
+// SealOnce is used to encrypt r under a freshly generated key using the AEAD
+// profile, returning the encrypted data, and a URL-safe token holding the key.
+// No state is retained, anyone holding the token can decrypt the data with OpenOnce
+func SealOnce(r io.Reader) ([]byte, string, error) {
+	// New never fails without options
+	e, _ := New()
+	return e.SealOnce(r)
+}
+
+// SealOnce is used to encrypt r as by the SealOnce function, generating the
+// key using the randomness source of the manager, and recording it in the
+// nonce log set using WithNonceLog
+func (e *EncryptManager) SealOnce(r io.Reader) ([]byte, string, error) {
+	if r == nil {
+		return nil, "", errors.New("invalid content provided")
+	}
+	key := make([]byte, keylen)
+	if _, err := io.ReadFull(e.randomness(), key); err != nil {
+		return nil, "", err
+	}
+	if err := e.recordNonce(key, nil); err != nil {
+		return nil, "", err
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+	id := preferredAEAD()
+	aead, err := newAEAD(id, key)
+	if err != nil {
+		return nil, "", err
+	}
+	// as every key is only ever used once, a fixed nonce is safe
+	sealed := aead.Seal([]byte{id}, make([]byte, aead.NonceSize()), data, nil)
+	return sealed, base64.RawURLEncoding.EncodeToString(append([]byte{onceTokenVersion}, key...)), nil
+}
+
+// OpenOnce is used to decrypt data encrypted by SealOnce using its token
+func OpenOnce(r io.Reader, token string) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	key, err := decodeOnceToken(token)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) == 0 {
+		return nil, errors.New("invalid content provided")
+	}
+	aead, err := newAEAD(sealed[0], key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, make([]byte, aead.NonceSize()), sealed[1:], nil)
+}
+
+// decodeOnceToken returns the key held by a token returned from SealOnce
+func decodeOnceToken(token string) ([]byte, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) != 1+keylen || decoded[0] != onceTokenVersion {
+		return nil, errors.New("invalid token")
+	}
+	return decoded[1:], nil
+}
@@ -0,0 +1,46 @@
+package crypto
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func Test_SealOnce(t *testing.T) {
+	original, err := ioutil.ReadFile("README.md")
+	if err != nil {
+		t.Fatalf("setup failed: %s", err)
+	}
+	sealed, token, err := SealOnce(bytes.NewReader(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.ContainsAny(token, "+/=") {
+		t.Fatalf("token %s is not url safe", token)
+	}
+	opened, err := OpenOnce(bytes.NewReader(sealed), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, original) {
+		t.Fatal("opened data does not match original")
+	}
+	// every call must use a new key
+	_, otherToken, err := SealOnce(bytes.NewReader(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if otherToken == token {
+		t.Fatal("key reused across calls")
+	}
+	if _, err := OpenOnce(bytes.NewReader(sealed), otherToken); err == nil {
+		t.Fatal("expected error opening with wrong token")
+	}
+	if _, err := OpenOnce(bytes.NewReader(sealed), "invalid"); err == nil {
+		t.Fatal("expected error opening with invalid token")
+	}
+	if _, _, err := SealOnce(nil); err == nil {
+		t.Fatal("expected error sealing nil reader")
+	}
+}
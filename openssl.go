@@ -0,0 +1,202 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// opensslMagic prefixes data encrypted by openssl enc using a salt
+	opensslMagic = "Salted__"
+	// opensslSaltSize is the salt size used by openssl enc
+	opensslSaltSize = 8
+	// opensslIterations is the default PBKDF2 iteration count of openssl enc
+	opensslIterations = 10000
+)
+
+// OpenSSLConfig configures key derivation for the OPENSSL protocol, and
+// must match the options given to openssl enc. The zero value matches
+// openssl enc -aes-256-cbc -pbkdf2
+type OpenSSLConfig struct {
+	// Digest is the digest given by -md, being one of MD5, SHA1, SHA256, or
+	// SHA512, and defaulting to SHA256 as for OpenSSL 1.1.0 onwards
+	Digest crypto.Hash
+	// Iterations is the PBKDF2 iteration count given by -iter, defaulting to 10000
+	Iterations int
+	// LegacyKDF selects EVP_BytesToKey, which is used when -pbkdf2 isn't
+	// given, and is much weaker than PBKDF2
+	LegacyKDF bool
+	// Base64 encodes encrypted data as openssl enc -a does. Base64 encoded
+	// data is always detected when decrypting
+	Base64 bool
+}
+
+// WithOpenSSL is used to setup, and return EncryptManager for use with
+// OPENSSL, being the format of openssl enc -aes-256-cbc, so data may be
+// exchanged with servers using openssl enc. The key, and iv are derived
+// from the passphrase, and a random salt recorded in the output. A nil config
+// selects the defaults. AES256-CBC is not authenticated, so the protocol must
+// only be used for compatibility with openssl
+func (e *EncryptManager) WithOpenSSL(config *OpenSSLConfig) *EncryptManager {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.protocol = OpenSSL
+	e.opensslConfig = config
+	return e
+}
+
+// getOpenSSLConfig returns the openssl configuration, or the defaults
+func (e *EncryptManager) getOpenSSLConfig() OpenSSLConfig {
+	if e.opensslConfig == nil {
+		return OpenSSLConfig{}
+	}
+	return *e.opensslConfig
+}
+
+// deriveKey derives the AES256 key, and iv from the passphrase, and salt
+func (c OpenSSLConfig) deriveKey(passphrase, salt []byte) ([]byte, []byte, error) {
+	var h func() hash.Hash
+	switch c.Digest {
+	case 0, crypto.SHA256:
+		h = sha256.New
+	case crypto.MD5:
+		h = md5.New
+	case crypto.SHA1:
+		h = sha1.New
+	case crypto.SHA512:
+		h = sha512.New
+	default:
+		return nil, nil, errors.New("unsupported openssl digest")
+	}
+	size := keylen + aes.BlockSize
+	if !c.LegacyKDF {
+		iterations := c.Iterations
+		if iterations == 0 {
+			iterations = opensslIterations
+		}
+		if iterations < 0 {
+			return nil, nil, errors.New("invalid openssl iteration count")
+		}
+		key := pbkdf2.Key(passphrase, salt, iterations, size, h)
+		return key[:keylen], key[keylen:], nil
+	}
+	// EVP_BytesToKey, concatenating D_i = H(D_i-1 || passphrase || salt)
+	var key, digest []byte
+	for len(key) < size {
+		d := h()
+		d.Write(digest)
+		d.Write(passphrase)
+		d.Write(salt)
+		digest = d.Sum(nil)
+		key = append(key, digest...)
+	}
+	return key[:keylen], key[keylen:size], nil
+}
+
+// encryptOpenSSL encrypts given io.Reader in the format of openssl enc
+func (e *EncryptManager) encryptOpenSSL(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	if len(e.aad) > 0 {
+		return nil, errors.New("additional data is not supported by openssl")
+	}
+	config := e.getOpenSSLConfig()
+	salt := make([]byte, opensslSaltSize)
+	if _, err := io.ReadFull(e.randomness(), salt); err != nil {
+		return nil, err
+	}
+	key, iv, err := config.deriveKey(e.passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	// PKCS #7 padding
+	pad := aes.BlockSize - len(data)%aes.BlockSize
+	data = append(data, bytes.Repeat([]byte{byte(pad)}, pad)...)
+	out := make([]byte, len(opensslMagic)+opensslSaltSize+len(data))
+	copy(out, opensslMagic)
+	copy(out[len(opensslMagic):], salt)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out[len(opensslMagic)+opensslSaltSize:], data)
+	if !config.Base64 {
+		return out, nil
+	}
+	// openssl enc -a wraps lines at 64 characters
+	encoded := base64.StdEncoding.EncodeToString(out)
+	var armored bytes.Buffer
+	for len(encoded) > 64 {
+		armored.WriteString(encoded[:64] + "\n")
+		encoded = encoded[64:]
+	}
+	armored.WriteString(encoded + "\n")
+	return armored.Bytes(), nil
+}
+
+// decryptOpenSSL decrypts given io.Reader encrypted by openssl enc
+func (e *EncryptManager) decryptOpenSSL(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	if len(e.aad) > 0 {
+		return nil, errors.New("additional data is not supported by openssl")
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	// "Salted__" is encoded as "U2FsdGVkX1" by openssl enc -a
+	if bytes.HasPrefix(data, []byte("U2FsdGVkX1")) {
+		encoded := bytes.Join(bytes.Fields(data), nil)
+		if data, err = base64.StdEncoding.DecodeString(string(encoded)); err != nil {
+			return nil, err
+		}
+	}
+	if !bytes.HasPrefix(data, []byte(opensslMagic)) {
+		return nil, errors.New("invalid openssl data, only salted data is supported")
+	}
+	data = data[len(opensslMagic):]
+	if len(data) < opensslSaltSize+aes.BlockSize || (len(data)-opensslSaltSize)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid openssl data")
+	}
+	key, iv, err := e.getOpenSSLConfig().deriveKey(e.passphrase, data[:opensslSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data)-opensslSaltSize)
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data[opensslSaltSize:])
+	// remove the PKCS #7 padding, which fails to validate given the wrong passphrase
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > aes.BlockSize {
+		return nil, errors.New("incorrect passphrase, or openssl options")
+	}
+	for _, b := range out[len(out)-pad:] {
+		if int(b) != pad {
+			return nil, errors.New("incorrect passphrase, or openssl options")
+		}
+	}
+	return out[:len(out)-pad], nil
+}
@@ -0,0 +1,87 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_EncryptManager_OpenSSL_Fixtures(t *testing.T) {
+	// produced by openssl enc -aes-256-cbc using the given options
+	tests := []struct {
+		name    string
+		file    string
+		config  *OpenSSLConfig
+		wantErr bool
+	}{
+		{"pbkdf2", "pbkdf2.enc", nil, false},
+		{"pbkdf2-sha512", "pbkdf2-sha512.b64", &OpenSSLConfig{Digest: crypto.SHA512, Iterations: 1000}, false},
+		{"legacy", "legacy.enc", &OpenSSLConfig{LegacyKDF: true}, false},
+		{"legacy-md5", "legacy-md5.b64", &OpenSSLConfig{Digest: crypto.MD5, LegacyKDF: true}, false},
+		{"wrong-iterations", "pbkdf2-sha512.b64", &OpenSSLConfig{Digest: crypto.SHA512}, true},
+		{"wrong-kdf", "legacy.enc", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fh, err := os.Open(filepath.Join("testdata/openssl", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fh.Close()
+			decrypted, err := NewEncryptManager("helloworld").WithOpenSSL(tt.config).Decrypt(fh)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decrypt err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(decrypted) != "hello world" {
+				t.Fatalf("Decrypt = %s", decrypted)
+			}
+		})
+	}
+}
+
+func Test_EncryptManager_OpenSSL(t *testing.T) {
+	configs := []*OpenSSLConfig{
+		nil,
+		{Base64: true},
+		{Digest: crypto.SHA1, LegacyKDF: true},
+	}
+	for _, config := range configs {
+		for _, size := range []int{0, 15, 16, 1000} {
+			data := bytes.Repeat([]byte{'a'}, size)
+			e := NewEncryptManager("helloworld").WithOpenSSL(config)
+			encrypted, err := e.Encrypt(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config != nil && config.Base64 != bytes.HasPrefix(encrypted, []byte("U2FsdGVkX1")) {
+				t.Fatalf("%+v: unexpected encoding", config)
+			}
+			decrypted, err := e.Decrypt(bytes.NewReader(encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatalf("%+v, size %d: decrypted data does not match original", config, size)
+			}
+		}
+	}
+	// the padding of random data is valid one in 256 times, so the wrong
+	// passphrase is checked against a fixture
+	fixture, err := os.Open("testdata/openssl/pbkdf2.enc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fixture.Close()
+	if _, err := NewEncryptManager("wrong").WithOpenSSL(nil).Decrypt(fixture); err == nil {
+		t.Fatal("expected error decrypting with wrong passphrase")
+	}
+	e := NewEncryptManager("helloworld").WithOpenSSL(&OpenSSLConfig{Digest: crypto.SHA3_256})
+	if _, err := e.Encrypt(bytes.NewReader([]byte("hello world"))); err == nil {
+		t.Fatal("expected error using unsupported digest")
+	}
+	if _, err := NewEncryptManager("helloworld").WithOpenSSL(nil).Decrypt(bytes.NewReader([]byte("hello world"))); err == nil {
+		t.Fatal("expected error decrypting unsalted data")
+	}
+}
@@ -0,0 +1,145 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Option configures an EncryptManager created by New
+type Option func(*EncryptManager) error
+
+// protocols are the protocols which may be selected using WithProtocol
+var protocols = []Protocol{CFB, GCM, AEAD, GCMStream, ChaCha20Poly1305, XChaCha20Poly1305, RSA, IPFSKey, ECIES, MultiRecipient, Archive, SecretBox, Box, PGP, OpenSSL}
+
+// New creates a new EncryptManager configured by opts, which are applied in
+// order. Without options the manager uses AES256-CFB with an empty passphrase,
+// so a key source is normally given using WithPassphrase, or WithKeyProvider
+func New(opts ...Option) (*EncryptManager, error) {
+	e := &EncryptManager{protocol: CFB}
+	for _, opt := range opts {
+		if err := opt(e); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// WithPassphrase is used to derive keys from passphrase
+func WithPassphrase(passphrase string) Option {
+	return func(e *EncryptManager) error {
+		e.passphrase = []byte(passphrase)
+		return nil
+	}
+}
+
+// WithKeyProvider is used to derive keys from the key stored under id by
+// provider, such as a KeyRing, which is retrieved once by New
+func WithKeyProvider(provider KeyProvider, id string) Option {
+	return func(e *EncryptManager) error {
+		if provider == nil {
+			return errors.New("no key provider given")
+		}
+		key, err := provider.Key(id)
+		if err != nil {
+			return err
+		}
+		if len(key) < keylen {
+			return fmt.Errorf("key %s is too short", id)
+		}
+		e.passphrase = []byte(hex.EncodeToString(key))
+		return nil
+	}
+}
+
+// WithProtocol is used to select the protocol used for encryption, and
+// decryption of data without a header, including protocols registered using
+// RegisterProtocol. Decryption parameters are given using the method of the
+// protocol, such as EncryptManager.WithGCM
+func WithProtocol(protocol Protocol) Option {
+	return func(e *EncryptManager) error {
+		for _, p := range protocols {
+			if p == protocol {
+				e.protocol = protocol
+				return nil
+			}
+		}
+		if _, ok := registeredProtocol(protocol); ok {
+			e.protocol = protocol
+			return nil
+		}
+		return fmt.Errorf("unsupported protocol %s", protocol)
+	}
+}
+
+// WithKDF is used to select the key derivation function, as set by
+// EncryptManager.WithKDF
+func WithKDF(cfg KDFConfig) Option {
+	return func(e *EncryptManager) error {
+		if err := cfg.validateWithin(e.getKDFLimits()); err != nil {
+			return err
+		}
+		e.WithKDF(cfg)
+		return nil
+	}
+}
+
+// WithKDFLimits is used to set the largest cost of key derivation accepted,
+// as set by EncryptManager.WithKDFLimits, which must precede WithKDF
+func WithKDFLimits(limits KDFLimits) Option {
+	return func(e *EncryptManager) error {
+		e.WithKDFLimits(limits)
+		return nil
+	}
+}
+
+// WithNonceSize is used to set the nonce size of AES256-GCM, being the
+// default of 24 bytes used by Temporal, or the standard 12 bytes expected by
+// most other implementations. The nonce is part of the decryption
+// parameters, so decryption supports both sizes regardless of this option
+func WithNonceSize(n int) Option {
+	return func(e *EncryptManager) error {
+		if !validGCMNonceSize(n) {
+			return fmt.Errorf("nonce size must be %d, or %d bytes", standardNonceSize, nonceSize)
+		}
+		e.gcmNonceSize = n
+		return nil
+	}
+}
+
+// WithChunkSize is used to set the amount of plaintext sealed in each
+// segment by GCM-STREAM, and EncryptStream, between 1KiB, and 64MiB. Smaller
+// segments allow finer grained random access using DecryptSeeker, while
+// larger segments reduce the overhead of large files. Sizes other than the
+// default of 64KiB are recorded in the output, so decryption needs no option
+func WithChunkSize(n int) Option {
+	return func(e *EncryptManager) error {
+		if n < minSegmentSize || n > maxSegmentSize {
+			return fmt.Errorf("chunk size must be between %d, and %d bytes", minSegmentSize, maxSegmentSize)
+		}
+		e.chunkSize = n
+		return nil
+	}
+}
+
+// WithSegmentKeys is used to seal each GCM-STREAM segment using its own key,
+// as set by EncryptManager.WithSegmentKeys
+func WithSegmentKeys() Option {
+	return func(e *EncryptManager) error {
+		e.segmentKeys = true
+		return nil
+	}
+}
+
+// WithRandom is used to override the source of randomness, as set by
+// EncryptManager.WithRandom
+func WithRandom(r io.Reader) Option {
+	return func(e *EncryptManager) error {
+		if r == nil {
+			return errors.New("no source of randomness given")
+		}
+		e.WithRandom(r)
+		return nil
+	}
+}
@@ -0,0 +1,199 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func Test_New(t *testing.T) {
+	master := make([]byte, 32)
+	if _, err := rand.Read(master); err != nil {
+		t.Fatal(err)
+	}
+	provider, err := NewDerivedKeyProvider(master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr bool
+	}{
+		{"default", nil, false},
+		{"passphrase", []Option{WithPassphrase("helloworld")}, false},
+		{"key-provider", []Option{WithKeyProvider(provider, "objects")}, false},
+		{"no-key-provider", []Option{WithKeyProvider(nil, "objects")}, true},
+		{"protocol", []Option{WithProtocol(XChaCha20Poly1305)}, false},
+		{"bad-protocol", []Option{WithProtocol("ROT13")}, true},
+		{"kdf", []Option{WithKDF(DefaultKDFConfig(Scrypt))}, false},
+		{"bad-kdf", []Option{WithKDF(KDFConfig{KDF: PBKDF2})}, true},
+		{"nonce-size", []Option{WithNonceSize(12)}, false},
+		{"bad-nonce-size", []Option{WithNonceSize(16)}, true},
+		{"chunk-size", []Option{WithChunkSize(4096)}, false},
+		{"small-chunk-size", []Option{WithChunkSize(16)}, true},
+		{"segment-keys", []Option{WithProtocol(GCMStream), WithSegmentKeys()}, false},
+		{"large-chunk-size", []Option{WithChunkSize(maxSegmentSize + 1)}, true},
+		{"random", []Option{WithRandom(rand.Reader)}, false},
+		{"no-random", []Option{WithRandom(nil)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := New(tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			encrypted, err := e.Encrypt(bytes.NewReader([]byte("hello world")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err := e.Decrypt(bytes.NewReader(encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(decrypted) != "hello world" {
+				t.Fatalf("Decrypt = %s", decrypted)
+			}
+		})
+	}
+}
+
+func Test_New_KeyProvider(t *testing.T) {
+	master := make([]byte, 32)
+	if _, err := rand.Read(master); err != nil {
+		t.Fatal(err)
+	}
+	provider, err := NewDerivedKeyProvider(master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := New(WithKeyProvider(provider, "objects"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := e.Encrypt(bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the key of another id does not decrypt the data
+	other, err := New(WithKeyProvider(provider, "other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Decrypt(bytes.NewReader(encrypted)); err == nil {
+		t.Fatal("expected error decrypting using the key of another id")
+	}
+	same, err := New(WithKeyProvider(provider, "objects"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := same.Decrypt(bytes.NewReader(encrypted)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_New_NonceSize(t *testing.T) {
+	for _, header := range []bool{false, true} {
+		e, err := New(WithPassphrase("helloworld"), WithProtocol(GCM), WithNonceSize(standardNonceSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header {
+			e.WithHeader()
+		}
+		encrypted, err := e.Encrypt(bytes.NewReader([]byte("hello world")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		params := e.getGCMDecryptParams()
+		if len(params.Nonce) != 2*standardNonceSize {
+			t.Fatalf("nonce = %s, want %d bytes", params.Nonce, standardNonceSize)
+		}
+		// decryption does not depend on the option
+		d := NewEncryptManager("helloworld").WithGCM(params)
+		decrypted, err := d.Decrypt(bytes.NewReader(encrypted))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(decrypted) != "hello world" {
+			t.Fatalf("Decrypt = %s", decrypted)
+		}
+		if _, err := e.RetrieveWrappedGCMDecryptionParameters(); err == nil {
+			t.Fatal("expected error wrapping parameters with a non-default nonce size")
+		}
+		kek := bytes.Repeat([]byte{7}, 32)
+		wrapped, err := e.WrapDataKey(kek)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := UnwrapDataKey(kek, wrapped); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test_New_ChunkSize(t *testing.T) {
+	const chunk = 4096
+	data := make([]byte, 3*chunk+100)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	e, err := New(WithPassphrase("helloworld"), WithProtocol(GCMStream), WithChunkSize(chunk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := e.WithHeader().Encrypt(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := Inspect(bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.Segments != 4 {
+		t.Fatalf("Segments = %d, want 4", in.Segments)
+	}
+	d := NewEncryptManager("helloworld").WithGCMStream(e.getGCMDecryptParams())
+	decrypted, err := d.Decrypt(bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Fatal("decrypted data does not match original")
+	}
+
+	var stream bytes.Buffer
+	if err := e.EncryptStream(&stream, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if stream.Bytes()[0]&segmentSizeFlag == 0 {
+		t.Fatal("segment size not recorded")
+	}
+	d = NewEncryptManager("helloworld").WithGCMStream(e.getGCMDecryptParams())
+	var out bytes.Buffer
+	if err := d.DecryptStream(&out, bytes.NewReader(stream.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("stream decrypted data does not match original")
+	}
+	seeker, err := d.DecryptSeeker(bytes.NewReader(stream.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := seeker.Seek(chunk-5, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	part, err := ioutil.ReadAll(io.LimitReader(seeker, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(part, data[chunk-5:chunk+5]) {
+		t.Fatal("range spanning segments does not match")
+	}
+}
@@ -0,0 +1,82 @@
+package crypto
+
+import (
+	"bufio"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// WithParallelism is used to seal, and open the segments of AES256-GCM,
+// GCM-STREAM, the AEAD profile, ChaCha20-Poly1305, and XChaCha20-Poly1305
+// streams using n goroutines. Segments are independent, each using its own
+// nonce, so the output is identical to that of sequential encryption, and is
+// written in order. Up to n segments are buffered at a time. If n is zero,
+// or negative, the number of CPUs is used
+func (e *EncryptManager) WithParallelism(n int) *EncryptManager {
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	e.parallelism = n
+	return e
+}
+
+// segmentJob is a segment read by mapSegments, and the result of processing it
+type segmentJob struct {
+	index uint32
+	buf   []byte
+	last  bool
+	out   []byte
+	err   error
+}
+
+// mapSegments calls fn with every size byte segment of r, as readSegments
+// does, writing the results to dst in order. With more than one worker,
+// batches of segments are processed concurrently. As with sequential
+// processing, the results preceding the first failed segment are written
+func mapSegments(dst io.Writer, r *bufio.Reader, size, workers int, fn func(index uint32, segment []byte, last bool) ([]byte, error)) error {
+	if workers <= 1 {
+		return readSegments(r, size, func(index uint32, segment []byte, last bool) error {
+			out, err := fn(index, segment, last)
+			if err != nil {
+				return err
+			}
+			_, err = dst.Write(out)
+			return err
+		})
+	}
+	jobs := make([]segmentJob, workers)
+	pending := 0
+	flush := func() error {
+		var wg sync.WaitGroup
+		for i := range jobs[:pending] {
+			wg.Add(1)
+			go func(job *segmentJob) {
+				defer wg.Done()
+				job.out, job.err = fn(job.index, job.buf, job.last)
+			}(&jobs[i])
+		}
+		wg.Wait()
+		for i := range jobs[:pending] {
+			job := &jobs[i]
+			if job.err != nil {
+				return job.err
+			}
+			if _, err := dst.Write(job.out); err != nil {
+				return err
+			}
+			// results are usually sealed, or opened in place, so reuse them
+			job.buf = job.out[:0]
+		}
+		pending = 0
+		return nil
+	}
+	return readSegments(r, size, func(index uint32, segment []byte, last bool) error {
+		job := &jobs[pending]
+		job.index, job.buf, job.last = index, append(job.buf[:0], segment...), last
+		if pending++; pending == workers || last {
+			return flush()
+		}
+		return nil
+	})
+}
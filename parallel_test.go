@@ -0,0 +1,104 @@
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func Test_EncryptManager_WithParallelism(t *testing.T) {
+	sizes := []int{0, 100, segmentSize, 5*segmentSize + 7}
+	protocols := []Protocol{GCM, GCMStream, AEAD, ChaCha20Poly1305, XChaCha20Poly1305}
+	for _, protocol := range protocols {
+		for _, size := range sizes {
+			t.Run(fmt.Sprintf("%s/%d", protocol, size), func(t *testing.T) {
+				data := make([]byte, size)
+				rand.Read(data)
+				encrypt := func(parallelism int) []byte {
+					e := NewEncryptManager("helloworld").WithRandom(rand.New(rand.NewSource(1))).WithParallelism(parallelism)
+					setProtocol(e, protocol)
+					var buf bytes.Buffer
+					if err := e.EncryptStream(&buf, bytes.NewReader(data)); err != nil {
+						t.Fatal(err)
+					}
+					return buf.Bytes()
+				}
+				// the output does not depend on the parallelism
+				sequential, parallel := encrypt(1), encrypt(4)
+				if !bytes.Equal(sequential, parallel) {
+					t.Fatal("parallel output does not match sequential output")
+				}
+				e := NewEncryptManager("helloworld").WithRandom(rand.New(rand.NewSource(1)))
+				setProtocol(e, protocol)
+				if err := e.EncryptStream(ioutil.Discard, bytes.NewReader(data)); err != nil {
+					t.Fatal(err)
+				}
+				for _, parallelism := range []int{1, 3, 0} {
+					var decrypted bytes.Buffer
+					if err := e.Clone().WithParallelism(parallelism).DecryptStream(&decrypted, bytes.NewReader(parallel)); err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(decrypted.Bytes(), data) {
+						t.Fatal("decrypted data does not match original")
+					}
+				}
+			})
+		}
+	}
+}
+
+func Test_EncryptManager_WithParallelism_Tampering(t *testing.T) {
+	data := bytes.Repeat([]byte("a"), 5*segmentSize)
+	e := NewEncryptManager("helloworld").WithGCM(nil).WithParallelism(4)
+	var buf bytes.Buffer
+	if err := e.EncryptStream(&buf, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	encrypted := buf.Bytes()
+	// modify the third segment, so only the first two are written
+	encrypted[1+2*(segmentSize+16)] ^= 1
+	var decrypted bytes.Buffer
+	if err := e.DecryptStream(&decrypted, bytes.NewReader(encrypted)); err == nil {
+		t.Fatal("expected error")
+	}
+	if decrypted.Len() != 2*segmentSize {
+		t.Fatalf("wrote %d bytes before the modified segment, want %d", decrypted.Len(), 2*segmentSize)
+	}
+}
+
+func setProtocol(e *EncryptManager, protocol Protocol) {
+	switch protocol {
+	case GCM:
+		e.WithGCM(nil)
+	case GCMStream:
+		e.WithGCMStream(nil)
+	case AEAD:
+		e.WithAEAD(nil)
+	case ChaCha20Poly1305:
+		e.WithChaCha20Poly1305(nil)
+	case XChaCha20Poly1305:
+		e.WithXChaCha20Poly1305(nil)
+	case Archive:
+		e.WithArchive(nil)
+	}
+}
+
+func benchmarkParallelism(b *testing.B, parallelism int) {
+	data := make([]byte, 64*segmentSize)
+	rand.Read(data)
+	e := NewEncryptManager("helloworld").WithGCM(nil).WithParallelism(parallelism)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := e.EncryptStream(ioutil.Discard, bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncryptStream_Parallelism1(b *testing.B) { benchmarkParallelism(b, 1) }
+func BenchmarkEncryptStream_Parallelism2(b *testing.B) { benchmarkParallelism(b, 2) }
+func BenchmarkEncryptStream_Parallelism4(b *testing.B) { benchmarkParallelism(b, 4) }
+func BenchmarkEncryptStream_Parallelism8(b *testing.B) { benchmarkParallelism(b, 8) }
@@ -0,0 +1,195 @@
+package crypto
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ParamFormat is used to configure the serialization of parameters returned
+// by RetrieveGCMDecryptionParameters
+type ParamFormat string
+
+var (
+	// TextParams serializes parameters as tab separated lines, the default,
+	// and the format used by Temporal
+	TextParams ParamFormat = "text"
+	// JSONParams serializes parameters as a versioned JSON object holding the
+	// base64 encoded nonce, and cipher key
+	JSONParams ParamFormat = "json"
+	// ProtobufParams serializes parameters as a versioned protobuf message
+	//
+	//	message DecryptionParameters {
+	//		uint32 version = 1;
+	//		bytes nonce = 2;
+	//		bytes cipher_key = 3;
+	//	}
+	ProtobufParams ParamFormat = "protobuf"
+)
+
+// paramsVersion is the current version of structured parameters
+const paramsVersion = 1
+
+// structuredParams are parameters serialized using JSONParams, or ProtobufParams
+type structuredParams struct {
+	Version   int    `json:"version"`
+	Nonce     []byte `json:"nonce"`
+	CipherKey []byte `json:"cipher_key"`
+}
+
+// WithParamFormat is used to select the serialization of parameters returned by
+// RetrieveGCMDecryptionParameters. Structured formats are recognised by all
+// parameter loaders, and by ParseGCMDecryptionParameters. Parameter encodings
+// set using WithParamEncoding only apply to TextParams
+func (e *EncryptManager) WithParamFormat(format ParamFormat) *EncryptManager {
+	e.paramFormat = format
+	return e
+}
+
+// ParseGCMDecryptionParameters is used to parse decrypted parameters returned
+// by RetrieveGCMDecryptionParameters in any format, returning them ready for
+// use with WithGCM, or the protocol they were exported from
+func ParseGCMDecryptionParameters(data []byte) (*GCMDecryptParams, error) {
+	return parseGCMDecryptParams(data)
+}
+
+// LoadGCMDecryptionParameters is used to decrypt the output of
+// RetrieveGCMDecryptionParameters read from r using passphrase, returning the
+// parameters ready for use with WithGCM, or the protocol they were exported
+// from. Parameters exported by earlier, unauthenticated versions are refused,
+// as they could have been modified undetected, see LoadLegacyGCMDecryptionParameters
+func LoadGCMDecryptionParameters(r io.Reader, passphrase string) (*GCMDecryptParams, error) {
+	return loadGCMDecryptionParameters(r, passphrase, false)
+}
+
+// LoadLegacyGCMDecryptionParameters is used as LoadGCMDecryptionParameters,
+// additionally accepting the unauthenticated parameters exported by earlier
+// versions, as WithLegacyCFB does for AES256-CFB content
+func LoadLegacyGCMDecryptionParameters(r io.Reader, passphrase string) (*GCMDecryptParams, error) {
+	return loadGCMDecryptionParameters(r, passphrase, true)
+}
+
+// loadGCMDecryptionParameters decrypts exported parameters, accepting the
+// unauthenticated format when legacy is set
+func loadGCMDecryptionParameters(r io.Reader, passphrase string, legacy bool) (*GCMDecryptParams, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	decrypted, err := NewEncryptManager(passphrase).decryptCFBWith(r, legacy)
+	if err != nil {
+		return nil, err
+	}
+	return parseGCMDecryptParams(decrypted)
+}
+
+// serializeGCMDecryptParams serializes params in the configured format
+func (e *EncryptManager) serializeGCMDecryptParams(params *GCMDecryptParams) ([]byte, error) {
+	switch e.paramFormat {
+	case "", TextParams:
+		formatted, err := formatGCMDecryptParams(params, e.paramEncoding)
+		return []byte(formatted), err
+	case JSONParams, ProtobufParams:
+	default:
+		return nil, fmt.Errorf("unsupported parameter format %s", e.paramFormat)
+	}
+	nonce, err := hex.DecodeString(params.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(params.CipherKey)
+	if err != nil {
+		return nil, err
+	}
+	structured := structuredParams{Version: paramsVersion, Nonce: nonce, CipherKey: key}
+	if e.paramFormat == JSONParams {
+		return json.Marshal(structured)
+	}
+	return structured.marshalProtobuf(), nil
+}
+
+// marshalProtobuf returns the protobuf encoding of the parameters
+func (p *structuredParams) marshalProtobuf() []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	out := []byte{1 << 3}
+	out = append(out, buf[:binary.PutUvarint(buf, uint64(p.Version))]...)
+	for i, field := range [][]byte{p.Nonce, p.CipherKey} {
+		out = append(out, byte(i+2)<<3|2)
+		out = append(out, buf[:binary.PutUvarint(buf, uint64(len(field)))]...)
+		out = append(out, field...)
+	}
+	return out
+}
+
+// unmarshalProtobuf decodes parameters encoded by marshalProtobuf, skipping
+// unknown fields so later versions can add them
+func (p *structuredParams) unmarshalProtobuf(data []byte) error {
+	invalid := errors.New("invalid gcm decryption parameters")
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return invalid
+		}
+		data = data[n:]
+		switch tag & 7 {
+		case 0: // varint
+			value, n := binary.Uvarint(data)
+			if n <= 0 {
+				return invalid
+			}
+			if tag>>3 == 1 {
+				p.Version = int(value)
+			}
+			data = data[n:]
+		case 2: // length delimited
+			length, n := binary.Uvarint(data)
+			if n <= 0 || length > uint64(len(data)-n) {
+				return invalid
+			}
+			value := data[n : n+int(length)]
+			switch tag >> 3 {
+			case 2:
+				p.Nonce = value
+			case 3:
+				p.CipherKey = value
+			}
+			data = data[n+int(length):]
+		case 1, 5: // fixed 64, and 32 bits
+			size := 8
+			if tag&7 == 5 {
+				size = 4
+			}
+			if len(data) < size {
+				return invalid
+			}
+			data = data[size:]
+		default:
+			return invalid
+		}
+	}
+	return nil
+}
+
+// parseStructuredParams parses parameters serialized using JSONParams, or ProtobufParams
+func parseStructuredParams(data []byte) (*GCMDecryptParams, error) {
+	var structured structuredParams
+	if data[0] == '{' {
+		if err := json.Unmarshal(data, &structured); err != nil {
+			return nil, err
+		}
+	} else if err := structured.unmarshalProtobuf(data); err != nil {
+		return nil, err
+	}
+	if structured.Version < 1 || structured.Version > paramsVersion {
+		return nil, fmt.Errorf("unsupported gcm decryption parameters version %d", structured.Version)
+	}
+	if len(structured.Nonce) == 0 || len(structured.CipherKey) == 0 {
+		return nil, errors.New("invalid gcm decryption parameters")
+	}
+	return &GCMDecryptParams{
+		Nonce:     hex.EncodeToString(structured.Nonce),
+		CipherKey: hex.EncodeToString(structured.CipherKey),
+	}, nil
+}
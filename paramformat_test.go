@@ -0,0 +1,177 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_EncryptManager_ParamFormat(t *testing.T) {
+	data := []byte("hello world")
+	tests := []struct {
+		format ParamFormat
+		prefix byte
+	}{
+		{TextParams, 'N'},
+		{JSONParams, '{'},
+		{ProtobufParams, 1 << 3},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			e := NewEncryptManager("helloworld").WithGCM(nil).WithParamFormat(tt.format)
+			encrypted, err := e.Encrypt(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			exported, err := e.RetrieveGCMDecryptionParameters()
+			if err != nil {
+				t.Fatal(err)
+			}
+			serialized, err := NewEncryptManager("helloworld").Decrypt(bytes.NewReader(exported))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if serialized[0] != tt.prefix {
+				t.Fatalf("unexpected parameter format %q", serialized)
+			}
+			params, err := ParseGCMDecryptionParameters(serialized)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *params != *e.getGCMDecryptParams() {
+				t.Fatalf("ParseGCMDecryptionParameters() = %+v", params)
+			}
+			decrypted, err := NewEncryptManager("helloworld").WithGCM(params).Decrypt(bytes.NewReader(encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+			// parameter loaders understand every format
+			store := NewMemoryParamStore()
+			if err := store.Put("object", exported); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := NewEncryptManager("helloworld").WithGCM(nil).LoadAndDecrypt(store, "object", bytes.NewReader(encrypted)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	if _, err := NewEncryptManager("helloworld").WithGCM(&GCMDecryptParams{Nonce: "00", CipherKey: "00"}).
+		WithParamFormat("xml").RetrieveGCMDecryptionParameters(); err == nil {
+		t.Fatal("expected error for unsupported format")
+	}
+}
+
+func Test_ParseGCMDecryptionParameters(t *testing.T) {
+	// unknown fields of later versions are skipped
+	protobuf := (&structuredParams{Version: 1, Nonce: []byte{1}, CipherKey: []byte{2}}).marshalProtobuf()
+	extended := append(append([]byte{}, protobuf...), 4<<3|0, 1, 5<<3|2, 1, 'x', 6<<3|5, 0, 0, 0, 0)
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"text", []byte("Nonce:\t01\nCipherKey:\t02"), false},
+		{"json", []byte(`{"version":1,"nonce":"AQ==","cipher_key":"Ag=="}`), false},
+		{"protobuf", protobuf, false},
+		{"protobuf-unknown-fields", extended, false},
+		{"json-future-version", []byte(`{"version":2,"nonce":"AQ==","cipher_key":"Ag=="}`), true},
+		{"json-missing-key", []byte(`{"version":1,"nonce":"AQ=="}`), true},
+		{"protobuf-truncated", protobuf[:len(protobuf)-1], true},
+		{"protobuf-missing-version", protobuf[2:], true},
+		{"empty", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := ParseGCMDecryptionParameters(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseGCMDecryptionParameters() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (params.Nonce != "01" || params.CipherKey != "02") {
+				t.Fatalf("ParseGCMDecryptionParameters() = %+v", params)
+			}
+		})
+	}
+}
+
+func Test_LoadGCMDecryptionParameters(t *testing.T) {
+	data := []byte("hello world")
+	for _, format := range []ParamFormat{TextParams, JSONParams, ProtobufParams} {
+		t.Run(string(format), func(t *testing.T) {
+			e := NewEncryptManager("helloworld").WithGCM(nil).WithParamFormat(format)
+			encrypted, err := e.Encrypt(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			exported, err := e.RetrieveGCMDecryptionParameters()
+			if err != nil {
+				t.Fatal(err)
+			}
+			params, err := LoadGCMDecryptionParameters(bytes.NewReader(exported), "helloworld")
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err := NewEncryptManager("helloworld").WithGCM(params).Decrypt(bytes.NewReader(encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+			if _, err := LoadGCMDecryptionParameters(bytes.NewReader(exported), "wrong"); err == nil {
+				t.Fatal("expected error loading parameters with the wrong passphrase")
+			}
+		})
+	}
+	if _, err := LoadGCMDecryptionParameters(nil, "helloworld"); err == nil {
+		t.Fatal("expected error for nil reader")
+	}
+}
+
+func Test_LoadGCMDecryptionParameters_Legacy(t *testing.T) {
+	data := []byte("hello world")
+	e := NewEncryptManager("helloworld").WithGCM(nil)
+	encrypted, err := e.Encrypt(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err := e.RetrieveGCMDecryptionParameters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serialized, err := e.serializeGCMDecryptParams(e.gcmDecryptParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := legacyCFB(t, NewEncryptManager("helloworld"), serialized)
+	// stripping the magic, and mac must not downgrade the parameters to the
+	// unauthenticated format
+	stripped := exported[len(cfbMACMagic) : len(exported)-cfbMACSize]
+	store := NewMemoryParamStore()
+	for _, blob := range [][]byte{legacy, stripped} {
+		if _, err := LoadGCMDecryptionParameters(bytes.NewReader(blob), "helloworld"); err != ErrUnauthenticatedCFB {
+			t.Fatalf("LoadGCMDecryptionParameters err = %v, want %v", err, ErrUnauthenticatedCFB)
+		}
+		store.Put("object", blob)
+		if _, err := NewEncryptManager("helloworld").WithGCM(nil).LoadAndDecrypt(store, "object", bytes.NewReader(encrypted)); err != ErrUnauthenticatedCFB {
+			t.Fatalf("LoadAndDecrypt err = %v, want %v", err, ErrUnauthenticatedCFB)
+		}
+	}
+	// the legacy format is accepted once enabled
+	params, err := LoadLegacyGCMDecryptionParameters(bytes.NewReader(legacy), "helloworld")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *params != *e.gcmDecryptParams {
+		t.Fatal("loaded parameters do not match")
+	}
+	store.Put("object", legacy)
+	decrypted, err := NewEncryptManager("helloworld").WithGCM(nil).WithLegacyCFB().LoadAndDecrypt(store, "object", bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Fatal("decrypted data does not match original")
+	}
+}
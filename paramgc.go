@@ -0,0 +1,66 @@
+package crypto
+
+import (
+	"errors"
+	"sort"
+)
+
+// ParamLister is implemented by a ParamStore able to list the ids of all stored parameters
+type ParamLister interface {
+	List() ([]string, error)
+}
+
+// ParamGCReport is the result of cross-referencing a ParamStore against the
+// objects which currently exist
+type ParamGCReport struct {
+	// Orphaned are ids with stored parameters, but no object
+	Orphaned []string
+	// Missing are ids of objects without stored parameters, which can no longer be decrypted
+	Missing []string
+	// Removed are the orphaned ids whose parameters were deleted
+	Removed []string
+}
+
+// CollectParamGarbage is used to cross-reference the parameters held by store
+// against objects, the ids of all existing objects. Orphaned parameters are
+// deleted when remove is true, otherwise they are only reported. The store
+// must implement ParamLister
+func CollectParamGarbage(store ParamStore, objects []string, remove bool) (*ParamGCReport, error) {
+	lister, ok := store.(ParamLister)
+	if !ok {
+		return nil, errors.New("param store does not support listing")
+	}
+	ids, err := lister.List()
+	if err != nil {
+		return nil, err
+	}
+	stored := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		stored[id] = true
+	}
+	existing := make(map[string]bool, len(objects))
+	report := &ParamGCReport{}
+	for _, id := range objects {
+		existing[id] = true
+		if !stored[id] {
+			report.Missing = append(report.Missing, id)
+		}
+	}
+	for _, id := range ids {
+		if !existing[id] {
+			report.Orphaned = append(report.Orphaned, id)
+		}
+	}
+	sort.Strings(report.Orphaned)
+	sort.Strings(report.Missing)
+	if !remove {
+		return report, nil
+	}
+	for _, id := range report.Orphaned {
+		if err := store.Delete(id); err != nil {
+			return report, err
+		}
+		report.Removed = append(report.Removed, id)
+	}
+	return report, nil
+}
@@ -0,0 +1,80 @@
+package crypto
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func Test_CollectParamGarbage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "paramgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileStore, err := NewFileParamStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("fakesql", "params")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	sqlStore := NewSQLParamStore(db, "params")
+	// the fake database is shared with other tests
+	if _, err := CollectParamGarbage(sqlStore, nil, true); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		store ParamStore
+	}{
+		{"memory", NewMemoryParamStore()},
+		{"file", fileStore},
+		{"sql", sqlStore},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, id := range []string{"kept", "orphan-1", "orphan-2"} {
+				if err := tt.store.Put(id, []byte("params")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			objects := []string{"kept", "unencrypted"}
+			want := &ParamGCReport{
+				Orphaned: []string{"orphan-1", "orphan-2"},
+				Missing:  []string{"unencrypted"},
+			}
+			report, err := CollectParamGarbage(tt.store, objects, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(report, want) {
+				t.Fatalf("report = %+v, want %+v", report, want)
+			}
+			want.Removed = want.Orphaned
+			if report, err = CollectParamGarbage(tt.store, objects, true); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(report, want) {
+				t.Fatalf("report = %+v, want %+v", report, want)
+			}
+			ids, err := tt.store.(ParamLister).List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(ids)
+			if !reflect.DeepEqual(ids, []string{"kept"}) {
+				t.Fatalf("remaining ids = %v", ids)
+			}
+		})
+	}
+	if _, err := CollectParamGarbage(&S3ParamStore{}, nil, false); err == nil {
+		t.Fatal("expected error using store without listing support")
+	}
+}
@@ -0,0 +1,88 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// paramRecipientMagic prefixes decryption parameters wrapped for a recipient
+var paramRecipientMagic = []byte("TPR\x01")
+
+// WithParamRecipient is used to wrap the parameters returned by
+// RetrieveGCMDecryptionParameters for a recipient public key, being an
+// *rsa.PublicKey, or any key supported by ECIES, such as X25519 keys, instead
+// of encrypting them using the passphrase, so the data key never depends on a
+// low-entropy passphrase. The format is
+//
+//	"TPR\x01" || slot type || wrapped key || nonce
+//
+// where keys are wrapped as the key slots of multi-recipient encryption.
+// Parameter loaders, such as LoadAndDecrypt, unwrap the parameters using the
+// key set with WithRecipientKey, which must be set before selecting the protocol
+func (e *EncryptManager) WithParamRecipient(public interface{}) *EncryptManager {
+	e.paramRecipient = public
+	return e
+}
+
+// LoadRecipientGCMDecryptionParameters is used to unwrap the output of
+// RetrieveGCMDecryptionParameters read from r, wrapped for the public key of
+// private using WithParamRecipient, returning the parameters ready for use
+// with WithGCM, or the protocol they were exported from
+func LoadRecipientGCMDecryptionParameters(r io.Reader, private interface{}) (*GCMDecryptParams, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewEncryptManager("").WithRecipientKey(private).loadGCMDecryptParams(data)
+}
+
+// wrapGCMDecryptParams wraps the key, and nonce for the parameter recipient
+func (e *EncryptManager) wrapGCMDecryptParams() ([]byte, error) {
+	key, nonce, err := e.decodeGCMDecryptParams()
+	if err != nil {
+		return nil, err
+	}
+	slotType, wrapped, err := e.wrapForRecipient(e.paramRecipient, append(key, nonce...))
+	if err != nil {
+		return nil, err
+	}
+	return append(append(append([]byte{}, paramRecipientMagic...), slotType), wrapped...), nil
+}
+
+// loadGCMDecryptParams recovers parameters returned by
+// RetrieveGCMDecryptionParameters, unwrapping them using the recipient key, or
+// decrypting them using the passphrase. The unauthenticated format of earlier
+// versions is only accepted when enabled using WithLegacyCFB
+func (e *EncryptManager) loadGCMDecryptParams(data []byte) (*GCMDecryptParams, error) {
+	if !bytes.HasPrefix(data, paramRecipientMagic) {
+		decrypted, err := e.decryptCFB(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return parseGCMDecryptParams(decrypted)
+	}
+	if e.recipientKey == nil {
+		return nil, errors.New("no recipient key provided")
+	}
+	if len(data) < len(paramRecipientMagic)+1 {
+		return nil, errors.New("invalid gcm decryption parameters")
+	}
+	n := len(paramRecipientMagic)
+	unwrapped, err := e.unwrapForRecipient(e.recipientKey, data[n], data[n+1:])
+	if err != nil {
+		return nil, err
+	}
+	if len(unwrapped) <= keylen {
+		return nil, errors.New("invalid gcm decryption parameters")
+	}
+	return &GCMDecryptParams{
+		CipherKey: hex.EncodeToString(unwrapped[:keylen]),
+		Nonce:     hex.EncodeToString(unwrapped[keylen:]),
+	}, nil
+}
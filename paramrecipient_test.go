@@ -0,0 +1,84 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+)
+
+func Test_EncryptManager_ParamRecipient(t *testing.T) {
+	data := []byte("hello world")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x25519Public, x25519Private := newX25519Key(t)
+	_, otherPrivate := newX25519Key(t)
+	tests := []struct {
+		name    string
+		public  interface{}
+		private interface{}
+		wantErr bool
+	}{
+		{"rsa", &rsaKey.PublicKey, rsaKey, false},
+		{"x25519", x25519Public, x25519Private, false},
+		{"wrong-key", x25519Public, otherPrivate, true},
+		{"no-key", x25519Public, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the passphrase is never used for the parameters
+			e := NewEncryptManager("").WithGCM(nil).WithParamRecipient(tt.public)
+			encrypted, err := e.Encrypt(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			wrapped, err := e.RetrieveGCMDecryptionParameters()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(wrapped, paramRecipientMagic) {
+				t.Fatal("parameters not wrapped for recipient")
+			}
+			params, err := LoadRecipientGCMDecryptionParameters(bytes.NewReader(wrapped), tt.private)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadRecipientGCMDecryptionParameters() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			decrypted, err := NewEncryptManager("").WithGCM(params).Decrypt(bytes.NewReader(encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+
+			// parameter stores unwrap using the recipient key
+			store := NewMemoryParamStore()
+			e = NewEncryptManager("").WithGCM(nil).WithParamRecipient(tt.public)
+			if encrypted, err = e.EncryptAndStore(store, "object", bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err = NewEncryptManager("").WithRecipientKey(tt.private).WithGCM(nil).
+				LoadAndDecrypt(store, "object", bytes.NewReader(encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+		})
+	}
+
+	for _, data := range [][]byte{paramRecipientMagic, append(append([]byte{}, paramRecipientMagic...), recipientECIES)} {
+		if _, err := LoadRecipientGCMDecryptionParameters(bytes.NewReader(data), x25519Private); err == nil {
+			t.Fatalf("LoadRecipientGCMDecryptionParameters(%x) expected error", data)
+		}
+	}
+	if _, err := LoadRecipientGCMDecryptionParameters(nil, x25519Private); err == nil {
+		t.Fatal("expected error with nil reader")
+	}
+}
@@ -0,0 +1,202 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrParamsNotFound is returned by a ParamStore when no decryption parameters
+// are stored for the requested object
+var ErrParamsNotFound = errors.New("decryption parameters not found")
+
+// ParamStore is used to persist the encrypted decryption parameters of objects,
+// as returned by RetrieveGCMDecryptionParameters, keyed by object identifier
+type ParamStore interface {
+	Put(id string, params []byte) error
+	Get(id string) ([]byte, error)
+	Delete(id string) error
+}
+
+// EncryptAndStore is used to encrypt r, persisting the resulting decryption
+// parameters in store under id. Protocols without decryption parameters
+// such as AES256-CFB store nothing
+func (e *EncryptManager) EncryptAndStore(store ParamStore, id string, r io.Reader) ([]byte, error) {
+	if id == "" {
+		return nil, errors.New("no object id provided")
+	}
+	res, err := e.encrypt(r, e.header)
+	if err != nil {
+		return nil, err
+	}
+	if e.hasDecryptParams() {
+		params, err := e.RetrieveGCMDecryptionParameters()
+		if err != nil {
+			return nil, err
+		}
+		if err := store.Put(id, params); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.afterEncrypt(id, res.Data); err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// LoadAndDecrypt is used to decrypt r using the decryption parameters stored
+// in store under id. The decryption parameters of the EncryptManager are not modified
+func (e *EncryptManager) LoadAndDecrypt(store ParamStore, id string, r io.Reader) ([]byte, error) {
+	if id == "" {
+		return nil, errors.New("no object id provided")
+	}
+	if !e.hasDecryptParams() {
+		return e.Decrypt(r)
+	}
+	encryptedParams, err := store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	params, err := e.loadGCMDecryptParams(encryptedParams)
+	if err != nil {
+		return nil, err
+	}
+	d := e.Clone()
+	d.gcmDecryptParams = params
+	return d.Decrypt(r)
+}
+
+// hasDecryptParams indicates whether the protocol in use produces decryption parameters
+func (e *EncryptManager) hasDecryptParams() bool {
+	switch e.getProtocol() {
+	case GCM, GCMStream, AEAD, ChaCha20Poly1305, XChaCha20Poly1305, Archive, SecretBox:
+		return true
+	default:
+		_, ok := registeredProtocol(e.getProtocol())
+		return ok
+	}
+}
+
+// MemoryParamStore is a ParamStore holding parameters in memory
+type MemoryParamStore struct {
+	mux    sync.RWMutex
+	params map[string][]byte
+}
+
+// NewMemoryParamStore is used to instantiate an empty MemoryParamStore
+func NewMemoryParamStore() *MemoryParamStore {
+	return &MemoryParamStore{params: make(map[string][]byte)}
+}
+
+// Put stores params under id
+func (m *MemoryParamStore) Put(id string, params []byte) error {
+	m.mux.Lock()
+	m.params[id] = append([]byte(nil), params...)
+	m.mux.Unlock()
+	return nil
+}
+
+// Get returns the params stored under id
+func (m *MemoryParamStore) Get(id string) ([]byte, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	params, ok := m.params[id]
+	if !ok {
+		return nil, ErrParamsNotFound
+	}
+	return append([]byte(nil), params...), nil
+}
+
+// Delete removes the params stored under id
+func (m *MemoryParamStore) Delete(id string) error {
+	m.mux.Lock()
+	delete(m.params, id)
+	m.mux.Unlock()
+	return nil
+}
+
+// List returns the ids of all stored params
+func (m *MemoryParamStore) List() ([]string, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	ids := make([]string, 0, len(m.params))
+	for id := range m.params {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// FileParamStore is a ParamStore holding parameters as files within a directory
+type FileParamStore struct {
+	dir string
+}
+
+// NewFileParamStore is used to store parameters in dir, creating it if needed
+func NewFileParamStore(dir string) (*FileParamStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileParamStore{dir: dir}, nil
+}
+
+// Put stores params under id
+func (f *FileParamStore) Put(id string, params []byte) error {
+	path, err := f.path(id)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, params, 0600)
+}
+
+// Get returns the params stored under id
+func (f *FileParamStore) Get(id string) ([]byte, error) {
+	path, err := f.path(id)
+	if err != nil {
+		return nil, err
+	}
+	params, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrParamsNotFound
+	}
+	return params, err
+}
+
+// Delete removes the params stored under id
+func (f *FileParamStore) Delete(id string) error {
+	path, err := f.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List returns the ids of all stored params
+func (f *FileParamStore) List() ([]string, error) {
+	files, err := ioutil.ReadDir(f.dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".params") {
+			ids = append(ids, strings.TrimSuffix(file.Name(), ".params"))
+		}
+	}
+	return ids, nil
+}
+
+// path returns the file used to store the params of id
+func (f *FileParamStore) path(id string) (string, error) {
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) {
+		return "", fmt.Errorf("invalid object id %q", id)
+	}
+	return filepath.Join(f.dir, id+".params"), nil
+}
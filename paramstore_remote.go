@@ -0,0 +1,166 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// S3ParamStore is a ParamStore holding parameters as objects in an S3 bucket
+type S3ParamStore struct {
+	// Endpoint is the S3 endpoint, ie https://s3.us-east-1.amazonaws.com
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is prepended to the key of every stored object
+	Prefix      string
+	Credentials AWSCredentials
+	// Client is used to make requests, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// Put stores params under id
+func (s *S3ParamStore) Put(id string, params []byte) error {
+	_, err := s.do(http.MethodPut, id, params)
+	return err
+}
+
+// Get returns the params stored under id
+func (s *S3ParamStore) Get(id string) ([]byte, error) {
+	return s.do(http.MethodGet, id, nil)
+}
+
+// Delete removes the params stored under id
+func (s *S3ParamStore) Delete(id string) error {
+	_, err := s.do(http.MethodDelete, id, nil)
+	if err == ErrParamsNotFound {
+		return nil
+	}
+	return err
+}
+
+// do performs a signed request against the object for id
+func (s *S3ParamStore) do(method, id string, body []byte) ([]byte, error) {
+	if id == "" {
+		return nil, errors.New("no object id provided")
+	}
+	resp, err := doS3Request(s.Client, s.Endpoint, s.Region, s.Bucket, s.Prefix+id,
+		s.Credentials, method, body)
+	if err == ErrObjectNotFound {
+		return nil, ErrParamsNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Close()
+	return ioutil.ReadAll(resp)
+}
+
+// VaultParamStore is a ParamStore holding parameters in a HashiCorp Vault
+// key/value (version 2) secrets engine
+type VaultParamStore struct {
+	// Address is the address of the Vault server, ie https://vault:8200
+	Address string
+	Token   string
+	// Mount is the mount path of the secrets engine, defaults to "secret"
+	Mount string
+	// Prefix is prepended to the path of every stored secret
+	Prefix string
+	// Client is used to make requests, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// vaultSecret is the payload of a key/value version 2 secret
+type vaultSecret struct {
+	Data struct {
+		Params []byte `json:"params"`
+	} `json:"data"`
+}
+
+// Put stores params under id
+func (v *VaultParamStore) Put(id string, params []byte) error {
+	var secret vaultSecret
+	secret.Data.Params = params
+	body, err := json.Marshal(secret)
+	if err != nil {
+		return err
+	}
+	_, err = v.do(http.MethodPost, "data", id, body)
+	return err
+}
+
+// Get returns the params stored under id
+func (v *VaultParamStore) Get(id string) ([]byte, error) {
+	resp, err := v.do(http.MethodGet, "data", id, nil)
+	if err != nil {
+		return nil, err
+	}
+	var secret struct {
+		Data vaultSecret `json:"data"`
+	}
+	if err := json.Unmarshal(resp, &secret); err != nil {
+		return nil, err
+	}
+	if secret.Data.Data.Params == nil {
+		return nil, ErrParamsNotFound
+	}
+	return secret.Data.Data.Params, nil
+}
+
+// Delete removes all versions of the params stored under id
+func (v *VaultParamStore) Delete(id string) error {
+	_, err := v.do(http.MethodDelete, "metadata", id, nil)
+	if err == ErrParamsNotFound {
+		return nil
+	}
+	return err
+}
+
+// do performs an authenticated request against the given api of the secret for id
+func (v *VaultParamStore) do(method, api, id string, body []byte) ([]byte, error) {
+	if id == "" {
+		return nil, errors.New("no object id provided")
+	}
+	mount := v.Mount
+	if mount == "" {
+		mount = "secret"
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/v1/%s/%s/%s",
+		strings.TrimSuffix(v.Address, "/"), mount, api, url.PathEscape(v.Prefix+id)), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return doParamStoreRequest(v.Client, req)
+}
+
+// doParamStoreRequest performs req, mapping missing objects to ErrParamsNotFound
+func doParamStoreRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrParamsNotFound
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return nil, fmt.Errorf("%s %s returned status %d: %s", req.Method, req.URL.Path, resp.StatusCode, data)
+	}
+	return data, nil
+}
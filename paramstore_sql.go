@@ -0,0 +1,91 @@
+package crypto
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// SQLParamStore is a ParamStore holding parameters in a SQL database table
+// with the schema:
+//
+//	CREATE TABLE <table> (id VARCHAR(255) PRIMARY KEY, params BLOB NOT NULL)
+type SQLParamStore struct {
+	db    *sql.DB
+	table string
+	// Placeholder returns the bind parameter for the nth (1-indexed) argument
+	// of a query, defaulting to "?". Databases such as PostgreSQL require $n
+	Placeholder func(n int) string
+}
+
+// NewSQLParamStore is used to store parameters in table of the given database
+func NewSQLParamStore(db *sql.DB, table string) *SQLParamStore {
+	return &SQLParamStore{db: db, table: table}
+}
+
+// Put stores params under id, replacing any existing params
+func (s *SQLParamStore) Put(id string, params []byte) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		fmt.Sprintf("DELETE FROM %s WHERE id = %s", s.table, s.bind(1)), id,
+	); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(
+		fmt.Sprintf("INSERT INTO %s (id, params) VALUES (%s, %s)", s.table, s.bind(1), s.bind(2)), id, params,
+	); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Get returns the params stored under id
+func (s *SQLParamStore) Get(id string) ([]byte, error) {
+	var params []byte
+	err := s.db.QueryRow(
+		fmt.Sprintf("SELECT params FROM %s WHERE id = %s", s.table, s.bind(1)), id,
+	).Scan(&params)
+	if err == sql.ErrNoRows {
+		return nil, ErrParamsNotFound
+	}
+	return params, err
+}
+
+// Delete removes the params stored under id
+func (s *SQLParamStore) Delete(id string) error {
+	if id == "" {
+		return errors.New("no object id provided")
+	}
+	_, err := s.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = %s", s.table, s.bind(1)), id)
+	return err
+}
+
+// List returns the ids of all stored params
+func (s *SQLParamStore) List() ([]string, error) {
+	rows, err := s.db.Query(fmt.Sprintf("SELECT id FROM %s", s.table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *SQLParamStore) bind(n int) string {
+	if s.Placeholder == nil {
+		return "?"
+	}
+	return s.Placeholder(n)
+}
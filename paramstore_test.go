@@ -0,0 +1,242 @@
+package crypto
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_ParamStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "paramstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileStore, err := NewFileParamStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3srv := newFakeObjectServer(t, func(r *http.Request) bool {
+		return strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/")
+	})
+	defer s3srv.Close()
+	vaultsrv := newFakeVaultServer(t)
+	defer vaultsrv.Close()
+	db, err := sql.Open("fakesql", "params")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name  string
+		store ParamStore
+	}{
+		{"memory", NewMemoryParamStore()},
+		{"file", fileStore},
+		{"sql", NewSQLParamStore(db, "params")},
+		{"s3", &S3ParamStore{
+			Endpoint:    s3srv.URL,
+			Region:      "us-east-1",
+			Bucket:      "bucket",
+			Prefix:      "params/",
+			Credentials: AWSCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret"},
+		}},
+		{"vault", &VaultParamStore{Address: vaultsrv.URL, Token: "token"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, protocol := range []Protocol{CFB, GCM, AEAD} {
+				e := NewEncryptManager("helloworld")
+				e.protocol = protocol
+				encrypted, err := e.EncryptAndStore(tt.store, "object-"+string(protocol), bytes.NewReader([]byte("hello world")))
+				if err != nil {
+					t.Fatal(err)
+				}
+				// decrypt using a fresh manager which only knows the passphrase
+				d := NewEncryptManager("helloworld")
+				d.protocol = protocol
+				decrypted, err := d.LoadAndDecrypt(tt.store, "object-"+string(protocol), bytes.NewReader(encrypted))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(decrypted) != "hello world" {
+					t.Fatalf("LoadAndDecrypt = %s", decrypted)
+				}
+			}
+			if _, err := tt.store.Get("missing"); err != ErrParamsNotFound {
+				t.Fatalf("Get err = %v, want %v", err, ErrParamsNotFound)
+			}
+			if err := tt.store.Delete("object-" + string(GCM)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tt.store.Get("object-" + string(GCM)); err != ErrParamsNotFound {
+				t.Fatalf("Get after Delete err = %v, want %v", err, ErrParamsNotFound)
+			}
+		})
+	}
+	if err := fileStore.Put("../escape", []byte("params")); err == nil {
+		t.Fatal("expected error using object id with path separators")
+	}
+}
+
+// newFakeObjectServer returns a server storing objects in memory by path,
+// rejecting requests which are not authorized
+func newFakeObjectServer(t *testing.T, authorized func(r *http.Request) bool) *httptest.Server {
+	var (
+		mux     sync.Mutex
+		objects = make(map[string][]byte)
+	)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		mux.Lock()
+		defer mux.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			data, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				t.Error(err)
+			}
+			objects[r.URL.Path] = data
+		case http.MethodGet:
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(data)
+		case http.MethodDelete:
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+}
+
+// newFakeVaultServer returns a server emulating a Vault key/value version 2 engine
+func newFakeVaultServer(t *testing.T) *httptest.Server {
+	var (
+		mux     sync.Mutex
+		secrets = make(map[string]json.RawMessage)
+	)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+		mux.Lock()
+		defer mux.Unlock()
+		name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/v1/secret/data/"), "/v1/secret/metadata/")
+		switch r.Method {
+		case http.MethodPost:
+			var req struct {
+				Data json.RawMessage `json:"data"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Error(err)
+			}
+			secrets[name] = req.Data
+		case http.MethodGet:
+			data, ok := secrets[name]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{"data": data},
+			})
+		case http.MethodDelete:
+			delete(secrets, name)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+}
+
+// fakeSQLDriver is a minimal database/sql driver supporting the
+// queries issued by SQLParamStore
+type fakeSQLDriver struct {
+	mux  sync.Mutex
+	rows map[string][]byte
+}
+
+func init() {
+	sql.Register("fakesql", &fakeSQLDriver{rows: make(map[string][]byte)})
+}
+
+func (d *fakeSQLDriver) Open(name string) (driver.Conn, error) { return &fakeSQLConn{d}, nil }
+
+type fakeSQLConn struct{ d *fakeSQLDriver }
+
+func (c *fakeSQLConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeSQLStmt{c.d, query}, nil
+}
+func (c *fakeSQLConn) Close() error              { return nil }
+func (c *fakeSQLConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *fakeSQLConn) Commit() error             { return nil }
+func (c *fakeSQLConn) Rollback() error           { return nil }
+
+type fakeSQLStmt struct {
+	d     *fakeSQLDriver
+	query string
+}
+
+func (s *fakeSQLStmt) Close() error  { return nil }
+func (s *fakeSQLStmt) NumInput() int { return strings.Count(s.query, "?") }
+
+func (s *fakeSQLStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.mux.Lock()
+	defer s.d.mux.Unlock()
+	switch {
+	case strings.HasPrefix(s.query, "DELETE"):
+		delete(s.d.rows, args[0].(string))
+	case strings.HasPrefix(s.query, "INSERT"):
+		s.d.rows[args[0].(string)] = args[1].([]byte)
+	default:
+		return nil, errors.New("unsupported query")
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeSQLStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.mux.Lock()
+	defer s.d.mux.Unlock()
+	if strings.HasPrefix(s.query, "SELECT id") {
+		rows := &fakeSQLRows{column: "id"}
+		for id := range s.d.rows {
+			rows.values = append(rows.values, id)
+		}
+		return rows, nil
+	}
+	rows := &fakeSQLRows{column: "params"}
+	if params, ok := s.d.rows[args[0].(string)]; ok {
+		rows.values = append(rows.values, params)
+	}
+	return rows, nil
+}
+
+type fakeSQLRows struct {
+	column string
+	values []driver.Value
+}
+
+func (r *fakeSQLRows) Columns() []string { return []string{r.column} }
+func (r *fakeSQLRows) Close() error      { return nil }
+func (r *fakeSQLRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0], r.values = r.values[0], r.values[1:]
+	return nil
+}
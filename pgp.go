@@ -0,0 +1,140 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// pgpArmorPrefix begins ASCII armored OpenPGP data, as produced by gpg -a
+const pgpArmorPrefix = "-----BEGIN PGP "
+
+// ReadPGPKeys reads OpenPGP public, or private keys in either the binary, or
+// ASCII armored format, such as those exported by gpg --export
+func ReadPGPKeys(r io.Reader) (openpgp.EntityList, error) {
+	br := bufio.NewReader(r)
+	if pgpArmored(br) {
+		return openpgp.ReadArmoredKeyRing(br)
+	}
+	return openpgp.ReadKeyRing(br)
+}
+
+// WithPGP is used to setup, and return EncryptManager for use with PGP,
+// being OpenPGP messages compatible with GnuPG. Content is encrypted to the
+// public keys of the given entities, or when none are given symmetrically
+// using the passphrase, as gpg -c does. Decryption uses the private keys of
+// the given entities, which are unlocked using the passphrase when needed, or
+// the passphrase for symmetrically encrypted messages. Messages may be ASCII
+// armored. RSA, and ElGamal keys are supported
+func (e *EncryptManager) WithPGP(keys openpgp.EntityList) *EncryptManager {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.protocol = PGP
+	e.pgpKeys = keys
+	return e
+}
+
+// pgpConfig returns the configuration used to write OpenPGP messages
+func (e *EncryptManager) pgpConfig() *packet.Config {
+	return &packet.Config{
+		Rand:          e.randomness(),
+		DefaultHash:   crypto.SHA256,
+		DefaultCipher: packet.CipherAES256,
+	}
+}
+
+// encryptPGP encrypts given io.Reader as an OpenPGP message
+func (e *EncryptManager) encryptPGP(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	if len(e.aad) > 0 {
+		return nil, errors.New("additional data is not supported by pgp")
+	}
+	var (
+		out bytes.Buffer
+		w   io.WriteCloser
+		err error
+	)
+	hints := &openpgp.FileHints{IsBinary: true}
+	if len(e.pgpKeys) > 0 {
+		w, err = openpgp.Encrypt(&out, e.pgpKeys, nil, hints, e.pgpConfig())
+	} else {
+		if len(e.passphrase) == 0 {
+			return nil, errors.New("no pgp keys, or passphrase provided")
+		}
+		w, err = openpgp.SymmetricallyEncrypt(&out, e.passphrase, hints, e.pgpConfig())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// decryptPGP decrypts given io.Reader holding an OpenPGP message
+func (e *EncryptManager) decryptPGP(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	if len(e.aad) > 0 {
+		return nil, errors.New("additional data is not supported by pgp")
+	}
+	br := bufio.NewReader(r)
+	var message io.Reader = br
+	if pgpArmored(br) {
+		block, err := armor.Decode(br)
+		if err != nil {
+			return nil, err
+		}
+		if block.Type != "PGP MESSAGE" {
+			return nil, errors.New("armored data is not a pgp message")
+		}
+		message = block.Body
+	}
+	// the prompt is called again after every failed attempt, so the
+	// passphrase is only offered once
+	prompted := false
+	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		if prompted || len(e.passphrase) == 0 {
+			return nil, errors.New("no matching pgp private key, or incorrect passphrase")
+		}
+		prompted = true
+		for _, k := range keys {
+			// keys the passphrase doesn't unlock are left encrypted
+			_ = k.PrivateKey.Decrypt(e.passphrase)
+		}
+		return e.passphrase, nil
+	}
+	md, err := openpgp.ReadMessage(message, e.pgpKeys, prompt, e.pgpConfig())
+	if err != nil {
+		return nil, err
+	}
+	// integrity, and signatures are checked once the body is fully read
+	decrypted, err := ioutil.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return nil, err
+	}
+	if md.IsSigned && md.SignedBy != nil && md.SignatureError != nil {
+		return nil, md.SignatureError
+	}
+	return decrypted, nil
+}
+
+// pgpArmored reports whether the buffered data is ASCII armored
+func pgpArmored(r *bufio.Reader) bool {
+	prefix, _ := r.Peek(len(pgpArmorPrefix))
+	return string(prefix) == pgpArmorPrefix
+}
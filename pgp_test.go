@@ -0,0 +1,97 @@
+package crypto
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+func readPGPKeys(t *testing.T, name string) openpgp.EntityList {
+	t.Helper()
+	fh, err := os.Open(filepath.Join("testdata/pgp", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	keys, err := ReadPGPKeys(fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func Test_EncryptManager_PGP_GnuPG(t *testing.T) {
+	secret := readPGPKeys(t, "secret.asc")
+	// messages produced by gpg -e, and gpg -c -a
+	tests := []struct {
+		name       string
+		file       string
+		keys       openpgp.EntityList
+		passphrase string
+		wantErr    bool
+	}{
+		{"public-key", "public.gpg", secret, "helloworld", false},
+		{"public-key-wrong-passphrase", "public.gpg", readPGPKeys(t, "secret.asc"), "wrong", true},
+		{"public-key-no-key", "public.gpg", nil, "helloworld", true},
+		{"symmetric", "symmetric.asc", nil, "helloworld", false},
+		{"symmetric-wrong-passphrase", "symmetric.asc", nil, "wrong", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fh, err := os.Open(filepath.Join("testdata/pgp", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fh.Close()
+			decrypted, err := NewEncryptManager(tt.passphrase).WithPGP(tt.keys).Decrypt(fh)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decrypt err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(decrypted) != "hello world" {
+				t.Fatalf("Decrypt = %s", decrypted)
+			}
+		})
+	}
+}
+
+func Test_EncryptManager_PGP(t *testing.T) {
+	public := readPGPKeys(t, "public.asc")
+	secret := readPGPKeys(t, "secret.asc")
+	encrypted, err := NewEncryptManager("").WithPGP(public).Encrypt(bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := NewEncryptManager("helloworld").WithPGP(secret).Decrypt(bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != "hello world" {
+		t.Fatalf("Decrypt = %s", decrypted)
+	}
+	// symmetric encryption using the passphrase
+	e := NewEncryptManager("helloworld").WithPGP(nil)
+	if err := e.Health(); err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err = e.Encrypt(bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted[len(encrypted)-1] ^= 1
+	if _, err := e.Decrypt(bytes.NewReader(encrypted)); err == nil {
+		t.Fatal("expected error decrypting modified message")
+	}
+	if _, err := NewEncryptManager("").WithPGP(nil).Encrypt(bytes.NewReader([]byte("hello world"))); err == nil {
+		t.Fatal("expected error encrypting without keys, or passphrase")
+	}
+	// managers holding only public keys can't decrypt
+	if err := NewEncryptManager("").WithPGP(public).Health(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.WithAdditionalData([]byte("object")).Encrypt(bytes.NewReader([]byte("hello world"))); err == nil {
+		t.Fatal("expected error using additional data")
+	}
+}
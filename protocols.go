@@ -0,0 +1,128 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Encrypter encrypts data for a protocol registered using RegisterProtocol
+type Encrypter interface {
+	// Encrypt seals plaintext under key, and nonce, authenticating aad
+	Encrypt(key, nonce, plaintext, aad []byte) ([]byte, error)
+}
+
+// Decrypter decrypts data for a protocol registered using RegisterProtocol
+type Decrypter interface {
+	// Decrypt opens ciphertext sealed by Encrypt, returning an error if it,
+	// or aad, was modified
+	Decrypt(key, nonce, ciphertext, aad []byte) ([]byte, error)
+}
+
+// ProtocolImpl implements a custom protocol, such as SM4, or Twofish. For
+// every encryption the EncryptManager generates a random key of KeySize
+// bytes, and nonce of NonceSize bytes, handled as the decryption parameters
+// in the same way as those of AES256-GCM
+type ProtocolImpl interface {
+	Encrypter
+	Decrypter
+	KeySize() int
+	NonceSize() int
+}
+
+var (
+	protocolMux         sync.RWMutex
+	registeredProtocols = make(map[Protocol]ProtocolImpl)
+)
+
+// RegisterProtocol is used to add support for a custom protocol, selected
+// using WithProtocol, or EncryptManager.WithRegisteredProtocol, and recorded
+// by name in envelopes. The protocol must be registered wherever data
+// encrypted using it is decrypted. Built-in protocols take precedence, and
+// can not be replaced
+func RegisterProtocol(name string, impl ProtocolImpl) {
+	protocolMux.Lock()
+	registeredProtocols[Protocol(name)] = impl
+	protocolMux.Unlock()
+}
+
+// registeredProtocol returns the implementation of a registered protocol
+func registeredProtocol(protocol Protocol) (ProtocolImpl, bool) {
+	for _, p := range protocols {
+		if p == protocol {
+			return nil, false
+		}
+	}
+	protocolMux.RLock()
+	impl, ok := registeredProtocols[protocol]
+	protocolMux.RUnlock()
+	return impl, ok && impl != nil
+}
+
+// WithRegisteredProtocol is used to setup, and return EncryptManager for use
+// with a protocol registered using RegisterProtocol. The params are expected
+// to be unencrypted, and in hex encoded string format
+func (e *EncryptManager) WithRegisteredProtocol(protocol Protocol, params *GCMDecryptParams) (*EncryptManager, error) {
+	if _, ok := registeredProtocol(protocol); !ok {
+		return nil, fmt.Errorf("protocol %s is not registered", protocol)
+	}
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.protocol = protocol
+	e.gcmDecryptParams = params
+	return e, nil
+}
+
+// encryptRegistered encrypts given io.Reader using a registered protocol
+// the resultant encrypted bytes, nonce, and cipher key are returned
+func (e *EncryptManager) encryptRegistered(impl ProtocolImpl, r io.Reader) ([]byte, []byte, []byte, error) {
+	if r == nil {
+		return nil, nil, nil, errors.New("invalid content provided")
+	}
+	cipherKeyBytes := make([]byte, impl.KeySize())
+	if _, err := io.ReadFull(e.randomness(), cipherKeyBytes); err != nil {
+		return nil, nil, nil, err
+	}
+	nonce := make([]byte, impl.NonceSize())
+	if _, err := io.ReadFull(e.randomness(), nonce); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := e.recordNonce(cipherKeyBytes, nonce); err != nil {
+		return nil, nil, nil, err
+	}
+	dataToEncrypt, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	encryptedData, err := impl.Encrypt(cipherKeyBytes, nonce, dataToEncrypt, e.additionalData())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return encryptedData, nonce, cipherKeyBytes, nil
+}
+
+// decryptRegistered is used to decrypt the given io.Reader encrypted using a
+// registered protocol
+func (e *EncryptManager) decryptRegistered(impl ProtocolImpl, r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	decodedKey, decodedNonce, err := e.decryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	if len(decodedKey) != impl.KeySize() || len(decodedNonce) != impl.NonceSize() {
+		return nil, errors.New("invalid key, or nonce size")
+	}
+	encryptedData, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	decrypted, err := impl.Decrypt(decodedKey, decodedNonce, encryptedData, e.additionalData())
+	if err != nil && e.objectBound {
+		return nil, ErrObjectMismatch
+	}
+	return decrypted, err
+}
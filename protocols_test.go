@@ -0,0 +1,96 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+)
+
+// aes128GCM is a custom protocol using AES128-GCM with standard nonces
+type aes128GCM struct{}
+
+func (aes128GCM) aead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (p aes128GCM) Encrypt(key, nonce, plaintext, aad []byte) ([]byte, error) {
+	aead, err := p.aead(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, nonce, plaintext, aad), nil
+}
+
+func (p aes128GCM) Decrypt(key, nonce, ciphertext, aad []byte) ([]byte, error) {
+	aead, err := p.aead(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, nonce, ciphertext, aad)
+}
+
+func (aes128GCM) KeySize() int { return 16 }
+
+func (aes128GCM) NonceSize() int { return 12 }
+
+func Test_RegisterProtocol(t *testing.T) {
+	RegisterProtocol("AES128-GCM", aes128GCM{})
+	// built-in protocols can not be replaced
+	RegisterProtocol(string(GCM), aes128GCM{})
+	if _, ok := registeredProtocol(GCM); ok {
+		t.Fatal("built-in protocol was replaced")
+	}
+	if _, err := New(WithProtocol("TWOFISH")); err == nil {
+		t.Fatal("expected error using unregistered protocol")
+	}
+	if _, err := NewEncryptManager("helloworld").WithRegisteredProtocol("TWOFISH", nil); err == nil {
+		t.Fatal("expected error using unregistered protocol")
+	}
+	e, err := New(WithPassphrase("helloworld"), WithProtocol("AES128-GCM"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := e.Encrypt(bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := e.getGCMDecryptParams()
+	if len(params.CipherKey) != 32 || len(params.Nonce) != 24 {
+		t.Fatalf("params = %+v, want a 16 byte key, and 12 byte nonce", params)
+	}
+	d, err := NewEncryptManager("helloworld").WithRegisteredProtocol("AES128-GCM", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := d.Decrypt(bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != "hello world" {
+		t.Fatalf("Decrypt = %s", decrypted)
+	}
+	encrypted[0] ^= 1
+	if _, err := d.Decrypt(bytes.NewReader(encrypted)); err == nil {
+		t.Fatal("expected error decrypting modified data")
+	}
+	// the protocol is recorded by name in envelopes
+	env, payload, err := e.EncryptSplit(bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Protocol != "AES128-GCM" {
+		t.Fatalf("envelope protocol = %s", env.Protocol)
+	}
+	decrypted, err = NewEncryptManager("helloworld").DecryptSplit(env, bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != "hello world" {
+		t.Fatalf("DecryptSplit = %s", decrypted)
+	}
+}
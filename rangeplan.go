@@ -0,0 +1,96 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ChunkLayout describes the layout of chunked ciphertext, being a header of
+// HeaderSize bytes followed by chunks of ChunkSize bytes of plaintext, each
+// sealed with Overhead bytes. Every chunk is full sized, except for the final
+// chunk which may be shorter, and is empty only when the plaintext is empty
+type ChunkLayout struct {
+	HeaderSize int64
+	ChunkSize  int64
+	Overhead   int64
+}
+
+// SegmentLayout is the layout of GCM-STREAM output, and of EncryptStream
+// output using AES256-GCM, or the AEAD profile, where the header is the
+// cipher identifier. Output using a chunk size set by WithChunkSize instead
+// has a HeaderSize of 5, recording the chunk size after the cipher identifier
+var SegmentLayout = ChunkLayout{HeaderSize: 1, ChunkSize: segmentSize, Overhead: 16}
+
+// ByteRange is the range of bytes [Start, End)
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+// RangePlan describes the ciphertext required to decrypt a range of plaintext
+type RangePlan struct {
+	// Ranges are the ranges of ciphertext to fetch, holding the header, and
+	// the chunks covering the plaintext range, merged when adjacent
+	Ranges []ByteRange
+	// FirstChunk, and LastChunk are the indexes of the chunks to decrypt
+	FirstChunk int64
+	LastChunk  int64
+	// Skip is the number of bytes to discard from the start of the plaintext
+	// of the first chunk, and Length the number of bytes to return from there
+	Skip   int64
+	Length int64
+}
+
+// Chunks returns the number of chunks of ciphertext holding size bytes of plaintext
+func (l ChunkLayout) Chunks(size int64) int64 {
+	if size <= 0 {
+		return 1
+	}
+	return (size + l.ChunkSize - 1) / l.ChunkSize
+}
+
+// CiphertextSize returns the size of ciphertext holding size bytes of plaintext
+func (l ChunkLayout) CiphertextSize(size int64) int64 {
+	return l.HeaderSize + size + l.Chunks(size)*l.Overhead
+}
+
+// PlanRange is used to plan the ciphertext ranges required to decrypt length
+// bytes of plaintext from offset, of an object holding size bytes of
+// plaintext, so only those ranges need to be requested from object storage
+func (l ChunkLayout) PlanRange(size, offset, length int64) (*RangePlan, error) {
+	if l.HeaderSize < 0 || l.ChunkSize <= 0 || l.Overhead < 0 {
+		return nil, errors.New("invalid chunk layout")
+	}
+	if offset < 0 || length <= 0 || size < 0 || offset > size-length {
+		return nil, fmt.Errorf("invalid range of %d bytes from %d of %d bytes", length, offset, size)
+	}
+	sealed := l.ChunkSize + l.Overhead
+	plan := &RangePlan{
+		FirstChunk: offset / l.ChunkSize,
+		LastChunk:  (offset + length - 1) / l.ChunkSize,
+		Skip:       offset % l.ChunkSize,
+		Length:     length,
+	}
+	start := l.HeaderSize + plan.FirstChunk*sealed
+	end := l.HeaderSize + (plan.LastChunk+1)*sealed
+	if total := l.CiphertextSize(size); end > total {
+		end = total
+	}
+	if l.HeaderSize > 0 && start > l.HeaderSize {
+		plan.Ranges = append(plan.Ranges, ByteRange{Start: 0, End: l.HeaderSize})
+	} else {
+		start = 0
+	}
+	plan.Ranges = append(plan.Ranges, ByteRange{Start: start, End: end})
+	return plan, nil
+}
+
+// HTTPRange formats the ranges of the plan as the value of an HTTP Range header
+func (p *RangePlan) HTTPRange() string {
+	ranges := make([]string, len(p.Ranges))
+	for i, r := range p.Ranges {
+		ranges[i] = fmt.Sprintf("%d-%d", r.Start, r.End-1)
+	}
+	return "bytes=" + strings.Join(ranges, ",")
+}
@@ -0,0 +1,90 @@
+package crypto
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func Test_ChunkLayout_PlanRange(t *testing.T) {
+	layout := ChunkLayout{HeaderSize: 1, ChunkSize: 10, Overhead: 2}
+	tests := []struct {
+		name                 string
+		size, offset, length int64
+		ranges               []ByteRange
+		httpRange            string
+		wantErr              bool
+	}{
+		{"first-chunk", 25, 0, 5, []ByteRange{{0, 13}}, "bytes=0-12", false},
+		{"spanning", 25, 8, 5, []ByteRange{{0, 25}}, "bytes=0-24", false},
+		{"last-chunk", 25, 20, 5, []ByteRange{{0, 1}, {25, 32}}, "bytes=0-0,25-31", false},
+		{"middle-chunk", 25, 12, 3, []ByteRange{{0, 1}, {13, 25}}, "bytes=0-0,13-24", false},
+		{"past-end", 25, 20, 6, nil, "", true},
+		{"negative-offset", 25, -1, 5, nil, "", true},
+		{"empty", 25, 0, 0, nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := layout.PlanRange(tt.size, tt.offset, tt.length)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PlanRange() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(plan.Ranges) != len(tt.ranges) {
+				t.Fatalf("PlanRange() ranges = %v, want %v", plan.Ranges, tt.ranges)
+			}
+			for i := range tt.ranges {
+				if plan.Ranges[i] != tt.ranges[i] {
+					t.Fatalf("PlanRange() ranges = %v, want %v", plan.Ranges, tt.ranges)
+				}
+			}
+			if plan.HTTPRange() != tt.httpRange {
+				t.Fatalf("HTTPRange() = %s, want %s", plan.HTTPRange(), tt.httpRange)
+			}
+		})
+	}
+}
+
+func Test_SegmentLayout_PlanRange(t *testing.T) {
+	data := make([]byte, 3*segmentSize+100)
+	rand.Read(data)
+	e := NewEncryptManager("").WithGCM(nil)
+	var encrypted bytes.Buffer
+	if err := e.EncryptStream(&encrypted, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	size := int64(len(data))
+	if SegmentLayout.CiphertextSize(size) != int64(encrypted.Len()) {
+		t.Fatalf("CiphertextSize() = %d, want %d", SegmentLayout.CiphertextSize(size), encrypted.Len())
+	}
+	for i := 0; i < 20; i++ {
+		offset := rand.Int63n(size)
+		length := 1 + rand.Int63n(size-offset)
+		plan, err := SegmentLayout.PlanRange(size, offset, length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// only the planned ranges are fetched, the rest is left zeroed
+		fetched := make([]byte, encrypted.Len())
+		for _, r := range plan.Ranges {
+			copy(fetched[r.Start:r.End], encrypted.Bytes()[r.Start:r.End])
+		}
+		seeker, err := e.DecryptSeeker(bytes.NewReader(fetched))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		out := make([]byte, length)
+		if _, err := io.ReadFull(seeker, out); err != nil {
+			t.Fatalf("range %d+%d: %v", offset, length, err)
+		}
+		if !bytes.Equal(out, data[offset:offset+length]) {
+			t.Fatalf("range %d+%d does not match original", offset, length)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// ErrInvalidReceipt is returned when a decryption receipt does not match the
+// encrypted data, was not signed by the expected key, or was modified
+var ErrInvalidReceipt = errors.New("invalid decryption receipt")
+
+// receiptContext separates receipt signatures from other uses of the signing key
+const receiptContext = "temporal-decryption-receipt"
+
+// DecryptionReceipt is a signed record that encrypted data was decrypted,
+// allowing auditors to verify a chain of custody offline using only the
+// encrypted data, and the public key of the signer
+type DecryptionReceipt struct {
+	// CiphertextDigest is the SHA-256 digest of the encrypted data, as returned by CiphertextDigest
+	CiphertextDigest []byte `json:"ciphertext_digest"`
+	// KeyID identifies the key used to decrypt, as configured using WithDecryptionReceipts
+	KeyID string `json:"key_id,omitempty"`
+	// Timestamp is the time of decryption
+	Timestamp time.Time `json:"timestamp"`
+	// Context is supplied by the caller, ie the requesting user, or a case number
+	Context   string            `json:"context,omitempty"`
+	Signer    ed25519.PublicKey `json:"signer"`
+	Signature []byte            `json:"signature"`
+}
+
+// WithDecryptionReceipts is used to sign a DecryptionReceipt for every
+// successful decryption using the signer key, recording keyID to identify
+// the decryption key. The receipt of the last Decrypt is available via
+// LastDecryptionReceipt, while DecryptWithReceipt records caller context
+func (e *EncryptManager) WithDecryptionReceipts(signer ed25519.PrivateKey, keyID string) *EncryptManager {
+	e.receiptSigner = signer
+	e.receiptKeyID = keyID
+	return e
+}
+
+// LastDecryptionReceipt returns the receipt signed for the last Decrypt
+func (e *EncryptManager) LastDecryptionReceipt() *DecryptionReceipt {
+	e.mux.RLock()
+	defer e.mux.RUnlock()
+	return e.lastReceipt
+}
+
+// DecryptWithReceipt is used to decrypt r as Decrypt does, returning a receipt
+// recording the caller supplied context. WithDecryptionReceipts is required
+func (e *EncryptManager) DecryptWithReceipt(r io.Reader, context string) ([]byte, *DecryptionReceipt, error) {
+	if len(e.receiptSigner) != ed25519.PrivateKeySize {
+		return nil, nil, errors.New("no receipt signing key provided")
+	}
+	if r == nil {
+		return nil, nil, errors.New("invalid content provided")
+	}
+	digest := sha256.New()
+	out, err := e.decryptObject(io.TeeReader(r, digest))
+	if err != nil {
+		return nil, nil, e.decryptError(err)
+	}
+	receipt := &DecryptionReceipt{
+		CiphertextDigest: digest.Sum(nil),
+		KeyID:            e.receiptKeyID,
+		Timestamp:        e.now().UTC(),
+		Context:          context,
+		Signer:           e.receiptSigner.Public().(ed25519.PublicKey),
+	}
+	receipt.Signature = ed25519.Sign(e.receiptSigner, receipt.message())
+	return out, receipt, nil
+}
+
+// VerifyDecryptionReceipt is used to verify that receipt was signed by the
+// trusted key for the encrypted data read from r
+func VerifyDecryptionReceipt(r io.Reader, receipt *DecryptionReceipt, trusted ed25519.PublicKey) error {
+	if receipt == nil || len(trusted) != ed25519.PublicKeySize {
+		return errors.New("no receipt, or trusted key provided")
+	}
+	digest, err := CiphertextDigest(r)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(digest, receipt.CiphertextDigest) || !bytes.Equal(receipt.Signer, trusted) {
+		return ErrInvalidReceipt
+	}
+	if !ed25519.Verify(trusted, receipt.message(), receipt.Signature) {
+		return ErrInvalidReceipt
+	}
+	return nil
+}
+
+// message returns the signed encoding of the receipt, in the format of
+//
+//	context || digest || key id || timestamp || caller context || signer
+//
+// where the timestamp is in nanoseconds since the unix epoch, and all other
+// fields are length prefixed as for Canonicalize
+func (receipt *DecryptionReceipt) message() []byte {
+	buf := bytes.NewBufferString(receiptContext)
+	writeCanonical(buf, receipt.CiphertextDigest)
+	writeCanonical(buf, []byte(receipt.KeyID))
+	binary.Write(buf, binary.BigEndian, receipt.Timestamp.UnixNano())
+	writeCanonical(buf, []byte(receipt.Context))
+	writeCanonical(buf, receipt.Signer)
+	return buf.Bytes()
+}
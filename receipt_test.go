@@ -0,0 +1,91 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+func Test_EncryptManager_DecryptionReceipts(t *testing.T) {
+	data := []byte("hello world")
+	public, signer, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	e := NewEncryptManager("helloworld").WithClock(ClockFunc(func() time.Time { return now }))
+	encrypted, err := e.Encrypt(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := e.DecryptWithReceipt(bytes.NewReader(encrypted), "case-1"); err == nil {
+		t.Fatal("expected error without a signing key")
+	}
+	e.WithDecryptionReceipts(signer, "archive-key-1")
+	decrypted, receipt, err := e.DecryptWithReceipt(bytes.NewReader(encrypted), "case-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Fatal("decrypted data does not match original")
+	}
+	if receipt.KeyID != "archive-key-1" || receipt.Context != "case-1" || !receipt.Timestamp.Equal(now) {
+		t.Fatalf("receipt = %+v", receipt)
+	}
+	// receipts survive serialization, and verify offline
+	encoded, err := json.Marshal(receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded DecryptionReceipt
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyDecryptionReceipt(bytes.NewReader(encrypted), &decoded, public); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := decoded
+	tampered.Context = "case-2"
+	tests := []struct {
+		name    string
+		data    []byte
+		receipt *DecryptionReceipt
+		trusted ed25519.PublicKey
+	}{
+		{"other-data", []byte("other data"), &decoded, public},
+		{"untrusted-signer", encrypted, &decoded, other},
+		{"tampered", encrypted, &tampered, public},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := VerifyDecryptionReceipt(bytes.NewReader(tt.data), tt.receipt, tt.trusted); err != ErrInvalidReceipt {
+				t.Fatalf("VerifyDecryptionReceipt() err = %v, want %v", err, ErrInvalidReceipt)
+			}
+		})
+	}
+
+	// Decrypt records the receipt of the last decryption
+	if _, err := e.Decrypt(bytes.NewReader(encrypted)); err != nil {
+		t.Fatal(err)
+	}
+	last := e.LastDecryptionReceipt()
+	if last == nil || last.Context != "" {
+		t.Fatalf("LastDecryptionReceipt() = %+v", last)
+	}
+	if err := VerifyDecryptionReceipt(bytes.NewReader(encrypted), last, public); err != nil {
+		t.Fatal(err)
+	}
+	// no receipt is issued for failed decryptions
+	if _, _, err := e.DecryptWithReceipt(bytes.NewReader(encrypted[:10]), ""); err == nil {
+		t.Fatal("expected error decrypting truncated data")
+	}
+}
@@ -0,0 +1,201 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// ErrNoMatchingRecipient is returned when multi-recipient encrypted data has
+// no key slot which can be opened using the recipient key
+var ErrNoMatchingRecipient = errors.New("no key slot matches the recipient key")
+
+// recipientsMagic identifies multi-recipient encrypted data
+var recipientsMagic = []byte("TMR\x01")
+
+// types of multi-recipient key slots
+const (
+	recipientRSA   byte = 1
+	recipientECIES byte = 2
+)
+
+// WithRecipients is used to setup, and return EncryptManager for use with
+// multi-recipient encryption, so content encrypted once can be decrypted by
+// each recipient using their own private key. Content is encrypted using a
+// random AES256-GCM key, which is wrapped separately for every recipient in a
+// key slot stored alongside the content. Recipients may be RSA public keys,
+// which wrap the key using RSA-OAEP with SHA-256, or any key supported by ECIES,
+// such as X25519 keys. Decryption requires WithRecipientKey
+func (e *EncryptManager) WithRecipients(recipients ...interface{}) *EncryptManager {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.protocol = MultiRecipient
+	e.recipients = recipients
+	return e
+}
+
+// WithRecipientKey is used to set the private key used to decrypt multi-recipient
+// encrypted data, being an *rsa.PrivateKey, or any key supported by ECIES. Every
+// key slot is tried until one can be opened using the key
+func (e *EncryptManager) WithRecipientKey(private interface{}) *EncryptManager {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.protocol = MultiRecipient
+	e.recipientKey = private
+	return e
+}
+
+// encryptRecipients encrypts given io.Reader to all configured recipients, in
+// the format of magic || slot count(2) || slots || nonce || ciphertext, where
+// every slot is type(1) || length(2) || wrapped key, and all slots are
+// authenticated as additional data
+func (e *EncryptManager) encryptRecipients(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	if len(e.recipients) == 0 || len(e.recipients) > 0xffff {
+		return nil, errors.New("invalid number of recipients")
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	cipherKey := make([]byte, keylen)
+	if _, err := io.ReadFull(e.randomness(), cipherKey); err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(append([]byte{}, recipientsMagic...))
+	binary.Write(buf, binary.BigEndian, uint16(len(e.recipients)))
+	for _, recipient := range e.recipients {
+		slotType, wrapped, err := e.wrapForRecipient(recipient, cipherKey)
+		if err != nil {
+			return nil, err
+		}
+		if len(wrapped) > 0xffff {
+			return nil, errors.New("wrapped key is too large")
+		}
+		buf.WriteByte(slotType)
+		binary.Write(buf, binary.BigEndian, uint16(len(wrapped)))
+		buf.Write(wrapped)
+	}
+	aead, err := newAEAD(aeadAES256GCM, cipherKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(e.randomness(), nonce); err != nil {
+		return nil, err
+	}
+	if err := e.recordNonce(cipherKey, nonce); err != nil {
+		return nil, err
+	}
+	header := buf.Bytes()
+	out := append(append([]byte{}, header...), nonce...)
+	return aead.Seal(out, nonce, data, header), nil
+}
+
+// wrapForRecipient wraps key for recipient, returning the slot type
+func (e *EncryptManager) wrapForRecipient(recipient interface{}, key []byte) (byte, []byte, error) {
+	if public, ok := recipient.(*rsa.PublicKey); ok {
+		wrapped, err := rsa.EncryptOAEP(crypto.SHA256.New(), e.randomness(), public, key, nil)
+		return recipientRSA, wrapped, err
+	}
+	wrapped, err := eciesSeal(e.randomness(), recipient, key)
+	return recipientECIES, wrapped, err
+}
+
+// decryptRecipients decrypts given io.Reader using the first key slot which
+// can be opened using the configured recipient key
+func (e *EncryptManager) decryptRecipients(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	if e.recipientKey == nil {
+		return nil, errors.New("no recipient key provided")
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	slots, n, err := parseRecipientSlots(data)
+	if err != nil {
+		return nil, err
+	}
+	header := data[:n]
+	for _, slot := range slots {
+		key, err := e.unwrapForRecipient(e.recipientKey, slot.slotType, slot.wrapped)
+		if err != nil || len(key) != keylen {
+			continue
+		}
+		aead, err := newAEAD(aeadAES256GCM, key)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < n+aead.NonceSize() {
+			return nil, errors.New("invalid content provided")
+		}
+		nonce := data[n : n+aead.NonceSize()]
+		return aead.Open(nil, nonce, data[n+aead.NonceSize():], header)
+	}
+	return nil, ErrNoMatchingRecipient
+}
+
+// unwrapForRecipient attempts to unwrap a key slot using the recipient key
+func (e *EncryptManager) unwrapForRecipient(recipient interface{}, slotType byte, wrapped []byte) ([]byte, error) {
+	private, isRSA := recipient.(*rsa.PrivateKey)
+	switch {
+	case slotType == recipientRSA && isRSA:
+		return rsa.DecryptOAEP(crypto.SHA256.New(), e.randomness(), private, wrapped, nil)
+	case slotType == recipientECIES && !isRSA:
+		return eciesOpen(recipient, wrapped)
+	default:
+		return nil, errors.New("key slot does not match the recipient key type")
+	}
+}
+
+// recipientPublicKey returns the public key of a recipient private key
+func recipientPublicKey(private interface{}) (interface{}, error) {
+	if private, ok := private.(*rsa.PrivateKey); ok {
+		return &private.PublicKey, nil
+	}
+	return eciesPublicKey(private)
+}
+
+// recipientSlot is a wrapped key within multi-recipient encrypted data
+type recipientSlot struct {
+	slotType byte
+	wrapped  []byte
+}
+
+// parseRecipientSlots returns the key slots of multi-recipient encrypted
+// data, and the size of the header holding them
+func parseRecipientSlots(data []byte) ([]recipientSlot, int, error) {
+	if !bytes.HasPrefix(data, recipientsMagic) || len(data) < len(recipientsMagic)+2 {
+		return nil, 0, errors.New("invalid multi-recipient content")
+	}
+	n := len(recipientsMagic)
+	count := int(binary.BigEndian.Uint16(data[n:]))
+	n += 2
+	slots := make([]recipientSlot, 0, count)
+	for i := 0; i < count; i++ {
+		if len(data) < n+3 {
+			return nil, 0, errors.New("invalid multi-recipient content")
+		}
+		slotType, length := data[n], int(binary.BigEndian.Uint16(data[n+1:]))
+		n += 3
+		if len(data) < n+length {
+			return nil, 0, errors.New("invalid multi-recipient content")
+		}
+		if slotType != recipientRSA && slotType != recipientECIES {
+			return nil, 0, fmt.Errorf("unsupported key slot type %d", slotType)
+		}
+		slots = append(slots, recipientSlot{slotType: slotType, wrapped: data[n : n+length]})
+		n += length
+	}
+	return slots, n, nil
+}
@@ -0,0 +1,83 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+func newX25519Key(t *testing.T) (*[32]byte, *[32]byte) {
+	var private, public [32]byte
+	if _, err := rand.Read(private[:]); err != nil {
+		t.Fatal(err)
+	}
+	curve25519.ScalarBaseMult(&public, &private)
+	return &public, &private
+}
+
+func Test_EncryptManager_Recipients(t *testing.T) {
+	data := bytes.Repeat([]byte("hello world"), 1000)
+	alice, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobPublic, bobPrivate := newX25519Key(t)
+	carolPublic, carolPrivate := newX25519Key(t)
+	_, malloryPrivate := newX25519Key(t)
+
+	encrypted, err := NewEncryptManager("").WithRecipients(&alice.PublicKey, bobPublic, carolPublic).Encrypt(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, private := range map[string]interface{}{"alice": alice, "bob": bobPrivate, "carol": carolPrivate} {
+		t.Run(name, func(t *testing.T) {
+			e := NewEncryptManager("").WithRecipientKey(private)
+			decrypted, err := e.Decrypt(bytes.NewReader(encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+			if err := e.Health(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	if _, err := NewEncryptManager("").WithRecipientKey(malloryPrivate).Decrypt(bytes.NewReader(encrypted)); err != ErrNoMatchingRecipient {
+		t.Fatalf("err = %v, want %v", err, ErrNoMatchingRecipient)
+	}
+	// key slots are authenticated with the content
+	tampered := append([]byte{}, encrypted...)
+	tampered[len(recipientsMagic)+3] ^= 0xff
+	if _, err := NewEncryptManager("").WithRecipientKey(carolPrivate).Decrypt(bytes.NewReader(tampered)); err == nil {
+		t.Fatal("decrypted data with tampered key slots")
+	}
+	in, err := Inspect(bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.Format != FormatMultiRecipient || in.Recipients != 3 || !strings.Contains(in.String(), "Recipients:\t3") {
+		t.Fatalf("Inspect() = %+v", in)
+	}
+}
+
+func Test_EncryptManager_Recipients_Errors(t *testing.T) {
+	_, private := newX25519Key(t)
+	if _, err := NewEncryptManager("").WithRecipients().Encrypt(bytes.NewReader([]byte("hello"))); err == nil {
+		t.Fatal("encrypted without recipients")
+	}
+	if _, err := NewEncryptManager("").WithRecipients("key").Encrypt(bytes.NewReader([]byte("hello"))); err == nil {
+		t.Fatal("encrypted to an unsupported key")
+	}
+	if _, err := NewEncryptManager("").WithRecipientKey(private).Decrypt(bytes.NewReader([]byte("TMR\x01\x00\x01\x02\x00\x20"))); err == nil {
+		t.Fatal("decrypted truncated content")
+	}
+	if _, err := NewEncryptManager("").WithRecipients().Decrypt(bytes.NewReader([]byte("hello"))); err == nil {
+		t.Fatal("decrypted without a recipient key")
+	}
+}
@@ -0,0 +1,89 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"encoding/hex"
+	"io"
+)
+
+// EncryptResult is the result of a single encryption, recording the random
+// parameters generated to protect the object, so audit systems, and external
+// verifiers can record them. Parameters not used by the protocol are nil
+type EncryptResult struct {
+	// Data is the encrypted data, as returned by Encrypt
+	Data []byte
+	// Protocol is the protocol used
+	Protocol Protocol
+	// KDF is the key derivation function used by AES256-CFB, if configured using WithKDF
+	KDF *KDFConfig
+	// Salt is the salt the AES256-CFB key was derived using
+	Salt []byte
+	// IV is the AES256-CFB initialization vector
+	IV []byte
+	// MAC is the HMAC-SHA-256 authenticating the AES256-CFB output
+	MAC []byte
+	// Nonce is the nonce, or for segmented protocols the nonce prefix, used
+	// with a cipher key
+	Nonce []byte
+	// Params are the decryption parameters, for protocols using a cipher key
+	Params *GCMDecryptParams
+	// Signature is the signature of the plaintext, if configured using WithSignedPlaintext
+	Signature []byte
+}
+
+// EncryptWithResult is used to encrypt r as Encrypt does, also returning the
+// parameters generated for this encryption. Unlike the decryption parameters
+// of the manager, the result is not replaced by later encryptions
+func (e *EncryptManager) EncryptWithResult(r io.Reader) (*EncryptResult, error) {
+	res, err := e.encrypt(r, e.header)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.afterEncrypt("", res.Data); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// newEncryptResult records the parameters used to produce out, the output of
+// the configured protocol without a header
+func (e *EncryptManager) newEncryptResult(out []byte, params *GCMDecryptParams) (*EncryptResult, error) {
+	res := &EncryptResult{Data: out, Protocol: e.getProtocol(), Params: params}
+	if res.Protocol == CFB {
+		// AES256-CFB output is in the format of
+		// magic || [kdf header] || iv || ciphertext || salt || mac
+		out = out[len(cfbMACMagic):]
+		kdf, n, err := parseKDFHeader(out)
+		if err != nil {
+			return nil, err
+		}
+		out = out[n:]
+		res.KDF = kdf
+		res.IV = out[:aes.BlockSize]
+		res.Salt = out[len(out)-kdf.saltLength()-cfbMACSize : len(out)-cfbMACSize]
+		res.MAC = out[len(out)-cfbMACSize:]
+		return res, nil
+	}
+	if params != nil {
+		nonce, err := hex.DecodeString(params.Nonce)
+		if err != nil {
+			return nil, err
+		}
+		res.Nonce = nonce
+	}
+	return res, nil
+}
+
+// cfbCiphertext returns the ciphertext of the AES256-CFB output recorded by
+// res, which is in the format of magic || [kdf header] || iv || ciphertext || salt || mac
+func (res *EncryptResult) cfbCiphertext() ([]byte, error) {
+	start := len(cfbMACMagic) + aes.BlockSize
+	if res.KDF != nil {
+		kdf, err := res.KDF.header()
+		if err != nil {
+			return nil, err
+		}
+		start += len(kdf)
+	}
+	return res.Data[start : len(res.Data)-res.KDF.saltLength()-cfbMACSize], nil
+}
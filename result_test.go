@@ -0,0 +1,75 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func Test_EncryptManager_EncryptWithResult(t *testing.T) {
+	data := []byte("hello world")
+	tests := []struct {
+		name      string
+		e         *EncryptManager
+		wantIV    bool
+		wantNonce int
+	}{
+		{"cfb", NewEncryptManager("helloworld"), true, 0},
+		{"cfb-kdf", NewEncryptManager("helloworld").WithKDF(KDFConfig{KDF: Scrypt, N: 1024, R: 8, P: 1}), true, 0},
+		{"gcm", NewEncryptManager("helloworld").WithGCM(nil), false, 24},
+		{"aead", NewEncryptManager("helloworld").WithAEAD(nil), false, nonceSize},
+		{"chacha20", NewEncryptManager("helloworld").WithChaCha20Poly1305(nil), false, 12},
+		{"xchacha20", NewEncryptManager("helloworld").WithXChaCha20Poly1305(nil), false, 24},
+		{"header", NewEncryptManager("helloworld").WithXChaCha20Poly1305(nil).WithHeader(), false, 24},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := tt.e.EncryptWithResult(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Protocol != tt.e.getProtocol() {
+				t.Fatalf("protocol = %s, want %s", res.Protocol, tt.e.getProtocol())
+			}
+			if tt.wantIV {
+				if len(res.IV) != 16 || len(res.Salt) != saltlen {
+					t.Fatal("missing iv, or salt")
+				}
+				if (res.KDF != nil) != (tt.e.kdf != nil) {
+					t.Fatal("kdf not recorded")
+				}
+				if len(res.MAC) != cfbMACSize {
+					t.Fatal("missing mac")
+				}
+				if !bytes.Contains(res.Data, res.IV) || !bytes.HasSuffix(res.Data, append(res.Salt, res.MAC...)) {
+					t.Fatal("iv, salt, or mac does not match encrypted data")
+				}
+			}
+			if len(res.Nonce) != tt.wantNonce {
+				t.Fatalf("nonce length = %d, want %d", len(res.Nonce), tt.wantNonce)
+			}
+			if tt.wantNonce > 0 && (res.Params == nil || res.Params.Nonce != hex.EncodeToString(res.Nonce)) {
+				t.Fatal("nonce does not match decryption parameters")
+			}
+			// results are not replaced by later encryptions
+			next, err := tt.e.EncryptWithResult(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Equal(next.IV, res.IV) && bytes.Equal(next.Nonce, res.Nonce) && bytes.Equal(next.Salt, res.Salt) {
+				t.Fatal("parameters reused between encryptions")
+			}
+			d := tt.e.Clone()
+			if res.Params != nil {
+				d.gcmDecryptParams = res.Params
+			}
+			decrypted, err := d.Decrypt(bytes.NewReader(res.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Rotate is used to re-encrypt r, encrypted by oldCfg, using newCfg, such as
+// when retiring a passphrase, or key, writing the result to w. r is decrypted
+// as by Decrypt, and encrypted as by Encrypt, so data keeps its format, and
+// data with a header is given a header recording the same signature of the
+// plaintext, unless newCfg signs the plaintext itself. AES256-CFB, and
+// GCM-STREAM data without a header is rotated in a single streaming pass
+// using constant memory, for which AES256-CFB requires r to be an
+// io.ReadSeeker, while other data is buffered. The decryption parameters of
+// newCfg are updated as by Encrypt.
+//
+// w may have received part of the output when an error is returned, so
+// RotateFile should be used for files
+func Rotate(r io.Reader, w io.Writer, oldCfg, newCfg *EncryptManager) error {
+	if r == nil || w == nil {
+		return errors.New("invalid content provided")
+	}
+	if oldCfg == nil || newCfg == nil {
+		return errors.New("invalid encrypt manager provided")
+	}
+	// look for a header without consuming r, which AES256-CFB seeks within
+	magic, r, err := peekReader(r, len(headerMagic))
+	if err != nil {
+		return err
+	}
+	_, seekable := r.(io.ReadSeeker)
+	if !bytes.Equal(magic, headerMagic) && rotatesStreaming(oldCfg, newCfg, seekable) {
+		return rotateStream(r, w, oldCfg, newCfg)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	h, headered := parseHeader(data)
+	plaintext, err := oldCfg.Decrypt(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer zero(plaintext)
+	var signature []byte
+	if headered {
+		signature = h.signature
+	}
+	res, err := newCfg.encryptSigned(bytes.NewReader(plaintext), newCfg.header || headered, signature)
+	if err != nil {
+		return err
+	}
+	if err := newCfg.afterEncrypt("", res.Data); err != nil {
+		return err
+	}
+	_, err = w.Write(res.Data)
+	return err
+}
+
+// ChangePassphrase is used to re-encrypt AES256-CFB data read from in under
+// newPass, writing the result to out, so the plaintext is never exposed to the
+// caller. A new salt is generated, while the key derivation function, its
+// parameters, and any header are kept. Data in the unauthenticated legacy
+// format is upgraded to the authenticated format, though as the legacy format
+// can't detect a wrong oldPass, the result should be verified before the
+// original is discarded
+func ChangePassphrase(in io.Reader, out io.Writer, oldPass, newPass string) error {
+	if in == nil || out == nil {
+		return errors.New("invalid content provided")
+	}
+	if oldPass == "" || newPass == "" {
+		return errors.New("no passphrase provided")
+	}
+	head, in, err := peekReader(in, inspectPeek)
+	if err != nil {
+		return err
+	}
+	info, err := Inspect(bytes.NewReader(head))
+	if err != nil {
+		return err
+	}
+	switch {
+	case info.Format == FormatHeader && info.Protocol != CFB:
+		return fmt.Errorf("passphrase change is not supported for %s", info.Protocol)
+	case info.Format != FormatHeader && info.Format != FormatCFB && info.Format != FormatUnknown:
+		return fmt.Errorf("passphrase change is not supported for %s data", info.Format)
+	}
+	oldCfg, newCfg := NewEncryptManager(oldPass), NewEncryptManager(newPass)
+	if !info.Authenticated {
+		oldCfg.WithLegacyCFB()
+	}
+	if info.KDF != nil {
+		newCfg.WithKDF(*info.KDF)
+	}
+	return Rotate(in, out, oldCfg, newCfg)
+}
+
+// peekReader returns up to the first n bytes of r, along with a reader of all of r,
+// being r itself when it is an io.ReadSeeker, so it remains seekable
+func peekReader(r io.Reader, n int) ([]byte, io.Reader, error) {
+	if seeker, ok := r.(io.ReadSeeker); ok {
+		head := make([]byte, n)
+		read, err := io.ReadFull(seeker, head)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return nil, nil, err
+		}
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return nil, nil, err
+		}
+		return head[:read], r, nil
+	}
+	br := bufio.NewReaderSize(r, n)
+	head, err := br.Peek(n)
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	return head, br, nil
+}
+
+// rotatesStreaming indicates whether the output of Encrypt for both managers
+// is the same as that of EncryptStream, so data can be rotated as a stream
+func rotatesStreaming(oldCfg, newCfg *EncryptManager, seekable bool) bool {
+	switch oldCfg.getProtocol() {
+	case CFB:
+		if !seekable {
+			return false
+		}
+	case GCMStream:
+	default:
+		return false
+	}
+	switch newCfg.getProtocol() {
+	case CFB, GCMStream:
+		return !newCfg.header
+	default:
+		return false
+	}
+}
+
+// rotateStream decrypts r using DecryptStream, piping the plaintext to
+// EncryptStream, so neither the plaintext, nor the ciphertext is buffered
+func rotateStream(r io.Reader, w io.Writer, oldCfg, newCfg *EncryptManager) error {
+	pr, pw := io.Pipe()
+	decrypted := make(chan error, 1)
+	go func() {
+		err := oldCfg.DecryptStream(pw, r)
+		pw.CloseWithError(err)
+		decrypted <- err
+	}()
+	err := newCfg.EncryptStream(w, pr)
+	// unblock decryption when encryption stops early
+	pr.CloseWithError(errors.New("encryption failed"))
+	decryptErr := <-decrypted
+	// failures to decrypt are read by EncryptStream from the pipe
+	if err != nil && err != decryptErr {
+		return err
+	}
+	return decryptErr
+}
+
+// RotateFile is used to re-encrypt the file at inPath, encrypted by oldCfg,
+// using newCfg as by Rotate, writing the result to outPath, which may be the
+// same as inPath. The output is written to a temporary file which is renamed
+// once complete, so a failed rotation never leaves a partial file behind
+func RotateFile(inPath, outPath string, oldCfg, newCfg *EncryptManager) error {
+	return transformFile(inPath, outPath, func(dst io.Writer, src *os.File) error {
+		return Rotate(src, dst, oldCfg, newCfg)
+	})
+}
+
+// rotatingSuffix is appended to the id of a file to store its new decryption
+// parameters while RotateDirectory replaces it
+const rotatingSuffix = ".rotating"
+
+// rotateRename replaces files rotated by RotateDirectory, replaced by tests
+var rotateRename = os.Rename
+
+// RotateDirectory is used to re-encrypt every file within the directory tree
+// at path in place, using RotateFile. Symbolic links, and other irregular
+// files are skipped. When the managers use protocols with decryption
+// parameters, the parameters of every file are loaded from, and replaced in
+// store, keyed by the slash separated path of the file relative to path, as by
+// LoadAndDecrypt, and EncryptAndStore.
+//
+// The new parameters of a file are stored under its id followed by
+// ".rotating" until the rotated file has replaced the original, so the
+// parameters of either are always stored. Rotation stops at the first
+// failure, and can be resumed by calling RotateDirectory again, which
+// completes, and skips files already decrypted by newCfg
+func RotateDirectory(path string, store ParamStore, oldCfg, newCfg *EncryptManager) error {
+	if oldCfg == nil || newCfg == nil {
+		return errors.New("invalid encrypt manager provided")
+	}
+	if store == nil && (oldCfg.hasDecryptParams() || newCfg.hasDecryptParams()) {
+		return errors.New("a param store is required to rotate protocols with decryption parameters")
+	}
+	return filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(path, file)
+		if err != nil {
+			return err
+		}
+		id := filepath.ToSlash(rel)
+		if err := rotateDirectoryFile(file, id, store, oldCfg.Clone(), newCfg.Clone()); err != nil {
+			return fmt.Errorf("%s: %v", id, err)
+		}
+		return nil
+	})
+}
+
+// rotateDirectoryFile rotates file, stored under id, unless it was rotated
+// by an earlier, interrupted call to RotateDirectory
+func rotateDirectoryFile(file, id string, store ParamStore, old, updated *EncryptManager) error {
+	var err error
+	if old.hasDecryptParams() {
+		var encrypted []byte
+		if encrypted, err = store.Get(id); err == nil {
+			old.gcmDecryptParams, err = old.loadGCMDecryptParams(encrypted)
+		}
+	}
+	rotated := filepath.Join(filepath.Dir(file), "."+filepath.Base(file)+rotatingSuffix)
+	if err == nil {
+		err = RotateFile(file, rotated, old, updated)
+	}
+	if err != nil {
+		// files which oldCfg can't rotate may have been rotated already
+		if done, doneErr := completeRotation(file, id, store, old, updated); done || doneErr != nil {
+			return doneErr
+		}
+		return err
+	}
+	if updated.hasDecryptParams() {
+		params, err := updated.RetrieveGCMDecryptionParameters()
+		if err == nil {
+			err = store.Put(id+rotatingSuffix, params)
+		}
+		if err != nil {
+			os.Remove(rotated)
+			return err
+		}
+	}
+	if err := rotateRename(rotated, file); err != nil {
+		os.Remove(rotated)
+		if updated.hasDecryptParams() {
+			store.Delete(id + rotatingSuffix)
+		}
+		return err
+	}
+	_, err = completeRotation(file, id, store, old, updated)
+	return err
+}
+
+// completeRotation indicates whether file is decrypted by updated, using the
+// parameters stored for the rotation of id, or those stored under id, in which
+// case the parameters for updated replace those of old in store
+func completeRotation(file, id string, store ParamStore, old, updated *EncryptManager) (bool, error) {
+	var params []byte
+	if updated.hasDecryptParams() {
+		var err error
+		if params, err = store.Get(id + rotatingSuffix); err == ErrParamsNotFound {
+			params, err = store.Get(id)
+		}
+		if err != nil {
+			return false, nil
+		}
+		if updated.gcmDecryptParams, err = updated.loadGCMDecryptParams(params); err != nil {
+			return false, nil
+		}
+	}
+	fh, err := os.Open(file)
+	if err != nil {
+		return false, err
+	}
+	defer fh.Close()
+	plaintext, err := updated.Decrypt(fh)
+	if err != nil {
+		return false, nil
+	}
+	zero(plaintext)
+	switch {
+	case updated.hasDecryptParams():
+		if err := store.Put(id, params); err != nil {
+			return true, err
+		}
+		return true, store.Delete(id + rotatingSuffix)
+	case old.hasDecryptParams():
+		return true, store.Delete(id)
+	}
+	return true, nil
+}
@@ -0,0 +1,337 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+func Test_Rotate(t *testing.T) {
+	data := bytes.Repeat([]byte("hello world"), 20000)
+	old := NewEncryptManager("old")
+	encrypted, err := old.Encrypt(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		r    io.Reader
+	}{
+		{"streaming", bytes.NewReader(encrypted)},
+		// readers which cannot seek are buffered
+		{"buffered", struct{ io.Reader }{bytes.NewReader(encrypted)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated := NewEncryptManager("new").WithGCMStream(nil)
+			var out bytes.Buffer
+			if err := Rotate(tt.r, &out, old, updated); err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err := updated.Decrypt(bytes.NewReader(out.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+		})
+	}
+	var out bytes.Buffer
+	if err := Rotate(bytes.NewReader(encrypted), &out, NewEncryptManager("wrong"), NewEncryptManager("new")); err == nil {
+		t.Fatal("expected error rotating with wrong passphrase")
+	}
+	if err := Rotate(bytes.NewReader(encrypted), &out, old, nil); err == nil {
+		t.Fatal("expected error rotating without encrypt manager")
+	}
+}
+
+func Test_Rotate_Header(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := NewEncryptManager("old").WithGCM(nil).WithSigningKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := old.WithSignedPlaintext().Encrypt(bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the signature is kept without the signing key
+	updated := NewEncryptManager("new").WithXChaCha20Poly1305(nil)
+	var out bytes.Buffer
+	if err := Rotate(bytes.NewReader(encrypted), &out, old, updated); err != nil {
+		t.Fatal(err)
+	}
+	before, err := Inspect(bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := Inspect(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Format != FormatHeader || after.Protocol != XChaCha20Poly1305 || after.SignerKeyID != before.SignerKeyID {
+		t.Fatalf("Inspect = %+v", after)
+	}
+	updated, err = updated.WithVerifyKeys(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := updated.Decrypt(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != "hello world" {
+		t.Fatalf("Decrypt = %s", decrypted)
+	}
+}
+
+func Test_RotateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "encrypted")
+	encrypted, err := NewEncryptManager("old").Encrypt(bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, encrypted, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := RotateFile(path, path, NewEncryptManager("wrong"), NewEncryptManager("new")); err == nil {
+		t.Fatal("expected error rotating with wrong passphrase")
+	}
+	// a failed rotation leaves the file untouched
+	if out, err := ioutil.ReadFile(path); err != nil || !bytes.Equal(out, encrypted) {
+		t.Fatal("file modified by failed rotation")
+	}
+	if err := RotateFile(path, path, NewEncryptManager("old"), NewEncryptManager("new")); err != nil {
+		t.Fatal(err)
+	}
+	fh, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	decrypted, err := NewEncryptManager("new").Decrypt(fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != "hello world" {
+		t.Fatalf("Decrypt = %s", decrypted)
+	}
+}
+
+func Test_RotateDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryParamStore()
+	ids := []string{"a", "sub/b"}
+	for _, id := range ids {
+		encrypted, err := NewEncryptManager("old").WithGCM(nil).EncryptAndStore(store, id, bytes.NewReader([]byte(id)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(id)), encrypted, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := RotateDirectory(dir, nil, NewEncryptManager("old").WithGCM(nil), NewEncryptManager("new")); err == nil {
+		t.Fatal("expected error rotating without param store")
+	}
+	updated := NewEncryptManager("new").WithXChaCha20Poly1305(nil)
+	if err := RotateDirectory(dir, store, NewEncryptManager("old").WithGCM(nil), updated); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		fh, err := os.Open(filepath.Join(dir, filepath.FromSlash(id)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := updated.LoadAndDecrypt(store, id, fh)
+		fh.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(decrypted) != id {
+			t.Fatalf("%s: LoadAndDecrypt = %s", id, decrypted)
+		}
+	}
+	// rotating to a protocol without parameters removes them from the store
+	if err := RotateDirectory(dir, store, NewEncryptManager("new").WithXChaCha20Poly1305(nil), NewEncryptManager("newer")); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := store.List(); len(stored) != 0 {
+		t.Fatalf("params left in store: %v", stored)
+	}
+	if err := RotateDirectory(dir, nil, NewEncryptManager("newer"), NewEncryptManager("newest")); err != nil {
+		t.Fatal(err)
+	}
+	// files already rotated are skipped when resuming
+	if err := RotateDirectory(dir, nil, NewEncryptManager("newer"), NewEncryptManager("newest")); err != nil {
+		t.Fatal(err)
+	}
+	if err := RotateDirectory(dir, nil, NewEncryptManager("wrong"), NewEncryptManager("other")); err == nil {
+		t.Fatal("expected error rotating with wrong passphrase")
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("temporary files left behind: %d entries", len(entries))
+	}
+}
+
+func Test_RotateDirectory_Interrupted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { rotateRename = os.Rename }()
+	store := NewMemoryParamStore()
+	ids := []string{"a", "b"}
+	for _, id := range ids {
+		encrypted, err := NewEncryptManager("old").WithGCM(nil).EncryptAndStore(store, id, bytes.NewReader([]byte(id)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, id), encrypted, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	load := func(e *EncryptManager, id string) ([]byte, error) {
+		fh, err := os.Open(filepath.Join(dir, id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fh.Close()
+		return e.LoadAndDecrypt(store, id, fh)
+	}
+	old, updated := NewEncryptManager("old").WithGCM(nil), NewEncryptManager("new").WithGCM(nil)
+	// a failed rename leaves the file, and its parameters untouched
+	rotateRename = func(string, string) error { return errors.New("rename failed") }
+	if err := RotateDirectory(dir, store, old, updated); err == nil {
+		t.Fatal("expected error from failed rename")
+	}
+	if decrypted, err := load(old, "a"); err != nil || string(decrypted) != "a" {
+		t.Fatalf("LoadAndDecrypt = %q, %v", decrypted, err)
+	}
+	if stored, _ := store.List(); len(stored) != 2 {
+		t.Fatalf("stored params = %v", stored)
+	}
+	// a crash after replacing the first file is completed when resuming
+	rotateRename = func(from, to string) error {
+		if err := os.Rename(from, to); err != nil {
+			return err
+		}
+		panic("crash")
+	}
+	func() {
+		defer func() { recover() }()
+		RotateDirectory(dir, store, old, updated)
+	}()
+	rotateRename = os.Rename
+	if err := RotateDirectory(dir, store, old, updated); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if decrypted, err := load(updated, id); err != nil || string(decrypted) != id {
+			t.Fatalf("%s: LoadAndDecrypt = %q, %v", id, decrypted, err)
+		}
+	}
+	if stored, _ := store.List(); len(stored) != 2 {
+		t.Fatalf("stored params = %v", stored)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("temporary files left behind: %d entries", len(entries))
+	}
+}
+
+func Test_ChangePassphrase(t *testing.T) {
+	data := []byte("hello world")
+	pbkdf2 := NewEncryptManager("old").WithPBKDF2Iterations(1000)
+	tests := []struct {
+		name      string
+		encrypted []byte
+		kdf       *KDFConfig
+	}{
+		{"default", mustEncrypt(t, NewEncryptManager("old"), data), nil},
+		{"pbkdf2", mustEncrypt(t, pbkdf2, data), &KDFConfig{KDF: PBKDF2, Iterations: 1000}},
+		{"header", mustEncrypt(t, NewEncryptManager("old").WithHeader(), data), nil},
+		{"legacy", legacyCFB(t, NewEncryptManager("old"), data), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			// the legacy format can't detect a wrong passphrase
+			if err := ChangePassphrase(bytes.NewReader(tt.encrypted), &out, "wrong", "new"); err == nil && tt.name != "legacy" {
+				t.Fatal("expected error changing passphrase with wrong passphrase")
+			}
+			out.Reset()
+			if err := ChangePassphrase(bytes.NewReader(tt.encrypted), &out, "old", "new"); err != nil {
+				t.Fatal(err)
+			}
+			before, err := Inspect(bytes.NewReader(tt.encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			after, err := Inspect(bytes.NewReader(out.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (after.KDF == nil) != (tt.kdf == nil) || tt.kdf != nil && *after.KDF != *tt.kdf {
+				t.Fatalf("kdf = %+v, want %+v", after.KDF, tt.kdf)
+			}
+			if after.Format != before.Format && before.Format != FormatUnknown || !after.Authenticated {
+				t.Fatalf("Inspect = %+v", after)
+			}
+			decrypted, err := NewEncryptManager("new").Decrypt(bytes.NewReader(out.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+		})
+	}
+	encrypted := mustEncrypt(t, NewEncryptManager("old").WithGCM(nil).WithHeader(), data)
+	if err := ChangePassphrase(bytes.NewReader(encrypted), ioutil.Discard, "old", "new"); err == nil {
+		t.Fatal("expected error changing passphrase of AES256-GCM data")
+	}
+	if err := ChangePassphrase(bytes.NewReader(encrypted), ioutil.Discard, "old", ""); err == nil {
+		t.Fatal("expected error changing to empty passphrase")
+	}
+}
+
+func mustEncrypt(t *testing.T, e *EncryptManager, data []byte) []byte {
+	t.Helper()
+	encrypted, err := e.Encrypt(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encrypted
+}
@@ -0,0 +1,245 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// WithRSA is used to setup, and return EncryptManager for use with RSA
+// encryption. Encryption requires the public key, and decryption the private
+// key, so either may be nil. Content of any size is encrypted using a random
+// AES256-GCM key, which is encrypted using PKCS #1 v1.5 unless WithRSAOAEP is
+// used. Content encrypted directly using the key can still be decrypted
+func (e *EncryptManager) WithRSA(public *rsa.PublicKey, private *rsa.PrivateKey) *EncryptManager {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if public == nil && private != nil {
+		public = &private.PublicKey
+	}
+	e.protocol = RSA
+	e.rsaPublic = public
+	e.rsaPrivate = rsaDecrypter(private)
+	return e
+}
+
+// WithRSADecrypter is used to setup, and return EncryptManager for use with
+// RSA encryption, using an RSA private key held outside of memory, such as by
+// a PKCS #11 token, or HSM, which performs the decryption of wrapped keys.
+// The public key of the decrypter is used for encryption
+func (e *EncryptManager) WithRSADecrypter(private crypto.Decrypter) (*EncryptManager, error) {
+	if private == nil {
+		return nil, errors.New("no rsa decrypter provided")
+	}
+	public, ok := private.Public().(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("decrypter does not hold an rsa key")
+	}
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.protocol = RSA
+	e.rsaPublic = public
+	e.rsaPrivate = private
+	return e, nil
+}
+
+// rsaDecrypter returns private as a crypto.Decrypter, or nil if it is nil
+func rsaDecrypter(private *rsa.PrivateKey) crypto.Decrypter {
+	if private == nil {
+		return nil
+	}
+	return private
+}
+
+// WithRSAKeyFromPEM is used to setup, and return EncryptManager for use with
+// RSA encryption using a PEM encoded key, as produced by openssl. Private keys
+// may be PKCS #1, or PKCS #8, and encrypted using the passphrase as supported
+// by ParsePrivateKey, allowing both encryption, and decryption. Public keys
+// may be PKIX, or PKCS #1, allowing only encryption
+func (e *EncryptManager) WithRSAKeyFromPEM(data []byte, passphrase string) (*EncryptManager, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no pem encoded rsa key found")
+	}
+	if block.Type == "PUBLIC KEY" || block.Type == "RSA PUBLIC KEY" {
+		public, err := parseRSAPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return e.WithRSA(public, nil), nil
+	}
+	private, err := ParsePrivateKey(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	rsaPrivate, ok := private.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an rsa key")
+	}
+	return e.WithRSA(nil, rsaPrivate), nil
+}
+
+// WithRSAKeyFromFile is used to setup, and return EncryptManager for use with
+// RSA encryption using the PEM encoded key stored at path, as WithRSAKeyFromPEM does
+func (e *EncryptManager) WithRSAKeyFromFile(path, passphrase string) (*EncryptManager, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return e.WithRSAKeyFromPEM(data, passphrase)
+}
+
+// WithRSAPublicKey is used to setup, and return EncryptManager for encryption
+// to the recipient RSA public key, as PEM, or DER encoded PKIX, or PKCS #1.
+// Only the public key is needed to encrypt, while decryption requires the
+// private key, using WithRSA, or WithRSAKeyFromPEM
+func (e *EncryptManager) WithRSAPublicKey(key []byte) (*EncryptManager, error) {
+	if block, _ := pem.Decode(key); block != nil {
+		if block.Type != "PUBLIC KEY" && block.Type != "RSA PUBLIC KEY" {
+			return nil, fmt.Errorf("unsupported public key type %s", block.Type)
+		}
+		key = block.Bytes
+	}
+	public, err := parseRSAPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return e.WithRSA(public, nil), nil
+}
+
+// parseRSAPublicKey parses a DER encoded PKIX, or PKCS #1 RSA public key
+func parseRSAPublicKey(der []byte) (*rsa.PublicKey, error) {
+	if public, err := x509.ParsePKCS1PublicKey(der); err == nil {
+		return public, nil
+	}
+	public, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, errors.New("invalid rsa public key")
+	}
+	rsaPublic, ok := public.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not an rsa key")
+	}
+	return rsaPublic, nil
+}
+
+// WithRSAOAEP is used to encrypt, and decrypt using RSA-OAEP with the given
+// hash, such as crypto.SHA256, or crypto.SHA512, rather than PKCS #1 v1.5,
+// which is discouraged for new designs. Content encrypted with PKCS #1 v1.5
+// can not be decrypted when it is set, or vice versa
+func (e *EncryptManager) WithRSAOAEP(hash crypto.Hash) *EncryptManager {
+	e.rsaOAEPHash = hash
+	return e
+}
+
+// encryptRSA encrypts given io.Reader using a random AES256-GCM key, wrapped
+// using the configured RSA public key. The output is in the format of
+// wrapped key || nonce || ciphertext, where the wrapped key is authenticated
+func (e *EncryptManager) encryptRSA(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	if e.rsaPublic == nil {
+		return nil, errors.New("no rsa public key provided")
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	cipherKey := make([]byte, keylen)
+	if _, err := io.ReadFull(e.randomness(), cipherKey); err != nil {
+		return nil, err
+	}
+	wrapped, err := e.rsaEncrypt(cipherKey)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(cipherKey)
+	if err != nil {
+		return nil, err
+	}
+	aesGCM, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aesGCM.NonceSize())
+	if _, err := io.ReadFull(e.randomness(), nonce); err != nil {
+		return nil, err
+	}
+	if err := e.recordNonce(cipherKey, nonce); err != nil {
+		return nil, err
+	}
+	out := append(wrapped, nonce...)
+	return aesGCM.Seal(out, nonce, data, wrapped), nil
+}
+
+// decryptRSA decrypts given io.Reader using the configured RSA private key.
+// Content the size of the key was encrypted directly using the key, rather
+// than using a wrapped AES256-GCM key
+func (e *EncryptManager) decryptRSA(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	if e.rsaPrivate == nil {
+		return nil, errors.New("no rsa private key provided")
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	public, ok := e.rsaPrivate.Public().(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("rsa private key is not an rsa key")
+	}
+	size := public.Size()
+	if len(data) == size {
+		return e.rsaDecrypt(data)
+	}
+	if len(data) < size+12+16 {
+		return nil, errors.New("invalid content provided")
+	}
+	wrapped := data[:size]
+	cipherKey, err := e.rsaDecrypt(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(cipherKey)
+	if err != nil {
+		return nil, err
+	}
+	aesGCM, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := data[size : size+aesGCM.NonceSize()]
+	return aesGCM.Open(nil, nonce, data[size+aesGCM.NonceSize():], wrapped)
+}
+
+// rsaEncrypt encrypts data using the configured RSA public key, and padding
+func (e *EncryptManager) rsaEncrypt(data []byte) ([]byte, error) {
+	if e.rsaOAEPHash == 0 {
+		return rsa.EncryptPKCS1v15(e.randomness(), e.rsaPublic, data)
+	}
+	if !e.rsaOAEPHash.Available() {
+		return nil, errors.New("rsa-oaep hash is not available")
+	}
+	return rsa.EncryptOAEP(e.rsaOAEPHash.New(), e.randomness(), e.rsaPublic, data, nil)
+}
+
+// rsaDecrypt decrypts data using the configured RSA private key, and padding
+func (e *EncryptManager) rsaDecrypt(data []byte) ([]byte, error) {
+	if e.rsaOAEPHash == 0 {
+		return e.rsaPrivate.Decrypt(e.randomness(), data, &rsa.PKCS1v15DecryptOptions{})
+	}
+	if !e.rsaOAEPHash.Available() {
+		return nil, errors.New("rsa-oaep hash is not available")
+	}
+	return e.rsaPrivate.Decrypt(e.randomness(), data, &rsa.OAEPOptions{Hash: e.rsaOAEPHash})
+}
@@ -0,0 +1,300 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func Test_EncryptManager_RSA(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("hello world")
+	tests := []struct {
+		name string
+		hash crypto.Hash
+	}{
+		{"pkcs1v15", 0},
+		{"oaep-sha256", crypto.SHA256},
+		{"oaep-sha512", crypto.SHA512},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted, err := NewEncryptManager("").WithRSA(&private.PublicKey, nil).WithRSAOAEP(tt.hash).Encrypt(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err := NewEncryptManager("").WithRSA(nil, private).WithRSAOAEP(tt.hash).Decrypt(bytes.NewReader(encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+			if err := NewEncryptManager("").WithRSA(&private.PublicKey, nil).WithRSAOAEP(tt.hash).Health(); err != nil {
+				t.Fatal(err)
+			}
+			if err := NewEncryptManager("").WithRSA(nil, private).WithRSAOAEP(tt.hash).Health(); err != nil {
+				t.Fatal(err)
+			}
+			// the padding must match for decryption to succeed
+			other := crypto.SHA256
+			if tt.hash == crypto.SHA256 {
+				other = 0
+			}
+			if _, err := NewEncryptManager("").WithRSA(nil, private).WithRSAOAEP(other).Decrypt(bytes.NewReader(encrypted)); err == nil {
+				t.Fatal("decrypted using the wrong padding")
+			}
+		})
+	}
+}
+
+func Test_EncryptManager_RSA_Errors(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewEncryptManager("").WithRSA(nil, nil).Encrypt(bytes.NewReader([]byte("hello"))); err == nil {
+		t.Fatal("encrypted without a public key")
+	}
+	if _, err := NewEncryptManager("").WithRSA(&private.PublicKey, nil).Decrypt(bytes.NewReader([]byte("hello"))); err == nil {
+		t.Fatal("decrypted without a private key")
+	}
+	if _, err := NewEncryptManager("").WithRSA(nil, private).Decrypt(bytes.NewReader(make([]byte, private.Size()+1))); err == nil {
+		t.Fatal("decrypted truncated content")
+	}
+	if _, err := NewEncryptManager("").WithRSA(&private.PublicKey, nil).WithRSAOAEP(crypto.MD4).Encrypt(bytes.NewReader([]byte("hello"))); err == nil {
+		t.Fatal("encrypted using an unavailable hash")
+	}
+}
+
+func Test_EncryptManager_RSA_Hybrid(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// content larger than the key is encrypted using a wrapped key
+	data := bytes.Repeat([]byte("hello world"), 100000)
+	e := NewEncryptManager("").WithRSA(nil, private).WithRSAOAEP(crypto.SHA256)
+	encrypted, err := e.Encrypt(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := e.Decrypt(bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Fatal("decrypted data does not match original")
+	}
+	// the wrapped key is authenticated
+	tampered := append([]byte{}, encrypted...)
+	tampered[0] ^= 0xff
+	if _, err := e.Decrypt(bytes.NewReader(tampered)); err == nil {
+		t.Fatal("decrypted data with tampered key")
+	}
+	tampered = append([]byte{}, encrypted...)
+	tampered[len(tampered)-1] ^= 0xff
+	if _, err := e.Decrypt(bytes.NewReader(tampered)); err == nil {
+		t.Fatal("decrypted tampered data")
+	}
+	// content encrypted directly using the key can still be decrypted
+	direct, err := rsa.EncryptOAEP(crypto.SHA256.New(), rand.Reader, &private.PublicKey, []byte("hello world"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted, err = e.Decrypt(bytes.NewReader(direct)); err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != "hello world" {
+		t.Fatal("decrypted data does not match original")
+	}
+}
+
+func Test_EncryptManager_WithRSAKeyFromPEM(t *testing.T) {
+	data := []byte("hello world")
+	// keys generated using openssl genpkey, pkcs8, and rsa
+	encrypted, err := NewEncryptManager("").WithRSAKeyFromFile("testdata/keys/public.pem", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := encrypted.Encrypt(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		file       string
+		passphrase string
+		wantErr    bool
+	}{
+		{"pkcs1", "pkcs1.pem", "", false},
+		{"pkcs1-aes256", "pkcs1-aes256.pem", "helloworld", false},
+		{"pkcs8", "pkcs8.pem", "", false},
+		{"pkcs8-aes256", "pkcs8-aes256.pem", "helloworld", false},
+		{"pkcs8-aes128-sha1", "pkcs8-aes128-sha1.pem", "helloworld", false},
+		{"pkcs8-des3", "pkcs8-des3.pem", "helloworld", false},
+		{"wrong-passphrase", "pkcs8-aes256.pem", "wrong", true},
+		{"no-passphrase", "pkcs8-aes256.pem", "", true},
+		{"legacy-no-passphrase", "pkcs1-aes256.pem", "", true},
+		{"missing", "missing.pem", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := NewEncryptManager("").WithRSAKeyFromFile(filepath.Join("testdata/keys", tt.file), tt.passphrase)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WithRSAKeyFromFile() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			decrypted, err := e.Decrypt(bytes.NewReader(ciphertext))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+		})
+	}
+
+	// keys which are not rsa are rejected
+	edPublic, edPrivate, err := GenerateKeyPair(Ed25519Key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range [][]byte{edPublic, edPrivate, []byte("not pem")} {
+		if _, err := NewEncryptManager("").WithRSAKeyFromPEM(key, ""); err == nil {
+			t.Fatal("expected error loading a key which is not rsa")
+		}
+	}
+}
+
+func Test_EncryptManager_WithRSAPublicKey(t *testing.T) {
+	data := []byte("hello world")
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkix, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs1 := x509.MarshalPKCS1PublicKey(&private.PublicKey)
+	tests := []struct {
+		name    string
+		key     []byte
+		wantErr bool
+	}{
+		{"pem-pkix", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkix}), false},
+		{"pem-pkcs1", pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: pkcs1}), false},
+		{"der-pkix", pkix, false},
+		{"der-pkcs1", pkcs1, false},
+		{"pem-private", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(private)}), true},
+		{"invalid", []byte("not a key"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := NewEncryptManager("").WithRSAPublicKey(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WithRSAPublicKey() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			encrypted, err := e.Encrypt(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the private key is still required for decryption
+			if _, err := e.Decrypt(bytes.NewReader(encrypted)); err == nil {
+				t.Fatal("decrypted using only the public key")
+			}
+			decrypted, err := NewEncryptManager("").WithRSA(nil, private).Decrypt(bytes.NewReader(encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+		})
+	}
+}
+
+// hsmDecrypter is a crypto.Decrypter standing in for a key held by a PKCS #11 token
+type hsmDecrypter struct {
+	key    *rsa.PrivateKey
+	public crypto.PublicKey
+	calls  int
+}
+
+func (h *hsmDecrypter) Public() crypto.PublicKey {
+	if h.public != nil {
+		return h.public
+	}
+	return &h.key.PublicKey
+}
+
+func (h *hsmDecrypter) Decrypt(rand io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	h.calls++
+	return h.key.Decrypt(rand, msg, opts)
+}
+
+func Test_EncryptManager_WithRSADecrypter(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("hello world")
+	for _, hash := range []crypto.Hash{0, crypto.SHA256} {
+		hsm := &hsmDecrypter{key: private}
+		e, err := NewEncryptManager("").WithRSADecrypter(hsm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.WithRSAOAEP(hash)
+		// encryption uses the public key of the decrypter
+		encrypted, err := e.Encrypt(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := e.Decrypt(bytes.NewReader(encrypted))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Fatal("decrypted data does not match original")
+		}
+		if hsm.calls != 1 {
+			t.Fatalf("decrypter calls = %d, want 1", hsm.calls)
+		}
+		// data encrypted using the in-memory key decrypts using the token
+		encrypted, err = NewEncryptManager("").WithRSA(&private.PublicKey, nil).WithRSAOAEP(hash).Encrypt(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := e.Decrypt(bytes.NewReader(encrypted)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := NewEncryptManager("").WithRSADecrypter(nil); err == nil {
+		t.Fatal("expected error using nil decrypter")
+	}
+	public, _, err := GenerateEd25519KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewEncryptManager("").WithRSADecrypter(&hsmDecrypter{key: private, public: public}); err == nil {
+		t.Fatal("expected error using non-rsa decrypter")
+	}
+}
@@ -0,0 +1,100 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// SearchIndex maps HMAC tokens of selected metadata fields, such as filenames
+// and tags, to the references of encrypted objects. This allows exact-match
+// lookups without decrypting the corpus, while the stored index reveals only
+// which objects share a field value, never the values themselves
+type SearchIndex struct {
+	key     []byte
+	mux     sync.RWMutex
+	objects map[string][]string
+}
+
+// NewSearchIndex is used to create an empty index using the given 32 byte key
+func NewSearchIndex(key []byte) (*SearchIndex, error) {
+	if len(key) != keylen {
+		return nil, fmt.Errorf("invalid search index key length %d, must be %d", len(key), keylen)
+	}
+	return &SearchIndex{key: key, objects: make(map[string][]string)}, nil
+}
+
+// Token returns the token indexed for value of the given metadata field
+func (s *SearchIndex) Token(field, value string) string {
+	mac := hmac.New(sha256.New, s.key)
+	// separate field and value so that distinct pairs never collide
+	mac.Write([]byte(field))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Add indexes the metadata of the object ref, replacing any previously
+// indexed metadata. Fields may hold several values, ie multiple tags
+func (s *SearchIndex) Add(ref string, metadata map[string][]string) {
+	var tokens []string
+	for field, values := range metadata {
+		for _, value := range values {
+			tokens = append(tokens, s.Token(field, value))
+		}
+	}
+	sort.Strings(tokens)
+	s.mux.Lock()
+	s.objects[ref] = tokens
+	s.mux.Unlock()
+}
+
+// Remove removes the object ref from the index
+func (s *SearchIndex) Remove(ref string) {
+	s.mux.Lock()
+	delete(s.objects, ref)
+	s.mux.Unlock()
+}
+
+// Lookup returns the sorted references of all objects whose field exactly matches value
+func (s *SearchIndex) Lookup(field, value string) []string {
+	token := s.Token(field, value)
+	var refs []string
+	s.mux.RLock()
+	for ref, tokens := range s.objects {
+		if i := sort.SearchStrings(tokens, token); i < len(tokens) && tokens[i] == token {
+			refs = append(refs, ref)
+		}
+	}
+	s.mux.RUnlock()
+	sort.Strings(refs)
+	return refs
+}
+
+// Export is used to serialize the index for storage alongside the encrypted objects.
+// The key is never included
+func (s *SearchIndex) Export() ([]byte, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return json.Marshal(s.objects)
+}
+
+// Restore is used to load an index previously serialized with Export,
+// replacing the contents of the index. The same key must be used
+func (s *SearchIndex) Restore(data []byte) error {
+	objects := make(map[string][]string)
+	if err := json.Unmarshal(data, &objects); err != nil {
+		return err
+	}
+	for _, tokens := range objects {
+		sort.Strings(tokens)
+	}
+	s.mux.Lock()
+	s.objects = objects
+	s.mux.Unlock()
+	return nil
+}
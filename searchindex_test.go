@@ -0,0 +1,72 @@
+package crypto
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func Test_SearchIndex(t *testing.T) {
+	if _, err := NewSearchIndex([]byte("short")); err == nil {
+		t.Fatal("expected error using invalid key")
+	}
+	key := bytes.Repeat([]byte{1}, keylen)
+	index, err := NewSearchIndex(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Add("QmA", map[string][]string{"filename": {"report.pdf"}, "tags": {"finance", "2019"}})
+	index.Add("QmB", map[string][]string{"filename": {"photo.jpg"}, "tags": {"2019"}})
+	index.Add("QmC", map[string][]string{"filename": {"2019"}})
+
+	exported, err := index.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, plaintext := range []string{"report.pdf", "finance", "filename"} {
+		if bytes.Contains(exported, []byte(plaintext)) {
+			t.Fatalf("exported index contains %q", plaintext)
+		}
+	}
+	restored, err := NewSearchIndex(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Restore(exported); err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := NewSearchIndex(bytes.Repeat([]byte{2}, keylen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := otherKey.Restore(exported); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		index *SearchIndex
+		field string
+		value string
+		want  []string
+	}{
+		{"filename", index, "filename", "report.pdf", []string{"QmA"}},
+		{"tag", index, "tags", "2019", []string{"QmA", "QmB"}},
+		{"field-separation", index, "filename", "2019", []string{"QmC"}},
+		{"no-match", index, "tags", "fin", nil},
+		{"restored", restored, "tags", "finance", []string{"QmA"}},
+		{"wrong-key", otherKey, "tags", "finance", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.index.Lookup(tt.field, tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Lookup = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	index.Remove("QmA")
+	if got := index.Lookup("tags", "2019"); !reflect.DeepEqual(got, []string{"QmB"}) {
+		t.Fatalf("Lookup after Remove = %v", got)
+	}
+}
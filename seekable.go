@@ -0,0 +1,194 @@
+package crypto
+
+import (
+	"errors"
+	"io"
+)
+
+// DecryptSeeker is used to decrypt src, encrypted in the segmented format of
+// GCM-STREAM, or by EncryptStream using an AEAD protocol, returning an
+// io.ReadSeeker over the plaintext. Only the segments covering the data read
+// are decrypted, allowing random access to large objects, ie to serve HTTP
+// range requests, without decrypting them in full. As with DecryptStream,
+// content validators require the complete plaintext, and are not supported.
+// Usage is reported once, for the size of the plaintext
+func (e *EncryptManager) DecryptSeeker(src io.ReadSeeker) (io.ReadSeeker, error) {
+	out, err := e.decryptSeeker(src)
+	if err != nil {
+		return nil, e.decryptError(err)
+	}
+	return out, nil
+}
+
+// decryptSeeker implements DecryptSeeker, returning the cause of failures
+func (e *EncryptManager) decryptSeeker(src io.ReadSeeker) (io.ReadSeeker, error) {
+	if src == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	if err := e.checkApprovals(); err != nil {
+		return nil, err
+	}
+	if len(e.validators) > 0 {
+		return nil, errStreamValidators
+	}
+	key, prefix, err := e.decryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	out, err := e.newSegmentReader(src, key, prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	e.reportUsage(OperationDecrypt, out.size)
+	return out, nil
+}
+
+// newSegmentReader returns a segmentReader over src, using the data key, and
+// nonce prefix, or the keys of the segments in chunks when set
+func (e *EncryptManager) newSegmentReader(src io.ReadSeeker, key, prefix []byte, chunks *ChunkKeyRange) (*segmentReader, error) {
+	size, err := src.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	flags, segment, header, err := readSegmentHeader(src)
+	if err != nil {
+		return nil, err
+	}
+	id := flags &^ segmentKeysFlag
+	if err := e.checkCipher(id); err != nil {
+		return nil, err
+	}
+	if chunks != nil {
+		if flags&segmentKeysFlag == 0 {
+			return nil, errors.New("stream segments are not sealed using segment keys")
+		}
+		if len(chunks.Keys) == 0 {
+			return nil, errors.New("no segment keys provided")
+		}
+		key, prefix = chunks.Keys[0], chunks.ObjectID
+	}
+	aead, err := newSegmentAEAD(id, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(prefix) != aead.NonceSize()-5 {
+		return nil, errors.New("invalid stream nonce prefix")
+	}
+	aeads := sameSegmentAEAD(aead)
+	switch {
+	case chunks != nil:
+		aeads = rangeSegmentAEADs(id, chunks.Key)
+	case flags&segmentKeysFlag != 0:
+		if aeads, err = keyedSegmentAEADs(id, key, prefix); err != nil {
+			return nil, err
+		}
+	}
+	// every segment is full sized, except for the final segment which may
+	// be shorter, and is empty only when the plaintext is empty
+	sealedSize := int64(segment + aead.Overhead())
+	body := size - int64(header)
+	segments := body / sealedSize
+	if rem := body % sealedSize; rem != 0 {
+		if rem < int64(aead.Overhead()) {
+			return nil, errors.New("invalid content provided")
+		}
+		segments++
+	}
+	if segments == 0 || segments > int64(^uint32(0))+1 {
+		return nil, errors.New("invalid content provided")
+	}
+	return &segmentReader{
+		src:      src,
+		aeads:    aeads,
+		overhead: aead.Overhead(),
+		prefix:   prefix,
+		aad:      e.additionalData(),
+		bound:    e.objectBound,
+		header:   int64(header),
+		segment:  int64(segment),
+		segments: segments,
+		size:     body - segments*int64(aead.Overhead()),
+		cached:   -1,
+		fail:     e.decryptError,
+	}, nil
+}
+
+// segmentReader provides random access to the plaintext of a segmented stream
+type segmentReader struct {
+	src      io.ReadSeeker
+	aeads    segmentAEADs
+	overhead int
+	prefix   []byte
+	aad      []byte
+	bound    bool
+	header   int64
+	segment  int64
+	segments int64
+	size     int64
+	offset   int64
+	// the most recently decrypted segment
+	cached int64
+	buf    []byte
+	// fail sets the detail of authentication failures
+	fail func(error) error
+}
+
+func (s *segmentReader) Read(p []byte) (int, error) {
+	if s.offset >= s.size {
+		return 0, io.EOF
+	}
+	index := s.offset / s.segment
+	if err := s.load(index); err != nil {
+		return 0, err
+	}
+	n := copy(p, s.buf[s.offset-index*s.segment:])
+	s.offset += int64(n)
+	return n, nil
+}
+
+func (s *segmentReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.offset
+	case io.SeekEnd:
+		offset += s.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	s.offset = offset
+	return offset, nil
+}
+
+// load decrypts the segment at index, unless it is already cached
+func (s *segmentReader) load(index int64) error {
+	if index == s.cached {
+		return nil
+	}
+	sealedSize := s.segment + int64(s.overhead)
+	if _, err := s.src.Seek(s.header+index*sealedSize, io.SeekStart); err != nil {
+		return err
+	}
+	sealed := make([]byte, sealedSize)
+	n, err := io.ReadFull(s.src, sealed)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	last := index == s.segments-1
+	aead, err := s.aeads(uint64(index))
+	if err != nil {
+		return err
+	}
+	opened, err := openAEAD(aead, sealed[:0], segmentNonce(s.prefix, uint32(index), last), sealed[:n], s.aad, s.bound)
+	if err != nil {
+		return s.fail(err)
+	}
+	s.cached, s.buf = index, opened
+	return nil
+}
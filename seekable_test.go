@@ -0,0 +1,87 @@
+package crypto
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func Test_EncryptManager_DecryptSeeker(t *testing.T) {
+	readme, err := ioutil.ReadFile("README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{0, 100, segmentSize, 2*segmentSize + 7} {
+		data := bytes.Repeat(readme, size/len(readme)+1)[:size]
+		e := NewEncryptManager("helloworld").WithGCMStream(nil)
+		encrypted, err := e.Encrypt(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		seeker, err := e.DecryptSeeker(bytes.NewReader(encrypted))
+		if err != nil {
+			t.Fatal(err)
+		}
+		all, err := ioutil.ReadAll(seeker)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(all, data) {
+			t.Fatalf("size %d: decrypted data does not match original", size)
+		}
+		end, err := seeker.Seek(0, io.SeekEnd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if end != int64(size) {
+			t.Fatalf("size = %d, want %d", end, size)
+		}
+		// read ranges spanning segment boundaries
+		for _, r := range [][2]int{{0, 10}, {segmentSize - 5, segmentSize + 5}, {size - 3, size}} {
+			start, stop := r[0], r[1]
+			if start < 0 || stop > size || start > stop {
+				continue
+			}
+			if _, err := seeker.Seek(int64(start), io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			part := make([]byte, stop-start)
+			if _, err := io.ReadFull(seeker, part); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(part, data[start:stop]) {
+				t.Fatalf("size %d: range %d-%d does not match", size, start, stop)
+			}
+		}
+	}
+
+	e := NewEncryptManager("helloworld").WithGCMStream(nil)
+	encrypted, err := e.Encrypt(bytes.NewReader(bytes.Repeat([]byte("a"), 2*segmentSize)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// dropping the final segment leaves a full segment which is not marked as final
+	truncated, err := e.DecryptSeeker(bytes.NewReader(encrypted[:1+segmentSize+16]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(truncated); err == nil {
+		t.Fatal("expected error reading truncated stream")
+	}
+	if _, err := e.DecryptSeeker(bytes.NewReader(encrypted[:5])); err == nil {
+		t.Fatal("expected error opening invalid stream")
+	}
+	// validators require the complete plaintext
+	validated := e.Clone().WithContentValidators(MaxContentSize(1024))
+	if _, err := validated.DecryptSeeker(bytes.NewReader(encrypted)); err != errStreamValidators {
+		t.Fatalf("DecryptSeeker err = %v, want %v", err, errStreamValidators)
+	}
+	counter := NewUsageCounter()
+	if _, err := e.Clone().WithUsageReporter("tenant", counter).DecryptSeeker(bytes.NewReader(encrypted)); err != nil {
+		t.Fatal(err)
+	}
+	if usage := counter.Usage("tenant"); usage.DecryptOperations != 1 || usage.DecryptedBytes != 2*segmentSize {
+		t.Fatalf("Usage = %+v", usage)
+	}
+}
@@ -0,0 +1,223 @@
+// Package shamir implements Shamir's secret sharing over GF(2^8), splitting
+// a secret, such as the cipher key of encrypted data, into shares of which
+// any threshold reconstruct it, while fewer reveal nothing about it. This
+// enables key escrow, and recovery without any single person holding the key.
+// Shares encode as text, so they can be stored alongside the ciphertext, or
+// handed to their holders.
+package shamir
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// shareVersion is the version of the binary encoding of shares
+const shareVersion = 1
+
+// shareHeaderSize is the size of the version, id, threshold, and index
+const shareHeaderSize = 7
+
+// ErrMismatchedShares is returned when shares were not split from the same secret
+var ErrMismatchedShares = errors.New("shares were not split from the same secret")
+
+// Share is one share of a secret. Shares carry no integrity protection, so
+// secrets should be verifiable once reconstructed, such as cipher keys
+// authenticating the data they encrypt
+type Share struct {
+	// ID is random, and shared by all shares of a secret, so shares of
+	// different secrets are not combined
+	ID uint32
+	// Threshold is the number of shares needed to reconstruct the secret
+	Threshold byte
+	// Index is the point at which the share was evaluated, from 1 to 255
+	Index byte
+	Data  []byte
+}
+
+// Split is used to split secret into n shares, of which threshold are needed
+// to reconstruct it using Combine. The threshold must be at least 2, and at
+// most n, which may be up to 255
+func Split(secret []byte, n, threshold int) ([]Share, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("no secret provided")
+	}
+	if threshold < 2 || threshold > n || n > 255 {
+		return nil, errors.New("threshold must be at least 2, and at most the number of shares, of which there may be 255")
+	}
+	var id [4]byte
+	if _, err := io.ReadFull(rand.Reader, id[:]); err != nil {
+		return nil, err
+	}
+	shares := make([]Share, n)
+	for i := range shares {
+		shares[i] = Share{
+			ID:        binary.BigEndian.Uint32(id[:]),
+			Threshold: byte(threshold),
+			Index:     byte(i + 1),
+			Data:      make([]byte, len(secret)),
+		}
+	}
+	coefficients := make([]byte, threshold)
+	defer zero(coefficients)
+	for b := range secret {
+		// the constant term of every polynomial is a byte of the secret
+		coefficients[0] = secret[b]
+		if _, err := io.ReadFull(rand.Reader, coefficients[1:]); err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			shares[i].Data[b] = eval(coefficients, shares[i].Index)
+		}
+	}
+	return shares, nil
+}
+
+// Combine is used to reconstruct a secret from at least the threshold number
+// of its shares, returning ErrMismatchedShares for shares of different secrets
+func Combine(shares ...Share) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares provided")
+	}
+	first := shares[0]
+	if first.Threshold < 2 {
+		return nil, errors.New("invalid share threshold")
+	}
+	if len(shares) < int(first.Threshold) {
+		return nil, fmt.Errorf("%d shares are required, %d provided", first.Threshold, len(shares))
+	}
+	seen := make(map[byte]bool)
+	for _, share := range shares {
+		if share.ID != first.ID || share.Threshold != first.Threshold || len(share.Data) != len(first.Data) {
+			return nil, ErrMismatchedShares
+		}
+		if share.Index == 0 || seen[share.Index] {
+			return nil, errors.New("shares must have distinct, non-zero indexes")
+		}
+		seen[share.Index] = true
+	}
+	secret := make([]byte, len(first.Data))
+	for b := range secret {
+		secret[b] = interpolate(shares, b)
+	}
+	return secret, nil
+}
+
+// MarshalBinary encodes the share in the format of
+// version(1) || id(4) || threshold(1) || index(1) || data
+func (s Share) MarshalBinary() ([]byte, error) {
+	out := make([]byte, shareHeaderSize, shareHeaderSize+len(s.Data))
+	out[0] = shareVersion
+	binary.BigEndian.PutUint32(out[1:], s.ID)
+	out[5] = s.Threshold
+	out[6] = s.Index
+	return append(out, s.Data...), nil
+}
+
+// UnmarshalBinary decodes a share encoded using MarshalBinary
+func (s *Share) UnmarshalBinary(data []byte) error {
+	if len(data) <= shareHeaderSize {
+		return errors.New("invalid share")
+	}
+	if data[0] != shareVersion {
+		return fmt.Errorf("unsupported share version %d", data[0])
+	}
+	*s = Share{
+		ID:        binary.BigEndian.Uint32(data[1:]),
+		Threshold: data[5],
+		Index:     data[6],
+		Data:      append([]byte{}, data[shareHeaderSize:]...),
+	}
+	return nil
+}
+
+// MarshalText encodes the share as unpadded base64url of its binary encoding,
+// so shares are encoded as strings within JSON
+func (s Share) MarshalText() ([]byte, error) {
+	data, err := s.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, base64.RawURLEncoding.EncodedLen(len(data)))
+	base64.RawURLEncoding.Encode(out, data)
+	return out, nil
+}
+
+// UnmarshalText decodes a share encoded using MarshalText
+func (s *Share) UnmarshalText(text []byte) error {
+	data := make([]byte, base64.RawURLEncoding.DecodedLen(len(text)))
+	n, err := base64.RawURLEncoding.Decode(data, text)
+	if err != nil {
+		return err
+	}
+	return s.UnmarshalBinary(data[:n])
+}
+
+// String returns the text encoding of the share
+func (s Share) String() string {
+	text, _ := s.MarshalText()
+	return string(text)
+}
+
+// Parse is used to decode a share from its text encoding
+func Parse(text string) (Share, error) {
+	var s Share
+	err := s.UnmarshalText([]byte(text))
+	return s, err
+}
+
+// eval evaluates the polynomial with the given coefficients at x
+func eval(coefficients []byte, x byte) byte {
+	var y byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		y = mul(y, x) ^ coefficients[i]
+	}
+	return y
+}
+
+// interpolate returns the value at zero of the polynomial through byte b of the shares
+func interpolate(shares []Share, b int) byte {
+	var y byte
+	for i, si := range shares {
+		basis := byte(1)
+		for j, sj := range shares {
+			if i != j {
+				basis = mul(basis, mul(sj.Index, inv(sj.Index^si.Index)))
+			}
+		}
+		y ^= mul(si.Data[b], basis)
+	}
+	return y
+}
+
+// mul multiplies a, and b in GF(2^8) using the AES polynomial, without
+// branching on secret data
+func mul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= a & -(b & 1)
+		a = a<<1 ^ 0x1b&-(a>>7)
+		b >>= 1
+	}
+	return p
+}
+
+// inv returns the multiplicative inverse of a in GF(2^8), being a^254
+func inv(a byte) byte {
+	out := byte(1)
+	for i := 0; i < 7; i++ {
+		a = mul(a, a)
+		out = mul(out, a)
+	}
+	return out
+}
+
+// zero overwrites secret material held in b
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
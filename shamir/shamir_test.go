@@ -0,0 +1,93 @@
+package shamir
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func Test_Shamir(t *testing.T) {
+	secret := []byte("the cipher key of encrypted data")
+	shares, err := Split(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		shares  []Share
+		wantErr bool
+	}{
+		{"threshold", shares[:3], false},
+		{"other-threshold", []Share{shares[4], shares[1], shares[3]}, false},
+		{"all", shares, false},
+		{"below-threshold", shares[:2], true},
+		{"duplicate", []Share{shares[0], shares[0], shares[1]}, true},
+		{"none", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			combined, err := Combine(tt.shares...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Combine err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !bytes.Equal(combined, secret) {
+				t.Fatalf("Combine = %s", combined)
+			}
+		})
+	}
+	other, err := Split(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Combine(shares[0], shares[1], other[2]); err != ErrMismatchedShares {
+		t.Fatalf("Combine err = %v, want ErrMismatchedShares", err)
+	}
+	for _, params := range [][2]int{{5, 1}, {2, 3}, {256, 2}} {
+		if _, err := Split(secret, params[0], params[1]); err == nil {
+			t.Fatalf("expected error splitting into %d shares with threshold %d", params[0], params[1])
+		}
+	}
+	if _, err := Split(nil, 5, 3); err == nil {
+		t.Fatal("expected error splitting empty secret")
+	}
+}
+
+func Test_Share_Encoding(t *testing.T) {
+	shares, err := Split([]byte("hello world"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Parse(shares[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// shares are strings within JSON
+	data, err := json.Marshal(shares[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []Share
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	combined, err := Combine(parsed, decoded[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(combined) != "hello world" {
+		t.Fatalf("Combine = %s", combined)
+	}
+	for _, text := range []string{"", "AQ", "AgAAAAACAWE", "!"} {
+		if _, err := Parse(text); err == nil {
+			t.Fatalf("expected error parsing %q", text)
+		}
+	}
+}
+
+func Test_inv(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if mul(byte(a), inv(byte(a))) != 1 {
+			t.Fatalf("inv(%d) is not the inverse", a)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// shareLinkVersion is the version of the share link token format
+	shareLinkVersion byte = 1
+	// shareProtocolOnce identifies objects encrypted using SealOnce
+	shareProtocolOnce byte = 1
+	// shareFlagProtected indicates the key is wrapped under a passphrase
+	shareFlagProtected byte = 1
+	// shareFlagKDF indicates the wrapped key is prefixed by the header of the
+	// key derivation function used, otherwise the legacy function was used
+	shareFlagKDF byte = 2
+)
+
+// ShareLink is a compact, URL-safe token granting "anyone with the link" access
+// to an object encrypted using SealOnce. The token encodes the protocol, the
+// key (optionally wrapped under a passphrase), and a reference to the object
+// such as an IPFS CID, or URL. The token format is:
+//
+//	version || protocol || flags || key length || key || reference
+//
+// where a key wrapped under a passphrase is kdf header || salt || wrapped key
+type ShareLink struct {
+	// Ref is the reference to the encrypted object
+	Ref string
+	// Protected indicates a passphrase is required to open the object
+	Protected bool
+
+	protocol byte
+	key      []byte
+	kdf      bool
+}
+
+// NewShareLink is used to create a share link for the object ref, encrypted
+// using SealOnce, and the token it returned
+func NewShareLink(ref, token string) (*ShareLink, error) {
+	if ref == "" {
+		return nil, errors.New("no object reference provided")
+	}
+	key, err := decodeOnceToken(token)
+	if err != nil {
+		return nil, err
+	}
+	return &ShareLink{Ref: ref, protocol: shareProtocolOnce, key: key}, nil
+}
+
+// Protect is used to wrap the key of the link under passphrase, so that
+// both the link and passphrase are required to open the object. The key is
+// derived from the passphrase using scrypt with the default parameters
+func (s *ShareLink) Protect(passphrase string) error {
+	return s.ProtectWithKDF(passphrase, DefaultKDFConfig(Scrypt))
+}
+
+// ProtectWithKDF is used to wrap the key of the link under passphrase, using
+// a key derived by kdf, which is recorded in the link
+func (s *ShareLink) ProtectWithKDF(passphrase string, kdf KDFConfig) error {
+	if s.Protected {
+		return errors.New("share link is already protected")
+	}
+	if err := kdf.validate(); err != nil {
+		return err
+	}
+	header, err := kdf.header()
+	if err != nil {
+		return err
+	}
+	e := NewEncryptManager(passphrase)
+	salt := make([]byte, kdf.saltLength())
+	if _, err := io.ReadFull(e.randomness(), salt); err != nil {
+		return err
+	}
+	key, err := kdf.deriveKey(e.passphrase, salt)
+	if err != nil {
+		return err
+	}
+	wrapped, err := WrapKey(key, s.key)
+	if err != nil {
+		return err
+	}
+	protected := append(append(header, salt...), wrapped...)
+	if len(protected) > 255 {
+		return errors.New("salt length is too long for a share link")
+	}
+	s.key = protected
+	s.Protected = true
+	s.kdf = true
+	return nil
+}
+
+// String returns the encoded token of the share link
+func (s *ShareLink) String() string {
+	var flags byte
+	if s.Protected {
+		flags |= shareFlagProtected
+	}
+	if s.kdf {
+		flags |= shareFlagKDF
+	}
+	token := []byte{shareLinkVersion, s.protocol, flags, byte(len(s.key))}
+	token = append(append(token, s.key...), s.Ref...)
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+// ParseShareLink is used to parse a share link token returned by ShareLink.String
+func ParseShareLink(token string) (*ShareLink, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) < 4 {
+		return nil, errors.New("invalid share link")
+	}
+	if decoded[0] != shareLinkVersion {
+		return nil, fmt.Errorf("unsupported share link version %d", decoded[0])
+	}
+	if decoded[1] != shareProtocolOnce {
+		return nil, fmt.Errorf("unsupported share link protocol %d", decoded[1])
+	}
+	keyLen := int(decoded[3])
+	if len(decoded) <= 4+keyLen {
+		return nil, errors.New("invalid share link")
+	}
+	link := &ShareLink{
+		Ref:       string(decoded[4+keyLen:]),
+		Protected: decoded[2]&shareFlagProtected != 0,
+		protocol:  decoded[1],
+		key:       decoded[4 : 4+keyLen],
+		kdf:       decoded[2]&shareFlagKDF != 0,
+	}
+	if link.kdf {
+		kdf, _, err := parseKDFHeader(link.key)
+		if err != nil {
+			return nil, err
+		}
+		if kdf == nil || !link.Protected {
+			return nil, errors.New("invalid share link")
+		}
+	}
+	return link, nil
+}
+
+// Open is used to decrypt the object read from r, which must be the object
+// referenced by the link. The passphrase is only required for protected links
+func (s *ShareLink) Open(r io.Reader, passphrase string) ([]byte, error) {
+	key := s.key
+	if s.Protected {
+		if passphrase == "" {
+			return nil, errors.New("share link is protected by a passphrase")
+		}
+		var (
+			kdf *KDFConfig
+			n   int
+		)
+		if s.kdf {
+			var err error
+			if kdf, n, err = parseKDFHeader(key); err != nil {
+				return nil, err
+			}
+			key = key[n:]
+		}
+		size := kdf.saltLength()
+		if len(key) <= size {
+			return nil, errors.New("invalid share link")
+		}
+		kek, err := NewEncryptManager(passphrase).cfbKey(kdf, key[:size])
+		if err != nil {
+			return nil, err
+		}
+		if key, err = UnwrapKey(kek, key[size:]); err != nil {
+			return nil, err
+		}
+	}
+	return OpenOnce(r, base64.RawURLEncoding.EncodeToString(append([]byte{onceTokenVersion}, key...)))
+}
@@ -0,0 +1,115 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_ShareLink(t *testing.T) {
+	sealed, token, err := SealOnce(bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		ref        string
+		passphrase string
+		kdf        *KDFConfig
+	}{
+		{"cid", "QmS4ustL54uo8FzR9455qaxZwuMiUhyvMcX9Ba8nUH4uVv", "", nil},
+		{"url", "https://gateway.temporal.cloud/ipfs/QmS4ustL54uo8FzR9455qaxZwuMiUhyvMcX9Ba8nUH4uVv", "", nil},
+		{"protected", "QmS4ustL54uo8FzR9455qaxZwuMiUhyvMcX9Ba8nUH4uVv", "helloworld", nil},
+		{"protected-argon2id", "QmS4ustL54uo8FzR9455qaxZwuMiUhyvMcX9Ba8nUH4uVv", "helloworld", &KDFConfig{KDF: Argon2id, Iterations: 1, Memory: 1024, Threads: 1, SaltLength: 16}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link, err := NewShareLink(tt.ref, token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tt.kdf != nil:
+				if err := link.ProtectWithKDF(tt.passphrase, *tt.kdf); err != nil {
+					t.Fatal(err)
+				}
+			case tt.passphrase != "":
+				if err := link.Protect(tt.passphrase); err != nil {
+					t.Fatal(err)
+				}
+			}
+			parsed, err := ParseShareLink(link.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.passphrase != "" {
+				want := DefaultKDFConfig(Scrypt)
+				if tt.kdf != nil {
+					want = *tt.kdf
+				}
+				kdf, _, err := parseKDFHeader(parsed.key)
+				if err != nil || kdf == nil || *kdf != want {
+					t.Fatalf("link records kdf %+v, want %+v", kdf, want)
+				}
+			}
+			if parsed.Ref != tt.ref || parsed.Protected != (tt.passphrase != "") {
+				t.Fatalf("ParseShareLink = %+v", parsed)
+			}
+			opened, err := parsed.Open(bytes.NewReader(sealed), tt.passphrase)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(opened) != "hello world" {
+				t.Fatalf("Open = %s", opened)
+			}
+			if tt.passphrase == "" {
+				return
+			}
+			for _, wrong := range []string{"", "wrong"} {
+				if _, err := parsed.Open(bytes.NewReader(sealed), wrong); err == nil {
+					t.Fatalf("expected error opening protected link with passphrase %q", wrong)
+				}
+			}
+		})
+	}
+	for _, invalid := range []string{"", "AQ", "!!!", "AgEAIA", "AQEDAWFi"} {
+		if _, err := ParseShareLink(invalid); err == nil {
+			t.Fatalf("expected error parsing %q", invalid)
+		}
+	}
+	if _, err := NewShareLink("", token); err == nil {
+		t.Fatal("expected error creating link without reference")
+	}
+}
+
+func Test_ShareLink_Legacy(t *testing.T) {
+	sealed, token, err := SealOnce(bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := decodeOnceToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// links protected by earlier versions wrap the key using the legacy PBKDF2
+	// parameters, without recording them
+	salt := bytes.Repeat([]byte{1}, saltlen)
+	wrapped, err := WrapKey(NewEncryptManager("helloworld").deriveKey(salt), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := &ShareLink{Ref: "QmS4ustL54uo8FzR9455qaxZwuMiUhyvMcX9Ba8nUH4uVv", Protected: true, protocol: shareProtocolOnce, key: append(salt, wrapped...)}
+	parsed, err := ParseShareLink(legacy.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, err := parsed.Open(bytes.NewReader(sealed), "helloworld")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(opened) != "hello world" {
+		t.Fatalf("Open = %s", opened)
+	}
+	if _, err := parsed.Open(bytes.NewReader(sealed), "wrong"); err == nil {
+		t.Fatal("expected error opening legacy link with wrong passphrase")
+	}
+}
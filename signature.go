@@ -0,0 +1,284 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// ErrSignatureMismatch is returned when a signature was not produced by a
+// trusted key, or the signed data was modified
+var ErrSignatureMismatch = errors.New("signature does not match the data, or a trusted key")
+
+// ErrUnsigned is returned when decrypting data without an embedded signature
+// using a manager with verification keys set
+var ErrUnsigned = errors.New("encrypted data is not signed")
+
+// signatureMagic identifies signatures produced by Sign
+var signatureMagic = []byte("TSG\x01")
+
+// signatureContext separates signatures from other uses of the signing key
+const signatureContext = "temporal-signature"
+
+// SignatureExt is the extension of detached signature files written by SignFile
+const SignatureExt = ".sig"
+
+// identifiers for the algorithm of a signature
+const (
+	signatureEd25519 byte = 1
+	signatureRSAPSS  byte = 2
+)
+
+// WithSigningKey is used to sign data using Sign, with an ed25519.PrivateKey,
+// an *rsa.PrivateKey using RSA-PSS, or a crypto.Signer holding either type of
+// key outside of memory, such as a PKCS #11 token, or HSM
+func (e *EncryptManager) WithSigningKey(signer crypto.Signer) (*EncryptManager, error) {
+	if signer == nil {
+		return nil, errors.New("no signing key provided")
+	}
+	if _, _, err := signatureKey(signer.Public()); err != nil {
+		return nil, err
+	}
+	e.signer = signer
+	return e, nil
+}
+
+// WithVerifyKeys is used to set the public keys trusted by Verify, being
+// ed25519.PublicKey, or *rsa.PublicKey. The key is selected using the
+// key identifier recorded in the signature. Decrypt, and DecryptSplit then
+// refuse data without a signature embedded by WithSignedPlaintext
+func (e *EncryptManager) WithVerifyKeys(keys ...crypto.PublicKey) (*EncryptManager, error) {
+	for _, key := range keys {
+		if _, _, err := signatureKey(key); err != nil {
+			return nil, err
+		}
+	}
+	e.verifyKeys = keys
+	return e, nil
+}
+
+// WithSignedPlaintext is used to sign the plaintext using the key set by
+// WithSigningKey before it is encrypted, embedding the signature, which
+// records the ID of the signing key, in the header of Encrypt, which is
+// implied, or the envelope of EncryptSplit. Decrypt, and DecryptSplit verify
+// the signature against the keys set by WithVerifyKeys after decryption
+func (e *EncryptManager) WithSignedPlaintext() *EncryptManager {
+	e.signPlaintext = true
+	e.header = true
+	return e
+}
+
+// Sign is used to sign the data read from r, returning a detached signature
+// in the format of
+//
+//	magic || algorithm || key id || signature
+//
+// where the key id is the KeyFingerprint of the public key, and the signature
+// covers the SHA-512 digest of the data, so data of any size is signed in constant memory
+func (e *EncryptManager) Sign(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	if e.signer == nil {
+		return nil, errors.New("no signing key provided")
+	}
+	algorithm, keyID, err := signatureKey(e.signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	digest := sha512.New()
+	if _, err := io.Copy(digest, r); err != nil {
+		return nil, err
+	}
+	message := signatureMessage(algorithm, keyID, digest.Sum(nil))
+	var signature []byte
+	switch algorithm {
+	case signatureEd25519:
+		signature, err = e.signer.Sign(e.randomness(), message, crypto.Hash(0))
+	case signatureRSAPSS:
+		sum := sha256.Sum256(message)
+		signature, err = e.signer.Sign(e.randomness(), sum[:], &rsa.PSSOptions{
+			SaltLength: rsa.PSSSaltLengthEqualsHash,
+			Hash:       crypto.SHA256,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := append(append([]byte{}, signatureMagic...), algorithm)
+	return append(append(out, keyID...), signature...), nil
+}
+
+// Verify is used to verify that sig, as returned by Sign, was produced for
+// the data read from r by one of the keys set using WithVerifyKeys
+func (e *EncryptManager) Verify(r io.Reader, sig []byte) error {
+	if r == nil {
+		return errors.New("invalid content provided")
+	}
+	if len(e.verifyKeys) == 0 {
+		return errors.New("no verification keys provided")
+	}
+	if len(sig) < len(signatureMagic)+1+sha256.Size || !bytes.HasPrefix(sig, signatureMagic) {
+		return errors.New("invalid signature format")
+	}
+	algorithm := sig[len(signatureMagic)]
+	keyID := sig[len(signatureMagic)+1 : len(signatureMagic)+1+sha256.Size]
+	signature := sig[len(signatureMagic)+1+sha256.Size:]
+	var public crypto.PublicKey
+	for _, key := range e.verifyKeys {
+		keyAlgorithm, id, err := signatureKey(key)
+		if err != nil {
+			return err
+		}
+		if keyAlgorithm == algorithm && bytes.Equal(id, keyID) {
+			public = key
+			break
+		}
+	}
+	if public == nil {
+		return ErrSignatureMismatch
+	}
+	digest := sha512.New()
+	if _, err := io.Copy(digest, r); err != nil {
+		return err
+	}
+	message := signatureMessage(algorithm, keyID, digest.Sum(nil))
+	switch public := public.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(public, message, signature) {
+			return ErrSignatureMismatch
+		}
+	case *rsa.PublicKey:
+		sum := sha256.Sum256(message)
+		if rsa.VerifyPSS(public, crypto.SHA256, sum[:], signature, &rsa.PSSOptions{
+			SaltLength: rsa.PSSSaltLengthEqualsHash,
+		}) != nil {
+			return ErrSignatureMismatch
+		}
+	}
+	return nil
+}
+
+// verifyPlaintext verifies the signature embedded alongside encrypted data,
+// which is required when verification keys are set, and otherwise ignored
+func (e *EncryptManager) verifyPlaintext(sig, plaintext []byte) error {
+	switch {
+	case len(e.verifyKeys) == 0:
+		return nil
+	case len(sig) == 0:
+		return ErrUnsigned
+	default:
+		return e.Verify(bytes.NewReader(plaintext), sig)
+	}
+}
+
+// SignFile is used to sign the file at path, writing the detached signature
+// to a file of the same name with the SignatureExt extension
+func (e *EncryptManager) SignFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sig, err := e.Sign(f)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+SignatureExt, sig, 0644)
+}
+
+// VerifyFile is used to verify the file at path against its detached
+// signature, as written by SignFile
+func (e *EncryptManager) VerifyFile(path string) error {
+	sig, err := ioutil.ReadFile(path + SignatureExt)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return e.Verify(f, sig)
+}
+
+// ParseSigningKey is used to load a PEM encoded ed25519, or RSA private key
+// for use with WithSigningKey, as supported by ParsePrivateKey
+func ParseSigningKey(data []byte, passphrase string) (crypto.Signer, error) {
+	private, err := ParsePrivateKey(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("private key can not sign")
+	}
+	if _, _, err := signatureKey(signer.Public()); err != nil {
+		return nil, err
+	}
+	return signer, nil
+}
+
+// ParseVerifyKey is used to load a PEM encoded ed25519, or RSA public key,
+// such as one generated using GenerateKeyPair, for use with WithVerifyKeys
+func ParseVerifyKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no pem encoded public key found")
+	}
+	switch block.Type {
+	case "ED25519 PUBLIC KEY":
+		if len(block.Bytes) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 public key")
+		}
+		return ed25519.PublicKey(block.Bytes), nil
+	case "PUBLIC KEY", "RSA PUBLIC KEY":
+		return parseRSAPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported public key type %s", block.Type)
+	}
+}
+
+// signatureKey returns the signature algorithm, and key id of the public key
+func signatureKey(public crypto.PublicKey) (byte, []byte, error) {
+	switch public := public.(type) {
+	case ed25519.PublicKey:
+		if len(public) != ed25519.PublicKeySize {
+			return 0, nil, errors.New("invalid ed25519 public key")
+		}
+		return signatureEd25519, KeyFingerprint(public), nil
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(public)
+		if err != nil {
+			return 0, nil, err
+		}
+		return signatureRSAPSS, KeyFingerprint(der), nil
+	default:
+		return 0, nil, fmt.Errorf("unsupported signing key type %T", public)
+	}
+}
+
+// signatureKeyID returns the key id recorded in sig, or nil if it is invalid
+func signatureKeyID(sig []byte) []byte {
+	if len(sig) < len(signatureMagic)+1+sha256.Size || !bytes.HasPrefix(sig, signatureMagic) {
+		return nil
+	}
+	return sig[len(signatureMagic)+1 : len(signatureMagic)+1+sha256.Size]
+}
+
+// signatureMessage returns the message signed for the digest of data
+func signatureMessage(algorithm byte, keyID, digest []byte) []byte {
+	message := append([]byte(signatureContext), algorithm)
+	return append(append(message, keyID...), digest...)
+}
@@ -0,0 +1,256 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_EncryptManager_Sign(t *testing.T) {
+	edPublic, edPrivate, err := GenerateEd25519KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPublic, rsaPrivate, err := GenerateRSAKeyPair(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublic, _, err := GenerateEd25519KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("hello world")
+	tests := []struct {
+		name    string
+		signer  crypto.Signer
+		trusted []crypto.PublicKey
+		wantErr bool
+	}{
+		{"ed25519", edPrivate, []crypto.PublicKey{edPublic}, false},
+		{"rsa-pss", rsaPrivate, []crypto.PublicKey{rsaPublic}, false},
+		{"several-trusted", rsaPrivate, []crypto.PublicKey{edPublic, rsaPublic}, false},
+		{"untrusted", edPrivate, []crypto.PublicKey{otherPublic, rsaPublic}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewEncryptManager("").WithSigningKey(tt.signer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sig, err := s.Sign(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, err := NewEncryptManager("").WithVerifyKeys(tt.trusted...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := v.Verify(bytes.NewReader(data), sig); (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err := v.Verify(bytes.NewReader([]byte("hello there")), sig); err != ErrSignatureMismatch {
+				t.Fatalf("err = %v, want %v", err, ErrSignatureMismatch)
+			}
+		})
+	}
+	if _, err := NewEncryptManager("").WithSigningKey(nil); err == nil {
+		t.Fatal("expected error using nil signing key")
+	}
+	if _, err := NewEncryptManager("").WithVerifyKeys([]byte("not a key")); err == nil {
+		t.Fatal("expected error using unsupported verification key")
+	}
+	if _, err := NewEncryptManager("").Sign(bytes.NewReader(data)); err == nil {
+		t.Fatal("expected error signing without a key")
+	}
+	v, err := NewEncryptManager("").WithVerifyKeys(edPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Verify(bytes.NewReader(data), []byte("TSG")); err == nil {
+		t.Fatal("expected error verifying truncated signature")
+	}
+}
+
+func Test_EncryptManager_SignFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "payload")
+	if err := ioutil.WriteFile(path, []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	public, private, err := GenerateEd25519KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewEncryptManager("").WithSigningKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SignFile(path); err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewEncryptManager("").WithVerifyKeys(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.VerifyFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("hello there"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.VerifyFile(path); err != ErrSignatureMismatch {
+		t.Fatalf("err = %v, want %v", err, ErrSignatureMismatch)
+	}
+	if err := v.VerifyFile(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("expected error verifying file without signature")
+	}
+}
+
+func Test_ParseSigningKey(t *testing.T) {
+	for _, keyType := range []KeyType{Ed25519Key, RSAKey} {
+		t.Run(string(keyType), func(t *testing.T) {
+			public, private, err := GenerateKeyPair(keyType, "helloworld")
+			if err != nil {
+				t.Fatal(err)
+			}
+			signer, err := ParseSigningKey(private, "helloworld")
+			if err != nil {
+				t.Fatal(err)
+			}
+			verifyKey, err := ParseVerifyKey(public)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := NewEncryptManager("").WithSigningKey(signer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sig, err := s.Sign(bytes.NewReader([]byte("hello world")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, err := NewEncryptManager("").WithVerifyKeys(verifyKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := v.Verify(bytes.NewReader([]byte("hello world")), sig); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ParseSigningKey(private, "wrong"); err == nil {
+				t.Fatal("expected error using wrong passphrase")
+			}
+		})
+	}
+	if _, err := ParseVerifyKey([]byte("not a key")); err == nil {
+		t.Fatal("expected error parsing invalid public key")
+	}
+}
+
+func Test_EncryptManager_WithSignedPlaintext(t *testing.T) {
+	public, private, err := GenerateEd25519KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublic, _, err := GenerateEd25519KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("hello world")
+	tests := []struct {
+		name    string
+		sign    bool
+		trusted []crypto.PublicKey
+		wantErr error
+	}{
+		{"signed", true, []crypto.PublicKey{public}, nil},
+		{"signed-unverified", true, nil, nil},
+		{"untrusted", true, []crypto.PublicKey{otherPublic}, ErrSignatureMismatch},
+		{"unsigned", false, []crypto.PublicKey{public}, ErrUnsigned},
+		{"unsigned-unverified", false, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewEncryptManager("password")
+			if tt.sign {
+				if _, err := s.WithSigningKey(private); err != nil {
+					t.Fatal(err)
+				}
+				s.WithSignedPlaintext()
+			}
+			v := NewEncryptManager("password")
+			if len(tt.trusted) > 0 {
+				if _, err := v.WithVerifyKeys(tt.trusted...); err != nil {
+					t.Fatal(err)
+				}
+			}
+			encrypted, err := s.Encrypt(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := v.Decrypt(bytes.NewReader(encrypted))
+			if err != tt.wantErr {
+				t.Fatalf("Decrypt() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !bytes.Equal(out, data) {
+				t.Fatal("failed to decrypt data")
+			}
+			env, payload, err := s.EncryptSplit(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.sign && env.Version != 4 {
+				t.Fatalf("envelope version = %d, want 4", env.Version)
+			}
+			out, err = v.DecryptSplit(env, bytes.NewReader(payload))
+			if err != tt.wantErr {
+				t.Fatalf("DecryptSplit() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !bytes.Equal(out, data) {
+				t.Fatal("failed to decrypt data")
+			}
+			if tt.sign {
+				in, err := Inspect(bytes.NewReader(encrypted))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if in.SignerKeyID != hex.EncodeToString(KeyFingerprint(public)) {
+					t.Fatalf("SignerKeyID = %s", in.SignerKeyID)
+				}
+			}
+		})
+	}
+}
+
+func Test_EncryptManager_WithSignedPlaintext_Tampered(t *testing.T) {
+	public, private, err := GenerateEd25519KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewEncryptManager("password").WithSigningKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewEncryptManager("password").WithVerifyKeys(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, payload, err := s.WithSignedPlaintext().EncryptSplit(bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a signature of other plaintext by the trusted key is refused
+	if env.Signature, err = s.Sign(bytes.NewReader([]byte("goodbye world"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.DecryptSplit(env, bytes.NewReader(payload)); err != ErrSignatureMismatch {
+		t.Fatalf("DecryptSplit() err = %v, want %v", err, ErrSignatureMismatch)
+	}
+}
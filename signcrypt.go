@@ -0,0 +1,140 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// ErrInvalidSignature is returned when signcrypted data was not signed by the
+// sender key it records, or was modified
+var ErrInvalidSignature = errors.New("signcrypted data has an invalid signature")
+
+// signcryptMagic identifies signcrypted data
+var signcryptMagic = []byte("TSC\x01")
+
+// signcryptContext separates signcryption signatures from other uses of the sender key
+const signcryptContext = "temporal-signcrypt"
+
+// SignAndEncrypt is used to encrypt r to the recipient public key, being an
+// *rsa.PublicKey, or any key supported by ECIES, and sign it using the sender
+// key in a single pass over the data. The output is in the format of
+//
+//	magic || sender || type || len(wrapped) || wrapped key || nonce || ciphertext
+//
+// where the key is wrapped as for multi-recipient encryption, and the
+// signature is encrypted along with the data, so only the recipient learns,
+// and can verify, that the sender produced it. The signature covers the
+// wrapped key, binding it to the recipient
+func (e *EncryptManager) SignAndEncrypt(r io.Reader, sender ed25519.PrivateKey, recipient interface{}) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	if len(sender) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid sender key")
+	}
+	cipherKey := make([]byte, keylen)
+	if _, err := io.ReadFull(e.randomness(), cipherKey); err != nil {
+		return nil, err
+	}
+	slotType, wrapped, err := e.wrapForRecipient(recipient, cipherKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) > 0xffff {
+		return nil, errors.New("wrapped key is too large")
+	}
+	buf := bytes.NewBuffer(append([]byte{}, signcryptMagic...))
+	buf.Write(sender.Public().(ed25519.PublicKey))
+	buf.WriteByte(slotType)
+	binary.Write(buf, binary.BigEndian, uint16(len(wrapped)))
+	buf.Write(wrapped)
+	header := buf.Bytes()
+
+	// the digest is computed as the data is read
+	digest := sha512.New()
+	data, err := ioutil.ReadAll(io.TeeReader(r, digest))
+	if err != nil {
+		return nil, err
+	}
+	signature := ed25519.Sign(sender, signcryptMessage(header, digest.Sum(nil)))
+
+	aead, err := newAEAD(aeadAES256GCM, cipherKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(e.randomness(), nonce); err != nil {
+		return nil, err
+	}
+	if err := e.recordNonce(cipherKey, nonce); err != nil {
+		return nil, err
+	}
+	out := append(append([]byte{}, header...), nonce...)
+	out = aead.Seal(out, nonce, append(data, signature...), header)
+	e.reportUsage(OperationEncrypt, int64(len(data)))
+	return out, nil
+}
+
+// VerifyAndDecrypt is used to decrypt r, produced by SignAndEncrypt, using the
+// recipient private key, returning the plaintext, and the public key of the
+// verified sender. Callers must check the sender is who they expect, ie
+// by comparing its KeyFingerprint, as anyone may encrypt to the recipient
+func (e *EncryptManager) VerifyAndDecrypt(r io.Reader, recipient interface{}) ([]byte, ed25519.PublicKey, error) {
+	if r == nil {
+		return nil, nil, errors.New("invalid content provided")
+	}
+	if err := e.checkApprovals(); err != nil {
+		return nil, nil, err
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	n := len(signcryptMagic) + ed25519.PublicKeySize + 3
+	if !bytes.HasPrefix(data, signcryptMagic) || len(data) < n {
+		return nil, nil, errors.New("invalid content provided")
+	}
+	n += int(binary.BigEndian.Uint16(data[n-2:]))
+	if len(data) < n {
+		return nil, nil, errors.New("invalid content provided")
+	}
+	header := data[:n]
+	sender := ed25519.PublicKey(header[len(signcryptMagic) : len(signcryptMagic)+ed25519.PublicKeySize])
+	slotType := header[len(signcryptMagic)+ed25519.PublicKeySize]
+	key, err := e.unwrapForRecipient(recipient, slotType, header[len(signcryptMagic)+ed25519.PublicKeySize+3:])
+	if err != nil || len(key) != keylen {
+		return nil, nil, ErrNoMatchingRecipient
+	}
+	aead, err := newAEAD(aeadAES256GCM, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) < n+aead.NonceSize() {
+		return nil, nil, errors.New("invalid content provided")
+	}
+	opened, err := aead.Open(nil, data[n:n+aead.NonceSize()], data[n+aead.NonceSize():], header)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(opened) < ed25519.SignatureSize {
+		return nil, nil, ErrInvalidSignature
+	}
+	plaintext, signature := opened[:len(opened)-ed25519.SignatureSize], opened[len(opened)-ed25519.SignatureSize:]
+	digest := sha512.Sum512(plaintext)
+	if !ed25519.Verify(sender, signcryptMessage(header, digest[:]), signature) {
+		return nil, nil, ErrInvalidSignature
+	}
+	e.reportUsage(OperationDecrypt, int64(len(plaintext)))
+	return plaintext, append(ed25519.PublicKey{}, sender...), nil
+}
+
+// signcryptMessage returns the message signed by the sender
+func signcryptMessage(header, digest []byte) []byte {
+	return append(append([]byte(signcryptContext), header...), digest...)
+}
@@ -0,0 +1,107 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+func Test_EncryptManager_Signcryption(t *testing.T) {
+	data := bytes.Repeat([]byte("hello world"), 1000)
+	senderPublic, sender, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x25519Public, x25519Private := newX25519Key(t)
+	_, malloryPrivate := newX25519Key(t)
+	tests := []struct {
+		name      string
+		recipient interface{}
+		private   interface{}
+		wantErr   error
+	}{
+		{"rsa", &rsaKey.PublicKey, rsaKey, nil},
+		{"x25519", x25519Public, x25519Private, nil},
+		{"wrong-recipient", x25519Public, malloryPrivate, ErrNoMatchingRecipient},
+		{"wrong-key-type", x25519Public, rsaKey, ErrNoMatchingRecipient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEncryptManager("")
+			encrypted, err := e.SignAndEncrypt(bytes.NewReader(data), sender, tt.recipient)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, from, err := e.VerifyAndDecrypt(bytes.NewReader(encrypted), tt.private)
+			if err != tt.wantErr {
+				t.Fatalf("VerifyAndDecrypt() err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+			if !bytes.Equal(from, senderPublic) {
+				t.Fatal("sender does not match signing key")
+			}
+		})
+	}
+
+	// the sender, and wrapped key are authenticated with the content
+	encrypted, err := NewEncryptManager("").SignAndEncrypt(bytes.NewReader(data), sender, x25519Public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	malloryPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte{}, encrypted...)
+	copy(tampered[len(signcryptMagic):], malloryPublic)
+	if _, _, err := NewEncryptManager("").VerifyAndDecrypt(bytes.NewReader(tampered), x25519Private); err == nil {
+		t.Fatal("decrypted data with a substituted sender")
+	}
+}
+
+func Test_EncryptManager_Signcryption_InvalidSignature(t *testing.T) {
+	_, sender, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, private := newX25519Key(t)
+	// the cipher key is the first random value read
+	key := bytes.Repeat([]byte{7}, keylen)
+	e := NewEncryptManager("").WithRandom(io.MultiReader(bytes.NewReader(key), rand.Reader))
+	encrypted, err := e.SignAndEncrypt(bytes.NewReader([]byte("hello world")), sender, public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// reseal the content with a corrupted signature, as the recipient could
+	aead, err := newAEAD(aeadAES256GCM, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := len(encrypted) - len("hello world") - ed25519.SignatureSize - aead.Overhead() - aead.NonceSize()
+	header, nonce := encrypted[:n], encrypted[n:n+aead.NonceSize()]
+	opened, err := aead.Open(nil, nonce, encrypted[n+aead.NonceSize():], header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened[len(opened)-1] ^= 1
+	forged := aead.Seal(append(append([]byte{}, header...), nonce...), nonce, opened, header)
+	if _, _, err := NewEncryptManager("").VerifyAndDecrypt(bytes.NewReader(forged), private); err != ErrInvalidSignature {
+		t.Fatalf("VerifyAndDecrypt() err = %v, want %v", err, ErrInvalidSignature)
+	}
+	if _, _, err := NewEncryptManager("").VerifyAndDecrypt(bytes.NewReader([]byte("TSC\x01")), private); err == nil {
+		t.Fatal("decrypted truncated data")
+	}
+}
@@ -0,0 +1,180 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// ErrSpillModified is returned when a temporary file used by a SpillBuffer
+// was modified while in use
+var ErrSpillModified = errors.New("temporary spill file was modified")
+
+// SpillConfig configures when buffered data is moved from memory to a
+// temporary file
+type SpillConfig struct {
+	// Threshold is the number of bytes held in memory before spilling, where
+	// zero never spills
+	Threshold int64
+	// Dir is the directory of temporary files, defaulting to os.TempDir
+	Dir string
+}
+
+// WithSpillover is used to move data buffered internally, such as plaintext
+// signed by WithSignedPlaintext, to encrypted temporary files in dir once it
+// exceeds threshold bytes, so the []byte APIs keep working for occasional
+// inputs too large to buffer several times in memory
+func (e *EncryptManager) WithSpillover(threshold int64, dir string) *EncryptManager {
+	e.spill = SpillConfig{Threshold: threshold, Dir: dir}
+	return e
+}
+
+// SpillBuffer holds data written to it in memory until it exceeds the
+// configured threshold, then moves it to a temporary file encrypted using
+// AES256-CTR, and authenticated using HMAC-SHA-256, under ephemeral keys
+// which are never written to disk. Where supported, the file is unlinked as
+// soon as it is created, so it is removed even if the process crashes.
+// Otherwise it is removed by Close, which must always be called
+type SpillBuffer struct {
+	cfg    SpillConfig
+	mem    bytes.Buffer
+	file   *os.File
+	name   string
+	key    []byte
+	iv     []byte
+	stream cipher.Stream
+	mac    hash.Hash
+	size   int64
+	sealed bool
+}
+
+// NewSpillBuffer is used to instantiate an empty SpillBuffer
+func NewSpillBuffer(cfg SpillConfig) *SpillBuffer {
+	return &SpillBuffer{cfg: cfg}
+}
+
+// Write appends p to the buffer, spilling to a temporary file once the
+// threshold is exceeded
+func (b *SpillBuffer) Write(p []byte) (int, error) {
+	if b.sealed {
+		return 0, errors.New("spill buffer is being read")
+	}
+	if b.file == nil && b.cfg.Threshold > 0 && int64(b.mem.Len()+len(p)) > b.cfg.Threshold {
+		if err := b.spill(); err != nil {
+			return 0, err
+		}
+	}
+	if b.file == nil {
+		b.size += int64(len(p))
+		return b.mem.Write(p)
+	}
+	encrypted := make([]byte, len(p))
+	b.stream.XORKeyStream(encrypted, p)
+	n, err := b.file.Write(encrypted)
+	b.mac.Write(encrypted[:n])
+	b.size += int64(n)
+	return n, err
+}
+
+// spill moves the data held in memory to a new temporary file
+func (b *SpillBuffer) spill() error {
+	b.key, b.iv = make([]byte, keylen+sha256.Size), make([]byte, aes.BlockSize)
+	if _, err := rand.Read(b.key); err != nil {
+		return err
+	}
+	if _, err := rand.Read(b.iv); err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(b.key[:keylen])
+	if err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(b.cfg.Dir, "temporal-spill")
+	if err != nil {
+		return err
+	}
+	b.file, b.name = file, file.Name()
+	// unlinking fails while the file is open on some platforms, where Close removes it
+	if os.Remove(b.name) == nil {
+		b.name = ""
+	}
+	b.stream, b.mac = cipher.NewCTR(block, b.iv), hmac.New(sha256.New, b.key[keylen:])
+	data := b.mem.Bytes()
+	b.mem = bytes.Buffer{}
+	b.size = 0
+	_, err = b.Write(data)
+	return err
+}
+
+// Len returns the number of bytes written to the buffer
+func (b *SpillBuffer) Len() int64 {
+	return b.size
+}
+
+// Spilled indicates whether the data was moved to a temporary file
+func (b *SpillBuffer) Spilled() bool {
+	return b.file != nil
+}
+
+// Reader returns a reader of all data written to the buffer, which may be
+// called several times to read it again. Data read from a temporary file is
+// authenticated once fully read, returning ErrSpillModified in place of
+// io.EOF if it was modified. No further data may be written
+func (b *SpillBuffer) Reader() (io.Reader, error) {
+	b.sealed = true
+	if b.file == nil {
+		return bytes.NewReader(b.mem.Bytes()), nil
+	}
+	block, err := aes.NewCipher(b.key[:keylen])
+	if err != nil {
+		return nil, err
+	}
+	return &spillReader{
+		r:      io.NewSectionReader(b.file, 0, b.size),
+		stream: cipher.NewCTR(block, b.iv),
+		mac:    hmac.New(sha256.New, b.key[keylen:]),
+		sum:    b.mac.Sum(nil),
+	}, nil
+}
+
+// Close discards the buffered data, removing any temporary file
+func (b *SpillBuffer) Close() error {
+	b.mem = bytes.Buffer{}
+	if b.file == nil {
+		return nil
+	}
+	err := b.file.Close()
+	if b.name != "" {
+		if rerr := os.Remove(b.name); err == nil {
+			err = rerr
+		}
+	}
+	b.file = nil
+	return err
+}
+
+// spillReader decrypts, and authenticates a temporary file
+type spillReader struct {
+	r      io.Reader
+	stream cipher.Stream
+	mac    hash.Hash
+	sum    []byte
+}
+
+func (s *spillReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.mac.Write(p[:n])
+	s.stream.XORKeyStream(p[:n], p[:n])
+	if err == io.EOF && !hmac.Equal(s.mac.Sum(nil), s.sum) {
+		return n, ErrSpillModified
+	}
+	return n, err
+}
@@ -0,0 +1,154 @@
+package crypto
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_SpillBuffer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := bytes.Repeat([]byte("hello world"), 1000)
+	tests := []struct {
+		name      string
+		threshold int64
+		spilled   bool
+	}{
+		{"never", 0, false},
+		{"below-threshold", int64(len(data)), false},
+		{"spilled", 100, true},
+		{"spilled-first-write", 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := NewSpillBuffer(SpillConfig{Threshold: tt.threshold, Dir: dir})
+			defer buf.Close()
+			// written in pieces, so data held in memory is moved on spilling
+			for i := 0; i < len(data); i += 500 {
+				end := i + 500
+				if end > len(data) {
+					end = len(data)
+				}
+				if _, err := buf.Write(data[i:end]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if buf.Spilled() != tt.spilled {
+				t.Fatalf("Spilled() = %v, want %v", buf.Spilled(), tt.spilled)
+			}
+			if buf.Len() != int64(len(data)) {
+				t.Fatalf("Len() = %d, want %d", buf.Len(), len(data))
+			}
+			// the data can be read several times
+			for i := 0; i < 2; i++ {
+				r, err := buf.Reader()
+				if err != nil {
+					t.Fatal(err)
+				}
+				out, err := ioutil.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(out, data) {
+					t.Fatal("read data does not match written data")
+				}
+			}
+			if _, err := buf.Write(data); err == nil {
+				t.Fatal("expected error writing after reading")
+			}
+			if tt.spilled {
+				// the spilled data is encrypted
+				encrypted := make([]byte, len(data))
+				if _, err := buf.file.ReadAt(encrypted, 0); err != nil {
+					t.Fatal(err)
+				}
+				if bytes.Contains(encrypted, []byte("hello world")) {
+					t.Fatal("spilled data is not encrypted")
+				}
+			}
+			if err := buf.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if files, err := ioutil.ReadDir(dir); err != nil || len(files) != 0 {
+				t.Fatalf("temporary files remain: %v, %v", files, err)
+			}
+		})
+	}
+}
+
+func Test_SpillBuffer_Modified(t *testing.T) {
+	buf := NewSpillBuffer(SpillConfig{Threshold: 1})
+	defer buf.Close()
+	if _, err := buf.Write([]byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+	first := make([]byte, 1)
+	if _, err := buf.file.ReadAt(first, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := buf.file.WriteAt([]byte{first[0] ^ 1}, 0); err != nil {
+		t.Fatal(err)
+	}
+	r, err := buf.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != ErrSpillModified {
+		t.Fatalf("ReadAll() err = %v, want %v", err, ErrSpillModified)
+	}
+}
+
+func Test_EncryptManager_WithSpillover(t *testing.T) {
+	_, private, err := GenerateEd25519KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEncryptManager("password").WithGCM(nil).WithSigningKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.WithSignedPlaintext().WithSpillover(16, "")
+	data := bytes.Repeat([]byte("hello world"), 100)
+	encrypted, err := e.Encrypt(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.spill.Threshold != 16 || e.Clone().spill != e.spill {
+		t.Fatal("spillover not configured")
+	}
+	out, err := e.Decrypt(bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatal("failed to decrypt data")
+	}
+}
+
+func Test_RetryingSink_Spill(t *testing.T) {
+	flaky := &flakySink{MemoryStorage: NewMemoryStorage(), failures: 2}
+	sink := &RetryingSink{Sink: flaky, Retries: 2, RetryDelay: time.Millisecond, Spill: SpillConfig{Threshold: 16}}
+	data := bytes.Repeat([]byte("hello world"), 100)
+	if _, err := sink.Put("object", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := flaky.Get("object")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	var out bytes.Buffer
+	if _, err := io.Copy(&out, rc); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("stored object does not match")
+	}
+}
@@ -0,0 +1,111 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ManagerState is the serializable state of an EncryptManager, allowing a
+// coordinator to hand per-job crypto state to worker processes. The passphrase
+// is never included, and decryption parameters are encrypted using it
+type ManagerState struct {
+	Protocol Protocol `json:"protocol"`
+	// Params are the decryption parameters as returned by RetrieveGCMDecryptionParameters
+	Params []byte `json:"params,omitempty"`
+}
+
+// Export is used to serialize the state of the EncryptManager. Protocols
+// using keys other than the passphrase, such as RSA, and ECIES, are refused,
+// as their state cannot be restored from the passphrase alone
+func (e *EncryptManager) Export() ([]byte, error) {
+	state := ManagerState{Protocol: e.getProtocol()}
+	if !restorableProtocol(state.Protocol) {
+		return nil, fmt.Errorf("state of protocol %s cannot be exported", state.Protocol)
+	}
+	if e.getGCMDecryptParams() != nil {
+		params, err := e.RetrieveGCMDecryptionParameters()
+		if err != nil {
+			return nil, err
+		}
+		state.Params = params
+	}
+	return json.Marshal(state)
+}
+
+// Restore is used to load state previously serialized with Export. The
+// EncryptManager must have been created using the same passphrase
+func (e *EncryptManager) Restore(data []byte) error {
+	var state ManagerState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if !restorableProtocol(state.Protocol) {
+		return fmt.Errorf("unsupported protocol %s", state.Protocol)
+	}
+	var params *GCMDecryptParams
+	if state.Params != nil {
+		var err error
+		if params, err = e.loadGCMDecryptParams(state.Params); err != nil {
+			return err
+		}
+	}
+	e.mux.Lock()
+	e.protocol = state.Protocol
+	e.gcmDecryptParams = params
+	e.mux.Unlock()
+	return nil
+}
+
+// restorableProtocol indicates whether the state of protocol can be
+// restored using the passphrase alone
+func restorableProtocol(protocol Protocol) bool {
+	switch protocol {
+	case CFB, GCM, GCMStream, AEAD, ChaCha20Poly1305, XChaCha20Poly1305, Archive, SecretBox:
+		return true
+	}
+	_, ok := registeredProtocol(protocol)
+	return ok
+}
+
+// parseGCMDecryptParams parses decryption parameters in the formats
+// used by RetrieveGCMDecryptionParameters
+func parseGCMDecryptParams(data []byte) (*GCMDecryptParams, error) {
+	// text parameters begin with a field name
+	if len(data) > 0 && (data[0] == '{' || data[0] == 1<<3) {
+		return parseStructuredParams(data)
+	}
+	params := &GCMDecryptParams{}
+	enc := Hex
+	for _, line := range strings.Split(string(data), "\n") {
+		parts := strings.SplitN(line, "\t", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("invalid gcm decryption parameters")
+		}
+		switch parts[0] {
+		case "Encoding:":
+			enc = Encoding(parts[1])
+		case "Nonce:":
+			params.Nonce = parts[1]
+		case "CipherKey:":
+			params.CipherKey = parts[1]
+		}
+	}
+	if params.Nonce == "" || params.CipherKey == "" {
+		return nil, errors.New("invalid gcm decryption parameters")
+	}
+	if enc == Hex {
+		return params, nil
+	}
+	// parameters are held internally as hex
+	for _, value := range []*string{&params.Nonce, &params.CipherKey} {
+		decoded, err := enc.Decode(*value)
+		if err != nil {
+			return nil, err
+		}
+		*value = hex.EncodeToString(decoded)
+	}
+	return params, nil
+}
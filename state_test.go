@@ -0,0 +1,93 @@
+package crypto
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func Test_EncryptManager_ExportRestore(t *testing.T) {
+	original, err := ioutil.ReadFile("README.md")
+	if err != nil {
+		t.Fatalf("setup failed: %s", err)
+	}
+	tests := []struct {
+		name    string
+		manager func() *EncryptManager
+	}{
+		{"CFB", func() *EncryptManager { return NewEncryptManager("helloworld") }},
+		{"GCM", func() *EncryptManager { return NewEncryptManager("helloworld").WithGCM(nil) }},
+		{"AEAD", func() *EncryptManager { return NewEncryptManager("helloworld").WithAEAD(nil) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			coordinator := tt.manager()
+			encrypted, err := coordinator.Encrypt(bytes.NewReader(original))
+			if err != nil {
+				t.Fatal(err)
+			}
+			state, err := coordinator.Export()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the exported state must not leak secrets
+			if coordinator.gcmDecryptParams != nil &&
+				strings.Contains(string(state), coordinator.gcmDecryptParams.CipherKey) {
+				t.Fatal("exported state contains cipher key")
+			}
+			worker := NewEncryptManager("helloworld")
+			if err := worker.Restore(state); err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err := worker.Decrypt(bytes.NewReader(encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, original) {
+				t.Fatal("decrypted data does not match original")
+			}
+		})
+	}
+	if err := NewEncryptManager("helloworld").Restore([]byte(`{"protocol":"ROT13"}`)); err == nil {
+		t.Fatal("expected error restoring unsupported protocol")
+	}
+}
+
+func Test_EncryptManager_ExportRestore_Protocols(t *testing.T) {
+	data := []byte("hello world")
+	// state exported for any protocol must be restorable
+	for _, protocol := range protocols {
+		t.Run(string(protocol), func(t *testing.T) {
+			coordinator, err := New(WithPassphrase("helloworld"), WithProtocol(protocol))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !restorableProtocol(protocol) {
+				if _, err := coordinator.Export(); err == nil {
+					t.Fatal("expected error exporting state which cannot be restored")
+				}
+				return
+			}
+			encrypted, err := coordinator.Encrypt(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			state, err := coordinator.Export()
+			if err != nil {
+				t.Fatal(err)
+			}
+			worker := NewEncryptManager("helloworld")
+			if err := worker.Restore(state); err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err := worker.Decrypt(bytes.NewReader(encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("decrypted data does not match original")
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrObjectNotFound is returned by a StorageSource when the requested object does not exist
+var ErrObjectNotFound = errors.New("object not found")
+
+// StorageSink is used to write encrypted objects to a storage backend
+type StorageSink interface {
+	// Put stores the object read from r under name, returning a reference
+	// used to retrieve it, such as a path, object key, or IPFS CID
+	Put(name string, r io.Reader) (string, error)
+}
+
+// StorageSource is used to read encrypted objects from a storage backend
+type StorageSource interface {
+	// Get returns the object identified by ref, which must be closed by the caller
+	Get(ref string) (io.ReadCloser, error)
+}
+
+// StorageDeleter is implemented by sinks able to remove partially written objects
+type StorageDeleter interface {
+	// Delete removes the object stored under name. Deleting a missing object is not an error
+	Delete(name string) error
+}
+
+// AtomicSink is implemented by sinks whose failed writes never leave a
+// partially written object behind, nor modify the object previously stored
+// under the name, so failed writes are not cleaned up by deleting the object
+type AtomicSink interface {
+	// Atomic reports whether Put replaces objects atomically
+	Atomic() bool
+}
+
+// EncryptTo is used to encrypt r, writing the encrypted object to sink under name.
+// The reference of the stored object is returned. If writing fails and sink is a
+// StorageDeleter, which is not an AtomicSink, any partially written object is
+// deleted. Objects written before the EncryptHooks fail are deleted
+func (e *EncryptManager) EncryptTo(sink StorageSink, name string, r io.Reader) (string, error) {
+	res, err := e.encrypt(r, e.header)
+	if err != nil {
+		return "", err
+	}
+	ref, err := sink.Put(name, bytes.NewReader(res.Data))
+	if err != nil {
+		return "", cleanupSink(sink, name, err)
+	}
+	if err := e.afterEncrypt(ref, res.Data); err != nil {
+		return "", deleteFromSink(sink, name, err)
+	}
+	return ref, nil
+}
+
+// RetryingSink is a StorageSink retrying failed writes to Sink with backoff.
+// Partially written objects are deleted between attempts, and after the final
+// failed attempt, when Sink is a StorageDeleter, which is not an AtomicSink
+type RetryingSink struct {
+	Sink StorageSink
+	// Retries is the number of times a failed write is retried
+	Retries int
+	// RetryDelay is the delay between retries, doubling after every attempt
+	RetryDelay time.Duration
+	// Spill configures when objects are buffered in temporary files
+	Spill SpillConfig
+}
+
+// Put stores the object read from r under name, retrying on failure. As the
+// object may need to be written several times, it is buffered in memory, or
+// a temporary file once larger than the Spill threshold
+func (s *RetryingSink) Put(name string, r io.Reader) (string, error) {
+	buf := NewSpillBuffer(s.Spill)
+	defer buf.Close()
+	if _, err := io.Copy(buf, r); err != nil {
+		return "", err
+	}
+	delay := s.RetryDelay
+	for attempt := 0; ; attempt++ {
+		object, err := buf.Reader()
+		if err != nil {
+			return "", err
+		}
+		ref, err := s.Sink.Put(name, object)
+		if err == nil {
+			return ref, nil
+		}
+		if err = cleanupSink(s.Sink, name, err); attempt >= s.Retries {
+			return "", err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// Delete removes the object stored under name, if Sink is a StorageDeleter
+func (s *RetryingSink) Delete(name string) error {
+	if deleter, ok := s.Sink.(StorageDeleter); ok {
+		return deleter.Delete(name)
+	}
+	return nil
+}
+
+// Atomic reports whether Sink replaces objects atomically
+func (s *RetryingSink) Atomic() bool {
+	atomic, ok := s.Sink.(AtomicSink)
+	return ok && atomic.Atomic()
+}
+
+// cleanupSink deletes the partially written object name after the write
+// error err, returning err along with any failure to clean up. Atomic sinks
+// keep the object previously stored under name, which is not deleted
+func cleanupSink(sink StorageSink, name string, err error) error {
+	if atomic, ok := sink.(AtomicSink); ok && atomic.Atomic() {
+		return err
+	}
+	return deleteFromSink(sink, name, err)
+}
+
+// deleteFromSink deletes the object name after the error err, returning err
+// along with any failure to delete it
+func deleteFromSink(sink StorageSink, name string, err error) error {
+	deleter, ok := sink.(StorageDeleter)
+	if !ok {
+		return err
+	}
+	if cerr := deleter.Delete(name); cerr != nil {
+		return fmt.Errorf("%s: failed to delete partial object: %s", err, cerr)
+	}
+	return err
+}
+
+// DecryptFrom is used to read the object identified by ref from source, and decrypt it
+func (e *EncryptManager) DecryptFrom(source StorageSource, ref string) ([]byte, error) {
+	rc, err := source.Get(ref)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return e.Decrypt(rc)
+}
+
+// MemoryStorage is a StorageSink and StorageSource holding objects in memory
+type MemoryStorage struct {
+	mux     sync.RWMutex
+	objects map[string][]byte
+}
+
+// NewMemoryStorage is used to instantiate an empty MemoryStorage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{objects: make(map[string][]byte)}
+}
+
+// Put stores the object read from r under name, which is returned as its reference
+func (m *MemoryStorage) Put(name string, r io.Reader) (string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	m.mux.Lock()
+	m.objects[name] = data
+	m.mux.Unlock()
+	return name, nil
+}
+
+// Atomic reports that objects are replaced atomically
+func (m *MemoryStorage) Atomic() bool {
+	return true
+}
+
+// Delete removes the object stored under name
+func (m *MemoryStorage) Delete(name string) error {
+	m.mux.Lock()
+	delete(m.objects, name)
+	m.mux.Unlock()
+	return nil
+}
+
+// Get returns the object stored under ref
+func (m *MemoryStorage) Get(ref string) (io.ReadCloser, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	data, ok := m.objects[ref]
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// FileStorage is a StorageSink and StorageSource holding objects as files within a directory
+type FileStorage struct {
+	dir string
+}
+
+// NewFileStorage is used to store objects in dir, creating it if needed
+func NewFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &FileStorage{dir: dir}, nil
+}
+
+// Put stores the object read from r under name, which may contain
+// subdirectories. The name is returned as the reference of the object.
+// The object is written to a temporary file which is renamed once complete,
+// so failed writes never leave a partial object behind
+func (f *FileStorage) Put(name string, r io.Reader) (string, error) {
+	path, err := f.path(name)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return "", err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".partial")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return name, nil
+}
+
+// Atomic reports that objects are replaced atomically, as they are renamed
+// into place once complete
+func (f *FileStorage) Atomic() bool {
+	return true
+}
+
+// Delete removes the object stored under name
+func (f *FileStorage) Delete(name string) error {
+	path, err := f.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Get returns the object stored under ref
+func (f *FileStorage) Get(ref string) (io.ReadCloser, error) {
+	path, err := f.path(ref)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrObjectNotFound
+	}
+	return file, err
+}
+
+// path returns the location of the object name, ensuring it is within the storage directory
+func (f *FileStorage) path(name string) (string, error) {
+	cleaned := filepath.Clean(filepath.FromSlash(name))
+	if name == "" || filepath.IsAbs(cleaned) || cleaned == ".." ||
+		strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid object name %q", name)
+	}
+	return filepath.Join(f.dir, cleaned), nil
+}
@@ -0,0 +1,173 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3Storage is a StorageSink and StorageSource holding objects in an S3 bucket
+type S3Storage struct {
+	// Endpoint is the S3 endpoint, ie https://s3.us-east-1.amazonaws.com
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is prepended to the key of every stored object
+	Prefix      string
+	Credentials AWSCredentials
+	// Client is used to make requests, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// Put stores the object read from r under name, which is returned as its reference
+func (s *S3Storage) Put(name string, r io.Reader) (string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	resp, err := doS3Request(s.Client, s.Endpoint, s.Region, s.Bucket, s.Prefix+name,
+		s.Credentials, http.MethodPut, data)
+	if err != nil {
+		return "", err
+	}
+	resp.Close()
+	return name, nil
+}
+
+// Atomic reports that objects are replaced atomically, as S3 only stores
+// objects once completely uploaded
+func (s *S3Storage) Atomic() bool {
+	return true
+}
+
+// Delete removes the object stored under name
+func (s *S3Storage) Delete(name string) error {
+	resp, err := doS3Request(s.Client, s.Endpoint, s.Region, s.Bucket, s.Prefix+name,
+		s.Credentials, http.MethodDelete, nil)
+	if err == ErrObjectNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Close()
+}
+
+// Get returns the object stored under ref
+func (s *S3Storage) Get(ref string) (io.ReadCloser, error) {
+	return doS3Request(s.Client, s.Endpoint, s.Region, s.Bucket, s.Prefix+ref,
+		s.Credentials, http.MethodGet, nil)
+}
+
+// doS3Request performs a signed request against the given object, returning
+// the response body. Missing objects are reported as ErrObjectNotFound
+func doS3Request(client *http.Client, endpoint, region, bucket, key string,
+	creds AWSCredentials, method string, body []byte) (io.ReadCloser, error) {
+	if key == "" {
+		return nil, errors.New("no object key provided")
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(endpoint, "/")+"/"+
+		url.PathEscape(bucket)+"/"+url.PathEscape(key), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	signAWSRequest(req, body, creds, region, "s3", time.Now())
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrObjectNotFound
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s returned status %d: %s", method, req.URL.Path, resp.StatusCode, data)
+	}
+	return resp.Body, nil
+}
+
+// IPFSStorage is a StorageSink and StorageSource using the HTTP API of an IPFS node
+type IPFSStorage struct {
+	// API is the address of the node's HTTP API, ie http://localhost:5001
+	API string
+	// Client is used to make requests, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// Put adds the object read from r to IPFS, returning its CID as the reference
+func (i *IPFSStorage) Put(name string, r io.Reader) (string, error) {
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	go func() {
+		part, err := form.CreateFormFile("file", name)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(part, r); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(form.Close())
+	}()
+	resp, err := i.post("add", nil, form.FormDataContentType(), pr)
+	if err != nil {
+		pr.Close()
+		return "", err
+	}
+	defer resp.Close()
+	var added struct {
+		Hash string
+	}
+	if err := json.NewDecoder(resp).Decode(&added); err != nil {
+		return "", err
+	}
+	if added.Hash == "" {
+		return "", errors.New("ipfs node did not return a hash")
+	}
+	return added.Hash, nil
+}
+
+// Get returns the object with the CID ref
+func (i *IPFSStorage) Get(ref string) (io.ReadCloser, error) {
+	return i.post("cat", url.Values{"arg": {ref}}, "", nil)
+}
+
+// post calls the given command of the IPFS HTTP API, returning the response body
+func (i *IPFSStorage) post(command string, args url.Values, contentType string, body io.Reader) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodPost,
+		strings.TrimSuffix(i.API, "/")+"/api/v0/"+command+"?"+args.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	client := i.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("ipfs %s returned status %d: %s", command, resp.StatusCode, data)
+	}
+	return resp.Body, nil
+}
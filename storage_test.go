@@ -0,0 +1,264 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Storage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileStorage, err := NewFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3srv := newFakeObjectServer(t, func(r *http.Request) bool {
+		return r.Header.Get("Authorization") != ""
+	})
+	defer s3srv.Close()
+	ipfssrv := newFakeIPFSServer(t)
+	defer ipfssrv.Close()
+
+	type storage interface {
+		StorageSink
+		StorageSource
+	}
+	tests := []struct {
+		name    string
+		storage storage
+	}{
+		{"memory", NewMemoryStorage()},
+		{"file", fileStorage},
+		{"s3", &S3Storage{
+			Endpoint:    s3srv.URL,
+			Region:      "us-east-1",
+			Bucket:      "bucket",
+			Credentials: AWSCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret"},
+		}},
+		{"ipfs", &IPFSStorage{API: ipfssrv.URL}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEncryptManager("helloworld").WithGCM(nil)
+			ref, err := e.EncryptTo(tt.storage, "nested/object", bytes.NewReader([]byte("hello world")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err := e.DecryptFrom(tt.storage, ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(decrypted) != "hello world" {
+				t.Fatalf("DecryptFrom = %s", decrypted)
+			}
+			if _, err := tt.storage.Get("missing"); err == nil {
+				t.Fatal("expected error retrieving missing object")
+			}
+			if deleter, ok := tt.storage.(StorageDeleter); ok {
+				if err := deleter.Delete(ref); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := tt.storage.Get(ref); err == nil {
+					t.Fatal("expected error retrieving deleted object")
+				}
+				if err := deleter.Delete(ref); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+	if _, err := fileStorage.Put("../escape", bytes.NewReader(nil)); err == nil {
+		t.Fatal("expected error storing object outside of directory")
+	}
+}
+
+// flakySink writes half of every object to storage before failing, until
+// failures reaches zero
+type flakySink struct {
+	*MemoryStorage
+	failures int
+	attempts int
+}
+
+func (f *flakySink) Put(name string, r io.Reader) (string, error) {
+	f.attempts++
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	if f.failures > 0 {
+		f.failures--
+		f.MemoryStorage.Put(name, bytes.NewReader(data[:len(data)/2]))
+		return "", errors.New("connection reset")
+	}
+	return f.MemoryStorage.Put(name, bytes.NewReader(data))
+}
+
+// Atomic reports that failed writes leave partial objects behind
+func (f *flakySink) Atomic() bool {
+	return false
+}
+
+// failingOverwriteSink fails every write to storage part way through
+type failingOverwriteSink struct {
+	*FileStorage
+}
+
+func (f *failingOverwriteSink) Put(name string, r io.Reader) (string, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		io.CopyN(pw, r, 4)
+		pw.CloseWithError(errors.New("connection reset"))
+	}()
+	return f.FileStorage.Put(name, pr)
+}
+
+func Test_AtomicSink_FailedOverwrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileStorage, err := NewFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewEncryptManager("helloworld")
+	ref, err := e.EncryptTo(fileStorage, "object", bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing := &failingOverwriteSink{fileStorage}
+	sinks := []StorageSink{failing, &RetryingSink{Sink: failing, Retries: 1, RetryDelay: time.Millisecond}}
+	for _, sink := range sinks {
+		if _, err := e.EncryptTo(sink, "object", bytes.NewReader([]byte("goodbye world"))); err == nil {
+			t.Fatal("expected error overwriting object")
+		}
+		// the previous object is kept
+		decrypted, err := e.DecryptFrom(fileStorage, ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(decrypted) != "hello world" {
+			t.Fatalf("DecryptFrom = %s", decrypted)
+		}
+	}
+}
+
+func Test_RetryingSink(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		retries  int
+		attempts int
+		wantErr  bool
+	}{
+		{"no-failures", 0, 0, 1, false},
+		{"recovers", 2, 2, 3, false},
+		{"gives-up", 3, 2, 3, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flaky := &flakySink{MemoryStorage: NewMemoryStorage(), failures: tt.failures}
+			sink := &RetryingSink{Sink: flaky, Retries: tt.retries, RetryDelay: time.Millisecond}
+			e := NewEncryptManager("helloworld").WithGCM(nil)
+			ref, err := e.EncryptTo(sink, "object", bytes.NewReader([]byte("hello world")))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EncryptTo error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if flaky.attempts != tt.attempts {
+				t.Fatalf("attempts = %d, want %d", flaky.attempts, tt.attempts)
+			}
+			if tt.wantErr {
+				if _, err := flaky.Get("object"); err != ErrObjectNotFound {
+					t.Fatal("partial object was not cleaned up")
+				}
+				return
+			}
+			decrypted, err := e.DecryptFrom(flaky, ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(decrypted) != "hello world" {
+				t.Fatalf("DecryptFrom = %s", decrypted)
+			}
+		})
+	}
+}
+
+func Test_FileStorage_PartialWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileStorage, err := NewFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("partial"))
+		pw.CloseWithError(errors.New("connection reset"))
+	}()
+	if _, err := fileStorage.Put("object", pr); err == nil {
+		t.Fatal("expected error")
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("partial write left %d files behind", len(files))
+	}
+}
+
+// newFakeIPFSServer returns a server emulating the add and cat commands of
+// the IPFS HTTP API, using the sha256 of the content as its hash
+func newFakeIPFSServer(t *testing.T) *httptest.Server {
+	var (
+		mux     sync.Mutex
+		objects = make(map[string][]byte)
+	)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v0/add":
+			file, _, err := r.FormFile("file")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			data, _ := ioutil.ReadAll(file)
+			sum := sha256.Sum256(data)
+			hash := "Qm" + hex.EncodeToString(sum[:])
+			objects[hash] = data
+			json.NewEncoder(w).Encode(map[string]string{"Name": "file", "Hash": hash})
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v0/cat":
+			data, ok := objects[r.URL.Query().Get("arg")]
+			if !ok || !strings.HasPrefix(r.URL.Query().Get("arg"), "Qm") {
+				http.Error(w, "invalid path", http.StatusInternalServerError)
+				return
+			}
+			w.Write(data)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
@@ -0,0 +1,422 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// streamChunkSize is the maximum amount of plaintext carried by a frame
+	streamChunkSize = 64 * 1024
+	// streamFrameData marks a frame carrying a chunk of the stream
+	streamFrameData byte = 1
+	// streamFrameFinal marks the final frame of the stream
+	streamFrameFinal byte = 2
+	// streamFrameResume marks the frame resuming the stream over a new connection
+	streamFrameResume byte = 3
+)
+
+var (
+	// streamMagic prefixes the handshake sent at the start of each direction,
+	// and streamKDFMagic the handshake recording the key derivation function
+	streamMagic    = []byte("TCS\x01")
+	streamKDFMagic = []byte("TCS\x02")
+	// streamTokenMagic prefixes resumption tokens
+	streamTokenMagic = []byte("TCR\x01")
+
+	// ErrStreamTruncated is returned when a stream ends without its final frame
+	ErrStreamTruncated = errors.New("encrypted stream was truncated")
+)
+
+// Stream is an encrypted, framed connection between two processes using this
+// package, for example over stdin/stdout or a socket. Each direction begins
+// with a handshake holding a random salt, from which the keys for that
+// direction are derived using the passphrase of the manager, and the key
+// derivation function set using WithKDF, which is recorded in the handshake
+// so the receiver selects it automatically. Data is then sent
+// as a sequence of sealed chunk frames, terminated by a final frame sent by
+// Close, so that reordered, replayed, reflected, or truncated frames are
+// detected by the receiver.
+//
+// Framing is:
+//
+//	handshake: "TCS\x01" || salt, or "TCS\x02" || kdf header || salt
+//	frame:     type || length (4 bytes) || sealed chunk
+//	resume:    type || length (4 bytes) || index (8 bytes) || sealed offset
+//
+// A Stream may be read from and written to concurrently, however a single
+// direction should not be used by multiple goroutines at once.
+//
+// Interrupted transfers can be resumed over a new connection, using the same
+// keys, without a new handshake. See ResumptionToken
+type Stream struct {
+	rw     io.ReadWriter
+	e      *EncryptManager
+	local  string
+	remote string
+
+	wmux    sync.Mutex
+	wkeys   *ChunkKeys
+	windex  uint64
+	wbytes  int64
+	wclosed bool
+
+	rmux    sync.Mutex
+	rkeys   *ChunkKeys
+	rindex  uint64
+	rbytes  int64
+	rbuf    []byte
+	rdone   bool
+	rresume bool
+}
+
+// DialStream is used to open an encrypted stream over rw, whose other end is
+// opened using AcceptStream with the same passphrase
+func (e *EncryptManager) DialStream(rw io.ReadWriter) *Stream {
+	return &Stream{rw: rw, e: e, local: "dial", remote: "accept"}
+}
+
+// AcceptStream is used to accept an encrypted stream over rw, opened by the
+// other end using DialStream
+func (e *EncryptManager) AcceptStream(rw io.ReadWriter) *Stream {
+	return &Stream{rw: rw, e: e, local: "accept", remote: "dial"}
+}
+
+// Write encrypts p and writes it to the stream, sending the handshake first
+// when needed
+func (s *Stream) Write(p []byte) (int, error) {
+	s.wmux.Lock()
+	defer s.wmux.Unlock()
+	if s.wclosed {
+		return 0, errors.New("write to closed stream")
+	}
+	if err := s.handshake(); err != nil {
+		return 0, err
+	}
+	var n int
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > streamChunkSize {
+			chunk = chunk[:streamChunkSize]
+		}
+		if err := s.writeFrame(streamFrameData, chunk); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		s.wbytes += int64(len(chunk))
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// Close sends the final frame of the stream, after which no more data may be
+// written. The underlying io.ReadWriter is not closed
+func (s *Stream) Close() error {
+	s.wmux.Lock()
+	defer s.wmux.Unlock()
+	if s.wclosed {
+		return nil
+	}
+	if err := s.handshake(); err != nil {
+		return err
+	}
+	s.wclosed = true
+	return s.writeFrame(streamFrameFinal, nil)
+}
+
+// Read reads and decrypts data from the stream, returning io.EOF once the
+// final frame is received, or ErrStreamTruncated if the stream ended early
+func (s *Stream) Read(p []byte) (int, error) {
+	s.rmux.Lock()
+	defer s.rmux.Unlock()
+	for len(s.rbuf) == 0 {
+		if s.rdone {
+			return 0, io.EOF
+		}
+		if err := s.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.rbuf)
+	s.rbuf = s.rbuf[n:]
+	return n, nil
+}
+
+func (s *Stream) handshake() error {
+	if s.wkeys != nil {
+		return nil
+	}
+	salt := make([]byte, s.e.kdf.saltLength())
+	if _, err := io.ReadFull(s.e.randomness(), salt); err != nil {
+		return err
+	}
+	keys, err := s.streamKeys(s.e.kdf, salt, s.local)
+	if err != nil {
+		return err
+	}
+	// frames are sealed using fixed nonces, so the keys must never repeat
+	if err := s.e.recordNonce(keys.master, nil); err != nil {
+		return err
+	}
+	handshake := append([]byte{}, streamMagic...)
+	if s.e.kdf != nil {
+		header, err := s.e.kdf.header()
+		if err != nil {
+			return err
+		}
+		handshake = append(append([]byte{}, streamKDFMagic...), header...)
+	}
+	if _, err := s.rw.Write(append(handshake, salt...)); err != nil {
+		return err
+	}
+	s.wkeys = keys
+	return nil
+}
+
+func (s *Stream) writeFrame(frameType byte, chunk []byte) error {
+	key, err := s.wkeys.Key(s.windex)
+	if err != nil {
+		return err
+	}
+	sealed, err := SealChunk(key, s.windex, chunk, frameType == streamFrameFinal)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 5, 5+len(sealed))
+	frame[0] = frameType
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(sealed)))
+	if _, err := s.rw.Write(append(frame, sealed...)); err != nil {
+		return err
+	}
+	s.windex++
+	return nil
+}
+
+func (s *Stream) readFrame() error {
+	if s.rkeys == nil {
+		magic := make([]byte, len(streamMagic))
+		if _, err := io.ReadFull(s.rw, magic); err != nil {
+			return streamReadError(err)
+		}
+		var kdf *KDFConfig
+		switch string(magic) {
+		case string(streamMagic):
+		case string(streamKDFMagic):
+			var err error
+			if kdf, err = readKDFHeader(s.rw); err != nil {
+				return streamReadError(err)
+			}
+		default:
+			return errors.New("invalid stream handshake")
+		}
+		salt := make([]byte, kdf.saltLength())
+		if _, err := io.ReadFull(s.rw, salt); err != nil {
+			return streamReadError(err)
+		}
+		keys, err := s.streamKeys(kdf, salt, s.remote)
+		if err != nil {
+			return err
+		}
+		s.rkeys = keys
+	}
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(s.rw, header); err != nil {
+		return streamReadError(err)
+	}
+	frameType, length := header[0], binary.BigEndian.Uint32(header[1:])
+	if s.rresume {
+		if frameType != streamFrameResume {
+			return errors.New("expected stream resume frame")
+		}
+		return s.readResumeFrame(length)
+	}
+	if frameType != streamFrameData && frameType != streamFrameFinal {
+		return fmt.Errorf("invalid stream frame type %d", frameType)
+	}
+	// 16 bytes of GCM tag overhead
+	if length > streamChunkSize+16 {
+		return fmt.Errorf("stream frame of %d bytes exceeds maximum size", length)
+	}
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(s.rw, sealed); err != nil {
+		return streamReadError(err)
+	}
+	key, err := s.rkeys.Key(s.rindex)
+	if err != nil {
+		return err
+	}
+	chunk, err := OpenChunk(key, s.rindex, sealed, frameType == streamFrameFinal)
+	if err != nil {
+		return err
+	}
+	s.rindex++
+	s.rbytes += int64(len(chunk))
+	s.rbuf = chunk
+	s.rdone = frameType == streamFrameFinal
+	return nil
+}
+
+// streamKeys derives the chunk keys for the direction sent by role using the
+// key derivation function kdf, where nil is the legacy function, so that
+// frames reflected back to their sender fail to decrypt
+func (s *Stream) streamKeys(kdf *KDFConfig, salt []byte, role string) (*ChunkKeys, error) {
+	key, err := s.e.cfbKey(kdf, salt)
+	if err != nil {
+		return nil, err
+	}
+	master := make([]byte, keylen)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte("temporal-stream:"+role)), master); err != nil {
+		return nil, err
+	}
+	// the master is unique to the direction, so no object id is needed
+	return &ChunkKeys{master: master}, nil
+}
+
+// ResumptionToken returns a token recording the amount of data received from
+// the other end, for use after the connection was interrupted. The other end
+// passes the token to Resume, and continues sending from the offset it
+// returns. The token is authenticated using the keys of the stream
+func (s *Stream) ResumptionToken() ([]byte, error) {
+	s.rmux.Lock()
+	defer s.rmux.Unlock()
+	if s.rkeys == nil {
+		return nil, errors.New("stream handshake not received")
+	}
+	token := make([]byte, len(streamTokenMagic)+8, len(streamTokenMagic)+8+sha256.Size)
+	copy(token, streamTokenMagic)
+	binary.BigEndian.PutUint64(token[len(streamTokenMagic):], uint64(s.rbytes))
+	mac, err := streamTokenMAC(s.rkeys, token)
+	if err != nil {
+		return nil, err
+	}
+	return append(token, mac...), nil
+}
+
+// Reconnect replaces the connection of the stream with rw, after it was
+// interrupted, once any pending Read, and Write have returned. The next frame
+// received must resume the stream, see Resume. Data which was received, but
+// not read, is kept
+func (s *Stream) Reconnect(rw io.ReadWriter) {
+	s.wmux.Lock()
+	defer s.wmux.Unlock()
+	s.rmux.Lock()
+	defer s.rmux.Unlock()
+	s.rw = rw
+	s.rresume = s.rkeys != nil && !s.rdone
+}
+
+// Resume is used to resume sending over the connection given to Reconnect,
+// using the token returned by ResumptionToken at the other end, returning the
+// offset of the data written so far from which writing must continue. Frames
+// sent after resuming use new chunk indexes, so no key is reused. If the
+// stream was closed, Close must be called again
+func (s *Stream) Resume(token []byte) (int64, error) {
+	s.wmux.Lock()
+	defer s.wmux.Unlock()
+	if s.wkeys == nil {
+		return 0, errors.New("stream handshake not sent")
+	}
+	n := len(streamTokenMagic) + 8
+	if len(token) != n+sha256.Size || string(token[:len(streamTokenMagic)]) != string(streamTokenMagic) {
+		return 0, errors.New("invalid resumption token")
+	}
+	mac, err := streamTokenMAC(s.wkeys, token[:n])
+	if err != nil {
+		return 0, err
+	}
+	offset := int64(binary.BigEndian.Uint64(token[len(streamTokenMagic):]))
+	if !hmac.Equal(mac, token[n:]) || offset < 0 || offset > s.wbytes {
+		return 0, errors.New("invalid resumption token")
+	}
+	key, err := streamResumeKey(s.wkeys, s.windex)
+	if err != nil {
+		return 0, err
+	}
+	encoded := make([]byte, 8)
+	binary.BigEndian.PutUint64(encoded, uint64(offset))
+	sealed, err := SealChunk(key, s.windex, encoded, false)
+	if err != nil {
+		return 0, err
+	}
+	frame := make([]byte, 13, 13+len(sealed))
+	frame[0] = streamFrameResume
+	binary.BigEndian.PutUint32(frame[1:], uint32(8+len(sealed)))
+	binary.BigEndian.PutUint64(frame[5:], s.windex)
+	if _, err := s.rw.Write(append(frame, sealed...)); err != nil {
+		return 0, err
+	}
+	s.windex++
+	s.wbytes = offset
+	s.wclosed = false
+	return offset, nil
+}
+
+// readResumeFrame reads the body of a resume frame of length bytes, which
+// must resume from the amount of data received, at a chunk index not used yet
+func (s *Stream) readResumeFrame(length uint32) error {
+	// the index, an 8 byte offset, and 16 bytes of GCM tag overhead
+	if length != 8+8+16 {
+		return errors.New("invalid stream resume frame")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.rw, body); err != nil {
+		return streamReadError(err)
+	}
+	index := binary.BigEndian.Uint64(body)
+	if index < s.rindex {
+		return errors.New("invalid stream resume frame")
+	}
+	key, err := streamResumeKey(s.rkeys, index)
+	if err != nil {
+		return err
+	}
+	encoded, err := OpenChunk(key, index, body[8:], false)
+	if err != nil {
+		return err
+	}
+	if offset := int64(binary.BigEndian.Uint64(encoded)); offset != s.rbytes {
+		return fmt.Errorf("stream resumed from offset %d, expected %d", offset, s.rbytes)
+	}
+	s.rindex = index + 1
+	s.rresume = false
+	return nil
+}
+
+// streamResumeKey derives the key sealing the resume frame at index, distinct
+// from the key of a data frame at the same index
+func streamResumeKey(keys *ChunkKeys, index uint64) ([]byte, error) {
+	chunkKey, err := keys.Key(index)
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, keylen)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, chunkKey, nil, []byte("temporal-stream-resume")), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// streamTokenMAC authenticates a resumption token for the direction using keys
+func streamTokenMAC(keys *ChunkKeys, token []byte) ([]byte, error) {
+	key := make([]byte, keylen)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, keys.master, nil, []byte("temporal-stream-token")), key); err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(token)
+	return mac.Sum(nil), nil
+}
+
+func streamReadError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrStreamTruncated
+	}
+	return err
+}
@@ -0,0 +1,265 @@
+package crypto
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func Test_Stream(t *testing.T) {
+	data, err := ioutil.ReadFile("README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// exceed a single frame
+	large := bytes.Repeat(data, (2*streamChunkSize)/len(data)+1)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"small", data},
+		{"multi-frame", large},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var wire bytes.Buffer
+			sender := NewEncryptManager("helloworld").DialStream(&wire)
+			if _, err := sender.Write(tt.data); err != nil {
+				t.Fatal(err)
+			}
+			if err := sender.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.data) > 0 && bytes.Contains(wire.Bytes(), tt.data[:32]) {
+				t.Fatal("plaintext found on the wire")
+			}
+			received, err := ioutil.ReadAll(NewEncryptManager("helloworld").AcceptStream(&wire))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(received, tt.data) {
+				t.Fatal("received data does not match sent data")
+			}
+		})
+	}
+}
+
+func Test_Stream_Tampering(t *testing.T) {
+	var wire bytes.Buffer
+	sender := NewEncryptManager("helloworld").DialStream(&wire)
+	if _, err := sender.Write([]byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.Close(); err != nil {
+		t.Fatal(err)
+	}
+	encoded := wire.Bytes()
+	// handshake, then a data frame of 11 bytes plus the GCM tag
+	finalFrame := len(streamMagic) + saltlen + 5 + 11 + 16
+
+	tests := []struct {
+		name       string
+		wire       []byte
+		passphrase string
+		dial       bool
+		wantErr    error
+	}{
+		{"truncated", encoded[:finalFrame], "helloworld", false, ErrStreamTruncated},
+		{"no-handshake", nil, "helloworld", false, ErrStreamTruncated},
+		{"wrong-passphrase", encoded, "wrong", false, nil},
+		{"reflected", encoded, "helloworld", true, nil},
+		{"modified", append(append([]byte{}, encoded[:finalFrame-1]...), append([]byte{encoded[finalFrame-1] ^ 1}, encoded[finalFrame:]...)...), "helloworld", false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEncryptManager(tt.passphrase)
+			var stream *Stream
+			if tt.dial {
+				stream = e.DialStream(bytes.NewBuffer(tt.wire))
+			} else {
+				stream = e.AcceptStream(bytes.NewBuffer(tt.wire))
+			}
+			_, err := ioutil.ReadAll(stream)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if tt.wantErr != nil && err != tt.wantErr {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_Stream_KDF(t *testing.T) {
+	salted := DefaultKDFConfig(PBKDF2)
+	salted.SaltLength = 16
+	tests := []struct {
+		name string
+		kdf  KDFConfig
+	}{
+		{"scrypt", KDFConfig{KDF: Scrypt, N: 1 << 10, R: 8, P: 1}},
+		{"argon2id", KDFConfig{KDF: Argon2id, Iterations: 1, Memory: 1024, Threads: 1}},
+		{"pbkdf2-salt-length", salted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var wire bytes.Buffer
+			sender := NewEncryptManager("helloworld").WithKDF(tt.kdf).DialStream(&wire)
+			if _, err := sender.Write([]byte("hello world")); err != nil {
+				t.Fatal(err)
+			}
+			if err := sender.Close(); err != nil {
+				t.Fatal(err)
+			}
+			header, err := tt.kdf.header()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(wire.Bytes(), append(append([]byte{}, streamKDFMagic...), header...)) {
+				t.Fatal("handshake does not record the kdf")
+			}
+			encoded := append([]byte{}, wire.Bytes()...)
+			// the receiver selects the kdf from the handshake
+			received, err := ioutil.ReadAll(NewEncryptManager("helloworld").AcceptStream(&wire))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(received) != "hello world" {
+				t.Fatalf("received %s", received)
+			}
+			if _, err := ioutil.ReadAll(NewEncryptManager("wrong").AcceptStream(bytes.NewBuffer(encoded))); err == nil {
+				t.Fatal("expected error with wrong passphrase")
+			}
+			truncated := bytes.NewBuffer(encoded[:len(streamKDFMagic)+len(header)-1])
+			if _, err := ioutil.ReadAll(NewEncryptManager("helloworld").AcceptStream(truncated)); err != ErrStreamTruncated {
+				t.Fatalf("got error %v, want %v", err, ErrStreamTruncated)
+			}
+		})
+	}
+}
+
+func Test_Stream_KDFLimits(t *testing.T) {
+	forged, err := (&KDFConfig{KDF: Argon2id, Iterations: 1, Memory: 0xFFFFFFFF, Threads: 1}).header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handshake := append(append(append([]byte{}, streamKDFMagic...), forged...), make([]byte, saltlen)...)
+	if _, err := ioutil.ReadAll(NewEncryptManager("helloworld").AcceptStream(bytes.NewBuffer(handshake))); err == nil || err == ErrStreamTruncated {
+		t.Fatalf("expected kdf limit error, got %v", err)
+	}
+}
+
+func Test_Stream_Bidirectional(t *testing.T) {
+	type pipe struct {
+		io.Reader
+		io.Writer
+	}
+	ar, bw := io.Pipe()
+	br, aw := io.Pipe()
+	a := NewEncryptManager("helloworld").DialStream(pipe{ar, aw})
+	b := NewEncryptManager("helloworld").AcceptStream(pipe{br, bw})
+
+	go func() {
+		// echo everything back to the dialer
+		if _, err := io.Copy(b, b); err != nil {
+			bw.CloseWithError(err)
+			return
+		}
+		b.Close()
+	}()
+	go func() {
+		a.Write([]byte("ping"))
+		a.Close()
+	}()
+	echoed, err := ioutil.ReadAll(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(echoed) != "ping" {
+		t.Fatalf("echoed %q", echoed)
+	}
+}
+
+func Test_Stream_Resume(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), streamChunkSize/4)
+	var first bytes.Buffer
+	sender := NewEncryptManager("helloworld").DialStream(&first)
+	if _, err := sender.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	// the connection drops during the third frame
+	frame := 5 + streamChunkSize + 16
+	interrupted := first.Bytes()[:len(streamMagic)+saltlen+2*frame+100]
+	receiver := NewEncryptManager("helloworld").AcceptStream(bytes.NewBuffer(interrupted))
+	received, err := ioutil.ReadAll(receiver)
+	if err != ErrStreamTruncated {
+		t.Fatalf("ReadAll() err = %v, want %v", err, ErrStreamTruncated)
+	}
+	token, err := receiver.ResumptionToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var second bytes.Buffer
+	sender.Reconnect(&second)
+	if _, err := sender.Resume(append(append([]byte{}, token[:len(token)-1]...), token[len(token)-1]^1)); err == nil {
+		t.Fatal("expected error resuming with a modified token")
+	}
+	offset, err := sender.Resume(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != int64(len(received)) || offset != 2*streamChunkSize {
+		t.Fatalf("Resume() = %d, received %d bytes", offset, len(received))
+	}
+	if _, err := sender.Write(data[offset:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the resumed connection carries no handshake
+	if bytes.HasPrefix(second.Bytes(), streamMagic) {
+		t.Fatal("handshake sent when resuming")
+	}
+	resumed := second.Bytes()
+	receiver.Reconnect(bytes.NewBuffer(resumed))
+	rest, err := ioutil.ReadAll(receiver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(append(received, rest...), data) {
+		t.Fatal("received data does not match sent data")
+	}
+
+	// resuming requires the resume frame, which can't be replayed
+	tests := []struct {
+		name string
+		wire []byte
+	}{
+		{"data-frame", resumed[13+8+16:]},
+		{"replayed", resumed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := NewEncryptManager("helloworld").AcceptStream(bytes.NewBuffer(interrupted))
+			ioutil.ReadAll(receiver)
+			if tt.name == "replayed" {
+				// the resumed connection drops again after the next frame
+				receiver.Reconnect(bytes.NewBuffer(resumed[:13+8+16+frame]))
+				if _, err := ioutil.ReadAll(receiver); err != ErrStreamTruncated {
+					t.Fatalf("ReadAll() err = %v, want %v", err, ErrStreamTruncated)
+				}
+			}
+			receiver.Reconnect(bytes.NewBuffer(tt.wire))
+			if _, err := ioutil.ReadAll(receiver); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+	if _, err := NewEncryptManager("helloworld").AcceptStream(&first).ResumptionToken(); err == nil {
+		t.Fatal("expected error creating token before the handshake")
+	}
+}
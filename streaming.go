@@ -0,0 +1,551 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// segmentSize is the default amount of plaintext sealed in each segment
+	// of a chunked AEAD stream
+	segmentSize = 64 * 1024
+	// minSegmentSize, and maxSegmentSize bound segment sizes set using WithChunkSize
+	minSegmentSize = 1024
+	maxSegmentSize = 64 * 1024 * 1024
+	// segmentSizeFlag is set in the cipher identifier of streams using a
+	// segment size other than segmentSize, which is recorded in the 4 bytes
+	// following it
+	segmentSizeFlag byte = 0x80
+	// segmentKeysFlag is set in the cipher identifier of streams sealing
+	// every segment using its own key, see WithSegmentKeys
+	segmentKeysFlag byte = 0x40
+)
+
+// EncryptStream is used to encrypt src, writing the result to dst using a
+// constant amount of memory, regardless of the size of src.
+//
+// AES256-CFB, and GCM-STREAM produce the same output as Encrypt. AES256-GCM,
+// and the AEAD profile seal src in segments using the STREAM construction, as
+// GCM-STREAM does, where every segment nonce is derived from a random prefix,
+// the segment counter, and a final segment marker, so reordered, or truncated
+// segments are detected. For these protocols the output differs from that of
+// Encrypt, and must be decrypted using DecryptStream.
+// The decryption parameters are available once EncryptStream returns
+func (e *EncryptManager) EncryptStream(dst io.Writer, src io.Reader) error {
+	if dst == nil || src == nil {
+		return errors.New("invalid content provided")
+	}
+	if err := e.checkProtocol(e.getProtocol()); err != nil {
+		return err
+	}
+	counter := &countingReader{r: src}
+	// the notarizer, and hooks receive the digest of the complete output
+	var digest hash.Hash
+	output := &countingWriter{w: dst}
+	if e.notarizer != nil || len(e.encryptHooks) > 0 {
+		digest = sha256.New()
+		dst = io.MultiWriter(output, digest)
+	}
+	var params *GCMDecryptParams
+	switch protocol := e.getProtocol(); protocol {
+	case CFB:
+		if err := e.encryptCFBStream(dst, counter); err != nil {
+			return err
+		}
+	case GCM, GCMStream, AEAD, ChaCha20Poly1305, XChaCha20Poly1305, Archive:
+		id := aeadAES256GCM
+		switch protocol {
+		case AEAD:
+			id = preferredAEAD()
+		case ChaCha20Poly1305:
+			id = aeadChaCha20Poly1305
+		case XChaCha20Poly1305:
+			id = aeadXChaCha20Poly1305
+		case Archive:
+			id = aeadArchive
+		}
+		var err error
+		if params, err = e.encryptSegments(dst, counter, id); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("no protocol specified")
+	}
+	var receipt []byte
+	if e.notarizer != nil {
+		var err error
+		if receipt, err = e.notarizer.Notarize(digest.Sum(nil)); err != nil {
+			return err
+		}
+	}
+	if len(e.encryptHooks) > 0 {
+		if err := e.runEncryptHooks(EncryptionEvent{Digest: digest.Sum(nil), Size: output.n}); err != nil {
+			return err
+		}
+	}
+	e.mux.Lock()
+	if params != nil {
+		e.gcmDecryptParams = params
+	}
+	if receipt != nil {
+		e.notaryReceipt = receipt
+	}
+	e.mux.Unlock()
+	e.reportUsage(OperationEncrypt, counter.n)
+	return nil
+}
+
+// errStreamValidators is returned when decrypting a stream using content
+// validators requiring the complete plaintext
+var errStreamValidators = errors.New("content validators are not supported by stream decryption")
+
+// DecryptStream is used to decrypt src, produced by EncryptStream, writing
+// the result to dst using a constant amount of memory. As AES256-CFB stores
+// its salt after the encrypted data, src must be an io.ReadSeeker for it.
+//
+// Plaintext is written to dst as it is authenticated, so dst may have received
+// part of the data before an error, such as truncation, is detected. Only
+// content validators implementing StreamValidator, such as MaxContentSize, and
+// MagicBytes are supported, which check the plaintext before it is written
+func (e *EncryptManager) DecryptStream(dst io.Writer, src io.Reader) error {
+	return e.decryptError(e.decryptStream(dst, src))
+}
+
+// decryptStream implements DecryptStream, returning the cause of failures
+func (e *EncryptManager) decryptStream(dst io.Writer, src io.Reader) error {
+	if dst == nil || src == nil {
+		return errors.New("invalid content provided")
+	}
+	if err := e.checkApprovals(); err != nil {
+		return err
+	}
+	counter := &countingWriter{w: dst}
+	validated, err := e.streamValidators(counter)
+	if err != nil {
+		return err
+	}
+	switch e.getProtocol() {
+	case CFB:
+		seeker, ok := src.(io.ReadSeeker)
+		if !ok {
+			return errors.New("stream decryption of AES256-CFB requires a seekable source")
+		}
+		err = e.decryptCFBStream(validated, seeker)
+	case GCM, GCMStream, AEAD, ChaCha20Poly1305, XChaCha20Poly1305, Archive:
+		if e.getGCMDecryptParams() == nil {
+			return errors.New("no gcm decryption parameters given")
+		}
+		err = e.decryptSegments(validated, e.limitContent(src))
+	default:
+		return fmt.Errorf("no protocol specified")
+	}
+	if err != nil {
+		return err
+	}
+	if err := validated.Close(); err != nil {
+		return err
+	}
+	e.reportUsage(OperationDecrypt, counter.n)
+	return nil
+}
+
+// encryptCFBStream writes the authenticated AES256-CFB format
+// magic || [kdf header] || iv || ciphertext || salt || mac
+func (e *EncryptManager) encryptCFBStream(dst io.Writer, src io.Reader) error {
+	salt := make([]byte, e.kdf.saltLength())
+	if _, err := io.ReadFull(e.randomness(), salt); err != nil {
+		return err
+	}
+	key, err := e.cfbKey(e.kdf, salt)
+	if err != nil {
+		return err
+	}
+	encKey, macKey, err := cfbKeys(key)
+	if err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(e.randomness(), iv); err != nil {
+		return err
+	}
+	if err := e.recordNonce(encKey, iv); err != nil {
+		return err
+	}
+	// everything written before the mac is authenticated
+	mac := hmac.New(sha256.New, macKey)
+	out := io.MultiWriter(dst, mac)
+	prefix, err := cfbOutput(e.kdf, iv, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(append(cfbMACMagic, prefix...)); err != nil {
+		return err
+	}
+	if _, err := io.Copy(cipher.StreamWriter{S: cipher.NewCFBEncrypter(block, iv), W: out}, src); err != nil {
+		return err
+	}
+	if _, err := out.Write(salt); err != nil {
+		return err
+	}
+	_, err = dst.Write(mac.Sum(nil))
+	return err
+}
+
+// decryptCFBStream decrypts the AES256-CFB format, reading the salt from the
+// end of src. Authenticated content is verified in a first pass over src, so no
+// plaintext is written unless it is authentic
+func (e *EncryptManager) decryptCFBStream(dst io.Writer, src io.ReadSeeker) error {
+	size, err := src.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	// the magic is followed by the key derivation function header
+	head := make([]byte, len(cfbMACMagic)+maxKDFHeaderSize)
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	head = head[:n]
+	var start int64
+	authenticated := bytes.HasPrefix(head, cfbMACMagic)
+	switch {
+	case authenticated:
+		if size < int64(len(cfbMACMagic)+cfbMACSize) {
+			return errors.New("invalid content provided")
+		}
+		start, size = int64(len(cfbMACMagic)), size-int64(len(cfbMACMagic)+cfbMACSize)
+		head = head[len(cfbMACMagic):]
+	case !e.legacyCFB:
+		return ErrUnauthenticatedCFB
+	}
+	kdf, headerLen, err := parseKDFHeader(head)
+	if err != nil {
+		return err
+	}
+	start += int64(headerLen)
+	size -= int64(headerLen)
+	saltSize := int64(kdf.saltLength())
+	if size < aes.BlockSize+saltSize {
+		return errors.New("invalid content provided")
+	}
+	salt := make([]byte, saltSize)
+	if _, err := src.Seek(start+size-saltSize, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(src, salt); err != nil {
+		return err
+	}
+	key, err := e.cfbKey(kdf, salt)
+	if err != nil {
+		return err
+	}
+	if authenticated {
+		var macKey []byte
+		if key, macKey, err = cfbKeys(key); err != nil {
+			return err
+		}
+		want := make([]byte, cfbMACSize)
+		if _, err := io.ReadFull(src, want); err != nil {
+			return err
+		}
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		mac := hmac.New(sha256.New, macKey)
+		if _, err := io.Copy(mac, io.LimitReader(src, start+size)); err != nil {
+			return err
+		}
+		if !hmac.Equal(mac.Sum(nil), want) {
+			return ErrCFBAuthentication
+		}
+	}
+	if _, err := src.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(src, iv); err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, cipher.StreamReader{
+		S: cipher.NewCFBDecrypter(block, iv),
+		R: io.LimitReader(src, size-aes.BlockSize-saltSize),
+	})
+	return err
+}
+
+// encryptSegments writes the cipher id, and segment size followed by the
+// sealed segments of src, returning the decryption parameters
+func (e *EncryptManager) encryptSegments(dst io.Writer, src io.Reader, id byte) (*GCMDecryptParams, error) {
+	key := make([]byte, keylen)
+	if _, err := io.ReadFull(e.randomness(), key); err != nil {
+		return nil, err
+	}
+	aead, err := newSegmentAEAD(id, key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, aead.NonceSize()-5)
+	if _, err := io.ReadFull(e.randomness(), prefix); err != nil {
+		return nil, err
+	}
+	if err := e.recordNonce(key, prefix); err != nil {
+		return nil, err
+	}
+	aeads := sameSegmentAEAD(aead)
+	header := id
+	if e.segmentKeys {
+		if aeads, err = keyedSegmentAEADs(id, key, prefix); err != nil {
+			return nil, err
+		}
+		header |= segmentKeysFlag
+	}
+	size, aad := e.segmentLength(), e.additionalData()
+	if _, err := dst.Write(segmentHeader(header, size)); err != nil {
+		return nil, err
+	}
+	err = mapSegments(dst, bufio.NewReaderSize(src, size+1), size, e.parallelism, func(index uint32, segment []byte, last bool) ([]byte, error) {
+		aead, err := aeads(uint64(index))
+		if err != nil {
+			return nil, err
+		}
+		return aead.Seal(segment[:0], segmentNonce(prefix, index, last), segment, aad), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &GCMDecryptParams{
+		CipherKey: hex.EncodeToString(key),
+		Nonce:     hex.EncodeToString(prefix),
+	}, nil
+}
+
+// WithGCMStream is used to setup, and return EncryptManager for use with GCM-STREAM.
+// The params are expected to be unencrypted, and in hex encoded string format
+func (e *EncryptManager) WithGCMStream(params *GCMDecryptParams) *EncryptManager {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.protocol = GCMStream
+	e.gcmDecryptParams = params
+	return e
+}
+
+// decryptGCMStream decrypts the given io.Reader encrypted using GCM-STREAM
+func (e *EncryptManager) decryptGCMStream(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	if e.getGCMDecryptParams() == nil {
+		return nil, errors.New("no gcm decryption parameters given")
+	}
+	var buf bytes.Buffer
+	if err := e.decryptSegments(&buf, r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decryptSegments writes the opened segments of src to dst
+func (e *EncryptManager) decryptSegments(dst io.Writer, src io.Reader) error {
+	key, prefix, err := e.decryptionKey()
+	if err != nil {
+		return err
+	}
+	header, size, _, err := readSegmentHeader(src)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReaderSize(src, size+64)
+	id := header &^ segmentKeysFlag
+	if err := e.checkCipher(id); err != nil {
+		return err
+	}
+	aead, err := newSegmentAEAD(id, key)
+	if err != nil {
+		return err
+	}
+	if len(prefix) != aead.NonceSize()-5 {
+		return errors.New("invalid stream nonce prefix")
+	}
+	aeads := sameSegmentAEAD(aead)
+	if header&segmentKeysFlag != 0 {
+		if aeads, err = keyedSegmentAEADs(id, key, prefix); err != nil {
+			return err
+		}
+	}
+	aad := e.additionalData()
+	return mapSegments(dst, r, size+aead.Overhead(), e.parallelism, func(index uint32, segment []byte, last bool) ([]byte, error) {
+		aead, err := aeads(uint64(index))
+		if err != nil {
+			return nil, err
+		}
+		return openAEAD(aead, segment[:0], segmentNonce(prefix, index, last), segment, aad, e.objectBound)
+	})
+}
+
+// segmentLength returns the segment size in use
+func (e *EncryptManager) segmentLength() int {
+	if e.chunkSize == 0 {
+		return segmentSize
+	}
+	return e.chunkSize
+}
+
+// segmentHeader returns the cipher identifier of a segmented stream, followed
+// by the segment size when it is not the default
+func segmentHeader(id byte, size int) []byte {
+	if size == segmentSize {
+		return []byte{id}
+	}
+	header := []byte{id | segmentSizeFlag, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(header[1:], uint32(size))
+	return header
+}
+
+// readSegmentHeader reads the header written by segmentHeader from r,
+// returning the cipher identifier, including the segmentKeysFlag, segment
+// size, and length of the header
+func readSegmentHeader(r io.Reader) (byte, int, int, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header[:1]); err != nil {
+		return 0, 0, 0, errors.New("invalid content provided")
+	}
+	if header[0]&segmentSizeFlag == 0 {
+		return header[0], segmentSize, 1, nil
+	}
+	if _, err := io.ReadFull(r, header[1:]); err != nil {
+		return 0, 0, 0, errors.New("invalid content provided")
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size < minSegmentSize || size > maxSegmentSize {
+		return 0, 0, 0, errors.New("invalid stream segment size")
+	}
+	return header[0] &^ segmentSizeFlag, int(size), len(header), nil
+}
+
+// readSegments calls fn with every size byte segment of r, and whether it is
+// the final segment. The final segment may be shorter, or empty
+func readSegments(r *bufio.Reader, size int, fn func(index uint32, segment []byte, last bool) error) error {
+	buf := make([]byte, size)
+	for index := uint32(0); ; index++ {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last := err != nil
+		if !last {
+			// a full segment is final when nothing follows it
+			if _, err := r.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return err
+			}
+		}
+		if err := fn(index, buf[:n], last); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		if index == ^uint32(0) {
+			return errors.New("stream exceeds maximum number of segments")
+		}
+	}
+}
+
+// newSegmentAEAD returns the cipher identified by id using its standard nonce size
+func newSegmentAEAD(id byte, key []byte) (cipher.AEAD, error) {
+	switch id {
+	case aeadAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case aeadXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	case aeadChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+	case aeadArchive:
+		return newCascadeAEAD(key)
+	default:
+		return nil, fmt.Errorf("unsupported aead cipher %d", id)
+	}
+}
+
+// segmentAEADs returns the cipher sealing the segment at index
+type segmentAEADs func(index uint64) (cipher.AEAD, error)
+
+// sameSegmentAEAD seals every segment using aead
+func sameSegmentAEAD(aead cipher.AEAD) segmentAEADs {
+	return func(uint64) (cipher.AEAD, error) {
+		return aead, nil
+	}
+}
+
+// keyedSegmentAEADs seals every segment using the cipher identified by id,
+// and the key derived for it from the data key, and nonce prefix
+func keyedSegmentAEADs(id byte, key, prefix []byte) (segmentAEADs, error) {
+	keys, err := NewChunkKeys(key, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return rangeSegmentAEADs(id, keys.Key), nil
+}
+
+// rangeSegmentAEADs seals every segment using the cipher identified by id,
+// and the key returned for it by keyFn
+func rangeSegmentAEADs(id byte, keyFn func(index uint64) ([]byte, error)) segmentAEADs {
+	return func(index uint64) (cipher.AEAD, error) {
+		key, err := keyFn(index)
+		if err != nil {
+			return nil, err
+		}
+		return newSegmentAEAD(id, key)
+	}
+}
+
+// segmentNonce returns prefix || index || final segment marker
+func segmentNonce(prefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, len(prefix)+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[len(prefix):], index)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// countingWriter counts the bytes written to the underlying writer
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,163 @@
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+func Test_EncryptManager_Stream(t *testing.T) {
+	defer func(v bool) { hasAESHardware = v }(hasAESHardware)
+	readme, err := ioutil.ReadFile("README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes := []int{0, 100, segmentSize, 2*segmentSize + 7}
+	tests := []struct {
+		protocol    Protocol
+		hardwareAES bool
+	}{
+		{CFB, true},
+		{GCM, true},
+		{AEAD, true},
+		{AEAD, false},
+	}
+	for _, tt := range tests {
+		for _, size := range sizes {
+			t.Run(fmt.Sprintf("%s/%v/%d", tt.protocol, tt.hardwareAES, size), func(t *testing.T) {
+				hasAESHardware = tt.hardwareAES
+				data := bytes.Repeat(readme, size/len(readme)+1)[:size]
+				e := NewEncryptManager("helloworld")
+				switch tt.protocol {
+				case GCM:
+					e.WithGCM(nil)
+				case AEAD:
+					e.WithAEAD(nil)
+				}
+				var encrypted bytes.Buffer
+				if err := e.EncryptStream(&encrypted, bytes.NewReader(data)); err != nil {
+					t.Fatal(err)
+				}
+				var decrypted bytes.Buffer
+				if err := e.DecryptStream(&decrypted, bytes.NewReader(encrypted.Bytes())); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(decrypted.Bytes(), data) {
+					t.Fatal("decrypted data does not match original")
+				}
+				if tt.protocol != CFB {
+					return
+				}
+				// AES256-CFB streams are compatible with Encrypt and Decrypt
+				out, err := e.Decrypt(bytes.NewReader(encrypted.Bytes()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(out, data) {
+					t.Fatal("Decrypt output does not match original")
+				}
+				legacy, err := e.Encrypt(bytes.NewReader(data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				decrypted.Reset()
+				if err := e.DecryptStream(&decrypted, bytes.NewReader(legacy)); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(decrypted.Bytes(), data) {
+					t.Fatal("DecryptStream output does not match original")
+				}
+			})
+		}
+	}
+}
+
+func Test_EncryptManager_Stream_Tampering(t *testing.T) {
+	data := bytes.Repeat([]byte("a"), 2*segmentSize+7)
+	e := NewEncryptManager("helloworld").WithGCM(nil)
+	var buf bytes.Buffer
+	if err := e.EncryptStream(&buf, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	encrypted := buf.Bytes()
+	sealedSegment := segmentSize + 16
+	first, second := encrypted[1:1+sealedSegment], encrypted[1+sealedSegment:1+2*sealedSegment]
+	tests := []struct {
+		name      string
+		encrypted []byte
+	}{
+		{"truncated-segment", encrypted[:1+2*sealedSegment]},
+		{"truncated-bytes", encrypted[:len(encrypted)-1]},
+		{"reordered", append(append(append([]byte{encrypted[0]}, second...), first...), encrypted[1+2*sealedSegment:]...)},
+		{"modified", append(append([]byte{}, encrypted[:10]...), append([]byte{encrypted[10] ^ 1}, encrypted[11:]...)...)},
+		{"unknown-cipher", append([]byte{9}, encrypted[1:]...)},
+		{"empty", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := e.DecryptStream(ioutil.Discard, bytes.NewReader(tt.encrypted)); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+
+	cfb := NewEncryptManager("helloworld")
+	if err := cfb.DecryptStream(ioutil.Discard, bytes.NewBufferString("not seekable")); err == nil {
+		t.Fatal("expected error decrypting AES256-CFB from a non seekable source")
+	}
+	validated := NewEncryptManager("helloworld").WithContentValidators(MaxContentSize(1))
+	if err := validated.DecryptStream(ioutil.Discard, bytes.NewReader(encrypted)); err == nil {
+		t.Fatal("expected error decrypting stream with content validators")
+	}
+}
+
+func Test_EncryptManager_GCMStream(t *testing.T) {
+	data := bytes.Repeat([]byte("hello world"), segmentSize/5)
+	e := NewEncryptManager("helloworld").WithGCMStream(nil)
+	encrypted, err := e.Encrypt(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// buffered and streamed output are interchangeable
+	var decrypted bytes.Buffer
+	if err := e.DecryptStream(&decrypted, bytes.NewReader(encrypted)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted.Bytes(), data) {
+		t.Fatal("DecryptStream output does not match original")
+	}
+	var streamed bytes.Buffer
+	if err := e.EncryptStream(&streamed, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	state, err := e.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewEncryptManager("helloworld")
+	if err := restored.Restore(state); err != nil {
+		t.Fatal(err)
+	}
+	out, err := restored.Decrypt(bytes.NewReader(streamed.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatal("Decrypt output does not match original")
+	}
+
+	env, payload, err := e.EncryptSplit(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err = NewEncryptManager("helloworld").DecryptSplit(env, bytes.NewReader(payload)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatal("DecryptSplit output does not match original")
+	}
+	if _, err := NewEncryptManager("helloworld").WithGCMStream(nil).Decrypt(bytes.NewReader(encrypted)); err == nil {
+		t.Fatal("expected error decrypting without parameters")
+	}
+}
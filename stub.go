@@ -0,0 +1,410 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go/format"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// decryptStub describes the format of a single encrypted object, pinning the
+// parameters the generated program decrypts it with
+type decryptStub struct {
+	Protocol Protocol
+	Format   string
+	// Cipher is one of gcm, chacha20, xchacha20, archive, or cfb
+	Cipher string
+	// Offset is the length of the header preceding the body
+	Offset int
+	// Prefixed indicates the body begins with the identifier of the cipher
+	Prefixed bool
+	// Nonce is the hex encoded nonce recorded in the header, if any
+	Nonce     string
+	NonceSize int
+	// KDF is the key derivation function of AES256-CFB, nil for the legacy
+	// PBKDF2 parameters
+	KDF            *KDFConfig
+	KDFDescription string
+	Authenticated  bool
+	// Prefix, and Suffix are hex encoded, rebuilding the standalone
+	// AES256-CFB format from the body of a header
+	Prefix string
+	Suffix string
+	// Skip is the length of the mac magic, and kdf header of AES256-CFB
+	Skip int
+	// SaltSize is the length of the AES256-CFB salt
+	SaltSize int
+	Imports  []string
+}
+
+// WriteDecryptStub is used to write the source of a small, self-contained Go
+// program to w, which decrypts the object read from r using only the standard
+// library, and golang.org/x/crypto. The program is pinned to the format of
+// the object, such as its protocol, key derivation function, and header, so
+// archives can be stored alongside the code needed to decrypt them, and built
+// into a static binary using go build. The protocol is required for data
+// without a header, and is otherwise taken from the data. AES256-CFB, and
+// the authenticated ciphers other than segmented streams are supported
+func WriteDecryptStub(w io.Writer, r io.Reader, protocol Protocol) error {
+	if w == nil || r == nil {
+		return errors.New("invalid content provided")
+	}
+	peek, err := bufio.NewReaderSize(r, inspectPeek).Peek(inspectPeek)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return err
+	}
+	stub, err := newDecryptStub(peek, protocol)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := stubTemplate.Execute(&buf, stub); err != nil {
+		return err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// newDecryptStub describes the object beginning with peek
+func newDecryptStub(peek []byte, protocol Protocol) (*decryptStub, error) {
+	stub := &decryptStub{Protocol: protocol, Format: FormatUnknown}
+	body := peek
+	if h, ok := parseHeader(peek); ok {
+		if protocol != "" && protocol != h.protocol {
+			return nil, fmt.Errorf("header records protocol %s, not %s", h.protocol, protocol)
+		}
+		stub.Protocol, stub.Format, stub.KDF = h.protocol, FormatHeader, h.kdf
+		stub.Offset, body = len(peek)-len(h.body), h.body
+		if h.protocol == CFB {
+			if len(h.nonce) != aes.BlockSize || len(h.salt) != h.kdf.saltLength() {
+				return nil, errors.New("invalid header iv or salt")
+			}
+			var prefix []byte
+			if h.version >= 2 {
+				stub.Authenticated = true
+				prefix = append(prefix, cfbMACMagic...)
+			}
+			if h.kdf != nil {
+				kdf, err := h.kdf.header()
+				if err != nil {
+					return nil, err
+				}
+				prefix = append(prefix, kdf...)
+			}
+			stub.Skip = len(prefix)
+			stub.Prefix = hex.EncodeToString(append(prefix, h.nonce...))
+			stub.Suffix = hex.EncodeToString(h.salt)
+		} else {
+			stub.Nonce = hex.EncodeToString(h.nonce)
+		}
+	} else if bytes.HasPrefix(peek, cfbMACMagic) || hasKDFHeader(peek) {
+		if protocol != "" && protocol != CFB {
+			return nil, fmt.Errorf("data is in the %s format, not %s", CFB, protocol)
+		}
+		stub.Protocol, stub.Format = CFB, FormatCFB
+		if bytes.HasPrefix(peek, cfbMACMagic) {
+			stub.Authenticated, stub.Skip = true, len(cfbMACMagic)
+		}
+		kdf, n, err := parseKDFHeader(peek[stub.Skip:])
+		if err != nil {
+			return nil, err
+		}
+		stub.KDF, stub.Skip = kdf, stub.Skip+n
+	} else if protocol == "" {
+		return nil, errors.New("the protocol of data without a header must be given")
+	}
+	switch stub.Protocol {
+	case CFB:
+		stub.Cipher, stub.SaltSize = "cfb", stub.KDF.saltLength()
+	case GCM:
+		stub.Cipher, stub.NonceSize = "gcm", nonceSize
+		if stub.Nonce != "" {
+			// the nonce size may be set using WithNonceSize
+			stub.NonceSize = len(stub.Nonce) / 2
+		}
+	case ChaCha20Poly1305:
+		stub.Cipher, stub.NonceSize = "chacha20", 12
+	case XChaCha20Poly1305:
+		stub.Cipher, stub.NonceSize = "xchacha20", nonceSize
+	case AEAD, Archive:
+		if len(body) == 0 {
+			return nil, errors.New("invalid content provided")
+		}
+		stub.Prefixed, stub.NonceSize = true, nonceSize
+		switch body[0] {
+		case aeadAES256GCM:
+			stub.Cipher = "gcm"
+		case aeadXChaCha20Poly1305:
+			stub.Cipher = "xchacha20"
+		case aeadArchive:
+			stub.Cipher = "archive"
+		default:
+			return nil, fmt.Errorf("unsupported aead cipher %d", body[0])
+		}
+		if stub.Protocol == Archive && stub.Cipher != "archive" {
+			return nil, ErrDowngrade
+		}
+	default:
+		return nil, fmt.Errorf("decryption stubs are not supported by protocol %s", stub.Protocol)
+	}
+	stub.KDFDescription = describeKDF(&KDFConfig{KDF: PBKDF2, Iterations: 4096})
+	if stub.KDF != nil {
+		stub.KDFDescription = describeKDF(stub.KDF)
+	}
+	stub.Imports = stub.imports()
+	return stub, nil
+}
+
+// imports returns the packages used by the generated program
+func (s *decryptStub) imports() []string {
+	imports := []string{"flag", "io/ioutil", "log"}
+	switch s.Cipher {
+	case "cfb":
+		imports = append(imports, "crypto/aes", "crypto/cipher", "os")
+		if s.Prefix != "" {
+			imports = append(imports, "encoding/hex")
+		}
+		if s.Authenticated {
+			imports = append(imports, "crypto/hmac", "crypto/sha256", "io", "golang.org/x/crypto/hkdf")
+		}
+		switch {
+		case s.KDF == nil || s.KDF.KDF == PBKDF2:
+			imports = append(imports, "crypto/sha512", "golang.org/x/crypto/pbkdf2")
+		case s.KDF.KDF == Argon2id:
+			imports = append(imports, "golang.org/x/crypto/argon2")
+		case s.KDF.KDF == Scrypt:
+			imports = append(imports, "golang.org/x/crypto/scrypt")
+		}
+	case "gcm":
+		imports = append(imports, "crypto/aes", "crypto/cipher", "encoding/hex")
+	case "chacha20", "xchacha20":
+		imports = append(imports, "encoding/hex", "golang.org/x/crypto/chacha20poly1305")
+	case "archive":
+		imports = append(imports, "crypto/aes", "crypto/cipher", "crypto/sha256", "encoding/hex", "io",
+			"golang.org/x/crypto/chacha20poly1305", "golang.org/x/crypto/hkdf")
+	}
+	// the standard library is grouped before golang.org/x/crypto
+	var std, x []string
+	for _, path := range imports {
+		if strings.Contains(path, ".") {
+			x = append(x, path)
+		} else {
+			std = append(std, path)
+		}
+	}
+	sort.Strings(std)
+	sort.Strings(x)
+	if len(x) == 0 {
+		return std
+	}
+	return append(append(std, ""), x...)
+}
+
+var stubTemplate = template.Must(template.New("stub").Parse(`// Code generated by github.com/RTradeLtd/crypto. DO NOT EDIT.
+
+// Command decrypt decrypts a single object encrypted using
+// github.com/RTradeLtd/crypto, pinned to the format of that object:
+//
+//	Format:   {{.Format}}
+//	Protocol: {{.Protocol}}
+//	Cipher:   {{.Cipher}}
+{{- if eq .Cipher "cfb"}}
+//	KDF:      {{.KDFDescription}}
+//	MAC:      {{if .Authenticated}}hmac-sha256{{else}}none{{end}}
+//
+// Usage:
+//
+//	TEMPORAL_PASSPHRASE=<passphrase> go run decrypt.go -in <file> -out <file>
+{{- else}}
+//	Nonce:    {{if .Nonce}}{{.Nonce}}{{else}}{{.NonceSize}} bytes, given using -nonce{{end}}
+//
+// Usage:
+//
+//	go run decrypt.go -in <file> -out <file> -key <hex cipher key>{{if not .Nonce}} -nonce <hex nonce>{{end}}
+{{- end}}
+package main
+
+import (
+{{- range .Imports}}
+{{if .}}{{printf "%q" .}}{{end}}
+{{- end}}
+)
+
+func main() {
+	in := flag.String("in", "", "the encrypted file")
+	out := flag.String("out", "", "the file to write the decrypted data to")
+{{- if ne .Cipher "cfb"}}
+	keyHex := flag.String("key", "", "the hex encoded cipher key")
+{{- if not .Nonce}}
+	nonceHex := flag.String("nonce", "", "the hex encoded nonce")
+{{- end}}
+{{- end}}
+	flag.Parse()
+	data, err := ioutil.ReadFile(*in)
+	if err != nil {
+		log.Fatal(err)
+	}
+{{- if .Offset}}
+	data = data[{{.Offset}}:]
+{{- end}}
+{{- if eq .Cipher "cfb"}}
+	passphrase := os.Getenv("TEMPORAL_PASSPHRASE")
+	if passphrase == "" {
+		log.Fatal("no passphrase provided in TEMPORAL_PASSPHRASE")
+	}
+{{- if .Prefix}}
+	// rebuild the standalone format from the parameters recorded in the header
+	prefix, _ := hex.DecodeString("{{.Prefix}}")
+	suffix, _ := hex.DecodeString("{{.Suffix}}")
+{{- if .Authenticated}}
+	if len(data) < sha256.Size {
+		log.Fatal("invalid content provided")
+	}
+	mac := data[len(data)-sha256.Size:]
+	data = append(append(append(prefix, data[:len(data)-sha256.Size]...), suffix...), mac...)
+{{- else}}
+	data = append(append(prefix, data...), suffix...)
+{{- end}}
+{{- end}}
+{{- if .Authenticated}}
+	// the format is magic || kdf header || iv || ciphertext || salt || mac
+	if len(data) < {{.Skip}}+aes.BlockSize+{{.SaltSize}}+sha256.Size {
+		log.Fatal("invalid content provided")
+	}
+	signed, mac := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	raw := signed[{{.Skip}}:]
+{{- else}}
+	// the format is {{if .Skip}}kdf header || {{end}}iv || ciphertext || salt
+	if len(data) < {{.Skip}}+aes.BlockSize+{{.SaltSize}} {
+		log.Fatal("invalid content provided")
+	}
+	raw := data[{{.Skip}}:]
+{{- end}}
+	salt := raw[len(raw)-{{.SaltSize}}:]
+	raw = raw[:len(raw)-{{.SaltSize}}]
+{{- with .KDF}}
+{{- if eq .KDF "argon2id"}}
+	key := argon2.IDKey([]byte(passphrase), salt, {{.Iterations}}, {{.Memory}}, {{.Threads}}, 32)
+{{- else if eq .KDF "scrypt"}}
+	key, err := scrypt.Key([]byte(passphrase), salt, {{.N}}, {{.R}}, {{.P}}, 32)
+	if err != nil {
+		log.Fatal(err)
+	}
+{{- else}}
+	key := pbkdf2.Key([]byte(passphrase), salt, {{.Iterations}}, 32, sha512.New)
+{{- end}}
+{{- else}}
+	key := pbkdf2.Key([]byte(passphrase), salt, 4096, 32, sha512.New)
+{{- end}}
+{{- if .Authenticated}}
+	// separate encryption, and mac keys are derived from the key
+	encKey, macKey := make([]byte, 32), make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte("temporal-cfb-encrypt")), encKey); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte("temporal-cfb-mac")), macKey); err != nil {
+		log.Fatal(err)
+	}
+	h := hmac.New(sha256.New, macKey)
+	h.Write(signed)
+	if !hmac.Equal(h.Sum(nil), mac) {
+		log.Fatal("content failed authentication, the passphrase is wrong, or the data was modified")
+	}
+	key = encKey
+{{- end}}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	plaintext := make([]byte, len(raw)-aes.BlockSize)
+	cipher.NewCFBDecrypter(block, raw[:aes.BlockSize]).XORKeyStream(plaintext, raw[aes.BlockSize:])
+{{- else}}
+	key, err := hex.DecodeString(*keyHex)
+	if err != nil || len(key) != 32 {
+		log.Fatal("the cipher key must be 32 hex encoded bytes")
+	}
+{{- if .Nonce}}
+	nonce, _ := hex.DecodeString("{{.Nonce}}")
+{{- else}}
+	nonce, err := hex.DecodeString(*nonceHex)
+	if err != nil || len(nonce) != {{.NonceSize}} {
+		log.Fatal("the nonce must be {{.NonceSize}} hex encoded bytes")
+	}
+{{- end}}
+{{- if .Prefixed}}
+	// skip the identifier of the cipher
+	if len(data) == 0 {
+		log.Fatal("invalid content provided")
+	}
+	data = data[1:]
+{{- end}}
+{{- if eq .Cipher "gcm"}}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	aead, err := cipher.NewGCMWithNonceSize(block, {{.NonceSize}})
+	if err != nil {
+		log.Fatal(err)
+	}
+	plaintext, err := aead.Open(nil, nonce, data, nil)
+{{- else if eq .Cipher "chacha20"}}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	plaintext, err := aead.Open(nil, nonce, data, nil)
+{{- else if eq .Cipher "xchacha20"}}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	plaintext, err := aead.Open(nil, nonce, data, nil)
+{{- else if eq .Cipher "archive"}}
+	// AES256-GCM, and XChaCha20-Poly1305 keys are derived from the cipher key,
+	// and the data was sealed using AES256-GCM, and then XChaCha20-Poly1305
+	gcmKey, chachaKey := make([]byte, 32), make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte("temporal-archive:aes256-gcm")), gcmKey); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte("temporal-archive:xchacha20-poly1305")), chachaKey); err != nil {
+		log.Fatal(err)
+	}
+	outer, err := chacha20poly1305.NewX(chachaKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sealed, err := outer.Open(nil, nonce, data, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	block, err := aes.NewCipher(gcmKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	inner, err := cipher.NewGCMWithNonceSize(block, {{.NonceSize}})
+	if err != nil {
+		log.Fatal(err)
+	}
+	plaintext, err := inner.Open(nil, nonce, sealed, nil)
+{{- end}}
+	if err != nil {
+		log.Fatal(err)
+	}
+{{- end}}
+	if err := ioutil.WriteFile(*out, plaintext, 0600); err != nil {
+		log.Fatal(err)
+	}
+}
+`))
@@ -0,0 +1,125 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func Test_WriteDecryptStub(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds generated programs")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+	// stubs are built within the module to resolve golang.org/x/crypto,
+	// the leading underscore excludes the directory from ./...
+	dir, err := ioutil.TempDir(".", "_stub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	original := []byte("hello world")
+	tests := []struct {
+		name     string
+		with     func(e *EncryptManager) *EncryptManager
+		protocol Protocol
+	}{
+		{"cfb", func(e *EncryptManager) *EncryptManager { return e }, ""},
+		{"cfb-legacy", nil, CFB},
+		{"cfb-scrypt", func(e *EncryptManager) *EncryptManager {
+			return e.WithKDF(KDFConfig{KDF: Scrypt, N: 1024, R: 8, P: 1})
+		}, ""},
+		{"cfb-header", func(e *EncryptManager) *EncryptManager {
+			return e.WithKDF(KDFConfig{KDF: Argon2id, Iterations: 1, Memory: 1024, Threads: 1}).WithHeader()
+		}, ""},
+		{"cfb-salt-header", func(e *EncryptManager) *EncryptManager {
+			e, _ = e.WithPBKDF2Iterations(1000).WithSaltLength(16)
+			return e.WithHeader()
+		}, ""},
+		{"gcm", func(e *EncryptManager) *EncryptManager { return e.WithGCM(nil) }, GCM},
+		{"gcm-header", func(e *EncryptManager) *EncryptManager { return e.WithGCM(nil).WithHeader() }, ""},
+		{"aead", func(e *EncryptManager) *EncryptManager { return e.WithAEAD(nil) }, AEAD},
+		{"chacha20", func(e *EncryptManager) *EncryptManager { return e.WithChaCha20Poly1305(nil) }, ChaCha20Poly1305},
+		{"xchacha20-header", func(e *EncryptManager) *EncryptManager {
+			return e.WithXChaCha20Poly1305(nil).WithHeader()
+		}, ""},
+		{"archive", func(e *EncryptManager) *EncryptManager { return e.WithArchive(nil).WithHeader() }, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEncryptManager("helloworld")
+			var encrypted []byte
+			if tt.with == nil {
+				// the unauthenticated format used by Temporal
+				encrypted = legacyCFB(t, e, original)
+			} else if encrypted, err = tt.with(e).Encrypt(bytes.NewReader(original)); err != nil {
+				t.Fatal(err)
+			}
+			var src bytes.Buffer
+			if err := WriteDecryptStub(&src, bytes.NewReader(encrypted), tt.protocol); err != nil {
+				t.Fatal(err)
+			}
+			stubDir := filepath.Join(dir, tt.name)
+			if err := os.Mkdir(stubDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			in, out := filepath.Join(stubDir, "in"), filepath.Join(stubDir, "out")
+			if err := ioutil.WriteFile(filepath.Join(stubDir, "decrypt.go"), src.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(in, encrypted, 0644); err != nil {
+				t.Fatal(err)
+			}
+			args := []string{"run", filepath.Join(stubDir, "decrypt.go"), "-in", in, "-out", out}
+			if params := e.getGCMDecryptParams(); params != nil {
+				args = append(args, "-key", params.CipherKey)
+				if bytes.Contains(src.Bytes(), []byte("nonceHex")) {
+					args = append(args, "-nonce", params.Nonce)
+				}
+			}
+			cmd := exec.Command(goTool, args...)
+			cmd.Env = append(os.Environ(), "TEMPORAL_PASSPHRASE=helloworld")
+			if output, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("%v: %s\n%s", err, output, src.String())
+			}
+			decrypted, err := ioutil.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, original) {
+				t.Fatalf("decrypted = %s, want %s", hex.EncodeToString(decrypted), hex.EncodeToString(original))
+			}
+		})
+	}
+}
+
+func Test_WriteDecryptStub_Errors(t *testing.T) {
+	e := NewEncryptManager("helloworld").WithGCM(nil)
+	encrypted, err := e.Encrypt(bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		data     []byte
+		protocol Protocol
+	}{
+		{"headerless-without-protocol", encrypted, ""},
+		{"unsupported-protocol", encrypted, GCMStream},
+		{"downgraded-archive", append([]byte{aeadAES256GCM}, encrypted...), Archive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := WriteDecryptStub(ioutil.Discard, bytes.NewReader(tt.data), tt.protocol); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
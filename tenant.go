@@ -0,0 +1,72 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// KeyProvider is used to retrieve key material by identifier, such as keys
+// stored in a KMS, or derived from a master key
+type KeyProvider interface {
+	Key(id string) ([]byte, error)
+}
+
+// DerivedKeyProvider is a KeyProvider deriving an isolated key for every
+// identifier from a single master key
+type DerivedKeyProvider struct {
+	master []byte
+}
+
+// NewDerivedKeyProvider is used to derive keys from the given master key,
+// which must be at least 32 bytes
+func NewDerivedKeyProvider(master []byte) (*DerivedKeyProvider, error) {
+	if len(master) < keylen {
+		return nil, fmt.Errorf("master key must be at least %d bytes", keylen)
+	}
+	return &DerivedKeyProvider{master: master}, nil
+}
+
+// Key returns the key derived for id
+func (d *DerivedKeyProvider) Key(id string) ([]byte, error) {
+	key := make([]byte, keylen)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, d.master, nil, []byte("temporal-key:"+id)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// TenantKeyManager is used to hand out EncryptManagers keyed per tenant,
+// ensuring data encrypted for one tenant can't be decrypted using the
+// credentials of another tenant
+type TenantKeyManager struct {
+	provider KeyProvider
+}
+
+// NewTenantKeyManager is used to instantiate a TenantKeyManager
+// retrieving tenant keys from the given KeyProvider
+func NewTenantKeyManager(provider KeyProvider) *TenantKeyManager {
+	return &TenantKeyManager{provider: provider}
+}
+
+// Manager returns an EncryptManager using the key of tenant. Usage of the
+// returned manager is tagged with the tenant when a UsageReporter is configured
+func (t *TenantKeyManager) Manager(tenant string) (*EncryptManager, error) {
+	if tenant == "" {
+		return nil, errors.New("no tenant provided")
+	}
+	key, err := t.provider.Key("tenant/" + tenant)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) < keylen {
+		return nil, fmt.Errorf("key for tenant %s is too short", tenant)
+	}
+	e := NewEncryptManager(hex.EncodeToString(key))
+	e.tenant = tenant
+	return e, nil
+}
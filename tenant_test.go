@@ -0,0 +1,72 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+)
+
+func Test_TenantKeyManager(t *testing.T) {
+	master := make([]byte, 32)
+	if _, err := rand.Read(master); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDerivedKeyProvider(master[:8]); err == nil {
+		t.Fatal("expected error using short master key")
+	}
+	provider, err := NewDerivedKeyProvider(master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm := NewTenantKeyManager(provider)
+	if _, err := tm.Manager(""); err == nil {
+		t.Fatal("expected error using empty tenant")
+	}
+	alice, err := tm.Manager("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := alice.WithGCM(nil).Encrypt(bytes.NewReader([]byte("alice's data")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, err := alice.RetrieveGCMDecryptionParameters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		tenant  string
+		wantErr bool
+	}{
+		{"alice", false},
+		{"bob", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tenant, func(t *testing.T) {
+			e, err := tm.Manager(tt.tenant)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// decryption parameters are only recoverable by the same tenant
+			state, err := json.Marshal(&ManagerState{Protocol: GCM, Params: params})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = e.Restore(state)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Restore err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			decrypted, err := e.Decrypt(bytes.NewReader(encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(decrypted) != "alice's data" {
+				t.Fatalf("Decrypt = %s", decrypted)
+			}
+		})
+	}
+}
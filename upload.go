@@ -0,0 +1,141 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"time"
+)
+
+// Uploader is used to encrypt data, and push the result to an HTTP endpoint
+// as a multipart form upload, such as Temporal's file upload API
+type Uploader struct {
+	// Endpoint is the URL the encrypted data is uploaded to
+	Endpoint string
+	// Header holds additional headers, such as Authorization, sent with every upload
+	Header http.Header
+	// FieldName is the multipart form field holding the file, defaults to "file"
+	FieldName string
+	// Fields are additional multipart form fields sent with the file
+	Fields map[string]string
+	// Retries is the number of times a failed upload is retried
+	Retries int
+	// RetryDelay is the delay between retries, doubling after every attempt
+	RetryDelay time.Duration
+	// Client is used to make requests, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// Upload is used to encrypt r using EncryptStream with the given
+// EncryptManager, streaming the encrypted data into the upload as filename,
+// so neither the plaintext, nor the ciphertext is buffered. The body of the
+// final response is returned. Requests are retried on network errors, and
+// server side (5xx) failures, which requires reading r again, so uploads are
+// only retried when r is an io.Seeker, such as an *os.File
+func (u *Uploader) Upload(e *EncryptManager, filename string, r io.Reader) ([]byte, error) {
+	if u.Endpoint == "" {
+		return nil, errors.New("no upload endpoint provided")
+	}
+	if e == nil || r == nil {
+		return nil, errors.New("invalid content provided")
+	}
+	seeker, seekable := r.(io.Seeker)
+	var start int64
+	if seekable {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+	delay := u.RetryDelay
+	for attempt := 0; ; attempt++ {
+		body, retry, err := u.upload(e, filename, r)
+		if err == nil || !retry || !seekable || attempt >= u.Retries {
+			return body, err
+		}
+		time.Sleep(delay)
+		delay *= 2
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// upload performs a single upload attempt, indicating whether failures can be retried
+func (u *Uploader) upload(e *EncryptManager, filename string, r io.Reader) ([]byte, bool, error) {
+	fieldName := u.FieldName
+	if fieldName == "" {
+		fieldName = "file"
+	}
+	// stream the multipart form into the request, rather than buffering it
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	encrypted := make(chan error, 1)
+	go func() {
+		err := u.writeForm(form, fieldName, filename, e, r)
+		pw.CloseWithError(err)
+		encrypted <- err
+	}()
+	req, err := http.NewRequest(http.MethodPost, u.Endpoint, pr)
+	if err != nil {
+		pr.Close()
+		<-encrypted
+		return nil, false, err
+	}
+	for name, values := range u.Header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	client := u.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		pr.Close()
+		// failures to encrypt are not retried
+		if encErr := <-encrypted; encErr != nil && encErr != io.ErrClosedPipe {
+			return nil, false, encErr
+		}
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	// the server may respond without reading the whole form, or accept, or
+	// reject the form truncated by a failure to encrypt, so the form is
+	// always stopped, and failures to encrypt take precedence
+	pr.Close()
+	if encErr := <-encrypted; encErr != nil && encErr != io.ErrClosedPipe {
+		return nil, false, encErr
+	}
+	if err != nil {
+		return nil, true, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return body, resp.StatusCode >= 500, fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, body)
+	}
+	return body, false, nil
+}
+
+// writeForm writes the fields of the upload, followed by the file holding r
+// encrypted using e
+func (u *Uploader) writeForm(form *multipart.Writer, fieldName, filename string, e *EncryptManager, r io.Reader) error {
+	for name, value := range u.Fields {
+		if err := form.WriteField(name, value); err != nil {
+			return err
+		}
+	}
+	part, err := form.CreateFormFile(fieldName, filename)
+	if err != nil {
+		return err
+	}
+	if err := e.EncryptStream(part, r); err != nil {
+		return err
+	}
+	return form.Close()
+}
@@ -0,0 +1,165 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_Uploader(t *testing.T) {
+	var (
+		attempts int
+		received []byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		// fail the first attempt to exercise retries
+		if attempts == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if r.FormValue("hold_time") != "1" {
+			http.Error(w, "missing hold_time", http.StatusBadRequest)
+			return
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		if header.Filename != "README.md" {
+			http.Error(w, "unexpected filename", http.StatusBadRequest)
+			return
+		}
+		received, _ = ioutil.ReadAll(file)
+		w.Write([]byte(`{"response":"QmHash"}`))
+	}))
+	defer srv.Close()
+
+	u := &Uploader{
+		Endpoint: srv.URL,
+		Header:   http.Header{"Authorization": {"Bearer token"}},
+		Fields:   map[string]string{"hold_time": "1"},
+		Retries:  2,
+	}
+	e := NewEncryptManager("helloworld")
+	resp, err := u.Upload(e, "README.md", bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != `{"response":"QmHash"}` || attempts != 2 {
+		t.Fatalf("Upload = %s after %d attempts", resp, attempts)
+	}
+	decrypted, err := e.Decrypt(bytes.NewReader(received))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != "hello world" {
+		t.Fatalf("uploaded data decrypted to %s", decrypted)
+	}
+
+	// readers which can't be read again are not retried
+	attempts = 0
+	if _, err := u.Upload(e, "README.md", struct{ io.Reader }{bytes.NewReader([]byte("hello world"))}); err == nil {
+		t.Fatal("expected error from failed upload")
+	}
+	if attempts != 1 {
+		t.Fatalf("unseekable upload attempted %d times, want 1", attempts)
+	}
+
+	// segmented protocols are streamed in the format of EncryptStream
+	attempts = 1
+	data := bytes.Repeat([]byte("hello world"), segmentSize/5)
+	stream := NewEncryptManager("helloworld").WithGCM(nil)
+	if _, err := u.Upload(stream, "README.md", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := stream.DecryptStream(&out, bytes.NewReader(received)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("uploaded data does not match original")
+	}
+
+	// client errors are not retried
+	attempts = 0
+	u.Header = nil
+	if _, err := u.Upload(e, "README.md", bytes.NewReader([]byte("hello world"))); err == nil {
+		t.Fatal("expected error uploading without authorization")
+	}
+	if attempts != 1 {
+		t.Fatalf("unauthorized upload attempted %d times, want 1", attempts)
+	}
+	// failures to encrypt are reported, rather than retried
+	u.Header = http.Header{"Authorization": {"Bearer token"}}
+	if _, err := u.Upload(NewEncryptManager("helloworld").WithSecretBox(nil), "README.md", bytes.NewReader(data)); err == nil || strings.Contains(err.Error(), "status") {
+		t.Fatalf("Upload err = %v, want encryption failure", err)
+	}
+}
+
+// roundTripFunc allows using an ordinary function as an http.RoundTripper
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// failingReader returns data, followed by err
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func Test_Uploader_Responses(t *testing.T) {
+	errRead := errors.New("read failed")
+	respond := func(status int, body io.Reader) roundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			// the form is read up to the failure to encrypt, as by a server
+			// accepting truncated uploads
+			io.Copy(ioutil.Discard, req.Body)
+			return &http.Response{StatusCode: status, Body: ioutil.NopCloser(body), Request: req}, nil
+		}
+	}
+	tests := []struct {
+		name      string
+		transport roundTripFunc
+		r         io.Reader
+		wantErr   error
+	}{
+		{"accepted", respond(http.StatusOK, strings.NewReader("ok")), strings.NewReader("hello world"), nil},
+		{"truncated-accepted", respond(http.StatusOK, strings.NewReader("ok")), &failingReader{[]byte("hello"), errRead}, errRead},
+		{"truncated-rejected", respond(http.StatusBadRequest, strings.NewReader("bad")), &failingReader{[]byte("hello"), errRead}, errRead},
+		{"unread-body", func(req *http.Request) (*http.Response, error) {
+			// respond without reading the form, with a body failing to be read
+			return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(&failingReader{err: errRead}), Request: req}, nil
+		}, strings.NewReader("hello world"), errRead},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &Uploader{Endpoint: "http://localhost/upload", Client: &http.Client{Transport: tt.transport}}
+			if _, err := u.Upload(NewEncryptManager("helloworld"), "README.md", tt.r); err != tt.wantErr {
+				t.Fatalf("Upload err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
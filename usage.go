@@ -0,0 +1,111 @@
+package crypto
+
+import (
+	"io"
+	"sync"
+)
+
+const (
+	// OperationEncrypt is reported for encryption of objects
+	OperationEncrypt = "encrypt"
+	// OperationDecrypt is reported for decryption of objects
+	OperationDecrypt = "decrypt"
+)
+
+// UsageEvent describes a single successful encryption or decryption operation
+type UsageEvent struct {
+	// Tenant is the caller supplied tenant, or context identifier
+	Tenant    string
+	Operation string
+	Protocol  Protocol
+	// Bytes is the number of plaintext bytes processed
+	Bytes int64
+}
+
+// UsageReporter is used to receive usage events, allowing multi-tenant
+// services to bill and quota their crypto usage
+type UsageReporter interface {
+	ReportUsage(event UsageEvent)
+}
+
+// UsageReporterFunc allows using an ordinary function as a UsageReporter
+type UsageReporterFunc func(event UsageEvent)
+
+// ReportUsage calls f(event)
+func (f UsageReporterFunc) ReportUsage(event UsageEvent) {
+	f(event)
+}
+
+// WithUsageReporter is used to report all operations to the given reporter, tagged with tenant
+func (e *EncryptManager) WithUsageReporter(tenant string, r UsageReporter) *EncryptManager {
+	e.tenant = tenant
+	e.usageReporter = r
+	return e
+}
+
+// reportUsage sends a usage event to the configured reporter
+func (e *EncryptManager) reportUsage(operation string, bytes int64) {
+	if e.usageReporter == nil {
+		return
+	}
+	e.usageReporter.ReportUsage(UsageEvent{
+		Tenant:    e.tenant,
+		Operation: operation,
+		Protocol:  e.getProtocol(),
+		Bytes:     bytes,
+	})
+}
+
+// TenantUsage is the accumulated usage of a single tenant
+type TenantUsage struct {
+	EncryptOperations int64
+	EncryptedBytes    int64
+	DecryptOperations int64
+	DecryptedBytes    int64
+}
+
+// UsageCounter is a UsageReporter which accumulates usage per tenant in memory
+type UsageCounter struct {
+	mux   sync.Mutex
+	usage map[string]TenantUsage
+}
+
+// NewUsageCounter is used to instantiate an empty UsageCounter
+func NewUsageCounter() *UsageCounter {
+	return &UsageCounter{usage: make(map[string]TenantUsage)}
+}
+
+// ReportUsage records the given event
+func (u *UsageCounter) ReportUsage(event UsageEvent) {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	usage := u.usage[event.Tenant]
+	switch event.Operation {
+	case OperationEncrypt:
+		usage.EncryptOperations++
+		usage.EncryptedBytes += event.Bytes
+	case OperationDecrypt:
+		usage.DecryptOperations++
+		usage.DecryptedBytes += event.Bytes
+	}
+	u.usage[event.Tenant] = usage
+}
+
+// Usage returns the accumulated usage of tenant
+func (u *UsageCounter) Usage(tenant string) TenantUsage {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	return u.usage[tenant]
+}
+
+// countingReader counts the bytes read from the underlying reader
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
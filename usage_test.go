@@ -0,0 +1,54 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_EncryptManager_UsageReporter(t *testing.T) {
+	counter := NewUsageCounter()
+	var events []UsageEvent
+	reporter := UsageReporterFunc(func(event UsageEvent) {
+		events = append(events, event)
+		counter.ReportUsage(event)
+	})
+	tenants := []string{"tenant-a", "tenant-b"}
+	for i, tenant := range tenants {
+		data := bytes.Repeat([]byte("a"), 100*(i+1))
+		e := NewEncryptManager("helloworld").WithGCM(nil).WithUsageReporter(tenant, reporter)
+		for j := 0; j <= i; j++ {
+			encrypted, err := e.Encrypt(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := e.Decrypt(bytes.NewReader(encrypted)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// failed operations are not reported
+		if _, err := e.Encrypt(nil); err == nil {
+			t.Fatal("expected error encrypting nil reader")
+		}
+	}
+	if len(events) != 6 {
+		t.Fatalf("got %d events, want 6", len(events))
+	}
+	if events[0].Protocol != GCM || events[0].Operation != OperationEncrypt {
+		t.Fatalf("unexpected event %+v", events[0])
+	}
+	tests := []struct {
+		tenant string
+		want   TenantUsage
+	}{
+		{"tenant-a", TenantUsage{1, 100, 1, 100}},
+		{"tenant-b", TenantUsage{2, 400, 2, 400}},
+		{"tenant-c", TenantUsage{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tenant, func(t *testing.T) {
+			if got := counter.Usage(tt.tenant); got != tt.want {
+				t.Fatalf("Usage = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
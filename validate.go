@@ -0,0 +1,286 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// contentOverhead bounds the size of encrypted data beyond its content, such
+// as headers, signatures, the keys of recipients, and authentication tags
+const contentOverhead = 1 << 20
+
+var (
+	// ErrContentTooLarge is returned when decrypted data exceeds the size allowed by MaxContentSize
+	ErrContentTooLarge = errors.New("decrypted content exceeds maximum size")
+	// ErrUnexpectedContentType is returned when decrypted data does not match any of the
+	// signatures allowed by MagicBytes
+	ErrUnexpectedContentType = errors.New("decrypted content is not of an allowed type")
+)
+
+// ContentValidator is used to inspect decrypted data before it is returned to
+// the caller, ie to check file signatures, or submit it to a virus scanner
+type ContentValidator interface {
+	ValidateContent(plaintext []byte) error
+}
+
+// ContentValidatorFunc allows using an ordinary function as a ContentValidator
+type ContentValidatorFunc func(plaintext []byte) error
+
+// ValidateContent calls f(plaintext)
+func (f ContentValidatorFunc) ValidateContent(plaintext []byte) error {
+	return f(plaintext)
+}
+
+// StreamValidator is a ContentValidator able to inspect plaintext as it is
+// decrypted, allowing it to be used with DecryptStream
+type StreamValidator interface {
+	ContentValidator
+	// NewStreamCheck returns a check of a single stream of plaintext
+	NewStreamCheck() StreamCheck
+}
+
+// StreamCheck inspects a single stream of plaintext as it is decrypted
+type StreamCheck interface {
+	// Write is called with each part of the plaintext in order, before it is
+	// written to the destination, returning an error to reject the stream
+	Write(p []byte) error
+	// Pending indicates that the check has not yet accepted the plaintext
+	// written so far, which is held back from the destination until it has
+	Pending() bool
+	// Close is called once the stream is complete, returning an error to reject it
+	Close() error
+}
+
+// WithContentValidators is used to run the given validators, in order, against
+// all decrypted data. Decryption fails with the error of the first validator
+// rejecting it. DecryptStream supports validators implementing StreamValidator,
+// such as MaxContentSize, and MagicBytes
+func (e *EncryptManager) WithContentValidators(validators ...ContentValidator) *EncryptManager {
+	e.validators = validators
+	return e
+}
+
+// validateContent runs all configured validators against plaintext
+func (e *EncryptManager) validateContent(plaintext []byte) error {
+	for _, v := range e.validators {
+		if err := v.ValidateContent(plaintext); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maxContentSize is the validator returned by MaxContentSize
+type maxContentSize int64
+
+// ValidateContent rejects plaintext larger than the maximum size
+func (max maxContentSize) ValidateContent(plaintext []byte) error {
+	if int64(len(plaintext)) > int64(max) {
+		return ErrContentTooLarge
+	}
+	return nil
+}
+
+// NewStreamCheck returns a check rejecting streams once they exceed the maximum size
+func (max maxContentSize) NewStreamCheck() StreamCheck {
+	return &sizeCheck{max: int64(max)}
+}
+
+// sizeCheck counts the plaintext of a stream
+type sizeCheck struct {
+	max  int64
+	size int64
+}
+
+func (c *sizeCheck) Write(p []byte) error {
+	c.size += int64(len(p))
+	if c.size > c.max {
+		return ErrContentTooLarge
+	}
+	return nil
+}
+
+func (c *sizeCheck) Pending() bool { return false }
+
+func (c *sizeCheck) Close() error { return nil }
+
+// MaxContentSize returns a validator rejecting decrypted data larger than max
+// bytes. Encrypted data too large to hold max bytes of content is refused
+// while it is read, and compressed payloads are not decompressed beyond max bytes
+func MaxContentSize(max int64) ContentValidator {
+	return maxContentSize(max)
+}
+
+// maxContentSize returns the smallest size allowed by the configured
+// MaxContentSize validators
+func (e *EncryptManager) maxContentSize() (int64, bool) {
+	var (
+		size  int64
+		found bool
+	)
+	for _, v := range e.validators {
+		if max, ok := v.(maxContentSize); ok && (!found || int64(max) < size) {
+			size, found = int64(max), true
+		}
+	}
+	return size, found
+}
+
+// readContent reads the encrypted data from r, refusing data too large to
+// hold the content allowed by MaxContentSize before it is buffered
+func (e *EncryptManager) readContent(r io.Reader) ([]byte, error) {
+	return ioutil.ReadAll(e.limitContent(r))
+}
+
+// limitContent limits r to the encrypted size of the content allowed by
+// MaxContentSize, allowing for base64 encoded output
+func (e *EncryptManager) limitContent(r io.Reader) io.Reader {
+	max, ok := e.maxContentSize()
+	if !ok {
+		return r
+	}
+	return &contentLimitReader{r: r, max: max + max/2 + contentOverhead}
+}
+
+// contentLimitReader fails with ErrContentTooLarge once more than max bytes are read
+type contentLimitReader struct {
+	r    io.Reader
+	max  int64
+	read int64
+}
+
+func (l *contentLimitReader) Read(p []byte) (int, error) {
+	// never read more than a single byte beyond the limit
+	if remaining := l.max - l.read + 1; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.max {
+		return 0, ErrContentTooLarge
+	}
+	return n, err
+}
+
+// streamValidators returns a writer running the stream checks of all
+// configured validators against the plaintext written to dst, or
+// errStreamValidators if a validator does not support streams
+func (e *EncryptManager) streamValidators(dst io.Writer) (*validatingWriter, error) {
+	w := &validatingWriter{w: dst}
+	for _, v := range e.validators {
+		sv, ok := v.(StreamValidator)
+		if !ok {
+			return nil, errStreamValidators
+		}
+		w.checks = append(w.checks, sv.NewStreamCheck())
+	}
+	return w, nil
+}
+
+// validatingWriter passes plaintext to its checks before writing it to w,
+// holding it back while any check is pending
+type validatingWriter struct {
+	w       io.Writer
+	checks  []StreamCheck
+	pending []byte
+}
+
+func (v *validatingWriter) Write(p []byte) (int, error) {
+	pending := false
+	for _, c := range v.checks {
+		if err := c.Write(p); err != nil {
+			return 0, err
+		}
+		pending = pending || c.Pending()
+	}
+	if pending {
+		v.pending = append(v.pending, p...)
+		return len(p), nil
+	}
+	if err := v.flush(); err != nil {
+		return 0, err
+	}
+	return v.w.Write(p)
+}
+
+// Close completes the checks, writing any plaintext held back once accepted
+func (v *validatingWriter) Close() error {
+	for _, c := range v.checks {
+		if err := c.Close(); err != nil {
+			return err
+		}
+	}
+	return v.flush()
+}
+
+func (v *validatingWriter) flush() error {
+	if len(v.pending) == 0 {
+		return nil
+	}
+	_, err := v.w.Write(v.pending)
+	v.pending = nil
+	return err
+}
+
+// magicBytes is the validator returned by MagicBytes
+type magicBytes [][]byte
+
+// ValidateContent rejects plaintext not beginning with one of the signatures
+func (m magicBytes) ValidateContent(plaintext []byte) error {
+	for _, signature := range m {
+		if bytes.HasPrefix(plaintext, signature) {
+			return nil
+		}
+	}
+	return ErrUnexpectedContentType
+}
+
+// NewStreamCheck returns a check of the signature at the start of a stream
+func (m magicBytes) NewStreamCheck() StreamCheck {
+	return &magicCheck{signatures: m}
+}
+
+// magicCheck holds the start of a stream until it matches, or can no
+// longer match a signature
+type magicCheck struct {
+	signatures [][]byte
+	prefix     []byte
+	done       bool
+}
+
+func (c *magicCheck) Write(p []byte) error {
+	if c.done {
+		return nil
+	}
+	c.prefix = append(c.prefix, p...)
+	possible := false
+	for _, signature := range c.signatures {
+		if bytes.HasPrefix(c.prefix, signature) {
+			c.done, c.prefix = true, nil
+			return nil
+		}
+		possible = possible || bytes.HasPrefix(signature, c.prefix)
+	}
+	if !possible {
+		return ErrUnexpectedContentType
+	}
+	return nil
+}
+
+func (c *magicCheck) Pending() bool { return !c.done }
+
+func (c *magicCheck) Close() error {
+	if c.done {
+		return nil
+	}
+	// streams shorter than the signatures are checked as a whole
+	return magicBytes(c.signatures).ValidateContent(c.prefix)
+}
+
+// MagicBytes returns a validator rejecting decrypted data which does not begin with
+// one of the given file signatures, ie []byte("%PDF-") or []byte{0x89, 'P', 'N', 'G'}
+func MagicBytes(signatures ...[]byte) ContentValidator {
+	return magicBytes(signatures)
+}
@@ -0,0 +1,133 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func Test_EncryptManager_ContentValidators(t *testing.T) {
+	pdf := []byte("%PDF-1.4 document")
+	encrypted, err := NewEncryptManager("helloworld").Encrypt(bytes.NewReader(pdf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errInfected := errors.New("EICAR test signature found")
+	scanner := ContentValidatorFunc(func(plaintext []byte) error {
+		if bytes.Contains(plaintext, []byte("document")) {
+			return errInfected
+		}
+		return nil
+	})
+	tests := []struct {
+		name       string
+		validators []ContentValidator
+		wantErr    error
+	}{
+		{"no validators", nil, nil},
+		{"within size", []ContentValidator{MaxContentSize(1024)}, nil},
+		{"too large", []ContentValidator{MaxContentSize(4)}, ErrContentTooLarge},
+		{"allowed type", []ContentValidator{MagicBytes([]byte{0x89, 'P', 'N', 'G'}, []byte("%PDF-"))}, nil},
+		{"disallowed type", []ContentValidator{MagicBytes([]byte{0x89, 'P', 'N', 'G'})}, ErrUnexpectedContentType},
+		{"scanner", []ContentValidator{MaxContentSize(1024), scanner}, errInfected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decrypted, err := NewEncryptManager("helloworld").
+				WithContentValidators(tt.validators...).
+				Decrypt(bytes.NewReader(encrypted))
+			if err != tt.wantErr {
+				t.Fatalf("Decrypt err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if decrypted != nil {
+					t.Fatal("rejected content was returned")
+				}
+				return
+			}
+			if !bytes.Equal(decrypted, pdf) {
+				t.Fatal("decrypted data does not match original")
+			}
+		})
+	}
+}
+
+func Test_EncryptManager_ContentValidators_Limit(t *testing.T) {
+	// oversized data is refused while it is read, before it is buffered
+	for _, protocol := range []Protocol{CFB, GCMStream} {
+		t.Run(string(protocol), func(t *testing.T) {
+			e := NewEncryptManager("helloworld").WithContentValidators(MaxContentSize(16))
+			e.protocol = protocol
+			e.gcmDecryptParams = &GCMDecryptParams{CipherKey: hex.EncodeToString(make([]byte, keylen)), Nonce: hex.EncodeToString(make([]byte, 7))}
+			src := &countingReader{r: io.LimitReader(zeroReader{}, 64<<20)}
+			if _, err := e.Decrypt(src); err != ErrContentTooLarge {
+				t.Fatalf("Decrypt err = %v, want %v", err, ErrContentTooLarge)
+			}
+			if src.n > 2*contentOverhead {
+				t.Fatalf("read %d bytes before refusing oversized data", src.n)
+			}
+		})
+	}
+	// including streams whose first segment is larger than allowed
+	e, err := New(WithPassphrase("helloworld"), WithProtocol(GCMStream), WithChunkSize(8<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var encrypted bytes.Buffer
+	if err := e.EncryptStream(&encrypted, bytes.NewReader(make([]byte, 8<<20))); err != nil {
+		t.Fatal(err)
+	}
+	src := &countingReader{r: bytes.NewReader(encrypted.Bytes())}
+	if err := e.Clone().WithContentValidators(MaxContentSize(16)).DecryptStream(ioutil.Discard, src); err != ErrContentTooLarge {
+		t.Fatalf("DecryptStream err = %v, want %v", err, ErrContentTooLarge)
+	}
+	if src.n > 2*contentOverhead {
+		t.Fatalf("read %d bytes before refusing oversized stream", src.n)
+	}
+}
+
+func Test_EncryptManager_StreamValidators(t *testing.T) {
+	pdf := bytes.Repeat([]byte("%PDF-1.4 document"), segmentSize/8)
+	scanner := ContentValidatorFunc(func(plaintext []byte) error { return nil })
+	tests := []struct {
+		name       string
+		data       []byte
+		validators []ContentValidator
+		wantErr    error
+	}{
+		{"within size", pdf, []ContentValidator{MaxContentSize(int64(len(pdf)))}, nil},
+		{"too large", pdf, []ContentValidator{MaxContentSize(int64(len(pdf)) - 1)}, ErrContentTooLarge},
+		{"allowed type", pdf, []ContentValidator{MagicBytes([]byte{0x89, 'P', 'N', 'G'}, []byte("%PDF-"))}, nil},
+		{"disallowed type", pdf, []ContentValidator{MagicBytes([]byte{0x89, 'P', 'N', 'G'})}, ErrUnexpectedContentType},
+		{"shorter than signature", []byte("%P"), []ContentValidator{MagicBytes([]byte("%PDF-"))}, ErrUnexpectedContentType},
+		{"empty signature", nil, []ContentValidator{MagicBytes([]byte{})}, nil},
+		{"unsupported", pdf, []ContentValidator{MaxContentSize(int64(len(pdf))), scanner}, errStreamValidators},
+	}
+	for _, protocol := range []Protocol{CFB, GCMStream, AEAD} {
+		for _, tt := range tests {
+			t.Run(string(protocol)+"/"+tt.name, func(t *testing.T) {
+				e := NewEncryptManager("helloworld")
+				e.protocol = protocol
+				var encrypted bytes.Buffer
+				if err := e.EncryptStream(&encrypted, bytes.NewReader(tt.data)); err != nil {
+					t.Fatal(err)
+				}
+				var decrypted bytes.Buffer
+				err := e.Clone().WithContentValidators(tt.validators...).DecryptStream(&decrypted, bytes.NewReader(encrypted.Bytes()))
+				if err != tt.wantErr {
+					t.Fatalf("DecryptStream err = %v, want %v", err, tt.wantErr)
+				}
+				// content of the wrong type is never written
+				if tt.wantErr == ErrUnexpectedContentType && decrypted.Len() > 0 {
+					t.Fatal("rejected content was written")
+				}
+				if err == nil && !bytes.Equal(decrypted.Bytes(), tt.data) {
+					t.Fatal("decrypted data does not match original")
+				}
+			})
+		}
+	}
+}
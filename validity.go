@@ -0,0 +1,130 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	// ErrNotYetValid is returned when decrypting an envelope before its NotBefore time
+	ErrNotYetValid = errors.New("envelope is not yet valid")
+	// ErrExpired is returned when decrypting an envelope after its NotAfter time
+	ErrExpired = errors.New("envelope has expired")
+)
+
+// Clock is used to obtain the current time, allowing expiry to be tested deterministically
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc allows using an ordinary function as a Clock
+type ClockFunc func() time.Time
+
+// Now calls f()
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// Validity restricts the period during which an envelope may be decrypted. The
+// window is authenticated using a key derived from the passphrase, and bound
+// to the payload, so it cannot be modified, or moved to another envelope
+type Validity struct {
+	// NotBefore is the time from which decryption is allowed, ignored if zero
+	NotBefore time.Time `json:"not_before,omitempty"`
+	// NotAfter is the time after which decryption is refused, ignored if zero
+	NotAfter time.Time `json:"not_after,omitempty"`
+	// KDF is the key derivation function used to derive the key of the mac,
+	// if configured using WithKDF
+	KDF  *KDFConfig `json:"kdf,omitempty"`
+	Salt []byte     `json:"salt"`
+	MAC  []byte     `json:"mac"`
+}
+
+// WithValidity is used to restrict decryption of envelopes produced by
+// EncryptSplit to the given window. Zero times leave that side of the window open
+func (e *EncryptManager) WithValidity(notBefore, notAfter time.Time) *EncryptManager {
+	e.notBefore = notBefore
+	e.notAfter = notAfter
+	return e
+}
+
+// WithClock is used to override the clock used to check envelope validity,
+// defaulting to the system clock
+func (e *EncryptManager) WithClock(c Clock) *EncryptManager {
+	e.clock = c
+	return e
+}
+
+// now returns the current time according to the configured clock
+func (e *EncryptManager) now() time.Time {
+	if e.clock == nil {
+		return time.Now()
+	}
+	return e.clock.Now()
+}
+
+// validity returns the authenticated validity window for payload, if configured
+func (e *EncryptManager) validity(payload []byte) (*Validity, error) {
+	if e.notBefore.IsZero() && e.notAfter.IsZero() {
+		return nil, nil
+	}
+	v := &Validity{NotBefore: e.notBefore, NotAfter: e.notAfter, KDF: e.kdf, Salt: make([]byte, e.kdf.saltLength())}
+	if _, err := io.ReadFull(e.randomness(), v.Salt); err != nil {
+		return nil, err
+	}
+	var err error
+	if v.MAC, err = e.validityMAC(v, payload); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// checkValidity verifies v was produced using the passphrase, and the current
+// time is within it. The recorded KDF is subject to the limits, and format
+// policy of the manager
+func (e *EncryptManager) checkValidity(v *Validity, payload []byte) error {
+	if len(v.Salt) != v.KDF.saltLength() {
+		return errors.New("invalid envelope validity")
+	}
+	mac, err := e.validityMAC(v, payload)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(v.MAC, mac) {
+		return errors.New("invalid envelope validity")
+	}
+	now := e.now()
+	if !v.NotBefore.IsZero() && now.Before(v.NotBefore) {
+		return ErrNotYetValid
+	}
+	if !v.NotAfter.IsZero() && now.After(v.NotAfter) {
+		return ErrExpired
+	}
+	return nil
+}
+
+func (e *EncryptManager) validityMAC(v *Validity, payload []byte) ([]byte, error) {
+	key, err := e.cfbKey(v.KDF, v.Salt)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("temporal-validity"))
+	binary.Write(mac, binary.BigEndian, validityTime(v.NotBefore))
+	binary.Write(mac, binary.BigEndian, validityTime(v.NotAfter))
+	digest := sha256.Sum256(payload)
+	mac.Write(digest[:])
+	return mac.Sum(nil), nil
+}
+
+// validityTime encodes t for authentication, with zero times encoded as zero
+func validityTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
@@ -0,0 +1,107 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func Test_EncryptManager_Validity(t *testing.T) {
+	start := time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	clock := ClockFunc(func() time.Time { return now })
+
+	tests := []struct {
+		name      string
+		notBefore time.Time
+		notAfter  time.Time
+		now       time.Time
+		tamper    func(env *Envelope)
+		wantErr   error
+	}{
+		{"within", start, start.Add(time.Hour), start.Add(time.Minute), nil, nil},
+		{"expired", start, start.Add(time.Hour), start.Add(2 * time.Hour), nil, ErrExpired},
+		{"not-yet-valid", start, start.Add(time.Hour), start.Add(-time.Minute), nil, ErrNotYetValid},
+		{"open-start", time.Time{}, start.Add(time.Hour), start.Add(-24 * time.Hour), nil, nil},
+		{"open-end", start, time.Time{}, start.Add(24 * time.Hour), nil, nil},
+		{"extended", start, start.Add(time.Hour), start.Add(2 * time.Hour), func(env *Envelope) {
+			env.Validity.NotAfter = env.Validity.NotAfter.Add(24 * time.Hour)
+		}, nil},
+		{"removed-bound", start, start.Add(time.Hour), start.Add(2 * time.Hour), func(env *Envelope) {
+			env.Validity.NotAfter = time.Time{}
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEncryptManager("helloworld").
+				WithGCM(nil).
+				WithValidity(tt.notBefore, tt.notAfter).
+				WithClock(clock)
+			env, payload, err := e.EncryptSplit(bytes.NewReader([]byte("hello world")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if env.Validity == nil {
+				t.Fatal("envelope has no validity")
+			}
+			now = tt.now
+			if tt.tamper != nil {
+				tt.tamper(env)
+			}
+			decrypted, err := e.DecryptSplit(env, bytes.NewReader(payload))
+			switch {
+			case tt.tamper != nil:
+				if err == nil {
+					t.Fatal("expected error decrypting tampered envelope")
+				}
+			case err != tt.wantErr:
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			case err == nil && string(decrypted) != "hello world":
+				t.Fatalf("DecryptSplit = %s", decrypted)
+			}
+		})
+	}
+
+	// without a validity window envelopes carry none
+	env, _, err := NewEncryptManager("helloworld").EncryptSplit(bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Validity != nil {
+		t.Fatal("unexpected validity in envelope")
+	}
+}
+
+func Test_EncryptManager_Validity_KDF(t *testing.T) {
+	start := time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)
+	clock := ClockFunc(func() time.Time { return start })
+	e := NewEncryptManager("helloworld").WithGCM(nil).WithPBKDF2Iterations(1000).
+		WithValidity(start, start.Add(time.Hour)).WithClock(clock)
+	env, payload, err := e.EncryptSplit(bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Validity.KDF == nil || env.Validity.KDF.Iterations != 1000 {
+		t.Fatalf("validity kdf = %+v", env.Validity.KDF)
+	}
+	if decrypted, err := NewEncryptManager("helloworld").WithClock(clock).DecryptSplit(env, bytes.NewReader(payload)); err != nil || string(decrypted) != "hello world" {
+		t.Fatalf("DecryptSplit = %s, %v", decrypted, err)
+	}
+	// the recorded kdf is subject to the format policy, and limits of the manager
+	policy, err := NewEncryptManager("helloworld").WithClock(clock).WithFormatPolicy(FormatPolicy{KDF: &KDFPolicy{MinPBKDF2Iterations: 10000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := policy.DecryptSplit(env, bytes.NewReader(payload)); err != ErrDowngrade {
+		t.Fatalf("DecryptSplit() err = %v, want %v", err, ErrDowngrade)
+	}
+	limited := NewEncryptManager("helloworld").WithClock(clock).WithKDFLimits(KDFLimits{MaxPBKDF2Iterations: 100})
+	if _, err := limited.DecryptSplit(env, bytes.NewReader(payload)); err == nil {
+		t.Fatal("expected error decrypting envelope exceeding kdf limits")
+	}
+	// the kdf can not be replaced
+	env.Validity.KDF = nil
+	if _, err := NewEncryptManager("helloworld").WithClock(clock).DecryptSplit(env, bytes.NewReader(payload)); err == nil {
+		t.Fatal("expected error decrypting envelope with modified validity kdf")
+	}
+}